@@ -2,10 +2,10 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bls"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bootloader"
@@ -14,7 +14,6 @@ import (
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/discovery"
-	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/snapshotfs"
@@ -55,21 +54,23 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	espPath, err := discovery.ResolveESP(discovery.ESPOptions{
+	espPath, releaseESP, err := discovery.ResolveESP(discovery.ESPOptions{
 		UUID:       cfg.ESP.UUID,
 		AutoDetect: cfg.ESP.AutoDetect.IsTrue(),
 		MountPoint: cfg.ESP.MountPoint,
+		ReadOnly:   cfg.DryRun.IsTrue(),
 	})
 	if err != nil {
 		return fmt.Errorf("resolve ESP: %w", err)
 	}
+	defer releaseESP()
 
 	bootSets, _ := discovery.DetectBootSets(
 		discovery.ESPOptions{UUID: cfg.ESP.UUID, AutoDetect: cfg.ESP.AutoDetect.IsTrue(), MountPoint: cfg.ESP.MountPoint},
 		patternsFromConfig(cfg.Kernel.BootImagePatterns),
 	)
 
-	btrfsMgr := btrfs.NewManager(cfg.Snapshot.SearchDirectories, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue())
+	btrfsMgr := cliconfig.NewBtrfsManager(cfg, nil)
 	rootFS, err := btrfsMgr.GetRootFilesystem()
 	if err != nil {
 		return fmt.Errorf("locate root btrfs filesystem: %w", err)
@@ -92,8 +93,11 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 
 	staleAction := kernel.ParseStaleAction(cfg.Kernel.StaleSnapshotAction)
 	checker := kernel.NewChecker(staleAction)
-	planner := kernel.NewPlanner(fstab.NewManager(), checker, bootSets, rootFS)
+	checker.SetUsePackageDB(cfg.Kernel.UsePackageDB.IsTrue())
+	fstabMgr := cliconfig.NewFstabManager(cfg)
+	planner := kernel.NewPlanner(fstabMgr, checker, bootSets, rootFS)
 	plans := planner.Plan(snapshots)
+	kernel.LogStaleness(plans)
 
 	entriesDir := filepath.Join(espPath, strings.TrimPrefix(cfg.BLS.EntriesDir, "/"))
 	sourceEntries := extractSourceEntries(entriesDir, cfg.BLS.EntryPrefix, bootSets)
@@ -116,8 +120,17 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 	}
 
 	patch := diff.NewPatchDiff()
-	for _, u := range snapshotfs.UpdateFstabs(snapshots, rootFS, fstab.NewManager()) {
-		patch.AddFile(u.Diff)
+	// Read-only boots leave snapshots untouched, fstab included, so only
+	// flag fstabs that would mount the wrong subvolume.
+	if cfg.Behavior.BootReadOnly.IsTrue() {
+		snapshotfs.CheckSubvolids(snapshots, rootFS, fstabMgr)
+	} else {
+		for _, u := range snapshotfs.UpdateFstabs(snapshots, rootFS, fstabMgr) {
+			patch.AddFile(u.Diff)
+		}
+		for _, u := range snapshotfs.UpdateCrypttabs(snapshots, rootFS, fstabMgr) {
+			patch.AddFile(u.Diff)
+		}
 	}
 	for _, d := range out.Diffs {
 		patch.AddFile(d)
@@ -143,8 +156,15 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		diff.ShowPatchWithPager(patch, false)
 	}
 
-	if err := diff.Apply(patch, r); err != nil {
-		return fmt.Errorf("apply BLS entries: %w", err)
+	now := time.Now()
+	applyErr := diff.Apply(patch, r)
+	if cfg.Behavior.AuditLog != "" {
+		if err := diff.AppendAuditLog(cfg.Behavior.AuditLog, diff.NewAuditRecord(patch, now, applyErr)); err != nil {
+			return fmt.Errorf("record changes in audit log %s: %w", cfg.Behavior.AuditLog, err)
+		}
+	}
+	if applyErr != nil {
+		return fmt.Errorf("apply BLS entries: %w", applyErr)
 	}
 	log.Info().Int("entries", len(out.Diffs)).Msg("BLS entries written")
 	return nil
@@ -227,7 +247,7 @@ func extractSourceEntries(entriesDir, managedPrefix string, bootSets []*kernel.B
 		return sources
 	}
 
-	cmdline, src, err := readFallbackCmdline()
+	cmdline, src, err := bootloader.ReadKernelCmdline()
 	if err != nil {
 		log.Warn().Err(err).Msg("No existing BLS entries and no cmdline fallback available")
 		return nil
@@ -253,29 +273,3 @@ func extractSourceEntries(entriesDir, managedPrefix string, bootSets []*kernel.B
 	}
 	return sources
 }
-
-// readFallbackCmdline returns the kernel command line from the first
-// readable source. /etc/kernel/cmdline is the kernel-install / mkinitcpio
-// canonical location; /proc/cmdline is the running kernel as a last resort,
-// stripped of bootloader-injected initrd= and BOOT_IMAGE= tokens.
-func readFallbackCmdline() (string, string, error) {
-	if b, err := os.ReadFile("/etc/kernel/cmdline"); err == nil {
-		return strings.TrimSpace(string(b)), "/etc/kernel/cmdline", nil
-	}
-	if b, err := os.ReadFile("/proc/cmdline"); err == nil {
-		return stripProcCmdline(strings.TrimSpace(string(b))), "/proc/cmdline", nil
-	}
-	return "", "", fmt.Errorf("no readable cmdline at /etc/kernel/cmdline or /proc/cmdline")
-}
-
-func stripProcCmdline(s string) string {
-	keep := make([]string, 0, len(strings.Fields(s)))
-	for _, tok := range strings.Fields(s) {
-		lower := strings.ToLower(tok)
-		if strings.HasPrefix(lower, "initrd=") || strings.HasPrefix(lower, "boot_image=") {
-			continue
-		}
-		keep = append(keep, tok)
-	}
-	return strings.Join(keep, " ")
-}
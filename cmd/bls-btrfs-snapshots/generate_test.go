@@ -43,7 +43,7 @@ efi      /EFI/Microsoft/Boot/bootmgfw.efi
 func TestExtractSourceEntries_FallbackFromBootSets(t *testing.T) {
 	dir := t.TempDir() // empty, forces fallback
 
-	t.Setenv("HOME", t.TempDir()) // belt-and-braces; readFallbackCmdline uses absolute paths
+	t.Setenv("HOME", t.TempDir()) // belt-and-braces; bootloader.ReadKernelCmdline uses absolute paths
 	bootSets := []*kernel.BootSet{
 		{
 			KernelName: "linux",
@@ -80,38 +80,3 @@ func TestExtractSourceEntries_NoEntriesNoBootSets(t *testing.T) {
 	srcs := extractSourceEntries(dir, "bls-btrfs-snapshots-", nil)
 	assert.Empty(t, srcs, "no BLS entries and no BootSets → no sources")
 }
-
-func TestStripProcCmdline(t *testing.T) {
-	tests := []struct {
-		name string
-		in   string
-		want string
-	}{
-		{
-			name: "strips_initrd_and_boot_image",
-			in:   "BOOT_IMAGE=/vmlinuz-linux root=UUID=abc rw initrd=/initramfs-linux.img quiet",
-			want: "root=UUID=abc rw quiet",
-		},
-		{
-			name: "case_insensitive_keys",
-			in:   "BOOT_IMAGE=/x initrd=/y Initrd=/z BOOT_image=/w root=UUID=abc",
-			want: "root=UUID=abc",
-		},
-		{
-			name: "preserves_other_tokens",
-			in:   "root=UUID=abc rw quiet splash",
-			want: "root=UUID=abc rw quiet splash",
-		},
-		{
-			name: "empty_input",
-			in:   "",
-			want: "",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.want, stripProcCmdline(tt.in))
-		})
-	}
-}
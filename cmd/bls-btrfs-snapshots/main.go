@@ -11,9 +11,11 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var rootCmd = &cobra.Command{
@@ -31,6 +33,7 @@ for btrfs-mode coverage via rEFInd's btrfs driver.`,
 func init() {
 	rootCmd.PersistentFlags().StringP("config", "c", "", "Path to config file (default: /etc/bls-btrfs-snapshots.yaml)")
 	rootCmd.PersistentFlags().String("log-level", "", "Log verbosity: trace, debug, info, warn, error")
+	rootCmd.PersistentFlags().Bool("no-color", false, "Disable colors (also set by the NO_COLOR environment variable)")
 }
 
 func setupLogging(cmd *cobra.Command, args []string) error {
@@ -43,7 +46,14 @@ func setupLogging(cmd *cobra.Command, args []string) error {
 		}
 		zerolog.SetGlobalLevel(parsed)
 	}
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"})
+	noColor, _ := cmd.Flags().GetBool("no-color")
+	noColor = noColor || os.Getenv("NO_COLOR") != ""
+	diff.SetColor(!noColor)
+	log.Logger = log.Output(zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: "15:04:05",
+		NoColor:    noColor || !term.IsTerminal(int(os.Stderr.Fd())),
+	})
 	return nil
 }
 
@@ -42,14 +42,17 @@ func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"})
 
 	resolvedESP := *espPath
+	releaseESP := func() {}
 	if resolvedESP == "" {
-		mp, err := discovery.ResolveESP(discovery.ESPOptions{AutoDetect: true})
+		mp, release, err := discovery.ResolveESP(discovery.ESPOptions{AutoDetect: true, ReadOnly: true})
+		releaseESP = release
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "warning: could not autodetect ESP: %v\n", err)
 		} else {
 			resolvedESP = mp
 		}
 	}
+	defer releaseESP()
 
 	scanDirs := flag.Args()
 	if len(scanDirs) == 0 {
@@ -63,6 +66,7 @@ func main() {
 	images, err := scanner.ScanDir(scanDirs...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: scan failed: %v\n", err)
+		releaseESP()
 		os.Exit(1)
 	}
 	scanner.InspectAll(images)
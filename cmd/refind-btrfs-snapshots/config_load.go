@@ -20,6 +20,12 @@ var flagToKey = map[string]string{
 	"force":            "force",
 	"generate-include": "generate_include",
 	"yes":              "yes",
+	"verify-hashes":    "kernel.verify_hashes",
+	"backup-configs":   "behavior.backup_configs",
+
+	"timeout-per-snapshot": "behavior.timeout_per_snapshot",
+	"entries-per-snapshot": "kernel.btrfs_entries_per_snapshot",
+	"kernel-filter":        "kernel.filter",
 }
 
 func loadConfig(cmd *cobra.Command) (*config.Config, error) {
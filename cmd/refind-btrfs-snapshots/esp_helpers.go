@@ -19,6 +19,7 @@ import (
 	"slices"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/discovery"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
@@ -36,8 +37,12 @@ func espOptionsFromConfig(cfg *config.Config) discovery.ESPOptions {
 }
 
 // detectESPPath resolves the ESP mount point from config (uuid > auto_detect > mount_point).
-func detectESPPath(cfg *config.Config) (string, error) {
-	return discovery.ResolveESP(espOptionsFromConfig(cfg))
+// An ESP it has to mount is mounted read-only when readOnly, and unmounted
+// by the returned release function, which the caller defers.
+func detectESPPath(cfg *config.Config, readOnly bool) (string, func(), error) {
+	opts := espOptionsFromConfig(cfg)
+	opts.ReadOnly = readOnly
+	return discovery.ResolveESP(opts)
 }
 
 // buildKernelScanner creates a kernel.Scanner from config, using custom patterns
@@ -88,7 +93,7 @@ func discoverSnapshots(cfg *config.Config, searchDirOverrides []string) ([]*btrf
 		searchDirs = searchDirOverrides
 		log.Debug().Strs("search_dirs", searchDirs).Msg("Using overridden search directories")
 	}
-	btrfsManager := btrfs.NewManager(searchDirs, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue())
+	btrfsManager := cliconfig.NewBtrfsManager(cfg, searchDirs)
 
 	filesystems, err := btrfsManager.DetectBtrfsFilesystems()
 	if err != nil {
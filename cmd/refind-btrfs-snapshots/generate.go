@@ -18,15 +18,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os/user"
+	"path/filepath"
+	"time"
 
-	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/backend"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
-	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/progress"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -51,25 +59,162 @@ func init() {
 	generateCmd.Flags().Bool("dry-run", false, "Show what would be done without making changes")
 	generateCmd.Flags().Bool("force", false, "Force generation even if booted from snapshot")
 	generateCmd.Flags().BoolP("generate-include", "g", false, "Force generation of refind-btrfs-snapshots.conf for inclusion into refind.conf")
+	generateCmd.Flags().Bool("refind-linux-only", false, "Only update refind_linux.conf files; never generate refind-btrfs-snapshots.conf, skipping menuentry-style sources")
+	generateCmd.Flags().Bool("refresh-fstab-only", false, "Only rewrite the selected snapshots' fstabs (and crypttabs); leave every rEFInd config untouched")
+	generateCmd.Flags().Bool("no-write-markers", false, "Write refind_linux.conf snapshot entries without section markers, for manual management; later runs can't update or remove them")
 	generateCmd.Flags().BoolP("yes", "y", false, "Automatically approve all changes without prompting")
+	generateCmd.Flags().StringArray("exclude-kernel", nil, "Exclude a kernel (by name, e.g. linux-debug) from snapshot generation (repeatable)")
+	generateCmd.Flags().StringSlice("kernel-filter", nil, "Only generate snapshot entries for these kernels, by name or image file name (e.g. linux-lts)")
+	generateCmd.Flags().Bool("verify-hashes", false, "Record hashes of in-snapshot kernels/initramfs and warn when a recorded hash changes")
+	generateCmd.Flags().Bool("backup-configs", false, "Save a timestamped .bak copy of each file before overwriting it")
+	generateCmd.Flags().Bool("all-volumes", false, "Generate snapshot entries for every btrfs volume with a bootable rEFInd entry, not just the running root")
+	generateCmd.Flags().String("stage-dir", "", "Write all generated files under this directory (mirroring their real paths) instead of the live ESP and snapshots")
+	generateCmd.Flags().String("report", "", "Write a Markdown (or HTML, by .html extension) report of the run to this path for attaching to bug reports")
+	generateCmd.Flags().String("only-mode", "", "Regenerate only entries for snapshots in this boot mode (esp or btrfs), leaving the other mode's entries untouched")
+	generateCmd.Flags().Bool("check", false, "Make no changes; exit non-zero if the generated configuration is out of date")
+	generateCmd.Flags().Bool("selfcheck", false, "Make no changes; read back the regenerated rEFInd configs and exit non-zero if any snapshot entry doesn't match its snapshot")
+	generateCmd.Flags().Bool("diff-only", false, "Make no changes, even with --yes; print the pending changes as a plain unified diff on stdout")
+	generateCmd.Flags().Bool("summary-only", false, "Don't print the diff or menu changes; with --yes or --dry-run, log only the counts of added, removed and updated entries and files")
+	generateCmd.Flags().Bool("esp-ro-check", false, "Before generating, check that rEFInd's config and EFI binary are readable on the ESP, failing early if rEFInd doesn't appear installed")
+	generateCmd.Flags().Bool("explain-skips", false, "Print a line for each snapshot found but left without boot entries, saying why")
+	generateCmd.Flags().Int("entries-per-snapshot", 0, "Generate entries for at most this many kernels found in each btrfs-mode snapshot, live kernels first (0 = all)")
+	generateCmd.Flags().Duration("timeout-per-snapshot", 0, "Skip a snapshot, with a warning, when processing it takes longer than this (e.g. 30s; 0 = no limit)")
+	generateCmd.Flags().Bool("since-last-run", false, "Exit early without changes when no snapshots were added or removed since the last successful run")
+	generateCmd.Flags().String("backend", backend.Refind, "Bootloader to write snapshot entries for: refind, or grub for a menuentry fragment at grub.config_path")
+	generateCmd.Flags().String("profiles", "", "Run generate once for each *.yaml config file in this directory instead of a single --config")
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
-	log.Info().Msg("Starting rEFInd btrfs snapshot generation")
+	if dir, _ := cmd.Flags().GetString("profiles"); dir != "" {
+		return runGenerateProfiles(cmd, dir)
+	}
 
 	cfg, err := loadConfig(cmd)
 	if err != nil {
 		return err
 	}
+	return generateWithConfig(cmd, cfg, nil)
+}
+
+// generateWithConfig runs generate with cfg, taking everything else from
+// the command's flags. run, when set, shares subvolume lookups with the
+// other profiles of a --profiles run and collects the run's summary.
+func generateWithConfig(cmd *cobra.Command, cfg *config.Config, run *profileRun) (err error) {
+	log.Info().Msg("Starting rEFInd btrfs snapshot generation")
+	started := time.Now()
+
+	reportPath, _ := cmd.Flags().GetString("report")
+	if diffOnly, _ := cmd.Flags().GetBool("diff-only"); diffOnly && reportPath != "" {
+		return fmt.Errorf("--diff-only and --report are mutually exclusive")
+	}
+	// The report is written however the run ends, with the error it failed
+	// with, and with as much of the plan and summary as was reached.
+	var (
+		pipeline *generator.Pipeline
+		plan     *generator.Plan
+		summary  *generator.OperationSummary
+	)
+	if reportPath != "" {
+		warnings := &generator.WarningCollector{}
+		log.Logger = log.Output(zerolog.MultiLevelWriter(consoleWriter(), warnings))
+		defer func() {
+			if pipeline == nil {
+				pipeline = &generator.Pipeline{Cfg: cfg}
+			}
+			report := pipeline.NewReport(plan, summary, warnings.Messages())
+			if err != nil {
+				report.Error = err.Error()
+			}
+			if writeErr := report.Write(reportPath); writeErr != nil {
+				log.Error().Err(writeErr).Msg("Failed to write generation report")
+				if err == nil {
+					err = writeErr
+				}
+				return
+			}
+			log.Info().Str("path", reportPath).Msg("Wrote generation report")
+		}()
+	}
 
 	if err := checkRootPrivileges(); err != nil {
 		log.Warn().Err(err).Msg("Not running as root - some operations may fail")
 	}
 
-	espPath, err := detectESPPath(cfg)
+	var onlyMode kernel.BootMode
+	if s, _ := cmd.Flags().GetString("only-mode"); s != "" {
+		if onlyMode, err = kernel.ParseBootMode(s); err != nil {
+			return fmt.Errorf("invalid --only-mode: %w", err)
+		}
+	}
+
+	refindLinuxOnly, _ := cmd.Flags().GetBool("refind-linux-only")
+	if refindLinuxOnly && cfg.GenerateInclude.IsTrue() {
+		return fmt.Errorf("--refind-linux-only and --generate-include are mutually exclusive")
+	}
+	fstabOnly, _ := cmd.Flags().GetBool("refresh-fstab-only")
+	backendName, _ := cmd.Flags().GetString("backend")
+	entries, err := backend.New(backendName)
 	if err != nil {
+		return fmt.Errorf("invalid --backend: %w", err)
+	}
+	if entries.Name() == backend.GRUB {
+		if err := checkGRUBFlags(cmd, cfg); err != nil {
+			return err
+		}
+	}
+	if fstabOnly {
+		sinceLastRun, _ := cmd.Flags().GetBool("since-last-run")
+		if refindLinuxOnly || cfg.GenerateInclude.IsTrue() || sinceLastRun {
+			return fmt.Errorf("--refresh-fstab-only can't be combined with --refind-linux-only, --generate-include or --since-last-run")
+		}
+		if cfg.Behavior.BootReadOnly.IsTrue() {
+			return fmt.Errorf("--refresh-fstab-only has nothing to do with behavior.boot_readonly, which leaves snapshot fstabs untouched")
+		}
+	}
+	noWriteMarkers, _ := cmd.Flags().GetBool("no-write-markers")
+	if noWriteMarkers {
+		log.Warn().Msg("--no-write-markers: snapshot entries written to refind_linux.conf are yours to manage; later runs won't update or remove them")
+	}
+
+	btrfsManager := cliconfig.NewBtrfsManager(cfg, nil)
+	if run != nil {
+		btrfsManager.SetShowCache(run.showCache)
+	}
+	allVolumes, _ := cmd.Flags().GetBool("all-volumes")
+	if sinceLastRun, _ := cmd.Flags().GetBool("since-last-run"); sinceLastRun {
+		state, err := generator.LoadRunState(cfg.Behavior.StateFile)
+		if err != nil {
+			return err
+		}
+		unchanged, err := generator.SnapshotsUnchanged(btrfsManager, state, allVolumes)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			log.Info().Time("last_run", state.LastRun).Msg("No snapshot changes since last run - configurations are up to date")
+			return nil
+		}
+	}
+
+	// Runs that write nothing to the ESP only need to read it.
+	espReadOnly := cfg.DryRun.IsTrue()
+	for _, name := range []string{"check", "diff-only", "selfcheck"} {
+		if set, _ := cmd.Flags().GetBool(name); set {
+			espReadOnly = true
+		}
+	}
+	if stageDir, _ := cmd.Flags().GetString("stage-dir"); stageDir != "" {
+		espReadOnly = true
+	}
+	espPath, releaseESP, err := detectESPPath(cfg, espReadOnly)
+	if err != nil {
+		if espUnavailable(err) && !cfg.Behavior.RequireESP.IsTrue() {
+			log.Warn().Err(err).Msg("ESP not available - skipping generation (behavior.require_esp is false)")
+			return nil
+		}
 		return err
 	}
+	defer releaseESP()
 
 	kernelScanner := buildKernelScanner(espPath, cfg.Kernel.BootImagePatterns)
 	allImages := scanBootImages(espPath, kernelScanner)
@@ -85,56 +230,306 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		log.Debug().Msg("No boot images found on ESP, staleness checking will be unavailable")
 	}
 
-	r := runner.New(cfg.DryRun.IsTrue())
-	pipeline := &generator.Pipeline{
+	bootSets, excludedBootSets := generator.FilterKernels(bootSets, cfg.Kernel.Filter)
+	if len(cfg.Kernel.Filter) > 0 && len(excludedBootSets) > 0 && len(bootSets) == 0 {
+		log.Warn().
+			Strs("kernel_filter", cfg.Kernel.Filter).
+			Strs("kernels", bootSetLayoutLabels(excludedBootSets)).
+			Msg("No kernel on the ESP matches kernel.filter - nothing to generate")
+		return nil
+	}
+	excludeNames, _ := cmd.Flags().GetStringArray("exclude-kernel")
+	var excluded []*kernel.BootSet
+	bootSets, excluded = generator.ExcludeKernels(bootSets, excludeNames)
+	excludedBootSets = append(excludedBootSets, excluded...)
+
+	var hashes *kernel.HashStore
+	if cfg.Kernel.VerifyHashes.IsTrue() {
+		if hashes, err = kernel.LoadHashStore(cfg.Kernel.HashFile); err != nil {
+			return err
+		}
+	}
+
+	check, _ := cmd.Flags().GetBool("check")
+	diffOnly, _ := cmd.Flags().GetBool("diff-only")
+	if diffOnly && check {
+		return fmt.Errorf("--diff-only and --check are mutually exclusive")
+	}
+	selfCheck, _ := cmd.Flags().GetBool("selfcheck")
+	if selfCheck && (check || diffOnly || fstabOnly) {
+		return fmt.Errorf("--selfcheck can't be combined with --check, --diff-only or --refresh-fstab-only")
+	}
+	summaryOnly, _ := cmd.Flags().GetBool("summary-only")
+	if summaryOnly && diffOnly {
+		return fmt.Errorf("--summary-only and --diff-only are mutually exclusive")
+	}
+	if summaryOnly && !cfg.AutoApprove.IsTrue() && !cfg.DryRun.IsTrue() && !check && !selfCheck {
+		// Changes can't be confirmed without seeing them.
+		return fmt.Errorf("--summary-only needs --yes or --dry-run")
+	}
+	r := runner.New(cfg.DryRun.IsTrue() || check || diffOnly || selfCheck)
+	stageDir, _ := cmd.Flags().GetString("stage-dir")
+	if stageDir != "" {
+		if check {
+			return fmt.Errorf("--stage-dir and --check are mutually exclusive")
+		}
+		if diffOnly {
+			return fmt.Errorf("--stage-dir and --diff-only are mutually exclusive")
+		}
+		if selfCheck {
+			return fmt.Errorf("--stage-dir and --selfcheck are mutually exclusive")
+		}
+		if cfg.DryRun.IsTrue() {
+			return fmt.Errorf("--stage-dir and --dry-run are mutually exclusive")
+		}
+		if stageDir, err = filepath.Abs(stageDir); err != nil {
+			return fmt.Errorf("invalid --stage-dir: %w", err)
+		}
+		log.Info().Str("stage_dir", stageDir).Msg("Staging all writes instead of modifying the live system")
+		r = runner.NewStaging(stageDir)
+	}
+	fstabMgr := cliconfig.NewFstabManager(cfg)
+	pipeline = &generator.Pipeline{
 		Cfg:           cfg,
-		Btrfs:         btrfs.NewManager(cfg.Snapshot.SearchDirectories, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue()),
-		Fstab:         fstab.NewManager(),
+		Btrfs:         btrfsManager,
+		Fstab:         fstabMgr,
 		Runner:        r,
 		ESPPath:       espPath,
 		KernelScanner: kernelScanner,
 		BootSets:      bootSets,
+
+		ExcludedBootSets: excludedBootSets,
+		OnlyMode:         onlyMode,
+		RefindLinuxOnly:  refindLinuxOnly,
+		FstabOnly:        fstabOnly,
+		KeepSnapshots:    fstabOnly,
+		NoWriteMarkers:   noWriteMarkers,
+		Hashes:           hashes,
+		SelfCheck:        selfCheck,
 	}
 
-	plan, err := pipeline.Discover()
+	if espCheck, _ := cmd.Flags().GetBool("esp-ro-check"); espCheck {
+		if err := pipeline.CheckRefindInstalled(); err != nil {
+			return err
+		}
+	}
+
+	discover := pipeline.Discover
+	if allVolumes {
+		discover = pipeline.DiscoverAll
+	}
+	progress.PhaseStart("discover")
+	plan, err = discover()
+	progress.PhaseEnd("discover", err)
 	if err != nil {
 		return err
 	}
+	if explain, _ := cmd.Flags().GetBool("explain-skips"); explain {
+		// --diff-only's stdout is the patch alone.
+		w := cmd.OutOrStdout()
+		if diffOnly {
+			w = cmd.ErrOrStderr()
+		}
+		writeSkips(w, plan.Skipped)
+	}
 
-	patch, summary, err := pipeline.BuildPatch(plan)
+	progress.PhaseStart("build")
+	patch, summary := pipeline.BuildSnapshotPatch(plan)
+	if !fstabOnly {
+		err = entries.AddEntries(pipeline, plan, patch, summary)
+	}
+	progress.PhaseEnd("build", err)
 	if err != nil {
 		return err
 	}
 
-	if len(patch.Files) == 0 {
+	if check {
+		// Out of date is an expected result, not a usage error.
+		cmd.SilenceUsage = true
+		return checkPatch(patch)
+	}
+	if selfCheck {
+		cmd.SilenceUsage = true
+		return reportSelfCheck(summary)
+	}
+	if diffOnly {
+		// Nothing past this point may write, whatever --yes says.
+		_, err := io.WriteString(cmd.OutOrStdout(), patch.Generate())
+		return err
+	}
+
+	if r.IsDryRun() && !summaryOnly {
+		// The boot menu changes first, so they aren't lost in the file diff.
+		for _, changes := range summary.MenuChanges {
+			fmt.Fprintln(cmd.OutOrStdout(), changes)
+		}
+	}
+	progress.PhaseStart("apply")
+	applied, err := applyPatch(cfg, patch, r, !summaryOnly)
+	progress.PhaseEnd("apply", err)
+	if err != nil || !applied {
+		return err
+	}
+
+	if err := pipeline.SaveHashes(cfg.Kernel.HashFile); err != nil {
+		return fmt.Errorf("failed to save hash sidecar: %w", err)
+	}
+	// The state file records the snapshots the boot menu was last
+	// generated for, which a fstab refresh doesn't change.
+	if !fstabOnly {
+		if err := pipeline.SaveRunState(cfg.Behavior.StateFile, plan, started); err != nil {
+			return fmt.Errorf("failed to save state file: %w", err)
+		}
+	}
+
+	if run != nil {
+		run.summary.Merge(summary)
+		run.dryRun = run.dryRun && r.IsDryRun()
+	}
+	if summaryOnly {
+		generator.LogSummaryCounts(summary, r.IsDryRun())
+	} else {
+		generator.LogSummary(summary, r.IsDryRun())
+	}
+	if r.IsDryRun() {
+		log.Info().Msg("Dry run completed - no changes made")
+	} else {
+		if stageDir != "" {
+			log.Info().Str("stage_dir", stageDir).Msg("Staged rEFInd snapshot configurations")
+			return nil
+		}
+		if fstabOnly {
+			log.Info().Int("fstabs", len(summary.UpdatedFstabs)).Int("crypttabs", len(summary.UpdatedCrypttabs)).Msg("Successfully refreshed snapshot fstabs")
+			return nil
+		}
+		if entries.Name() == backend.GRUB {
+			log.Info().Str("path", cfg.GRUB.ConfigPath).Msg("Successfully generated GRUB snapshot entries")
+			return nil
+		}
+		log.Info().Msg("Successfully generated rEFInd snapshot configurations")
+	}
+	return nil
+}
+
+// applyPatch shows patch, unless showPatch is false, and once approved
+// (prompting unless --yes), backs up and writes its files through r; dry
+// runs only show it. Returns false when the user declined the changes.
+func applyPatch(cfg *config.Config, patch *diff.PatchDiff, r runner.Runner, showPatch bool) (bool, error) {
+	if patch.Empty() {
 		log.Info().Msg("No changes needed - configurations are up to date")
-	} else if r.IsDryRun() {
+		return true, nil
+	}
+	if r.IsDryRun() {
+		if !showPatch {
+			log.Info().Int("files", patch.Changes()).Msg("[DRY RUN] Would apply changes")
+			return true, nil
+		}
 		diff.ShowPatchWithPager(patch, !cfg.AutoApprove.IsTrue())
 		log.Info().Msg("[DRY RUN] Would apply all changes shown above")
+		return true, nil
+	}
+
+	if !cfg.AutoApprove.IsTrue() {
+		if !diff.ConfirmPatchChanges(patch, false) {
+			log.Info().Msg("User declined changes - operation cancelled")
+			return false, nil
+		}
 	} else {
-		if !cfg.AutoApprove.IsTrue() {
-			if !diff.ConfirmPatchChanges(patch, false) {
-				log.Info().Msg("User declined changes - operation cancelled")
-				return nil
-			}
-		} else {
+		if showPatch {
 			diff.ShowPatchWithPager(patch, false)
-			log.Info().Msg("Auto-approving all changes")
 		}
-		if err := diff.Apply(patch, r); err != nil {
-			return fmt.Errorf("failed to apply changes: %w", err)
+		log.Info().Int("files", patch.Changes()).Msg("Auto-approving all changes")
+	}
+	now := time.Now()
+	if cfg.Behavior.BackupConfigs.IsTrue() {
+		if err := diff.Backup(patch, r, cfg.Behavior.BackupRetain, now); err != nil {
+			return false, fmt.Errorf("failed to back up files, no changes applied: %w", err)
 		}
 	}
+	applyErr := diff.Apply(patch, r)
+	if err := auditPatch(cfg, patch, r, now, applyErr); err != nil {
+		return false, err
+	}
+	if applyErr != nil {
+		return false, fmt.Errorf("failed to apply changes: %w", applyErr)
+	}
+	return true, nil
+}
 
-	generator.LogSummary(summary, r.IsDryRun())
-	if r.IsDryRun() {
-		log.Info().Msg("Dry run completed - no changes made")
-	} else {
-		log.Info().Msg("Successfully generated rEFInd snapshot configurations")
+// auditPatch appends patch to behavior.audit_log once it has been applied
+// to the live system, whether or not every write succeeded. Staged writes
+// aren't audited; they change nothing until installed.
+func auditPatch(cfg *config.Config, patch *diff.PatchDiff, r runner.Runner, now time.Time, applyErr error) error {
+	if cfg.Behavior.AuditLog == "" {
+		return nil
+	}
+	if _, staged := r.(*runner.StagingRunner); staged {
+		return nil
+	}
+	if err := diff.AppendAuditLog(cfg.Behavior.AuditLog, diff.NewAuditRecord(patch, now, applyErr)); err != nil {
+		return fmt.Errorf("failed to record changes in audit log %s: %w", cfg.Behavior.AuditLog, err)
+	}
+	return nil
+}
+
+// espUnavailable reports whether err means the ESP is absent or unmounted,
+// as with an unplugged removable device, rather than misconfigured.
+func espUnavailable(err error) bool {
+	return errors.Is(err, esp.ErrESPNotFound) || errors.Is(err, esp.ErrESPNotMounted)
+}
+
+// errOutOfDate is returned by generate --check when a fresh run would change
+// files; main maps it to exitOutOfDate.
+var errOutOfDate = errors.New("rEFInd snapshot configuration is out of date")
+
+// checkPatch reports whether patch is empty, logging each file a fresh
+// generate would change.
+func checkPatch(patch *diff.PatchDiff) error {
+	if patch.Empty() {
+		log.Info().Msg("Configurations are up to date")
+		return nil
+	}
+	for _, fileDiff := range patch.Files {
+		log.Warn().Str("path", fileDiff.Path).Bool("new", fileDiff.IsNew).Msg("File is out of date")
 	}
+	for _, c := range patch.Copies {
+		log.Warn().Str("path", c.Dest).Str("source", c.Source).Msg("File would be copied")
+	}
+	for _, path := range patch.Removals {
+		log.Warn().Str("path", path).Msg("File would be removed")
+	}
+	return fmt.Errorf("%d files would change: %w", patch.Changes(), errOutOfDate)
+}
+
+// reportSelfCheck logs the discrepancies --selfcheck found and fails when
+// there are any.
+func reportSelfCheck(summary *generator.OperationSummary) error {
+	if len(summary.SelfCheckedConfigs) == 0 {
+		log.Warn().Msg("Self-check found no generated rEFInd configs to read back")
+		return nil
+	}
+	for _, problem := range summary.SelfCheckProblems {
+		log.Error().Str("problem", problem).Msg("Generated entry doesn't read back as written")
+	}
+	if n := len(summary.SelfCheckProblems); n > 0 {
+		return fmt.Errorf("generated rEFInd configs failed the self-check (%d problems)", n)
+	}
+	log.Info().Strs("configs", summary.SelfCheckedConfigs).Msg("Self-check passed: generated entries read back as written")
 	return nil
 }
 
+// writeSkips prints each skipped snapshot and why it was skipped
+// (--explain-skips).
+func writeSkips(w io.Writer, skipped []generator.SkippedSnapshot) {
+	if len(skipped) == 0 {
+		fmt.Fprintln(w, "No snapshots were skipped")
+		return
+	}
+	for _, s := range skipped {
+		fmt.Fprintf(w, "Skipped %s: %s\n", s.Path, s.Reason)
+	}
+}
+
 // bootSetLayoutLabels returns "<kernel-name>:<layout>" labels for each boot set,
 // for inclusion in summary log lines.
 func bootSetLayoutLabels(bootSets []*kernel.BootSet) []string {
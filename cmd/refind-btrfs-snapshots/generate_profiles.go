@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// profileRun is what the profiles of a generate --profiles run share: one
+// cache of subvolume lookups, so profiles on the same filesystem discover
+// its snapshots once, and the summary of every profile that finished.
+type profileRun struct {
+	showCache *btrfs.ShowCache
+	summary   *generator.OperationSummary
+	dryRun    bool // every finished profile was a dry run
+}
+
+// runGenerateProfiles runs generate once per *.yaml config file in dir, in
+// name order, with the command's flags applied on top of each, then logs a
+// summary across all of them. A failing profile doesn't stop the others;
+// their errors are returned together.
+func runGenerateProfiles(cmd *cobra.Command, dir string) error {
+	if cmd.Flags().Changed("config") {
+		return fmt.Errorf("--profiles and --config are mutually exclusive")
+	}
+	if reportPath, _ := cmd.Flags().GetString("report"); reportPath != "" {
+		return fmt.Errorf("--profiles and --report are mutually exclusive")
+	}
+
+	paths, err := profilePaths(dir)
+	if err != nil {
+		return err
+	}
+
+	base := log.Logger
+	defer func() { log.Logger = base }()
+
+	run := &profileRun{
+		showCache: btrfs.NewShowCache(),
+		summary:   &generator.OperationSummary{},
+		dryRun:    true,
+	}
+	var errs []error
+	var succeeded []string
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		log.Logger = base.With().Str("profile", name).Logger()
+
+		cfg, err := cliconfig.LoadFile(cmd, path, flagToKey)
+		if err == nil {
+			err = generateWithConfig(cmd, cfg, run)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Profile failed")
+			errs = append(errs, fmt.Errorf("profile %s: %w", name, err))
+			continue
+		}
+		succeeded = append(succeeded, name)
+	}
+
+	log.Logger = base
+	log.Info().
+		Int("profiles", len(paths)).
+		Strs("succeeded", succeeded).
+		Int("failed", len(errs)).
+		Msg("Finished generating for all profiles")
+	if len(succeeded) > 0 {
+		generator.LogSummary(run.summary, run.dryRun)
+	}
+	return errors.Join(errs...)
+}
+
+// profilePaths returns the *.yaml files in dir, sorted by name.
+func profilePaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --profiles directory %s: %w", dir, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.yaml profiles in %s", dir)
+	}
+	return paths, nil
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"os/user"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
 	"github.com/spf13/cobra"
@@ -48,6 +55,7 @@ func TestGenerateCommandFlags(t *testing.T) {
 		name         string
 		defaultValue string
 	}{
+		{"backend", "refind"},
 		{"config-path", ""},
 		{"esp-path", ""},
 		{"count", "0"},
@@ -55,6 +63,25 @@ func TestGenerateCommandFlags(t *testing.T) {
 		{"force", "false"},
 		{"generate-include", "false"},
 		{"yes", "false"},
+		{"exclude-kernel", "[]"},
+		{"kernel-filter", "[]"},
+		{"all-volumes", "false"},
+		{"backup-configs", "false"},
+		{"check", "false"},
+		{"diff-only", "false"},
+		{"entries-per-snapshot", "0"},
+		{"esp-ro-check", "false"},
+		{"explain-skips", "false"},
+		{"selfcheck", "false"},
+		{"no-write-markers", "false"},
+		{"only-mode", ""},
+		{"profiles", ""},
+		{"report", ""},
+		{"refresh-fstab-only", "false"},
+		{"since-last-run", "false"},
+		{"stage-dir", ""},
+		{"summary-only", "false"},
+		{"verify-hashes", "false"},
 	}
 
 	for _, test := range flagTests {
@@ -539,3 +566,45 @@ func TestBootSetLayoutLabels(t *testing.T) {
 	}
 }
 
+func TestCheckPatch(t *testing.T) {
+	assert.NoError(t, checkPatch(diff.NewPatchDiff()))
+
+	patch := diff.NewPatchDiff()
+	patch.AddFile(&diff.FileDiff{Path: "/boot/efi/EFI/refind/refind-btrfs-snapshots.conf", Original: "old\n", Modified: "new\n"})
+	err := checkPatch(patch)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errOutOfDate)
+	assert.Equal(t, exitOutOfDate, exitCode(err))
+}
+
+func TestReportSelfCheck(t *testing.T) {
+	assert.NoError(t, reportSelfCheck(&generator.OperationSummary{}))
+	assert.NoError(t, reportSelfCheck(&generator.OperationSummary{SelfCheckedConfigs: []string{"/boot/efi/EFI/refind/refind-btrfs-snapshots.conf"}}))
+
+	err := reportSelfCheck(&generator.OperationSummary{
+		SelfCheckedConfigs: []string{"/boot/efi/EFI/arch/refind_linux.conf"},
+		SelfCheckProblems:  []string{`/boot/efi/EFI/arch/refind_linux.conf: entry "x" names no known snapshot`},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed the self-check")
+}
+
+func TestProfilePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"laptop.yaml", "desktop.yaml", "notes.txt", "old.yaml.bak"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+
+	paths, err := profilePaths(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "desktop.yaml"), filepath.Join(dir, "laptop.yaml")}, paths)
+
+	_, err = profilePaths(t.TempDir())
+	assert.ErrorContains(t, err, "no *.yaml profiles")
+}
+
+func TestESPUnavailable(t *testing.T) {
+	assert.True(t, espUnavailable(fmt.Errorf("failed to detect ESP: %w", esp.ErrESPNotFound)))
+	assert.True(t, espUnavailable(fmt.Errorf("ESP with UUID abcd: %w", esp.ErrESPNotMounted)))
+	assert.False(t, espUnavailable(errors.New("ESP path not configured and auto-detection disabled")))
+}
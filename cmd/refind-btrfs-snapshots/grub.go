@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// checkGRUBFlags rejects the generate flags that only mean something for
+// rEFInd's configs, or that GRUB output can't honour.
+func checkGRUBFlags(cmd *cobra.Command, cfg *config.Config) error {
+	if cfg.GenerateInclude.IsTrue() {
+		return fmt.Errorf("--backend grub and --generate-include are mutually exclusive")
+	}
+	for _, name := range []string{"refind-linux-only", "refresh-fstab-only", "no-write-markers", "selfcheck", "esp-ro-check", "all-volumes"} {
+		if set, _ := cmd.Flags().GetBool(name); set {
+			return fmt.Errorf("--backend grub and --%s are mutually exclusive", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckGRUBFlags(t *testing.T) {
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{}
+		for _, name := range []string{"refind-linux-only", "refresh-fstab-only", "no-write-markers", "selfcheck", "esp-ro-check", "all-volumes"} {
+			cmd.Flags().Bool(name, false, "")
+		}
+		return cmd
+	}
+	cfg := config.Defaults()
+
+	require.NoError(t, checkGRUBFlags(newCmd(), &cfg))
+
+	cmd := newCmd()
+	require.NoError(t, cmd.Flags().Set("selfcheck", "true"))
+	assert.EqualError(t, checkGRUBFlags(cmd, &cfg), "--backend grub and --selfcheck are mutually exclusive")
+
+	cfg.GenerateInclude = config.Truthy(true)
+	assert.EqualError(t, checkGRUBFlags(newCmd(), &cfg), "--backend grub and --generate-include are mutually exclusive")
+}
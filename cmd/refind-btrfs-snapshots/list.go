@@ -19,13 +19,21 @@ func init() {
 	listCmd.AddCommand(listSnapshotsCmd)
 
 	listVolumesCmd.Flags().Bool("json", false, "Output in JSON format")
+	listVolumesCmd.Flags().Bool("csv", false, "Output in CSV format, with a header row")
 	listVolumesCmd.Flags().Bool("show-all-ids", false, "Show all device identifiers (UUID, PARTUUID, LABEL, etc.)")
 
 	listSnapshotsCmd.Flags().Bool("json", false, "Output in JSON format")
+	listSnapshotsCmd.Flags().Bool("csv", false, "Output in CSV format, with a header row; size_bytes is filled in with --show-size")
+	listSnapshotsCmd.Flags().Bool("yaml", false, "Output in YAML format")
 	listSnapshotsCmd.Flags().Bool("show-size", false, "Show snapshot sizes (slower)")
+	listSnapshotsCmd.Flags().String("sort", "time", "Sort snapshots by time (newest first), id, size (largest first; needs --show-size) or path")
+	listSnapshotsCmd.Flags().Bool("reverse", false, "Reverse the --sort order")
 	listSnapshotsCmd.Flags().Bool("show-volume", false, "Show volume column (useful for multi-filesystem setups)")
 	listSnapshotsCmd.Flags().String("volume", "", "Show snapshots only for specific volume UUID or device")
 	listSnapshotsCmd.Flags().StringSlice("search-dirs", nil, "Override snapshot search directories")
+	listSnapshotsCmd.Flags().Bool("list-kernels", false, "Show the kernel images in each snapshot's /boot and its /lib/modules versions")
+	listSnapshotsCmd.Flags().Bool("stale-only", false, "Show only snapshots that are stale for a detected boot kernel, with reason and action")
+	listSnapshotsCmd.Flags().Bool("stale", false, "Show each snapshot's boot mode and staleness verdict per kernel, with the action generate would take")
 }
 
 func runListRoot(cmd *cobra.Command, args []string) error {
@@ -97,10 +97,11 @@ func runListBootsets(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	espPath, err := detectESPPath(cfg)
+	espPath, releaseESP, err := detectESPPath(cfg, true)
 	if err != nil {
 		return err
 	}
+	defer releaseESP()
 
 	scanner := buildKernelScanner(espPath, cfg.Kernel.BootImagePatterns)
 	allImages := scanBootImages(espPath, scanner)
@@ -1,16 +1,46 @@
 package main
 
 import (
+	"cmp"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"slices"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"gopkg.in/yaml.v3"
 )
 
+// snapshotSortKeys are the --sort keys, each compared in its default
+// direction: newest and largest first, lowest ID and path first.
+var snapshotSortKeys = map[string]func(a, b *SnapshotInfo) int{
+	"time": func(a, b *SnapshotInfo) int { return b.Snapshot.SnapshotTime.Compare(a.Snapshot.SnapshotTime) },
+	"id":   func(a, b *SnapshotInfo) int { return cmp.Compare(a.Snapshot.ID, b.Snapshot.ID) },
+	"size": func(a, b *SnapshotInfo) int { return cmp.Compare(b.SizeBytes, a.SizeBytes) },
+	"path": func(a, b *SnapshotInfo) int { return strings.Compare(a.Snapshot.Path, b.Snapshot.Path) },
+}
+
+// sortSnapshots sorts snapshots by key, one of snapshotSortKeys, in the
+// opposite direction with reverse. The sort is stable, so snapshots with
+// equal keys keep their discovery order.
+func sortSnapshots(snapshots []*SnapshotInfo, key string, reverse bool) {
+	compare := snapshotSortKeys[key]
+	slices.SortStableFunc(snapshots, func(a, b *SnapshotInfo) int {
+		if reverse {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+}
+
 func outputVolumesJSON(filesystems []*btrfs.Filesystem) error {
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
@@ -57,17 +87,107 @@ func outputVolumesTable(filesystems []*btrfs.Filesystem, showAllIds bool) error
 	return nil
 }
 
-func outputSnapshotsJSON(snapshots []*SnapshotInfo) error {
-	encoder := json.NewEncoder(os.Stdout)
+// outputVolumesCSV writes one RFC 4180 row per filesystem, with every
+// identifier, for spreadsheets.
+func outputVolumesCSV(out io.Writer, filesystems []*btrfs.Filesystem) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"device", "mount_point", "uuid", "partuuid", "label", "partlabel", "subvolume"})
+	for _, fs := range filesystems {
+		subvolPath := ""
+		if fs.Subvolume != nil {
+			subvolPath = fs.Subvolume.Path
+		}
+		w.Write([]string{fs.Device, fs.MountPoint, fs.UUID, fs.PartUUID, fs.Label, fs.PartLabel, subvolPath})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// outputSnapshotsCSV writes one RFC 4180 row per snapshot, in order, for
+// spreadsheets. Times are RFC 3339; size_bytes is empty unless sizes
+// were calculated.
+func outputSnapshotsCSV(out io.Writer, snapshots []*SnapshotInfo, useLocalTime bool) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"time", "path", "id", "read_only", "size_bytes", "description", "volume"})
+	for _, info := range snapshots {
+		t := info.Snapshot.SnapshotTime.UTC()
+		if useLocalTime {
+			t = t.Local()
+		}
+		sizeBytes := ""
+		if info.SizeBytes > 0 {
+			sizeBytes = strconv.FormatInt(info.SizeBytes, 10)
+		}
+		w.Write([]string{
+			t.Format(time.RFC3339),
+			info.Snapshot.Path,
+			strconv.FormatUint(info.Snapshot.ID, 10),
+			strconv.FormatBool(info.Snapshot.IsReadOnly),
+			sizeBytes,
+			info.Snapshot.Description,
+			info.Filesystem.GetBestIdentifier(),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// snapshotYAML is one snapshot as written by --yaml.
+type snapshotYAML struct {
+	Path         string    `yaml:"path"`
+	ID           uint64    `yaml:"id"`
+	Created      time.Time `yaml:"created"`
+	IsReadOnly   bool      `yaml:"is_readonly"`
+	OriginalPath string    `yaml:"original_path"`
+	Description  string    `yaml:"description,omitempty"`
+	SnapperNum   int       `yaml:"snapper_num,omitempty"`
+	SizeBytes    int64     `yaml:"size_bytes,omitempty"`
+	Volume       string    `yaml:"volume"`
+}
+
+// outputSnapshotsYAML writes the snapshots, in order, as a YAML list.
+// Times are in UTC unless useLocalTime is set; size_bytes is only present
+// when sizes were calculated.
+func outputSnapshotsYAML(out io.Writer, snapshots []*SnapshotInfo, useLocalTime bool) error {
+	docs := make([]snapshotYAML, 0, len(snapshots))
+	for _, info := range snapshots {
+		created := info.Snapshot.SnapshotTime.UTC()
+		if useLocalTime {
+			created = created.Local()
+		}
+		docs = append(docs, snapshotYAML{
+			Path:         info.Snapshot.Path,
+			ID:           info.Snapshot.ID,
+			Created:      created,
+			IsReadOnly:   info.Snapshot.IsReadOnly,
+			OriginalPath: info.Snapshot.OriginalPath,
+			Description:  info.Snapshot.Description,
+			SnapperNum:   info.Snapshot.SnapperNum,
+			SizeBytes:    info.SizeBytes,
+			Volume:       info.Filesystem.GetBestIdentifier(),
+		})
+	}
+
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(docs); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// outputSnapshotsJSON writes snapshots to out as an indented JSON array,
+// [] when there are none.
+func outputSnapshotsJSON(out io.Writer, snapshots []*SnapshotInfo) error {
+	if snapshots == nil {
+		snapshots = []*SnapshotInfo{}
+	}
+	encoder := json.NewEncoder(out)
 	encoder.SetIndent("", "  ")
 	return encoder.Encode(snapshots)
 }
 
-func outputSnapshotsTable(snapshots []*SnapshotInfo, showSize bool, showVolume bool, useLocalTime bool) error {
-	slices.SortFunc(snapshots, func(a, b *SnapshotInfo) int {
-		return b.Snapshot.SnapshotTime.Compare(a.Snapshot.SnapshotTime)
-	})
-
+func outputSnapshotsTable(snapshots []*SnapshotInfo, showSize bool, showVolume bool, showStale bool, showKernels bool, useLocalTime bool) error {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	defer w.Flush()
 
@@ -86,6 +206,14 @@ func outputSnapshotsTable(snapshots []*SnapshotInfo, showSize bool, showVolume b
 		headers = append(headers, "SIZE")
 		separators = append(separators, "────")
 	}
+	if showStale {
+		headers = append(headers, "STALE")
+		separators = append(separators, "─────")
+	}
+	if showKernels {
+		headers = append(headers, "KERNELS", "MODULES")
+		separators = append(separators, "───────", "───────")
+	}
 
 	fmt.Fprintln(w, strings.Join(headers, "\t"))
 	fmt.Fprintln(w, strings.Join(separators, "\t"))
@@ -105,8 +233,57 @@ func outputSnapshotsTable(snapshots []*SnapshotInfo, showSize bool, showVolume b
 			}
 			row = append(row, size)
 		}
+		if showStale {
+			var reasons []string
+			for _, stale := range info.Stale {
+				reasons = append(reasons, formatStaleInfo(stale))
+			}
+			row = append(row, strings.Join(reasons, "; "))
+		}
+		if showKernels {
+			row = append(row, formatSnapshotKernels(info.Kernels), formatList(info.Modules))
+		}
 		fmt.Fprintln(w, strings.Join(row, "\t"))
 	}
 
 	return nil
 }
+
+// formatStaleInfo renders one kernel's staleness verdict, prefixed with the
+// boot mode when known and suffixed with the action when there is one.
+func formatStaleInfo(stale StaleInfo) string {
+	out := stale.Kernel
+	if stale.Mode != "" {
+		out += " [" + stale.Mode + "]"
+	}
+	out += ": " + stale.Reason
+	if stale.Action != "" {
+		out += " (action=" + stale.Action + ")"
+	}
+	return out
+}
+
+// formatSnapshotKernels renders a snapshot's kernels with their versions,
+// flagging any kernel without a matching /lib/modules directory.
+func formatSnapshotKernels(kernels []kernel.SnapshotKernel) string {
+	var out []string
+	for _, k := range kernels {
+		name := path.Base(k.Path)
+		switch {
+		case k.Version == "":
+			out = append(out, name+" (version unknown)")
+		case !k.ModulesMatch:
+			out = append(out, fmt.Sprintf("%s (%s, no modules)", name, k.Version))
+		default:
+			out = append(out, fmt.Sprintf("%s (%s)", name, k.Version))
+		}
+	}
+	return formatList(out)
+}
+
+func formatList(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"cmp"
 	"fmt"
+	"os"
+	"path"
 	"slices"
 	"strings"
 	"sync"
@@ -10,6 +12,11 @@ import (
 	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/progress"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -26,17 +33,49 @@ Shows snapshot path, creation time, and parent volume for each snapshot.
 Size calculation (--show-size) performance:
   • Fast: Uses btrfs quotas if already enabled
   • Slower: Falls back to native file scanning with progress indicator
-  • Note: Large snapshots may take time to calculate`,
+  • Note: Large snapshots may take time to calculate
+
+Staleness (--stale-only):
+  Runs the same staleness checks as generate against the boot sets on the
+  ESP and lists only snapshots that are stale for at least one kernel, with
+  the reason and the configured stale_snapshot_action.
+
+Staleness (--stale):
+  Lists every snapshot with the verdict generate would reach for each
+  kernel: its boot mode, whether it is fresh or stale and why, and the
+  action taken. btrfs-mode snapshots boot their own kernel and are shown
+  as self-contained. Nothing is written.
+
+Kernels (--list-kernels):
+  Lists the kernel images in each snapshot's /boot, as btrfs-mode booting
+  finds them, alongside its /lib/modules versions. Kernels marked
+  "no modules" have no matching modules directory and won't boot fully.`,
 	RunE: runListSnapshots,
 }
 
 // SnapshotInfo holds snapshot with filesystem context
 type SnapshotInfo struct {
-	Snapshot   *btrfs.Snapshot   `json:"snapshot"`
-	Filesystem *btrfs.Filesystem `json:"filesystem"`
-	Size       string            `json:"size,omitempty"`
+	Snapshot   *btrfs.Snapshot         `json:"snapshot"`
+	Filesystem *btrfs.Filesystem       `json:"filesystem"`
+	Size       string                  `json:"size,omitempty"`
+	SizeBytes  int64                   `json:"size_bytes,omitempty"`
+	Stale      []StaleInfo             `json:"stale,omitempty"`
+	Kernels    []kernel.SnapshotKernel `json:"kernels,omitempty"`
+	Modules    []string                `json:"modules,omitempty"`
 }
 
+// StaleInfo describes why a snapshot is stale for one boot kernel, or with
+// --stale, the verdict for each of its boot plans
+type StaleInfo struct {
+	Kernel string `json:"kernel"`
+	Mode   string `json:"mode,omitempty"`
+	Reason string `json:"reason"`
+	Action string `json:"action"`
+}
+
+// selfContainedReason is the --stale verdict of a btrfs-mode plan.
+const selfContainedReason = "self-contained (never stale)"
+
 // SnapshotProgress tracks progress for a single snapshot calculation
 type SnapshotProgress struct {
 	Index     int
@@ -100,6 +139,14 @@ func runListSnapshots(cmd *cobra.Command, args []string) error {
 	}
 
 	showSize, _ := cmd.Flags().GetBool("show-size")
+	sortKey, _ := cmd.Flags().GetString("sort")
+	reverse, _ := cmd.Flags().GetBool("reverse")
+	if _, ok := snapshotSortKeys[sortKey]; !ok {
+		return fmt.Errorf("invalid --sort %q: must be time, id, size or path", sortKey)
+	}
+	if sortKey == "size" && !showSize {
+		return fmt.Errorf("--sort size needs --show-size, sizes aren't calculated without it")
+	}
 	if showSize {
 		log.Info().Msg("Calculating snapshot sizes...")
 	}
@@ -109,7 +156,7 @@ func runListSnapshots(cmd *cobra.Command, args []string) error {
 		searchDirs = flagDirs
 		log.Debug().Strs("search_dirs", searchDirs).Msg("Using search directories from --search-dirs flag")
 	}
-	btrfsManager := btrfs.NewManager(searchDirs, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue())
+	btrfsManager := cliconfig.NewBtrfsManager(cfg, searchDirs)
 
 	filesystems, err := btrfsManager.DetectBtrfsFilesystems()
 	if err != nil {
@@ -122,6 +169,14 @@ func runListSnapshots(cmd *cobra.Command, args []string) error {
 	}
 
 	jsonOutput, _ := cmd.Flags().GetBool("json")
+	csvOutput, _ := cmd.Flags().GetBool("csv")
+	yamlOutput, _ := cmd.Flags().GetBool("yaml")
+	if jsonOutput && csvOutput {
+		return fmt.Errorf("--json and --csv are mutually exclusive")
+	}
+	if yamlOutput && (jsonOutput || csvOutput) {
+		return fmt.Errorf("--yaml can't be combined with --json or --csv")
+	}
 	showVolume, _ := cmd.Flags().GetBool("show-volume")
 	volumeFilter, _ := cmd.Flags().GetString("volume")
 	useLocalTime := cfg.Display.LocalTime.IsTrue()
@@ -170,14 +225,44 @@ func runListSnapshots(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	staleOnly, _ := cmd.Flags().GetBool("stale-only")
+	showStale, _ := cmd.Flags().GetBool("stale")
+	if staleOnly && showStale {
+		return fmt.Errorf("--stale can't be combined with --stale-only")
+	}
+	if staleOnly || showStale {
+		planner, err := newStalenessPlanner(cfg, btrfsManager)
+		if err != nil {
+			return err
+		}
+		if staleOnly {
+			allSnapshots = filterStaleSnapshots(allSnapshots, planner)
+		} else {
+			assessSnapshotStaleness(allSnapshots, planner)
+		}
+	}
+
+	showKernels, _ := cmd.Flags().GetBool("list-kernels")
+	if showKernels {
+		for _, info := range allSnapshots {
+			info.Modules = kernel.GetSnapshotModuleVersions(info.Snapshot.FilesystemPath)
+			info.Kernels = kernel.FindSnapshotKernels(info.Snapshot.FilesystemPath, info.Modules)
+		}
+	}
+
 	if showSize {
 		done := make(chan struct{})
 		var activeSnapshots sync.Map
 
-		go showParallelProgress(&activeSnapshots, len(allSnapshots), done)
+		spin := showProgress()
+		if spin {
+			go showParallelProgress(&activeSnapshots, len(allSnapshots), done)
+		}
 
 		semaphore := make(chan struct{}, maxConcurrentSizeCalculations)
 		var wg sync.WaitGroup
+		var sized atomic.Int64
+		progress.PhaseStart("size")
 
 		for i, info := range allSnapshots {
 			wg.Add(1)
@@ -187,25 +272,29 @@ func runListSnapshots(cmd *cobra.Command, args []string) error {
 				semaphore <- struct{}{}
 				defer func() { <-semaphore }()
 
-				progress := SnapshotProgress{
+				snapshotProgress := SnapshotProgress{
 					Index:     index + 1,
 					FileCount: 0,
 					Path:      snapshot.Snapshot.FilesystemPath,
 				}
-				activeSnapshots.Store(index, &progress)
+				activeSnapshots.Store(index, &snapshotProgress)
 
-				if size, err := btrfs.GetSnapshotSizeWithoutProgress(snapshot.Snapshot.FilesystemPath, &progress.FileCount); err == nil {
-					snapshot.Size = size
+				if size, sizeBytes, err := btrfsManager.GetSnapshotSizeWithoutProgress(snapshot.Snapshot.FilesystemPath, &snapshotProgress.FileCount); err == nil {
+					snapshot.Size, snapshot.SizeBytes = size, sizeBytes
 				}
 
 				activeSnapshots.Delete(index)
+				progress.Step("size", int(sized.Add(1)), len(allSnapshots), snapshot.Snapshot.Path)
 			}(i, info)
 		}
 
 		wg.Wait()
+		progress.PhaseEnd("size", nil)
 
 		close(done)
-		fmt.Print("\r\033[K")
+		if spin {
+			fmt.Print("\r\033[K")
+		}
 	}
 
 	log.Info().
@@ -214,14 +303,115 @@ func runListSnapshots(cmd *cobra.Command, args []string) error {
 		Int("total_filesystems", len(filesystems)).
 		Msg("Snapshot discovery complete")
 
+	sortSnapshots(allSnapshots, sortKey, reverse)
+	if jsonOutput {
+		// Always a JSON array, even empty, so the output can be piped to jq.
+		return outputSnapshotsJSON(os.Stdout, allSnapshots)
+	}
 	if len(allSnapshots) == 0 {
-		fmt.Println("No snapshots found")
+		if staleOnly {
+			fmt.Println("No stale snapshots found")
+		} else {
+			fmt.Println("No snapshots found")
+		}
 		return nil
 	}
 
-	if jsonOutput {
-		return outputSnapshotsJSON(allSnapshots)
+	if csvOutput {
+		return outputSnapshotsCSV(os.Stdout, allSnapshots, useLocalTime)
+	}
+	if yamlOutput {
+		return outputSnapshotsYAML(os.Stdout, allSnapshots, useLocalTime)
 	}
 
-	return outputSnapshotsTable(allSnapshots, showSize, showVolume, useLocalTime)
+	return outputSnapshotsTable(allSnapshots, showSize, showVolume, staleOnly || showStale, showKernels, useLocalTime)
+}
+
+// newStalenessPlanner builds the planner generate plans with, against the
+// boot sets detected on the ESP.
+func newStalenessPlanner(cfg *config.Config, btrfsManager *btrfs.Manager) (*kernel.Planner, error) {
+	bootSets := detectBootSets(cfg)
+	if len(bootSets) == 0 {
+		return nil, fmt.Errorf("no boot sets detected on ESP — cannot check staleness")
+	}
+	rootFS, err := btrfsManager.GetRootFilesystem()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root filesystem: %w", err)
+	}
+	return newPipelinePlanner(cfg, bootSets, rootFS)
+}
+
+// newPipelinePlanner returns generator.Pipeline.NewPlanner for cfg and
+// bootSets, loading the hash sidecar read-only when kernel.verify_hashes is
+// set.
+func newPipelinePlanner(cfg *config.Config, bootSets []*kernel.BootSet, rootFS *btrfs.Filesystem) (*kernel.Planner, error) {
+	fstabMgr := cliconfig.NewFstabManager(cfg)
+	pipeline := &generator.Pipeline{Cfg: cfg, Fstab: fstabMgr, BootSets: bootSets}
+	if cfg.Kernel.VerifyHashes.IsTrue() {
+		hashes, err := kernel.LoadHashStore(cfg.Kernel.HashFile)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Hashes = hashes
+	}
+	return pipeline.NewPlanner(rootFS), nil
+}
+
+// filterStaleSnapshots plans each snapshot and keeps only those stale for at
+// least one boot set, recording the reason and action per kernel.
+// btrfs-mode snapshots carry their own kernel and are never stale.
+func filterStaleSnapshots(snapshots []*SnapshotInfo, planner *kernel.Planner) []*SnapshotInfo {
+	var stale []*SnapshotInfo
+	for _, info := range snapshots {
+		info.Stale = nil
+		for _, plan := range planner.Plan([]*btrfs.Snapshot{info.Snapshot}) {
+			if !plan.IsStale() {
+				continue
+			}
+			info.Stale = append(info.Stale, StaleInfo{
+				Kernel: plan.BootSet.KernelName,
+				Reason: string(plan.Staleness.Reason),
+				Action: string(plan.Staleness.Action),
+			})
+		}
+		if len(info.Stale) > 0 {
+			stale = append(stale, info)
+		}
+	}
+	return stale
+}
+
+// assessSnapshotStaleness plans each snapshot and records the verdict of
+// every plan: its boot mode, why it is fresh or stale, and the action
+// generate takes. btrfs-mode plans are named after the kernel inside the
+// snapshot and reported as self-contained.
+func assessSnapshotStaleness(snapshots []*SnapshotInfo, planner *kernel.Planner) {
+	for _, info := range snapshots {
+		info.Stale = nil
+		for _, plan := range planner.Plan([]*btrfs.Snapshot{info.Snapshot}) {
+			verdict := StaleInfo{Mode: string(plan.Mode)}
+			switch {
+			case plan.Mode == kernel.BootModeBtrfs:
+				verdict.Kernel = "snapshot"
+				if plan.SnapshotKernel != "" {
+					verdict.Kernel = path.Base(plan.SnapshotKernel)
+				}
+				verdict.Reason = selfContainedReason
+			case plan.IsStale():
+				verdict.Kernel = plan.BootSet.KernelName
+				verdict.Reason = string(plan.Staleness.Reason)
+				verdict.Action = string(plan.Staleness.Action)
+			case plan.HasESPCopy():
+				verdict.Kernel = plan.BootSet.KernelName
+				verdict.Reason = "fresh (ESP copy)"
+			default:
+				verdict.Kernel = plan.BootSet.KernelName
+				verdict.Reason = "fresh"
+			}
+			if plan.Fallback {
+				verdict.Kernel += " (fallback)"
+			}
+			info.Stale = append(info.Stale, verdict)
+		}
+	}
 }
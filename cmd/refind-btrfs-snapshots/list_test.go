@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func createMockFilesystem(uuid, device, mountPoint string) *btrfs.Filesystem {
@@ -173,6 +180,12 @@ func TestOutputSnapshotsTable(t *testing.T) {
 			},
 			Filesystem: createMockFilesystem("uuid1", "/dev/sda1", "/"),
 			Size:       "1.2 GiB",
+			Stale:      []StaleInfo{{Kernel: "linux", Reason: "modules_missing", Action: "warn"}},
+			Kernels: []kernel.SnapshotKernel{
+				{Path: "boot/vmlinuz-linux", Version: "6.19.0-2-cachyos", ModulesMatch: true},
+				{Path: "boot/vmlinuz-linux-lts", Version: "6.12.1-1-lts"},
+			},
+			Modules: []string{"6.19.0-2-cachyos"},
 		},
 		{
 			Snapshot: &btrfs.Snapshot{
@@ -188,9 +201,11 @@ func TestOutputSnapshotsTable(t *testing.T) {
 	}
 
 	tests := []struct {
-		name       string
-		showSize   bool
-		showVolume bool
+		name        string
+		showSize    bool
+		showVolume  bool
+		showStale   bool
+		showKernels bool
 	}{
 		{
 			name:       "basic_output",
@@ -212,11 +227,19 @@ func TestOutputSnapshotsTable(t *testing.T) {
 			showSize:   true,
 			showVolume: true,
 		},
+		{
+			name:      "with_stale",
+			showStale: true,
+		},
+		{
+			name:        "with_kernels",
+			showKernels: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := outputSnapshotsTable(snapshots, tt.showSize, tt.showVolume, false)
+			err := outputSnapshotsTable(snapshots, tt.showSize, tt.showVolume, tt.showStale, tt.showKernels, false)
 			assert.NoError(t, err)
 		})
 	}
@@ -228,16 +251,31 @@ func TestOutputSnapshotsJSON(t *testing.T) {
 			Snapshot: &btrfs.Snapshot{
 				Subvolume: &btrfs.Subvolume{
 					ID:   1,
-					Path: "/.snapshots/1/snapshot",
+					Path: `/.snapshots/"quoted"\\1/snapshot`,
 				},
 				SnapshotTime: time.Now(),
+				Description:  `pre: "pacman -Syu" C:\\path`,
 			},
 			Filesystem: createMockFilesystem("uuid1", "/dev/sda1", "/"),
 		},
 	}
 
-	err := outputSnapshotsJSON(snapshots)
-	assert.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, outputSnapshotsJSON(&buf, snapshots))
+	var decoded []struct {
+		Snapshot struct {
+			Path        string `json:"path"`
+			Description string `json:"description"`
+		} `json:"snapshot"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded), "output must be valid JSON")
+	require.Len(t, decoded, 1)
+	assert.Equal(t, `/.snapshots/"quoted"\\1/snapshot`, decoded[0].Snapshot.Path)
+	assert.Equal(t, `pre: "pacman -Syu" C:\\path`, decoded[0].Snapshot.Description)
+
+	buf.Reset()
+	require.NoError(t, outputSnapshotsJSON(&buf, nil))
+	assert.JSONEq(t, "[]", buf.String(), "no snapshots is an empty array")
 }
 
 func TestFilterFilesystems(t *testing.T) {
@@ -361,6 +399,128 @@ func TestSnapshotInfo(t *testing.T) {
 	assert.Equal(t, "1.2 GiB", info.Size)
 }
 
+func TestOutputVolumesCSV(t *testing.T) {
+	filesystems := []*btrfs.Filesystem{
+		{
+			Device:     "/dev/sda1",
+			MountPoint: "/",
+			UUID:       "12345678-1234-1234-1234-123456789abc",
+			Label:      "root, main",
+			Subvolume:  &btrfs.Subvolume{Path: "@"},
+		},
+	}
+
+	var out strings.Builder
+	require.NoError(t, outputVolumesCSV(&out, filesystems))
+	assert.Equal(t, "device,mount_point,uuid,partuuid,label,partlabel,subvolume\n"+
+		"/dev/sda1,/,12345678-1234-1234-1234-123456789abc,,\"root, main\",,@\n", out.String())
+}
+
+func TestOutputSnapshotsCSV(t *testing.T) {
+	older := createMockSnapshot(256, "/.snapshots/1/snapshot", time.Date(2025, 6, 12, 7, 0, 18, 0, time.UTC), true)
+	older.Description = `before "upgrade", pacman`
+	newer := createMockSnapshot(257, "/.snapshots/2/snapshot", time.Date(2025, 6, 13, 7, 0, 18, 0, time.UTC), false)
+	fs := createMockFilesystem("uuid1", "/dev/sda1", "/")
+	snapshots := []*SnapshotInfo{
+		{Snapshot: older, Filesystem: fs, Size: "1.0 GiB", SizeBytes: 1073741824},
+		{Snapshot: newer, Filesystem: fs},
+	}
+
+	var out strings.Builder
+	sortSnapshots(snapshots, "time", false)
+	require.NoError(t, outputSnapshotsCSV(&out, snapshots, false))
+	assert.Equal(t, "time,path,id,read_only,size_bytes,description,volume\n"+
+		"2025-06-13T07:00:18Z,/.snapshots/2/snapshot,257,false,,,uuid1\n"+
+		"2025-06-12T07:00:18Z,/.snapshots/1/snapshot,256,true,1073741824,\"before \"\"upgrade\"\", pacman\",uuid1\n", out.String())
+}
+
+func TestOutputSnapshotsYAML(t *testing.T) {
+	older := createMockSnapshot(256, "/.snapshots/1/snapshot", time.Date(2025, 6, 12, 7, 0, 18, 0, time.UTC), true)
+	older.Description = "pre: pacman -Syu"
+	older.SnapperNum = 1
+	newer := createMockSnapshot(257, "/.snapshots/2/snapshot", time.Date(2025, 6, 13, 7, 0, 18, 0, time.UTC), false)
+	newer.Description = `"quoted" #not a comment`
+	fs := createMockFilesystem("uuid1", "/dev/sda1", "/")
+	snapshots := []*SnapshotInfo{
+		{Snapshot: older, Filesystem: fs, SizeBytes: 1073741824},
+		{Snapshot: newer, Filesystem: fs},
+	}
+
+	var out strings.Builder
+	sortSnapshots(snapshots, "time", false)
+	require.NoError(t, outputSnapshotsYAML(&out, snapshots, false))
+
+	var got []snapshotYAML
+	require.NoError(t, yaml.Unmarshal([]byte(out.String()), &got))
+	require.Len(t, got, 2)
+	assert.Equal(t, uint64(257), got[0].ID, "newest first")
+	assert.Equal(t, `"quoted" #not a comment`, got[0].Description)
+	assert.Equal(t, "pre: pacman -Syu", got[1].Description)
+	assert.Equal(t, "/.snapshots/1/snapshot", got[1].Path)
+	assert.Equal(t, 1, got[1].SnapperNum)
+	assert.True(t, got[1].IsReadOnly)
+	assert.Equal(t, int64(1073741824), got[1].SizeBytes)
+	assert.Equal(t, "uuid1", got[1].Volume)
+	assert.True(t, got[1].Created.Equal(older.SnapshotTime))
+}
+
+func TestSortSnapshots(t *testing.T) {
+	base := time.Date(2025, 6, 12, 7, 0, 0, 0, time.UTC)
+	fs := createMockFilesystem("uuid1", "/dev/sda1", "/")
+	info := func(id uint64, path string, age time.Duration, size int64) *SnapshotInfo {
+		return &SnapshotInfo{Snapshot: createMockSnapshot(id, path, base.Add(-age), true), Filesystem: fs, SizeBytes: size}
+	}
+	ids := func(snapshots []*SnapshotInfo) []uint64 {
+		var ids []uint64
+		for _, s := range snapshots {
+			ids = append(ids, s.Snapshot.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		key     string
+		reverse bool
+		want    []uint64
+	}{
+		{"time", false, []uint64{258, 256, 257, 259}},
+		{"time", true, []uint64{259, 257, 256, 258}},
+		{"id", false, []uint64{256, 257, 258, 259}},
+		{"id", true, []uint64{259, 258, 257, 256}},
+		{"size", false, []uint64{257, 256, 259, 258}},
+		{"size", true, []uint64{258, 256, 259, 257}},
+		{"path", false, []uint64{259, 256, 257, 258}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s_reverse_%t", tt.key, tt.reverse), func(t *testing.T) {
+			// 256 and 259 share a size and stay in discovery order.
+			snapshots := []*SnapshotInfo{
+				info(256, "/.snapshots/b", 2*time.Hour, 500),
+				info(257, "/.snapshots/c", 3*time.Hour, 900),
+				info(258, "/.snapshots/d", time.Hour, 100),
+				info(259, "/.snapshots/a", 4*time.Hour, 500),
+			}
+			sortSnapshots(snapshots, tt.key, tt.reverse)
+			assert.Equal(t, tt.want, ids(snapshots))
+		})
+	}
+}
+
+func TestRunListSnapshots_SortValidation(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().AddFlagSet(listSnapshotsCmd.Flags())
+	t.Cleanup(func() {
+		listSnapshotsCmd.Flags().Set("sort", "time")
+		listSnapshotsCmd.Flags().Set("show-size", "false")
+	})
+
+	require.NoError(t, cmd.Flags().Set("sort", "name"))
+	assert.ErrorContains(t, runListSnapshots(cmd, nil), `invalid --sort "name"`)
+
+	require.NoError(t, cmd.Flags().Set("sort", "size"))
+	assert.ErrorContains(t, runListSnapshots(cmd, nil), "--sort size needs --show-size")
+}
+
 func TestListCommandFlags(t *testing.T) {
 	// Test that flags are properly configured
 	var listCommand *cobra.Command
@@ -406,6 +566,90 @@ func TestListCommandFlags(t *testing.T) {
 	volumeFlag := snapshotsCommand.Flags().Lookup("volume")
 	require.NotNil(t, volumeFlag)
 	assert.Equal(t, "", volumeFlag.DefValue)
+
+	staleOnlyFlag := snapshotsCommand.Flags().Lookup("stale-only")
+	require.NotNil(t, staleOnlyFlag)
+	assert.Equal(t, "false", staleOnlyFlag.DefValue)
+
+	staleFlag := snapshotsCommand.Flags().Lookup("stale")
+	require.NotNil(t, staleFlag)
+	assert.Equal(t, "false", staleFlag.DefValue)
+
+	listKernelsFlag := snapshotsCommand.Flags().Lookup("list-kernels")
+	require.NotNil(t, listKernelsFlag)
+	assert.Equal(t, "false", listKernelsFlag.DefValue)
+
+	yamlFlag := snapshotsCommand.Flags().Lookup("yaml")
+	require.NotNil(t, yamlFlag)
+	assert.Equal(t, "false", yamlFlag.DefValue)
+}
+
+func TestFormatSnapshotKernels(t *testing.T) {
+	kernels := []kernel.SnapshotKernel{
+		{Path: "boot/vmlinuz-linux", Version: "6.19.0-2-cachyos", ModulesMatch: true},
+		{Path: "boot/vmlinuz-linux-lts", Version: "6.12.1-1-lts"},
+		{Path: "boot/EFI/Linux/arch.efi"},
+	}
+
+	assert.Equal(t, "vmlinuz-linux (6.19.0-2-cachyos), vmlinuz-linux-lts (6.12.1-1-lts, no modules), arch.efi (version unknown)", formatSnapshotKernels(kernels))
+	assert.Equal(t, "none", formatSnapshotKernels(nil))
+}
+
+func TestFilterStaleSnapshots(t *testing.T) {
+	fresh := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(fresh, "lib", "modules", "6.1.0-arch1-1"), 0o755))
+	stale := t.TempDir()
+
+	snapshot := func(id uint64, fsPath string) *SnapshotInfo {
+		snap := createMockSnapshot(id, fmt.Sprintf("/.snapshots/%d/snapshot", id), time.Now(), true)
+		snap.FilesystemPath = fsPath
+		return &SnapshotInfo{Snapshot: snap}
+	}
+	snapshots := []*SnapshotInfo{snapshot(1, fresh), snapshot(2, stale)}
+
+	bootSet := makeBootSet("linux", kernel.LayoutSplit)
+	bootSet.Kernel.Inspected = &kernel.InspectedMetadata{Version: "6.1.0-arch1-1"}
+	planner := kernel.NewPlanner(fstab.NewManager(), kernel.NewChecker(kernel.ActionWarn),
+		[]*kernel.BootSet{bootSet}, createMockFilesystem("uuid1", "/dev/sda1", "/"))
+
+	got := filterStaleSnapshots(snapshots, planner)
+	require.Len(t, got, 1)
+	assert.Equal(t, uint64(2), got[0].Snapshot.ID)
+	assert.Equal(t, []StaleInfo{{Kernel: "linux", Reason: string(kernel.ReasonNoModulesDir), Action: string(kernel.ActionWarn)}}, got[0].Stale)
+}
+
+func TestAssessSnapshotStaleness(t *testing.T) {
+	fresh := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(fresh, "lib", "modules", "6.1.0-arch1-1"), 0o755))
+	stale := t.TempDir()
+	selfContained := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(selfContained, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(selfContained, "etc", "fstab"),
+		[]byte("UUID=uuid1 / btrfs subvol=/.snapshots/3/snapshot 0 1\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(selfContained, "boot"), 0o755))
+	for _, name := range []string{"vmlinuz-linux", "initramfs-linux.img"} {
+		require.NoError(t, os.WriteFile(filepath.Join(selfContained, "boot", name), []byte("fake"), 0o644))
+	}
+
+	snapshot := func(id uint64, fsPath string) *SnapshotInfo {
+		snap := createMockSnapshot(id, fmt.Sprintf("/.snapshots/%d/snapshot", id), time.Now(), true)
+		snap.FilesystemPath = fsPath
+		return &SnapshotInfo{Snapshot: snap}
+	}
+	snapshots := []*SnapshotInfo{snapshot(1, fresh), snapshot(2, stale), snapshot(3, selfContained)}
+
+	bootSet := makeBootSet("linux", kernel.LayoutSplit)
+	bootSet.Kernel.Inspected = &kernel.InspectedMetadata{Version: "6.1.0-arch1-1"}
+	planner := kernel.NewPlanner(fstab.NewManager(), kernel.NewChecker(kernel.ActionWarn),
+		[]*kernel.BootSet{bootSet}, createMockFilesystem("uuid1", "/dev/sda1", "/"))
+
+	assessSnapshotStaleness(snapshots, planner)
+	assert.Equal(t, []StaleInfo{{Kernel: "linux", Mode: "esp", Reason: "fresh"}}, snapshots[0].Stale)
+	assert.Equal(t, []StaleInfo{{Kernel: "linux", Mode: "esp", Reason: string(kernel.ReasonNoModulesDir), Action: string(kernel.ActionWarn)}}, snapshots[1].Stale)
+	assert.Equal(t, []StaleInfo{{Kernel: "vmlinuz-linux", Mode: "btrfs", Reason: selfContainedReason}}, snapshots[2].Stale)
+
+	assert.Equal(t, "vmlinuz-linux [btrfs]: self-contained (never stale)", formatStaleInfo(snapshots[2].Stale[0]))
+	assert.Equal(t, "linux [esp]: no_modules_dir (action=warn)", formatStaleInfo(snapshots[1].Stale[0]))
 }
 
 // makeBootSet builds a synthetic BootSet for renderer tests. Layout drives
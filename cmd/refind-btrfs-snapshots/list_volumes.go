@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -27,7 +29,7 @@ func runListVolumes(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	btrfsManager := btrfs.NewManager(cfg.Snapshot.SearchDirectories, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue())
+	btrfsManager := cliconfig.NewBtrfsManager(cfg, nil)
 
 	filesystems, err := btrfsManager.DetectBtrfsFilesystems()
 	if err != nil {
@@ -40,11 +42,18 @@ func runListVolumes(cmd *cobra.Command, args []string) error {
 	}
 
 	jsonOutput, _ := cmd.Flags().GetBool("json")
+	csvOutput, _ := cmd.Flags().GetBool("csv")
 	showAllIds, _ := cmd.Flags().GetBool("show-all-ids")
+	if jsonOutput && csvOutput {
+		return fmt.Errorf("--json and --csv are mutually exclusive")
+	}
 
 	if jsonOutput {
 		return outputVolumesJSON(filesystems)
 	}
+	if csvOutput {
+		return outputVolumesCSV(os.Stdout, filesystems)
+	}
 
 	return outputVolumesTable(filesystems, showAllIds)
 }
@@ -21,6 +21,6 @@ import "os"
 
 func main() {
 	if err := Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Wrap legacy generated entries in section markers",
+	Long: `Wrap the snapshot entries older versions generated into refind_linux.conf
+without section markers in the current ##refind-btrfs-snapshots-start/end
+markers, without scanning btrfs.
+
+Legacy entries are found by their timestamped titles, e.g.
+"Boot (2024-01-15_12-30-00)", and the old "# Snapshot entries generated by
+refind-btrfs-snapshots" comment is dropped. Files that already have markers
+are left alone, as is the managed config, which is always written whole.
+Changes are shown as a diff before being applied, so a hand-written line that
+happens to match can be spotted before it is moved into the section.
+
+Once migrated, generate replaces the entries between the markers instead of
+relying on the title heuristic.`,
+	RunE: runMigrate,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().String("config-path", "", "Path to rEFInd main config file")
+	migrateCmd.Flags().StringP("esp-path", "e", "", "Path to ESP mount point")
+	migrateCmd.Flags().Bool("dry-run", false, "Show what would be done without making changes")
+	migrateCmd.Flags().BoolP("yes", "y", false, "Automatically approve all changes without prompting")
+	migrateCmd.Flags().Bool("backup-configs", false, "Save a timestamped .bak copy of each file before overwriting it")
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	espPath, releaseESP, err := detectESPPath(cfg, cfg.DryRun.IsTrue())
+	if err != nil {
+		return err
+	}
+	defer releaseESP()
+
+	r := runner.New(cfg.DryRun.IsTrue())
+	pipeline := &generator.Pipeline{Cfg: cfg, Runner: r, ESPPath: espPath}
+	patch, err := pipeline.BuildMigratePatch()
+	if err != nil {
+		return err
+	}
+
+	if applied, err := applyPatch(cfg, patch, r, true); err != nil || !applied {
+		return err
+	}
+	if len(patch.Files) > 0 && !r.IsDryRun() {
+		log.Info().Int("files", len(patch.Files)).Msg("Migrated legacy generated entries to marker sections")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old writable snapshot copies",
+	Long: `Delete the writable snapshot copies made with snapshot.writable_method "copy".
+
+Lists every rwsnap_* subvolume in snapshot.destination_dir with its age and
+size, and deletes those beyond the newest --keep, or created longer ago
+than --older-than. With both, a copy matching either is deleted. Without
+either, the copies are only listed.
+
+Copies the booted root or generate's boot entries use are never deleted,
+though they count towards --keep. Each copy is checked to be a real
+subvolume before it is deleted with btrfs subvolume delete. generate prunes
+copies beyond snapshot.selection_count itself; this runs the same cleanup on
+its own schedule.`,
+	RunE: runPrune,
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().Int("keep", -1, "Number of newest writable copies to keep (-1 = don't prune by count)")
+	pruneCmd.Flags().Duration("older-than", 0, "Delete writable copies created longer ago than this (e.g. 720h; 0 = don't prune by age)")
+	pruneCmd.Flags().Bool("dry-run", false, "Show what would be done without making changes")
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	keep, _ := cmd.Flags().GetInt("keep")
+	if keep < -1 {
+		return fmt.Errorf("--keep must be 0 or more, got %d", keep)
+	}
+	olderThan, _ := cmd.Flags().GetDuration("older-than")
+	if olderThan < 0 {
+		return fmt.Errorf("--older-than must not be negative, got %s", olderThan)
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if cfg.Snapshot.WritableMethod != "copy" {
+		log.Info().Str("writable_method", cfg.Snapshot.WritableMethod).Msg("snapshot.writable_method isn't copy; listing any copies left from before")
+	}
+
+	btrfsManager := cliconfig.NewBtrfsManager(cfg, nil)
+	destDir := cfg.Snapshot.DestinationDir
+	copies, err := btrfsManager.ListWritableCopies(destDir)
+	if err != nil {
+		return err
+	}
+	if len(copies) == 0 {
+		fmt.Printf("No writable snapshot copies in %s\n", destDir)
+		return nil
+	}
+
+	inUse, err := subvolumesInUse(cfg, btrfsManager)
+	if err != nil {
+		return fmt.Errorf("failed to find the writable copies in use: %w", err)
+	}
+	now := time.Now()
+	prune := btrfs.SelectPrunableCopies(copies, keep, olderThan, now, inUse)
+	if err := outputWritableCopies(copies, prune, inUse, btrfsManager, now, cfg.Display.LocalTime.IsTrue()); err != nil {
+		return err
+	}
+
+	if keep < 0 && olderThan == 0 {
+		log.Info().Msg("Nothing to prune without --keep or --older-than")
+		return nil
+	}
+	if len(prune) == 0 {
+		log.Info().Int("copies", len(copies)).Msg("No writable snapshot copies to prune")
+		return nil
+	}
+
+	r := runner.New(cfg.DryRun.IsTrue())
+	failed := 0
+	for _, c := range prune {
+		if err := btrfsManager.DeleteWritableCopy(c, r); err != nil {
+			log.Warn().Err(err).Str("path", c.Path).Msg("Failed to remove writable snapshot copy")
+			failed++
+		}
+	}
+	if r.IsDryRun() {
+		log.Info().Int("copies", len(prune)).Msg("[DRY RUN] Would prune writable snapshot copies")
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d writable snapshot copies", failed, len(prune))
+	}
+	log.Info().Int("copies", len(prune)).Int("kept", len(copies)-len(prune)).Msg("Pruned writable snapshot copies")
+	return nil
+}
+
+// subvolumesInUse returns the subvolume IDs of the booted root and of the
+// writable copies generate's boot entries boot, which are never pruned.
+// Discovery runs without the ESP and changes nothing, so no snapshot is
+// planned stale and the copy of every selected snapshot counts as in use.
+func subvolumesInUse(cfg *config.Config, btrfsManager *btrfs.Manager) (map[uint64]bool, error) {
+	fstabMgr := cliconfig.NewFstabManager(cfg)
+	pipeline := &generator.Pipeline{
+		Cfg:    cfg,
+		Btrfs:  btrfsManager,
+		Fstab:  fstabMgr,
+		Runner: runner.New(true),
+	}
+	return pipeline.SubvolumesInUse()
+}
+
+// outputWritableCopies prints copies, newest first, with their age, size
+// and whether they are pruned or in use.
+func outputWritableCopies(copies, prune []*btrfs.WritableCopy, inUse map[uint64]bool, btrfsManager *btrfs.Manager, now time.Time, useLocalTime bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	timeHeader := "CREATED (UTC)"
+	if useLocalTime {
+		timeHeader = "CREATED (LOCAL)"
+	}
+	fmt.Fprintln(w, strings.Join([]string{timeHeader, "AGE", "SIZE", "ACTION", "PATH"}, "\t"))
+	fmt.Fprintln(w, strings.Join([]string{"─────────────", "───", "────", "──────", "────"}, "\t"))
+
+	for _, c := range slices.Backward(copies) {
+		size, _, err := btrfsManager.GetSnapshotSizeWithoutProgress(c.Path, new(int64))
+		if err != nil {
+			size = "unknown"
+		}
+		action := "keep"
+		switch {
+		case slices.Contains(prune, c):
+			action = "delete"
+		case c.Subvolume != nil && inUse[c.Subvolume.ID]:
+			action = "in use"
+		}
+		fmt.Fprintln(w, strings.Join([]string{
+			btrfs.FormatSnapshotTimeForDisplay(c.Created, useLocalTime),
+			formatAge(now.Sub(c.Created)),
+			size,
+			action,
+			c.Path,
+		}, "\t"))
+	}
+	return nil
+}
+
+// formatAge renders d in days and hours, or minutes under an hour.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		days := int(d.Hours()) / 24
+		return fmt.Sprintf("%dd%dh", days, int(d.Hours())%24)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneCommandFlags(t *testing.T) {
+	flagTests := []struct {
+		name     string
+		defValue string
+	}{
+		{"keep", "-1"},
+		{"older-than", "0s"},
+		{"dry-run", "false"},
+	}
+	for _, test := range flagTests {
+		flag := pruneCmd.Flags().Lookup(test.name)
+		require.NotNil(t, flag, "flag %s should exist", test.name)
+		assert.Equal(t, test.defValue, flag.DefValue, "flag %s default", test.name)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	assert.Equal(t, "42m", formatAge(42*time.Minute))
+	assert.Equal(t, "5h", formatAge(5*time.Hour+10*time.Minute))
+	assert.Equal(t, "3d4h", formatAge(76*time.Hour))
+}
@@ -18,13 +18,22 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/progress"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/version"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var cfgFile string
@@ -47,25 +56,122 @@ ESP detection, snapshot discovery, and configuration management.`,
 			return err
 		}
 		loadedCfg = cfg
+		if off, _ := cmd.Flags().GetBool("no-color"); off {
+			noColor = true
+		}
+		diff.SetColor(!noColor)
+		switch mode, _ := cmd.Flags().GetString("progress"); mode {
+		case "":
+			if cmd.Flags().Changed("progress-file") {
+				return fmt.Errorf("--progress-file needs --progress")
+			}
+		case "json":
+			w, err := progressOutput(cmd)
+			if err != nil {
+				return err
+			}
+			progress.SetOutput(w)
+		default:
+			return fmt.Errorf("invalid --progress %q: must be json", mode)
+		}
+		log.Logger = log.Output(consoleWriter())
 		initLogging(cfg.LogLevel)
 		return nil
 	},
 }
 
+// Exit codes returned by main. 2 is left to its conventional meaning of
+// command-line misuse.
+const (
+	exitError             = 1
+	exitNoBtrfsRoot       = 3
+	exitESPNotMounted     = 4
+	exitNoBootableEntries = 5
+	exitOutOfDate         = 6
+	exitESPNotFound       = 7
+)
+
 func Execute() error {
+	defer func() {
+		if progressFile != nil {
+			progress.SetOutput(nil)
+			progressFile.Close()
+			progressFile = nil
+		}
+	}()
 	return rootCmd.Execute()
 }
 
+// exitCode maps an error returned by Execute to the process exit code, so
+// scripts can tell the common environment failures apart.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, btrfs.ErrNoBtrfsRoot):
+		return exitNoBtrfsRoot
+	case errors.Is(err, esp.ErrESPNotMounted):
+		return exitESPNotMounted
+	case errors.Is(err, esp.ErrESPNotFound):
+		return exitESPNotFound
+	case errors.Is(err, generator.ErrNoBootableEntries):
+		return exitNoBootableEntries
+	case errors.Is(err, errOutOfDate):
+		return exitOutOfDate
+	default:
+		return exitError
+	}
+}
+
 func init() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        os.Stderr,
-		TimeFormat: "15:04:05",
-		NoColor:    false,
-	})
+	log.Logger = log.Output(consoleWriter())
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is /etc/refind-btrfs-snapshots.yaml)")
 	rootCmd.PersistentFlags().String("log-level", "info", "log level (trace, debug, info, warn, error, fatal, panic)")
 	rootCmd.PersistentFlags().Bool("local-time", false, "Display times in local time instead of UTC")
+	rootCmd.PersistentFlags().Bool("no-color", false, "Disable colors and progress spinners (also set by the NO_COLOR environment variable)")
+	rootCmd.PersistentFlags().String("progress", "", "Write progress events in this format instead of showing spinners (json: one JSON object per line)")
+	rootCmd.PersistentFlags().String("progress-file", "", "Write --progress events to this file, such as a FIFO or /dev/fd/3, instead of stderr")
+}
+
+// noColor disables ANSI escapes everywhere: log colors, diff colors and
+// progress spinners. Set by NO_COLOR or --no-color.
+var noColor = os.Getenv("NO_COLOR") != ""
+
+// consoleWriter is the human-readable stderr log writer every command uses.
+// Colors are left out with noColor or when stderr isn't a terminal.
+func consoleWriter() zerolog.ConsoleWriter {
+	return zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: "15:04:05",
+		NoColor:    noColor || !term.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// progressFile is the --progress-file opened for the current command,
+// closed by Execute once the command has finished.
+var progressFile *os.File
+
+// progressOutput returns where --progress events are written: stderr, with
+// the log, or the --progress-file to keep them apart from it.
+func progressOutput(cmd *cobra.Command) (io.Writer, error) {
+	path, _ := cmd.Flags().GetString("progress-file")
+	if path == "" {
+		return os.Stderr, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --progress-file: %w", err)
+	}
+	progressFile = f
+	return f, nil
+}
+
+// showProgress reports whether progress spinners, which redraw their line
+// with ANSI escapes, may be written to stdout. --progress json replaces
+// them.
+func showProgress() bool {
+	return !noColor && !progress.Enabled() && term.IsTerminal(int(os.Stdout.Fd()))
 }
 
 func initLogging(level string) {
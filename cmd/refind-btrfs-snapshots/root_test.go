@@ -1,9 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -62,4 +67,34 @@ func TestRootCmdConfiguration(t *testing.T) {
 	localTimeFlag := rootCmd.PersistentFlags().Lookup("local-time")
 	require.NotNil(t, localTimeFlag)
 	assert.Equal(t, "false", localTimeFlag.DefValue)
+
+	noColorFlag := rootCmd.PersistentFlags().Lookup("no-color")
+	require.NotNil(t, noColorFlag)
+	assert.Equal(t, "false", noColorFlag.DefValue)
+
+	progressFlag := rootCmd.PersistentFlags().Lookup("progress")
+	require.NotNil(t, progressFlag)
+	assert.Equal(t, "", progressFlag.DefValue)
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"generic", errors.New("boom"), exitError},
+		{"no_btrfs_root", fmt.Errorf("failed to get root filesystem: %w", btrfs.ErrNoBtrfsRoot), exitNoBtrfsRoot},
+		{"esp_not_mounted", fmt.Errorf("ESP with UUID abcd: %w", esp.ErrESPNotMounted), exitESPNotMounted},
+		{"no_bootable_entries", generator.ErrNoBootableEntries, exitNoBootableEntries},
+		{"esp_not_found", fmt.Errorf("failed to detect ESP: %w", esp.ErrESPNotFound), exitESPNotFound},
+		{"out_of_date", fmt.Errorf("2 files would change: %w", errOutOfDate), exitOutOfDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.err))
+		})
+	}
 }
@@ -17,7 +17,6 @@ import (
 	"text/tabwriter"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
-	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -59,12 +58,15 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	}
 
 	rootFS, _ := btrfsManager.GetRootFilesystem()
-	fstabMgr := fstab.NewManager()
 	staleAction := kernel.ParseStaleAction(cfg.Kernel.StaleSnapshotAction)
 	checker := kernel.NewChecker(staleAction)
+	checker.SetUsePackageDB(cfg.Kernel.UsePackageDB.IsTrue())
 	var planner *kernel.Planner
 	if rootFS != nil {
-		planner = kernel.NewPlanner(fstabMgr, checker, bootSets, rootFS)
+		var err error
+		if planner, err = newPipelinePlanner(cfg, bootSets, rootFS); err != nil {
+			return err
+		}
 	}
 
 	matrix := buildCompatibilityMatrix(snapshots, bootSets, planner, checker)
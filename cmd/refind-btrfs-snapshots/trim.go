@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var trimCmd = &cobra.Command{
+	Use:   "trim",
+	Short: "Trim generated snapshot entries to the newest N",
+	Long: `Trim the snapshot entries already generated into refind_linux.conf files and
+the managed config down to the N newest snapshots, without scanning btrfs.
+
+Only the generated sections are touched: the lines between the
+##refind-btrfs-snapshots markers in refind_linux.conf and the submenuentry
+blocks in the managed config. Snapshots are ranked by the time in their
+titles, read using advanced.naming.menu_format; entries whose time can't be
+read are kept. Changes are shown as a diff before being applied.
+
+The next generate run writes entries for every selected snapshot again;
+set snapshot.selection_count to keep the menu trimmed.`,
+	RunE: runTrim,
+}
+
+func init() {
+	rootCmd.AddCommand(trimCmd)
+
+	trimCmd.Flags().Int("trim-to", 0, "Number of newest snapshots to keep entries for (required)")
+	trimCmd.Flags().String("config-path", "", "Path to rEFInd main config file")
+	trimCmd.Flags().StringP("esp-path", "e", "", "Path to ESP mount point")
+	trimCmd.Flags().Bool("dry-run", false, "Show what would be done without making changes")
+	trimCmd.Flags().BoolP("yes", "y", false, "Automatically approve all changes without prompting")
+	trimCmd.Flags().Bool("backup-configs", false, "Save a timestamped .bak copy of each file before overwriting it")
+	_ = trimCmd.MarkFlagRequired("trim-to")
+}
+
+func runTrim(cmd *cobra.Command, args []string) error {
+	keep, _ := cmd.Flags().GetInt("trim-to")
+	if keep < 1 {
+		return fmt.Errorf("--trim-to must be at least 1, got %d", keep)
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	espPath, releaseESP, err := detectESPPath(cfg, cfg.DryRun.IsTrue())
+	if err != nil {
+		return err
+	}
+	defer releaseESP()
+
+	r := runner.New(cfg.DryRun.IsTrue())
+	pipeline := &generator.Pipeline{Cfg: cfg, Runner: r, ESPPath: espPath}
+	patch, err := pipeline.BuildTrimPatch(keep)
+	if err != nil {
+		return err
+	}
+
+	if applied, err := applyPatch(cfg, patch, r, true); err != nil || !applied {
+		return err
+	}
+	if len(patch.Files) > 0 && !r.IsDryRun() {
+		log.Info().Int("keep", keep).Int("files", len(patch.Files)).Msg("Trimmed generated snapshot entries")
+	}
+	return nil
+}
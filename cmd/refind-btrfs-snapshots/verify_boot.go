@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/cliconfig"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var verifyBootCmd = &cobra.Command{
+	Use:   "verify-boot",
+	Short: "Check that snapshot kernels load, using kexec",
+	Long: `Check that the kernel, initramfs and command line of each snapshot's boot
+entry load, without booting them.
+
+Snapshots are discovered and planned as generate would, but left unmodified.
+For each boot plan the kernel and initrds the generated entry loads are
+located on the running system and handed to "kexec --load" with the entry's
+command line, then unloaded again. A missing file or a kernel kexec refuses
+is reported as a failure and the command exits non-zero. It refuses to run
+while a kernel is already loaded with kexec, which it would discard.
+
+This catches a corrupt or truncated kernel or initramfs and a command line
+the kernel can't take, not problems that only show once the snapshot boots.
+Needs root and kexec-tools; kernels locked down under Secure Boot may refuse
+unsigned kernels. With --dry-run the kexec commands are logged instead.`,
+	RunE: runVerifyBoot,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyBootCmd)
+
+	verifyBootCmd.Flags().String("config-path", "", "Path to rEFInd main config file")
+	verifyBootCmd.Flags().StringP("esp-path", "e", "", "Path to ESP mount point")
+	verifyBootCmd.Flags().IntP("count", "n", 0, "Number of snapshots to include (0 = all snapshots)")
+	verifyBootCmd.Flags().Bool("dry-run", false, "Log the kexec commands instead of running them")
+	verifyBootCmd.Flags().Bool("all-volumes", false, "Verify snapshots of every btrfs volume with a bootable rEFInd entry, not just the running root")
+	verifyBootCmd.Flags().StringArray("snapshot", nil, "Only verify the snapshot at this subvolume path, e.g. /.snapshots/42/snapshot (repeatable)")
+}
+
+func runVerifyBoot(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := checkRootPrivileges(); err != nil {
+		log.Warn().Err(err).Msg("Not running as root - kexec will fail")
+	}
+
+	espPath, releaseESP, err := detectESPPath(cfg, true)
+	if err != nil {
+		return err
+	}
+	defer releaseESP()
+	kernelScanner := buildKernelScanner(espPath, cfg.Kernel.BootImagePatterns)
+	var bootSets []*kernel.BootSet
+	if allImages := scanBootImages(espPath, kernelScanner); len(allImages) > 0 {
+		kernelScanner.InspectAll(allImages)
+		bootSets = kernelScanner.BuildBootSets(allImages)
+	}
+	bootSets, _ = generator.FilterKernels(bootSets, cfg.Kernel.Filter)
+
+	btrfsManager := cliconfig.NewBtrfsManager(cfg, nil)
+	fstabMgr := cliconfig.NewFstabManager(cfg)
+
+	// Discovery plans the snapshots as they are: nothing is made writable.
+	discoverCfg := *cfg
+	discoverCfg.Behavior.BootReadOnly = config.Truthy(true)
+	pipeline := &generator.Pipeline{
+		Cfg:           &discoverCfg,
+		Btrfs:         btrfsManager,
+		Fstab:         fstabMgr,
+		Runner:        runner.New(true),
+		ESPPath:       espPath,
+		KernelScanner: kernelScanner,
+		BootSets:      bootSets,
+	}
+	discover := pipeline.Discover
+	if allVolumes, _ := cmd.Flags().GetBool("all-volumes"); allVolumes {
+		discover = pipeline.DiscoverAll
+	}
+	plan, err := discover()
+	if err != nil {
+		return err
+	}
+
+	// The command lines are those generate writes with the real config.
+	pipeline.Cfg = cfg
+	checks, err := pipeline.BootChecks(plan)
+	if err != nil {
+		return err
+	}
+	if only, _ := cmd.Flags().GetStringArray("snapshot"); len(only) > 0 {
+		checks = slices.DeleteFunc(checks, func(c generator.BootCheck) bool {
+			return !slices.ContainsFunc(only, func(path string) bool {
+				return strings.TrimPrefix(path, "/") == strings.TrimPrefix(c.Snapshot, "/")
+			})
+		})
+	}
+	if len(checks) == 0 {
+		return fmt.Errorf("no snapshot boots to verify")
+	}
+
+	r := runner.New(cfg.DryRun.IsTrue())
+	if !r.IsDryRun() {
+		loaded, err := generator.KexecLoaded()
+		if err != nil {
+			return err
+		}
+		if loaded {
+			return fmt.Errorf("a kexec kernel is already loaded and would be unloaded; run kexec --unload first")
+		}
+	}
+	failed := 0
+	for _, check := range checks {
+		if err := generator.VerifyBoot(r, check); err != nil {
+			failed++
+			fmt.Fprintf(cmd.OutOrStdout(), "FAIL %s (%s): %v\n", check.Snapshot, check.Kernel, err)
+			continue
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "ok   %s (%s)\n", check.Snapshot, check.Kernel)
+	}
+	if failed > 0 {
+		// A failed check is an expected result, not a usage error.
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d snapshot boots failed to load", failed, len(checks))
+	}
+	if !r.IsDryRun() {
+		log.Info().Int("checked", len(checks)).Msg("All snapshot kernels loaded")
+	}
+	return nil
+}
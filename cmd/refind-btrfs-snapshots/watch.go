@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Regenerate boot entries whenever snapshots are created or deleted",
+	Long: `Watch the snapshot search directories and run generate whenever an entry is
+created, removed or renamed in one of them, as an alternative to a snapper
+hook or the systemd path unit.
+
+Changes are debounced: generation starts once the directories have been
+quiet for --debounce, and never sooner than --min-interval after the
+previous run. Only the search directories themselves are watched, which is
+where snapper, Timeshift and most tools create each snapshot's directory.
+
+Changes are applied without prompting, as with generate --yes; use
+--dry-run to only log what each run would change. Runs until interrupted.`,
+	RunE: runWatch,
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().StringP("esp-path", "e", "", "Path to ESP mount point")
+	watchCmd.Flags().String("config-path", "", "Path to rEFInd main config file")
+	watchCmd.Flags().Bool("dry-run", false, "Log what each run would change without making changes")
+	watchCmd.Flags().Duration("debounce", 5*time.Second, "Wait for this long without further changes before regenerating")
+	watchCmd.Flags().Duration("min-interval", 30*time.Second, "Leave at least this long between the start of two runs")
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	// Nobody is there to answer a prompt.
+	cfg.AutoApprove = config.Truthy(true)
+
+	debounce, _ := cmd.Flags().GetDuration("debounce")
+	minInterval, _ := cmd.Flags().GetDuration("min-interval")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	defer watcher.Close()
+
+	var watched []string
+	for _, dir := range cfg.Snapshot.SearchDirectories {
+		if err := watcher.Add(dir); err != nil {
+			log.Warn().Err(err).Str("dir", dir).Msg("Cannot watch snapshot search directory")
+			continue
+		}
+		watched = append(watched, dir)
+	}
+	if len(watched) == 0 {
+		return fmt.Errorf("none of the snapshot search directories %v can be watched", cfg.Snapshot.SearchDirectories)
+	}
+	log.Info().
+		Strs("dirs", watched).
+		Dur("debounce", debounce).
+		Dur("min_interval", minInterval).
+		Bool("dry_run", cfg.DryRun.IsTrue()).
+		Msg("Watching for snapshot changes")
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	pending := false
+	var lastRun time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Stopped watching for snapshot changes")
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			log.Debug().Str("path", event.Name).Str("op", event.Op.String()).Msg("Snapshot directory changed")
+			timer.Reset(watchDelay(lastRun, time.Now(), debounce, minInterval))
+			pending = true
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Msg("Error watching snapshot directories")
+
+		case <-timer.C:
+			if !pending {
+				continue
+			}
+			pending = false
+			lastRun = time.Now()
+			log.Info().Msg("Snapshots changed, regenerating")
+			if err := generateWithConfig(cmd, cfg, nil); err != nil {
+				// A failed run mustn't end the watch; the next change retries.
+				log.Error().Err(err).Msg("Generation failed")
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// watchDelay returns how long to wait after a change seen at now before
+// regenerating: debounce, stretched so the run starts no sooner than
+// minInterval after lastRun.
+func watchDelay(lastRun, now time.Time, debounce, minInterval time.Duration) time.Duration {
+	delay := debounce
+	if lastRun.IsZero() {
+		return delay
+	}
+	if untilAllowed := lastRun.Add(minInterval).Sub(now); untilAllowed > delay {
+		delay = untilAllowed
+	}
+	return delay
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchDelay(t *testing.T) {
+	now := time.Date(2025, 6, 12, 7, 0, 0, 0, time.UTC)
+	debounce, minInterval := 5*time.Second, 30*time.Second
+
+	tests := []struct {
+		name    string
+		lastRun time.Time
+		want    time.Duration
+	}{
+		{"first_run", time.Time{}, debounce},
+		{"long_after_last_run", now.Add(-time.Hour), debounce},
+		{"soon_after_last_run", now.Add(-10 * time.Second), 20 * time.Second},
+		{"interval_nearly_over", now.Add(-28 * time.Second), debounce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, watchDelay(tt.lastRun, now, debounce, minInterval))
+		})
+	}
+}
@@ -53,14 +53,16 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	espPath, err := discovery.ResolveESP(discovery.ESPOptions{
+	espPath, releaseESP, err := discovery.ResolveESP(discovery.ESPOptions{
 		UUID:       cfg.ESP.UUID,
 		AutoDetect: cfg.ESP.AutoDetect.IsTrue(),
 		MountPoint: cfg.ESP.MountPoint,
+		ReadOnly:   cfg.DryRun.IsTrue(),
 	})
 	if err != nil {
 		return fmt.Errorf("resolve ESP: %w", err)
 	}
+	defer releaseESP()
 
 	bootSets, _ := discovery.DetectBootSets(
 		discovery.ESPOptions{UUID: cfg.ESP.UUID, AutoDetect: cfg.ESP.AutoDetect.IsTrue(), MountPoint: cfg.ESP.MountPoint},
@@ -73,7 +75,7 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	btrfsMgr := btrfs.NewManager(cfg.Snapshot.SearchDirectories, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue())
+	btrfsMgr := cliconfig.NewBtrfsManager(cfg, nil)
 	rootFS, err := btrfsMgr.GetRootFilesystem()
 	if err != nil {
 		return fmt.Errorf("locate root btrfs filesystem: %w", err)
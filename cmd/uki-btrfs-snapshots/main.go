@@ -13,9 +13,11 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var rootCmd = &cobra.Command{
@@ -35,6 +37,7 @@ refind-btrfs-snapshots or bls-btrfs-snapshots instead.`,
 func init() {
 	rootCmd.PersistentFlags().StringP("config", "c", "", "Path to config file (default: /etc/uki-btrfs-snapshots.yaml)")
 	rootCmd.PersistentFlags().String("log-level", "", "Log verbosity: trace, debug, info, warn, error")
+	rootCmd.PersistentFlags().Bool("no-color", false, "Disable colors (also set by the NO_COLOR environment variable)")
 }
 
 func setupLogging(cmd *cobra.Command, args []string) error {
@@ -47,7 +50,14 @@ func setupLogging(cmd *cobra.Command, args []string) error {
 		}
 		zerolog.SetGlobalLevel(parsed)
 	}
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"})
+	noColor, _ := cmd.Flags().GetBool("no-color")
+	noColor = noColor || os.Getenv("NO_COLOR") != ""
+	diff.SetColor(!noColor)
+	log.Logger = log.Output(zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: "15:04:05",
+		NoColor:    noColor || !term.IsTerminal(int(os.Stderr.Fd())),
+	})
 	return nil
 }
 
@@ -0,0 +1,48 @@
+// Package backend is the bootloader generate writes snapshot boot entries
+// for. Discovery and the snapshots' own fstab and crypttab updates are
+// shared (generator.Pipeline); a Backend adds its bootloader's entries for
+// the discovered plan to the same patch.
+package backend
+
+import (
+	"fmt"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+)
+
+// Names of the backends New knows (generate --backend).
+const (
+	Refind = "refind"
+	GRUB   = "grub"
+)
+
+// Backend adds the boot entries for a discovered plan to patch, and the
+// files it changes to summary. Like bootloader.Generator it must not touch
+// disk itself: the patch is applied through the runner after confirmation.
+type Backend interface {
+	Name() string
+	AddEntries(p *generator.Pipeline, plan *generator.Plan, patch *diff.PatchDiff, summary *generator.OperationSummary) error
+}
+
+// New returns the backend called name.
+func New(name string) (Backend, error) {
+	switch name {
+	case Refind:
+		return refindBackend{}, nil
+	case GRUB:
+		return grubBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q (must be %s or %s)", name, Refind, GRUB)
+	}
+}
+
+// refindBackend writes refind_linux.conf entries and the managed include
+// file from the live rEFInd config.
+type refindBackend struct{}
+
+func (refindBackend) Name() string { return Refind }
+
+func (refindBackend) AddEntries(p *generator.Pipeline, plan *generator.Plan, patch *diff.PatchDiff, summary *generator.OperationSummary) error {
+	return p.AddRefindEntries(plan, patch, summary)
+}
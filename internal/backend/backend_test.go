@@ -0,0 +1,19 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{Refind, GRUB} {
+		b, err := New(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, b.Name())
+	}
+
+	_, err := New("systemd-boot")
+	assert.EqualError(t, err, `unknown backend "systemd-boot" (must be refind or grub)`)
+}
@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"path"
+	"slices"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bootloader"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/generator"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/grub"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/rs/zerolog/log"
+)
+
+// grubBackend writes the GRUB menu fragment at grub.config_path. There is
+// no rEFInd config to take source entries from, so one is synthesised per
+// kernel: the ESP's boot sets, then any kernel only btrfs-mode plans boot,
+// all with the cmdline from /etc/kernel/cmdline or /proc/cmdline.
+type grubBackend struct{}
+
+func (grubBackend) Name() string { return GRUB }
+
+func (grubBackend) AddEntries(p *generator.Pipeline, plan *generator.Plan, patch *diff.PatchDiff, summary *generator.OperationSummary) error {
+	cmdline, source, err := bootloader.ReadKernelCmdline()
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("source", source).Msg("Using kernel cmdline for GRUB entries")
+
+	out, err := grub.NewGenerator().Generate(bootloader.Input{
+		Cfg:                p.Cfg,
+		ESPPath:            p.ESPPath,
+		RootFS:             plan.RootFS,
+		ProcessedSnapshots: plan.ProcessedSnapshots,
+		BootPlans:          plan.BootPlans,
+		SourceEntries:      grubSourceEntries(p.BootSets, plan.BootPlans, cmdline),
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range out.Diffs {
+		patch.AddFile(d)
+	}
+	summary.UpdatedConfigs = append(summary.UpdatedConfigs, out.UpdatedConfigs...)
+	return nil
+}
+
+// grubSourceEntries returns a source entry for each non-UKI boot set, and
+// one for each kernel, by file name, booted only by btrfs-mode plans, as
+// when /boot is on the btrfs root. The latter's loader is only matched by
+// name against the plans' in-snapshot kernels.
+func grubSourceEntries(bootSets []*kernel.BootSet, plans []*kernel.BootPlan, cmdline string) []bootloader.SourceEntry {
+	var sources []bootloader.SourceEntry
+	var names []string
+	for _, bs := range bootSets {
+		if bs.Layout == kernel.LayoutUKI || bs.Kernel == nil {
+			continue
+		}
+		se := bootloader.SourceEntry{
+			Title:   bs.DisplayName(),
+			Loader:  bs.Kernel.Path,
+			Options: cmdline,
+		}
+		for _, mc := range bs.Microcode {
+			se.Initrd = append(se.Initrd, mc.Path)
+		}
+		if bs.Initramfs != nil {
+			se.Initrd = append(se.Initrd, bs.Initramfs.Path)
+		}
+		sources = append(sources, se)
+		names = append(names, path.Base(bs.Kernel.Path))
+	}
+	for _, bp := range plans {
+		if bp.Mode != kernel.BootModeBtrfs || bp.SnapshotKernel == "" {
+			continue
+		}
+		name := path.Base(bp.SnapshotKernel)
+		if slices.Contains(names, name) {
+			continue
+		}
+		sources = append(sources, bootloader.SourceEntry{
+			Title:   name,
+			Loader:  "/boot/" + name,
+			Options: cmdline,
+		})
+		names = append(names, name)
+	}
+	return sources
+}
@@ -0,0 +1,37 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bootloader"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGRUBSourceEntries(t *testing.T) {
+	bootSets := []*kernel.BootSet{
+		{
+			KernelName: "linux",
+			Layout:     kernel.LayoutSplit,
+			Kernel:     &kernel.BootImage{Path: "/vmlinuz-linux"},
+			Initramfs:  &kernel.BootImage{Path: "/initramfs-linux.img"},
+			Microcode:  []*kernel.BootImage{{Path: "/intel-ucode.img"}},
+		},
+		{KernelName: "linux", Layout: kernel.LayoutUKI, UKI: &kernel.BootImage{Path: "/EFI/Linux/arch.efi"}},
+	}
+	plans := []*kernel.BootPlan{
+		{Mode: kernel.BootModeBtrfs, SnapshotKernel: "/@/.snapshots/1/snapshot/boot/vmlinuz-linux"},
+		{Mode: kernel.BootModeBtrfs, SnapshotKernel: "/@/.snapshots/1/snapshot/boot/vmlinuz-linux-lts"},
+		{Mode: kernel.BootModeBtrfs, SnapshotKernel: "/@/.snapshots/2/snapshot/boot/vmlinuz-linux-lts"},
+	}
+
+	assert.Equal(t, []bootloader.SourceEntry{
+		{
+			Title:   "Linux",
+			Loader:  "/vmlinuz-linux",
+			Initrd:  []string{"/intel-ucode.img", "/initramfs-linux.img"},
+			Options: "root=UUID=abc rw",
+		},
+		{Title: "vmlinuz-linux-lts", Loader: "/boot/vmlinuz-linux-lts", Options: "root=UUID=abc rw"},
+	}, grubSourceEntries(bootSets, plans, "root=UUID=abc rw"))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/params"
 	"github.com/rs/zerolog/log"
 )
 
@@ -90,7 +91,8 @@ func (g *generator) buildExpected(input bootloader.Input, entriesDir, prefix str
 				continue
 			}
 
-			entry := newEntryFromSource(snap, src, snapshotDisplayName(snap, input.Cfg.Advanced.Naming.MenuFormat, input.Cfg.Display.LocalTime.IsTrue()))
+			readOnly := input.Cfg.Behavior.BootReadOnly.IsTrue() || input.Cfg.Behavior.MatchWritability.IsTrue() && snap.IsReadOnly
+			entry := newEntryFromSource(snap, src, bootloader.SnapshotDisplayName(snap, input.Cfg.Advanced.Naming.MenuFormat, input.Cfg.Display.LocalTime.IsTrue()), readOnly, input.Cfg.Behavior.MatchWritability.IsTrue())
 			if entry == nil {
 				continue
 			}
@@ -149,12 +151,21 @@ func eligibleSnapshots(plans []*kernel.BootPlan) []*btrfs.Snapshot {
 }
 
 // newEntryFromSource builds a BLS Entry from a source entry's loader/initrd
-// plus the snapshot-targeted cmdline.
-func newEntryFromSource(snap *btrfs.Snapshot, src bootloader.SourceEntry, displayName string) *Entry {
+// plus the snapshot-targeted cmdline, mounting the root read-only when
+// readOnly is set (behavior.boot_readonly, or a read-only snapshot under
+// behavior.match_writability) and otherwise read-write when writable is.
+func newEntryFromSource(snap *btrfs.Snapshot, src bootloader.SourceEntry, displayName string, readOnly, writable bool) *Entry {
 	if snap == nil || snap.Subvolume == nil || src.Loader == "" {
 		return nil
 	}
-	opts := rewriteCmdline(src.Options, snap)
+	opts := bootloader.RewriteCmdline(src.Options, snap)
+	switch {
+	case opts == "":
+	case readOnly:
+		opts = params.ReadOnlyOptions(opts)
+	case writable:
+		opts = params.WritableOptions(opts)
+	}
 	e := &Entry{
 		Title:  fmt.Sprintf("%s (%s)", src.Title, displayName),
 		Sort:   fmt.Sprintf("bls-btrfs-snapshots-%d", snap.Subvolume.ID),
@@ -210,23 +221,6 @@ func (g *generator) findOrphans(entriesDir, prefix string, expected map[string]*
 	return orphans, nil
 }
 
-// snapshotDisplayName mirrors the rEFInd generator's getSnapshotDisplayName
-// so labels read the same across both binaries: rwsnap_<ts>_<id> directory
-// names yield the embedded timestamp; everything else formats SnapshotTime
-// through the user's menu_format + local_time settings.
-func snapshotDisplayName(snap *btrfs.Snapshot, menuFormat string, useLocalTime bool) string {
-	if snap == nil {
-		return ""
-	}
-	if base := filepath.Base(snap.Path); strings.HasPrefix(base, "rwsnap_") {
-		parts := strings.Split(base, "_")
-		if len(parts) >= 3 {
-			return strings.Join(parts[1:len(parts)-1], "_")
-		}
-	}
-	return btrfs.FormatSnapshotTimeForMenu(snap.SnapshotTime, menuFormat, useLocalTime)
-}
-
 // slugify produces a filesystem-friendly identifier from a free-form title:
 // lowercase, alnum + dashes only, runs of separators collapsed.
 func slugify(s string) string {
@@ -0,0 +1,75 @@
+package bootloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/params"
+)
+
+// RewriteCmdline substitutes the snapshot's subvol path and subvolid into
+// baseCmdline. Preserves the user's @ vs /@ subvolume-format preference.
+// Every generator boots snapshots with the same rewriting, only embedding
+// the result differently.
+func RewriteCmdline(baseCmdline string, snap *btrfs.Snapshot) string {
+	if baseCmdline == "" {
+		return ""
+	}
+	p := params.NewBootOptionsParser()
+
+	rootflags := p.ExtractRootFlags(baseCmdline)
+	snapshotSubvol := snap.SubvolPath(p.ExtractSubvol(rootflags))
+
+	out := p.UpdateSubvol(baseCmdline, params.NormalizeSubvol(snapshotSubvol))
+	out = p.UpdateSubvolID(out, fmt.Sprintf("%d", snap.ID))
+	return out
+}
+
+// ReadKernelCmdline returns the kernel command line RewriteCmdline is given
+// when no existing entry supplies one, and the file it came from.
+// /etc/kernel/cmdline is the kernel-install / mkinitcpio canonical
+// location; /proc/cmdline is the running kernel as a last resort, stripped
+// of bootloader-injected initrd= and BOOT_IMAGE= tokens.
+func ReadKernelCmdline() (string, string, error) {
+	if b, err := os.ReadFile("/etc/kernel/cmdline"); err == nil {
+		return strings.TrimSpace(string(b)), "/etc/kernel/cmdline", nil
+	}
+	if b, err := os.ReadFile("/proc/cmdline"); err == nil {
+		return stripProcCmdline(strings.TrimSpace(string(b))), "/proc/cmdline", nil
+	}
+	return "", "", fmt.Errorf("no readable cmdline at /etc/kernel/cmdline or /proc/cmdline")
+}
+
+// stripProcCmdline drops the initrd= and BOOT_IMAGE= tokens a bootloader
+// adds to the running kernel's command line.
+func stripProcCmdline(s string) string {
+	keep := make([]string, 0, len(strings.Fields(s)))
+	for _, tok := range strings.Fields(s) {
+		lower := strings.ToLower(tok)
+		if strings.HasPrefix(lower, "initrd=") || strings.HasPrefix(lower, "boot_image=") {
+			continue
+		}
+		keep = append(keep, tok)
+	}
+	return strings.Join(keep, " ")
+}
+
+// SnapshotDisplayName is the label a snapshot's entries carry, so they read
+// the same in every bootloader's menu: rwsnap_<ts>_<id> directory names
+// yield the embedded timestamp; everything else formats SnapshotTime
+// through the user's menu_format + local_time settings.
+func SnapshotDisplayName(snap *btrfs.Snapshot, menuFormat string, useLocalTime bool) string {
+	if snap == nil {
+		return ""
+	}
+	if base := filepath.Base(snap.Path); strings.HasPrefix(base, "rwsnap_") {
+		parts := strings.Split(base, "_")
+		if len(parts) >= 3 {
+			return strings.Join(parts[1:len(parts)-1], "_")
+		}
+	}
+	return btrfs.FormatSnapshotTimeForMenu(snap.SnapshotTime, menuFormat, useLocalTime)
+}
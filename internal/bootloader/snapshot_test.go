@@ -0,0 +1,146 @@
+package bootloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func snap(id uint64, path string) *btrfs.Snapshot {
+	return &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: id, Path: path}}
+}
+
+func TestRewriteCmdline(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		snap *btrfs.Snapshot
+		want string
+	}{
+		{
+			name: "empty_base_returns_empty",
+			base: "",
+			snap: snap(256, "@/.snapshots/1/snapshot"),
+			want: "",
+		},
+		{
+			name: "preserves_bare_at_prefix",
+			base: "root=UUID=x rw rootflags=subvol=@,subvolid=5",
+			snap: snap(256, "@/.snapshots/1/snapshot"),
+			want: "root=UUID=x rw rootflags=subvol=@/.snapshots/1/snapshot,subvolid=256",
+		},
+		{
+			name: "preserves_slash_at_prefix",
+			base: "root=UUID=x rw rootflags=subvol=/@,subvolid=5",
+			snap: snap(256, "@/.snapshots/1/snapshot"),
+			want: "root=UUID=x rw rootflags=subvol=/@/.snapshots/1/snapshot,subvolid=256",
+		},
+		{
+			name: "trailing_slash_keeps_bare_at_prefix",
+			base: "root=UUID=x rw rootflags=subvol=@/,subvolid=5",
+			snap: snap(256, "@/.snapshots/1/snapshot"),
+			want: "root=UUID=x rw rootflags=subvol=@/.snapshots/1/snapshot,subvolid=256",
+		},
+		{
+			name: "double_slash_keeps_slash_at_prefix",
+			base: "root=UUID=x rw rootflags=subvol=//@//,subvolid=5",
+			snap: snap(256, "@/.snapshots/1/snapshot"),
+			want: "root=UUID=x rw rootflags=subvol=/@/.snapshots/1/snapshot,subvolid=256",
+		},
+		{
+			name: "adds_rootflags_when_missing",
+			base: "root=UUID=x rw quiet",
+			snap: snap(42, "@/.snapshots/9/snapshot"),
+			// First call adds rootflags=subvol=…; second call finds the
+			// rootflags= the first added and merges subvolid= into it
+			// comma-separated, so we end up with one combined rootflags=.
+			want: "root=UUID=x rw quiet rootflags=subvol=@/.snapshots/9/snapshot,subvolid=42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RewriteCmdline(tt.base, tt.snap)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSnapshotDisplayName(t *testing.T) {
+	ts := time.Date(2026, 5, 27, 16, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		path         string
+		menuFormat   string
+		useLocalTime bool
+		want         string
+	}{
+		{
+			name:       "default_menu_format_utc",
+			path:       "/.snapshots/8064/snapshot",
+			menuFormat: "2006-01-02T15:04:05Z",
+			want:       "2026-05-27T16:00:00Z",
+		},
+		{
+			name:       "custom_menu_format_friendly_placeholders",
+			path:       "/.snapshots/8064/snapshot",
+			menuFormat: "YYYY/MM/DD HH:mm",
+			want:       "2026/05/27 16:00",
+		},
+		{
+			name:       "rwsnap_prefix_extracts_embedded_timestamp",
+			path:       "/.snapshots/rwsnap_2026-05-27T16-00-00_42",
+			menuFormat: "2006-01-02T15:04:05Z",
+			want:       "2026-05-27T16-00-00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := &btrfs.Snapshot{
+				Subvolume:    &btrfs.Subvolume{ID: 42, Path: tt.path},
+				SnapshotTime: ts,
+			}
+			got := SnapshotDisplayName(snap, tt.menuFormat, tt.useLocalTime)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStripProcCmdline(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "strips_initrd_and_boot_image",
+			in:   "BOOT_IMAGE=/vmlinuz-linux root=UUID=abc rw initrd=/initramfs-linux.img quiet",
+			want: "root=UUID=abc rw quiet",
+		},
+		{
+			name: "case_insensitive_keys",
+			in:   "BOOT_IMAGE=/x initrd=/y Initrd=/z BOOT_image=/w root=UUID=abc",
+			want: "root=UUID=abc",
+		},
+		{
+			name: "preserves_other_tokens",
+			in:   "root=UUID=abc rw quiet splash",
+			want: "root=UUID=abc rw quiet splash",
+		},
+		{
+			name: "empty_input",
+			in:   "",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, stripProcCmdline(tt.in))
+		})
+	}
+}
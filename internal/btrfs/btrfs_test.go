@@ -1,11 +1,19 @@
 package btrfs
 
 import (
+	"context"
+	"errors"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewManager(t *testing.T) {
@@ -43,7 +51,7 @@ Received UUID: 		-
 Creation time: 		2023-10-15 14:30:22 +0000
 Subvolume ID: 		256
 Generation: 		1234
-Gen at creation: 	1234
+Gen at creation: 	1200
 Parent ID: 		5
 Top level ID: 		5
 Path: 			@
@@ -71,6 +79,10 @@ Snapshot(s):`
 		t.Errorf("Expected generation 1234, got %d", subvol.Generation)
 	}
 
+	if subvol.GenAtCreation != 1200 {
+		t.Errorf("Expected generation at creation 1200, got %d", subvol.GenAtCreation)
+	}
+
 	if subvol.IsReadOnly {
 		t.Error("Expected read-only to be false")
 	}
@@ -345,6 +357,26 @@ func TestFormatSnapshotTimeForMenu(t *testing.T) {
 	}
 }
 
+func TestParseSnapshotTimeForMenu(t *testing.T) {
+	testTime := time.Date(2025, 6, 14, 10, 0, 2, 0, time.UTC)
+
+	for _, format := range []string{"2006-01-02T15:04:05Z", "2006-01-02_15-04-05", "YYYY/MM/DD-HH:mm:ss"} {
+		t.Run(format, func(t *testing.T) {
+			parsed, err := ParseSnapshotTimeForMenu(FormatSnapshotTimeForMenu(testTime, format, false), format, false)
+			if err != nil {
+				t.Fatalf("ParseSnapshotTimeForMenu() error = %v", err)
+			}
+			if !parsed.Equal(testTime) {
+				t.Errorf("ParseSnapshotTimeForMenu() = %v, want %v", parsed, testTime)
+			}
+		})
+	}
+
+	if _, err := ParseSnapshotTimeForMenu("not a time", "2006-01-02T15:04:05Z", false); err == nil {
+		t.Error("ParseSnapshotTimeForMenu() expected error for unparseable title")
+	}
+}
+
 func TestFormatSnapshotTimeForRwsnap(t *testing.T) {
 	testTime := time.Date(2025, 6, 14, 10, 0, 2, 0, time.UTC)
 
@@ -429,6 +461,91 @@ func TestGetSnapperTimestamp(t *testing.T) {
 	}
 }
 
+func TestSnapshotTime(t *testing.T) {
+	snapper := time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC)
+	created := time.Date(2025, 6, 14, 10, 0, 5, 0, time.UTC)
+	mtime := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	var none time.Time
+
+	tests := []struct {
+		name    string
+		source  string
+		snapper time.Time
+		created time.Time
+		want    time.Time
+	}{
+		{"auto_prefers_snapper", TimeSourceAuto, snapper, created, snapper},
+		{"auto_falls_back_to_creation", TimeSourceAuto, none, created, created},
+		{"auto_falls_back_to_mtime", TimeSourceAuto, none, none, mtime},
+		{"empty_is_auto", "", none, created, created},
+		{"snapper_skips_creation", TimeSourceSnapper, none, created, mtime},
+		{"creation_ignores_snapper", TimeSourceCreation, snapper, created, created},
+		{"creation_falls_back_to_mtime", TimeSourceCreation, snapper, none, mtime},
+		{"mtime", TimeSourceMtime, snapper, created, mtime},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewManager(nil, 0, "", false)
+			manager.SetTimeSource(tt.source)
+			assert.Equal(t, tt.want, manager.snapshotTime("/.snapshots/1", tt.snapper, tt.created, mtime))
+		})
+	}
+}
+
+func TestApplyCommandMetadata(t *testing.T) {
+	manager := NewManager(nil, 0, "", false)
+	manager.SetMetadataCommand([]string{"snapdb", "show", "{}"})
+	var gotArgv []string
+	var gotPath string
+	manager.runMetadataCommand = func(argv []string, path string) (*CommandMetadata, error) {
+		gotArgv, gotPath = argv, path
+		return &CommandMetadata{Description: "before upgrade", Time: "2025-06-14T10:00:00Z", Tags: []string{"pre", "pacman"}}, nil
+	}
+
+	snapshot := &Snapshot{FilesystemPath: "/.snapshots/1/snapshot", Description: "from snapper"}
+	got := manager.applyCommandMetadata(snapshot)
+
+	assert.Equal(t, []string{"snapdb", "show", "{}"}, gotArgv)
+	assert.Equal(t, "/.snapshots/1/snapshot", gotPath)
+	assert.Equal(t, time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC), got)
+	assert.Equal(t, "before upgrade", snapshot.Description)
+	assert.Equal(t, []string{"pre", "pacman"}, snapshot.Tags)
+}
+
+func TestApplyCommandMetadata_FailureKeepsSnapshot(t *testing.T) {
+	manager := NewManager(nil, 0, "", false)
+	manager.SetMetadataCommand([]string{"snapdb"})
+	manager.runMetadataCommand = func([]string, string) (*CommandMetadata, error) {
+		return nil, errors.New("exit status 1")
+	}
+
+	snapshot := &Snapshot{FilesystemPath: "/.snapshots/1/snapshot", Description: "from snapper"}
+	assert.True(t, manager.applyCommandMetadata(snapshot).IsZero())
+	assert.Equal(t, "from snapper", snapshot.Description)
+}
+
+func TestExecMetadataCommand(t *testing.T) {
+	// The script echoes its last argument back, showing where the path went.
+	script := `for arg; do last=$arg; done; printf '{"description":"%s","tags":["a"]}' "$last"`
+
+	meta, err := execMetadataCommand([]string{"sh", "-c", script, "sh"}, "/snap/1")
+	require.NoError(t, err)
+	assert.Equal(t, "/snap/1", meta.Description, "path is appended without {}")
+	assert.Equal(t, []string{"a"}, meta.Tags)
+
+	meta, err = execMetadataCommand([]string{"sh", "-c", script, "sh", "{}", "last"}, "/snap/2")
+	require.NoError(t, err)
+	assert.Equal(t, "last", meta.Description, "path replaces {} and isn't appended")
+
+	meta, err = execMetadataCommand([]string{"sh", "-c", script, "sh", "--path={}"}, "/snap/3")
+	require.NoError(t, err)
+	assert.Equal(t, "--path=/snap/3", meta.Description, "{} is replaced within an argument")
+
+	_, err = execMetadataCommand([]string{"sh", "-c", "echo not json"}, "/snap/1")
+	assert.Error(t, err)
+}
+
 func TestLooksLikeSnapshot(t *testing.T) {
 	manager := NewManager([]string{"/.snapshots"}, 0, "2006-01-02_15-04-05", false)
 
@@ -520,6 +637,24 @@ func TestFormatBytes(t *testing.T) {
 	}
 }
 
+func TestQgroupExclusiveBytes(t *testing.T) {
+	output := `qgroupid         rfer         excl 
+--------         ----         ---- 
+0/5             16384        16384 
+0/256      1073741824     52428800 
+0/2560     1073741824            0 
+`
+	size, err := qgroupExclusiveBytes(output, "256")
+	require.NoError(t, err)
+	assert.Equal(t, int64(52428800), size)
+
+	_, err = qgroupExclusiveBytes(output, "2560")
+	assert.ErrorContains(t, err, "inconsistent", "a zero size is still being rescanned")
+
+	_, err = qgroupExclusiveBytes(output, "25")
+	assert.ErrorContains(t, err, "not found")
+}
+
 func TestIsSnapshotBootFromRootFS(t *testing.T) {
 	manager := NewManager(nil, 0, "2006-01-02_15-04-05", false)
 
@@ -571,3 +706,665 @@ func TestIsSnapshotBootFromRootFS(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceAliases_DeviceMapper(t *testing.T) {
+	devRoot := t.TempDir()
+	mustMkdir := func(dir string) {
+		if err := os.MkdirAll(filepath.Join(devRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustSymlink := func(target, link string) {
+		if err := os.Symlink(target, filepath.Join(devRoot, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustMkdir("mapper")
+	mustMkdir("disk/by-id")
+	for _, node := range []string{"dm-0", "dm-1", "nvme0n1"} {
+		if err := os.WriteFile(filepath.Join(devRoot, node), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustSymlink("../dm-0", "mapper/vg-root")
+	mustSymlink("../dm-1", "mapper/vg-home")
+	mustSymlink("../../dm-0", "disk/by-id/dm-name-vg-root")
+	mustSymlink("../../dm-0", "disk/by-id/dm-uuid-LVM-abc123")
+	mustSymlink("../../nvme0n1", "disk/by-id/nvme-Samsung_SSD")
+
+	realDevice, err := filepath.EvalSymlinks(filepath.Join(devRoot, "dm-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := filepath.Join(devRoot, "mapper/vg-root")
+
+	aliases := deviceAliases(devRoot, device, realDevice)
+	expected := []string{
+		realDevice,
+		filepath.Join(devRoot, "disk/by-id/dm-name-vg-root"),
+		filepath.Join(devRoot, "disk/by-id/dm-uuid-LVM-abc123"),
+	}
+	if !slices.Equal(aliases, expected) {
+		t.Errorf("Expected aliases %v, got %v", expected, aliases)
+	}
+
+	// Non-dm devices only get their resolved node.
+	nvme := filepath.Join(devRoot, "nvme0n1")
+	if got := deviceAliases(devRoot, nvme, nvme); len(got) != 0 {
+		t.Errorf("Expected no aliases for a plain device, got %v", got)
+	}
+}
+
+func TestFilesystem_MatchesDeviceAlias(t *testing.T) {
+	fs := &Filesystem{
+		UUID:          "test-uuid",
+		Device:        "/dev/mapper/vg-root",
+		DeviceAliases: []string{"/dev/dm-0", "/dev/disk/by-id/dm-name-vg-root"},
+	}
+
+	for _, device := range []string{"/dev/mapper/vg-root", "/dev/dm-0", "/dev/disk/by-id/dm-name-vg-root", "UUID=test-uuid"} {
+		if !fs.MatchesDevice(device) {
+			t.Errorf("Expected %s to match", device)
+		}
+	}
+	for _, device := range []string{"/dev/dm-1", "/dev/mapper/vg-home", "UUID=other"} {
+		if fs.MatchesDevice(device) {
+			t.Errorf("Expected %s not to match", device)
+		}
+	}
+}
+
+func TestMarkSharedUUIDs(t *testing.T) {
+	root := &Filesystem{UUID: "cloned", Device: "/dev/nvme0n1p2", MountPoint: "/"}
+	home := &Filesystem{UUID: "cloned", Device: "/dev/nvme0n1p2", MountPoint: "/home"}
+	clone := &Filesystem{UUID: "cloned", Device: "/dev/sda2", PartUUID: "clone-part", MountPoint: "/mnt/clone"}
+	other := &Filesystem{UUID: "unique", Device: "/dev/sdb1", MountPoint: "/data"}
+
+	markSharedUUIDs([]*Filesystem{root, home, clone, other})
+
+	for _, fs := range []*Filesystem{root, home, clone} {
+		if !fs.UUIDShared {
+			t.Errorf("Expected %s to be flagged as sharing its UUID", fs.MountPoint)
+		}
+	}
+	if other.UUIDShared {
+		t.Error("Expected a unique UUID not to be flagged")
+	}
+
+	// Subvolume mounts of a single device are not a clone.
+	single := &Filesystem{UUID: "single", Device: "/dev/sdc1", MountPoint: "/"}
+	singleHome := &Filesystem{UUID: "single", Device: "/dev/sdc1", MountPoint: "/home"}
+	markSharedUUIDs([]*Filesystem{single, singleHome})
+	if single.UUIDShared || singleHome.UUIDShared {
+		t.Error("Expected subvolume mounts of one device not to be flagged")
+	}
+
+	// UUID= is ambiguous; PARTUUID= and the device path still match.
+	if clone.MatchesDevice("UUID=cloned") {
+		t.Error("Expected UUID= not to match a filesystem with a shared UUID")
+	}
+	for _, device := range []string{"PARTUUID=clone-part", "/dev/sda2"} {
+		if !clone.MatchesDevice(device) {
+			t.Errorf("Expected %s to match", device)
+		}
+	}
+}
+
+func TestFindWritableCopy(t *testing.T) {
+	destDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(destDir, "rwsnap_2025-01-01_12-00-00_ID12"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "rwsnap_2025-01-01_12-00-00_ID7"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		id   uint64
+		want string
+	}{
+		{"existing copy", 12, filepath.Join(destDir, "rwsnap_2025-01-01_12-00-00_ID12")},
+		{"ID prefix does not match", 1, ""},
+		{"file is not a copy", 7, ""},
+		{"no copy", 99, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findWritableCopy(destDir, tt.id)
+			if err != nil {
+				t.Fatalf("findWritableCopy() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("findWritableCopy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritableCopy_RetriesSubvolumeInfo(t *testing.T) {
+	delay := writableCopyInfoDelay
+	writableCopyInfoDelay = 0
+	t.Cleanup(func() { writableCopyInfoDelay = delay })
+
+	source := &Snapshot{Subvolume: &Subvolume{ID: 512, Path: "/.snapshots/1/snapshot", IsReadOnly: true}}
+	path := "/.refind-btrfs-snapshots/rwsnap_2025-01-01_12-00-00_ID512"
+	m := NewManager(nil, 3, "", false)
+
+	calls := 0
+	m.subvolumeShow = func(string) ([]byte, error) {
+		calls++
+		switch calls {
+		case 1:
+			return nil, errors.New("transient failure")
+		case 2:
+			return []byte(path + "\n\tSubvolume ID: 512\n"), nil
+		default:
+			return []byte(path + "\n\tSubvolume ID: 900\n"), nil
+		}
+	}
+	writable, err := m.writableCopy(context.Background(), source, path, runner.New(false))
+	if err != nil {
+		t.Fatalf("writableCopy() error = %v", err)
+	}
+	if writable.ID != 900 || calls != 3 {
+		t.Errorf("Expected the copy's own ID 900 after 3 attempts, got ID %d after %d", writable.ID, calls)
+	}
+
+	m.subvolumeShow = func(string) ([]byte, error) {
+		return []byte(path + "\n\tSubvolume ID: 512\n"), nil
+	}
+	if _, err := m.writableCopy(context.Background(), source, path, runner.New(false)); err == nil || !strings.Contains(err.Error(), "source ID 512") {
+		t.Errorf("Expected the source's ID to be rejected, got error %v", err)
+	}
+}
+
+func TestCreateWritableSnapshot_ReusesExistingCopy(t *testing.T) {
+	destDir := t.TempDir()
+	existing := filepath.Join(destDir, "rwsnap_old-format_ID512")
+	if err := os.Mkdir(existing, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &Snapshot{
+		Subvolume:    &Subvolume{ID: 512, Path: "/.snapshots/1/snapshot", IsReadOnly: true},
+		SnapshotTime: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	m := NewManager(nil, 3, "", false)
+
+	writable, err := m.CreateWritableSnapshot(context.Background(), source, destDir, runner.New(true))
+	if err != nil {
+		t.Fatalf("CreateWritableSnapshot() error = %v", err)
+	}
+	if writable.Path != existing || writable.FilesystemPath != existing {
+		t.Errorf("Expected existing copy %s to be reused, got path %s", existing, writable.Path)
+	}
+	if writable.OriginalPath != source.Path {
+		t.Errorf("Expected original path %s, got %s", source.Path, writable.OriginalPath)
+	}
+	if writable.IsReadOnly || !source.IsReadOnly {
+		t.Errorf("Expected a writable copy of a read-only source, got copy read-only=%v, source read-only=%v", writable.IsReadOnly, source.IsReadOnly)
+	}
+	if source.Path != "/.snapshots/1/snapshot" {
+		t.Errorf("Source snapshot was modified: path is now %s", source.Path)
+	}
+
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no new copy to be created, found %d entries", len(entries))
+	}
+}
+
+func TestCreateWritableSnapshot_Staging(t *testing.T) {
+	destDir := t.TempDir()
+	source := &Snapshot{
+		Subvolume:    &Subvolume{ID: 512, Path: "/.snapshots/1/snapshot", IsReadOnly: true},
+		SnapshotTime: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	m := NewManager(nil, 3, "", false)
+	m.subvolumeShow = func(path string) ([]byte, error) {
+		t.Errorf("subvolume show %s: a staged copy is never created, so it must not be read", path)
+		return nil, errors.New("no such subvolume")
+	}
+
+	writable, err := m.CreateWritableSnapshot(context.Background(), source, destDir, runner.NewStaging(t.TempDir()))
+	if err != nil {
+		t.Fatalf("CreateWritableSnapshot() error = %v", err)
+	}
+	want := filepath.Join(destDir, "rwsnap_2025-01-01_12-00-00_ID512")
+	if writable.Path != want || writable.IsReadOnly {
+		t.Errorf("Expected a writable copy at %s, got path %s, read-only=%v", want, writable.Path, writable.IsReadOnly)
+	}
+
+	existing, err := m.ExistingWritableSnapshot(source, destDir, runner.NewStaging(t.TempDir()))
+	if err != nil || existing != nil {
+		t.Errorf("Expected no existing copy while staging, got %v, error %v", existing, err)
+	}
+}
+
+func TestFindSubvolumeInfo_TriesAlternatePaths(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	real := filepath.Join(dir, "real")
+	if err := os.Mkdir(real, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+
+	const show = "snapshot\n\tName: snapshot\n\tSubvolume ID: 300\n"
+	tests := []struct {
+		name      string
+		path      string
+		fs        *Filesystem
+		topLevel  string
+		showsAt   string
+		wantTried []string
+		wantErr   bool
+	}{
+		{
+			name:      "canonical_path_first",
+			path:      link,
+			showsAt:   real,
+			wantTried: []string{real},
+		},
+		{
+			name:      "falls_back_to_discovered_path",
+			path:      link,
+			showsAt:   link,
+			wantTried: []string{real, link},
+		},
+		{
+			name:      "top_level_mount",
+			path:      "/.snapshots/1/snapshot",
+			fs:        &Filesystem{UUID: "fs-uuid", MountPoint: "/", Subvolume: &Subvolume{ID: 256, Path: "@"}},
+			topLevel:  "/mnt/top",
+			showsAt:   "/mnt/top/@/.snapshots/1/snapshot",
+			wantTried: []string{"/.snapshots/1/snapshot", "/mnt/top/@/.snapshots/1/snapshot"},
+		},
+		{
+			name:      "all_fail",
+			path:      "/.snapshots/1/snapshot",
+			wantTried: []string{"/.snapshots/1/snapshot"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(nil, 3, "", false)
+			if tt.topLevel != "" {
+				m.topLevelMounts[tt.fs.UUID] = tt.topLevel
+			}
+			var tried []string
+			m.subvolumeShow = func(path string) ([]byte, error) {
+				tried = append(tried, path)
+				if path == tt.showsAt {
+					return []byte(show), nil
+				}
+				return nil, errors.New("not a btrfs subvolume")
+			}
+
+			subvol, err := m.findSubvolumeInfo(tt.path, tt.fs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatalf("findSubvolumeInfo() error = %v", err)
+			} else if subvol.ID != 300 {
+				t.Errorf("subvolume ID = %d, want 300", subvol.ID)
+			}
+			if !slices.Equal(tried, tt.wantTried) {
+				t.Errorf("tried %v, want %v", tried, tt.wantTried)
+			}
+		})
+	}
+}
+
+func TestFindSnapshots_SearchDirDepths(t *testing.T) {
+	root := t.TempDir()
+	shallow := filepath.Join(root, "shallow")
+	deep := filepath.Join(root, "deep")
+	shallowSnap := filepath.Join(shallow, "1", "snap")
+	deepSnap := filepath.Join(deep, "a", "b", "snap")
+	for _, dir := range []string{shallowSnap, deepSnap} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newManager := func(maxDepth int, depths map[string]int) *Manager {
+		m := NewManager([]string{shallow, deep}, maxDepth, "", false)
+		m.SetTimeSource(TimeSourceMtime)
+		m.SetSearchDirDepths(depths)
+		m.subvolumeShow = func(path string) ([]byte, error) {
+			if path == shallowSnap || path == deepSnap {
+				return []byte(path + "\n\tSubvolume ID: 300\n\tParent ID: 256\n\tFlags: readonly\n"), nil
+			}
+			return nil, errors.New("not a btrfs subvolume")
+		}
+		return m
+	}
+	fs := &Filesystem{MountPoint: "/", Subvolume: &Subvolume{ID: 256, Path: "@"}}
+
+	found := func(m *Manager) []string {
+		snapshots, err := m.FindSnapshots(fs)
+		if err != nil {
+			t.Fatalf("FindSnapshots() error = %v", err)
+		}
+		var paths []string
+		for _, s := range snapshots {
+			paths = append(paths, s.FilesystemPath)
+		}
+		slices.Sort(paths)
+		return paths
+	}
+
+	if got := found(newManager(1, nil)); !slices.Equal(got, []string{shallowSnap}) {
+		t.Errorf("global depth 1: found %v, want only %s", got, shallowSnap)
+	}
+	if got := found(newManager(1, map[string]int{deep + "/": 2})); !slices.Equal(got, []string{deepSnap, shallowSnap}) {
+		t.Errorf("deep dir at depth 2: found %v, want both snapshots", got)
+	}
+	if got := found(newManager(2, map[string]int{shallow: 0})); !slices.Equal(got, []string{deepSnap}) {
+		t.Errorf("shallow dir at depth 0: found %v, want only %s", got, deepSnap)
+	}
+}
+
+func TestFindSnapshots_IgnoreMarker(t *testing.T) {
+	root := t.TempDir()
+	kept := filepath.Join(root, "1")
+	ignored := filepath.Join(root, "2")
+	for _, dir := range []string{kept, ignored} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(ignored, ".refind-ignore"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager([]string{root}, 1, "", false)
+	m.SetTimeSource(TimeSourceMtime)
+	m.subvolumeShow = func(path string) ([]byte, error) {
+		if path == kept || path == ignored {
+			return []byte(path + "\n\tSubvolume ID: 300\n\tParent ID: 256\n\tFlags: readonly\n"), nil
+		}
+		return nil, errors.New("not a btrfs subvolume")
+	}
+	fs := &Filesystem{MountPoint: "/", Subvolume: &Subvolume{ID: 256, Path: "@"}}
+
+	found := func() []string {
+		snapshots, err := m.FindSnapshots(fs)
+		if err != nil {
+			t.Fatalf("FindSnapshots() error = %v", err)
+		}
+		var paths []string
+		for _, s := range snapshots {
+			paths = append(paths, s.FilesystemPath)
+		}
+		slices.Sort(paths)
+		return paths
+	}
+
+	if got := found(); !slices.Equal(got, []string{kept, ignored}) {
+		t.Errorf("without a marker name: found %v, want both snapshots", got)
+	}
+	m.SetIgnoreMarker(".refind-ignore")
+	if got := found(); !slices.Equal(got, []string{kept}) {
+		t.Errorf("with marker: found %v, want only %s", got, kept)
+	}
+}
+
+func TestFindSnapshots_Timeshift(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "timeshift-btrfs", "snapshots")
+	withInfo := filepath.Join(root, "2025-06-14_10-00-01")
+	withoutInfo := filepath.Join(root, "2025-06-13_10-00-01")
+	for _, dir := range []string{withInfo, withoutInfo} {
+		for _, sub := range []string{"@", "@home"} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	info := `{"created": "1749895201", "comments": " before upgrade ", "tags": "OD", "type": "btrfs"}`
+	if err := os.WriteFile(filepath.Join(withInfo, "info.json"), []byte(info), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager([]string{root}, 1, "", false)
+	m.subvolumeShow = func(path string) ([]byte, error) {
+		if filepath.Base(path) == "@" {
+			// Timeshift keeps its snapshots at the top level, which is
+			// what `btrfs subvolume show` reports paths from.
+			rel := strings.TrimPrefix(path, filepath.Dir(filepath.Dir(root))+"/")
+			return []byte(rel + "\n\tSubvolume ID: 300\n\tParent ID: 5\n\tFlags: -\n"), nil
+		}
+		return nil, errors.New("not a btrfs subvolume")
+	}
+	fs := &Filesystem{MountPoint: "/", Subvolume: &Subvolume{ID: 256, ParentID: 5, Path: "@"}}
+
+	snapshots, err := m.FindSnapshots(fs)
+	if err != nil {
+		t.Fatalf("FindSnapshots() error = %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("found %d snapshots, want 2", len(snapshots))
+	}
+
+	newest := snapshots[0]
+	if newest.FilesystemPath != filepath.Join(withInfo, "@") {
+		t.Errorf("FilesystemPath = %q, want the @ subvolume in %s", newest.FilesystemPath, withInfo)
+	}
+	if !newest.Timeshift || newest.Path != "timeshift-btrfs/snapshots/2025-06-14_10-00-01/@" {
+		t.Errorf("Path = %q, Timeshift = %v, want the path from the top level of a Timeshift snapshot", newest.Path, newest.Timeshift)
+	}
+	if got := newest.SubvolPath("@"); got != "timeshift-btrfs/snapshots/2025-06-14_10-00-01/@" {
+		t.Errorf("SubvolPath(@) = %q, want the path from the top level", got)
+	}
+	if want := time.Date(2025, 6, 14, 10, 0, 1, 0, time.Local); !newest.SnapshotTime.Equal(want) {
+		t.Errorf("SnapshotTime = %v, want %v from the directory name", newest.SnapshotTime, want)
+	}
+	if newest.Description != "before upgrade" {
+		t.Errorf("Description = %q, want the info.json comments", newest.Description)
+	}
+	if !slices.Equal(newest.Tags, []string{"ondemand", "daily"}) {
+		t.Errorf("Tags = %v, want [ondemand daily]", newest.Tags)
+	}
+	if oldest := snapshots[1]; oldest.Description != "" || oldest.FilesystemPath != filepath.Join(withoutInfo, "@") {
+		t.Errorf("snapshot without info.json = %+v", oldest)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	manager := NewManager(nil, 0, "", false)
+	var available uint64
+	var statErr error
+	manager.freeSpace = func(string) (uint64, error) { return available, statErr }
+
+	available = MinFreeSpace
+	assert.NoError(t, manager.CheckFreeSpace("/.snapshots"))
+
+	available = MinFreeSpace - 1
+	err := manager.CheckFreeSpace("/.snapshots")
+	require.ErrorIs(t, err, ErrNoSpace)
+	assert.Contains(t, err.Error(), "/.snapshots")
+
+	statErr = errors.New("permission denied")
+	err = manager.CheckFreeSpace("/.snapshots")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoSpace)
+}
+
+func TestNoSpaceError(t *testing.T) {
+	assert.NoError(t, noSpaceError(nil))
+	assert.ErrorIs(t, noSpaceError(&os.PathError{Op: "mkdir", Path: "/.rwsnaps", Err: syscall.ENOSPC}), ErrNoSpace)
+	assert.ErrorIs(t, noSpaceError(errors.New("exit status 1: ERROR: cannot snapshot '/.snapshots/1/snapshot': No space left on device")), ErrNoSpace)
+	assert.NotErrorIs(t, noSpaceError(errors.New("exit status 1: ERROR: not a btrfs filesystem")), ErrNoSpace)
+}
+
+func TestParseProgsVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   ProgsVersion
+	}{
+		{"btrfs-progs v6.8.1\n-EXPERIMENTAL -INJECT -STATIC +LZO +ZSTD +UDEV +FSVERITY +ZONED CRYPTO=builtin\n", ProgsVersion{6, 8, 1}},
+		{"btrfs-progs v4.4\n", ProgsVersion{4, 4, 0}},
+		{"Btrfs v3.12\n", ProgsVersion{3, 12, 0}},
+		{"Btrfs v3.17.3\n", ProgsVersion{3, 17, 3}},
+	}
+	for _, tt := range tests {
+		got, err := parseProgsVersion(tt.output)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := parseProgsVersion("btrfs: unknown command 'version'")
+	assert.Error(t, err)
+}
+
+func TestRequireProgs(t *testing.T) {
+	manager := NewManager(nil, 0, "", false)
+	calls := 0
+	manager.btrfsVersion = func() ([]byte, error) {
+		calls++
+		return []byte("btrfs-progs v4.0.1\n"), nil
+	}
+
+	assert.NoError(t, manager.requireProgs("toggling", ProgsVersion{Major: 3, Minor: 14}))
+	err := manager.requireProgs("qgroup sizes", ProgsVersion{Major: 4, Minor: 1})
+	require.ErrorIs(t, err, ErrProgsTooOld)
+	assert.Contains(t, err.Error(), "qgroup sizes needs btrfs-progs v4.1 or newer, found v4.0.1")
+	assert.Equal(t, 1, calls, "version is probed once")
+
+	unknown := NewManager(nil, 0, "", false)
+	unknown.btrfsVersion = func() ([]byte, error) { return nil, errors.New("executable file not found") }
+	assert.NoError(t, unknown.requireProgs("toggling", ProgsVersion{Major: 3, Minor: 14}), "an unknown version isn't refused")
+}
+
+func TestListWritableCopies(t *testing.T) {
+	destDir := t.TempDir()
+	for _, name := range []string{"rwsnap_101", "rwsnap_102", "rwsnap_broken", "other"} {
+		require.NoError(t, os.Mkdir(filepath.Join(destDir, name), 0o755))
+	}
+	created := map[string]string{
+		"rwsnap_101": "2025-06-12 10:00:00 +0000",
+		"rwsnap_102": "2025-06-11 10:00:00 +0000",
+	}
+
+	m := NewManager(nil, 1, "", false)
+	var shown []string
+	m.subvolumeShow = func(path string) ([]byte, error) {
+		shown = append(shown, filepath.Base(path))
+		if t, ok := created[filepath.Base(path)]; ok {
+			return []byte(path + "\n\tSubvolume ID: 300\n\tCreation time: \t" + t + "\n"), nil
+		}
+		return nil, errors.New("not a btrfs subvolume")
+	}
+
+	copies, err := m.ListWritableCopies(destDir)
+	require.NoError(t, err)
+	require.Len(t, copies, 2, "the directory that isn't a subvolume is left out")
+	assert.Equal(t, filepath.Join(destDir, "rwsnap_102"), copies[0].Path, "oldest first by creation time")
+	assert.Equal(t, filepath.Join(destDir, "rwsnap_101"), copies[1].Path)
+	assert.Equal(t, time.Date(2025, 6, 11, 10, 0, 0, 0, time.UTC), copies[0].Created.UTC())
+	assert.NotContains(t, shown, "other", "only rwsnap_ directories are checked")
+
+	missing, err := m.ListWritableCopies(filepath.Join(destDir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	require.NoError(t, m.DeleteWritableCopy(copies[0], runner.New(true)))
+}
+
+func TestSelectPrunableCopies(t *testing.T) {
+	now := time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC)
+	copies := []*WritableCopy{
+		{Path: "a", Subvolume: &Subvolume{ID: 301}, Created: now.Add(-10 * 24 * time.Hour)},
+		{Path: "b", Subvolume: &Subvolume{ID: 302}, Created: now.Add(-5 * 24 * time.Hour)},
+		{Path: "c", Subvolume: &Subvolume{ID: 303}, Created: now.Add(-1 * time.Hour)},
+	}
+	paths := func(cs []*WritableCopy) []string {
+		var out []string
+		for _, c := range cs {
+			out = append(out, c.Path)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		keep      int
+		olderThan time.Duration
+		inUse     map[uint64]bool
+		want      []string
+	}{
+		{name: "no_criteria", keep: -1},
+		{name: "keep_two", keep: 2, want: []string{"a"}},
+		{name: "keep_zero", keep: 0, want: []string{"a", "b", "c"}},
+		{name: "keep_more_than_present", keep: 5},
+		{name: "older_than", keep: -1, olderThan: 72 * time.Hour, want: []string{"a", "b"}},
+		{name: "either_criterion", keep: 2, olderThan: 7 * 24 * time.Hour, want: []string{"a"}},
+		{name: "union", keep: 1, olderThan: 7 * 24 * time.Hour, want: []string{"a", "b"}},
+		{name: "in_use_kept", keep: 0, inUse: map[uint64]bool{301: true, 303: true}, want: []string{"b"}},
+		{name: "in_use_counts_towards_keep", keep: 1, inUse: map[uint64]bool{303: true}, want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, paths(SelectPrunableCopies(copies, tt.keep, tt.olderThan, now, tt.inUse)))
+		})
+	}
+}
+
+func TestShowCache(t *testing.T) {
+	cache := NewShowCache()
+	calls := 0
+	newManager := func() *Manager {
+		m := NewManager([]string{"/.snapshots"}, 2, "", false)
+		m.subvolumeShow = func(path string) ([]byte, error) {
+			calls++
+			return []byte("snapshot\n\tName: snapshot\n\tSubvolume ID: 300\n"), nil
+		}
+		m.SetShowCache(cache)
+		return m
+	}
+	first, second := newManager(), newManager()
+
+	for _, m := range []*Manager{first, second} {
+		subvol, err := m.runSubvolumeShow("/.snapshots/1/snapshot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subvol.ID != 300 {
+			t.Errorf("ID = %d, want 300", subvol.ID)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("subvolume show ran %d times across managers sharing a cache, want 1", calls)
+	}
+
+	first.subvolumesChanged(runner.New(true))
+	if _, err := second.runSubvolumeShow("/.snapshots/1/snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("dry run cleared the cache: subvolume show ran %d times, want 1", calls)
+	}
+
+	first.subvolumesChanged(runner.New(false))
+	if _, err := second.runSubvolumeShow("/.snapshots/1/snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("subvolume show ran %d times after a change, want 2", calls)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
@@ -39,13 +40,14 @@ func (m *Manager) getMountedFilesystems() ([]*MountInfo, error) {
 		identifiers := m.getDeviceIdentifiers(device)
 
 		mount := &MountInfo{
-			Device:     device,
-			Mountpoint: mountpoint,
-			Fstype:     fstype,
-			UUID:       identifiers.UUID,
-			PartUUID:   identifiers.PartUUID,
-			Label:      identifiers.Label,
-			PartLabel:  identifiers.PartLabel,
+			Device:        device,
+			DeviceAliases: identifiers.Aliases,
+			Mountpoint:    mountpoint,
+			Fstype:        fstype,
+			UUID:          identifiers.UUID,
+			PartUUID:      identifiers.PartUUID,
+			Label:         identifiers.Label,
+			PartLabel:     identifiers.PartLabel,
 		}
 
 		mounts = append(mounts, mount)
@@ -71,10 +73,46 @@ func (m *Manager) getDeviceIdentifiers(device string) *esp.DeviceIdentifiers {
 	identifiers.PartUUID = m.findIdentifierInDir("/dev/disk/by-partuuid", realDevice)
 	identifiers.Label = m.findIdentifierInDir("/dev/disk/by-label", realDevice)
 	identifiers.PartLabel = m.findIdentifierInDir("/dev/disk/by-partlabel", realDevice)
+	identifiers.Aliases = deviceAliases("/dev", device, realDevice)
 
 	return identifiers
 }
 
+// deviceAliases returns the other paths under devRoot that resolve to
+// realDevice: the resolved node itself, its /dev/mapper name, and its
+// disk/by-id/dm-* links. Device-mapper volumes (LVM, dm-crypt) often have
+// no by-partuuid entry, so boot entries refer to them by one of these.
+func deviceAliases(devRoot, device, realDevice string) []string {
+	var aliases []string
+	add := func(path string) {
+		if path != device && !slices.Contains(aliases, path) {
+			aliases = append(aliases, path)
+		}
+	}
+
+	add(realDevice)
+	if !strings.HasPrefix(filepath.Base(realDevice), "dm-") {
+		return aliases
+	}
+
+	for _, dir := range []string{"mapper", "disk/by-id"} {
+		entries, err := os.ReadDir(filepath.Join(devRoot, dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if dir == "disk/by-id" && !strings.HasPrefix(entry.Name(), "dm-") {
+				continue
+			}
+			linkPath := filepath.Join(devRoot, dir, entry.Name())
+			if linked, err := filepath.EvalSymlinks(linkPath); err == nil && linked == realDevice {
+				add(linkPath)
+			}
+		}
+	}
+	return aliases
+}
+
 // findIdentifierInDir searches for a device in a /dev/disk/by-* directory and returns the identifier
 func (m *Manager) findIdentifierInDir(byDir, targetDevice string) string {
 	entries, err := os.ReadDir(byDir)
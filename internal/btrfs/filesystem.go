@@ -1,6 +1,13 @@
 package btrfs
 
-import "github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/esp"
+	"github.com/rs/zerolog/log"
+)
 
 // deviceIdentifiers returns the DeviceIdentifiers for this filesystem.
 func (f *Filesystem) deviceIdentifiers() *esp.DeviceIdentifiers {
@@ -10,6 +17,7 @@ func (f *Filesystem) deviceIdentifiers() *esp.DeviceIdentifiers {
 		Label:     f.Label,
 		PartLabel: f.PartLabel,
 		Device:    f.Device,
+		Aliases:   f.DeviceAliases,
 	}
 }
 
@@ -23,7 +31,70 @@ func (f *Filesystem) GetIdentifierType() string {
 	return f.deviceIdentifiers().GetIdentifierType()
 }
 
-// MatchesDevice checks if a device specification matches this filesystem using any available identifier
+// MatchesDevice checks if a device specification matches this filesystem using any available identifier.
+// Device paths that match neither Device nor its aliases directly are
+// resolved through symlinks (e.g. /dev/vg/root -> /dev/dm-0) and retried.
+//
+// UUID= specs never match a filesystem whose UUID is shared with another
+// device (UUIDShared); matching either one could rewrite the wrong
+// snapshot's fstab.
 func (f *Filesystem) MatchesDevice(device string) bool {
-	return f.deviceIdentifiers().Matches(device)
+	ids := f.deviceIdentifiers()
+	if f.UUIDShared && esp.ParseDeviceSpec(device).Type == "UUID" {
+		log.Debug().Str("device", device).Str("mountpoint", f.MountPoint).Msg("Not matching ambiguous UUID reference to a filesystem with a shared UUID")
+		return false
+	}
+	if ids.Matches(device) {
+		return true
+	}
+	if !strings.HasPrefix(device, "/dev/") {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(device)
+	return err == nil && resolved != device && ids.Matches(resolved)
+}
+
+// realDevice returns the block device node Device resolves to.
+func (f *Filesystem) realDevice() string {
+	if resolved, err := filepath.EvalSymlinks(f.Device); err == nil {
+		return resolved
+	}
+	return f.Device
+}
+
+// IsTopLevel reports whether s is the filesystem's top-level subvolume
+// (subvolid 5), which `btrfs subvolume show` names "/" or "<FS_TREE>".
+func (s *Subvolume) IsTopLevel() bool {
+	return s.ID == topLevelSubvolumeID || s.Path == "/" || s.Path == "<FS_TREE>"
+}
+
+// SubvolPath returns the subvol= value booting the snapshot, with a leading
+// slash when originalSubvol, the root's subvol= as configured, has one.
+// Snapper snapshots live under the root's @ subvolume, so their path is put
+// under @ unless it already starts there. Timeshift snapshots sit beside it
+// at the top level and are written as `btrfs subvolume show` reports them.
+func (s *Snapshot) SubvolPath(originalSubvol string) string {
+	var path string
+	if s.Timeshift {
+		path = strings.TrimPrefix(strings.TrimPrefix(s.Path, "<FS_TREE>"), "/")
+	} else {
+		path = "@" + strings.TrimPrefix(s.Path, "@")
+	}
+	if strings.HasPrefix(originalSubvol, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// SelectsTopLevel reports whether the subvol= and subvolid= mount options
+// subvol and subvolID, either of which may be empty, mount the top-level
+// subvolume. With neither, the default subvolume is mounted, which is the
+// top level unless `btrfs subvolume set-default` changed it.
+func SelectsTopLevel(subvol, subvolID string) bool {
+	switch strings.Trim(subvol, "/") {
+	case "", "<FS_TREE>":
+	default:
+		return false
+	}
+	return subvolID == "" || subvolID == strconv.Itoa(topLevelSubvolumeID)
 }
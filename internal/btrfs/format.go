@@ -57,3 +57,20 @@ func FormatSnapshotTimeForRwsnap(t time.Time, template string, useLocalTime bool
 	}
 	return strings.ReplaceAll(result, " ", "_")
 }
+
+// ParseSnapshotTimeForMenu reverses FormatSnapshotTimeForMenu, reading a
+// snapshot time back out of a menu title rendered with template. Placeholder
+// templates are parsed as the equivalent Go layout, so literal text in them
+// must not contain Go layout tokens such as digits.
+func ParseSnapshotTimeForMenu(value, template string, useLocalTime bool) (time.Time, error) {
+	layout := strings.NewReplacer(
+		"YYYY", "2006", "YY", "06", "MM", "01", "DD", "02",
+		"HH", "15", "mm", "04", "ss", "05",
+	).Replace(template)
+
+	loc := time.UTC
+	if useLocalTime {
+		loc = time.Local
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
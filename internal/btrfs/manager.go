@@ -1,20 +1,64 @@
 package btrfs
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 )
 
+// ErrNoBtrfsRoot is returned by GetRootFilesystem when / is not on btrfs.
+var ErrNoBtrfsRoot = errors.New("no btrfs filesystem mounted at root")
+
+// topLevelSubvolumeID is the ID of a btrfs filesystem's top-level subvolume.
+const topLevelSubvolumeID = 5
+
 // Manager handles btrfs filesystem operations
 type Manager struct {
 	searchDirs   []string
 	maxDepth     int
 	rwsnapFormat string
 	useLocalTime bool
+	timeSource   string
+
+	// searchDirDepths overrides maxDepth for individual search directories.
+	searchDirDepths map[string]int
+
+	// ignoreMarker names the file that keeps a snapshot out of discovery;
+	// see SetIgnoreMarker.
+	ignoreMarker string
+	ignored      []string
+
+	// topLevelMounts maps filesystem UUIDs to a mount point of their
+	// top-level subvolume, recorded by DetectBtrfsFilesystems.
+	topLevelMounts map[string]string
+
+	// subvolumeShow runs `btrfs subvolume show`; replaced in tests.
+	subvolumeShow func(path string) ([]byte, error)
+
+	// showCache, when set, shares subvolumeShow output with other
+	// managers; see SetShowCache.
+	showCache *ShowCache
+
+	// freeSpace returns the bytes available at a path; replaced in tests.
+	freeSpace func(path string) (uint64, error)
+
+	// btrfsVersion runs `btrfs version`; replaced in tests. Its parsed
+	// result is cached by ProgsVersion.
+	btrfsVersion func() ([]byte, error)
+	progsOnce    sync.Once
+	progs        ProgsVersion
+	progsErr     error
+
+	// metadataCommand is run per snapshot for its metadata; see
+	// SetMetadataCommand. runMetadataCommand is replaced in tests.
+	metadataCommand    []string
+	runMetadataCommand func(argv []string, path string) (*CommandMetadata, error)
 }
 
 // NewManager creates a new btrfs manager.
@@ -30,6 +74,13 @@ func NewManager(searchDirs []string, maxDepth int, rwsnapFormat string, useLocal
 		maxDepth:     maxDepth,
 		rwsnapFormat: rwsnapFormat,
 		useLocalTime: useLocalTime,
+
+		topLevelMounts: make(map[string]string),
+		subvolumeShow:  execSubvolumeShow,
+		freeSpace:      statfsFreeSpace,
+		btrfsVersion:   execBtrfsVersion,
+
+		runMetadataCommand: execMetadataCommand,
 	}
 }
 
@@ -55,6 +106,8 @@ func (m *Manager) DetectBtrfsFilesystems() ([]*Filesystem, error) {
 			PartLabel:  mount.PartLabel,
 			Device:     mount.Device,
 			MountPoint: mount.Mountpoint,
+
+			DeviceAliases: mount.DeviceAliases,
 		}
 
 		subvol, err := m.getRootSubvolume(mount.Mountpoint)
@@ -62,15 +115,52 @@ func (m *Manager) DetectBtrfsFilesystems() ([]*Filesystem, error) {
 			log.Warn().Err(err).Str("mountpoint", mount.Mountpoint).Msg("Failed to get root subvolume")
 		} else {
 			fs.Subvolume = subvol
+			if subvol.ID == topLevelSubvolumeID && fs.UUID != "" {
+				if _, seen := m.topLevelMounts[fs.UUID]; !seen {
+					m.topLevelMounts[fs.UUID] = fs.MountPoint
+				}
+			}
 		}
 
 		filesystems = append(filesystems, fs)
 	}
 
+	markSharedUUIDs(filesystems)
+
 	log.Info().Int("count", len(filesystems)).Msg("Found btrfs filesystems")
 	return filesystems, nil
 }
 
+// markSharedUUIDs flags filesystems whose UUID is also carried by a
+// different block device. The same filesystem mounted more than once (one
+// mount per subvolume) resolves to the same device and isn't flagged.
+func markSharedUUIDs(filesystems []*Filesystem) {
+	devices := make(map[string][]string)
+	for _, fs := range filesystems {
+		if fs.UUID == "" {
+			continue
+		}
+		if dev := fs.realDevice(); !slices.Contains(devices[fs.UUID], dev) {
+			devices[fs.UUID] = append(devices[fs.UUID], dev)
+		}
+	}
+
+	for uuid, devs := range devices {
+		if len(devs) < 2 {
+			continue
+		}
+		log.Warn().
+			Str("uuid", uuid).
+			Strs("devices", devs).
+			Msg("Multiple btrfs devices share a UUID (cloned disk?) - UUID= references to them are ambiguous and will not be matched, use PARTUUID= or a device path")
+		for _, fs := range filesystems {
+			if fs.UUID == uuid {
+				fs.UUIDShared = true
+			}
+		}
+	}
+}
+
 // FindSnapshots finds all snapshots for the given filesystem
 func (m *Manager) FindSnapshots(fs *Filesystem) ([]*Snapshot, error) {
 	log.Debug().Str("filesystem", fs.GetBestIdentifier()).Str("id_type", fs.GetIdentifierType()).Msg("Finding snapshots")
@@ -83,7 +173,7 @@ func (m *Manager) FindSnapshots(fs *Filesystem) ([]*Snapshot, error) {
 			searchPath = filepath.Join(fs.MountPoint, searchDir)
 		}
 
-		snapshots, err := m.findSnapshotsInDir(searchPath, fs, 0)
+		snapshots, err := m.findSnapshotsInDir(searchPath, fs, 0, m.searchDirDepth(searchDir))
 		if err != nil {
 			log.Warn().Err(err).Str("search_dir", searchPath).Msg("Failed to find snapshots in directory")
 			continue
@@ -100,6 +190,24 @@ func (m *Manager) FindSnapshots(fs *Filesystem) ([]*Snapshot, error) {
 	return allSnapshots, nil
 }
 
+// SetSearchDirDepths sets the maximum depth to scan individual search
+// directories to, keyed by the search directory as configured. Directories
+// not in depths are scanned to the manager's max depth.
+func (m *Manager) SetSearchDirDepths(depths map[string]int) {
+	m.searchDirDepths = make(map[string]int, len(depths))
+	for dir, depth := range depths {
+		m.searchDirDepths[filepath.Clean(dir)] = depth
+	}
+}
+
+// searchDirDepth returns the maximum depth to scan searchDir to.
+func (m *Manager) searchDirDepth(searchDir string) int {
+	if depth, ok := m.searchDirDepths[filepath.Clean(searchDir)]; ok {
+		return depth
+	}
+	return m.maxDepth
+}
+
 // GetRootFilesystem finds the filesystem that contains the root mount point
 func (m *Manager) GetRootFilesystem() (*Filesystem, error) {
 	filesystems, err := m.DetectBtrfsFilesystems()
@@ -113,7 +221,7 @@ func (m *Manager) GetRootFilesystem() (*Filesystem, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no btrfs filesystem mounted at root")
+	return nil, ErrNoBtrfsRoot
 }
 
 // IsSnapshotBootFromRootFS checks if we're booted from a snapshot using an existing root filesystem.
@@ -128,3 +236,31 @@ func (m *Manager) IsSnapshotBootFromRootFS(rootFS *Filesystem) bool {
 		strings.Contains(path, "rwsnap") ||
 		rootFS.Subvolume.IsSnapshot
 }
+
+// SetIgnoreMarker sets the name of a file that, present at a snapshot's
+// root, keeps the snapshot out of FindSnapshots, so single snapshots can
+// be opted out of booting without editing the config. Empty disables it.
+func (m *Manager) SetIgnoreMarker(name string) {
+	m.ignoreMarker = name
+}
+
+// hasIgnoreMarker reports whether the snapshot mounted at fsPath carries
+// the ignore marker.
+func (m *Manager) hasIgnoreMarker(fsPath string) bool {
+	if m.ignoreMarker == "" {
+		return false
+	}
+	_, err := os.Lstat(filepath.Join(fsPath, m.ignoreMarker))
+	if err != nil {
+		return false
+	}
+	log.Info().Str("path", fsPath).Str("marker", m.ignoreMarker).Msg("Skipping snapshot with ignore marker")
+	m.ignored = append(m.ignored, fsPath)
+	return true
+}
+
+// IgnoredSnapshots returns the paths of the snapshots FindSnapshots has
+// skipped for their ignore marker so far, in the order found.
+func (m *Manager) IgnoredSnapshots() []string {
+	return m.ignored
+}
@@ -0,0 +1,107 @@
+package btrfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// metadataCommandTimeout bounds each run of the snapshot metadata command so
+// a hung helper can't stall discovery.
+const metadataCommandTimeout = 10 * time.Second
+
+// CommandMetadata is the JSON a snapshot metadata command prints. All
+// fields are optional; Time is RFC 3339.
+type CommandMetadata struct {
+	Description string   `json:"description"`
+	Time        string   `json:"time"`
+	Tags        []string `json:"tags"`
+}
+
+// SetMetadataCommand sets the argv run for each discovered snapshot to
+// supply its metadata, for snapshot managers other than snapper. The
+// literal "{}" in any argv element is replaced by the snapshot's filesystem
+// path; without one the path is appended. Empty disables the command.
+func (m *Manager) SetMetadataCommand(argv []string) {
+	m.metadataCommand = argv
+}
+
+// applyCommandMetadata runs the metadata command for snapshot and merges
+// its output: a description and tags replace snapper's, and the time is
+// returned to take the place of snapper's date. Returns the zero time when
+// there is no command, it fails, or it prints no time.
+func (m *Manager) applyCommandMetadata(snapshot *Snapshot) time.Time {
+	if len(m.metadataCommand) == 0 {
+		return time.Time{}
+	}
+
+	meta, err := m.runMetadataCommand(m.metadataCommand, snapshot.FilesystemPath)
+	if err != nil {
+		log.Warn().Err(err).Str("path", snapshot.FilesystemPath).Msg("Snapshot metadata command failed")
+		return time.Time{}
+	}
+
+	if meta.Description != "" {
+		snapshot.Description = meta.Description
+	}
+	if len(meta.Tags) > 0 {
+		snapshot.Tags = meta.Tags
+	}
+	var t time.Time
+	if meta.Time != "" {
+		if t, err = time.Parse(time.RFC3339, meta.Time); err != nil {
+			log.Warn().Err(err).Str("path", snapshot.FilesystemPath).Msg("Unreadable time from snapshot metadata command")
+			t = time.Time{}
+		}
+	}
+
+	log.Debug().
+		Str("path", snapshot.FilesystemPath).
+		Str("description", snapshot.Description).
+		Strs("tags", snapshot.Tags).
+		Time("command_time", t).
+		Msg("Found snapshot metadata from command")
+	return t
+}
+
+// execMetadataCommand runs argv for the snapshot at path and decodes its
+// standard output.
+func execMetadataCommand(argv []string, path string) (*CommandMetadata, error) {
+	var args []string
+	substituted := false
+	for _, arg := range argv {
+		if strings.Contains(arg, "{}") {
+			arg = strings.ReplaceAll(arg, "{}", path)
+			substituted = true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metadataCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%s timed out after %s", args[0], metadataCommandTimeout)
+		}
+		return nil, fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	var meta CommandMetadata
+	if err := json.Unmarshal(output, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse %s output: %w", args[0], err)
+	}
+	return &meta, nil
+}
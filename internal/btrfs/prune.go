@@ -0,0 +1,102 @@
+package btrfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+)
+
+// writableCopyPrefix starts the name of every writable copy made with
+// snapshot.writable_method "copy".
+const writableCopyPrefix = "rwsnap_"
+
+// WritableCopy is a writable copy of a snapshot in the destination
+// directory, as made with snapshot.writable_method "copy".
+type WritableCopy struct {
+	Path      string     `json:"path"`
+	Subvolume *Subvolume `json:"subvolume"`
+
+	// Created is the subvolume's creation time, or the directory's
+	// modification time when btrfs doesn't report one.
+	Created time.Time `json:"created"`
+}
+
+// ListWritableCopies returns the rwsnap_* subvolumes in destDir, oldest
+// first. Directories that aren't subvolumes are logged and left out, so
+// they are never passed to `btrfs subvolume delete`. A missing destDir has
+// no copies.
+func (m *Manager) ListWritableCopies(destDir string) ([]*WritableCopy, error) {
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read destination directory: %w", err)
+	}
+
+	var copies []*WritableCopy
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), writableCopyPrefix) {
+			continue
+		}
+		path := filepath.Join(destDir, entry.Name())
+		subvol, err := m.getSubvolumeInfo(path)
+		if err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Not a valid subvolume, skipping")
+			continue
+		}
+		created := subvol.CreatedTime
+		if created.IsZero() {
+			if info, err := entry.Info(); err == nil {
+				created = info.ModTime()
+			}
+		}
+		copies = append(copies, &WritableCopy{Path: path, Subvolume: subvol, Created: created})
+	}
+
+	slices.SortStableFunc(copies, func(a, b *WritableCopy) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return copies, nil
+}
+
+// SelectPrunableCopies returns the copies, oldest first as given by
+// ListWritableCopies, that are beyond the newest keep or were created
+// before now-olderThan. A negative keep or zero olderThan disables that
+// criterion; with both disabled nothing is selected. Copies whose
+// subvolume ID is in inUse, booted or referenced by boot entries, are never
+// selected, though they count towards keep.
+func SelectPrunableCopies(copies []*WritableCopy, keep int, olderThan time.Duration, now time.Time, inUse map[uint64]bool) []*WritableCopy {
+	var prune []*WritableCopy
+	for i, c := range copies {
+		if c.Subvolume != nil && inUse[c.Subvolume.ID] {
+			continue
+		}
+		beyondKeep := keep >= 0 && i < len(copies)-keep
+		tooOld := olderThan > 0 && c.Created.Before(now.Add(-olderThan))
+		if beyondKeep || tooOld {
+			prune = append(prune, c)
+		}
+	}
+	return prune
+}
+
+// DeleteWritableCopy deletes a writable copy's subvolume through r.
+func (m *Manager) DeleteWritableCopy(c *WritableCopy, r runner.Runner) error {
+	log.Info().Str("path", c.Path).Msg("Removing writable snapshot copy")
+	if err := r.Command("btrfs", []string{"subvolume", "delete", c.Path}, "Remove writable snapshot copy"); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", c.Path, err)
+	}
+	m.subvolumesChanged(r)
+	return nil
+}
@@ -0,0 +1,71 @@
+package btrfs
+
+import (
+	"sync"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+)
+
+// ShowCache shares `btrfs subvolume show` output between managers, so runs
+// over several configs on the same filesystems (generate --profiles) query
+// each subvolume once. A manager using it clears it whenever it changes a
+// subvolume, as cached output would then be stale.
+type ShowCache struct {
+	mu      sync.Mutex
+	outputs map[string][]byte
+}
+
+// NewShowCache returns an empty ShowCache.
+func NewShowCache() *ShowCache {
+	return &ShowCache{outputs: make(map[string][]byte)}
+}
+
+// show returns the cached output for path, running show on a miss. Failures
+// aren't cached.
+func (c *ShowCache) show(path string, show func(string) ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	output, ok := c.outputs[path]
+	c.mu.Unlock()
+	if ok {
+		return output, nil
+	}
+
+	output, err := show(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.outputs[path] = output
+	c.mu.Unlock()
+	return output, nil
+}
+
+// clear drops every cached output.
+func (c *ShowCache) clear() {
+	c.mu.Lock()
+	clear(c.outputs)
+	c.mu.Unlock()
+}
+
+// SetShowCache makes the manager look subvolumes up through cache, shared
+// with other managers. Nil stops caching.
+func (m *Manager) SetShowCache(cache *ShowCache) {
+	m.showCache = cache
+}
+
+// showSubvolume runs `btrfs subvolume show` on path, through the show cache
+// when one is set.
+func (m *Manager) showSubvolume(path string) ([]byte, error) {
+	if m.showCache == nil {
+		return m.subvolumeShow(path)
+	}
+	return m.showCache.show(path, m.subvolumeShow)
+}
+
+// subvolumesChanged clears the show cache after the manager changed a
+// subvolume through r. Dry and staged runs change nothing.
+func (m *Manager) subvolumesChanged(r runner.Runner) {
+	if m.showCache != nil && runner.ExecutesCommands(r) {
+		m.showCache.clear()
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -17,42 +18,57 @@ import (
 
 // GetSnapshotSizeWithoutProgress calculates the size of a snapshot using an
 // external file counter. Tries btrfs qgroups first (fast, when quotas are
-// enabled), falls back to native filesystem walking with a 120s timeout.
-func GetSnapshotSizeWithoutProgress(path string, fileCount *int64) (string, error) {
+// enabled and btrfs-progs is new enough), falls back to native filesystem
+// walking with a 120s timeout. Returns the size both human-readable and in
+// bytes; a walk that times out reads "timeout" with 0 bytes.
+func (m *Manager) GetSnapshotSizeWithoutProgress(path string, fileCount *int64) (string, int64, error) {
 	if path == "" {
-		return "", fmt.Errorf("path cannot be empty")
+		return "", 0, fmt.Errorf("path cannot be empty")
 	}
 	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("path does not exist: %s", path)
+		return "", 0, fmt.Errorf("path does not exist: %s", path)
 	}
 
-	if size, err := getSnapshotSizeFromQgroups(path); err == nil {
-		return size, nil
+	err := m.requireProgs("reading snapshot sizes from qgroups", progsQgroupRaw)
+	var size int64
+	if err == nil {
+		size, err = getSnapshotSizeFromQgroups(path)
+	} else {
+		log.Debug().Err(err).Str("path", path).Msg("Counting snapshot size instead of reading qgroups")
+	}
+	if err != nil {
+		size, err = getSnapshotSizeNativeExternal(path, fileCount)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout", 0, nil
 	}
-	return getSnapshotSizeNativeExternal(path, fileCount)
+	if err != nil {
+		return "", 0, err
+	}
+	return formatBytes(size), size, nil
 }
 
 // getSnapshotSizeFromQgroups asks btrfs for the snapshot's exclusive size via
 // qgroups. Only works when quotas are enabled; returns an error otherwise so
 // the caller falls back to native counting.
-func getSnapshotSizeFromQgroups(path string) (string, error) {
+func getSnapshotSizeFromQgroups(path string) (int64, error) {
 	if err := exec.Command("btrfs", "filesystem", "show").Run(); err != nil {
-		return "", fmt.Errorf("btrfs not available")
+		return 0, fmt.Errorf("btrfs not available")
 	}
 
-	output, err := exec.Command("btrfs", "qgroup", "show", path).Output()
+	output, err := exec.Command("btrfs", "qgroup", "show", "--raw", path).Output()
 	if err != nil {
-		return "", fmt.Errorf("quotas not enabled")
+		return 0, fmt.Errorf("quotas not enabled")
 	}
 
 	outputStr := string(output)
-	if strings.Contains(outputStr, "qgroup data inconsistent") || strings.Contains(outputStr, "0.00B") {
-		return "", fmt.Errorf("qgroup data inconsistent or incomplete")
+	if strings.Contains(outputStr, "qgroup data inconsistent") {
+		return 0, fmt.Errorf("qgroup data inconsistent or incomplete")
 	}
 
 	subvolOutput, err := exec.Command("btrfs", "subvolume", "show", path).Output()
 	if err != nil {
-		return "", fmt.Errorf("failed to get subvolume info: %w", err)
+		return 0, fmt.Errorf("failed to get subvolume info: %w", err)
 	}
 
 	subvolID := ""
@@ -66,25 +82,37 @@ func getSnapshotSizeFromQgroups(path string) (string, error) {
 		}
 	}
 	if subvolID == "" {
-		return "", fmt.Errorf("could not find subvolume ID")
+		return 0, fmt.Errorf("could not find subvolume ID")
 	}
 
-	for _, line := range strings.Split(string(output), "\n") {
-		if strings.Contains(line, "0/"+subvolID) {
-			parts := strings.Fields(line)
-			if len(parts) >= 3 {
-				return parts[2], nil
-			}
+	return qgroupExclusiveBytes(outputStr, subvolID)
+}
+
+// qgroupExclusiveBytes reads the exclusive size of subvolume subvolID from
+// `btrfs qgroup show --raw` output. A zero size means the qgroup data is
+// still being rescanned.
+func qgroupExclusiveBytes(output, subvolID string) (int64, error) {
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) < 3 || parts[0] != "0/"+subvolID {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unreadable qgroup size %q: %w", parts[2], err)
+		}
+		if size == 0 {
+			return 0, fmt.Errorf("qgroup data inconsistent or incomplete")
 		}
+		return size, nil
 	}
-
-	return "", fmt.Errorf("subvolume not found in qgroups")
+	return 0, fmt.Errorf("subvolume not found in qgroups")
 }
 
 // getSnapshotSizeNativeExternal walks the snapshot directory and sums file
 // sizes, updating the supplied counter atomically. Bounded by a 120s timeout
 // so a hung walk on a corrupt subvolume doesn't lock the caller.
-func getSnapshotSizeNativeExternal(path string, externalFileCount *int64) (string, error) {
+func getSnapshotSizeNativeExternal(path string, externalFileCount *int64) (int64, error) {
 	var totalSize int64
 
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
@@ -114,9 +142,9 @@ func getSnapshotSizeNativeExternal(path string, externalFileCount *int64) (strin
 
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			return "timeout", nil
+			return 0, ctx.Err()
 		}
-		return "", fmt.Errorf("failed to calculate size: %w", err)
+		return 0, fmt.Errorf("failed to calculate size: %w", err)
 	}
 
 	log.Debug().
@@ -126,7 +154,7 @@ func getSnapshotSizeNativeExternal(path string, externalFileCount *int64) (strin
 		Str("path", path).
 		Msg("Completed size calculation")
 
-	return formatBytes(totalSize), nil
+	return totalSize, nil
 }
 
 // formatBytes converts bytes to human-readable IEC units (KiB, MiB, etc).
@@ -10,15 +10,18 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// applySnapperMetadata enriches a snapshot with metadata from snapper's info.xml if available.
-func (m *Manager) applySnapperMetadata(snapshot *Snapshot, entryPath string) {
+// applySnapperMetadata enriches a snapshot with metadata from snapper's
+// info.xml if available, returning the snapper date, or the zero time when
+// there is none.
+func (m *Manager) applySnapperMetadata(snapshot *Snapshot, entryPath string) time.Time {
 	snapperInfo, err := m.parseSnapperInfo(entryPath)
 	if err != nil {
-		log.Debug().Err(err).Str("path", entryPath).Msg("No snapper info.xml found, using file timestamp")
-		return
+		log.Debug().Err(err).Str("path", entryPath).Msg("No snapper info.xml found")
+		return time.Time{}
 	}
-	if snapperTime, err := m.getSnapperTimestamp(snapperInfo.Date); err == nil {
-		snapshot.SnapshotTime = snapperTime
+	snapperTime, err := m.getSnapperTimestamp(snapperInfo.Date)
+	if err != nil {
+		log.Debug().Err(err).Str("path", entryPath).Msg("Unreadable snapper date")
 	}
 	snapshot.Description = snapperInfo.Description
 	snapshot.SnapperNum = snapperInfo.Num
@@ -28,8 +31,9 @@ func (m *Manager) applySnapperMetadata(snapshot *Snapshot, entryPath string) {
 		Str("path", snapshot.FilesystemPath).
 		Str("description", snapshot.Description).
 		Int("snapper_num", snapshot.SnapperNum).
-		Time("snapper_time", snapshot.SnapshotTime).
+		Time("snapper_time", snapperTime).
 		Msg("Found snapper metadata")
+	return snapperTime
 }
 
 // parseSnapperInfo reads and parses snapper info.xml file
@@ -1,29 +1,32 @@
 package btrfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"slices"
 	"strings"
+	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/rs/zerolog/log"
 )
 
-// MakeSnapshotWritable changes a snapshot's read-only property to false
-func (m *Manager) MakeSnapshotWritable(snapshot *Snapshot, r runner.Runner) error {
-	return m.setSnapshotReadOnly(snapshot, false, r)
+// MakeSnapshotWritable changes a snapshot's read-only property to false.
+// The btrfs command is killed if ctx is done first.
+func (m *Manager) MakeSnapshotWritable(ctx context.Context, snapshot *Snapshot, r runner.Runner) error {
+	return m.setSnapshotReadOnly(ctx, snapshot, false, r)
 }
 
-// MakeSnapshotReadOnly changes a snapshot's read-only property to true
-func (m *Manager) MakeSnapshotReadOnly(snapshot *Snapshot, r runner.Runner) error {
-	return m.setSnapshotReadOnly(snapshot, true, r)
+// MakeSnapshotReadOnly changes a snapshot's read-only property to true.
+// The btrfs command is killed if ctx is done first.
+func (m *Manager) MakeSnapshotReadOnly(ctx context.Context, snapshot *Snapshot, r runner.Runner) error {
+	return m.setSnapshotReadOnly(ctx, snapshot, true, r)
 }
 
 // setSnapshotReadOnly sets the snapshot's read-only property
-func (m *Manager) setSnapshotReadOnly(snapshot *Snapshot, readOnly bool, r runner.Runner) error {
+func (m *Manager) setSnapshotReadOnly(ctx context.Context, snapshot *Snapshot, readOnly bool, r runner.Runner) error {
 	if snapshot == nil || snapshot.Subvolume == nil {
 		return fmt.Errorf("invalid snapshot provided")
 	}
@@ -35,61 +38,149 @@ func (m *Manager) setSnapshotReadOnly(snapshot *Snapshot, readOnly bool, r runne
 		desc = "read-only"
 	}
 
-	err := r.Command("btrfs", []string{"property", "set", snapshot.FilesystemPath, "ro", roValue},
+	if err := m.requireProgs("changing a snapshot's read-only flag", progsPropertySet); err != nil {
+		return err
+	}
+
+	err := runner.WithContext(ctx, r).Command("btrfs", []string{"property", "set", snapshot.FilesystemPath, "ro", roValue},
 		fmt.Sprintf("Make snapshot %s: %s", desc, snapshot.Path))
 	if err != nil {
-		return fmt.Errorf("failed to make snapshot %s: %w", desc, err)
+		return fmt.Errorf("failed to make snapshot %s: %w", desc, noSpaceError(err))
 	}
+	m.subvolumesChanged(r)
 
-	if !r.IsDryRun() {
-		snapshot.IsReadOnly = readOnly
-	}
+	// Recorded under a dry runner too, so entries generated from the
+	// snapshot (behavior.match_writability) preview what a real run writes.
+	snapshot.IsReadOnly = readOnly
 
 	return nil
 }
 
-// CreateWritableSnapshot creates a writable snapshot from a read-only snapshot
-func (m *Manager) CreateWritableSnapshot(snapshot *Snapshot, destDir string, r runner.Runner) (*Snapshot, error) {
+// CreateWritableSnapshot creates a writable snapshot from a read-only
+// snapshot. Once ctx is done, a running btrfs command is killed and no
+// further step is started.
+func (m *Manager) CreateWritableSnapshot(ctx context.Context, snapshot *Snapshot, destDir string, r runner.Runner) (*Snapshot, error) {
 	if snapshot == nil || snapshot.Subvolume == nil {
 		return nil, fmt.Errorf("invalid snapshot provided")
 	}
+	r = runner.WithContext(ctx, r)
+
+	existing, err := findWritableCopy(destDir, snapshot.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for existing writable snapshot: %w", err)
+	}
+	if existing != "" {
+		log.Info().Str("source", snapshot.Path).Str("path", existing).Msg("Reusing existing writable snapshot")
+		return m.writableCopy(ctx, snapshot, existing, r)
+	}
 
 	formattedTime := FormatSnapshotTimeForRwsnap(snapshot.SnapshotTime, m.rwsnapFormat, m.useLocalTime)
 	snapshotName := fmt.Sprintf("rwsnap_%s_ID%d", formattedTime, snapshot.ID)
 	destPath := filepath.Join(destDir, snapshotName)
 
 	if err := r.MkdirAll(destDir, 0755, fmt.Sprintf("Create writable snapshot directory: %s", destDir)); err != nil {
-		return nil, fmt.Errorf("failed to create destination directory: %w", err)
+		return nil, fmt.Errorf("failed to create destination directory: %w", noSpaceError(err))
 	}
 
-	err := r.Command("btrfs", []string{"subvolume", "snapshot", snapshot.Path, destPath},
+	err = r.Command("btrfs", []string{"subvolume", "snapshot", snapshot.Path, destPath},
 		fmt.Sprintf("Create writable snapshot: %s -> %s", snapshot.Path, destPath))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create writable snapshot: %w", err)
+		return nil, fmt.Errorf("failed to create writable snapshot: %w", noSpaceError(err))
 	}
+	m.subvolumesChanged(r)
 
-	if r.IsDryRun() {
-		writable := &Snapshot{
-			Subvolume:    snapshot.Subvolume,
-			OriginalPath: snapshot.Path,
-			SnapshotTime: snapshot.SnapshotTime,
-		}
-		writable.Path = destPath
+	return m.writableCopy(ctx, snapshot, destPath, r)
+}
+
+// ExistingWritableSnapshot returns the writable copy of snapshot in destDir
+// that CreateWritableSnapshot would reuse, or nil when there is none. It
+// creates nothing.
+func (m *Manager) ExistingWritableSnapshot(snapshot *Snapshot, destDir string, r runner.Runner) (*Snapshot, error) {
+	if snapshot == nil || snapshot.Subvolume == nil {
+		return nil, fmt.Errorf("invalid snapshot provided")
+	}
+	existing, err := findWritableCopy(destDir, snapshot.ID)
+	if err != nil || existing == "" {
+		return nil, err
+	}
+	return m.writableCopy(context.Background(), snapshot, existing, r)
+}
+
+// writableCopy describes the writable copy of snapshot at path. Under a
+// runner that doesn't execute commands (dry-run or --stage-dir) the copy may
+// not exist, so it inherits the source's subvolume, made writable; otherwise
+// its own is read and checked by writableCopyInfo.
+func (m *Manager) writableCopy(ctx context.Context, snapshot *Snapshot, path string, r runner.Runner) (*Snapshot, error) {
+	writable := &Snapshot{
+		OriginalPath:   snapshot.Path,
+		FilesystemPath: path,
+		SnapshotTime:   snapshot.SnapshotTime,
+	}
+	if !runner.ExecutesCommands(r) {
+		subvol := *snapshot.Subvolume
+		subvol.Path = path
+		subvol.IsReadOnly = false
+		writable.Subvolume = &subvol
 		return writable, nil
 	}
 
-	newSnapshot, err := m.getSubvolumeInfo(destPath)
+	newSnapshot, err := m.writableCopyInfo(ctx, snapshot, path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get new snapshot info: %w", err)
+		return nil, err
 	}
+	writable.Subvolume = newSnapshot
+	return writable, nil
+}
 
-	writable := &Snapshot{
-		Subvolume:    newSnapshot,
-		OriginalPath: snapshot.Path,
-		SnapshotTime: snapshot.SnapshotTime,
+// writableCopyInfoAttempts and writableCopyInfoDelay bound how long
+// writableCopyInfo waits for a copy's subvolume info; the delay is
+// shortened in tests.
+const writableCopyInfoAttempts = 3
+
+var writableCopyInfoDelay = 500 * time.Millisecond
+
+// writableCopyInfo reads the subvolume of snapshot's writable copy at path,
+// retrying when `btrfs subvolume show` fails or reports no ID or the
+// source's. The copy is a new subvolume whose ID ends up in subvolid= in its
+// fstab and boot options, so the source's must never stand in for it.
+// Retrying stops once ctx is done.
+func (m *Manager) writableCopyInfo(ctx context.Context, snapshot *Snapshot, path string) (*Subvolume, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var subvol *Subvolume
+		if subvol, err = m.getSubvolumeInfo(path); err == nil {
+			if subvol.ID != 0 && subvol.ID != snapshot.ID {
+				return subvol, nil
+			}
+			err = fmt.Errorf("reported subvolume ID %d, not a new subvolume's (source ID %d)", subvol.ID, snapshot.ID)
+		}
+		if attempt == writableCopyInfoAttempts {
+			return nil, fmt.Errorf("failed to get new snapshot info for %s: %w", path, err)
+		}
+		log.Debug().Err(err).Str("path", path).Int("attempt", attempt).Msg("Retrying writable snapshot info")
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to get new snapshot info for %s: %w", path, ctx.Err())
+		case <-time.After(writableCopyInfoDelay):
+		}
 	}
+}
 
-	return writable, nil
+// findWritableCopy returns the path of an existing writable copy of the
+// snapshot with the given subvolume ID in destDir ("rwsnap_<time>_ID<id>"),
+// or "" if there is none. Matching on the ID alone keeps copies reusable
+// after rwsnap_format changes.
+func findWritableCopy(destDir string, id uint64) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(destDir, fmt.Sprintf("rwsnap_*_ID%d", id)))
+	if err != nil {
+		return "", err
+	}
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			return match, nil
+		}
+	}
+	return "", nil
 }
 
 // GetSnapshotFstabPath returns the path to the fstab file in a snapshot
@@ -97,9 +188,10 @@ func GetSnapshotFstabPath(snapshot *Snapshot) string {
 	return filepath.Join(snapshot.FilesystemPath, "etc", "fstab")
 }
 
-// findSnapshotsInDir recursively finds snapshots in a directory
-func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth int) ([]*Snapshot, error) {
-	if depth > m.maxDepth {
+// findSnapshotsInDir recursively finds snapshots in a directory, descending
+// no further than maxDepth levels below the search directory.
+func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth, maxDepth int) ([]*Snapshot, error) {
+	if depth > maxDepth {
 		return nil, nil
 	}
 
@@ -121,14 +213,46 @@ func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth int) ([]*
 
 		entryPath := filepath.Join(dir, entry.Name())
 
+		if timeshiftPath, timeshiftTime, ok := timeshiftSnapshotPath(entryPath); ok {
+			subvol, err := m.findSubvolumeInfo(timeshiftPath, fs)
+			if err == nil && m.isSnapshotOfRoot(subvol, fs.Subvolume) {
+				if m.hasIgnoreMarker(timeshiftPath) {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil {
+					log.Warn().Err(err).Str("path", entryPath).Msg("Failed to get file info")
+					continue
+				}
+
+				snapshot := &Snapshot{
+					Subvolume:      subvol,
+					OriginalPath:   fs.Subvolume.Path,
+					FilesystemPath: timeshiftPath,
+					Timeshift:      true,
+				}
+
+				m.applyTimeshiftMetadata(snapshot, entryPath, timeshiftTime)
+				if t := m.applyCommandMetadata(snapshot); !t.IsZero() {
+					timeshiftTime = t
+				}
+				snapshot.SnapshotTime = m.snapshotTime(entryPath, timeshiftTime, subvol.CreatedTime, info.ModTime())
+				snapshots = append(snapshots, snapshot)
+				continue
+			}
+		}
+
 		snapperSnapshotPath := filepath.Join(entryPath, "snapshot")
 		snapperInfoPath := filepath.Join(entryPath, "info.xml")
 
 		if _, err := os.Stat(snapperSnapshotPath); err == nil {
 			if _, err := os.Stat(snapperInfoPath); err == nil {
-				subvol, err := m.getSubvolumeInfo(snapperSnapshotPath)
+				subvol, err := m.findSubvolumeInfo(snapperSnapshotPath, fs)
 				if err == nil {
 					if m.isSnapshotOfRoot(subvol, fs.Subvolume) {
+						if m.hasIgnoreMarker(snapperSnapshotPath) {
+							continue
+						}
 						info, err := entry.Info()
 						if err != nil {
 							log.Warn().Err(err).Str("path", entryPath).Msg("Failed to get file info")
@@ -139,10 +263,13 @@ func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth int) ([]*
 							Subvolume:      subvol,
 							OriginalPath:   fs.Subvolume.Path,
 							FilesystemPath: snapperSnapshotPath,
-							SnapshotTime:   info.ModTime(),
 						}
 
-						m.applySnapperMetadata(snapshot, entryPath)
+						snapperTime := m.applySnapperMetadata(snapshot, entryPath)
+						if t := m.applyCommandMetadata(snapshot); !t.IsZero() {
+							snapperTime = t
+						}
+						snapshot.SnapshotTime = m.snapshotTime(entryPath, snapperTime, subvol.CreatedTime, info.ModTime())
 						snapshots = append(snapshots, snapshot)
 						continue
 					}
@@ -150,10 +277,10 @@ func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth int) ([]*
 			}
 		}
 
-		subvol, err := m.getSubvolumeInfo(entryPath)
+		subvol, err := m.findSubvolumeInfo(entryPath, fs)
 		if err != nil {
-			if depth < m.maxDepth {
-				subSnapshots, err := m.findSnapshotsInDir(entryPath, fs, depth+1)
+			if depth < maxDepth {
+				subSnapshots, err := m.findSnapshotsInDir(entryPath, fs, depth+1, maxDepth)
 				if err != nil {
 					log.Warn().Err(err).Str("path", entryPath).Msg("Failed to search subdirectory")
 					continue
@@ -174,6 +301,9 @@ func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth int) ([]*
 			Msg("Evaluated potential snapshot")
 
 		if isSnapshot {
+			if m.hasIgnoreMarker(entryPath) {
+				continue
+			}
 			info, err := entry.Info()
 			if err != nil {
 				log.Warn().Err(err).Str("path", entryPath).Msg("Failed to get file info")
@@ -184,10 +314,13 @@ func (m *Manager) findSnapshotsInDir(dir string, fs *Filesystem, depth int) ([]*
 				Subvolume:      subvol,
 				OriginalPath:   fs.Subvolume.Path,
 				FilesystemPath: entryPath,
-				SnapshotTime:   info.ModTime(),
 			}
 
-			m.applySnapperMetadata(snapshot, entryPath)
+			snapperTime := m.applySnapperMetadata(snapshot, entryPath)
+			if t := m.applyCommandMetadata(snapshot); !t.IsZero() {
+				snapperTime = t
+			}
+			snapshot.SnapshotTime = m.snapshotTime(entryPath, snapperTime, subvol.CreatedTime, info.ModTime())
 			snapshots = append(snapshots, snapshot)
 		}
 	}
@@ -253,48 +386,25 @@ func (m *Manager) looksLikeSnapshot(subvol *Subvolume) bool {
 	return false
 }
 
-// CleanupOldSnapshots removes old writable snapshots from the destination directory
-func (m *Manager) CleanupOldSnapshots(destDir string, keepCount int, r runner.Runner) error {
+// CleanupOldSnapshots removes the writable copies in destDir beyond the
+// newest keepCount, leaving alone those whose subvolume ID is in inUse: the
+// copies being booted, or about to be.
+func (m *Manager) CleanupOldSnapshots(destDir string, keepCount int, inUse map[uint64]bool, r runner.Runner) error {
 	if keepCount < 0 {
 		return fmt.Errorf("keepCount must be non-negative")
 	}
 
 	log.Debug().Str("dest_dir", destDir).Int("keep_count", keepCount).Msg("Cleaning up old snapshots")
 
-	entries, err := os.ReadDir(destDir)
+	copies, err := m.ListWritableCopies(destDir)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
-		}
-		return fmt.Errorf("failed to read destination directory: %w", err)
+		return err
 	}
-
-	var snapshots []string
-	for _, entry := range entries {
-		if entry.IsDir() && strings.HasPrefix(entry.Name(), "rwsnap_") {
-			snapshots = append(snapshots, entry.Name())
+	for _, c := range SelectPrunableCopies(copies, keepCount, 0, time.Time{}, inUse) {
+		if err := m.DeleteWritableCopy(c, r); err != nil {
+			log.Warn().Err(err).Str("path", c.Path).Msg("Failed to remove old snapshot")
 		}
 	}
-
-	slices.Sort(snapshots)
-
-	if len(snapshots) > keepCount {
-		toRemove := snapshots[:len(snapshots)-keepCount]
-		for _, snapshot := range toRemove {
-			snapshotPath := filepath.Join(destDir, snapshot)
-			log.Info().Str("path", snapshotPath).Msg("Removing old snapshot")
-
-			if _, err := m.getSubvolumeInfo(snapshotPath); err != nil {
-				log.Warn().Err(err).Str("path", snapshotPath).Msg("Not a valid subvolume, skipping deletion")
-				continue
-			}
-
-			if err := r.Command("btrfs", []string{"subvolume", "delete", snapshotPath}, "Remove old snapshot"); err != nil {
-				log.Warn().Err(err).Str("path", snapshotPath).Msg("Failed to remove old snapshot")
-			}
-		}
-	}
-
 	return nil
 }
 
@@ -309,7 +419,7 @@ func (m *Manager) CleanupSnapshotWritability(allSnapshots []*Snapshot, selectedS
 
 	for _, snapshot := range allSnapshots {
 		if !selectedPaths[snapshot.Path] && !snapshot.IsReadOnly {
-			if err := m.MakeSnapshotReadOnly(snapshot, r); err != nil {
+			if err := m.MakeSnapshotReadOnly(context.Background(), snapshot, r); err != nil {
 				log.Warn().Err(err).Str("path", snapshot.Path).Msg("Failed to make snapshot read-only")
 			}
 		}
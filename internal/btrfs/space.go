@@ -0,0 +1,54 @@
+package btrfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// MinFreeSpace is the space a btrfs filesystem should have available before
+// snapshots on it are made writable. A writable copy or a flipped read-only
+// flag only costs metadata, but btrfs needs room to allocate it, and a
+// filesystem this close to full fails the btrfs call part way through a run.
+const MinFreeSpace = 256 << 20
+
+// ErrNoSpace is wrapped by errors from a btrfs filesystem that is full or
+// below MinFreeSpace.
+var ErrNoSpace = errors.New("btrfs filesystem is out of space")
+
+// CheckFreeSpace returns an error wrapping ErrNoSpace when the filesystem
+// holding path has less than MinFreeSpace available, and any error from
+// reading its free space.
+func (m *Manager) CheckFreeSpace(path string) error {
+	available, err := m.freeSpace(path)
+	if err != nil {
+		return fmt.Errorf("failed to read free space of %s: %w", path, err)
+	}
+	if available < MinFreeSpace {
+		return fmt.Errorf("%w: %s available on the filesystem holding %s, at least %s needed",
+			ErrNoSpace, formatBytes(int64(available)), path, formatBytes(MinFreeSpace))
+	}
+	return nil
+}
+
+// statfsFreeSpace returns the bytes statfs reports available at path.
+func statfsFreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// noSpaceError wraps err with ErrNoSpace when it comes from a btrfs call or
+// filesystem operation that ran out of space, so callers can stop trying.
+func noSpaceError(err error) error {
+	if err == nil || errors.Is(err, ErrNoSpace) {
+		return err
+	}
+	if errors.Is(err, syscall.ENOSPC) || strings.Contains(err.Error(), "No space left on device") {
+		return fmt.Errorf("%w: %w", ErrNoSpace, err)
+	}
+	return err
+}
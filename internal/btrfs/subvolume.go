@@ -2,11 +2,16 @@ package btrfs
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 // getRootSubvolume gets information about the root subvolume of a filesystem
@@ -22,17 +27,71 @@ func (m *Manager) getSubvolumeInfo(path string) (*Subvolume, error) {
 	return m.runSubvolumeShow(path)
 }
 
+// findSubvolumeInfo gets information about the subvolume discovered at path
+// on fs, trying each of subvolumeShowPaths in turn: `btrfs subvolume show`
+// needs a path on a real mount, which a nested or received snapshot may
+// only have through a symlink or the filesystem's top-level mount.
+func (m *Manager) findSubvolumeInfo(path string, fs *Filesystem) (*Subvolume, error) {
+	var errs []error
+	for _, candidate := range m.subvolumeShowPaths(path, fs) {
+		subvol, err := m.runSubvolumeShow(candidate)
+		if err == nil {
+			if candidate != path {
+				log.Debug().Str("path", path).Str("show_path", candidate).Msg("Read subvolume info through alternate path")
+			}
+			return subvol, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", candidate, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// subvolumeShowPaths returns the distinct paths the subvolume discovered at
+// path on fs can be shown through: its canonical path with symlinks
+// resolved, path itself, and the same location under a top-level (subvolid
+// 5) mount of the filesystem.
+func (m *Manager) subvolumeShowPaths(path string, fs *Filesystem) []string {
+	var paths []string
+	add := func(p string) {
+		if p != "" && !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+
+	if canonical, err := filepath.EvalSymlinks(path); err == nil {
+		add(canonical)
+	}
+	add(path)
+
+	if fs == nil || fs.Subvolume == nil || fs.UUID == "" {
+		return paths
+	}
+	top, ok := m.topLevelMounts[fs.UUID]
+	if !ok || top == fs.MountPoint {
+		return paths
+	}
+	if rel, err := filepath.Rel(fs.MountPoint, path); err == nil && !strings.HasPrefix(rel, "..") {
+		add(filepath.Join(top, strings.TrimPrefix(fs.Subvolume.Path, "/"), rel))
+	}
+	return paths
+}
+
 // runSubvolumeShow runs `btrfs subvolume show <path>` and parses the output.
 // Shared by getRootSubvolume and getSubvolumeInfo to avoid duplicating the
 // exec+parse pattern in two places.
 func (m *Manager) runSubvolumeShow(path string) (*Subvolume, error) {
-	output, err := exec.Command("btrfs", "subvolume", "show", path).Output()
+	output, err := m.showSubvolume(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subvolume info: %w", err)
 	}
 	return m.parseSubvolumeShow(string(output))
 }
 
+// execSubvolumeShow is the default Manager.subvolumeShow.
+func execSubvolumeShow(path string) ([]byte, error) {
+	return exec.Command("btrfs", "subvolume", "show", path).Output()
+}
+
 // parseSubvolumeShow parses the output of 'btrfs subvolume show'
 func (m *Manager) parseSubvolumeShow(output string) (*Subvolume, error) {
 	subvol := &Subvolume{}
@@ -75,6 +134,10 @@ func (m *Manager) parseSubvolumeShow(output string) (*Subvolume, error) {
 			if gen, err := strconv.ParseUint(value, 10, 64); err == nil {
 				subvol.Generation = gen
 			}
+		case "Gen at creation":
+			if gen, err := strconv.ParseUint(value, 10, 64); err == nil {
+				subvol.GenAtCreation = gen
+			}
 		case "Flags":
 			subvol.IsReadOnly = strings.Contains(value, "readonly")
 			subvol.IsSnapshot = strings.Contains(value, "snapshot")
@@ -0,0 +1,91 @@
+package btrfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// timeshiftDirLayout is the layout of the directory names Timeshift gives
+// its snapshots under timeshift-btrfs/snapshots, in local time.
+const timeshiftDirLayout = "2006-01-02_15-04-05"
+
+// timeshiftRootSubvolume is the subvolume Timeshift snapshots the root
+// filesystem to, inside each snapshot directory. Timeshift only supports
+// Ubuntu-style layouts, where the root subvolume is @.
+const timeshiftRootSubvolume = "@"
+
+// timeshiftTagNames maps the letters in info.json's tags to the schedules
+// they stand for.
+var timeshiftTagNames = map[rune]string{
+	'O': "ondemand",
+	'B': "boot",
+	'H': "hourly",
+	'D': "daily",
+	'W': "weekly",
+	'M': "monthly",
+}
+
+// TimeshiftInfo represents the parts of Timeshift's info.json used here.
+type TimeshiftInfo struct {
+	Comments string `json:"comments"`
+	Tags     string `json:"tags"`
+}
+
+// timeshiftSnapshotPath returns the root subvolume inside entryPath, and
+// the snapshot time read from its name, when entryPath is a Timeshift btrfs
+// snapshot directory: named for its timestamp and holding an @ subvolume.
+func timeshiftSnapshotPath(entryPath string) (string, time.Time, bool) {
+	t, err := time.ParseInLocation(timeshiftDirLayout, filepath.Base(entryPath), time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	path := filepath.Join(entryPath, timeshiftRootSubvolume)
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return "", time.Time{}, false
+	}
+	return path, t, true
+}
+
+// applyTimeshiftMetadata enriches a snapshot with the comments and tags from
+// Timeshift's info.json in entryPath, if available.
+func (m *Manager) applyTimeshiftMetadata(snapshot *Snapshot, entryPath string, dirTime time.Time) {
+	info, err := parseTimeshiftInfo(entryPath)
+	if err != nil {
+		log.Debug().Err(err).Str("path", entryPath).Msg("No Timeshift info.json found")
+		return
+	}
+	snapshot.Description = info.Comments
+	for _, letter := range info.Tags {
+		if name, ok := timeshiftTagNames[letter]; ok {
+			snapshot.Tags = append(snapshot.Tags, name)
+		}
+	}
+
+	log.Debug().
+		Str("path", snapshot.FilesystemPath).
+		Str("description", snapshot.Description).
+		Strs("tags", snapshot.Tags).
+		Time("timeshift_time", dirTime).
+		Msg("Found Timeshift metadata")
+}
+
+// parseTimeshiftInfo reads and parses Timeshift's info.json file
+func parseTimeshiftInfo(snapshotDir string) (*TimeshiftInfo, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, "info.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var info TimeshiftInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse info.json: %w", err)
+	}
+	info.Comments = strings.TrimSpace(info.Comments)
+	return &info, nil
+}
@@ -0,0 +1,54 @@
+package btrfs
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Snapshot time sources for snapshot.time_source. Whichever is chosen, a
+// snapshot without that timestamp falls back to its directory mtime.
+const (
+	// TimeSourceAuto uses the snapper date, then the subvolume creation
+	// time, then the directory mtime. A time from the metadata command, or
+	// from a Timeshift snapshot's directory name, stands in for the snapper
+	// date.
+	TimeSourceAuto = "auto"
+	// TimeSourceSnapper uses the snapper date, then the directory mtime.
+	TimeSourceSnapper = "snapper"
+	// TimeSourceCreation uses the subvolume creation time, ignoring snapper.
+	TimeSourceCreation = "creation"
+	// TimeSourceMtime always uses the directory mtime.
+	TimeSourceMtime = "mtime"
+)
+
+// SetTimeSource sets where snapshot times are read from, one of the
+// TimeSource constants. Empty means TimeSourceAuto.
+func (m *Manager) SetTimeSource(source string) {
+	m.timeSource = source
+}
+
+// snapshotTime picks the snapshot's time from the available timestamps
+// according to the manager's time source. Zero times are unavailable.
+func (m *Manager) snapshotTime(path string, snapperTime, createdTime, mtime time.Time) time.Time {
+	var chain []time.Time
+	var names []string
+	switch m.timeSource {
+	case TimeSourceSnapper:
+		chain, names = []time.Time{snapperTime}, []string{"snapper"}
+	case TimeSourceCreation:
+		chain, names = []time.Time{createdTime}, []string{"creation"}
+	case TimeSourceMtime:
+	default:
+		chain, names = []time.Time{snapperTime, createdTime}, []string{"snapper", "creation"}
+	}
+
+	for i, t := range chain {
+		if !t.IsZero() {
+			log.Trace().Str("path", path).Str("time_source", names[i]).Time("time", t).Msg("Chose snapshot time")
+			return t
+		}
+	}
+	log.Trace().Str("path", path).Str("time_source", "mtime").Time("time", mtime).Msg("Chose snapshot time")
+	return mtime
+}
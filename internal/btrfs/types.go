@@ -17,17 +17,26 @@ type Filesystem struct {
 	MountPoint string      `json:"mountpoint"`
 	Subvolume  *Subvolume  `json:"subvolume,omitempty"`
 	Snapshots  []*Snapshot `json:"snapshots,omitempty"`
+
+	// DeviceAliases are other paths to Device, see esp.DeviceIdentifiers.
+	DeviceAliases []string `json:"device_aliases,omitempty"`
+
+	// UUIDShared is set when another mounted device has the same UUID, as
+	// with a dd-cloned disk. UUID= specs can't tell the two apart, so they
+	// never match this filesystem.
+	UUIDShared bool `json:"uuid_shared,omitempty"`
 }
 
 // Subvolume represents a btrfs subvolume
 type Subvolume struct {
-	ID          uint64    `json:"id"`
-	Path        string    `json:"path"`
-	ParentID    uint64    `json:"parent_id"`
-	Generation  uint64    `json:"generation"`
-	CreatedTime time.Time `json:"created_time"`
-	IsSnapshot  bool      `json:"is_snapshot"`
-	IsReadOnly  bool      `json:"is_readonly"`
+	ID            uint64    `json:"id"`
+	Path          string    `json:"path"`
+	ParentID      uint64    `json:"parent_id"`
+	Generation    uint64    `json:"generation"`
+	GenAtCreation uint64    `json:"gen_at_creation"` // generation it was created in, not bumped by later writes
+	CreatedTime   time.Time `json:"created_time"`
+	IsSnapshot    bool      `json:"is_snapshot"`
+	IsReadOnly    bool      `json:"is_readonly"`
 }
 
 // Snapshot represents a btrfs snapshot
@@ -39,6 +48,8 @@ type Snapshot struct {
 	Description    string    `json:"description,omitempty"`
 	SnapperNum     int       `json:"snapper_num,omitempty"`
 	SnapperType    string    `json:"snapper_type,omitempty"`
+	Timeshift      bool      `json:"timeshift,omitempty"` // a Timeshift snapshot, beside the root subvolume at the top level
+	Tags           []string  `json:"tags,omitempty"`      // from snapshot.metadata_command or Timeshift
 }
 
 // SnapperInfo represents the snapper info.xml file structure
@@ -53,11 +64,12 @@ type SnapperInfo struct {
 
 // MountInfo represents a mounted filesystem
 type MountInfo struct {
-	Device     string
-	Mountpoint string
-	Fstype     string
-	UUID       string
-	PartUUID   string
-	Label      string
-	PartLabel  string
+	Device        string
+	DeviceAliases []string
+	Mountpoint    string
+	Fstype        string
+	UUID          string
+	PartUUID      string
+	Label         string
+	PartLabel     string
 }
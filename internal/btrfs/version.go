@@ -0,0 +1,106 @@
+package btrfs
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrProgsTooOld is wrapped by errors for features the installed
+// btrfs-progs is too old to provide.
+var ErrProgsTooOld = errors.New("btrfs-progs is too old")
+
+// ProgsVersion is a btrfs-progs release, as printed by `btrfs version`.
+type ProgsVersion struct {
+	Major, Minor, Patch int
+}
+
+// The oldest btrfs-progs releases providing the subcommands used here.
+var (
+	// progsPropertySet added `btrfs property set <path> ro`, used to toggle
+	// snapshots writable.
+	progsPropertySet = ProgsVersion{Major: 3, Minor: 14}
+
+	// progsQgroupRaw added --raw to `btrfs qgroup show`, used to read
+	// snapshot sizes from quotas.
+	progsQgroupRaw = ProgsVersion{Major: 4, Minor: 1}
+)
+
+// progsVersionPattern matches the version in `btrfs version` output, e.g.
+// "btrfs-progs v6.8.1" or "btrfs-progs v4.4", and in that of releases
+// before v4.0, which print "Btrfs v3.12".
+var progsVersionPattern = regexp.MustCompile(`(?:btrfs-progs|Btrfs) v(\d+)\.(\d+)(?:\.(\d+))?`)
+
+func (v ProgsVersion) String() string {
+	if v.Patch != 0 {
+		return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	}
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast reports whether v is minimum or newer.
+func (v ProgsVersion) AtLeast(minimum ProgsVersion) bool {
+	if v.Major != minimum.Major {
+		return v.Major > minimum.Major
+	}
+	if v.Minor != minimum.Minor {
+		return v.Minor > minimum.Minor
+	}
+	return v.Patch >= minimum.Patch
+}
+
+// ProgsVersion returns the installed btrfs-progs version. `btrfs version`
+// is run once per Manager and its result, or error, reused.
+func (m *Manager) ProgsVersion() (ProgsVersion, error) {
+	m.progsOnce.Do(func() {
+		output, err := m.btrfsVersion()
+		if err != nil {
+			m.progsErr = fmt.Errorf("failed to run btrfs version: %w", err)
+			return
+		}
+		m.progs, m.progsErr = parseProgsVersion(string(output))
+		if m.progsErr == nil {
+			log.Debug().Str("version", m.progs.String()).Msg("Found btrfs-progs")
+		}
+	})
+	return m.progs, m.progsErr
+}
+
+// requireProgs returns an error wrapping ErrProgsTooOld when the installed
+// btrfs-progs is older than minimum, naming feature. When the version can't
+// be read the command is left to succeed or fail on its own.
+func (m *Manager) requireProgs(feature string, minimum ProgsVersion) error {
+	version, err := m.ProgsVersion()
+	if err != nil {
+		log.Debug().Err(err).Str("feature", feature).Msg("Unknown btrfs-progs version, not checking it")
+		return nil
+	}
+	if !version.AtLeast(minimum) {
+		return fmt.Errorf("%w: %s needs btrfs-progs %s or newer, found %s", ErrProgsTooOld, feature, minimum, version)
+	}
+	return nil
+}
+
+// parseProgsVersion reads the version from `btrfs version` output.
+func parseProgsVersion(output string) (ProgsVersion, error) {
+	match := progsVersionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return ProgsVersion{}, fmt.Errorf("unrecognised btrfs version output %q", output)
+	}
+	var v ProgsVersion
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	if match[3] != "" {
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v, nil
+}
+
+// execBtrfsVersion is the default Manager.btrfsVersion.
+func execBtrfsVersion() ([]byte, error) {
+	return exec.Command("btrfs", "version").Output()
+}
@@ -1,5 +1,6 @@
-// Package cliconfig wires cobra flags into the koanf config loader.
-// Each binary supplies its own default config path and a flag→koanf-key map.
+// Package cliconfig wires cobra flags into the koanf config loader and builds
+// the managers the binaries share from the loaded config. Each binary supplies
+// its own default config path and a flag→koanf-key map.
 package cliconfig
 
 import (
@@ -18,6 +19,12 @@ func Load(cmd *cobra.Command, defaultPath string, flagToKey map[string]string) (
 	if path == "" {
 		path = defaultPath
 	}
+	return LoadFile(cmd, path, flagToKey)
+}
+
+// LoadFile loads the config at path, ignoring --config, with the same flag
+// overrides as Load. For commands that load several config files per run.
+func LoadFile(cmd *cobra.Command, path string, flagToKey map[string]string) (*config.Config, error) {
 	return config.Load(path, flagOverrides(cmd.Flags(), flagToKey))
 }
 
@@ -44,6 +51,8 @@ func flagValueAs(f *pflag.Flag) any {
 	case "int":
 		i, _ := strconv.Atoi(f.Value.String())
 		return i
+	case "stringSlice", "stringArray":
+		return f.Value.(pflag.SliceValue).GetSlice()
 	default:
 		return f.Value.String()
 	}
@@ -16,6 +16,7 @@ func newCmd() *cobra.Command {
 	cmd.PersistentFlags().String("log-level", "info", "log level")
 	cmd.PersistentFlags().Bool("dry-run", false, "dry run")
 	cmd.PersistentFlags().Int("count", 0, "count")
+	cmd.PersistentFlags().StringSlice("kernels", nil, "kernels")
 	return cmd
 }
 
@@ -76,11 +77,12 @@ func TestLoad_FlagNotInMapIgnored(t *testing.T) {
 
 func TestFlagValueAs_TypeCoercion(t *testing.T) {
 	cmd := newCmd()
-	require.NoError(t, cmd.ParseFlags([]string{"--dry-run", "--count=42", "--log-level=debug"}))
+	require.NoError(t, cmd.ParseFlags([]string{"--dry-run", "--count=42", "--log-level=debug", "--kernels=linux,linux-lts"}))
 
 	assert.Equal(t, true, flagValueAs(cmd.Flag("dry-run")))
 	assert.Equal(t, 42, flagValueAs(cmd.Flag("count")))
 	assert.Equal(t, "debug", flagValueAs(cmd.Flag("log-level")))
+	assert.Equal(t, []string{"linux", "linux-lts"}, flagValueAs(cmd.Flag("kernels")))
 }
 
 func TestFlagOverrides_ReturnsNilWhenEmpty(t *testing.T) {
@@ -90,3 +92,12 @@ func TestFlagOverrides_ReturnsNilWhenEmpty(t *testing.T) {
 	got := flagOverrides(cmd.Flags(), map[string]string{"dry-run": "dry_run"})
 	assert.Nil(t, got, "no flags set → nil so config.Load skips the override layer")
 }
+
+func TestLoad_SliceFlagOverride(t *testing.T) {
+	cmd := newCmd()
+	require.NoError(t, cmd.ParseFlags([]string{"--kernels=linux-lts", "--kernels=linux-zen"}))
+
+	cfg, err := Load(cmd, "/nonexistent/path.yaml", map[string]string{"kernels": "kernel.filter"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"linux-lts", "linux-zen"}, cfg.Kernel.Filter)
+}
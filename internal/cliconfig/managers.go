@@ -0,0 +1,31 @@
+package cliconfig
+
+import (
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
+)
+
+// NewBtrfsManager returns a btrfs.Manager configured from cfg's snapshot,
+// naming and display settings. searchDirs replaces
+// snapshot.search_directories when non-empty.
+func NewBtrfsManager(cfg *config.Config, searchDirs []string) *btrfs.Manager {
+	if len(searchDirs) == 0 {
+		searchDirs = cfg.Snapshot.SearchDirectories
+	}
+	m := btrfs.NewManager(searchDirs, cfg.Snapshot.MaxDepth, cfg.Advanced.Naming.RwsnapFormat, cfg.Display.LocalTime.IsTrue())
+	m.SetTimeSource(cfg.Snapshot.TimeSource)
+	m.SetMetadataCommand(cfg.Snapshot.MetadataCommand.Argv())
+	m.SetSearchDirDepths(cfg.Snapshot.SearchDirDepths())
+	m.SetIgnoreMarker(cfg.Snapshot.IgnoreMarker)
+	return m
+}
+
+// NewFstabManager returns an fstab.Manager configured from cfg's fstab
+// settings.
+func NewFstabManager(cfg *config.Config) *fstab.Manager {
+	m := fstab.NewManager()
+	m.SetCanonicalOptionOrder(cfg.Fstab.CanonicalOptionOrder.IsTrue())
+	m.SetBootFSTypes(cfg.Fstab.BootInternalFSTypes, cfg.Fstab.BootExternalFSTypes)
+	return m
+}
@@ -1,14 +1,18 @@
 // Package config defines the typed configuration schema and loader.
 package config
 
+import "time"
+
 type Config struct {
 	Snapshot SnapshotConfig `koanf:"snapshot"`
 	Refind   RefindConfig   `koanf:"refind"`
 	ESP      ESPConfig      `koanf:"esp"`
 	Behavior BehaviorConfig `koanf:"behavior"`
+	Fstab    FstabConfig    `koanf:"fstab"`
 	Kernel   KernelConfig   `koanf:"kernel"`
 	BLS      BLSConfig      `koanf:"bls"`
 	UKI      UKIConfig      `koanf:"uki"`
+	GRUB     GRUBConfig     `koanf:"grub"`
 	Display  DisplayConfig  `koanf:"display"`
 	Advanced AdvancedConfig `koanf:"advanced"`
 	List     ListConfig     `koanf:"list"`
@@ -28,10 +32,90 @@ type SnapshotConfig struct {
 	SelectionCount    int      `koanf:"selection_count"`
 	DestinationDir    string   `koanf:"destination_dir"`
 	WritableMethod    string   `koanf:"writable_method"`
+	TimeSource        string   `koanf:"time_source"`
+
+	// SkipIdentical leaves out snapshots whose generation shows the live
+	// root hasn't changed since they were taken.
+	SkipIdentical Truthy `koanf:"skip_identical"`
+
+	// IncludeTypes, when non-empty, limits generation to snapshots whose
+	// snapper type (single, pre, post) is listed. Snapshots without
+	// snapper metadata are always included.
+	IncludeTypes []string `koanf:"include_types"`
+
+	// SearchDirectoryDepths overrides MaxDepth for individual search
+	// directories. A list rather than a map because koanf splits keys on
+	// dots, which search directory paths usually contain.
+	SearchDirectoryDepths []SearchDirectoryDepth `koanf:"search_directory_depths"`
+
+	// MetadataCommand, when non-empty, is exec'd per discovered snapshot and
+	// prints JSON metadata (description, time, tags) for it. The literal
+	// "{}" in any argv element is substituted with the snapshot's path;
+	// without one the path is appended.
+	MetadataCommand ShellArgv `koanf:"metadata_command"`
+
+	// IgnoreMarker is a file name that, present at a snapshot's root, keeps
+	// the snapshot out of discovery. Empty disables the check.
+	IgnoreMarker string `koanf:"ignore_marker"`
+}
+
+// SearchDirectoryDepth is the maximum depth to scan one search directory to.
+type SearchDirectoryDepth struct {
+	Path     string `koanf:"path"`
+	MaxDepth int    `koanf:"max_depth"`
+}
+
+// SearchDirDepths returns SearchDirectoryDepths keyed by path.
+func (c SnapshotConfig) SearchDirDepths() map[string]int {
+	if len(c.SearchDirectoryDepths) == 0 {
+		return nil
+	}
+	depths := make(map[string]int, len(c.SearchDirectoryDepths))
+	for _, d := range c.SearchDirectoryDepths {
+		depths[d.Path] = d.MaxDepth
+	}
+	return depths
 }
 
 type RefindConfig struct {
 	ConfigPath string `koanf:"config_path"`
+
+	// SourceTitleInclude and SourceTitleExclude are regular expressions
+	// matched against source menuentry titles; only entries matching an
+	// include (or any, when empty) and no exclude get snapshot submenus.
+	SourceTitleInclude []string `koanf:"source_title_include"`
+	SourceTitleExclude []string `koanf:"source_title_exclude"`
+
+	// MaxOptionsLength is the generated options line length above which a
+	// warning is logged. Zero disables the check.
+	MaxOptionsLength int `koanf:"max_options_length"`
+
+	// ChainloadLoader, when set, is an ESP path to an EFI binary that
+	// snapshot submenus chainload instead of loading the kernel directly.
+	ChainloadLoader string `koanf:"chainload_loader"`
+
+	// SnapshotOptions adds kernel options to the submenus of individual
+	// snapshots, picked by subvolume ID or description.
+	SnapshotOptions []SnapshotOptions `koanf:"snapshot_options"`
+
+	// MultipleRefindLinux picks what happens when more than one
+	// refind_linux.conf matches the root volume: "all" updates each,
+	// "first" only the first by path, and "managed" none, generating the
+	// managed include from their entries instead.
+	MultipleRefindLinux string `koanf:"multiple_refind_linux"`
+
+	// PreserveCRLF keeps CRLF line endings in rEFInd configs that already
+	// use them instead of rewriting them with LF.
+	PreserveCRLF Truthy `koanf:"preserve_crlf"`
+}
+
+// SnapshotOptions adds Options to the entries of the snapshot with
+// subvolume ID SubvolID, or of every snapshot whose description matches
+// the regular expression Description. Exactly one of the two is set.
+type SnapshotOptions struct {
+	SubvolID    uint64 `koanf:"subvolid"`
+	Description string `koanf:"description"`
+	Options     string `koanf:"options"`
 }
 
 type ESPConfig struct {
@@ -41,13 +125,70 @@ type ESPConfig struct {
 }
 
 type BehaviorConfig struct {
-	ExitOnSnapshotBoot  Truthy `koanf:"exit_on_snapshot_boot"`
-	CleanupOldSnapshots Truthy `koanf:"cleanup_old_snapshots"`
+	ExitOnSnapshotBoot  Truthy        `koanf:"exit_on_snapshot_boot"`
+	CleanupOldSnapshots Truthy        `koanf:"cleanup_old_snapshots"`
+	BackupConfigs       Truthy        `koanf:"backup_configs"`
+	BackupRetain        int           `koanf:"backup_retain"`
+	StateFile           string        `koanf:"state_file"`
+	AuditLog            string        `koanf:"audit_log"`
+	RequireESP          Truthy        `koanf:"require_esp"`
+	CopyBootToESP       Truthy        `koanf:"copy_boot_to_esp"`
+	ESPBootDir          string        `koanf:"esp_boot_dir"`
+	TimeoutPerSnapshot  time.Duration `koanf:"timeout_per_snapshot"`
+
+	// BootReadOnly boots snapshots read-only as they are, skipping the
+	// writability change and fstab rewrite.
+	BootReadOnly Truthy `koanf:"boot_readonly"`
+
+	// MatchWritability sets ro or rw in snapshot entries' options by
+	// whether the snapshot is read-only, rather than inheriting the
+	// source entry's.
+	MatchWritability Truthy `koanf:"match_writability"`
+
+	// SetRecoveryDefault makes the managed config select the newest
+	// snapshot that isn't stale as rEFInd's default entry.
+	SetRecoveryDefault Truthy `koanf:"set_recovery_default"`
+}
+
+type FstabConfig struct {
+	// CanonicalOptionOrder arranges the rewritten root entry's mount
+	// options in a stable order instead of preserving their position.
+	CanonicalOptionOrder Truthy `koanf:"canonical_option_order"`
+
+	// BootInternalFSTypes and BootExternalFSTypes override how a snapshot's
+	// /boot mount is classified by filesystem type for boot mode detection:
+	// internal boots the snapshot's own kernels (btrfs mode), external the
+	// ESP's (ESP mode).
+	BootInternalFSTypes []string `koanf:"boot_internal_fstypes"`
+	BootExternalFSTypes []string `koanf:"boot_external_fstypes"`
 }
 
 type KernelConfig struct {
-	StaleSnapshotAction string          `koanf:"stale_snapshot_action"`
-	BootImagePatterns   []PatternConfig `koanf:"boot_image_patterns"`
+	StaleSnapshotAction  string          `koanf:"stale_snapshot_action"`
+	BootImagePatterns    []PatternConfig `koanf:"boot_image_patterns"`
+	VerifyHashes         Truthy          `koanf:"verify_hashes"`
+	HashFile             string          `koanf:"hash_file"`
+	UsePackageDB         Truthy          `koanf:"use_package_db"`
+	BtrfsFallbackEntries Truthy          `koanf:"btrfs_fallback_entries"`
+
+	// BtrfsEntriesPerSnapshot caps the in-snapshot kernels planned for a
+	// btrfs-mode snapshot (0 = all); BtrfsPreferredKernels names the
+	// kernels to keep first.
+	BtrfsEntriesPerSnapshot int      `koanf:"btrfs_entries_per_snapshot"`
+	BtrfsPreferredKernels   []string `koanf:"btrfs_preferred_kernels"`
+
+	// MicrocodeMismatchAction is what an ESP-mode snapshot entry does with
+	// a microcode initrd modified since the snapshot: "keep", "drop" or
+	// "pin" it to the snapshot's ESP boot copy.
+	MicrocodeMismatchAction string `koanf:"microcode_mismatch_action"`
+
+	// CheckInitrds leaves out ESP-mode snapshot entries that load an
+	// initrd missing from the ESP.
+	CheckInitrds Truthy `koanf:"check_initrds"`
+
+	// Filter, when non-empty, limits snapshot entries to the kernels it
+	// names, by kernel name or image file name (--kernel-filter).
+	Filter []string `koanf:"filter"`
 }
 
 // PatternConfig mirrors kernel.PatternConfig so the config package stays
@@ -61,7 +202,13 @@ type PatternConfig struct {
 }
 
 type DisplayConfig struct {
-	LocalTime Truthy `koanf:"local_time"`
+	LocalTime      Truthy `koanf:"local_time"`
+	SubmenuOrder   string `koanf:"submenu_order"`
+	FallbackMarker string `koanf:"fallback_marker"`
+
+	// BucketByAge groups snapshot submenus into one menuentry per age
+	// bucket (today, this week, this month, older).
+	BucketByAge Truthy `koanf:"bucket_by_age"`
 }
 
 // BLSConfig: optional BLS Type #1 entry output, consumed by the bls-btrfs-snapshots
@@ -87,8 +234,44 @@ type UKIConfig struct {
 	SignCommand ShellArgv `koanf:"sign_command"`
 }
 
+// GRUBConfig: the menuentry fragment written by generate --backend grub, for
+// sourcing from /etc/grub.d/40_custom. Unlike BLS, btrfs-mode snapshots
+// emit too, since GRUB reads kernels inside the snapshot subvolumes.
+type GRUBConfig struct {
+	ConfigPath string `koanf:"config_path"`
+}
+
 type AdvancedConfig struct {
 	Naming NamingConfig `koanf:"naming"`
+
+	// OptionsTemplate, when set, is a text/template that replaces the
+	// default subvol rewriting of snapshot submenu options.
+	OptionsTemplate string `koanf:"options_template"`
+
+	// KernelTitles names the menuentries generated for kernels by their
+	// loader file name, ahead of the built-in names.
+	KernelTitles []KernelTitle `koanf:"kernel_titles"`
+}
+
+// KernelTitle is the menu title for kernels loaded from a file named
+// Loader, e.g. vmlinuz-linux-custom.
+type KernelTitle struct {
+	Loader string `koanf:"loader"`
+	Title  string `koanf:"title"`
+}
+
+// KernelTitleMap returns the kernel titles keyed by loader file name. This
+// is a list in the config because file names like vmlinuz-6.1.0-13-amd64
+// contain the dots that separate config keys.
+func (c AdvancedConfig) KernelTitleMap() map[string]string {
+	if len(c.KernelTitles) == 0 {
+		return nil
+	}
+	titles := make(map[string]string, len(c.KernelTitles))
+	for _, t := range c.KernelTitles {
+		titles[t.Loader] = t.Title
+	}
+	return titles
 }
 
 type NamingConfig struct {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,11 +16,47 @@ func TestDefaults(t *testing.T) {
 	assert.Equal(t, []string{"/.snapshots"}, d.Snapshot.SearchDirectories)
 	assert.Equal(t, 3, d.Snapshot.MaxDepth)
 	assert.Equal(t, "toggle", d.Snapshot.WritableMethod)
+	assert.Equal(t, "auto", d.Snapshot.TimeSource)
+	assert.False(t, d.Snapshot.SkipIdentical.IsTrue())
+	assert.Empty(t, d.Snapshot.IncludeTypes)
+	assert.Equal(t, ".refind-ignore", d.Snapshot.IgnoreMarker)
 	assert.Equal(t, "/EFI/refind/refind.conf", d.Refind.ConfigPath)
+	assert.Equal(t, 1024, d.Refind.MaxOptionsLength)
+	assert.Empty(t, d.Refind.ChainloadLoader)
+	assert.Equal(t, "all", d.Refind.MultipleRefindLinux)
+	assert.False(t, d.Refind.PreserveCRLF.IsTrue())
 	assert.True(t, d.ESP.AutoDetect.IsTrue())
 	assert.True(t, d.Behavior.ExitOnSnapshotBoot.IsTrue())
 	assert.True(t, d.Behavior.CleanupOldSnapshots.IsTrue())
+	assert.False(t, d.Behavior.BackupConfigs.IsTrue())
+	assert.Equal(t, 5, d.Behavior.BackupRetain)
+	assert.Equal(t, "/var/lib/refind-btrfs-snapshots/last-run.json", d.Behavior.StateFile)
+	assert.Empty(t, d.Behavior.AuditLog)
+	assert.True(t, d.Behavior.RequireESP.IsTrue())
+	assert.False(t, d.Behavior.CopyBootToESP.IsTrue())
+	assert.Equal(t, "/EFI/refind-btrfs-snapshots", d.Behavior.ESPBootDir)
+	assert.Zero(t, d.Behavior.TimeoutPerSnapshot)
+	assert.False(t, d.Behavior.BootReadOnly.IsTrue())
+	assert.False(t, d.Behavior.MatchWritability.IsTrue())
+	assert.False(t, d.Behavior.SetRecoveryDefault.IsTrue())
+	assert.False(t, d.Fstab.CanonicalOptionOrder.IsTrue())
+	assert.Empty(t, d.Fstab.BootInternalFSTypes)
+	assert.Empty(t, d.Fstab.BootExternalFSTypes)
+	assert.Equal(t, "/boot/grub/refind-btrfs-snapshots.cfg", d.GRUB.ConfigPath)
+	assert.Equal(t, "newest", d.Display.SubmenuOrder)
+	assert.Equal(t, " [fallback]", d.Display.FallbackMarker)
+	assert.False(t, d.Display.BucketByAge.IsTrue())
 	assert.Equal(t, "delete", d.Kernel.StaleSnapshotAction)
+	assert.False(t, d.Kernel.VerifyHashes.IsTrue())
+	assert.True(t, d.Kernel.CheckInitrds.IsTrue())
+	assert.Equal(t, "/var/lib/refind-btrfs-snapshots/boot-hashes.json", d.Kernel.HashFile)
+	assert.False(t, d.Kernel.UsePackageDB.IsTrue())
+	assert.False(t, d.Kernel.BtrfsFallbackEntries.IsTrue())
+	assert.Equal(t, 0, d.Kernel.BtrfsEntriesPerSnapshot)
+	assert.Empty(t, d.Kernel.BtrfsPreferredKernels)
+	assert.Equal(t, "keep", d.Kernel.MicrocodeMismatchAction)
+	assert.Empty(t, d.Kernel.Filter)
+	assert.Empty(t, d.Advanced.KernelTitles)
 	assert.Equal(t, "info", d.LogLevel)
 }
 
@@ -35,6 +72,11 @@ func TestValidate(t *testing.T) {
 			mutate:  func(c *Config) { c.Snapshot.WritableMethod = "bogus" },
 			wantErr: `invalid snapshot.writable_method: "bogus"`,
 		},
+		{
+			name:    "invalid_time_source",
+			mutate:  func(c *Config) { c.Snapshot.TimeSource = "bogus" },
+			wantErr: `invalid snapshot.time_source: "bogus"`,
+		},
 		{
 			name:    "invalid_stale_action",
 			mutate:  func(c *Config) { c.Kernel.StaleSnapshotAction = "bogus" },
@@ -45,6 +87,130 @@ func TestValidate(t *testing.T) {
 			mutate:  func(c *Config) { c.Snapshot.MaxDepth = -1 },
 			wantErr: "invalid snapshot.max_depth: -1",
 		},
+		{
+			name: "negative_search_directory_depth",
+			mutate: func(c *Config) {
+				c.Snapshot.SearchDirectoryDepths = []SearchDirectoryDepth{{Path: "/.snapshots", MaxDepth: -1}}
+			},
+			wantErr: "invalid snapshot.search_directory_depths max_depth for /.snapshots: -1",
+		},
+		{
+			name:    "negative_backup_retain",
+			mutate:  func(c *Config) { c.Behavior.BackupRetain = -1 },
+			wantErr: "invalid behavior.backup_retain: -1",
+		},
+		{
+			name: "boot_fstype_internal_and_external",
+			mutate: func(c *Config) {
+				c.Fstab.BootInternalFSTypes = []string{"f2fs", "btrfs"}
+				c.Fstab.BootExternalFSTypes = []string{"btrfs"}
+			},
+			wantErr: `invalid fstab.boot_internal_fstypes: "btrfs" is also in fstab.boot_external_fstypes`,
+		},
+		{
+			name:    "unknown_include_type",
+			mutate:  func(c *Config) { c.Snapshot.IncludeTypes = []string{"single", "timeline"} },
+			wantErr: `invalid snapshot.include_types entry: "timeline"`,
+		},
+		{
+			name:    "relative_grub_config_path",
+			mutate:  func(c *Config) { c.GRUB.ConfigPath = "grub/custom.cfg" },
+			wantErr: `invalid grub.config_path: "grub/custom.cfg"`,
+		},
+		{
+			name:    "ignore_marker_path",
+			mutate:  func(c *Config) { c.Snapshot.IgnoreMarker = "etc/refind-ignore" },
+			wantErr: "invalid snapshot.ignore_marker",
+		},
+		{
+			name:    "negative_btrfs_entries_per_snapshot",
+			mutate:  func(c *Config) { c.Kernel.BtrfsEntriesPerSnapshot = -1 },
+			wantErr: "invalid kernel.btrfs_entries_per_snapshot: -1",
+		},
+		{
+			name:    "invalid_microcode_mismatch_action",
+			mutate:  func(c *Config) { c.Kernel.MicrocodeMismatchAction = "update" },
+			wantErr: "invalid kernel.microcode_mismatch_action",
+		},
+		{
+			name:    "negative_timeout_per_snapshot",
+			mutate:  func(c *Config) { c.Behavior.TimeoutPerSnapshot = -time.Second },
+			wantErr: "invalid behavior.timeout_per_snapshot: -1s",
+		},
+		{
+			name: "relative_esp_boot_dir",
+			mutate: func(c *Config) {
+				c.Behavior.CopyBootToESP = Truthy(true)
+				c.Behavior.ESPBootDir = "EFI/snapshots"
+			},
+			wantErr: `invalid behavior.esp_boot_dir: "EFI/snapshots"`,
+		},
+		{
+			name:    "negative_max_options_length",
+			mutate:  func(c *Config) { c.Refind.MaxOptionsLength = -1 },
+			wantErr: "invalid refind.max_options_length: -1",
+		},
+		{
+			name:    "relative_chainload_loader",
+			mutate:  func(c *Config) { c.Refind.ChainloadLoader = "EFI/tools/loader.efi" },
+			wantErr: `invalid refind.chainload_loader: "EFI/tools/loader.efi"`,
+		},
+		{
+			name:    "invalid_source_title_include",
+			mutate:  func(c *Config) { c.Refind.SourceTitleInclude = []string{"Arch (debug"} },
+			wantErr: `invalid refind.source_title_include pattern "Arch (debug"`,
+		},
+		{
+			name:    "invalid_source_title_exclude",
+			mutate:  func(c *Config) { c.Refind.SourceTitleExclude = []string{"[debug"} },
+			wantErr: `invalid refind.source_title_exclude pattern "[debug"`,
+		},
+		{
+			name:    "snapshot_options_without_options",
+			mutate:  func(c *Config) { c.Refind.SnapshotOptions = []SnapshotOptions{{SubvolID: 256}} },
+			wantErr: "invalid refind.snapshot_options entry: options is required",
+		},
+		{
+			name: "snapshot_options_with_both_selectors",
+			mutate: func(c *Config) {
+				c.Refind.SnapshotOptions = []SnapshotOptions{{SubvolID: 256, Description: "debug", Options: "debug"}}
+			},
+			wantErr: "set exactly one of subvolid or description",
+		},
+		{
+			name: "snapshot_options_invalid_description",
+			mutate: func(c *Config) {
+				c.Refind.SnapshotOptions = []SnapshotOptions{{Description: "[debug", Options: "debug"}}
+			},
+			wantErr: `invalid refind.snapshot_options description pattern "[debug"`,
+		},
+		{
+			name:    "invalid_options_template",
+			mutate:  func(c *Config) { c.Advanced.OptionsTemplate = "root=UUID={{.RootUUID" },
+			wantErr: "invalid advanced.options_template",
+		},
+		{
+			name: "kernel_titles_loader_path",
+			mutate: func(c *Config) {
+				c.Advanced.KernelTitles = []KernelTitle{{Loader: "/boot/vmlinuz-custom", Title: "Custom"}}
+			},
+			wantErr: `invalid advanced.kernel_titles loader: "/boot/vmlinuz-custom"`,
+		},
+		{
+			name:    "kernel_titles_empty_title",
+			mutate:  func(c *Config) { c.Advanced.KernelTitles = []KernelTitle{{Loader: "vmlinuz-custom"}} },
+			wantErr: "invalid advanced.kernel_titles title for vmlinuz-custom",
+		},
+		{
+			name:    "invalid_submenu_order",
+			mutate:  func(c *Config) { c.Display.SubmenuOrder = "random" },
+			wantErr: `invalid display.submenu_order: "random"`,
+		},
+		{
+			name:    "invalid_multiple_refind_linux",
+			mutate:  func(c *Config) { c.Refind.MultipleRefindLinux = "last" },
+			wantErr: `invalid refind.multiple_refind_linux: "last"`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -94,6 +260,18 @@ func assertEqualConfig(t *testing.T, want, got Config) {
 		want.Kernel.BootImagePatterns = nil
 		got.Kernel.BootImagePatterns = nil
 	}
+	if len(want.Snapshot.SearchDirectoryDepths) == 0 && len(got.Snapshot.SearchDirectoryDepths) == 0 {
+		want.Snapshot.SearchDirectoryDepths = nil
+		got.Snapshot.SearchDirectoryDepths = nil
+	}
+	if len(want.Refind.SnapshotOptions) == 0 && len(got.Refind.SnapshotOptions) == 0 {
+		want.Refind.SnapshotOptions = nil
+		got.Refind.SnapshotOptions = nil
+	}
+	if len(want.Advanced.KernelTitles) == 0 && len(got.Advanced.KernelTitles) == 0 {
+		want.Advanced.KernelTitles = nil
+		got.Advanced.KernelTitles = nil
+	}
 	assert.Equal(t, want, got)
 }
 
@@ -108,6 +286,52 @@ func TestLoad_FileOverridesDefaults(t *testing.T) {
 	assert.Equal(t, 7, cfg.Snapshot.MaxDepth)
 }
 
+func TestLoad_SearchDirectoryDepths(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(`snapshot:
+  max_depth: 5
+  search_directory_depths:
+    - path: /.snapshots
+      max_depth: 2
+`), 0644))
+
+	cfg, err := Load(cfgPath, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"/.snapshots": 2}, cfg.Snapshot.SearchDirDepths())
+}
+
+func TestLoad_SnapshotOptions(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(`refind:
+  snapshot_options:
+    - subvolid: 412
+      options: "debug loglevel=7"
+    - description: "^pre-upgrade"
+      options: "systemd.unit=rescue.target"
+`), 0644))
+
+	cfg, err := Load(cfgPath, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []SnapshotOptions{
+		{SubvolID: 412, Options: "debug loglevel=7"},
+		{Description: "^pre-upgrade", Options: "systemd.unit=rescue.target"},
+	}, cfg.Refind.SnapshotOptions)
+}
+
+func TestLoad_Duration(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "config.yaml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte("behavior:\n  timeout_per_snapshot: 90s\n"), 0644))
+
+	cfg, err := Load(cfgPath, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, cfg.Behavior.TimeoutPerSnapshot)
+
+	cfg, err = Load(cfgPath, map[string]any{"behavior.timeout_per_snapshot": "2m"})
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, cfg.Behavior.TimeoutPerSnapshot)
+}
+
 func TestLoad_EnvOverridesFile_TopLevelOnly(t *testing.T) {
 	tmp := t.TempDir()
 	cfgPath := filepath.Join(tmp, "config.yaml")
@@ -11,9 +11,17 @@ func Defaults() Config {
 			SelectionCount:    0,
 			DestinationDir:    "/.refind-btrfs-snapshots",
 			WritableMethod:    "toggle",
+			TimeSource:        "auto",
+			SkipIdentical:     false,
+			IgnoreMarker:      ".refind-ignore",
 		},
 		Refind: RefindConfig{
-			ConfigPath: "/EFI/refind/refind.conf",
+			ConfigPath:       "/EFI/refind/refind.conf",
+			MaxOptionsLength: 1024,
+			ChainloadLoader:  "",
+
+			MultipleRefindLinux: "all",
+			PreserveCRLF:        false,
 		},
 		ESP: ESPConfig{
 			UUID:       "",
@@ -23,9 +31,28 @@ func Defaults() Config {
 		Behavior: BehaviorConfig{
 			ExitOnSnapshotBoot:  Truthy(true),
 			CleanupOldSnapshots: Truthy(true),
+			BackupRetain:        5,
+			StateFile:           "/var/lib/refind-btrfs-snapshots/last-run.json",
+			AuditLog:            "",
+			RequireESP:          Truthy(true),
+			CopyBootToESP:       Truthy(false),
+			ESPBootDir:          "/EFI/refind-btrfs-snapshots",
+			TimeoutPerSnapshot:  0,
+			BootReadOnly:        Truthy(false),
+			MatchWritability:    Truthy(false),
+			SetRecoveryDefault:  Truthy(false),
+		},
+		Fstab: FstabConfig{
+			CanonicalOptionOrder: Truthy(false),
 		},
 		Kernel: KernelConfig{
-			StaleSnapshotAction: "delete",
+			StaleSnapshotAction:     "delete",
+			HashFile:                "/var/lib/refind-btrfs-snapshots/boot-hashes.json",
+			UsePackageDB:            Truthy(false),
+			BtrfsFallbackEntries:    Truthy(false),
+			BtrfsEntriesPerSnapshot: 0,
+			MicrocodeMismatchAction: "keep",
+			CheckInitrds:            Truthy(true),
 		},
 		BLS: BLSConfig{
 			WriteEntries: Truthy(false),
@@ -37,13 +64,16 @@ func Defaults() Config {
 			OutputDir:    "/EFI/Linux",
 			EntryPrefix:  "uki-btrfs-snapshots-",
 		},
+		GRUB: GRUBConfig{
+			ConfigPath: "/boot/grub/refind-btrfs-snapshots.cfg",
+		},
 		Advanced: AdvancedConfig{
 			Naming: NamingConfig{
 				RwsnapFormat: "2006-01-02_15-04-05",
 				MenuFormat:   "2006-01-02T15:04:05Z",
 			},
 		},
-		Display:  DisplayConfig{LocalTime: Truthy(false)},
+		Display:  DisplayConfig{LocalTime: Truthy(false), SubmenuOrder: "newest", FallbackMarker: " [fallback]", BucketByAge: Truthy(false)},
 		LogLevel: "info",
 	}
 }
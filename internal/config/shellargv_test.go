@@ -58,6 +58,19 @@ uki:
 		"string form must be shellwords-split into the same argv as the list form")
 }
 
+func TestLoad_MetadataCommand_StringForm(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+snapshot:
+  metadata_command: "snapdb-meta --path {}"
+`), 0o644))
+
+	cfg, err := Load(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snapdb-meta", "--path", "{}"}, cfg.Snapshot.MetadataCommand.Argv())
+}
+
 func TestLoad_SignCommand_AbsentMeansNil(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "cfg.yaml")
@@ -1,6 +1,12 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
 
 // Validate checks the resolved configuration for invalid values.
 // Returning an error here means the program will exit at startup rather
@@ -14,15 +20,125 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid snapshot.writable_method: %q (must be 'toggle' or 'copy')", c.Snapshot.WritableMethod)
 	}
 
+	switch c.Snapshot.TimeSource {
+	case "auto", "snapper", "creation", "mtime":
+	default:
+		return fmt.Errorf("invalid snapshot.time_source: %q (must be one of: auto, snapper, creation, mtime)", c.Snapshot.TimeSource)
+	}
+
 	switch c.Kernel.StaleSnapshotAction {
 	case "warn", "disable", "delete", "fallback":
 	default:
 		return fmt.Errorf("invalid kernel.stale_snapshot_action: %q (must be one of: warn, disable, delete, fallback)", c.Kernel.StaleSnapshotAction)
 	}
 
+	switch c.Kernel.MicrocodeMismatchAction {
+	case "keep", "drop", "pin":
+	default:
+		return fmt.Errorf("invalid kernel.microcode_mismatch_action: %q (must be one of: keep, drop, pin)", c.Kernel.MicrocodeMismatchAction)
+	}
+
+	switch c.Display.SubmenuOrder {
+	case "newest", "oldest":
+	default:
+		return fmt.Errorf("invalid display.submenu_order: %q (must be 'newest' or 'oldest')", c.Display.SubmenuOrder)
+	}
+
+	switch c.Refind.MultipleRefindLinux {
+	case "all", "first", "managed":
+	default:
+		return fmt.Errorf("invalid refind.multiple_refind_linux: %q (must be one of: all, first, managed)", c.Refind.MultipleRefindLinux)
+	}
+
 	if c.Snapshot.MaxDepth < 0 {
 		return fmt.Errorf("invalid snapshot.max_depth: %d (must be >= 0)", c.Snapshot.MaxDepth)
 	}
+	if l := c.Refind.ChainloadLoader; l != "" && !strings.HasPrefix(l, "/") && !strings.HasPrefix(l, `\`) {
+		return fmt.Errorf("invalid refind.chainload_loader: %q (must be an absolute path on the ESP)", l)
+	}
+	for _, d := range c.Snapshot.SearchDirectoryDepths {
+		if d.Path == "" {
+			return fmt.Errorf("invalid snapshot.search_directory_depths entry: path is required")
+		}
+		if d.MaxDepth < 0 {
+			return fmt.Errorf("invalid snapshot.search_directory_depths max_depth for %s: %d (must be >= 0)", d.Path, d.MaxDepth)
+		}
+	}
+	for _, t := range c.Snapshot.IncludeTypes {
+		switch t {
+		case "single", "pre", "post":
+		default:
+			return fmt.Errorf("invalid snapshot.include_types entry: %q (must be one of: single, pre, post)", t)
+		}
+	}
+	if m := c.Snapshot.IgnoreMarker; m == "." || m == ".." || strings.Contains(m, "/") {
+		return fmt.Errorf("invalid snapshot.ignore_marker: %q (must be a file name, not a path)", m)
+	}
+
+	for _, fstype := range c.Fstab.BootInternalFSTypes {
+		if slices.Contains(c.Fstab.BootExternalFSTypes, fstype) {
+			return fmt.Errorf("invalid fstab.boot_internal_fstypes: %q is also in fstab.boot_external_fstypes", fstype)
+		}
+	}
+
+	if c.Behavior.BackupRetain < 0 {
+		return fmt.Errorf("invalid behavior.backup_retain: %d (must be >= 0)", c.Behavior.BackupRetain)
+	}
+
+	if c.Kernel.BtrfsEntriesPerSnapshot < 0 {
+		return fmt.Errorf("invalid kernel.btrfs_entries_per_snapshot: %d (must be >= 0)", c.Kernel.BtrfsEntriesPerSnapshot)
+	}
+
+	if c.Behavior.TimeoutPerSnapshot < 0 {
+		return fmt.Errorf("invalid behavior.timeout_per_snapshot: %s (must be >= 0)", c.Behavior.TimeoutPerSnapshot)
+	}
+
+	if c.Behavior.CopyBootToESP.IsTrue() && !strings.HasPrefix(c.Behavior.ESPBootDir, "/") {
+		return fmt.Errorf("invalid behavior.esp_boot_dir: %q (must be an absolute path on the ESP)", c.Behavior.ESPBootDir)
+	}
+
+	if !strings.HasPrefix(c.GRUB.ConfigPath, "/") {
+		return fmt.Errorf("invalid grub.config_path: %q (must be an absolute path)", c.GRUB.ConfigPath)
+	}
+
+	if c.Refind.MaxOptionsLength < 0 {
+		return fmt.Errorf("invalid refind.max_options_length: %d (must be >= 0)", c.Refind.MaxOptionsLength)
+	}
+
+	for _, pattern := range c.Refind.SourceTitleInclude {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid refind.source_title_include pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range c.Refind.SourceTitleExclude {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid refind.source_title_exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, o := range c.Refind.SnapshotOptions {
+		if strings.TrimSpace(o.Options) == "" {
+			return fmt.Errorf("invalid refind.snapshot_options entry: options is required")
+		}
+		if (o.SubvolID == 0) == (o.Description == "") {
+			return fmt.Errorf("invalid refind.snapshot_options entry for %q: set exactly one of subvolid or description", o.Options)
+		}
+		if _, err := regexp.Compile(o.Description); err != nil {
+			return fmt.Errorf("invalid refind.snapshot_options description pattern %q: %w", o.Description, err)
+		}
+	}
+
+	if _, err := template.New("options_template").Parse(c.Advanced.OptionsTemplate); err != nil {
+		return fmt.Errorf("invalid advanced.options_template: %w", err)
+	}
+	for _, t := range c.Advanced.KernelTitles {
+		if t.Loader == "" || strings.ContainsAny(t.Loader, `/\`) {
+			return fmt.Errorf("invalid advanced.kernel_titles loader: %q (must be a file name, e.g. vmlinuz-linux)", t.Loader)
+		}
+		if t.Title == "" || strings.Contains(t.Title, `"`) {
+			return fmt.Errorf("invalid advanced.kernel_titles title for %s: %q (must be non-empty, without '\"')", t.Loader, t.Title)
+		}
+	}
 
 	return nil
 }
@@ -3,20 +3,33 @@ package diff
 import (
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/progress"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/rs/zerolog/log"
 )
 
-// Apply writes every file diff in the patch through the supplied runner,
-// creating parent directories as needed. Per-file errors are collected and
-// reported in a single joined error so a failure on one file doesn't prevent
-// other files from being written.
+// Apply copies, writes and removes every file in the patch through the
+// supplied runner, creating parent directories as needed. Copies go first so
+// configs never point at files not yet in place. Per-file errors are
+// collected and reported in a single joined error so a failure on one file
+// doesn't prevent other files from being written.
 func Apply(patch *PatchDiff, r runner.Runner) error {
 	var errs []error
 
+	for _, c := range patch.Copies {
+		if err := applyCopy(c, r); err != nil {
+			log.Warn().Err(err).Str("source", c.Source).Str("dest", c.Dest).Msg("Failed to copy file")
+			errs = append(errs, err)
+			continue
+		}
+		log.Info().Str("source", c.Source).Str("dest", c.Dest).Msg("Successfully copied file")
+		progress.FileWritten(c.Dest)
+	}
+
 	for _, fileDiff := range patch.Files {
 		if err := r.MkdirAll(filepath.Dir(fileDiff.Path), 0755, fmt.Sprintf("Create directory for %s", fileDiff.Path)); err != nil {
 			log.Warn().Err(err).Str("path", fileDiff.Path).Msg("Failed to create directory")
@@ -31,10 +44,35 @@ func Apply(patch *PatchDiff, r runner.Runner) error {
 		}
 
 		log.Info().Str("path", fileDiff.Path).Str("type", FileType(fileDiff.Path)).Msg("Successfully updated file")
+		progress.FileWritten(fileDiff.Path)
+	}
+
+	for _, path := range patch.Removals {
+		if err := r.Remove(path, fmt.Sprintf("Remove %s", path)); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to remove file")
+			errs = append(errs, fmt.Errorf("remove %s: %w", path, err))
+			continue
+		}
+		log.Info().Str("path", path).Msg("Successfully removed file")
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("failed to apply %d of %d changes: %w", len(errs), len(patch.Files), errors.Join(errs...))
+		return fmt.Errorf("failed to apply %d of %d changes: %w", len(errs), patch.Changes(), errors.Join(errs...))
+	}
+	return nil
+}
+
+// applyCopy copies c.Source to c.Dest through r.
+func applyCopy(c FileCopy, r runner.Runner) error {
+	content, err := os.ReadFile(c.Source)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", c.Source, err)
+	}
+	if err := r.MkdirAll(filepath.Dir(c.Dest), 0755, fmt.Sprintf("Create directory for %s", c.Dest)); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(c.Dest), err)
+	}
+	if err := r.WriteFile(c.Dest, content, 0644, fmt.Sprintf("Copy %s to %s", c.Source, c.Dest)); err != nil {
+		return fmt.Errorf("copy %s: %w", c.Dest, err)
 	}
 	return nil
 }
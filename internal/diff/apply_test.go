@@ -1,9 +1,13 @@
 package diff
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFileType(t *testing.T) {
@@ -27,3 +31,32 @@ func TestFileType(t *testing.T) {
 		})
 	}
 }
+
+func TestApply_CopiesAndRemovals(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "vmlinuz-linux")
+	dest := filepath.Join(dir, "esp", "1", "linux", "vmlinuz-linux")
+	stale := filepath.Join(dir, "esp", "2")
+	require.NoError(t, os.WriteFile(source, []byte("kernel"), 0644))
+	require.NoError(t, os.MkdirAll(stale, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(stale, "vmlinuz-linux"), nil, 0644))
+
+	patch := NewPatchDiff()
+	patch.AddCopy(source, dest)
+	patch.AddRemoval(filepath.Join(stale, "vmlinuz-linux"))
+	patch.AddRemoval(stale)
+	assert.Equal(t, 3, patch.Changes())
+	assert.Contains(t, patch.Generate(), "Copy "+source+" to "+dest+"\n")
+	assert.Contains(t, patch.Generate(), "Remove "+stale+"\n")
+
+	// Dry runs touch nothing.
+	require.NoError(t, Apply(patch, runner.New(true)))
+	assert.NoFileExists(t, dest)
+	assert.DirExists(t, stale)
+
+	require.NoError(t, Apply(patch, runner.New(false)))
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "kernel", string(content))
+	assert.NoDirExists(t, stale)
+}
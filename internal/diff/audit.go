@@ -0,0 +1,98 @@
+package diff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AuditRecord is one line of the audit log: the files a real run changed,
+// with enough about each to tell what it held before and find its backup.
+type AuditRecord struct {
+	Time  time.Time   `json:"time"`
+	Files []AuditFile `json:"files"`
+	// Copied and Removed are the whole files the patch copied and removed.
+	Copied  []FileCopy `json:"copied,omitempty"`
+	Removed []string   `json:"removed,omitempty"`
+	// Error is set when applying the patch failed part way; some files in
+	// Files may then not have been written.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditFile describes a single changed file in an AuditRecord.
+type AuditFile struct {
+	Path           string `json:"path"`
+	Type           string `json:"type"`
+	New            bool   `json:"new,omitempty"`
+	OriginalSHA256 string `json:"original_sha256,omitempty"` // empty for new files
+	ModifiedSHA256 string `json:"modified_sha256"`
+	// Backup is the copy Backup made of the original, when one exists.
+	Backup string `json:"backup,omitempty"`
+}
+
+// NewAuditRecord describes patch as applied at now, the time also passed to
+// Backup. applyErr is the error Apply returned, if any.
+func NewAuditRecord(patch *PatchDiff, now time.Time, applyErr error) AuditRecord {
+	record := AuditRecord{
+		Time:    now.UTC(),
+		Files:   make([]AuditFile, 0, len(patch.Files)),
+		Copied:  patch.Copies,
+		Removed: patch.Removals,
+	}
+	if applyErr != nil {
+		record.Error = applyErr.Error()
+	}
+	for _, fileDiff := range patch.Files {
+		file := AuditFile{
+			Path:           fileDiff.Path,
+			Type:           FileType(fileDiff.Path),
+			New:            fileDiff.IsNew,
+			ModifiedSHA256: sha256Hex(fileDiff.Modified),
+		}
+		if !fileDiff.IsNew {
+			file.OriginalSHA256 = sha256Hex(fileDiff.Original)
+			if backup := BackupPath(fileDiff.Path, now); fileExists(backup) {
+				file.Backup = backup
+			}
+		}
+		record.Files = append(record.Files, file)
+	}
+	return record
+}
+
+// AppendAuditLog appends record to the JSON-lines audit log at path,
+// creating the file and its directory if needed. Existing lines are never
+// rewritten. It writes directly rather than through a runner: only real
+// applies are audited.
+func AppendAuditLog(path string, record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,81 @@
+package diff
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditRecord(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "refind.conf")
+	created := filepath.Join(dir, "refind-btrfs-snapshots.conf")
+	require.NoError(t, os.WriteFile(existing, []byte("original\n"), 0644))
+
+	patch := &PatchDiff{Files: []*FileDiff{
+		{Path: existing, Original: "original\n", Modified: "modified\n"},
+		{Path: created, Modified: "new\n", IsNew: true},
+	}}
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, Backup(patch, runner.New(false), 0, now))
+
+	record := NewAuditRecord(patch, now, nil)
+	assert.Equal(t, now, record.Time)
+	assert.Empty(t, record.Error)
+	require.Len(t, record.Files, 2)
+
+	assert.Equal(t, AuditFile{
+		Path:           existing,
+		Type:           "refind_config",
+		OriginalSHA256: sha256Hex("original\n"),
+		ModifiedSHA256: sha256Hex("modified\n"),
+		Backup:         existing + ".bak-20250102T030405Z",
+	}, record.Files[0])
+	assert.Equal(t, AuditFile{
+		Path:           created,
+		Type:           "refind_include",
+		New:            true,
+		ModifiedSHA256: sha256Hex("new\n"),
+	}, record.Files[1])
+}
+
+func TestNewAuditRecord_NoBackupAndError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refind.conf")
+	patch := &PatchDiff{Files: []*FileDiff{{Path: path, Original: "a\n", Modified: "b\n"}}}
+
+	record := NewAuditRecord(patch, time.Now(), errors.New("write failed"))
+	assert.Equal(t, "write failed", record.Error)
+	assert.Empty(t, record.Files[0].Backup, "no backup was made")
+}
+
+func TestAppendAuditLog_AppendsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "audit.jsonl")
+	patch := &PatchDiff{Files: []*FileDiff{{Path: "/boot/efi/EFI/refind/refind.conf", Original: "a\n", Modified: "b\n"}}}
+
+	first := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	require.NoError(t, AppendAuditLog(path, NewAuditRecord(patch, first, nil)))
+	require.NoError(t, AppendAuditLog(path, NewAuditRecord(patch, second, nil)))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var times []time.Time
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		times = append(times, record.Time)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []time.Time{first, second}, times)
+}
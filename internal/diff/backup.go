@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+)
+
+// backupTimeFormat sorts lexically in chronological order, which pruning
+// relies on.
+const backupTimeFormat = "20060102T150405Z"
+
+// BackupSuffix is inserted between a file's path and the backup timestamp.
+const BackupSuffix = ".bak-"
+
+// Backup copies every existing file the patch will overwrite to
+// "<path>.bak-<timestamp>" through the supplied runner, then prunes that
+// file's backups down to the newest retain (retain <= 0 keeps all). New files
+// have nothing to back up. Per-file errors are joined like Apply's; callers
+// should not apply the patch if Backup fails.
+func Backup(patch *PatchDiff, r runner.Runner, retain int, now time.Time) error {
+	var errs []error
+
+	for _, fileDiff := range patch.Files {
+		if fileDiff.IsNew {
+			continue
+		}
+
+		info, err := os.Stat(fileDiff.Path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("stat %s: %w", fileDiff.Path, err))
+			continue
+		}
+		content, err := os.ReadFile(fileDiff.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("read %s: %w", fileDiff.Path, err))
+			continue
+		}
+
+		backupPath := BackupPath(fileDiff.Path, now)
+		if err := r.WriteFile(backupPath, content, info.Mode().Perm(), fmt.Sprintf("Back up %s", fileDiff.Path)); err != nil {
+			log.Warn().Err(err).Str("path", backupPath).Msg("Failed to write backup")
+			errs = append(errs, fmt.Errorf("backup %s: %w", fileDiff.Path, err))
+			continue
+		}
+		log.Info().Str("path", fileDiff.Path).Str("backup", backupPath).Msg("Backed up file before modification")
+
+		if err := pruneBackups(fileDiff.Path, backupPath, r, retain); err != nil {
+			// A failed prune leaves clutter but doesn't endanger the write.
+			log.Warn().Err(err).Str("path", fileDiff.Path).Msg("Failed to prune old backups")
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to back up %d of %d files: %w", len(errs), len(patch.Files), errors.Join(errs...))
+	}
+	return nil
+}
+
+// BackupPath returns where Backup copies path when run at now.
+func BackupPath(path string, now time.Time) string {
+	return path + BackupSuffix + now.UTC().Format(backupTimeFormat)
+}
+
+// pruneBackups removes all but the newest retain backups of path. current is
+// included explicitly because under a dry runner it was never written.
+func pruneBackups(path, current string, r runner.Runner, retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+
+	existing, err := filepath.Glob(globEscape(path) + BackupSuffix + "*")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(existing, current) {
+		existing = append(existing, current)
+	}
+	if len(existing) <= retain {
+		return nil
+	}
+
+	slices.Sort(existing)
+	var errs []error
+	for _, old := range existing[:len(existing)-retain] {
+		if err := r.Remove(old, fmt.Sprintf("Prune old backup of %s", path)); err != nil {
+			errs = append(errs, fmt.Errorf("remove %s: %w", old, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// globEscape escapes filepath.Match metacharacters so path is matched
+// literally.
+func globEscape(path string) string {
+	var out []rune
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[', '\\':
+			out = append(out, '\\')
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
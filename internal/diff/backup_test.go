@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup_CopiesExistingFilesAndSkipsNew(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "refind.conf")
+	created := filepath.Join(dir, "refind-btrfs-snapshots.conf")
+	require.NoError(t, os.WriteFile(existing, []byte("original\n"), 0600))
+
+	patch := &PatchDiff{Files: []*FileDiff{
+		{Path: existing, Original: "original\n", Modified: "modified\n"},
+		{Path: created, Modified: "new\n", IsNew: true},
+	}}
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	require.NoError(t, Backup(patch, runner.New(false), 5, now))
+
+	backup := existing + ".bak-20250102T030405Z"
+	content, err := os.ReadFile(backup)
+	require.NoError(t, err)
+	assert.Equal(t, "original\n", string(content))
+
+	info, err := os.Stat(backup)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "backup keeps the original's permissions")
+
+	matches, err := filepath.Glob(created + BackupSuffix + "*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "new files have nothing to back up")
+}
+
+func TestBackup_PrunesToRetainCount(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "refind.conf")
+	require.NoError(t, os.WriteFile(path, []byte("current\n"), 0644))
+	for _, stamp := range []string{"20240101T000000Z", "20240201T000000Z", "20240301T000000Z"} {
+		require.NoError(t, os.WriteFile(path+BackupSuffix+stamp, []byte("old\n"), 0644))
+	}
+
+	patch := &PatchDiff{Files: []*FileDiff{{Path: path, Modified: "next\n"}}}
+	require.NoError(t, Backup(patch, runner.New(false), 2, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	matches, err := filepath.Glob(path + BackupSuffix + "*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		path + BackupSuffix + "20240301T000000Z",
+		path + BackupSuffix + "20250101T000000Z",
+	}, matches)
+}
+
+func TestBackup_ZeroRetainKeepsAll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fstab")
+	require.NoError(t, os.WriteFile(path, []byte("current\n"), 0644))
+	require.NoError(t, os.WriteFile(path+BackupSuffix+"20240101T000000Z", []byte("old\n"), 0644))
+
+	patch := &PatchDiff{Files: []*FileDiff{{Path: path, Modified: "next\n"}}}
+	require.NoError(t, Backup(patch, runner.New(false), 0, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	matches, err := filepath.Glob(path + BackupSuffix + "*")
+	require.NoError(t, err)
+	assert.Len(t, matches, 2)
+}
+
+func TestBackup_DryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "refind.conf")
+	require.NoError(t, os.WriteFile(path, []byte("current\n"), 0644))
+	old := path + BackupSuffix + "20240101T000000Z"
+	require.NoError(t, os.WriteFile(old, []byte("old\n"), 0644))
+
+	patch := &PatchDiff{Files: []*FileDiff{{Path: path, Modified: "next\n"}}}
+	require.NoError(t, Backup(patch, runner.New(true), 1, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	matches, err := filepath.Glob(path + BackupSuffix + "*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{old}, matches)
+}
@@ -11,6 +11,16 @@ import (
 	"golang.org/x/term"
 )
 
+// colorOutput enables ANSI colors in shown diffs. Off with NO_COLOR set in
+// the environment; see SetColor.
+var colorOutput = os.Getenv("NO_COLOR") == ""
+
+// SetColor turns ANSI colors in shown diffs on or off. Diffs written to
+// anything but a terminal are never colored.
+func SetColor(enabled bool) {
+	colorOutput = enabled
+}
+
 // FileDiff represents a diff for a single file
 type FileDiff struct {
 	Path     string
@@ -135,9 +145,24 @@ func generateSimpleDiff(original, modified []string) []string {
 	return result
 }
 
-// PatchDiff represents a unified patch containing multiple file diffs
+// PatchDiff represents a unified patch containing multiple file diffs, plus
+// whole files it copies or removes
 type PatchDiff struct {
 	Files []*FileDiff
+
+	// Copies are files copied verbatim, such as kernel images, which are
+	// listed by path rather than diffed.
+	Copies []FileCopy
+
+	// Removals are paths removed in order, each directory after everything
+	// beneath it.
+	Removals []string
+}
+
+// FileCopy is a file a patch copies from Source to Dest.
+type FileCopy struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
 }
 
 // NewPatchDiff creates a new patch diff
@@ -152,7 +177,28 @@ func (pd *PatchDiff) AddFile(fileDiff *FileDiff) {
 	pd.Files = append(pd.Files, fileDiff)
 }
 
-// Generate creates a unified patch from all file diffs
+// AddCopy adds a file copied from source to dest to the patch
+func (pd *PatchDiff) AddCopy(source, dest string) {
+	pd.Copies = append(pd.Copies, FileCopy{Source: source, Dest: dest})
+}
+
+// AddRemoval adds a path to remove to the patch
+func (pd *PatchDiff) AddRemoval(path string) {
+	pd.Removals = append(pd.Removals, path)
+}
+
+// Changes returns the number of files the patch writes, copies or removes
+func (pd *PatchDiff) Changes() int {
+	return len(pd.Files) + len(pd.Copies) + len(pd.Removals)
+}
+
+// Empty reports whether the patch changes nothing
+func (pd *PatchDiff) Empty() bool {
+	return pd.Changes() == 0
+}
+
+// Generate creates a unified patch from all file diffs, followed by a line
+// for each copied and removed file
 func (pd *PatchDiff) Generate() string {
 	var result strings.Builder
 
@@ -169,6 +215,18 @@ func (pd *PatchDiff) Generate() string {
 		result.WriteString(diff)
 	}
 
+	if len(pd.Copies) > 0 || len(pd.Removals) > 0 {
+		if result.Len() > 0 {
+			result.WriteString("\n")
+		}
+		for _, c := range pd.Copies {
+			result.WriteString(fmt.Sprintf("Copy %s to %s\n", c.Source, c.Dest))
+		}
+		for _, path := range pd.Removals {
+			result.WriteString(fmt.Sprintf("Remove %s\n", path))
+		}
+	}
+
 	return result.String()
 }
 
@@ -283,8 +341,7 @@ func showWithPager(content string) {
 	}
 
 	// Write colorized content to pager
-	colorizedContent := colorizeContent(content)
-	_, _ = stdin.Write([]byte(colorizedContent))
+	_, _ = stdin.Write([]byte(renderContent(content)))
 	_ = stdin.Close()
 
 	// Wait for pager to finish
@@ -293,8 +350,16 @@ func showWithPager(content string) {
 
 // showDirect displays content directly to stdout
 func showDirect(content string) {
-	colorizedContent := colorizeContent(content)
-	fmt.Print(colorizedContent)
+	fmt.Print(renderContent(content))
+}
+
+// renderContent colorizes content when colors are enabled and stdout is a
+// terminal, and returns it as is otherwise.
+func renderContent(content string) string {
+	if !colorOutput || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return content
+	}
+	return colorizeContent(content)
 }
 
 // colorizeContent adds ANSI color codes to diff content
@@ -360,12 +425,12 @@ func ConfirmPatchChanges(patch *PatchDiff, autoApprove bool) bool {
 
 	// Auto-approve if requested
 	if autoApprove {
-		fmt.Printf("Auto-approving changes to %d file(s)\n", len(patch.Files))
+		fmt.Printf("Auto-approving changes to %d file(s)\n", patch.Changes())
 		return true
 	}
 
 	// Ask for confirmation
-	fmt.Printf("Apply changes to %d file(s)? [y/N]: ", len(patch.Files))
+	fmt.Printf("Apply changes to %d file(s)? [y/N]: ", patch.Changes())
 
 	scanner := bufio.NewScanner(os.Stdin)
 	if scanner.Scan() {
@@ -264,3 +264,13 @@ func TestColorizeContent(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderContent_NoColor(t *testing.T) {
+	defer SetColor(colorOutput)
+	SetColor(false)
+
+	content := "+added line\n-removed line\n"
+	if got := renderContent(content); got != content {
+		t.Errorf("renderContent() = %q, want %q", got, content)
+	}
+}
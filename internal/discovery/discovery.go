@@ -5,6 +5,7 @@
 package discovery
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 
@@ -18,58 +19,103 @@ import (
 type ESPOptions struct {
 	// UUID, if set, locates the ESP by filesystem UUID.
 	UUID string
-	// AutoDetect, when true, asks esp.Detector to scan block devices.
+	// AutoDetect, when true, asks esp.Detector to scan block devices,
+	// falling back to the partition named by the firmware's boot entry.
 	AutoDetect bool
 	// MountPoint is a literal fallback path (e.g. "/boot"). Only consulted
-	// when both UUID and AutoDetect are empty/false.
+	// when both UUID and AutoDetect are empty/false. It needn't be a vfat
+	// mount: a directory inside the btrfs root serves layouts without a
+	// separate ESP.
 	MountPoint string
+	// ReadOnly mounts an ESP auto-detection has to mount itself read-only,
+	// for callers that only read it.
+	ReadOnly bool
 }
 
-// ResolveESP returns the mounted, validated ESP path according to opts.
-// Returns an error when no option produces a valid path.
-func ResolveESP(opts ESPOptions) (string, error) {
+// ResolveESP returns the mounted, validated ESP path according to opts,
+// and a function releasing it: an ESP auto-detection mounted is unmounted
+// again, others are left as they were. Returns an error when no option
+// produces a valid path.
+func ResolveESP(opts ESPOptions) (string, func(), error) {
 	detector := esp.NewESPDetector(opts.UUID)
+	detector.SetReadOnlyMount(opts.ReadOnly)
+	path, detected, err := resolveESP(detector, opts)
+	release := func() {
+		if err := detector.Unmount(detected); err != nil {
+			log.Warn().Err(err).Msg("Failed to unmount ESP")
+		}
+	}
+	if err != nil {
+		release()
+		return "", func() {}, err
+	}
+	return path, release, nil
+}
 
+// resolveESP is ResolveESP with detector, returning the detected ESP,
+// which detector may have mounted, even when it fails.
+func resolveESP(detector *esp.ESPDetector, opts ESPOptions) (string, *esp.ESP, error) {
 	if opts.UUID != "" {
 		detected, err := detector.FindESP()
+		if errors.Is(err, esp.ErrESPNotFound) {
+			return "", nil, fmt.Errorf("ESP with UUID %s is not present (is its removable device connected?): %w", opts.UUID, err)
+		}
 		if err != nil {
-			return "", fmt.Errorf("failed to find ESP by UUID %s: %w", opts.UUID, err)
+			return "", nil, fmt.Errorf("failed to find ESP by UUID %s: %w", opts.UUID, err)
 		}
 		if detected.MountPoint == "" {
-			return "", fmt.Errorf("ESP with UUID %s is not mounted", opts.UUID)
+			return "", nil, fmt.Errorf("ESP with UUID %s: %w", opts.UUID, esp.ErrESPNotMounted)
 		}
 		log.Info().Str("path", detected.MountPoint).Str("uuid", opts.UUID).Msg("Found ESP by UUID")
 		if err := detector.ValidateESPPath(detected.MountPoint); err != nil {
-			return "", fmt.Errorf("ESP validation failed: %w", err)
+			return "", detected, fmt.Errorf("ESP validation failed: %w", err)
 		}
-		return detected.MountPoint, nil
+		return detected.MountPoint, detected, nil
 	}
 
 	if opts.AutoDetect {
 		detected, err := detector.FindESP()
+		if errors.Is(err, esp.ErrESPNotFound) || (err == nil && detected.MountPoint == "") {
+			if fromBoot, bootErr := detector.FindESPFromBootEntry(); bootErr == nil {
+				detected, err = fromBoot, nil
+			} else {
+				log.Debug().Err(bootErr).Msg("Could not locate ESP from EFI boot entry")
+			}
+		}
+		if errors.Is(err, esp.ErrESPNotFound) {
+			return "", nil, fmt.Errorf("failed to detect ESP (is its removable device connected? without a separate ESP, set esp.auto_detect to false and esp.mount_point): %w", err)
+		}
 		if err != nil {
-			return "", fmt.Errorf("failed to detect ESP: %w", err)
+			return "", nil, fmt.Errorf("failed to detect ESP: %w", err)
 		}
 		if detected.MountPoint == "" {
-			return "", fmt.Errorf("ESP is not mounted")
+			return "", nil, fmt.Errorf("ESP %s was found but is not mounted: %w", detected.Device, esp.ErrESPNotMounted)
 		}
 		log.Info().Str("path", detected.MountPoint).Msg("Auto-detected ESP path")
 		if err := detector.ValidateESPPath(detected.MountPoint); err != nil {
-			return "", fmt.Errorf("ESP validation failed: %w", err)
+			return "", detected, fmt.Errorf("ESP validation failed: %w", err)
 		}
-		return detected.MountPoint, nil
+		return detected.MountPoint, detected, nil
 	}
 
 	if mp := opts.MountPoint; mp != "" {
 		log.Info().Str("path", mp).Msg("Using configured ESP path")
 		fallback := esp.NewESPDetector("")
 		if err := fallback.ValidateESPPath(mp); err != nil {
-			return "", fmt.Errorf("ESP validation failed: %w", err)
+			return "", nil, fmt.Errorf("ESP validation failed: %w", err)
+		}
+		if fstype, err := esp.PathFSType(mp); err != nil {
+			log.Debug().Err(err).Str("path", mp).Msg("Could not determine ESP filesystem type")
+		} else if fstype == "btrfs" {
+			// Everything written here lands in the root filesystem, and
+			// therefore in later snapshots, and rEFInd needs its btrfs
+			// driver to read it.
+			log.Info().Str("path", mp).Msg("ESP path is a directory on btrfs rather than a separate partition")
 		}
-		return mp, nil
+		return mp, nil, nil
 	}
 
-	return "", fmt.Errorf("ESP path not configured and auto-detection disabled")
+	return "", nil, fmt.Errorf("ESP path not configured and auto-detection disabled")
 }
 
 // StandardScanDirs returns the canonical ESP-relative locations to scan
@@ -102,8 +148,13 @@ func ScanBootImages(scanner *kernel.Scanner, espPath string) []*kernel.BootImage
 // inspect, and assemble boot sets. Returns the assembled sets and the
 // resolved ESP path so callers can reuse it. Returns (nil, "") on any
 // fatal error (ESP not found, no images, etc.) so callers can degrade.
+// The ESP is only read: one it has to mount is mounted read-only and
+// unmounted again before returning, so the returned path is only usable
+// when the ESP was already mounted.
 func DetectBootSets(opts ESPOptions, patterns []kernel.PatternConfig) ([]*kernel.BootSet, string) {
-	espPath, err := ResolveESP(opts)
+	opts.ReadOnly = true
+	espPath, release, err := ResolveESP(opts)
+	defer release()
 	if err != nil {
 		log.Debug().Err(err).Msg("Could not detect ESP for boot set discovery")
 		return nil, ""
@@ -0,0 +1,225 @@
+package esp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// efiGlobalVariableGUID is the vendor GUID of the Boot#### and BootCurrent
+// variables, as it appears in efivarfs file names.
+const efiGlobalVariableGUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// defaultEFIVarsDir is where efivarfs is mounted.
+const defaultEFIVarsDir = "/sys/firmware/efi/efivars"
+
+// defaultBootEntryMountDir is where an unmounted ESP found through the
+// firmware's boot entry is mounted.
+const defaultBootEntryMountDir = "/run/refind-btrfs-snapshots/esp"
+
+// FindESPFromBootEntry locates the ESP through the firmware's boot entries:
+// the hard drive node of the entry the system booted from (BootCurrent,
+// else the first of BootOrder) names the partition's GUID, which is matched
+// against the block devices' PARTUUIDs. An unmounted partition is mounted
+// at /run/refind-btrfs-snapshots/esp, read-only with SetReadOnlyMount, and
+// the returned ESP is Mounted: the caller unmounts it with Unmount once
+// done. This is the fallback when scanning block devices finds no mounted
+// ESP.
+func (d *ESPDetector) FindESPFromBootEntry() (*ESP, error) {
+	partUUID, err := readBootPartitionGUID(d.efivarsDir)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("partuuid", partUUID).Msg("Read boot partition from EFI boot entry")
+
+	devices, err := d.getBlockDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block devices: %w", err)
+	}
+	device := deviceForPartUUID(devices, partUUID)
+	if device == nil {
+		return nil, fmt.Errorf("no block device has the boot entry's partition GUID %s: %w", partUUID, ErrESPNotFound)
+	}
+
+	found := &ESP{
+		Device:     device.Name,
+		UUID:       device.UUID,
+		MountPoint: device.Mountpoint,
+		Size:       device.Size,
+		Label:      device.PARTLABEL,
+	}
+	if found.MountPoint == "" {
+		if err := os.MkdirAll(d.bootEntryMountDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to mount ESP %s: %w", device.Name, err)
+		}
+		if err := d.mount(device.Name, d.bootEntryMountDir, d.readOnlyMount); err != nil {
+			return nil, fmt.Errorf("failed to mount ESP %s: %w", device.Name, err)
+		}
+		log.Info().
+			Str("device", device.Name).
+			Str("mountpoint", d.bootEntryMountDir).
+			Bool("read_only", d.readOnlyMount).
+			Msg("Mounted ESP")
+		found.MountPoint = d.bootEntryMountDir
+		found.Mounted = true
+	}
+
+	log.Info().
+		Str("device", found.Device).
+		Str("mountpoint", found.MountPoint).
+		Str("partuuid", partUUID).
+		Msg("Found EFI System Partition from EFI boot entry")
+	return found, nil
+}
+
+// deviceForPartUUID returns the device whose PARTUUID is partUUID.
+func deviceForPartUUID(devices []*BlockDevice, partUUID string) *BlockDevice {
+	for _, device := range devices {
+		if device.PARTUUID != "" && strings.EqualFold(device.PARTUUID, partUUID) {
+			return device
+		}
+	}
+	return nil
+}
+
+// Unmount unmounts an ESP FindESPFromBootEntry mounted. Other ESPs are
+// left alone.
+func (d *ESPDetector) Unmount(e *ESP) error {
+	if e == nil || !e.Mounted {
+		return nil
+	}
+	if err := d.unmount(e.MountPoint); err != nil {
+		return fmt.Errorf("failed to unmount ESP %s: %w", e.Device, err)
+	}
+	e.Mounted = false
+	log.Debug().Str("device", e.Device).Str("mountpoint", e.MountPoint).Msg("Unmounted ESP")
+	return nil
+}
+
+// execMount mounts device as vfat at dir, read-only if readOnly.
+func execMount(device, dir string, readOnly bool) error {
+	args := []string{"-t", "vfat"}
+	if readOnly {
+		args = append(args, "-o", "ro")
+	}
+	args = append(args, device, dir)
+	if output, err := exec.Command("mount", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// execUnmount unmounts dir.
+func execUnmount(dir string) error {
+	if output, err := exec.Command("umount", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("umount: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readBootPartitionGUID returns the GPT partition GUID in the hard drive
+// node of the current boot entry, falling back to the first entry in
+// BootOrder when BootCurrent isn't set.
+func readBootPartitionGUID(efivarsDir string) (string, error) {
+	var candidates []uint16
+	if data, err := readEFIVar(efivarsDir, "BootCurrent"); err == nil && len(data) >= 2 {
+		candidates = append(candidates, binary.LittleEndian.Uint16(data))
+	}
+	if data, err := readEFIVar(efivarsDir, "BootOrder"); err == nil && len(data) >= 2 {
+		candidates = append(candidates, binary.LittleEndian.Uint16(data))
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no EFI boot entry to read in %s: %w", efivarsDir, ErrESPNotFound)
+	}
+
+	var errs []error
+	for _, num := range candidates {
+		name := fmt.Sprintf("Boot%04X", num)
+		data, err := readEFIVar(efivarsDir, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		guid, err := loadOptionPartitionGUID(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		return guid, nil
+	}
+	return "", fmt.Errorf("no partition in EFI boot entries: %w: %w", ErrESPNotFound, errors.Join(errs...))
+}
+
+// readEFIVar reads an EFI global variable from efivarfs, dropping the
+// leading 4-byte attributes.
+func readEFIVar(efivarsDir, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(efivarsDir, name+"-"+efiGlobalVariableGUID))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("EFI variable %s is truncated", name)
+	}
+	return data[4:], nil
+}
+
+// loadOptionPartitionGUID walks the device path of an EFI_LOAD_OPTION
+// (UEFI spec 3.1.3) for its hard drive media node and returns the GPT
+// partition signature as a lowercase PARTUUID.
+func loadOptionPartitionGUID(option []byte) (string, error) {
+	// Attributes (4), FilePathListLength (2), then a NUL-terminated UCS-2
+	// description.
+	if len(option) < 6 {
+		return "", errors.New("load option is truncated")
+	}
+	pathLen := int(binary.LittleEndian.Uint16(option[4:6]))
+	pos := 6
+	for ; pos+1 < len(option); pos += 2 {
+		if option[pos] == 0 && option[pos+1] == 0 {
+			break
+		}
+	}
+	pos += 2
+	if pos+pathLen > len(option) {
+		return "", errors.New("load option device path is truncated")
+	}
+	path := option[pos : pos+pathLen]
+
+	for len(path) >= 4 {
+		nodeType, subType := path[0], path[1]
+		nodeLen := int(binary.LittleEndian.Uint16(path[2:4]))
+		if nodeLen < 4 || nodeLen > len(path) {
+			return "", errors.New("malformed device path node")
+		}
+		if nodeType == 0x7f && subType == 0xff {
+			break
+		}
+		// Media (0x04) / Hard Drive (0x01): partition number (4), start (8),
+		// size (8), signature (16), MBR type (1), signature type (1).
+		if nodeType == 0x04 && subType == 0x01 && nodeLen >= 42 {
+			if signatureType := path[41]; signatureType != 0x02 {
+				return "", errors.New("boot partition is not on a GPT disk")
+			}
+			return formatGUID(path[24:40]), nil
+		}
+		path = path[nodeLen:]
+	}
+	return "", errors.New("load option has no hard drive node")
+}
+
+// formatGUID renders an EFI GUID, whose first three fields are little
+// endian, in the usual lowercase text form.
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16])
+}
@@ -0,0 +1,134 @@
+package esp
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// espPartUUID is the PARTUUID the test load options point at; its GUID
+// bytes are in EFI's mixed-endian layout below.
+const espPartUUID = "0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9"
+
+var espPartSignature = []byte{
+	0x3d, 0x2c, 0x1b, 0x0a, 0x5f, 0x4e, 0x71, 0x60,
+	0x82, 0x93, 0xa4, 0xb5, 0xc6, 0xd7, 0xe8, 0xf9,
+}
+
+// loadOption builds an EFI_LOAD_OPTION booting \EFI\refind\refind_x64.efi
+// from the GPT partition with the given signature.
+func loadOption(description string, signature []byte) []byte {
+	hd := make([]byte, 42)
+	hd[0], hd[1] = 0x04, 0x01
+	binary.LittleEndian.PutUint16(hd[2:], 42)
+	binary.LittleEndian.PutUint32(hd[4:], 1)
+	copy(hd[24:40], signature)
+	hd[40], hd[41] = 0x02, 0x02
+
+	file := []byte{0x04, 0x04, 0, 0}
+	for _, c := range utf16.Encode([]rune(`\EFI\refind\refind_x64.efi` + "\x00")) {
+		file = binary.LittleEndian.AppendUint16(file, c)
+	}
+	binary.LittleEndian.PutUint16(file[2:], uint16(len(file)))
+
+	path := append(append(hd, file...), 0x7f, 0xff, 0x04, 0x00)
+
+	option := binary.LittleEndian.AppendUint32(nil, 1)
+	option = binary.LittleEndian.AppendUint16(option, uint16(len(path)))
+	for _, c := range utf16.Encode([]rune(description + "\x00")) {
+		option = binary.LittleEndian.AppendUint16(option, c)
+	}
+	return append(option, path...)
+}
+
+func writeEFIVar(t *testing.T, dir, name string, data []byte) {
+	t.Helper()
+	content := append([]byte{0x07, 0, 0, 0}, data...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+"-"+efiGlobalVariableGUID), content, 0644))
+}
+
+func TestLoadOptionPartitionGUID(t *testing.T) {
+	guid, err := loadOptionPartitionGUID(loadOption("rEFInd", espPartSignature))
+	require.NoError(t, err)
+	assert.Equal(t, espPartUUID, guid)
+
+	t.Run("no_hard_drive_node", func(t *testing.T) {
+		option := binary.LittleEndian.AppendUint32(nil, 1)
+		option = binary.LittleEndian.AppendUint16(option, 4)
+		option = append(option, 0, 0, 0x7f, 0xff, 0x04, 0x00)
+		_, err := loadOptionPartitionGUID(option)
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		option := loadOption("rEFInd", espPartSignature)
+		_, err := loadOptionPartitionGUID(option[:len(option)-10])
+		assert.Error(t, err)
+	})
+}
+
+func TestReadBootPartitionGUID(t *testing.T) {
+	t.Run("boot_current", func(t *testing.T) {
+		dir := t.TempDir()
+		writeEFIVar(t, dir, "BootCurrent", []byte{0x0a, 0x00})
+		writeEFIVar(t, dir, "BootOrder", []byte{0x01, 0x00, 0x0a, 0x00})
+		writeEFIVar(t, dir, "Boot0001", loadOption("Other", make([]byte, 16)))
+		writeEFIVar(t, dir, "Boot000A", loadOption("rEFInd", espPartSignature))
+
+		guid, err := readBootPartitionGUID(dir)
+		require.NoError(t, err)
+		assert.Equal(t, espPartUUID, guid)
+	})
+
+	t.Run("falls_back_to_boot_order", func(t *testing.T) {
+		dir := t.TempDir()
+		writeEFIVar(t, dir, "BootOrder", []byte{0x03, 0x00})
+		writeEFIVar(t, dir, "Boot0003", loadOption("rEFInd", espPartSignature))
+
+		guid, err := readBootPartitionGUID(dir)
+		require.NoError(t, err)
+		assert.Equal(t, espPartUUID, guid)
+	})
+
+	t.Run("no_efivars", func(t *testing.T) {
+		_, err := readBootPartitionGUID(t.TempDir())
+		assert.ErrorIs(t, err, ErrESPNotFound)
+	})
+}
+
+func TestDeviceForPartUUID(t *testing.T) {
+	devices := []*BlockDevice{
+		{Name: "/dev/sda1"},
+		{Name: "/dev/sda2", PARTUUID: "11111111-2222-3333-4444-555555555555"},
+		{Name: "/dev/nvme0n1p1", PARTUUID: "0A1B2C3D-4E5F-6071-8293-A4B5C6D7E8F9"},
+	}
+	device := deviceForPartUUID(devices, espPartUUID)
+	require.NotNil(t, device)
+	assert.Equal(t, "/dev/nvme0n1p1", device.Name)
+	assert.Nil(t, deviceForPartUUID(devices, "ffffffff-ffff-ffff-ffff-ffffffffffff"))
+}
+
+func TestUnmount(t *testing.T) {
+	d := NewESPDetector("")
+	var unmounted []string
+	d.unmount = func(dir string) error {
+		unmounted = append(unmounted, dir)
+		return nil
+	}
+
+	require.NoError(t, d.Unmount(&ESP{Device: "/dev/sda1", MountPoint: "/boot/efi"}))
+	assert.Empty(t, unmounted, "an ESP the detector didn't mount is left alone")
+
+	mounted := &ESP{Device: "/dev/sda1", MountPoint: defaultBootEntryMountDir, Mounted: true}
+	require.NoError(t, d.Unmount(mounted))
+	assert.Equal(t, []string{defaultBootEntryMountDir}, unmounted)
+	assert.False(t, mounted.Mounted)
+
+	require.NoError(t, d.Unmount(mounted))
+	assert.Len(t, unmounted, 1, "unmounting twice is a no-op")
+}
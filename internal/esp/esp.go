@@ -12,6 +12,13 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrESPNotMounted is returned when the ESP was found but has no mount point.
+var ErrESPNotMounted = errors.New("ESP is not mounted")
+
+// ErrESPNotFound is returned when no EFI System Partition is present at all,
+// e.g. because the removable device holding it is unplugged.
+var ErrESPNotFound = errors.New("no EFI System Partition found")
+
 // ESP represents an EFI System Partition
 type ESP struct {
 	Device     string `json:"device"`
@@ -19,20 +26,44 @@ type ESP struct {
 	MountPoint string `json:"mountpoint"`
 	Size       string `json:"size"`
 	Label      string `json:"label"`
+
+	// Mounted is set when the detector mounted the ESP itself; release it
+	// with ESPDetector.Unmount.
+	Mounted bool `json:"-"`
 }
 
 // ESPDetector handles ESP detection
 type ESPDetector struct {
-	forceUUID string
+	forceUUID         string
+	efivarsDir        string
+	bootEntryMountDir string
+
+	// readOnlyMount mounts an unmounted ESP read-only; see
+	// SetReadOnlyMount.
+	readOnlyMount bool
+
+	// mount and unmount run mount(8) and umount(8); replaced in tests.
+	mount   func(device, dir string, readOnly bool) error
+	unmount func(dir string) error
 }
 
 // NewESPDetector creates a new ESP detector
 func NewESPDetector(forceUUID string) *ESPDetector {
 	return &ESPDetector{
-		forceUUID: forceUUID,
+		forceUUID:         forceUUID,
+		efivarsDir:        defaultEFIVarsDir,
+		bootEntryMountDir: defaultBootEntryMountDir,
+		mount:             execMount,
+		unmount:           execUnmount,
 	}
 }
 
+// SetReadOnlyMount makes FindESPFromBootEntry mount an unmounted ESP
+// read-only, for runs that only read it (dry runs, checks and listings).
+func (d *ESPDetector) SetReadOnlyMount(readOnly bool) {
+	d.readOnlyMount = readOnly
+}
+
 // FindESP detects the EFI System Partition
 func (d *ESPDetector) FindESP() (*ESP, error) {
 	log.Debug().Msg("Detecting EFI System Partition")
@@ -64,7 +95,7 @@ func (d *ESPDetector) FindESP() (*ESP, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no EFI System Partition found")
+	return nil, ErrESPNotFound
 }
 
 // BlockDevice represents a block device from lsblk output
@@ -169,6 +200,52 @@ func parseMounts(r io.Reader) (map[string]*Mount, error) {
 	return mounts, scanner.Err()
 }
 
+// PathFSType returns the type of the filesystem holding path, e.g. "vfat"
+// for a real ESP or "btrfs" for an ESP directory inside the root
+// filesystem, with no separate partition.
+func PathFSType(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return pathFSType(file, resolved)
+}
+
+// pathFSType returns the type of the mount in /proc/mounts content r with
+// the longest mount point containing path; later mounts shadow earlier ones
+// at the same point.
+func pathFSType(r io.Reader, path string) (string, error) {
+	var fstype, longest string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		// Spaces in mount points are octal-escaped.
+		mountPoint := strings.ReplaceAll(fields[1], `\040`, " ")
+		rel, err := filepath.Rel(mountPoint, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if len(mountPoint) >= len(longest) {
+			fstype, longest = fields[2], mountPoint
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if longest == "" {
+		return "", fmt.Errorf("no mount contains %s", path)
+	}
+	return fstype, nil
+}
+
 // readPartitions reads partition information from /proc/partitions
 func (d *ESPDetector) readPartitions() ([]*Partition, error) {
 	file, err := os.Open("/proc/partitions")
@@ -366,7 +443,7 @@ func (d *ESPDetector) GetESPMountPoint() (string, error) {
 	}
 
 	if esp.MountPoint == "" {
-		return "", fmt.Errorf("ESP is not mounted")
+		return "", ErrESPNotMounted
 	}
 
 	return esp.MountPoint, nil
@@ -381,7 +458,7 @@ func (d *ESPDetector) ValidateESPAccess() error {
 	}
 
 	if esp.MountPoint == "" {
-		return fmt.Errorf("ESP is not mounted")
+		return ErrESPNotMounted
 	}
 
 	return d.ValidateESPPath(esp.MountPoint)
@@ -397,7 +474,7 @@ func (d *ESPDetector) ValidateESPPath(espPath string) error {
 	info, err := os.Stat(espPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("ESP mount point %s does not exist", espPath)
+			return fmt.Errorf("ESP mount point %s does not exist: %w", espPath, ErrESPNotMounted)
 		}
 		return fmt.Errorf("ESP mount point %s is not accessible: %w", espPath, err)
 	}
@@ -1,6 +1,7 @@
 package esp
 
 import (
+	"errors"
 	"path/filepath"
 	"testing"
 )
@@ -66,6 +67,18 @@ func TestESPDetector_GetESPMountPoint(t *testing.T) {
 	}
 }
 
+func TestESPDetector_ValidateESPPath_MissingIsNotMounted(t *testing.T) {
+	detector := NewESPDetector("")
+
+	err := detector.ValidateESPPath(filepath.Join(t.TempDir(), "usb-esp"))
+	if !errors.Is(err, ErrESPNotMounted) {
+		t.Errorf("ValidateESPPath() on a missing mount point = %v, want ErrESPNotMounted", err)
+	}
+	if err := detector.ValidateESPPath(t.TempDir()); err != nil {
+		t.Errorf("ValidateESPPath() on an existing directory = %v, want nil", err)
+	}
+}
+
 // Removed TestESPDetector_findESPByUUID as it tests private methods
 
 // Removed TestESPDetector_parseMount as it tests private methods
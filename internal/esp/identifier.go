@@ -1,6 +1,7 @@
 package esp
 
 import (
+	"slices"
 	"strings"
 )
 
@@ -45,6 +46,11 @@ type DeviceIdentifiers struct {
 	Label     string
 	PartLabel string
 	Device    string
+
+	// Aliases are other paths to the same block device, e.g. the /dev/dm-N
+	// node and /dev/mapper or /dev/disk/by-id/dm-* links of an LVM or
+	// dm-crypt volume. They match DEVICE specs like Device does.
+	Aliases []string
 }
 
 // MatchesSpec checks if these identifiers match the given device specification
@@ -59,7 +65,7 @@ func (d *DeviceIdentifiers) MatchesSpec(spec *DeviceSpec) bool {
 	case "PARTLABEL":
 		return d.PartLabel != "" && d.PartLabel == spec.Value
 	case "DEVICE":
-		return d.Device == spec.Value
+		return d.Device == spec.Value || slices.Contains(d.Aliases, spec.Value)
 	default:
 		return false
 	}
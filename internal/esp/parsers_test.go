@@ -8,6 +8,32 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func TestPathFSType(t *testing.T) {
+	input := `proc /proc proc rw 0 0
+/dev/nvme0n1p2 / btrfs rw,subvol=/@ 0 0
+/dev/nvme0n1p1 /boot/efi vfat rw 0 0
+/dev/sda1 /mnt/my\040disk ext4 rw 0 0
+`
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/boot/efi", "vfat"},
+		{"/boot/efi/EFI/refind", "vfat"},
+		{"/boot", "btrfs"},
+		{"/boot/efi-old", "btrfs"},
+		{"/mnt/my disk/esp", "ext4"},
+	}
+	for _, tt := range tests {
+		got, err := pathFSType(strings.NewReader(input), tt.path)
+		require.NoError(t, err, "path %q", tt.path)
+		assert.Equal(t, tt.want, got, "path %q", tt.path)
+	}
+
+	_, err := pathFSType(strings.NewReader("/dev/sda1 /mnt ext4 rw 0 0\n"), "/boot")
+	assert.Error(t, err)
+}
+
 func TestParseMounts(t *testing.T) {
 	t.Run("typical_mounts", func(t *testing.T) {
 		input := `proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
@@ -1,6 +1,8 @@
 package fstab
 
 import (
+	"slices"
+
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/rs/zerolog/log"
 )
@@ -12,8 +14,9 @@ type BootMountInfo struct {
 	HasSeparateBootMount bool
 
 	// BootOnSameBtrfs is true if /boot is either:
-	//   - not separately mounted (part of the root btrfs subvolume), or
-	//   - mounted from the same btrfs filesystem as root.
+	//   - not separately mounted (part of the root btrfs subvolume),
+	//   - mounted from the same btrfs filesystem as root, or
+	//   - of a filesystem type in fstab.boot_internal_fstypes.
 	// When true, snapshot boot images are self-contained.
 	BootOnSameBtrfs bool
 
@@ -22,10 +25,22 @@ type BootMountInfo struct {
 	Entry *Entry
 }
 
+// SetBootFSTypes overrides how a /boot mount is classified by filesystem
+// type (fstab.boot_internal_fstypes, fstab.boot_external_fstypes). A /boot
+// of an internal type is treated as part of the snapshot, which boots its
+// own kernels; one of an external type as a separate boot partition, which
+// boots from the ESP, even on the root's btrfs. Other types keep the
+// default: btrfs by whether it is root's filesystem, the rest external.
+func (m *Manager) SetBootFSTypes(internal, external []string) {
+	m.bootInternalFSTypes = internal
+	m.bootExternalFSTypes = external
+}
+
 // AnalyzeBootMount inspects a parsed fstab to determine how /boot is mounted.
 // rootFS is used to check whether a btrfs /boot mount is on the same filesystem
 // as the root subvolume. If rootFS is nil, any btrfs /boot mount is assumed to
-// be on a different filesystem (conservative).
+// be on a different filesystem (conservative). The filesystem types set with
+// SetBootFSTypes take precedence.
 func (m *Manager) AnalyzeBootMount(fstab *Fstab, rootFS *btrfs.Filesystem) *BootMountInfo {
 	if fstab == nil {
 		return &BootMountInfo{BootOnSameBtrfs: true}
@@ -36,6 +51,29 @@ func (m *Manager) AnalyzeBootMount(fstab *Fstab, rootFS *btrfs.Filesystem) *Boot
 			continue
 		}
 
+		switch {
+		case slices.Contains(m.bootInternalFSTypes, entry.FSType):
+			log.Debug().
+				Str("device", entry.Device).
+				Str("fstype", entry.FSType).
+				Msg("Snapshot fstab has /boot on a filesystem type configured as internal (btrfs mode)")
+			return &BootMountInfo{
+				HasSeparateBootMount: true,
+				BootOnSameBtrfs:      true,
+				Entry:                entry,
+			}
+		case slices.Contains(m.bootExternalFSTypes, entry.FSType):
+			log.Debug().
+				Str("device", entry.Device).
+				Str("fstype", entry.FSType).
+				Msg("Snapshot fstab has /boot on a filesystem type configured as external (ESP mode)")
+			return &BootMountInfo{
+				HasSeparateBootMount: true,
+				BootOnSameBtrfs:      false,
+				Entry:                entry,
+			}
+		}
+
 		if entry.FSType != "btrfs" {
 			log.Debug().
 				Str("device", entry.Device).
@@ -0,0 +1,192 @@
+package fstab
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultCrypttabPath is the live system's crypttab.
+const defaultCrypttabPath = "/etc/crypttab"
+
+// mapperDir is where device-mapper names dm-crypt mappings.
+const mapperDir = "/dev/mapper/"
+
+// CrypttabEntry represents a single crypttab entry: the mapper name, the
+// encrypted device it opens, and the optional key file and options.
+type CrypttabEntry struct {
+	Name     string `json:"name"`
+	Device   string `json:"device"`
+	KeyFile  string `json:"key_file,omitempty"`
+	Options  string `json:"options,omitempty"`
+	Original string `json:"original"`
+}
+
+// SetCrypttabPath sets the live crypttab snapshot crypttabs are aligned
+// with. Defaults to /etc/crypttab.
+func (m *Manager) SetCrypttabPath(path string) {
+	m.crypttabPath = path
+}
+
+// ParseCrypttab parses the entries of a crypttab file.
+func (m *Manager) ParseCrypttab(path string) ([]*CrypttabEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open crypttab file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []*CrypttabEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) < 2 {
+			continue
+		}
+		entry := &CrypttabEntry{Name: fields[0], Device: fields[1], Original: line}
+		if len(fields) >= 3 {
+			entry.KeyFile = fields[2]
+		}
+		if len(fields) >= 4 {
+			entry.Options = fields[3]
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading crypttab file: %w", err)
+	}
+	return entries, nil
+}
+
+// UpdateSnapshotCrypttab generates a diff aligning the snapshot's crypttab
+// with the live one when root is on dm-crypt: the snapshot's entry for the
+// root mapping, found by mapper name or encrypted device, gets the live
+// entry's name and device so the initramfs opens the container under the
+// name fstab and the kernel command line expect. Returns nil when root
+// isn't a mapping in the live crypttab, the snapshot has no crypttab or no
+// entry for it, or the entry already matches.
+func (m *Manager) UpdateSnapshotCrypttab(snapshot *btrfs.Snapshot, rootFS *btrfs.Filesystem) (*diff.FileDiff, error) {
+	if snapshot == nil || snapshot.Subvolume == nil {
+		return nil, fmt.Errorf("invalid snapshot provided")
+	}
+
+	live, err := m.rootCryptEntry(rootFS)
+	if err != nil || live == nil {
+		return nil, err
+	}
+
+	crypttabPath := filepath.Join(snapshot.FilesystemPath, "etc", "crypttab")
+	if _, err := os.Stat(crypttabPath); errors.Is(err, os.ErrNotExist) {
+		log.Debug().Str("path", crypttabPath).Msg("Crypttab file does not exist in snapshot")
+		return nil, nil
+	}
+	originalContent, err := os.ReadFile(crypttabPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read original crypttab: %w", err)
+	}
+	entries, err := m.ParseCrypttab(crypttabPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot crypttab: %w", err)
+	}
+
+	var root *CrypttabEntry
+	for _, entry := range entries {
+		if entry.Name == live.Name || strings.EqualFold(entry.Device, live.Device) {
+			root = entry
+			break
+		}
+	}
+	if root == nil {
+		log.Debug().Str("path", crypttabPath).Str("name", live.Name).Msg("Snapshot crypttab has no entry for the root mapping")
+		return nil, nil
+	}
+	if root.Name == live.Name && root.Device == live.Device {
+		log.Debug().Str("path", crypttabPath).Msg("No changes needed in crypttab")
+		return nil, nil
+	}
+
+	log.Debug().
+		Str("path", crypttabPath).
+		Str("name", root.Name).
+		Str("device", root.Device).
+		Str("live_name", live.Name).
+		Str("live_device", live.Device).
+		Msg("Aligning snapshot crypttab root entry with the live one")
+
+	fields := []string{live.Name, live.Device}
+	if root.KeyFile != "" {
+		fields = append(fields, root.KeyFile)
+	}
+	if root.Options != "" {
+		fields = append(fields, root.Options)
+	}
+
+	var content strings.Builder
+	for _, line := range strings.SplitAfter(string(originalContent), "\n") {
+		if strings.TrimSuffix(line, "\n") == root.Original {
+			content.WriteString(strings.Join(fields, " "))
+			if strings.HasSuffix(line, "\n") {
+				content.WriteString("\n")
+			}
+			continue
+		}
+		content.WriteString(line)
+	}
+
+	return &diff.FileDiff{
+		Path:     crypttabPath,
+		Original: string(originalContent),
+		Modified: content.String(),
+		IsNew:    false,
+	}, nil
+}
+
+// rootCryptEntry returns the live crypttab entry whose mapping rootFS is
+// mounted from, or nil when its device isn't a /dev/mapper name in the
+// live crypttab, or there is no live crypttab.
+func (m *Manager) rootCryptEntry(rootFS *btrfs.Filesystem) (*CrypttabEntry, error) {
+	if rootFS == nil {
+		return nil, nil
+	}
+	var names []string
+	for _, device := range append([]string{rootFS.Device}, rootFS.DeviceAliases...) {
+		if name, ok := strings.CutPrefix(device, mapperDir); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	path := m.crypttabPath
+	if path == "" {
+		path = defaultCrypttabPath
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	entries, err := m.ParseCrypttab(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse live crypttab: %w", err)
+	}
+	for _, entry := range entries {
+		for _, name := range names {
+			if entry.Name == name {
+				return entry, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,108 @@
+package fstab
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+)
+
+func TestManager_UpdateSnapshotCrypttab(t *testing.T) {
+	const liveCrypttab = "# <name> <device> <password> <options>\n" +
+		"luks-1111 UUID=1111 none luks,discard\n"
+
+	tests := []struct {
+		name     string
+		device   string
+		aliases  []string
+		snapshot string // "" means no crypttab in the snapshot
+		want     string // "" means no diff
+	}{
+		{
+			name:     "renamed_mapping",
+			device:   "/dev/mapper/luks-1111",
+			snapshot: "# <name> <device> <password> <options>\nluks-old UUID=1111 none luks,discard\nswap /dev/sda3 /dev/urandom swap\n",
+			want:     "# <name> <device> <password> <options>\nluks-1111 UUID=1111 none luks,discard\nswap /dev/sda3 /dev/urandom swap\n",
+		},
+		{
+			name:     "replaced_container",
+			device:   "/dev/dm-0",
+			aliases:  []string{"/dev/mapper/luks-1111"},
+			snapshot: "luks-1111 UUID=0000\n",
+			want:     "luks-1111 UUID=1111\n",
+		},
+		{
+			name:     "already_aligned",
+			device:   "/dev/mapper/luks-1111",
+			snapshot: "luks-1111\tUUID=1111 none luks,discard\n",
+		},
+		{
+			name:     "no_root_entry",
+			device:   "/dev/mapper/luks-1111",
+			snapshot: "home UUID=2222 none luks\n",
+		},
+		{
+			name:   "no_snapshot_crypttab",
+			device: "/dev/mapper/luks-1111",
+		},
+		{
+			name:     "root_not_on_dm_crypt",
+			device:   "/dev/sda2",
+			snapshot: "luks-old UUID=1111 none luks\n",
+		},
+		{
+			name:     "mapping_not_in_live_crypttab",
+			device:   "/dev/mapper/vg-root",
+			snapshot: "luks-old UUID=1111 none luks\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			livePath := filepath.Join(tmpDir, "crypttab")
+			if err := os.WriteFile(livePath, []byte(liveCrypttab), 0644); err != nil {
+				t.Fatalf("write live crypttab: %v", err)
+			}
+			snapshotDir := filepath.Join(tmpDir, "snapshot")
+			if err := os.MkdirAll(filepath.Join(snapshotDir, "etc"), 0755); err != nil {
+				t.Fatalf("create snapshot: %v", err)
+			}
+			snapshotPath := filepath.Join(snapshotDir, "etc", "crypttab")
+			if tt.snapshot != "" {
+				if err := os.WriteFile(snapshotPath, []byte(tt.snapshot), 0644); err != nil {
+					t.Fatalf("write snapshot crypttab: %v", err)
+				}
+			}
+
+			manager := NewManager()
+			manager.SetCrypttabPath(livePath)
+			snapshot := &btrfs.Snapshot{
+				Subvolume:      &btrfs.Subvolume{ID: 256, Path: "/@snapshots/1/snapshot"},
+				FilesystemPath: snapshotDir,
+			}
+			rootFS := &btrfs.Filesystem{UUID: "abcd", Device: tt.device, DeviceAliases: tt.aliases}
+
+			fileDiff, err := manager.UpdateSnapshotCrypttab(snapshot, rootFS)
+			if err != nil {
+				t.Fatalf("UpdateSnapshotCrypttab() error = %v", err)
+			}
+			if tt.want == "" {
+				if fileDiff != nil {
+					t.Errorf("UpdateSnapshotCrypttab() = %q, want no diff", fileDiff.Modified)
+				}
+				return
+			}
+			if fileDiff == nil {
+				t.Fatal("UpdateSnapshotCrypttab() returned nil diff, expected changes")
+			}
+			if fileDiff.Path != snapshotPath {
+				t.Errorf("UpdateSnapshotCrypttab() path = %q, want %q", fileDiff.Path, snapshotPath)
+			}
+			if fileDiff.Modified != tt.want {
+				t.Errorf("UpdateSnapshotCrypttab() modified = %q, want %q", fileDiff.Modified, tt.want)
+			}
+		})
+	}
+}
@@ -293,6 +293,64 @@ UUID=other-uuid / ext4 defaults 0 1`
 	}
 }
 
+func TestManager_UpdateSnapshotFstabDiff_AlreadyMountsSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		want    string // "" means no diff
+	}{
+		{name: "same_path", options: "subvol=/@snapshots/1/snapshot,subvolid=256"},
+		{name: "relative_path", options: "rw,subvol=@snapshots/1/snapshot"},
+		{name: "fs_tree_path", options: "subvol=<FS_TREE>/@snapshots/1/snapshot,subvolid=256"},
+		{
+			name:    "stale_subvolid",
+			options: "subvol=@snapshots/1/snapshot,subvolid=200",
+			want:    "subvol=/@snapshots/1/snapshot,subvolid=256",
+		},
+		{
+			name:    "origin_snapshot",
+			options: "subvol=/@snapshots/0/snapshot,subvolid=200",
+			want:    "subvol=/@snapshots/1/snapshot,subvolid=256",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshotDir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(snapshotDir, "etc"), 0755); err != nil {
+				t.Fatalf("Failed to create test directory: %v", err)
+			}
+			fstabContent := "UUID=12345678-1234-1234-1234-123456789abc / btrfs " + tt.options + " 0 1\n"
+			if err := os.WriteFile(filepath.Join(snapshotDir, "etc", "fstab"), []byte(fstabContent), 0644); err != nil {
+				t.Fatalf("Failed to create test fstab: %v", err)
+			}
+
+			snapshot := &btrfs.Snapshot{
+				Subvolume:      &btrfs.Subvolume{ID: 256, Path: "/@snapshots/1/snapshot"},
+				FilesystemPath: snapshotDir,
+			}
+			rootFS := &btrfs.Filesystem{UUID: "12345678-1234-1234-1234-123456789abc", Device: "/dev/sda2"}
+
+			fileDiff, err := NewManager().UpdateSnapshotFstabDiff(snapshot, rootFS)
+			if err != nil {
+				t.Fatalf("UpdateSnapshotFstabDiff() error = %v", err)
+			}
+			if tt.want == "" {
+				if fileDiff != nil {
+					t.Errorf("UpdateSnapshotFstabDiff() = %q, want no diff", fileDiff.Modified)
+				}
+				return
+			}
+			if fileDiff == nil {
+				t.Fatal("UpdateSnapshotFstabDiff() returned nil diff, expected changes")
+			}
+			if !strings.Contains(fileDiff.Modified, tt.want) {
+				t.Errorf("UpdateSnapshotFstabDiff() modified = %q, want options %q", fileDiff.Modified, tt.want)
+			}
+		})
+	}
+}
+
 func TestManager_UpdateSnapshotFstabDiff_NoFstab(t *testing.T) {
 	snapshot := &btrfs.Snapshot{
 		Subvolume: &btrfs.Subvolume{
@@ -319,6 +377,64 @@ func TestManager_UpdateSnapshotFstabDiff_NoFstab(t *testing.T) {
 	}
 }
 
+func TestManager_StaleSubvolid(t *testing.T) {
+	rootFS := &btrfs.Filesystem{
+		UUID:   "12345678-1234-1234-1234-123456789abc",
+		Device: "/dev/sda2",
+	}
+
+	tests := []struct {
+		name  string
+		fstab string
+		want  string
+	}{
+		{
+			name:  "stale subvolid copied from origin",
+			fstab: "UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=/@,subvolid=256 0 1\n",
+			want:  "256",
+		},
+		{
+			name:  "matching subvolid",
+			fstab: "UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=/@snapshots/1/snapshot,subvolid=300 0 1\n",
+			want:  "",
+		},
+		{
+			name:  "no subvolid",
+			fstab: "UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=/@ 0 1\n",
+			want:  "",
+		},
+		{
+			name:  "stale subvolid on another filesystem",
+			fstab: "UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=/@ 0 1\nUUID=other-uuid /home btrfs subvolid=257 0 2\n",
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshotDir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(snapshotDir, "etc"), 0755); err != nil {
+				t.Fatalf("Failed to create test directory: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(snapshotDir, "etc", "fstab"), []byte(tt.fstab), 0644); err != nil {
+				t.Fatalf("Failed to create test fstab: %v", err)
+			}
+			snapshot := &btrfs.Snapshot{
+				Subvolume:      &btrfs.Subvolume{ID: 300, Path: "/@snapshots/1/snapshot"},
+				FilesystemPath: snapshotDir,
+			}
+
+			got, err := NewManager().StaleSubvolid(snapshot, rootFS)
+			if err != nil {
+				t.Fatalf("StaleSubvolid() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("StaleSubvolid() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestManager_isRootMount(t *testing.T) {
 	rootFS := &btrfs.Filesystem{
 		UUID:      "test-uuid",
@@ -472,6 +588,83 @@ func TestManager_updateRootEntry(t *testing.T) {
 	}
 }
 
+func TestManager_updateRootEntry_CanonicalOptionOrder(t *testing.T) {
+	snapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{
+			ID:   256,
+			Path: "/@snapshots/1/snapshot",
+		},
+	}
+	manager := NewManager()
+	manager.SetCanonicalOptionOrder(true)
+
+	entry := &Entry{Options: "subvol=@,compress=zstd:3,noatime,rw,space_cache=v2"}
+	if !manager.updateRootEntry(entry, snapshot, &btrfs.Filesystem{UUID: "test-uuid"}) {
+		t.Fatal("updateRootEntry() = false, want true")
+	}
+	want := "rw,noatime,compress=zstd:3,space_cache=v2,subvolid=256,subvol=/@snapshots/1/snapshot"
+	if entry.Options != want {
+		t.Errorf("updateRootEntry() options = %v, want %v", entry.Options, want)
+	}
+
+	// Already canonical: nothing to rewrite.
+	if manager.updateRootEntry(entry, snapshot, &btrfs.Filesystem{UUID: "test-uuid"}) {
+		t.Error("updateRootEntry() on canonical options = true, want false")
+	}
+}
+
+func TestCanonicalOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		want    string
+	}{
+		{
+			name:    "sorted with fixed positions",
+			options: "subvol=/@,ssd,defaults,subvolid=256,compress=zstd,ro",
+			want:    "defaults,ro,compress=zstd,ssd,subvolid=256,subvol=/@",
+		},
+		{
+			name:    "overriding options keep their order",
+			options: "relatime,discard=async,noatime,nodiscard",
+			want:    "relatime,noatime,discard=async,nodiscard",
+		},
+		{
+			name:    "rw and ro keep their order",
+			options: "subvol=/@,rw,noatime,ro",
+			want:    "rw,ro,noatime,subvol=/@",
+		},
+		{
+			name:    "other last-wins pairs keep their order",
+			options: "sync,ssd_spread,compress-force=zstd,async,ssd,compress=lzo",
+			want:    "compress-force=zstd,compress=lzo,ssd_spread,ssd,sync,async",
+		},
+		{
+			name:    "duplicates dropped",
+			options: "noatime,ssd,noatime",
+			want:    "noatime,ssd",
+		},
+		{
+			name:    "last duplicate kept",
+			options: "rw,ro,rw",
+			want:    "ro,rw",
+		},
+		{
+			name:    "empty",
+			options: "",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalOptions(tt.options); got != tt.want {
+				t.Errorf("canonicalOptions(%q) = %v, want %v", tt.options, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestManager_updateSubvolOption(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -766,6 +959,7 @@ func TestManager_AnalyzeBootMount(t *testing.T) {
 		name                string
 		fstab               *Fstab
 		rootFS              *btrfs.Filesystem
+		internal, external  []string
 		wantHasSeparateBoot bool
 		wantBootOnSameBtrfs bool
 		wantEntryNil        bool
@@ -856,6 +1050,48 @@ func TestManager_AnalyzeBootMount(t *testing.T) {
 			wantBootOnSameBtrfs: false,
 			wantEntryNil:        false,
 		},
+		{
+			name: "/boot on f2fs configured as internal",
+			fstab: &Fstab{
+				Entries: []*Entry{
+					{Device: "UUID=aaaa-bbbb", Mountpoint: "/", FSType: "btrfs", Options: "subvol=@,defaults"},
+					{Device: "/dev/sda2", Mountpoint: "/boot", FSType: "f2fs", Options: "defaults"},
+				},
+			},
+			rootFS:              &btrfs.Filesystem{UUID: "aaaa-bbbb"},
+			internal:            []string{"f2fs"},
+			wantHasSeparateBoot: true,
+			wantBootOnSameBtrfs: true,
+			wantEntryNil:        false,
+		},
+		{
+			name: "/boot on different btrfs filesystem configured as internal",
+			fstab: &Fstab{
+				Entries: []*Entry{
+					{Device: "UUID=aaaa-bbbb", Mountpoint: "/", FSType: "btrfs", Options: "subvol=@,defaults"},
+					{Device: "UUID=xxxx-yyyy", Mountpoint: "/boot", FSType: "btrfs", Options: "subvol=@boot,defaults"},
+				},
+			},
+			rootFS:              &btrfs.Filesystem{UUID: "aaaa-bbbb"},
+			internal:            []string{"btrfs"},
+			wantHasSeparateBoot: true,
+			wantBootOnSameBtrfs: true,
+			wantEntryNil:        false,
+		},
+		{
+			name: "/boot on same btrfs filesystem configured as external",
+			fstab: &Fstab{
+				Entries: []*Entry{
+					{Device: "UUID=aaaa-bbbb", Mountpoint: "/", FSType: "btrfs", Options: "subvol=@,defaults"},
+					{Device: "UUID=aaaa-bbbb", Mountpoint: "/boot", FSType: "btrfs", Options: "subvol=@boot,defaults"},
+				},
+			},
+			rootFS:              &btrfs.Filesystem{UUID: "aaaa-bbbb"},
+			external:            []string{"btrfs"},
+			wantHasSeparateBoot: true,
+			wantBootOnSameBtrfs: false,
+			wantEntryNil:        false,
+		},
 		{
 			name: "/boot/efi on vfat does not affect /boot detection",
 			fstab: &Fstab{
@@ -884,6 +1120,7 @@ func TestManager_AnalyzeBootMount(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			manager := NewManager()
+			manager.SetBootFSTypes(tt.internal, tt.external)
 			got := manager.AnalyzeBootMount(tt.fstab, tt.rootFS)
 
 			if got.HasSeparateBootMount != tt.wantHasSeparateBoot {
@@ -0,0 +1,76 @@
+package fstab
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// SetCanonicalOptionOrder makes snapshot fstab rewrites arrange the root
+// entry's mount options in canonicalOptions order (fstab.canonical_option_order)
+// instead of preserving their position.
+func (m *Manager) SetCanonicalOptionOrder(enabled bool) {
+	m.canonicalOptionOrder = enabled
+}
+
+// canonicalOptions arranges comma-separated mount options in a stable
+// order: defaults, then rw/ro, then the rest sorted by name ignoring a
+// leading "no", then subvolid and subvol, as the kernel lists btrfs mounts.
+// Options that override each other (rw/ro, atime/noatime/relatime,
+// dev/nodev, sync/async, compress/compress-force, ssd/ssd_spread, ...) sort
+// together and keep their relative order, since the last one wins when
+// mounting. Of exact duplicates only the last is kept, so one written
+// after an overriding option still wins.
+func canonicalOptions(options string) string {
+	var opts []string
+	for opt := range strings.SplitSeq(options, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			opts = append(slices.DeleteFunc(opts, func(o string) bool { return o == opt }), opt)
+		}
+	}
+
+	slices.SortStableFunc(opts, func(a, b string) int {
+		ga, gb := optionGroup(a), optionGroup(b)
+		if ga != gb {
+			return cmp.Compare(ga, gb)
+		}
+		return cmp.Compare(optionSortKey(a), optionSortKey(b))
+	})
+	return strings.Join(opts, ",")
+}
+
+// optionGroup orders the fixed positions of canonicalOptions.
+func optionGroup(opt string) int {
+	name, _, _ := strings.Cut(opt, "=")
+	switch name {
+	case "defaults":
+		return 0
+	case "rw", "ro":
+		return 1
+	case "subvolid":
+		return 3
+	case "subvol":
+		return 4
+	default:
+		return 2
+	}
+}
+
+// optionSortKey is the name options sort by, shared by options that
+// override each other so the sort keeps them in their written order.
+func optionSortKey(opt string) string {
+	name, _, _ := strings.Cut(opt, "=")
+	switch name {
+	case "rw", "ro":
+		return "mode"
+	case "atime", "noatime", "relatime", "norelatime", "strictatime", "nostrictatime":
+		return "atime"
+	case "sync", "async":
+		return "sync"
+	case "compress", "compress-force":
+		return "compress"
+	case "ssd", "nossd", "ssd_spread", "nossd_spread":
+		return "ssd"
+	}
+	return strings.TrimPrefix(name, "no")
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
@@ -40,6 +41,10 @@ func (m *Manager) UpdateSnapshotFstabDiff(snapshot *btrfs.Snapshot, rootFS *btrf
 	modifiedEntries := make(map[string]bool)
 	for _, entry := range fstab.Entries {
 		if m.isRootMount(entry, rootFS) {
+			if m.mountsSnapshot(entry, snapshot) {
+				log.Debug().Str("path", fstabPath).Str("options", entry.Options).Msg("Fstab root entry already mounts the snapshot")
+				continue
+			}
 			if m.updateRootEntry(entry, snapshot, rootFS) {
 				modified = true
 				modifiedEntries[entry.Original] = true
@@ -65,6 +70,38 @@ func (m *Manager) UpdateSnapshotFstabDiff(snapshot *btrfs.Snapshot, rootFS *btrf
 	}, nil
 }
 
+// StaleSubvolid returns the subvolid hardcoded in the root entry of the
+// snapshot's fstab when it isn't the snapshot's own subvolume ID, as when
+// the line was copied from the origin and never rewritten. Mounting by a
+// stale subvolid fails or mounts the wrong subvolume. Returns "" when the
+// fstab is missing, has no subvolid on its root entry, or it matches.
+func (m *Manager) StaleSubvolid(snapshot *btrfs.Snapshot, rootFS *btrfs.Filesystem) (string, error) {
+	if snapshot == nil || snapshot.Subvolume == nil {
+		return "", fmt.Errorf("invalid snapshot provided")
+	}
+
+	fstabPath := btrfs.GetSnapshotFstabPath(snapshot)
+	if _, err := os.Stat(fstabPath); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	fstab, err := m.ParseFstab(fstabPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse snapshot fstab: %w", err)
+	}
+
+	parser := params.NewCommaParameterParser()
+	want := strconv.FormatUint(snapshot.ID, 10)
+	for _, entry := range fstab.Entries {
+		if !m.isRootMount(entry, rootFS) {
+			continue
+		}
+		if id := parser.Extract(entry.Options, "subvolid"); id != "" && id != want {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
 // isRootMount determines if an fstab entry is for the root filesystem
 func (m *Manager) isRootMount(entry *Entry, rootFS *btrfs.Filesystem) bool {
 	if entry.Mountpoint != "/" {
@@ -76,6 +113,27 @@ func (m *Manager) isRootMount(entry *Entry, rootFS *btrfs.Filesystem) bool {
 	return m.deviceMatches(entry.Device, rootFS)
 }
 
+// mountsSnapshot reports whether the root entry already mounts snapshot's
+// own subvolume: its subvol= names the snapshot's path, with or without a
+// leading slash or <FS_TREE>, and its subvolid=, if any, is the snapshot's
+// ID. A snapshot taken from a booted snapshot can carry such an fstab, and
+// it is left exactly as written.
+func (m *Manager) mountsSnapshot(entry *Entry, snapshot *btrfs.Snapshot) bool {
+	parser := params.NewCommaParameterParser()
+	subvol := parser.Extract(entry.Options, "subvol")
+	if subvol == "" || subvolTreePath(subvol) != subvolTreePath(snapshot.Path) {
+		return false
+	}
+	id := parser.Extract(entry.Options, "subvolid")
+	return id == "" || id == strconv.FormatUint(snapshot.ID, 10)
+}
+
+// subvolTreePath returns a subvolume path relative to the top level, as
+// written in subvol= or reported by btrfs.
+func subvolTreePath(path string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimPrefix(path, "/"), "<FS_TREE>"), "/")
+}
+
 // updateRootEntry updates a root mount entry for the snapshot
 func (m *Manager) updateRootEntry(entry *Entry, snapshot *btrfs.Snapshot, rootFS *btrfs.Filesystem) bool {
 	modified := false
@@ -96,6 +154,14 @@ func (m *Manager) updateRootEntry(entry *Entry, snapshot *btrfs.Snapshot, rootFS
 		modified = true
 	}
 
+	if m.canonicalOptionOrder {
+		newOptions = canonicalOptions(entry.Options)
+		if newOptions != entry.Options {
+			entry.Options = newOptions
+			modified = true
+		}
+	}
+
 	return modified
 }
 
@@ -22,7 +22,12 @@ type Fstab struct {
 }
 
 // Manager handles fstab operations
-type Manager struct{}
+type Manager struct {
+	canonicalOptionOrder bool
+	crypttabPath         string
+	bootInternalFSTypes  []string
+	bootExternalFSTypes  []string
+}
 
 // NewManager creates a new fstab manager
 func NewManager() *Manager {
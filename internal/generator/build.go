@@ -1,24 +1,45 @@
 package generator
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/snapshotfs"
 	"github.com/rs/zerolog/log"
 )
 
+// ErrNoBootableEntries is returned by BuildPatch when the rEFInd config has
+// no entry booting the root subvolume to derive snapshot entries from.
+var ErrNoBootableEntries = errors.New("no suitable boot entries found in rEFInd config")
+
 // BuildPatch turns a discovered Plan into a unified patch plus an operation
 // summary: it updates snapshot fstabs, parses the live rEFInd config, writes
 // snapshot entries into matching refind_linux.conf files, and optionally
 // generates the managed include file. Returns an empty patch (and zero-value
 // summary) when there's nothing to write.
 func (p *Pipeline) BuildPatch(plan *Plan) (*diff.PatchDiff, *OperationSummary, error) {
+	patch, summary := p.BuildSnapshotPatch(plan)
+	if p.FstabOnly {
+		return patch, summary, nil
+	}
+	if err := p.AddRefindEntries(plan, patch, summary); err != nil {
+		return nil, nil, err
+	}
+	return patch, summary, nil
+}
+
+// BuildSnapshotPatch is the part of BuildPatch every backend shares: the
+// snapshots' ESP boot copies and fstab and crypttab updates, plus the
+// summary the backend's entries are added to.
+func (p *Pipeline) BuildSnapshotPatch(plan *Plan) (*diff.PatchDiff, *OperationSummary) {
 	patch := diff.NewPatchDiff()
 	summary := &OperationSummary{
 		IncludedSnapshots: make([]string, 0),
@@ -26,8 +47,12 @@ func (p *Pipeline) BuildPatch(plan *Plan) (*diff.PatchDiff, *OperationSummary, e
 		RemovedSnapshots:  plan.Removed,
 		StaleSnapshots:    make([]string, 0),
 		UpdatedFstabs:     make([]string, 0),
+		UpdatedCrypttabs:  make([]string, 0),
 		UpdatedConfigs:    make([]string, 0),
 		WritableChanges:   make([]string, 0),
+		ESPCopies:         make([]string, 0),
+		RemovedESPCopies:  make([]string, 0),
+		TimedOutSnapshots: plan.TimedOut,
 	}
 
 	for _, bp := range plan.BootPlans {
@@ -36,39 +61,134 @@ func (p *Pipeline) BuildPatch(plan *Plan) (*diff.PatchDiff, *OperationSummary, e
 		}
 	}
 
-	for _, u := range snapshotfs.UpdateFstabs(plan.ProcessedSnapshots, plan.RootFS, p.Fstab) {
-		patch.AddFile(u.Diff)
-		summary.UpdatedFstabs = append(summary.UpdatedFstabs, u.Snapshot.Path+"/etc/fstab")
-	}
+	p.addESPChanges(plan, patch, summary)
 
-	refindParser := refind.NewParserWithScanner(p.ESPPath, p.KernelScanner)
-	configPath := p.resolveRefindConfigPath(refindParser)
+	// Read-only boots leave snapshots untouched, fstab included, so only
+	// flag fstabs that would mount the wrong subvolume.
+	for _, v := range plan.volumes() {
+		if p.Cfg.Behavior.BootReadOnly.IsTrue() {
+			snapshotfs.CheckSubvolids(v.Snapshots, v.FS, p.Fstab)
+			continue
+		}
+		for _, u := range snapshotfs.UpdateFstabs(v.Snapshots, v.FS, p.Fstab) {
+			patch.AddFile(u.Diff)
+			summary.UpdatedFstabs = append(summary.UpdatedFstabs, u.Snapshot.Path+"/etc/fstab")
+		}
+		for _, u := range snapshotfs.UpdateCrypttabs(v.Snapshots, v.FS, p.Fstab) {
+			patch.AddFile(u.Diff)
+			summary.UpdatedCrypttabs = append(summary.UpdatedCrypttabs, u.Snapshot.Path+"/etc/crypttab")
+		}
+	}
+	return patch, summary
+}
 
-	config, err := refindParser.ParseConfig(configPath)
+// AddRefindEntries adds plan's rEFInd snapshot entries to patch: it parses
+// the live rEFInd config, writes snapshot entries into matching
+// refind_linux.conf files, and optionally generates the managed include
+// file.
+func (p *Pipeline) AddRefindEntries(plan *Plan, patch *diff.PatchDiff, summary *OperationSummary) error {
+	refindParser, config, err := p.parseRefindConfig()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse rEFInd config: %w", err)
+		return err
 	}
 
-	sourceEntries := bootableEntries(config.Entries, plan.RootFS)
-	if len(sourceEntries) == 0 {
-		return nil, nil, fmt.Errorf("no suitable boot entries found in rEFInd config")
+	sourceEntries, err := p.sourceEntries(config, plan)
+	if err != nil {
+		return err
 	}
+	summary.SourceEntries = sourceEntries
 	log.Info().
 		Int("total_entries", len(config.Entries)).
 		Int("valid_entries", len(sourceEntries)).
 		Msg("Checking valid entries")
 
-	generator := refind.NewGeneratorWithBootPlans(p.ESPPath, p.Cfg.Advanced.Naming.MenuFormat, p.Cfg.Display.LocalTime.IsTrue(), p.KernelScanner, p.BootSets, plan.BootPlans)
+	generator, err := p.newRefindGenerator(plan)
+	if err != nil {
+		return err
+	}
 	refindLinuxEntries, otherEntries := splitSourcesByConfigType(sourceEntries)
 
-	updatedRefindLinuxConf := p.applyRefindLinuxUpdates(generator, refindLinuxEntries, plan, patch, summary)
-	p.maybeApplyManagedConfig(generator, refindParser, configPath, otherEntries, sourceEntries, updatedRefindLinuxConf, plan, patch, summary)
+	updatedRefindLinuxConf, consolidated := p.applyRefindLinuxUpdates(generator, refindLinuxEntries, plan, patch, summary)
+	generator.SetConsolidatedEntries(consolidated)
+	p.maybeApplyManagedConfig(generator, refindParser, config, otherEntries, sourceEntries, consolidated, updatedRefindLinuxConf, plan, patch, summary)
 
 	for _, snapshot := range plan.ProcessedSnapshots {
 		summary.IncludedSnapshots = append(summary.IncludedSnapshots, p.formatSnapshotName(snapshot))
 	}
+	return nil
+}
 
-	return patch, summary, nil
+// snapshotOptions converts refind.snapshot_options for the generator.
+func snapshotOptions(cfg []config.SnapshotOptions) []refind.SnapshotOptions {
+	var out []refind.SnapshotOptions
+	for _, o := range cfg {
+		out = append(out, refind.SnapshotOptions{
+			SubvolID:    o.SubvolID,
+			Description: o.Description,
+			Options:     o.Options,
+		})
+	}
+	return out
+}
+
+// sourceEntries returns the entries of config that boot one of plan's
+// volumes, less excluded kernels and titles: the entries snapshot entries
+// are derived from. Returns ErrNoBootableEntries when none are left.
+func (p *Pipeline) sourceEntries(config *refind.Config, plan *Plan) ([]*refind.MenuEntry, error) {
+	var entries []*refind.MenuEntry
+	for _, v := range plan.volumes() {
+		entries = append(entries, bootableEntries(config.Entries, v.FS)...)
+	}
+	entries = filterKernelEntries(entries, p.Cfg.Kernel.Filter, p.BootSets)
+	entries = excludeKernelEntries(entries, p.ExcludedBootSets)
+	entries, err := filterEntriesByTitle(entries, p.Cfg.Refind.SourceTitleInclude, p.Cfg.Refind.SourceTitleExclude)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoBootableEntries
+	}
+	return entries, nil
+}
+
+// newRefindGenerator returns a rEFInd generator for plan, set up from the
+// config.
+func (p *Pipeline) newRefindGenerator(plan *Plan) (*refind.Generator, error) {
+	generator := refind.NewGeneratorWithBootPlans(p.ESPPath, p.Cfg.Advanced.Naming.MenuFormat, p.Cfg.Display.LocalTime.IsTrue(), p.KernelScanner, p.BootSets, plan.BootPlans)
+	generator.SetOnlyMode(p.OnlyMode)
+	generator.SetVolumes(plan.Volumes)
+	generator.SetSubmenuOrder(p.Cfg.Display.SubmenuOrder)
+	generator.SetFallbackMarker(p.Cfg.Display.FallbackMarker)
+	generator.SetBucketByAge(p.Cfg.Display.BucketByAge.IsTrue())
+	generator.SetMaxOptionsLength(p.Cfg.Refind.MaxOptionsLength)
+	generator.SetBootReadOnly(p.Cfg.Behavior.BootReadOnly.IsTrue())
+	generator.SetMatchWritability(p.Cfg.Behavior.MatchWritability.IsTrue())
+	generator.SetOmitMarkers(p.NoWriteMarkers)
+	generator.SetChainloadLoader(p.Cfg.Refind.ChainloadLoader)
+	generator.SetRecoveryDefault(p.Cfg.Behavior.SetRecoveryDefault.IsTrue())
+	generator.SetPreserveCRLF(p.Cfg.Refind.PreserveCRLF.IsTrue())
+	generator.SetMicrocodeAction(p.Cfg.Kernel.MicrocodeMismatchAction)
+	generator.SetCheckInitrds(p.Cfg.Kernel.CheckInitrds.IsTrue())
+	generator.SetKernelTitles(p.Cfg.Advanced.KernelTitleMap())
+	if err := generator.SetOptionsTemplate(p.Cfg.Advanced.OptionsTemplate); err != nil {
+		return nil, fmt.Errorf("invalid advanced.options_template: %w", err)
+	}
+	if err := generator.SetSnapshotOptions(snapshotOptions(p.Cfg.Refind.SnapshotOptions)); err != nil {
+		return nil, fmt.Errorf("invalid refind.snapshot_options: %w", err)
+	}
+	return generator, nil
+}
+
+// parseRefindConfig locates and parses the live rEFInd config.
+func (p *Pipeline) parseRefindConfig() (*refind.Parser, *refind.Config, error) {
+	refindParser := refind.NewParserWithScanner(p.ESPPath, p.KernelScanner)
+	configPath := p.resolveRefindConfigPath(refindParser)
+
+	config, err := refindParser.ParseConfig(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse rEFInd config: %w", err)
+	}
+	return refindParser, config, nil
 }
 
 // resolveRefindConfigPath picks the rEFInd config file path: auto-detect
@@ -120,24 +240,40 @@ func splitSourcesByConfigType(entries []*refind.MenuEntry) (refindLinux, other [
 	return refindLinux, other
 }
 
+// entryTitles lists entries as "<title> (<source file>)" for logging.
+func entryTitles(entries []*refind.MenuEntry) []string {
+	titles := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		titles = append(titles, fmt.Sprintf("%s (%s)", entry.Title, entry.SourceFile))
+	}
+	return titles
+}
+
 // applyRefindLinuxUpdates writes snapshot entries into each refind_linux.conf
 // file that has at least one source entry matching the root subvolume.
 // Returns true if any file was updated, so the caller can decide whether to
-// also generate the managed include file.
-func (p *Pipeline) applyRefindLinuxUpdates(gen *refind.Generator, refindLinuxEntries []*refind.MenuEntry, plan *Plan, patch *diff.PatchDiff, summary *OperationSummary) bool {
-	rootSubvol := ""
-	if plan.RootFS.Subvolume != nil {
-		rootSubvol = strings.TrimPrefix(plan.RootFS.Subvolume.Path, "/")
+// also generate the managed include file. When several files match,
+// refind.multiple_refind_linux may leave some or all of them out: their
+// previously generated entries are removed, and with "managed" their source
+// entries are returned for the managed include instead. With
+// --refind-linux-only there is no managed include, so "managed" updates
+// every file.
+func (p *Pipeline) applyRefindLinuxUpdates(gen *refind.Generator, refindLinuxEntries []*refind.MenuEntry, plan *Plan, patch *diff.PatchDiff, summary *OperationSummary) (bool, []*refind.MenuEntry) {
+	rootSubvols := make(map[string]bool)
+	for _, v := range plan.volumes() {
+		if v.FS.Subvolume != nil {
+			rootSubvols[strings.TrimPrefix(v.FS.Subvolume.Path, "/")] = true
+		}
 	}
 
-	// Only process entries whose subvol matches the root filesystem so we
+	// Only process entries whose subvol matches a root filesystem so we
 	// don't pick up previously-generated snapshot entries from prior runs.
 	filesByPath := make(map[string][]*refind.MenuEntry)
 	for _, entry := range refindLinuxEntries {
 		if entry.BootOptions == nil || entry.BootOptions.Subvol == "" {
 			continue
 		}
-		if strings.TrimPrefix(entry.BootOptions.Subvol, "/") != rootSubvol {
+		if !rootSubvols[strings.TrimPrefix(entry.BootOptions.Subvol, "/")] {
 			continue
 		}
 		filesByPath[entry.SourceFile] = append(filesByPath[entry.SourceFile], entry)
@@ -149,6 +285,40 @@ func (p *Pipeline) applyRefindLinuxUpdates(gen *refind.Generator, refindLinuxEnt
 	}
 	sort.Strings(paths)
 
+	var skipped []string
+	var consolidated []*refind.MenuEntry
+	if len(paths) > 1 {
+		mode := p.Cfg.Refind.MultipleRefindLinux
+		if mode == "managed" && p.RefindLinuxOnly {
+			log.Warn().Msg("refind.multiple_refind_linux is managed but --refind-linux-only forbids the managed config, updating every refind_linux.conf")
+			mode = "all"
+		}
+		switch mode {
+		case "first":
+			log.Info().Str("updated", paths[0]).Strs("skipped", paths[1:]).Msg("Several refind_linux.conf files match the root volume, updating only the first")
+			paths, skipped = paths[:1], paths[1:]
+		case "managed":
+			log.Info().Strs("skipped", paths).Msg("Several refind_linux.conf files match the root volume, generating the managed config instead")
+			for _, path := range paths {
+				consolidated = append(consolidated, filesByPath[path]...)
+			}
+			paths, skipped = nil, paths
+		}
+	}
+	for _, path := range skipped {
+		// Clear out entries from runs that did update this file.
+		configDiff, err := gen.UpdateRefindLinuxConfWithAllEntries(nil, filesByPath[path], plan.RootFS)
+		if err != nil {
+			log.Error().Err(err).Str("source_file", path).Msg("Failed to clean refind_linux.conf")
+			continue
+		}
+		if configDiff != nil {
+			patch.AddFile(configDiff)
+			summary.UpdatedConfigs = append(summary.UpdatedConfigs, configDiff.Path)
+			recordMenuChanges(gen, configDiff, summary)
+		}
+	}
+
 	updated := false
 	for _, path := range paths {
 		entries := filesByPath[path]
@@ -159,27 +329,41 @@ func (p *Pipeline) applyRefindLinuxUpdates(gen *refind.Generator, refindLinuxEnt
 			log.Error().Err(err).Str("source_file", path).Msg("Failed to update refind_linux.conf")
 			continue
 		}
+		p.selfCheck(gen, path, configDiff, plan, summary)
 		if configDiff == nil {
 			continue
 		}
 
 		patch.AddFile(configDiff)
 		summary.UpdatedConfigs = append(summary.UpdatedConfigs, configDiff.Path)
+		recordMenuChanges(gen, configDiff, summary)
 		updated = true
 		for _, snapshot := range plan.ProcessedSnapshots {
 			summary.AddedSnapshots = append(summary.AddedSnapshots, p.formatSnapshotName(snapshot))
 		}
 	}
-	return updated
+	return updated, consolidated
 }
 
 // maybeApplyManagedConfig writes the refind-btrfs-snapshots.conf include
 // file when needed: either because refind_linux.conf wasn't updated and
-// there are menuentry-style sources, or because the user passed
-// --generate-include explicitly.
-func (p *Pipeline) maybeApplyManagedConfig(gen *refind.Generator, parser *refind.Parser, configPath string, otherEntries, sourceEntries []*refind.MenuEntry, updatedRefindLinuxConf bool, plan *Plan, patch *diff.PatchDiff, summary *OperationSummary) {
+// there are menuentry-style or consolidated refind_linux.conf sources, or
+// because the user passed --generate-include explicitly. Warns when the
+// main config wouldn't make rEFInd read the written file. Never writes it
+// with --refind-linux-only.
+func (p *Pipeline) maybeApplyManagedConfig(gen *refind.Generator, parser *refind.Parser, config *refind.Config, otherEntries, sourceEntries, consolidated []*refind.MenuEntry, updatedRefindLinuxConf bool, plan *Plan, patch *diff.PatchDiff, summary *OperationSummary) {
+	if p.RefindLinuxOnly {
+		if len(otherEntries) > 0 {
+			log.Info().
+				Strs("skipped_entries", entryTitles(otherEntries)).
+				Msg("Skipping managed config generation for menuentry sources (--refind-linux-only)")
+		}
+		return
+	}
+
 	force := p.Cfg.GenerateInclude.IsTrue()
-	shouldGenerate := (!updatedRefindLinuxConf && len(otherEntries) > 0 && len(plan.ProcessedSnapshots) > 0) || force
+	hasSources := len(otherEntries) > 0 || len(consolidated) > 0
+	shouldGenerate := (!updatedRefindLinuxConf && hasSources && len(plan.ProcessedSnapshots) > 0) || force
 
 	if !shouldGenerate {
 		if updatedRefindLinuxConf && len(otherEntries) > 0 {
@@ -190,14 +374,21 @@ func (p *Pipeline) maybeApplyManagedConfig(gen *refind.Generator, parser *refind
 		return
 	}
 
-	managedConfigPath := parser.GetManagedConfigPath(configPath)
+	managedConfigPath := parser.GetManagedConfigPath(config.Path)
+	for _, reason := range refind.CheckManagedInclude(config, managedConfigPath) {
+		log.Warn().
+			Str("config_path", managedConfigPath).
+			Str("reason", reason).
+			Msg("Managed config may not be read by rEFInd")
+	}
+
 	entriesToUse := otherEntries
-	if force && len(otherEntries) == 0 {
+	if force && !hasSources {
 		entriesToUse = sourceEntries
 	}
 
 	log.Info().
-		Int("entries", len(entriesToUse)).
+		Int("entries", len(entriesToUse)+len(consolidated)).
 		Int("snapshots", len(plan.ProcessedSnapshots)).
 		Str("config_path", managedConfigPath).
 		Bool("forced", force).
@@ -208,12 +399,18 @@ func (p *Pipeline) maybeApplyManagedConfig(gen *refind.Generator, parser *refind
 		log.Error().Err(err).Msg("Failed to generate managed config")
 		return
 	}
+	p.selfCheck(gen, managedConfigPath, configDiff, plan, summary)
 	if configDiff == nil {
+		if content, err := os.ReadFile(managedConfigPath); err == nil {
+			warnDefaultSelection(gen, config, managedConfigPath, string(content))
+		}
 		return
 	}
+	warnDefaultSelection(gen, config, managedConfigPath, configDiff.Modified)
 
 	patch.AddFile(configDiff)
 	summary.UpdatedConfigs = append(summary.UpdatedConfigs, configDiff.Path)
+	recordMenuChanges(gen, configDiff, summary)
 	if len(summary.AddedSnapshots) == 0 {
 		for _, snapshot := range plan.ProcessedSnapshots {
 			summary.AddedSnapshots = append(summary.AddedSnapshots, p.formatSnapshotName(snapshot))
@@ -221,6 +418,44 @@ func (p *Pipeline) maybeApplyManagedConfig(gen *refind.Generator, parser *refind
 	}
 }
 
+// selfCheck reads back the content generated for the rEFInd config at
+// path, which is the file as it stands when configDiff is nil, and records
+// each entry that doesn't match its snapshot (--selfcheck).
+func (p *Pipeline) selfCheck(gen *refind.Generator, path string, configDiff *diff.FileDiff, plan *Plan, summary *OperationSummary) {
+	if !p.SelfCheck {
+		return
+	}
+	content := ""
+	if configDiff != nil {
+		content = configDiff.Modified
+	} else if data, err := os.ReadFile(path); err == nil {
+		content = string(data)
+	}
+	summary.SelfCheckedConfigs = append(summary.SelfCheckedConfigs, path)
+	for _, problem := range gen.SelfCheck(path, content, plan.ProcessedSnapshots) {
+		summary.SelfCheckProblems = append(summary.SelfCheckProblems, path+": "+problem)
+	}
+}
+
+// recordMenuChanges adds the menu-level changes configDiff makes to the
+// rEFInd config it updates to summary.
+func recordMenuChanges(gen *refind.Generator, configDiff *diff.FileDiff, summary *OperationSummary) {
+	if changes := gen.MenuDiff(configDiff.Path, configDiff.Original, configDiff.Modified); changes != "" {
+		summary.MenuChanges = append(summary.MenuChanges, changes)
+	}
+}
+
+// warnDefaultSelection warns when the entries in the managed config change
+// what the main config's default_selection picks.
+func warnDefaultSelection(gen *refind.Generator, config *refind.Config, managedConfigPath, content string) {
+	for _, reason := range gen.CheckDefaultSelection(config, managedConfigPath, content) {
+		log.Warn().
+			Str("config_path", config.Path).
+			Str("reason", reason).
+			Msg("Generated entries change rEFInd's default_selection")
+	}
+}
+
 func (p *Pipeline) formatSnapshotName(snapshot *btrfs.Snapshot) string {
 	return btrfs.FormatSnapshotTimeForMenu(snapshot.SnapshotTime, p.Cfg.Advanced.Naming.MenuFormat, p.Cfg.Display.LocalTime.IsTrue())
 }
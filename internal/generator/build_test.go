@@ -3,11 +3,14 @@ package generator
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/stretchr/testify/assert"
@@ -91,6 +94,77 @@ func TestResolveRefindConfigPath(t *testing.T) {
 	})
 }
 
+// archRefindConf is a refind.conf whose one menuentry boots the
+// buildPatchFixture root filesystem.
+const archRefindConf = `menuentry "Arch Linux" {
+    loader /vmlinuz-linux
+    options "root=UUID=test-uuid rootflags=subvol=@ rw quiet"
+}
+`
+
+// rootFstab is a snapshot fstab mounting the buildPatchFixture root subvolume.
+const rootFstab = "UUID=test-uuid / btrfs rw,subvol=@ 0 0\n"
+
+// buildPatchFixture is a temp-dir ESP and snapshot tree with a dry-run
+// Pipeline and a Plan holding the one snapshot of the test-uuid root.
+type buildPatchFixture struct {
+	esp          string
+	snapshotPath string
+	pipeline     *Pipeline
+	plan         *Plan
+}
+
+// newBuildPatchFixture writes refindConf to the ESP's refind.conf and, when
+// fstabContent is non-empty, to the snapshot's etc/fstab. Tests adjust the
+// returned Cfg, Pipeline and Plan before calling BuildPatch.
+func newBuildPatchFixture(t *testing.T, refindConf, fstabContent string) *buildPatchFixture {
+	t.Helper()
+	tmpESP := t.TempDir()
+	refindDir := filepath.Join(tmpESP, "EFI", "refind")
+	require.NoError(t, os.MkdirAll(refindDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind.conf"), []byte(refindConf), 0644))
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot-1")
+	require.NoError(t, os.MkdirAll(filepath.Join(snapshotPath, "etc"), 0755))
+	if fstabContent != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(snapshotPath, "etc/fstab"), []byte(fstabContent), 0644))
+	}
+
+	return &buildPatchFixture{
+		esp:          tmpESP,
+		snapshotPath: snapshotPath,
+		pipeline: &Pipeline{
+			Cfg: &config.Config{
+				Refind:   config.RefindConfig{ConfigPath: "/EFI/refind/refind.conf"},
+				Snapshot: config.SnapshotConfig{WritableMethod: "toggle"},
+				Advanced: config.AdvancedConfig{Naming: config.NamingConfig{MenuFormat: "2006-01-02T15:04:05Z"}},
+			},
+			Fstab:   fstab.NewManager(),
+			Runner:  runner.New(true),
+			ESPPath: tmpESP,
+		},
+		plan: &Plan{
+			RootFS: &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}},
+			ProcessedSnapshots: []*btrfs.Snapshot{{
+				Subvolume:      &btrfs.Subvolume{ID: 257, Path: "/.snapshots/1/snapshot"},
+				FilesystemPath: snapshotPath,
+			}},
+		},
+	}
+}
+
+// writeRefindLinuxDirs stages a refind_linux.conf booting the root
+// filesystem in each of dirs under the fixture ESP's EFI directory.
+func (f *buildPatchFixture) writeRefindLinuxDirs(t *testing.T, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		kernelDir := filepath.Join(f.esp, "EFI", dir)
+		require.NoError(t, os.MkdirAll(kernelDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(kernelDir, "refind_linux.conf"),
+			[]byte(`"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw"`+"\n"), 0644))
+	}
+}
+
 // TestBuildPatch_EndToEnd exercises the full BuildPatch flow with a real
 // temp-dir ESP layout, real fstab updates against a fake snapshot tree, and
 // the real Parser/Generator — no btrfs operations required. Verifies the
@@ -172,6 +246,250 @@ menuentry "Other Distro" {
 	assert.True(t, foundInclude, "expected managed include diff in patch (because GenerateInclude=true)")
 }
 
+func TestBuildPatch_RefindLinuxOnlySkipsManagedConfig(t *testing.T) {
+	f := newBuildPatchFixture(t, archRefindConf, rootFstab)
+	f.pipeline.RefindLinuxOnly = true
+
+	patch, summary, err := f.pipeline.BuildPatch(f.plan)
+	require.NoError(t, err)
+	for _, file := range patch.Files {
+		assert.NotEqual(t, "refind-btrfs-snapshots.conf", filepath.Base(file.Path), "managed config must not be generated with RefindLinuxOnly")
+	}
+	assert.Empty(t, summary.UpdatedConfigs)
+}
+func TestBuildPatch_FstabOnly(t *testing.T) {
+	f := newBuildPatchFixture(t, archRefindConf, rootFstab)
+	f.pipeline.Cfg.GenerateInclude = config.Truthy(true)
+	f.pipeline.FstabOnly = true
+
+	patch, summary, err := f.pipeline.BuildPatch(f.plan)
+	require.NoError(t, err)
+	require.Len(t, patch.Files, 1, "only the snapshot fstab is rewritten")
+	assert.Equal(t, filepath.Join(f.snapshotPath, "etc", "fstab"), patch.Files[0].Path)
+	assert.Len(t, summary.UpdatedFstabs, 1)
+	assert.Empty(t, summary.UpdatedConfigs)
+	assert.Empty(t, summary.IncludedSnapshots)
+}
+func TestBuildPatch_MultipleRefindLinux(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantUpdated []string // refind_linux.conf dirs given snapshot entries
+		wantManaged bool
+	}{
+		{mode: "all", wantUpdated: []string{"linux", "zen"}},
+		{mode: "first", wantUpdated: []string{"linux"}},
+		{mode: "managed", wantManaged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			f := newBuildPatchFixture(t, "timeout 5\n", "")
+			f.writeRefindLinuxDirs(t, "linux", "zen")
+			f.pipeline.Cfg.Refind.MultipleRefindLinux = tt.mode
+			f.pipeline.Cfg.Behavior.BootReadOnly = true
+
+			patch, _, err := f.pipeline.BuildPatch(f.plan)
+			require.NoError(t, err)
+
+			var updated []string
+			managed := false
+			for _, file := range patch.Files {
+				switch filepath.Base(file.Path) {
+				case "refind_linux.conf":
+					updated = append(updated, filepath.Base(filepath.Dir(file.Path)))
+				case "refind-btrfs-snapshots.conf":
+					managed = true
+				}
+			}
+			slices.Sort(updated)
+			assert.Equal(t, tt.wantUpdated, updated)
+			assert.Equal(t, tt.wantManaged, managed)
+		})
+	}
+}
+
+func TestBuildPatch_MultipleRefindLinuxManaged_KeepsSnapshotEntries(t *testing.T) {
+	f := newBuildPatchFixture(t, "timeout 5\n", "")
+	f.writeRefindLinuxDirs(t, "linux", "zen")
+	for _, dir := range []string{"linux", "zen"} {
+		kernelDir := filepath.Join(f.esp, "EFI", dir)
+		require.NoError(t, os.WriteFile(filepath.Join(kernelDir, "vmlinuz-linux"), nil, 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(kernelDir, "initramfs-linux.img"), nil, 0644))
+	}
+	f.pipeline.Cfg.Refind.MultipleRefindLinux = "managed"
+	f.pipeline.Cfg.Behavior.BootReadOnly = true
+	f.pipeline.Cfg.Advanced.Naming.MenuFormat = "2006-01-02"
+	f.plan.ProcessedSnapshots[0].SnapshotTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	patch, _, err := f.pipeline.BuildPatch(f.plan)
+	require.NoError(t, err)
+
+	var managed string
+	for _, file := range patch.Files {
+		if filepath.Base(file.Path) == "refind-btrfs-snapshots.conf" {
+			managed = file.Modified
+		}
+	}
+	require.NotEmpty(t, managed, "expected managed include in patch")
+	assert.NotContains(t, managed, "disabled", "consolidated entries must be enabled, not templates")
+	assert.NotContains(t, managed, "TEMPLATE ENTRY")
+	for _, dir := range []string{"linux", "zen"} {
+		title := "Boot default (/EFI/" + dir + ")"
+		assert.Contains(t, managed, `menuentry "`+title+`" {
+    loader /EFI/`+dir+`/vmlinuz-linux
+    initrd /EFI/`+dir+`/initramfs-linux.img
+    options "root=UUID=test-uuid rootflags=subvol=@ rw"
+`)
+		assert.Contains(t, managed, `    submenuentry "`+title+` (2025-01-01)" {`)
+	}
+	assert.Contains(t, managed, `        options "root=UUID=test-uuid rootflags=subvol=@/.snapshots/1/snapshot,subvolid=257 ro"`+"\n")
+}
+
+func TestBuildPatch_BootReadOnlyLeavesFstab(t *testing.T) {
+	f := newBuildPatchFixture(t, archRefindConf, rootFstab)
+	f.pipeline.Cfg.Behavior.BootReadOnly = true
+
+	patch, summary, err := f.pipeline.BuildPatch(f.plan)
+	require.NoError(t, err)
+	assert.Empty(t, summary.UpdatedFstabs)
+	var managed string
+	for _, file := range patch.Files {
+		assert.NotEqual(t, filepath.Join(f.snapshotPath, "etc/fstab"), file.Path, "fstab must not be rewritten with BootReadOnly")
+		if filepath.Base(file.Path) == "refind-btrfs-snapshots.conf" {
+			managed = file.Modified
+		}
+	}
+	assert.Contains(t, managed, `options "root=UUID=test-uuid rootflags=subvol=@/.snapshots/1/snapshot,subvolid=257 ro quiet"`)
+}
+func TestBuildPatch_BtrfsModeLoaderIsVolumeRelative(t *testing.T) {
+	tmpESP := t.TempDir()
+	refindDir := filepath.Join(tmpESP, "EFI", "refind")
+	require.NoError(t, os.MkdirAll(refindDir, 0755))
+	entry := `menuentry "Arch Linux" {
+    volume "EFI system partition"
+    loader \EFI\arch\vmlinuz-linux
+    initrd \EFI\arch\initramfs-linux.img
+    options "root=UUID=test-uuid rootflags=subvol=@ rw"
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind.conf"), []byte("include refind-btrfs-snapshots.conf\n"+entry), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind-btrfs-snapshots.conf"), []byte(entry), 0644))
+
+	snapshotPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(snapshotPath, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(snapshotPath, "etc/fstab"),
+		[]byte("UUID=test-uuid / btrfs rw,subvol=@ 0 0\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(snapshotPath, "boot"), 0755))
+	for _, f := range []string{"vmlinuz-linux", "initramfs-linux.img"} {
+		require.NoError(t, os.WriteFile(filepath.Join(snapshotPath, "boot", f), []byte("fake"), 0644))
+	}
+
+	snapshot := &btrfs.Snapshot{
+		Subvolume:      &btrfs.Subvolume{ID: 300, Path: "@/.snapshots/7/snapshot"},
+		FilesystemPath: snapshotPath,
+		SnapshotTime:   time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	rootFS := &btrfs.Filesystem{
+		UUID:      "test-uuid",
+		Label:     "ARCH_ROOT",
+		Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"},
+	}
+
+	fstabMgr := fstab.NewManager()
+	bootPlans := kernel.NewPlanner(fstabMgr, nil, nil, rootFS).Plan([]*btrfs.Snapshot{snapshot})
+	require.Len(t, bootPlans, 1)
+	require.True(t, bootPlans[0].VolumeRelative())
+
+	pipeline := &Pipeline{
+		Cfg: &config.Config{
+			Refind:   config.RefindConfig{ConfigPath: "/EFI/refind/refind.conf"},
+			Snapshot: config.SnapshotConfig{WritableMethod: "toggle"},
+			Advanced: config.AdvancedConfig{Naming: config.NamingConfig{MenuFormat: "2006-01-02"}},
+		},
+		Fstab:   fstabMgr,
+		Runner:  runner.New(true),
+		ESPPath: tmpESP,
+	}
+	plan := &Plan{
+		RootFS:             rootFS,
+		ProcessedSnapshots: []*btrfs.Snapshot{snapshot},
+		BootPlans:          bootPlans,
+	}
+
+	patch, _, err := pipeline.BuildPatch(plan)
+	require.NoError(t, err)
+
+	var managed string
+	for _, f := range patch.Files {
+		if filepath.Base(f.Path) == "refind-btrfs-snapshots.conf" {
+			managed = f.Modified
+		}
+	}
+	require.NotEmpty(t, managed, "expected managed include in patch")
+
+	assert.Contains(t, managed, `    volume "EFI system partition"`)
+	assert.Contains(t, managed, `    loader \EFI\arch\vmlinuz-linux`)
+	assert.Contains(t, managed, "        volume  ARCH_ROOT\n        loader  /@/.snapshots/7/snapshot/boot/vmlinuz-linux\n")
+	assert.Contains(t, managed, "        initrd  /@/.snapshots/7/snapshot/boot/initramfs-linux.img\n")
+	assert.NotContains(t, managed, tmpESP, "volume-relative paths must not be rewritten against the ESP")
+}
+
+func TestBuildPatch_AllVolumesRoutesSnapshotsPerVolume(t *testing.T) {
+	tmpESP := t.TempDir()
+	refindDir := filepath.Join(tmpESP, "EFI", "refind")
+	require.NoError(t, os.MkdirAll(refindDir, 0755))
+
+	entries := `menuentry "Arch Linux" {
+    loader /vmlinuz-linux
+    options "root=UUID=root-uuid rootflags=subvol=@ rw"
+}
+menuentry "Other Distro" {
+    loader /vmlinuz-other
+    options "root=UUID=other-uuid rootflags=subvol=@other rw"
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind.conf"), []byte("include refind-btrfs-snapshots.conf\n"+entries), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind-btrfs-snapshots.conf"), []byte(entries), 0644))
+
+	rootSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 300, Path: "@/.snapshots/1/snapshot"},
+		SnapshotTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	otherSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 400, Path: "@other/.snapshots/9/snapshot"},
+		SnapshotTime: time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC),
+	}
+	rootFS := &btrfs.Filesystem{UUID: "root-uuid", MountPoint: "/", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}}
+	otherFS := &btrfs.Filesystem{UUID: "other-uuid", MountPoint: "/mnt/other", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@other"}}
+
+	pipeline := &Pipeline{
+		Cfg: &config.Config{
+			Refind:   config.RefindConfig{ConfigPath: "/EFI/refind/refind.conf"},
+			Advanced: config.AdvancedConfig{Naming: config.NamingConfig{MenuFormat: "2006-01-02"}},
+		},
+		Fstab:   fstab.NewManager(),
+		Runner:  runner.New(true),
+		ESPPath: tmpESP,
+	}
+	plan := &Plan{
+		RootFS:             rootFS,
+		ProcessedSnapshots: []*btrfs.Snapshot{rootSnap, otherSnap},
+		Volumes: []refind.VolumeSnapshots{
+			{FS: rootFS, Snapshots: []*btrfs.Snapshot{rootSnap}},
+			{FS: otherFS, Snapshots: []*btrfs.Snapshot{otherSnap}},
+		},
+	}
+
+	patch, _, err := pipeline.BuildPatch(plan)
+	require.NoError(t, err)
+	require.Len(t, patch.Files, 1)
+
+	managed := patch.Files[0].Modified
+	assert.Contains(t, managed, `submenuentry "Arch Linux (2025-01-01)"`)
+	assert.Contains(t, managed, `submenuentry "Other Distro (2025-02-02)"`)
+	assert.NotContains(t, managed, `submenuentry "Arch Linux (2025-02-02)"`, "root entry must not get the other volume's snapshots")
+	assert.NotContains(t, managed, `submenuentry "Other Distro (2025-01-01)"`, "other entry must not get the root volume's snapshots")
+}
+
 func TestBuildPatch_NoSourceEntriesIsAnError(t *testing.T) {
 	tmpESP := t.TempDir()
 	refindDir := filepath.Join(tmpESP, "EFI", "refind")
@@ -199,4 +517,5 @@ menuentry "Other" {
 
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "no suitable boot entries")
+	assert.ErrorIs(t, err, ErrNoBootableEntries)
 }
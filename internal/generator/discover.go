@@ -1,10 +1,16 @@
 package generator
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
 	"github.com/rs/zerolog/log"
 )
 
@@ -20,53 +26,239 @@ func (p *Pipeline) Discover() (*Plan, error) {
 		return nil, fmt.Errorf("failed to get root filesystem: %w", err)
 	}
 
+	if err := p.checkSnapshotBoot(rootFS); err != nil {
+		return nil, err
+	}
+
+	logRootFilesystem(rootFS)
+	logLiveBootMode(p.Fstab, rootFS)
+
+	return p.discoverFilesystem(rootFS)
+}
+
+// SubvolumesInUse returns the subvolume IDs prune must leave alone: the
+// booted root, and the snapshots and writable copies generate's boot
+// entries boot, as planned by discovery with nothing changed
+// (KeepSnapshots). Unlike Discover it runs when booted from a snapshot.
+func (p *Pipeline) SubvolumesInUse() (map[uint64]bool, error) {
+	rootFS, err := p.Btrfs.GetRootFilesystem()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root filesystem: %w", err)
+	}
+	keep := *p
+	keep.KeepSnapshots = true
+	plan, err := keep.discoverFilesystem(rootFS)
+	if err != nil {
+		return nil, err
+	}
+	return subvolumesInUse(rootFS, plan.ProcessedSnapshots), nil
+}
+
+// DiscoverAll runs discovery on every detected btrfs filesystem that at
+// least one rEFInd entry boots (--all-volumes), plus the root filesystem,
+// and merges the results. The merged Plan's Volumes record which snapshots
+// belong to which filesystem so BuildPatch can route them per entry.
+// Volumes nothing boots are skipped so their snapshots (e.g. @home) are
+// never made writable or have their fstab rewritten.
+func (p *Pipeline) DiscoverAll() (*Plan, error) {
+	filesystems, err := p.Btrfs.DetectBtrfsFilesystems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect btrfs filesystems: %w", err)
+	}
+
+	_, config, err := p.parseRefindConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &Plan{}
+	for _, fs := range filesystems {
+		isRoot := fs.MountPoint == "/"
+		if isRoot {
+			if err := p.checkSnapshotBoot(fs); err != nil {
+				return nil, err
+			}
+			logRootFilesystem(fs)
+			logLiveBootMode(p.Fstab, fs)
+			merged.RootFS = fs
+		} else if len(bootableEntries(config.Entries, fs)) == 0 {
+			log.Debug().Str("mountpoint", fs.MountPoint).Msg("Skipping btrfs volume with no bootable rEFInd entries")
+			continue
+		}
+
+		log.Info().
+			Str("mountpoint", fs.MountPoint).
+			Str("identifier", fs.GetBestIdentifier()).
+			Msg("Discovering snapshots on btrfs volume")
+		plan, err := p.discoverFilesystem(fs)
+		if err != nil {
+			return nil, err
+		}
+		merged.ProcessedSnapshots = append(merged.ProcessedSnapshots, plan.ProcessedSnapshots...)
+		merged.BootPlans = append(merged.BootPlans, plan.BootPlans...)
+		merged.Removed = append(merged.Removed, plan.Removed...)
+		merged.ESPCopies = append(merged.ESPCopies, plan.ESPCopies...)
+		merged.RemovedESPCopies = append(merged.RemovedESPCopies, plan.RemovedESPCopies...)
+		merged.TimedOut = append(merged.TimedOut, plan.TimedOut...)
+		merged.Skipped = append(merged.Skipped, plan.Skipped...)
+		merged.Volumes = append(merged.Volumes, refind.VolumeSnapshots{FS: fs, Snapshots: plan.ProcessedSnapshots})
+	}
+
+	if len(merged.Volumes) == 0 {
+		return nil, fmt.Errorf("no bootable btrfs volumes found: %w", btrfs.ErrNoBtrfsRoot)
+	}
+	if merged.RootFS == nil {
+		merged.RootFS = merged.Volumes[0].FS
+	}
+	return merged, nil
+}
+
+// checkSnapshotBoot refuses to proceed when booted from a snapshot of
+// rootFS, unless --force or behavior.exit_on_snapshot_boot=false.
+func (p *Pipeline) checkSnapshotBoot(rootFS *btrfs.Filesystem) error {
 	if !p.Cfg.Force && p.Cfg.Behavior.ExitOnSnapshotBoot {
 		if p.Btrfs.IsSnapshotBootFromRootFS(rootFS) {
 			log.Warn().Msg("Currently booted from a snapshot. Use --force to override or disable this check in config.")
-			return nil, fmt.Errorf("refusing to generate configs while booted from snapshot")
+			return fmt.Errorf("refusing to generate configs while booted from snapshot")
 		}
 	}
+	return nil
+}
 
-	logRootFilesystem(rootFS)
-	logLiveBootMode(p.Fstab, rootFS)
+// checkESPOutsideSnapshots refuses to proceed when the ESP or the rEFInd
+// config resolves inside one of snapshots, e.g. through a symlink or an
+// unusual mount setup, so boot config is never written into a read-only or
+// soon-deleted snapshot instead of the real ESP.
+func (p *Pipeline) checkESPOutsideSnapshots(snapshots []*btrfs.Snapshot) error {
+	if p.ESPPath == "" {
+		return nil
+	}
+	targets := []string{p.ESPPath}
+	if p.Cfg.Refind.ConfigPath != "" {
+		targets = append(targets, filepath.Join(p.ESPPath, p.Cfg.Refind.ConfigPath))
+	}
+	for _, target := range targets {
+		resolved := resolvePath(target)
+		for _, snapshot := range snapshots {
+			if snapshot.FilesystemPath == "" {
+				continue
+			}
+			if pathWithin(resolved, resolvePath(snapshot.FilesystemPath)) {
+				return fmt.Errorf("refusing to write boot config to %s: it resolves to %s, inside snapshot %s; check the ESP mount point (esp.mount_point or --esp-path)",
+					target, resolved, snapshot.Path)
+			}
+		}
+	}
+	return nil
+}
 
+// resolvePath returns path with symlinks resolved, resolving the deepest
+// existing parent when path itself doesn't exist yet.
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	var missing []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, missing...)...)
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// pathWithin reports whether path is dir or lies below it.
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// discoverFilesystem finds, selects, and plans the snapshots of one btrfs
+// filesystem.
+func (p *Pipeline) discoverFilesystem(rootFS *btrfs.Filesystem) (*Plan, error) {
+	var skipped skipLog
+	ignoredBefore := len(p.Btrfs.IgnoredSnapshots())
 	snapshots, err := p.Btrfs.FindSnapshots(rootFS)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find snapshots: %w", err)
 	}
+	for _, path := range p.Btrfs.IgnoredSnapshots()[ignoredBefore:] {
+		skipped.add(path, fmt.Sprintf("carries the ignore marker %s (snapshot.ignore_marker)", p.Cfg.Snapshot.IgnoreMarker))
+	}
 	if len(snapshots) == 0 {
 		log.Info().Msg("No snapshots found")
 	}
+	if err := p.checkESPOutsideSnapshots(snapshots); err != nil {
+		return nil, err
+	}
+
+	candidates := snapshots
+	if p.Cfg.Snapshot.SkipIdentical.IsTrue() {
+		candidates = skipIdenticalSnapshots(snapshots, rootFS.Subvolume)
+		skipped.dropped(snapshots, candidates, "identical to the live root (snapshot.skip_identical)")
+	}
 
-	selected := selectSnapshots(snapshots, p.Cfg.Snapshot.SelectionCount)
+	if types := p.Cfg.Snapshot.IncludeTypes; len(types) > 0 {
+		included := filterSnapperTypes(candidates, types)
+		skipped.dropped(candidates, included, fmt.Sprintf("snapper type not in %s (snapshot.include_types)", strings.Join(types, ", ")))
+		candidates = included
+	}
+
+	selected := selectSnapshots(candidates, p.Cfg.Snapshot.SelectionCount)
+	skipped.dropped(candidates, selected, fmt.Sprintf("beyond the first %d snapshots (snapshot.selection_count)", p.Cfg.Snapshot.SelectionCount))
 	log.Info().
 		Int("total", len(snapshots)).
 		Int("selected", len(selected)).
 		Msg("Selected snapshots for processing")
 
-	processed, err := p.processWritability(snapshots, selected)
+	processed, timedOut, err := p.processWritability(rootFS, snapshots, selected, &skipped)
 	if err != nil {
 		return nil, err
 	}
+	if p.KeepSnapshots {
+		skipped.dropped(selected, processed, "read-only, with no writable copy, and left unchanged (--refresh-fstab-only)")
+	} else {
+		skipped.dropped(selected, processed, "could not be made writable; see the errors logged above")
+	}
 	if len(processed) == 0 {
 		log.Warn().Msg("No snapshots available for processing")
 	}
+	removedESPCopies := p.staleESPCopies(rootFS, append(slices.Clone(snapshots), processed...))
 
 	staleAction := kernel.ParseStaleAction(p.Cfg.Kernel.StaleSnapshotAction)
-	var checker *kernel.Checker
-	if len(p.BootSets) > 0 {
-		checker = kernel.NewChecker(staleAction)
+	processedBefore := processed
+	bootPlans, processed, planTimedOut := p.planSnapshots(p.NewPlanner(rootFS), processed)
+	timedOut = append(timedOut, planTimedOut...)
+	for _, path := range planTimedOut {
+		skipped.add(sourcePath(processedBefore, path), "timed out being planned (behavior.timeout_per_snapshot)")
 	}
-	planner := kernel.NewPlanner(p.Fstab, checker, p.BootSets, rootFS)
-	bootPlans := filterRefindEligible(planner.Plan(processed))
+	bootPlans = filterRefindEligible(bootPlans)
+	espCopies := p.attachESPCopies(rootFS, bootPlans)
+	kernel.LogStaleness(bootPlans)
 
 	var removed []string
 	if staleAction == kernel.ActionDelete {
 		processed, removed = filterDeletedStale(processed, bootPlans)
+		for _, path := range removed {
+			skipped.add(sourcePath(processedBefore, path), "every boot plan is stale (kernel.stale_snapshot_action=delete)")
+		}
 		if len(processed) == 0 {
 			log.Warn().Msg("All snapshots were stale and removed (stale_snapshot_action=delete)")
 		}
-		bootPlans = filterRefindEligible(planner.Plan(processed))
+		// Filtered rather than re-planned so ESP boot copies aren't planned
+		// twice.
+		bootPlans = plansForSnapshots(bootPlans, processed)
+	}
+
+	if p.OnlyMode != "" {
+		bootPlans = filterPlansByMode(bootPlans, p.OnlyMode)
+		log.Info().
+			Str("mode", string(p.OnlyMode)).
+			Int("plans", len(bootPlans)).
+			Msg("Regenerating only boot plans for the requested mode")
 	}
 
 	return &Plan{
@@ -74,9 +266,109 @@ func (p *Pipeline) Discover() (*Plan, error) {
 		ProcessedSnapshots: processed,
 		BootPlans:          bootPlans,
 		Removed:            removed,
+		ESPCopies:          espCopiesForPlans(espCopies, bootPlans),
+		RemovedESPCopies:   removedESPCopies,
+		TimedOut:           timedOut,
+		Skipped:            skipped,
 	}, nil
 }
 
+// NewPlanner returns the boot planner discovery plans snapshots of rootFS
+// with, configured from p's config, boot sets, fstab manager and hash
+// store. Commands that report on snapshots build theirs here too, so they
+// judge them as generate does.
+func (p *Pipeline) NewPlanner(rootFS *btrfs.Filesystem) *kernel.Planner {
+	var checker *kernel.Checker
+	if len(p.BootSets) > 0 {
+		checker = kernel.NewChecker(kernel.ParseStaleAction(p.Cfg.Kernel.StaleSnapshotAction))
+		checker.SetUsePackageDB(p.Cfg.Kernel.UsePackageDB.IsTrue())
+	}
+	planner := kernel.NewPlanner(p.Fstab, checker, p.BootSets, rootFS)
+	planner.SetHashStore(p.Hashes)
+	planner.SetFallbackEntries(p.Cfg.Kernel.BtrfsFallbackEntries.IsTrue())
+	planner.SetBtrfsKernels(p.Cfg.Kernel.BtrfsPreferredKernels, p.Cfg.Kernel.BtrfsEntriesPerSnapshot)
+	return planner
+}
+
+// skipLog collects why discovery drops snapshots, for --explain-skips.
+type skipLog []SkippedSnapshot
+
+// add records that the snapshot at path was dropped for reason.
+func (l *skipLog) add(path, reason string) {
+	*l = append(*l, SkippedSnapshot{Path: path, Reason: reason})
+}
+
+// sourcePath returns the path of the snapshot at path among snapshots, or
+// of its source when it is a writable copy, so a skip is reported under the
+// snapshot the user knows.
+func sourcePath(snapshots []*btrfs.Snapshot, path string) string {
+	i := slices.IndexFunc(snapshots, func(s *btrfs.Snapshot) bool { return s.Path == path })
+	if i >= 0 && snapshots[i].OriginalPath != "" {
+		return snapshots[i].OriginalPath
+	}
+	return path
+}
+
+// dropped records reason for each of before that isn't in after and wasn't
+// already recorded. A writable copy in after stands for its source.
+func (l *skipLog) dropped(before, after []*btrfs.Snapshot, reason string) {
+	for _, snapshot := range before {
+		kept := slices.ContainsFunc(after, func(s *btrfs.Snapshot) bool {
+			return s.Path == snapshot.Path || s.OriginalPath == snapshot.Path
+		})
+		recorded := slices.ContainsFunc(*l, func(s SkippedSnapshot) bool { return s.Path == snapshot.Path })
+		if !kept && !recorded {
+			l.add(snapshot.Path, reason)
+		}
+	}
+}
+
+// skipIdenticalSnapshots drops snapshots identical to the live root
+// (snapshot.skip_identical): btrfs bumps a subvolume's generation on every
+// write, so a snapshot created in a generation at least the root's was
+// taken after the root last changed. The snapshot's generation at creation
+// is compared, not its current one, which this tool's own writes to it
+// (making it writable, rewriting its fstab) bump. Without generations to
+// compare, snapshots are kept.
+func skipIdenticalSnapshots(snapshots []*btrfs.Snapshot, root *btrfs.Subvolume) []*btrfs.Snapshot {
+	if root == nil || root.Generation == 0 {
+		log.Warn().Msg("Live root generation unknown, not skipping identical snapshots")
+		return snapshots
+	}
+
+	var kept []*btrfs.Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.GenAtCreation > 0 && snapshot.GenAtCreation >= root.Generation {
+			log.Info().
+				Str("snapshot", snapshot.Path).
+				Uint64("gen_at_creation", snapshot.GenAtCreation).
+				Uint64("root_generation", root.Generation).
+				Msg("Skipping snapshot identical to the live root")
+			continue
+		}
+		kept = append(kept, snapshot)
+	}
+	return kept
+}
+
+// filterSnapperTypes keeps the snapshots whose snapper type is one of types
+// (snapshot.include_types). Snapshots without snapper metadata have no type
+// and are always kept.
+func filterSnapperTypes(snapshots []*btrfs.Snapshot, types []string) []*btrfs.Snapshot {
+	var kept []*btrfs.Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.SnapperType != "" && !slices.Contains(types, snapshot.SnapperType) {
+			log.Debug().
+				Str("snapshot", snapshot.Path).
+				Str("type", snapshot.SnapperType).
+				Msg("Skipping snapshot of an excluded snapper type")
+			continue
+		}
+		kept = append(kept, snapshot)
+	}
+	return kept
+}
+
 // selectSnapshots applies the configured selection count. Zero or negative
 // means "all snapshots".
 func selectSnapshots(snapshots []*btrfs.Snapshot, selectionCount int) []*btrfs.Snapshot {
@@ -92,53 +384,188 @@ func selectSnapshots(snapshots []*btrfs.Snapshot, selectionCount int) []*btrfs.S
 // processWritability turns selected snapshots into a list of writable ones
 // per the configured writable_method. For "toggle" it flips the read-only
 // flag in place; for "copy" it creates writable copies in destination_dir.
-func (p *Pipeline) processWritability(allSnapshots, selected []*btrfs.Snapshot) ([]*btrfs.Snapshot, error) {
+// Snapshots whose btrfs call exceeds behavior.timeout_per_snapshot are
+// dropped and their paths returned. With behavior.boot_readonly snapshots
+// are booted as they are and nothing is changed.
+//
+// A filesystem short of space is caught before anything is changed: copies
+// aren't attempted and the run fails, while toggling, which only writes
+// metadata, goes ahead with a warning. Once a btrfs call runs out of space
+// no further snapshots are changed. Old writable copies are cleaned up,
+// except those processed and the booted root, which is rootFS's subvolume.
+func (p *Pipeline) processWritability(rootFS *btrfs.Filesystem, allSnapshots, selected []*btrfs.Snapshot, skipped *skipLog) ([]*btrfs.Snapshot, []string, error) {
+	if p.Cfg.Behavior.BootReadOnly.IsTrue() {
+		log.Info().Msg("Booting snapshots read-only, leaving them unmodified (behavior.boot_readonly)")
+		return selected, nil, nil
+	}
+
 	method := p.Cfg.Snapshot.WritableMethod
+	if p.KeepSnapshots {
+		return p.writableSnapshots(selected), nil, nil
+	}
 	log.Info().Str("method", method).Msg("Using writable snapshot method")
 
+	spaceErr := p.checkWritableSpace(selected)
+
 	switch method {
 	case "toggle":
+		if spaceErr != nil {
+			log.Warn().Err(spaceErr).Msg("Filesystem is nearly full, making snapshots writable may fail")
+		}
+		var processed, abandoned []*btrfs.Snapshot
+		var timedOut []string
+		outOfSpace := false
 		for _, snap := range selected {
-			if snap.IsReadOnly {
-				if err := p.Btrfs.MakeSnapshotWritable(snap, p.Runner); err != nil {
+			if snap.IsReadOnly && !outOfSpace {
+				// The step toggles a copy, so an abandoned one can't change
+				// the snapshot under the rest of the run.
+				toggled, ok := runSnapshotStep(p, snap, "make writable", func(ctx context.Context) snapshotResult {
+					c := copySnapshot(snap)
+					return snapshotResult{c, p.Btrfs.MakeSnapshotWritable(ctx, c, p.Runner)}
+				})
+				if !ok {
+					timedOut = append(timedOut, snap.Path)
+					skipped.add(snap.Path, "timed out being made writable (behavior.timeout_per_snapshot)")
+					abandoned = append(abandoned, snap)
+					continue
+				}
+				err := toggled.err
+				snap.IsReadOnly = toggled.snapshot.IsReadOnly
+				if errors.Is(err, btrfs.ErrProgsTooOld) {
+					return nil, nil, fmt.Errorf("cannot toggle snapshots writable: %w; upgrade btrfs-progs, or use writable_method copy or behavior.boot_readonly", err)
+				}
+				if err != nil {
 					log.Error().Err(err).Str("path", snap.Path).Msg("Failed to make snapshot writable")
 				}
+				if errors.Is(err, btrfs.ErrNoSpace) {
+					log.Error().Msg("Out of space, leaving the remaining snapshots read-only")
+					outOfSpace = true
+				}
 			}
+			processed = append(processed, snap)
 		}
+		p.restoreReadOnly(abandoned)
 		if p.Cfg.Behavior.CleanupOldSnapshots {
 			if err := p.Btrfs.CleanupSnapshotWritability(allSnapshots, selected, p.Runner); err != nil {
 				log.Warn().Err(err).Msg("Failed to cleanup snapshot writability")
 			}
 		}
-		return selected, nil
+		return processed, timedOut, nil
 
 	case "copy":
+		if spaceErr != nil {
+			if !p.Runner.IsDryRun() {
+				return nil, nil, fmt.Errorf("not creating writable snapshots: %w; free up space (e.g. delete old snapshots) or set behavior.boot_readonly", spaceErr)
+			}
+			log.Warn().Err(spaceErr).Msg("Filesystem is nearly full, creating writable snapshots would be refused")
+		}
 		destDir := p.Cfg.Snapshot.DestinationDir
 		var processed []*btrfs.Snapshot
+		var timedOut []string
+		outOfSpace := false
 		for _, snap := range selected {
 			if snap.IsReadOnly {
+				if outOfSpace {
+					skipped.add(snap.Path, "no writable copy created: the filesystem ran out of space")
+					continue
+				}
 				log.Info().Str("source", snap.Path).Msg("Creating writable snapshot")
-				copy, err := p.Btrfs.CreateWritableSnapshot(snap, destDir, p.Runner)
+				created, ok := runSnapshotStep(p, snap, "create writable copy", func(ctx context.Context) snapshotResult {
+					writable, err := p.Btrfs.CreateWritableSnapshot(ctx, snap, destDir, p.Runner)
+					return snapshotResult{writable, err}
+				})
+				if !ok {
+					timedOut = append(timedOut, snap.Path)
+					skipped.add(snap.Path, "timed out creating its writable copy (behavior.timeout_per_snapshot)")
+					continue
+				}
+				writable, err := created.snapshot, created.err
 				if err != nil {
 					log.Error().Err(err).Str("source", snap.Path).Msg("Failed to create writable snapshot")
+					skipped.add(snap.Path, fmt.Sprintf("failed to create its writable copy: %v", err))
+					if errors.Is(err, btrfs.ErrNoSpace) {
+						log.Error().Msg("Out of space, not creating writable copies of the remaining snapshots")
+						outOfSpace = true
+					}
 					continue
 				}
-				processed = append(processed, copy)
+				processed = append(processed, writable)
 			} else {
 				processed = append(processed, snap)
 			}
 		}
 		if p.Cfg.Behavior.CleanupOldSnapshots {
-			if err := p.Btrfs.CleanupOldSnapshots(destDir, p.Cfg.Snapshot.SelectionCount, p.Runner); err != nil {
+			if err := p.Btrfs.CleanupOldSnapshots(destDir, p.Cfg.Snapshot.SelectionCount, subvolumesInUse(rootFS, processed), p.Runner); err != nil {
 				log.Warn().Err(err).Msg("Failed to cleanup old snapshots")
 			}
 		}
-		return processed, nil
+		return processed, timedOut, nil
 
 	default:
 		// Validate caught this at startup; unreachable in practice.
-		return nil, fmt.Errorf("invalid writable_method: %s (must be 'toggle' or 'copy')", method)
+		return nil, nil, fmt.Errorf("invalid writable_method: %s (must be 'toggle' or 'copy')", method)
+	}
+}
+
+// subvolumesInUse returns the subvolume IDs of snapshots and of rootFS's
+// subvolume, which may be nil: the subvolumes a cleanup must not delete.
+func subvolumesInUse(rootFS *btrfs.Filesystem, snapshots []*btrfs.Snapshot) map[uint64]bool {
+	inUse := make(map[uint64]bool)
+	if rootFS != nil && rootFS.Subvolume != nil {
+		inUse[rootFS.Subvolume.ID] = true
 	}
+	for _, snap := range snapshots {
+		if snap.Subvolume != nil {
+			inUse[snap.ID] = true
+		}
+	}
+	return inUse
+}
+
+// writableSnapshots returns the snapshots in selected that are already
+// writable, with writable_method copy the existing writable copies of the
+// rest, changing nothing (Pipeline.KeepSnapshots).
+func (p *Pipeline) writableSnapshots(selected []*btrfs.Snapshot) []*btrfs.Snapshot {
+	var writable []*btrfs.Snapshot
+	for _, snap := range selected {
+		if !snap.IsReadOnly {
+			writable = append(writable, snap)
+			continue
+		}
+		if p.Cfg.Snapshot.WritableMethod != "copy" {
+			continue
+		}
+		existing, err := p.Btrfs.ExistingWritableSnapshot(snap, p.Cfg.Snapshot.DestinationDir, p.Runner)
+		if err != nil {
+			log.Warn().Err(err).Str("source", snap.Path).Msg("Failed to read writable snapshot")
+			continue
+		}
+		if existing != nil {
+			writable = append(writable, existing)
+		}
+	}
+	return writable
+}
+
+// checkWritableSpace returns an error wrapping btrfs.ErrNoSpace when the
+// filesystem of any read-only snapshot in selected is short of space. The
+// search directories may span several filesystems, and btrfs gives every
+// subvolume its own device, so each snapshot is checked rather than one per
+// filesystem. Snapshots whose free space can't be read are passed over.
+func (p *Pipeline) checkWritableSpace(selected []*btrfs.Snapshot) error {
+	for _, snap := range selected {
+		if !snap.IsReadOnly {
+			continue
+		}
+		err := p.Btrfs.CheckFreeSpace(snap.FilesystemPath)
+		if errors.Is(err, btrfs.ErrNoSpace) {
+			return err
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("path", snap.Path).Msg("Not checking free space before making snapshot writable")
+		}
+	}
+	return nil
 }
 
 // filterDeletedStale drops snapshots whose every BootPlan is stale and
@@ -165,6 +592,17 @@ func filterDeletedStale(snapshots []*btrfs.Snapshot, plans []*kernel.BootPlan) (
 	return kept, removed
 }
 
+// plansForSnapshots keeps the plans belonging to one of snapshots.
+func plansForSnapshots(plans []*kernel.BootPlan, snapshots []*btrfs.Snapshot) []*kernel.BootPlan {
+	out := make([]*kernel.BootPlan, 0, len(plans))
+	for _, plan := range plans {
+		if slices.ContainsFunc(snapshots, func(s *btrfs.Snapshot) bool { return s.Path == plan.Snapshot.Path }) {
+			out = append(out, plan)
+		}
+	}
+	return out
+}
+
 // filterRefindEligible drops BootPlans the refind binary can't act on. UKI
 // plans get excluded: an ESP-mode UKI's embedded cmdline references the live
 // root subvol, and a btrfs-mode UKI inside a snapshot was likewise built
@@ -182,3 +620,14 @@ func filterRefindEligible(plans []*kernel.BootPlan) []*kernel.BootPlan {
 	}
 	return out
 }
+
+// filterPlansByMode keeps only plans of the given boot mode (--only-mode).
+func filterPlansByMode(plans []*kernel.BootPlan, mode kernel.BootMode) []*kernel.BootPlan {
+	out := make([]*kernel.BootPlan, 0, len(plans))
+	for _, p := range plans {
+		if p.Mode == mode {
+			out = append(out, p)
+		}
+	}
+	return out
+}
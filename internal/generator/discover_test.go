@@ -1,12 +1,23 @@
 package generator
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func mkSnapshot(id uint64, path string) *btrfs.Snapshot {
@@ -57,6 +68,53 @@ func TestSelectSnapshots(t *testing.T) {
 	}
 }
 
+func TestSkipIdenticalSnapshots(t *testing.T) {
+	withGen := func(id, genAtCreation uint64) *btrfs.Snapshot {
+		s := mkSnapshot(id, fmt.Sprintf("/.snapshots/%d/snapshot", id))
+		s.GenAtCreation = genAtCreation
+		return s
+	}
+	snaps := []*btrfs.Snapshot{withGen(1, 120), withGen(2, 100), withGen(3, 80), withGen(4, 0)}
+	// Writing to a snapshot, as making it writable does, bumps its current
+	// generation but not the one it was created in.
+	snaps[2].Generation = 150
+
+	got := skipIdenticalSnapshots(snaps, &btrfs.Subvolume{Generation: 100})
+	assert.Equal(t, []*btrfs.Snapshot{snaps[2], snaps[3]}, got, "snapshots created at or past the root generation are identical; unknown generations are kept")
+
+	assert.Equal(t, snaps, skipIdenticalSnapshots(snaps, &btrfs.Subvolume{}), "unknown root generation keeps everything")
+	assert.Equal(t, snaps, skipIdenticalSnapshots(snaps, nil))
+}
+
+func TestFilterSnapperTypes(t *testing.T) {
+	withType := func(id uint64, snapperType string) *btrfs.Snapshot {
+		s := mkSnapshot(id, fmt.Sprintf("/.snapshots/%d/snapshot", id))
+		s.SnapperType = snapperType
+		return s
+	}
+	snaps := []*btrfs.Snapshot{withType(1, "post"), withType(2, "pre"), withType(3, "single"), withType(4, "")}
+
+	got := filterSnapperTypes(snaps, []string{"single", "post"})
+	assert.Equal(t, []*btrfs.Snapshot{snaps[0], snaps[2], snaps[3]}, got, "pre snapshots are dropped; snapshots without snapper metadata are kept")
+}
+
+func TestSkipLog(t *testing.T) {
+	a := mkSnapshot(1, "/.snapshots/1/snapshot")
+	b := mkSnapshot(2, "/.snapshots/2/snapshot")
+	c := mkSnapshot(3, "/.snapshots/3/snapshot")
+	copyOfA := mkSnapshot(10, "/.refind-btrfs-snapshots/a_rwsnap")
+	copyOfA.OriginalPath = a.Path
+
+	var skipped skipLog
+	skipped.add(c.Path, "timed out")
+	skipped.dropped([]*btrfs.Snapshot{a, b, c}, []*btrfs.Snapshot{copyOfA}, "could not be made writable")
+
+	assert.Equal(t, skipLog{
+		{Path: c.Path, Reason: "timed out"},
+		{Path: b.Path, Reason: "could not be made writable"},
+	}, skipped, "a writable copy keeps its source, and the first reason recorded wins")
+}
+
 // makePlan builds a BootPlan whose ShouldSkip returns the requested value by
 // constructing the underlying staleness state. ShouldSkip returns true iff
 // the plan is ESP-mode + stale + action=delete.
@@ -133,3 +191,247 @@ func TestFilterDeletedStale(t *testing.T) {
 		assert.Empty(t, removed)
 	})
 }
+
+func TestFilterPlansByMode(t *testing.T) {
+	esp := &kernel.BootPlan{Snapshot: mkSnapshot(1, "/.snapshots/1/snapshot"), Mode: kernel.BootModeESP}
+	btr := &kernel.BootPlan{Snapshot: mkSnapshot(2, "/.snapshots/2/snapshot"), Mode: kernel.BootModeBtrfs}
+	plans := []*kernel.BootPlan{esp, btr}
+
+	assert.Equal(t, []*kernel.BootPlan{esp}, filterPlansByMode(plans, kernel.BootModeESP))
+	assert.Equal(t, []*kernel.BootPlan{btr}, filterPlansByMode(plans, kernel.BootModeBtrfs))
+}
+
+func TestCheckESPOutsideSnapshots(t *testing.T) {
+	root := t.TempDir()
+	snapshotDir := filepath.Join(root, ".snapshots", "5", "snapshot")
+	require.NoError(t, os.MkdirAll(filepath.Join(snapshotDir, "boot", "efi"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "boot", "efi"), 0o755))
+	// A symlinked ESP path that lands inside the snapshot.
+	require.NoError(t, os.Symlink(filepath.Join(snapshotDir, "boot", "efi"), filepath.Join(root, "esp-link")))
+
+	snapshot := mkSnapshot(5, "/.snapshots/5/snapshot")
+	snapshot.FilesystemPath = snapshotDir
+	snapshots := []*btrfs.Snapshot{snapshot}
+
+	cfg := config.Defaults()
+	tests := []struct {
+		name    string
+		espPath string
+		wantErr bool
+	}{
+		{"real ESP", filepath.Join(root, "boot", "efi"), false},
+		{"inside snapshot", filepath.Join(snapshotDir, "boot", "efi"), true},
+		{"symlink into snapshot", filepath.Join(root, "esp-link"), true},
+		{"missing path inside snapshot", filepath.Join(snapshotDir, "mnt", "esp"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := &Pipeline{Cfg: &cfg, ESPPath: tt.espPath}
+			err := pipeline.checkESPOutsideSnapshots(snapshots)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "inside snapshot /.snapshots/5/snapshot")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	assert.True(t, pathWithin("/.snapshots/1/snapshot", "/.snapshots/1/snapshot"))
+	assert.True(t, pathWithin("/.snapshots/1/snapshot/boot/efi", "/.snapshots/1/snapshot"))
+	assert.False(t, pathWithin("/.snapshots/10/snapshot", "/.snapshots/1"))
+	assert.False(t, pathWithin("/boot/efi", "/.snapshots/1/snapshot"))
+}
+
+func TestProcessWritability_BootReadOnly(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Behavior.BootReadOnly = config.Truthy(true)
+	snapshot := mkSnapshot(1, "/.snapshots/1/snapshot")
+	snapshot.IsReadOnly = true
+
+	// No btrfs manager: any attempt to change a snapshot would panic.
+	pipeline := &Pipeline{Cfg: &cfg}
+	processed, timedOut, err := pipeline.processWritability(nil, []*btrfs.Snapshot{snapshot}, []*btrfs.Snapshot{snapshot}, new(skipLog))
+	require.NoError(t, err)
+	assert.Equal(t, []*btrfs.Snapshot{snapshot}, processed)
+	assert.Empty(t, timedOut)
+	assert.True(t, snapshot.IsReadOnly)
+}
+
+// hangingRunner records the commands it is given, hanging on those that
+// make a snapshot writable until released.
+type hangingRunner struct {
+	runner.DryRunner
+	release  chan struct{}
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *hangingRunner) Command(name string, args []string, description string) error {
+	r.mu.Lock()
+	r.commands = append(r.commands, strings.Join(append([]string{name}, args...), " "))
+	r.mu.Unlock()
+	if slices.Equal(args[len(args)-2:], []string{"ro", "false"}) {
+		<-r.release
+	}
+	return nil
+}
+
+func (r *hangingRunner) IsDryRun() bool { return false }
+
+func TestProcessWritability_ToggleTimeoutRestoresReadOnly(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Snapshot.WritableMethod = "toggle"
+	cfg.Behavior.TimeoutPerSnapshot = 10 * time.Millisecond
+	snapshot := mkSnapshot(1, "/.snapshots/1/snapshot")
+	snapshot.FilesystemPath = "/.snapshots/1/snapshot"
+	snapshot.IsReadOnly = true
+
+	r := &hangingRunner{release: make(chan struct{})}
+	defer close(r.release)
+	pipeline := &Pipeline{Cfg: &cfg, Btrfs: btrfs.NewManager(nil, 0, "", false), Runner: r}
+	var skipped skipLog
+	processed, timedOut, err := pipeline.processWritability(nil, []*btrfs.Snapshot{snapshot}, []*btrfs.Snapshot{snapshot}, &skipped)
+	require.NoError(t, err)
+	assert.Empty(t, processed)
+	assert.Equal(t, []string{snapshot.Path}, timedOut)
+	assert.Equal(t, skipLog{{Path: snapshot.Path, Reason: "timed out being made writable (behavior.timeout_per_snapshot)"}}, skipped)
+	assert.True(t, snapshot.IsReadOnly, "the abandoned step must not change the snapshot")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.Equal(t, []string{
+		"btrfs property set /.snapshots/1/snapshot ro false",
+		"btrfs property set /.snapshots/1/snapshot ro true",
+	}, r.commands)
+}
+
+// mutationRunner records every change it is asked to make.
+type mutationRunner struct {
+	runner.DryRunner
+	changes []string
+}
+
+func (r *mutationRunner) Command(name string, args []string, description string) error {
+	r.changes = append(r.changes, strings.Join(append([]string{name}, args...), " "))
+	return nil
+}
+
+func (r *mutationRunner) MkdirAll(path string, perm os.FileMode, description string) error {
+	r.changes = append(r.changes, "mkdir "+path)
+	return nil
+}
+
+func (r *mutationRunner) Remove(path string, description string) error {
+	r.changes = append(r.changes, "remove "+path)
+	return nil
+}
+
+func TestProcessWritability_KeepSnapshots(t *testing.T) {
+	for _, method := range []string{"toggle", "copy"} {
+		t.Run(method, func(t *testing.T) {
+			destDir := t.TempDir()
+			// An existing copy of snapshot 1 and an old one past selection.
+			for _, name := range []string{"rwsnap_2025-06-01_ID1", "rwsnap_2025-05-01_ID9"} {
+				require.NoError(t, os.Mkdir(filepath.Join(destDir, name), 0o755))
+			}
+			cfg := config.Defaults()
+			cfg.Snapshot.WritableMethod = method
+			cfg.Snapshot.DestinationDir = destDir
+			cfg.Snapshot.SelectionCount = 1
+			cfg.Behavior.CleanupOldSnapshots = true
+
+			readOnly := mkSnapshot(1, "/.snapshots/1/snapshot")
+			readOnly.IsReadOnly = true
+			writable := mkSnapshot(2, "/.snapshots/2/snapshot")
+			unselected := mkSnapshot(3, "/.snapshots/3/snapshot")
+
+			r := &mutationRunner{}
+			pipeline := &Pipeline{Cfg: &cfg, Btrfs: btrfs.NewManager(nil, 0, "", false), Runner: r, KeepSnapshots: true}
+			all := []*btrfs.Snapshot{readOnly, writable, unselected}
+			processed, timedOut, err := pipeline.processWritability(nil, all, []*btrfs.Snapshot{readOnly, writable}, new(skipLog))
+			require.NoError(t, err)
+			assert.Empty(t, timedOut)
+			assert.Empty(t, r.changes, "no snapshot may be changed")
+			assert.True(t, readOnly.IsReadOnly)
+
+			if method == "copy" {
+				require.Len(t, processed, 2)
+				assert.Equal(t, filepath.Join(destDir, "rwsnap_2025-06-01_ID1"), processed[0].FilesystemPath, "the existing copy is used")
+				assert.Same(t, writable, processed[1])
+			} else {
+				assert.Equal(t, []*btrfs.Snapshot{writable}, processed, "read-only snapshots are left out, not toggled")
+			}
+		})
+	}
+}
+
+// noSpaceRunner fails every writable copy for lack of space.
+type noSpaceRunner struct {
+	runner.DryRunner
+}
+
+func (r *noSpaceRunner) Command(name string, args []string, description string) error {
+	return errors.New("ERROR: cannot snapshot: No space left on device")
+}
+
+func (r *noSpaceRunner) IsDryRun() bool { return false }
+
+func TestProcessWritability_CopyOutOfSpaceRecordsSkips(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Snapshot.WritableMethod = "copy"
+	cfg.Snapshot.DestinationDir = t.TempDir()
+	first := mkSnapshot(1, "/.snapshots/1/snapshot")
+	first.IsReadOnly = true
+	second := mkSnapshot(2, "/.snapshots/2/snapshot")
+	second.IsReadOnly = true
+
+	var skipped skipLog
+	pipeline := &Pipeline{Cfg: &cfg, Btrfs: btrfs.NewManager(nil, 0, "", false), Runner: &noSpaceRunner{}}
+	selected := []*btrfs.Snapshot{first, second}
+	processed, _, err := pipeline.processWritability(nil, selected, selected, &skipped)
+	require.NoError(t, err)
+	assert.Empty(t, processed)
+
+	require.Len(t, skipped, 2)
+	assert.Equal(t, first.Path, skipped[0].Path)
+	assert.Contains(t, skipped[0].Reason, "failed to create its writable copy")
+	assert.Equal(t, SkippedSnapshot{Path: second.Path, Reason: "no writable copy created: the filesystem ran out of space"}, skipped[1])
+}
+
+func TestSourcePath(t *testing.T) {
+	snapshot := mkSnapshot(1, "/.snapshots/1/snapshot")
+	writable := mkSnapshot(100, "/.rwsnaps/rwsnap_2025-06-01_ID1")
+	writable.OriginalPath = snapshot.Path
+	snapshots := []*btrfs.Snapshot{snapshot, writable}
+
+	assert.Equal(t, snapshot.Path, sourcePath(snapshots, snapshot.Path))
+	assert.Equal(t, snapshot.Path, sourcePath(snapshots, writable.Path), "a copy is reported under its source")
+	assert.Equal(t, "/.snapshots/9/snapshot", sourcePath(snapshots, "/.snapshots/9/snapshot"))
+}
+
+func TestPipelineNewPlanner(t *testing.T) {
+	fsPath := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(fsPath, "etc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(fsPath, "etc", "fstab"), []byte("UUID=test-uuid / btrfs subvol=@/.snapshots/73/snapshot 0 1\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(fsPath, "boot"), 0o755))
+	for _, name := range []string{"vmlinuz-linux", "initramfs-linux.img", "initramfs-linux-fallback.img", "vmlinuz-linux-lts", "initramfs-linux-lts.img"} {
+		require.NoError(t, os.WriteFile(filepath.Join(fsPath, "boot", name), []byte("fake"), 0o644))
+	}
+	snapshot := mkSnapshot(256, "@/.snapshots/73/snapshot")
+	snapshot.FilesystemPath = fsPath
+
+	cfg := config.Defaults()
+	cfg.Kernel.BtrfsFallbackEntries = config.Truthy(true)
+	cfg.Kernel.BtrfsPreferredKernels = []string{"linux"}
+	cfg.Kernel.BtrfsEntriesPerSnapshot = 1
+	pipeline := &Pipeline{Cfg: &cfg, Fstab: fstab.NewManager()}
+
+	plans := pipeline.NewPlanner(&btrfs.Filesystem{UUID: "test-uuid", MountPoint: "/"}).Plan([]*btrfs.Snapshot{snapshot})
+	require.Len(t, plans, 2, "the preferred kernel and its fallback initramfs")
+	assert.Contains(t, plans[0].SnapshotKernel, "vmlinuz-linux")
+	assert.NotContains(t, plans[0].SnapshotKernel, "lts")
+	assert.True(t, plans[1].Fallback)
+}
@@ -0,0 +1,193 @@
+package generator
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/rs/zerolog/log"
+)
+
+// ESPSnapshotDir returns the ESP-relative directory holding the boot file
+// copies for snapshot under espBootDir: "<esp_boot_dir>/<fs uuid>/<id>",
+// with one subdirectory per kernel name beneath it. The filesystem UUID
+// keeps subvolume IDs from different volumes apart.
+func ESPSnapshotDir(espBootDir string, fs *btrfs.Filesystem, snapshot *btrfs.Snapshot) string {
+	return path.Join(espBootDir, fs.UUID, strconv.FormatUint(snapshot.ID, 10))
+}
+
+// attachESPCopies points each ESP-mode plan at a copy of its boot set's
+// kernel and initrds under behavior.esp_boot_dir (copy_boot_to_esp), so the
+// snapshot stays bootable after the live kernel is upgraded. A copy is only
+// planned while the snapshot is fresh for the boot set, when the live kernel
+// is known to match its modules; an existing copy is reused as-is. Nothing
+// is copied here: the returned copies, by plan, go into the patch and are
+// made when it is applied. None are planned with Pipeline.KeepSnapshots.
+func (p *Pipeline) attachESPCopies(fs *btrfs.Filesystem, plans []*kernel.BootPlan) map[*kernel.BootPlan][]diff.FileCopy {
+	if !p.Cfg.Behavior.CopyBootToESP.IsTrue() || p.KeepSnapshots {
+		return nil
+	}
+	if fs.UUID == "" {
+		log.Warn().Str("mountpoint", fs.MountPoint).Msg("Btrfs filesystem has no UUID, not copying boot files to the ESP")
+		return nil
+	}
+	if fs.UUIDShared {
+		log.Warn().Str("mountpoint", fs.MountPoint).Str("uuid", fs.UUID).Msg("Btrfs filesystem UUID is not unique, not copying boot files to the ESP")
+		return nil
+	}
+	copies := make(map[*kernel.BootPlan][]diff.FileCopy)
+	for _, plan := range plans {
+		if plan.Mode != kernel.BootModeESP || plan.BootSet == nil || plan.BootSet.Kernel == nil || plan.BootSet.Initramfs == nil {
+			continue
+		}
+		planCopies, err := p.attachESPCopy(fs, plan)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("snapshot", plan.Snapshot.Path).
+				Str("kernel", plan.BootSet.KernelName).
+				Msg("Failed to plan boot file copies to the ESP")
+			continue
+		}
+		if len(planCopies) > 0 {
+			copies[plan] = planCopies
+		}
+	}
+	return copies
+}
+
+func (p *Pipeline) attachESPCopy(fs *btrfs.Filesystem, plan *kernel.BootPlan) ([]diff.FileCopy, error) {
+	bs := plan.BootSet
+	dir := path.Join(ESPSnapshotDir(p.Cfg.Behavior.ESPBootDir, fs, plan.Snapshot), bs.KernelName)
+	absDir := filepath.Join(p.ESPPath, dir)
+	// Microcode is loaded before the initramfs, as in rEFInd's own entries.
+	initrds := append(slices.Clone(bs.Microcode), bs.Initramfs)
+
+	var copies []diff.FileCopy
+	if _, err := os.Stat(filepath.Join(absDir, bs.Kernel.Filename)); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if plan.Staleness == nil || plan.Staleness.StatusString() != "fresh" {
+			log.Debug().
+				Str("snapshot", plan.Snapshot.Path).
+				Str("kernel", bs.KernelName).
+				Msg("No ESP boot copy for snapshot and the live kernel is not known to match it")
+			return nil, nil
+		}
+		for _, img := range append([]*kernel.BootImage{bs.Kernel}, initrds...) {
+			copies = append(copies, diff.FileCopy{Source: img.AbsPath, Dest: filepath.Join(absDir, img.Filename)})
+		}
+		log.Info().
+			Str("snapshot", plan.Snapshot.Path).
+			Str("kernel", bs.KernelName).
+			Str("dir", dir).
+			Msg("Copying boot files to the ESP for snapshot")
+	}
+
+	plan.ESPKernel = path.Join(dir, bs.Kernel.Filename)
+	plan.ESPInitrds = nil
+	for _, img := range initrds {
+		if len(copies) > 0 || fileExists(filepath.Join(absDir, img.Filename)) {
+			plan.ESPInitrds = append(plan.ESPInitrds, path.Join(dir, img.Filename))
+		}
+	}
+	return copies, nil
+}
+
+// espCopiesForPlans returns the copies of the plans still in plans, in plan
+// order.
+func espCopiesForPlans(copies map[*kernel.BootPlan][]diff.FileCopy, plans []*kernel.BootPlan) []diff.FileCopy {
+	var out []diff.FileCopy
+	for _, plan := range plans {
+		out = append(out, copies[plan]...)
+	}
+	return out
+}
+
+// staleESPCopies returns the ESP-relative ESP boot copy directories under
+// fs's directory whose snapshot is no longer among existing, mirroring
+// CleanupOldSnapshots for writable copies. Nothing is removed here:
+// BuildPatch adds the directories to the patch. Skipped when fs shares its
+// UUID with another device, whose copies would live in the same directory,
+// and with Pipeline.KeepSnapshots.
+func (p *Pipeline) staleESPCopies(fs *btrfs.Filesystem, existing []*btrfs.Snapshot) []string {
+	if !p.Cfg.Behavior.CopyBootToESP.IsTrue() || !p.Cfg.Behavior.CleanupOldSnapshots.IsTrue() || p.KeepSnapshots || fs.UUID == "" || fs.UUIDShared {
+		return nil
+	}
+
+	fsDir := path.Join(p.Cfg.Behavior.ESPBootDir, fs.UUID)
+	entries, err := os.ReadDir(filepath.Join(p.ESPPath, fsDir))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("dir", fsDir).Msg("Failed to read ESP boot copy directory")
+		}
+		return nil
+	}
+
+	ids := make(map[string]bool, len(existing))
+	for _, snapshot := range existing {
+		ids[strconv.FormatUint(snapshot.ID, 10)] = true
+	}
+
+	var stale []string
+	for _, entry := range entries {
+		if !entry.IsDir() || ids[entry.Name()] {
+			continue
+		}
+		if _, err := strconv.ParseUint(entry.Name(), 10, 64); err != nil {
+			continue // not a copy we made
+		}
+		dir := path.Join(fsDir, entry.Name())
+		log.Info().Str("dir", dir).Msg("Removing ESP boot copy of deleted snapshot")
+		stale = append(stale, dir)
+	}
+	return stale
+}
+
+// addESPChanges adds plan's ESP boot copies and the removal of stale ones
+// to patch and summary.
+func (p *Pipeline) addESPChanges(plan *Plan, patch *diff.PatchDiff, summary *OperationSummary) {
+	for _, c := range plan.ESPCopies {
+		patch.AddCopy(c.Source, c.Dest)
+		summary.ESPCopies = append(summary.ESPCopies, c.Dest)
+	}
+	for _, dir := range plan.RemovedESPCopies {
+		if err := addTreeRemoval(patch, filepath.Join(p.ESPPath, dir)); err != nil {
+			log.Warn().Err(err).Str("dir", dir).Msg("Failed to read ESP boot copy")
+			continue
+		}
+		summary.RemovedESPCopies = append(summary.RemovedESPCopies, dir)
+	}
+}
+
+// addTreeRemoval adds root and everything beneath it to patch's removals,
+// deepest paths first so each directory is empty by the time it is removed.
+func addTreeRemoval(patch *diff.PatchDiff, root string) error {
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, _ os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range slices.Backward(paths) {
+		patch.AddRemoval(p)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
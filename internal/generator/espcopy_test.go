@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachESPCopies(t *testing.T) {
+	const copyDir = "/EFI/refind-btrfs-snapshots/root-uuid"
+
+	espPath := t.TempDir()
+	image := func(name string, role kernel.ImageRole) *kernel.BootImage {
+		abs := filepath.Join(espPath, name)
+		require.NoError(t, os.WriteFile(abs, []byte(name), 0o644))
+		return &kernel.BootImage{Path: "/" + name, AbsPath: abs, Filename: name, Role: role}
+	}
+	bootSet := &kernel.BootSet{
+		KernelName: "linux",
+		Layout:     kernel.LayoutSplit,
+		Kernel:     image("vmlinuz-linux", kernel.RoleKernel),
+		Initramfs:  image("initramfs-linux.img", kernel.RoleInitramfs),
+		Microcode:  []*kernel.BootImage{image("intel-ucode.img", kernel.RoleMicrocode)},
+	}
+	fresh := &kernel.StalenessResult{Method: kernel.MatchBinaryHeader}
+	stale := &kernel.StalenessResult{IsStale: true, Method: kernel.MatchBinaryHeader, Action: kernel.ActionDelete}
+	plan := func(id uint64, staleness *kernel.StalenessResult) *kernel.BootPlan {
+		return &kernel.BootPlan{
+			Snapshot:  mkSnapshot(id, fmt.Sprintf("/.snapshots/%d/snapshot", id)),
+			Mode:      kernel.BootModeESP,
+			Layout:    kernel.LayoutSplit,
+			BootSet:   bootSet,
+			Staleness: staleness,
+		}
+	}
+
+	// Snapshot 3 was copied on an earlier run, before the kernel upgrade.
+	previous := filepath.Join(espPath, copyDir, "3", "linux")
+	require.NoError(t, os.MkdirAll(previous, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(previous, "vmlinuz-linux"), []byte("old kernel"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(previous, "initramfs-linux.img"), []byte("old initramfs"), 0o644))
+
+	cfg := config.Defaults()
+	cfg.Behavior.CopyBootToESP = config.Truthy(true)
+	pipeline := &Pipeline{Cfg: &cfg, Runner: runner.New(false), ESPPath: espPath}
+	plans := []*kernel.BootPlan{plan(1, fresh), plan(2, stale), plan(3, stale)}
+
+	copies := pipeline.attachESPCopies(&btrfs.Filesystem{UUID: "root-uuid"}, plans)
+
+	// Fresh: a copy is planned, microcode ahead of the initramfs, but
+	// nothing is written until the patch is applied.
+	assert.Equal(t, copyDir+"/1/linux/vmlinuz-linux", plans[0].ESPKernel)
+	assert.Equal(t, []string{copyDir + "/1/linux/intel-ucode.img", copyDir + "/1/linux/initramfs-linux.img"}, plans[0].ESPInitrds)
+	dest := filepath.Join(espPath, copyDir, "1", "linux")
+	assert.Equal(t, []diff.FileCopy{
+		{Source: bootSet.Kernel.AbsPath, Dest: filepath.Join(dest, "vmlinuz-linux")},
+		{Source: bootSet.Microcode[0].AbsPath, Dest: filepath.Join(dest, "intel-ucode.img")},
+		{Source: bootSet.Initramfs.AbsPath, Dest: filepath.Join(dest, "initramfs-linux.img")},
+	}, espCopiesForPlans(copies, plans))
+	assert.NoDirExists(t, dest)
+
+	// Stale without a copy: the live kernel doesn't match, nothing to copy.
+	assert.Empty(t, plans[1].ESPKernel)
+	assert.True(t, plans[1].ShouldSkip())
+	assert.NoDirExists(t, filepath.Join(espPath, copyDir, "2"))
+
+	// Stale with a copy: the copy is reused untouched and the plan is bootable.
+	assert.Equal(t, copyDir+"/3/linux/vmlinuz-linux", plans[2].ESPKernel)
+	assert.Equal(t, []string{copyDir + "/3/linux/initramfs-linux.img"}, plans[2].ESPInitrds)
+	assert.False(t, plans[2].IsStale())
+	assert.False(t, plans[2].ShouldSkip())
+	assert.Empty(t, copies[plans[2]])
+}
+
+func TestAddESPChanges(t *testing.T) {
+	espPath := t.TempDir()
+	staleDir := filepath.Join(espPath, "/EFI/refind-btrfs-snapshots/root-uuid/2")
+	require.NoError(t, os.MkdirAll(filepath.Join(staleDir, "linux"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(staleDir, "linux", "vmlinuz-linux"), nil, 0o644))
+
+	cfg := config.Defaults()
+	pipeline := &Pipeline{Cfg: &cfg, Runner: runner.New(false), ESPPath: espPath}
+	copied := diff.FileCopy{Source: "/boot/vmlinuz-linux", Dest: espPath + "/EFI/refind-btrfs-snapshots/root-uuid/7/linux/vmlinuz-linux"}
+	plan := &Plan{
+		ESPCopies:        []diff.FileCopy{copied},
+		RemovedESPCopies: []string{"/EFI/refind-btrfs-snapshots/root-uuid/2"},
+	}
+	patch := diff.NewPatchDiff()
+	summary := &OperationSummary{}
+
+	pipeline.addESPChanges(plan, patch, summary)
+
+	// Copies and removals are only in the patch, shown by its diff.
+	assert.Equal(t, []diff.FileCopy{copied}, patch.Copies)
+	assert.Equal(t, []string{
+		filepath.Join(staleDir, "linux", "vmlinuz-linux"),
+		filepath.Join(staleDir, "linux"),
+		staleDir,
+	}, patch.Removals)
+	assert.Contains(t, patch.Generate(), "Remove "+staleDir+"\n")
+	assert.Equal(t, []string{copied.Dest}, summary.ESPCopies)
+	assert.Equal(t, plan.RemovedESPCopies, summary.RemovedESPCopies)
+	assert.DirExists(t, staleDir)
+}
+
+func TestStaleESPCopies(t *testing.T) {
+	const fsDir = "/EFI/refind-btrfs-snapshots/root-uuid"
+
+	espPath := t.TempDir()
+	for _, dir := range []string{"1/linux", "2/linux", "2/linux-lts", "notes"} {
+		abs := filepath.Join(espPath, fsDir, dir)
+		require.NoError(t, os.MkdirAll(abs, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(abs, "vmlinuz"), nil, 0o644))
+	}
+	// Another volume's copies are never touched.
+	other := filepath.Join(espPath, "/EFI/refind-btrfs-snapshots/other-uuid/2/linux")
+	require.NoError(t, os.MkdirAll(other, 0o755))
+
+	cfg := config.Defaults()
+	cfg.Behavior.CopyBootToESP = config.Truthy(true)
+	pipeline := &Pipeline{Cfg: &cfg, Runner: runner.New(false), ESPPath: espPath}
+
+	stale := pipeline.staleESPCopies(&btrfs.Filesystem{UUID: "root-uuid"}, []*btrfs.Snapshot{mkSnapshot(1, "/.snapshots/1/snapshot")})
+
+	// Only listed: removal waits for the patch to be applied.
+	assert.Equal(t, []string{fsDir + "/2"}, stale)
+	assert.DirExists(t, filepath.Join(espPath, fsDir, "2"))
+}
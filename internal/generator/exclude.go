@@ -0,0 +1,164 @@
+package generator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/rs/zerolog/log"
+)
+
+// ExcludeKernels splits bootSets into those kept and those whose kernel name
+// matches one of names (case-insensitive). Called at the cmd boundary before
+// planning so excluded kernels never reach the planner or the generator.
+func ExcludeKernels(bootSets []*kernel.BootSet, names []string) (kept, excluded []*kernel.BootSet) {
+	if len(names) == 0 {
+		return bootSets, nil
+	}
+	for _, bs := range bootSets {
+		if slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, bs.KernelName) }) {
+			log.Info().Str("kernel", bs.KernelName).Msg("Excluding kernel from snapshot generation")
+			excluded = append(excluded, bs)
+			continue
+		}
+		kept = append(kept, bs)
+	}
+	return kept, excluded
+}
+
+// FilterKernels splits bootSets into those matching one of names, per
+// kernel.filter, and the rest. A boot set matches a name equal to its kernel
+// name or its kernel (or UKI) image's file name, case-insensitively. An
+// empty filter keeps every boot set. Applied before ExcludeKernels, so an
+// excluded kernel stays excluded even when the filter names it.
+func FilterKernels(bootSets []*kernel.BootSet, names []string) (kept, dropped []*kernel.BootSet) {
+	if len(names) == 0 {
+		return bootSets, nil
+	}
+	for _, bs := range bootSets {
+		if kernelMatches(bs, names) {
+			kept = append(kept, bs)
+			continue
+		}
+		log.Debug().Str("kernel", bs.KernelName).Strs("kernel_filter", names).Msg("Kernel not matched by kernel.filter")
+		dropped = append(dropped, bs)
+	}
+	return kept, dropped
+}
+
+func kernelMatches(bs *kernel.BootSet, names []string) bool {
+	var image string
+	if img := bs.PrimaryImage(); img != nil {
+		image = path.Base(img.Path)
+	}
+	return slices.ContainsFunc(names, func(name string) bool {
+		return strings.EqualFold(name, bs.KernelName) || (image != "" && strings.EqualFold(name, image))
+	})
+}
+
+// filterKernelEntries keeps source entries, per kernel.filter, whose loader
+// file name is one of names or the image file name of a boot set matching
+// them, so btrfs-mode entries loading the same kernel from inside the
+// volume match too. Every entry is kept when names is empty.
+func filterKernelEntries(entries []*refind.MenuEntry, names []string, bootSets []*kernel.BootSet) []*refind.MenuEntry {
+	if len(names) == 0 {
+		return entries
+	}
+	fileNames := slices.Clone(names)
+	for _, bs := range bootSets {
+		if img := bs.PrimaryImage(); img != nil && kernelMatches(bs, names) {
+			fileNames = append(fileNames, path.Base(img.Path))
+		}
+	}
+
+	var out []*refind.MenuEntry
+	for _, entry := range entries {
+		_, loader := refind.SplitVolumePath(entry.Loader)
+		loader = path.Base(strings.ReplaceAll(loader, `\`, "/"))
+		if !slices.ContainsFunc(fileNames, func(name string) bool { return strings.EqualFold(name, loader) }) {
+			log.Debug().Str("title", entry.Title).Str("loader", entry.Loader).Msg("Skipping source entry not matched by kernel.filter")
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// excludeKernelEntries drops source entries whose loader is the kernel or
+// UKI image of an excluded boot set. Loader paths are compared
+// case-insensitively with backslashes normalised, as rEFInd does on FAT,
+// and without any volume qualifier.
+func excludeKernelEntries(entries []*refind.MenuEntry, excluded []*kernel.BootSet) []*refind.MenuEntry {
+	if len(excluded) == 0 {
+		return entries
+	}
+	var loaders []string
+	for _, bs := range excluded {
+		if img := bs.PrimaryImage(); img != nil {
+			loaders = append(loaders, img.Path)
+		}
+	}
+
+	var out []*refind.MenuEntry
+	for _, entry := range entries {
+		_, loader := refind.SplitVolumePath(entry.Loader)
+		loader = strings.ReplaceAll(loader, `\`, "/")
+		if slices.ContainsFunc(loaders, func(l string) bool { return strings.EqualFold(l, loader) }) {
+			log.Debug().Str("title", entry.Title).Str("loader", entry.Loader).Msg("Skipping source entry for excluded kernel")
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// filterEntriesByTitle keeps source entries whose title matches at least
+// one include pattern (all, when include is empty) and no exclude pattern,
+// per refind.source_title_include/source_title_exclude.
+func filterEntriesByTitle(entries []*refind.MenuEntry, include, exclude []string) ([]*refind.MenuEntry, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return entries, nil
+	}
+	includeRes, err := compilePatterns(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refind.source_title_include: %w", err)
+	}
+	excludeRes, err := compilePatterns(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refind.source_title_exclude: %w", err)
+	}
+
+	var out []*refind.MenuEntry
+	for _, entry := range entries {
+		if len(includeRes) > 0 && !matchesAny(includeRes, entry.Title) {
+			log.Debug().Str("title", entry.Title).Msg("Skipping source entry not matched by source_title_include")
+			continue
+		}
+		if matchesAny(excludeRes, entry.Title) {
+			log.Debug().Str("title", entry.Title).Msg("Skipping source entry matched by source_title_exclude")
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	return slices.ContainsFunc(res, func(re *regexp.Regexp) bool { return re.MatchString(s) })
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
@@ -0,0 +1,135 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/stretchr/testify/assert"
+)
+
+func mkSplitBootSet(name string) *kernel.BootSet {
+	return &kernel.BootSet{
+		KernelName: name,
+		Layout:     kernel.LayoutSplit,
+		Kernel:     &kernel.BootImage{Path: "/boot/vmlinuz-" + name, KernelName: name},
+	}
+}
+
+func TestExcludeKernels(t *testing.T) {
+	linux := mkSplitBootSet("linux")
+	lts := mkSplitBootSet("linux-lts")
+	debug := mkSplitBootSet("linux-debug")
+	sets := []*kernel.BootSet{linux, lts, debug}
+
+	tests := []struct {
+		name         string
+		names        []string
+		wantKept     []*kernel.BootSet
+		wantExcluded []*kernel.BootSet
+	}{
+		{name: "no_names_keeps_all", names: nil, wantKept: sets},
+		{name: "single_match", names: []string{"linux-debug"}, wantKept: []*kernel.BootSet{linux, lts}, wantExcluded: []*kernel.BootSet{debug}},
+		{name: "case_insensitive", names: []string{"LINUX-LTS"}, wantKept: []*kernel.BootSet{linux, debug}, wantExcluded: []*kernel.BootSet{lts}},
+		{name: "repeated_flag", names: []string{"linux", "linux-debug"}, wantKept: []*kernel.BootSet{lts}, wantExcluded: []*kernel.BootSet{linux, debug}},
+		{name: "unknown_name_ignored", names: []string{"linux-zen"}, wantKept: sets},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, excluded := ExcludeKernels(sets, tt.names)
+			assert.Equal(t, tt.wantKept, kept)
+			assert.Equal(t, tt.wantExcluded, excluded)
+		})
+	}
+}
+
+func TestFilterKernels(t *testing.T) {
+	linux := mkSplitBootSet("linux")
+	lts := mkSplitBootSet("linux-lts")
+	zen := mkSplitBootSet("linux-zen")
+	sets := []*kernel.BootSet{linux, lts, zen}
+
+	tests := []struct {
+		name        string
+		names       []string
+		wantKept    []*kernel.BootSet
+		wantDropped []*kernel.BootSet
+	}{
+		{name: "no_filter_keeps_all", names: nil, wantKept: sets},
+		{name: "kernel_name", names: []string{"linux-lts"}, wantKept: []*kernel.BootSet{lts}, wantDropped: []*kernel.BootSet{linux, zen}},
+		{name: "image_name", names: []string{"VMLINUZ-linux-zen"}, wantKept: []*kernel.BootSet{zen}, wantDropped: []*kernel.BootSet{linux, lts}},
+		{name: "no_match", names: []string{"linux-hardened"}, wantDropped: sets},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := FilterKernels(sets, tt.names)
+			assert.Equal(t, tt.wantKept, kept)
+			assert.Equal(t, tt.wantDropped, dropped)
+		})
+	}
+}
+
+func TestFilterKernelEntries(t *testing.T) {
+	entries := []*refind.MenuEntry{
+		{Title: "Arch", Loader: "/boot/vmlinuz-linux"},
+		{Title: "Arch LTS", Loader: `\boot\VMLINUZ-linux-lts`},
+		{Title: "Arch LTS (btrfs)", Loader: "/@/boot/vmlinuz-linux-lts"},
+		{Title: "Other", Loader: "/EFI/other/grubx64.efi"},
+	}
+	sets := []*kernel.BootSet{mkSplitBootSet("linux"), mkSplitBootSet("linux-lts")}
+
+	got := filterKernelEntries(entries, []string{"linux-lts"}, sets)
+	assert.Equal(t, []*refind.MenuEntry{entries[1], entries[2]}, got, "matched by the boot set's image")
+
+	got = filterKernelEntries(entries, []string{"vmlinuz-linux-lts"}, nil)
+	assert.Equal(t, []*refind.MenuEntry{entries[1], entries[2]}, got, "matched by loader file name")
+
+	assert.Equal(t, entries, filterKernelEntries(entries, nil, sets), "no filter leaves entries untouched")
+}
+
+func TestExcludeKernelEntries(t *testing.T) {
+	entries := []*refind.MenuEntry{
+		{Title: "Arch", Loader: "/boot/vmlinuz-linux"},
+		{Title: "Arch debug", Loader: `\boot\VMLINUZ-linux-debug`},
+		{Title: "Other", Loader: "/EFI/other/grubx64.efi"},
+		{Title: "Arch debug on ESP", Loader: `ESP:\boot\vmlinuz-linux-debug`},
+	}
+
+	got := excludeKernelEntries(entries, []*kernel.BootSet{mkSplitBootSet("linux-debug")})
+	assert.Equal(t, []*refind.MenuEntry{entries[0], entries[2]}, got)
+
+	assert.Equal(t, entries, excludeKernelEntries(entries, nil), "no exclusions leaves entries untouched")
+}
+
+func TestFilterEntriesByTitle(t *testing.T) {
+	normal := &refind.MenuEntry{Title: "Arch Linux"}
+	debug := &refind.MenuEntry{Title: "Arch Linux (debug)"}
+	lts := &refind.MenuEntry{Title: "Arch Linux LTS"}
+	entries := []*refind.MenuEntry{normal, debug, lts}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []*refind.MenuEntry
+	}{
+		{name: "no_patterns_keeps_all", want: entries},
+		{name: "exclude", exclude: []string{`\(debug\)`}, want: []*refind.MenuEntry{normal, lts}},
+		{name: "include", include: []string{`LTS$`}, want: []*refind.MenuEntry{lts}},
+		{name: "include_any_of", include: []string{`^Arch Linux$`, `LTS`}, want: []*refind.MenuEntry{normal, lts}},
+		{name: "exclude_wins", include: []string{`^Arch`}, exclude: []string{`debug`}, want: []*refind.MenuEntry{normal, lts}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterEntriesByTitle(entries, tt.include, tt.exclude)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := filterEntriesByTitle(entries, []string{"("}, nil)
+	assert.Error(t, err)
+}
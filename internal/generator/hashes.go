@@ -0,0 +1,22 @@
+package generator
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// SaveHashes writes the --verify-hashes sidecar through the runner, so dry
+// runs only report the write. No-op when hash verification is disabled.
+func (p *Pipeline) SaveHashes(path string) error {
+	if p.Hashes == nil {
+		return nil
+	}
+	data, err := p.Hashes.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode hash sidecar: %w", err)
+	}
+	if err := p.Runner.MkdirAll(filepath.Dir(path), 0o755, fmt.Sprintf("Create directory for %s", path)); err != nil {
+		return err
+	}
+	return p.Runner.WriteFile(path, append(data, '\n'), 0o644, fmt.Sprintf("Write %s", path))
+}
@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/rs/zerolog/log"
+)
+
+// BuildMigratePatch wraps the legacy, marker-less snapshot entries in the
+// refind_linux.conf files in the current section markers, without scanning
+// btrfs. The managed config is checked too but never needs changes.
+func (p *Pipeline) BuildMigratePatch() (*diff.PatchDiff, error) {
+	patch := diff.NewPatchDiff()
+	parser := refind.NewParser(p.ESPPath)
+	gen := refind.NewGenerator(p.ESPPath, p.Cfg.Advanced.Naming.MenuFormat, p.Cfg.Display.LocalTime.IsTrue())
+	gen.SetPreserveCRLF(p.Cfg.Refind.PreserveCRLF.IsTrue())
+
+	paths, err := parser.FindRefindLinuxConfigs()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to find refind_linux.conf files")
+	}
+	paths = append(paths, parser.GetManagedConfigPath(p.resolveRefindConfigPath(parser)))
+
+	for _, path := range paths {
+		fileDiff, err := gen.MigrateConfigDiff(path)
+		if err != nil {
+			return nil, err
+		}
+		if fileDiff != nil {
+			patch.AddFile(fileDiff)
+		}
+	}
+	return patch, nil
+}
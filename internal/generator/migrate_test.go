@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMigratePatch(t *testing.T) {
+	espPath := t.TempDir()
+	refindDir := filepath.Join(espPath, "EFI", "refind")
+	kernelDir := filepath.Join(espPath, "EFI", "arch")
+	require.NoError(t, os.MkdirAll(refindDir, 0o755))
+	require.NoError(t, os.MkdirAll(kernelDir, 0o755))
+
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind.conf"), []byte("include refind-btrfs-snapshots.conf\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(kernelDir, "refind_linux.conf"), []byte(`"Boot" "rw"
+# Snapshot entries generated by refind-btrfs-snapshots
+"Boot (2025-06-14T10:00:00Z)" "rw"
+`), 0o644))
+
+	cfg := config.Defaults()
+	pipeline := &Pipeline{Cfg: &cfg, Runner: runner.New(true), ESPPath: espPath}
+
+	patch, err := pipeline.BuildMigratePatch()
+	require.NoError(t, err)
+	require.Len(t, patch.Files, 1)
+	assert.Equal(t, "\"Boot\" \"rw\"\n\n##refind-btrfs-snapshots-start\n\"Boot (2025-06-14T10:00:00Z)\" \"rw\"\n##refind-btrfs-snapshots-end\n", patch.Files[0].Modified)
+}
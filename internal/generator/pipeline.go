@@ -7,8 +7,10 @@ package generator
 import (
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
 )
 
@@ -24,6 +26,42 @@ type Pipeline struct {
 	ESPPath       string
 	KernelScanner *kernel.Scanner
 	BootSets      []*kernel.BootSet
+
+	// ExcludedBootSets are boot sets dropped by ExcludeKernels or
+	// FilterKernels; source entries that load them are skipped when
+	// building the patch.
+	ExcludedBootSets []*kernel.BootSet
+
+	// OnlyMode, when set, keeps only boot plans of that mode and carries
+	// existing entries for snapshots of the other mode over unchanged.
+	OnlyMode kernel.BootMode
+
+	// RefindLinuxOnly suppresses the managed include file: only
+	// refind_linux.conf files are updated (--refind-linux-only).
+	RefindLinuxOnly bool
+
+	// FstabOnly limits BuildPatch to the snapshots' fstab and crypttab
+	// rewrites, leaving every rEFInd config untouched
+	// (--refresh-fstab-only).
+	FstabOnly bool
+
+	// KeepSnapshots makes Discover change no snapshot: none is made
+	// writable or copied, and neither old copies nor writability are
+	// cleaned up. Only snapshots already writable, or with a writable copy,
+	// are processed (--refresh-fstab-only).
+	KeepSnapshots bool
+
+	// NoWriteMarkers writes refind_linux.conf snapshot entries without the
+	// section markers, handing them over to the user (--no-write-markers).
+	NoWriteMarkers bool
+
+	// Hashes, when set, verifies in-snapshot boot files during btrfs-mode
+	// planning (--verify-hashes). Persist it with SaveHashes.
+	Hashes *kernel.HashStore
+
+	// SelfCheck makes BuildPatch read back each generated rEFInd config and
+	// record discrepancies in the summary (--selfcheck).
+	SelfCheck bool
 }
 
 // Plan is the typed result of Pipeline.Discover: the snapshots that will
@@ -35,4 +73,38 @@ type Plan struct {
 	ProcessedSnapshots []*btrfs.Snapshot
 	BootPlans          []*kernel.BootPlan
 	Removed            []string
+
+	// ESPCopies are the boot files to copy onto the ESP for the plans that
+	// boot from a copy, and RemovedESPCopies the ESP-relative boot copy
+	// directories of deleted snapshots (behavior.copy_boot_to_esp). Both are
+	// only planned by discovery; BuildPatch adds them to the patch.
+	ESPCopies        []diff.FileCopy
+	RemovedESPCopies []string
+
+	// TimedOut are the snapshots skipped because processing them exceeded
+	// behavior.timeout_per_snapshot.
+	TimedOut []string
+
+	// Skipped are the snapshots found but left without boot entries, with
+	// the reason for each, in the order they were dropped (--explain-skips).
+	Skipped []SkippedSnapshot
+
+	// Volumes is set by DiscoverAll: the processed snapshots grouped by the
+	// filesystem they live on. Nil for single-volume discovery.
+	Volumes []refind.VolumeSnapshots
+}
+
+// SkippedSnapshot is a snapshot discovery dropped, and why.
+type SkippedSnapshot struct {
+	Path   string
+	Reason string
+}
+
+// volumes returns the plan's snapshots grouped by filesystem. Single-volume
+// plans yield one group for RootFS.
+func (pl *Plan) volumes() []refind.VolumeSnapshots {
+	if len(pl.Volumes) > 0 {
+		return pl.Volumes
+	}
+	return []refind.VolumeSnapshots{{FS: pl.RootFS, Snapshots: pl.ProcessedSnapshots}}
 }
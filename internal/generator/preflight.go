@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/rs/zerolog/log"
+)
+
+// CheckRefindInstalled makes sure rEFInd is installed where its configs are
+// about to be written (--esp-ro-check): the main config must be readable,
+// and a rEFInd EFI binary for this architecture must sit readable beside
+// it. Otherwise generated configs would be orphaned on an ESP nothing boots.
+func (p *Pipeline) CheckRefindInstalled() error {
+	configPath := p.resolveRefindConfigPath(refind.NewParser(p.ESPPath))
+	if err := readFirstBytes(configPath, nil); err != nil {
+		return fmt.Errorf("rEFInd doesn't appear to be installed on the ESP at %s: %w; install it with refind-install, or point --esp-path and --config-path at the ESP rEFInd boots from", p.ESPPath, err)
+	}
+
+	binary, err := refind.FindBinary(filepath.Dir(configPath), runtime.GOARCH)
+	if err != nil {
+		return fmt.Errorf("rEFInd doesn't appear to be installed beside %s: %w; install it with refind-install, or point --config-path at the refind.conf next to the rEFInd binary", configPath, err)
+	}
+	if err := readFirstBytes(binary, []byte("MZ")); err != nil {
+		return fmt.Errorf("rEFInd binary %s isn't usable: %w; reinstall rEFInd with refind-install", binary, err)
+	}
+
+	log.Info().Str("config", configPath).Str("binary", binary).Msg("Found rEFInd installed on the ESP")
+	return nil
+}
+
+// readFirstBytes opens path and reads its first bytes, which must be magic
+// when given. EFI binaries are PE images starting with "MZ".
+func readFirstBytes(path string, magic []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !bytes.Equal(head, magic) {
+		return fmt.Errorf("%s is not an EFI binary", path)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRefindInstalled(t *testing.T) {
+	arch, known := map[string]string{"amd64": "x64", "arm64": "aa64"}[runtime.GOARCH]
+	if !known {
+		t.Skip("binary names below assume amd64 or arm64")
+	}
+	binary := "refind_" + arch + ".efi"
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "nothing installed",
+			wantErr: "rEFInd doesn't appear to be installed",
+		},
+		{
+			name:    "config without binary",
+			files:   map[string]string{"EFI/refind/refind.conf": "timeout 5\n"},
+			wantErr: binary,
+		},
+		{
+			name: "refind binary",
+			files: map[string]string{
+				"EFI/refind/refind.conf": "timeout 5\n",
+				"EFI/refind/" + binary:   "MZ\x90\x00",
+			},
+		},
+		{
+			name: "fallback loader",
+			files: map[string]string{
+				"EFI/BOOT/refind.conf":                           "timeout 5\n",
+				"EFI/BOOT/BOOT" + strings.ToUpper(arch) + ".EFI": "MZ\x90\x00",
+			},
+		},
+		{
+			name: "truncated binary",
+			files: map[string]string{
+				"EFI/refind/refind.conf": "timeout 5\n",
+				"EFI/refind/" + binary:   "",
+			},
+			wantErr: "isn't usable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			espPath := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(espPath, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			}
+			cfg := config.Defaults()
+			pipeline := &Pipeline{Cfg: &cfg, ESPPath: espPath}
+
+			err := pipeline.CheckRefindInstalled()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/version"
+	"github.com/rs/zerolog"
+)
+
+// Report is a human-readable account of one generate run (--report): the
+// detected system, what happened to each snapshot, and the warnings logged
+// along the way. It is meant to be attached to bug reports. Error is the
+// error the run failed with, if any.
+type Report struct {
+	GeneratedAt time.Time
+	Version     string
+	DryRun      bool
+	Error       string
+	ESPPath     string
+	Filesystems []*btrfs.Filesystem
+	BootSets    []*kernel.BootSet
+	Snapshots   []ReportSnapshot
+	Removed     []string
+	Changed     []string
+	Warnings    []string
+}
+
+// ReportSnapshot is one processed snapshot's row in the report.
+type ReportSnapshot struct {
+	Path    string
+	Time    string
+	Mode    string
+	Status  string
+	Entries []string
+}
+
+// NewReport assembles a Report from a run's plan and summary. A run that
+// failed before discovery or building finished passes nil for them.
+func (p *Pipeline) NewReport(plan *Plan, summary *OperationSummary, warnings []string) *Report {
+	report := &Report{
+		GeneratedAt: time.Now(),
+		Version:     version.String(),
+		DryRun:      p.Runner != nil && p.Runner.IsDryRun(),
+		ESPPath:     p.ESPPath,
+		BootSets:    p.BootSets,
+		Warnings:    warnings,
+	}
+	if plan == nil {
+		return report
+	}
+	report.Removed = plan.Removed
+	if summary == nil {
+		summary = &OperationSummary{}
+	}
+	report.Changed = append(report.Changed, summary.UpdatedConfigs...)
+	report.Changed = append(report.Changed, summary.UpdatedFstabs...)
+	report.Changed = append(report.Changed, summary.UpdatedCrypttabs...)
+
+	plansBySnapshot := kernel.GroupBySnapshot(plan.BootPlans)
+	for _, v := range plan.volumes() {
+		report.Filesystems = append(report.Filesystems, v.FS)
+
+		var entries []*refind.MenuEntry
+		for _, entry := range summary.SourceEntries {
+			if refind.IsBootable(entry, v.FS) {
+				entries = append(entries, entry)
+			}
+		}
+
+		for _, snapshot := range v.Snapshots {
+			name := p.formatSnapshotName(snapshot)
+			row := ReportSnapshot{
+				Path:   snapshot.Path,
+				Time:   btrfs.FormatSnapshotTimeForDisplay(snapshot.SnapshotTime, p.Cfg.Display.LocalTime.IsTrue()),
+				Mode:   string(kernel.BootModeESP),
+				Status: "no boot plan",
+			}
+			if plans := plansBySnapshot[snapshot.Path]; len(plans) > 0 {
+				row.Mode = string(plans[0].Mode)
+				row.Status = reportStatus(plans)
+			}
+			for _, entry := range entries {
+				row.Entries = append(row.Entries, fmt.Sprintf("%s (%s)", entry.Title, name))
+			}
+			report.Snapshots = append(report.Snapshots, row)
+		}
+	}
+	return report
+}
+
+// reportStatus summarises a snapshot's plans: btrfs-mode snapshots carry
+// their own kernel; ESP-mode ones report freshness per boot set.
+func reportStatus(plans []*kernel.BootPlan) string {
+	var parts []string
+	for _, plan := range plans {
+		switch {
+		case plan.Fallback:
+			parts = append(parts, filepath.Base(plan.SnapshotKernel)+": in-snapshot kernel, fallback initramfs")
+		case plan.Mode == kernel.BootModeBtrfs:
+			parts = append(parts, filepath.Base(plan.SnapshotKernel)+": in-snapshot kernel")
+		case plan.HasESPCopy():
+			parts = append(parts, plan.BootSet.KernelName+": ESP boot copy")
+		case plan.Staleness == nil:
+			parts = append(parts, "not checked")
+		case plan.BootSet != nil && plan.Staleness.IsStale:
+			parts = append(parts, fmt.Sprintf("%s: stale (%s, action=%s)", plan.BootSet.KernelName, plan.Staleness.Reason, plan.Staleness.Action))
+		case plan.BootSet != nil:
+			parts = append(parts, plan.BootSet.KernelName+": "+plan.Staleness.StatusString())
+		default:
+			parts = append(parts, plan.Staleness.StatusString())
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Write renders the report to path, as HTML when the extension is .html or
+// .htm and Markdown otherwise. The report is written directly rather than
+// through the runner so it is produced under --dry-run too.
+func (r *Report) Write(path string) error {
+	var content string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		content = r.HTML()
+	default:
+		content = r.Markdown()
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// Markdown renders the report as a Markdown document.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+
+	b.WriteString("# refind-btrfs-snapshots report\n\n")
+	fmt.Fprintf(&b, "- Generated: %s\n", r.GeneratedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Version: %s\n", r.Version)
+	fmt.Fprintf(&b, "- Dry run: %t\n", r.DryRun)
+	if r.Error != "" {
+		fmt.Fprintf(&b, "- Error: %s\n", r.Error)
+	}
+
+	b.WriteString("\n## System\n\n")
+	fmt.Fprintf(&b, "- ESP: `%s`\n", r.ESPPath)
+	for _, fs := range r.Filesystems {
+		fmt.Fprintf(&b, "- Btrfs filesystem: `%s` (%s), mounted at `%s`, subvolume `%s`\n",
+			fs.GetBestIdentifier(), fs.Device, fs.MountPoint, reportSubvolume(fs))
+	}
+
+	b.WriteString("\n### Kernels\n\n")
+	if len(r.BootSets) == 0 {
+		b.WriteString("No boot images detected on the ESP.\n")
+	} else {
+		b.WriteString("| Kernel | Layout | Version | Image |\n|---|---|---|---|\n")
+		for _, bs := range r.BootSets {
+			fmt.Fprintf(&b, "| %s | %s | %s | `%s` |\n", markdownCell(bs.KernelName), bs.Layout, markdownCell(bs.KernelVersion()), reportImagePath(bs))
+		}
+	}
+
+	b.WriteString("\n## Snapshots\n\n")
+	if len(r.Snapshots) == 0 {
+		b.WriteString("No snapshots were processed.\n")
+	} else {
+		b.WriteString("| Snapshot | Time | Mode | Status | Boot entries |\n|---|---|---|---|---|\n")
+		for _, s := range r.Snapshots {
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n",
+				s.Path, s.Time, s.Mode, markdownCell(s.Status), markdownCell(strings.Join(s.Entries, "<br>")))
+		}
+	}
+
+	writeMarkdownList(&b, "Removed snapshots", r.Removed)
+	writeMarkdownList(&b, "Changed files", r.Changed)
+	writeMarkdownList(&b, "Warnings", r.Warnings)
+
+	return b.String()
+}
+
+// HTML renders the report as a standalone HTML document.
+func (r *Report) HTML() string {
+	var b strings.Builder
+	esc := html.EscapeString
+
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>refind-btrfs-snapshots report</title>\n</head>\n<body>\n")
+	b.WriteString("<h1>refind-btrfs-snapshots report</h1>\n<ul>\n")
+	fmt.Fprintf(&b, "<li>Generated: %s</li>\n", esc(r.GeneratedAt.UTC().Format(time.RFC3339)))
+	fmt.Fprintf(&b, "<li>Version: %s</li>\n", esc(r.Version))
+	fmt.Fprintf(&b, "<li>Dry run: %t</li>\n", r.DryRun)
+	if r.Error != "" {
+		fmt.Fprintf(&b, "<li>Error: %s</li>\n", esc(r.Error))
+	}
+	b.WriteString("</ul>\n")
+
+	b.WriteString("<h2>System</h2>\n<ul>\n")
+	fmt.Fprintf(&b, "<li>ESP: <code>%s</code></li>\n", esc(r.ESPPath))
+	for _, fs := range r.Filesystems {
+		fmt.Fprintf(&b, "<li>Btrfs filesystem: <code>%s</code> (%s), mounted at <code>%s</code>, subvolume <code>%s</code></li>\n",
+			esc(fs.GetBestIdentifier()), esc(fs.Device), esc(fs.MountPoint), esc(reportSubvolume(fs)))
+	}
+	b.WriteString("</ul>\n")
+
+	b.WriteString("<h3>Kernels</h3>\n")
+	if len(r.BootSets) == 0 {
+		b.WriteString("<p>No boot images detected on the ESP.</p>\n")
+	} else {
+		b.WriteString("<table>\n<tr><th>Kernel</th><th>Layout</th><th>Version</th><th>Image</th></tr>\n")
+		for _, bs := range r.BootSets {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td><td><code>%s</code></td></tr>\n",
+				esc(bs.KernelName), esc(string(bs.Layout)), esc(bs.KernelVersion()), esc(reportImagePath(bs)))
+		}
+		b.WriteString("</table>\n")
+	}
+
+	b.WriteString("<h2>Snapshots</h2>\n")
+	if len(r.Snapshots) == 0 {
+		b.WriteString("<p>No snapshots were processed.</p>\n")
+	} else {
+		b.WriteString("<table>\n<tr><th>Snapshot</th><th>Time</th><th>Mode</th><th>Status</th><th>Boot entries</th></tr>\n")
+		for _, s := range r.Snapshots {
+			entries := make([]string, len(s.Entries))
+			for i, e := range s.Entries {
+				entries[i] = esc(e)
+			}
+			fmt.Fprintf(&b, "<tr><td><code>%s</code></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				esc(s.Path), esc(s.Time), esc(s.Mode), esc(s.Status), strings.Join(entries, "<br>"))
+		}
+		b.WriteString("</table>\n")
+	}
+
+	writeHTMLList(&b, "Removed snapshots", r.Removed)
+	writeHTMLList(&b, "Changed files", r.Changed)
+	writeHTMLList(&b, "Warnings", r.Warnings)
+
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func writeMarkdownList(b *strings.Builder, heading string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n## %s\n\n", heading)
+	for _, item := range items {
+		fmt.Fprintf(b, "- %s\n", item)
+	}
+}
+
+func writeHTMLList(b *strings.Builder, heading string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "<h2>%s</h2>\n<ul>\n", html.EscapeString(heading))
+	for _, item := range items {
+		fmt.Fprintf(b, "<li>%s</li>\n", html.EscapeString(item))
+	}
+	b.WriteString("</ul>\n")
+}
+
+// markdownCell escapes pipes so a value can't break out of its table cell.
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+func reportSubvolume(fs *btrfs.Filesystem) string {
+	if fs.Subvolume == nil {
+		return "<unknown>"
+	}
+	return fs.Subvolume.Path
+}
+
+func reportImagePath(bs *kernel.BootSet) string {
+	if img := bs.PrimaryImage(); img != nil {
+		return img.Path
+	}
+	return ""
+}
+
+// WarningCollector is a zerolog.LevelWriter that records warning-and-above
+// log lines, fields included, so they can be attached to a Report. Tee it
+// alongside the console writer with zerolog.MultiLevelWriter.
+type WarningCollector struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+// Write implements io.Writer; lines without a level are ignored.
+func (c *WarningCollector) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// WriteLevel implements zerolog.LevelWriter.
+func (c *WarningCollector) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < zerolog.WarnLevel || level == zerolog.NoLevel {
+		return len(p), nil
+	}
+
+	line := strings.TrimSpace(string(p))
+	var fields map[string]any
+	if err := json.Unmarshal(p, &fields); err == nil {
+		msg, _ := fields[zerolog.MessageFieldName].(string)
+		var extra []string
+		for _, k := range slices.Sorted(maps.Keys(fields)) {
+			switch k {
+			case zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.TimestampFieldName:
+				continue
+			}
+			extra = append(extra, fmt.Sprintf("%s=%v", k, fields[k]))
+		}
+		line = fmt.Sprintf("%s: %s", level, msg)
+		if len(extra) > 0 {
+			line += " (" + strings.Join(extra, ", ") + ")"
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, line)
+	return len(p), nil
+}
+
+// Messages returns the warnings collected so far.
+func (c *WarningCollector) Messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.messages)
+}
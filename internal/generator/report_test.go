@@ -0,0 +1,143 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport(t *testing.T) *Report {
+	t.Helper()
+
+	rootFS := &btrfs.Filesystem{UUID: "root-uuid", Device: "/dev/nvme0n1p2", MountPoint: "/", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}}
+	espSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 300, Path: "@/.snapshots/1/snapshot"},
+		SnapshotTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	btrfsSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 301, Path: "@/.snapshots/2/snapshot"},
+		SnapshotTime: time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC),
+	}
+	bs := &kernel.BootSet{KernelName: "linux", Layout: kernel.LayoutSplit, Kernel: &kernel.BootImage{Path: "/vmlinuz-linux"}}
+
+	pipeline := &Pipeline{
+		Cfg:      &config.Config{Advanced: config.AdvancedConfig{Naming: config.NamingConfig{MenuFormat: "2006-01-02"}}},
+		Runner:   runner.New(true),
+		ESPPath:  "/boot/efi",
+		BootSets: []*kernel.BootSet{bs},
+	}
+	plan := &Plan{
+		RootFS:             rootFS,
+		ProcessedSnapshots: []*btrfs.Snapshot{espSnap, btrfsSnap},
+		BootPlans: []*kernel.BootPlan{
+			{
+				Snapshot:  espSnap,
+				Mode:      kernel.BootModeESP,
+				BootSet:   bs,
+				Staleness: &kernel.StalenessResult{IsStale: true, Reason: kernel.ReasonModulesMissing, Action: kernel.ActionWarn},
+			},
+			{Snapshot: btrfsSnap, Mode: kernel.BootModeBtrfs, SnapshotKernel: "/@/.snapshots/2/snapshot/boot/vmlinuz-linux", BtrfsVolume: "ROOT"},
+		},
+		Removed: []string{"@/.snapshots/0/snapshot"},
+	}
+	summary := &OperationSummary{
+		UpdatedConfigs: []string{"/boot/efi/EFI/refind/refind-btrfs-snapshots.conf"},
+		SourceEntries: []*refind.MenuEntry{{
+			Title:       "Arch <Linux>",
+			BootOptions: &refind.BootOptions{Root: "UUID=root-uuid", Subvol: "@"},
+		}},
+	}
+
+	return pipeline.NewReport(plan, summary, []string{"warn: Snapshot is stale for boot kernel (kernel=linux)"})
+}
+
+func TestNewReport_DescribesSnapshots(t *testing.T) {
+	report := testReport(t)
+
+	assert.True(t, report.DryRun)
+	require.Len(t, report.Filesystems, 1)
+	require.Len(t, report.Snapshots, 2)
+
+	esp := report.Snapshots[0]
+	assert.Equal(t, "esp", esp.Mode)
+	assert.Contains(t, esp.Status, "linux: stale")
+	assert.Equal(t, []string{"Arch <Linux> (2025-01-01)"}, esp.Entries)
+
+	btr := report.Snapshots[1]
+	assert.Equal(t, "btrfs", btr.Mode)
+	assert.Equal(t, "vmlinuz-linux: in-snapshot kernel", btr.Status)
+	assert.Equal(t, []string{"Arch <Linux> (2025-02-02)"}, btr.Entries)
+}
+
+func TestReport_Markdown(t *testing.T) {
+	md := testReport(t).Markdown()
+
+	assert.Contains(t, md, "# refind-btrfs-snapshots report")
+	assert.Contains(t, md, "- Btrfs filesystem: `root-uuid` (/dev/nvme0n1p2), mounted at `/`, subvolume `@`")
+	assert.Contains(t, md, "| linux | split |")
+	assert.Contains(t, md, "| `@/.snapshots/2/snapshot` |")
+	assert.Contains(t, md, "## Removed snapshots\n\n- @/.snapshots/0/snapshot")
+	assert.Contains(t, md, "## Warnings\n\n- warn: Snapshot is stale for boot kernel (kernel=linux)")
+}
+
+func TestNewReport_FailedRun(t *testing.T) {
+	pipeline := &Pipeline{Cfg: &config.Config{}, ESPPath: "/boot/efi"}
+	report := pipeline.NewReport(nil, nil, []string{"warn: ESP is nearly full"})
+	report.Error = "no bootable entries found"
+
+	assert.Empty(t, report.Snapshots)
+	md := report.Markdown()
+	assert.Contains(t, md, "- Error: no bootable entries found")
+	assert.Contains(t, md, "No snapshots were processed.")
+	assert.Contains(t, md, "- warn: ESP is nearly full")
+}
+
+func TestReport_HTMLEscapes(t *testing.T) {
+	out := testReport(t).HTML()
+
+	assert.Contains(t, out, "<!DOCTYPE html>")
+	assert.Contains(t, out, "Arch &lt;Linux&gt; (2025-01-01)")
+	assert.NotContains(t, out, "Arch <Linux>")
+}
+
+func TestReport_WriteChoosesFormatByExtension(t *testing.T) {
+	dir := t.TempDir()
+	report := testReport(t)
+
+	mdPath := filepath.Join(dir, "report.md")
+	htmlPath := filepath.Join(dir, "report.HTML")
+	require.NoError(t, report.Write(mdPath))
+	require.NoError(t, report.Write(htmlPath))
+
+	md, err := os.ReadFile(mdPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(md), "# refind-btrfs-snapshots report")
+
+	html, err := os.ReadFile(htmlPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "<h1>refind-btrfs-snapshots report</h1>")
+}
+
+func TestWarningCollector_KeepsWarningsWithFields(t *testing.T) {
+	collector := &WarningCollector{}
+	logger := zerolog.New(collector)
+
+	logger.Info().Msg("ignored")
+	logger.Warn().Str("snapshot", "@/.snapshots/1/snapshot").Int("id", 300).Msg("Snapshot is stale")
+	logger.Error().Msg("Failed")
+
+	assert.Equal(t, []string{
+		"warn: Snapshot is stale (id=300, snapshot=@/.snapshots/1/snapshot)",
+		"error: Failed",
+	}, collector.Messages())
+}
@@ -0,0 +1,135 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/rs/zerolog/log"
+)
+
+// RunState is the record of the last successful generation, persisted in
+// behavior.state_file and consulted by --since-last-run.
+type RunState struct {
+	LastRun time.Time `json:"last_run"`
+	// Snapshots lists the snapshot paths found on each volume, keyed by the
+	// filesystem's best identifier, so deletions are noticed as well as
+	// additions.
+	Snapshots map[string][]string `json:"snapshots"`
+}
+
+// LoadRunState reads the state file at path. A missing file yields nil so
+// the first run always generates.
+func LoadRunState(path string) (*RunState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	var state RunState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// SnapshotsUnchanged reports whether the snapshots on every volume recorded
+// in state are exactly those found at the last run, with none newer than
+// it. Only snapshot discovery runs, so callers can skip the rest of the
+// pipeline cheaply. The root filesystem must always have been recorded;
+// with allVolumes, other detected volumes are checked when recorded.
+func SnapshotsUnchanged(mgr *btrfs.Manager, state *RunState, allVolumes bool) (bool, error) {
+	if state == nil {
+		return false, nil
+	}
+
+	detected, err := mgr.DetectBtrfsFilesystems()
+	if err != nil {
+		return false, fmt.Errorf("failed to detect btrfs filesystems: %w", err)
+	}
+	var filesystems []*btrfs.Filesystem
+	for _, fs := range detected {
+		if fs.MountPoint == "/" {
+			filesystems = append([]*btrfs.Filesystem{fs}, filesystems...)
+			continue
+		}
+		if _, ok := state.Snapshots[fs.GetBestIdentifier()]; allVolumes && ok {
+			filesystems = append(filesystems, fs)
+		}
+	}
+	if len(filesystems) == 0 || filesystems[0].MountPoint != "/" {
+		return false, btrfs.ErrNoBtrfsRoot
+	}
+
+	for _, fs := range filesystems {
+		snapshots, err := mgr.FindSnapshots(fs)
+		if err != nil {
+			return false, fmt.Errorf("failed to find snapshots: %w", err)
+		}
+		if !state.unchanged(fs, snapshots) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// unchanged reports whether snapshots are exactly the paths recorded for fs
+// and none was taken after the last run.
+func (s *RunState) unchanged(fs *btrfs.Filesystem, snapshots []*btrfs.Snapshot) bool {
+	recorded, ok := s.Snapshots[fs.GetBestIdentifier()]
+	if !ok {
+		log.Debug().Str("mountpoint", fs.MountPoint).Msg("Volume not recorded at last run")
+		return false
+	}
+	if len(snapshots) != len(recorded) {
+		log.Debug().
+			Str("mountpoint", fs.MountPoint).
+			Int("recorded", len(recorded)).
+			Int("found", len(snapshots)).
+			Msg("Snapshot count changed since last run")
+		return false
+	}
+	for _, snap := range snapshots {
+		if snap.SnapshotTime.After(s.LastRun) || !slices.Contains(recorded, snap.Path) {
+			log.Debug().Str("path", snap.Path).Msg("Snapshot is new since last run")
+			return false
+		}
+	}
+	return true
+}
+
+// SaveRunState records started and the snapshots currently on each of the
+// plan's volumes, writing through the runner so dry runs only report the
+// write. Volumes are rescanned rather than taken from the plan so writable
+// copies created this run are part of the record; started should be when
+// the run began, so a snapshot taken mid-run is still newer next time.
+func (p *Pipeline) SaveRunState(path string, plan *Plan, started time.Time) error {
+	state := RunState{LastRun: started.UTC(), Snapshots: make(map[string][]string)}
+	for _, vol := range plan.volumes() {
+		snapshots, err := p.Btrfs.FindSnapshots(vol.FS)
+		if err != nil {
+			return fmt.Errorf("failed to find snapshots: %w", err)
+		}
+		paths := make([]string, 0, len(snapshots))
+		for _, snap := range snapshots {
+			paths = append(paths, snap.Path)
+		}
+		state.Snapshots[vol.FS.GetBestIdentifier()] = paths
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state file: %w", err)
+	}
+	if err := p.Runner.MkdirAll(filepath.Dir(path), 0o755, fmt.Sprintf("Create directory for %s", path)); err != nil {
+		return err
+	}
+	return p.Runner.WriteFile(path, append(data, '\n'), 0o644, fmt.Sprintf("Write %s", path))
+}
@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRunState_MissingFile(t *testing.T) {
+	state, err := LoadRunState(filepath.Join(t.TempDir(), "last-run.json"))
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
+
+func TestSaveRunState_RoundTrip(t *testing.T) {
+	rootFS := &btrfs.Filesystem{UUID: "root-uuid", MountPoint: t.TempDir()}
+	pipeline := &Pipeline{
+		Btrfs:  btrfs.NewManager([]string{".snapshots"}, 3, "", false),
+		Runner: runner.New(false),
+	}
+	path := filepath.Join(t.TempDir(), "state", "last-run.json")
+	started := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, pipeline.SaveRunState(path, &Plan{RootFS: rootFS}, started))
+
+	state, err := LoadRunState(path)
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.True(t, started.Equal(state.LastRun))
+	assert.Equal(t, map[string][]string{"root-uuid": {}}, state.Snapshots)
+}
+
+func TestSaveRunState_DryRunWritesNothing(t *testing.T) {
+	pipeline := &Pipeline{
+		Btrfs:  btrfs.NewManager(nil, 3, "", false),
+		Runner: runner.New(true),
+	}
+	path := filepath.Join(t.TempDir(), "last-run.json")
+
+	require.NoError(t, pipeline.SaveRunState(path, &Plan{RootFS: &btrfs.Filesystem{UUID: "root-uuid"}}, time.Now()))
+
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunState_Unchanged(t *testing.T) {
+	lastRun := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fs := &btrfs.Filesystem{UUID: "root-uuid", MountPoint: "/"}
+	snap := func(path string, taken time.Time) *btrfs.Snapshot {
+		return &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{Path: path}, SnapshotTime: taken}
+	}
+	old := snap("/.snapshots/1/snapshot", lastRun.Add(-time.Hour))
+	state := &RunState{
+		LastRun:   lastRun,
+		Snapshots: map[string][]string{"root-uuid": {"/.snapshots/1/snapshot"}},
+	}
+
+	tests := []struct {
+		name      string
+		fs        *btrfs.Filesystem
+		snapshots []*btrfs.Snapshot
+		want      bool
+	}{
+		{"same snapshots", fs, []*btrfs.Snapshot{old}, true},
+		{"newer snapshot", fs, []*btrfs.Snapshot{old, snap("/.snapshots/2/snapshot", lastRun.Add(time.Minute))}, false},
+		{"snapshot deleted", fs, nil, false},
+		{"different older snapshot", fs, []*btrfs.Snapshot{snap("/.snapshots/0/snapshot", lastRun.Add(-2*time.Hour))}, false},
+		{"volume not recorded", &btrfs.Filesystem{UUID: "other-uuid"}, []*btrfs.Snapshot{old}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, state.unchanged(tt.fs, tt.snapshots))
+		})
+	}
+}
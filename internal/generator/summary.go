@@ -1,6 +1,11 @@
 package generator
 
-import "github.com/rs/zerolog/log"
+import (
+	"slices"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/rs/zerolog/log"
+)
 
 // OperationSummary records what happened during a generation run so the
 // final log line shows exactly which snapshots were added/removed, which
@@ -11,8 +16,27 @@ type OperationSummary struct {
 	RemovedSnapshots  []string // Snapshots removed from configs (due to stale-delete)
 	StaleSnapshots    []string // Snapshots detected as stale
 	UpdatedFstabs     []string
+	UpdatedCrypttabs  []string // Snapshot crypttabs aligned with the live dm-crypt root
 	UpdatedConfigs    []string
 	WritableChanges   []string
+	ESPCopies         []string // Boot files copied onto the ESP
+	RemovedESPCopies  []string // ESP boot copies of deleted snapshots
+	TimedOutSnapshots []string // Snapshots skipped for exceeding the per-snapshot timeout
+
+	// SourceEntries are the rEFInd entries snapshot submenus were derived
+	// from, for reporting. Not logged.
+	SourceEntries []*refind.MenuEntry
+
+	// SelfCheckedConfigs are the rEFInd configs read back by --selfcheck,
+	// and SelfCheckProblems each "<path>: <discrepancy>" it found. Not
+	// logged.
+	SelfCheckedConfigs []string
+	SelfCheckProblems  []string
+
+	// MenuChanges are the rEFInd menu changes of each updated rEFInd
+	// config, as described by refind.Generator.MenuDiff, shown by dry runs.
+	// Not logged.
+	MenuChanges []string
 }
 
 // LogSummary emits the comprehensive operation summary log line that runs
@@ -29,7 +53,58 @@ func LogSummary(summary *OperationSummary, isDryRun bool) {
 		Strs("removed_snapshots", summary.RemovedSnapshots).
 		Strs("stale_snapshots", summary.StaleSnapshots).
 		Strs("updated_fstabs", summary.UpdatedFstabs).
+		Strs("updated_crypttabs", summary.UpdatedCrypttabs).
 		Strs("updated_configs", summary.UpdatedConfigs).
 		Strs("writable_changes", summary.WritableChanges).
+		Strs("esp_copies", summary.ESPCopies).
+		Strs("removed_esp_copies", summary.RemovedESPCopies).
+		Strs("timed_out_snapshots", summary.TimedOutSnapshots).
+		Msg(prefix + "Operation summary")
+}
+
+// LogSummaryCounts emits the operation summary as counts rather than lists,
+// for --summary-only runs where the per-snapshot detail is noise.
+func LogSummaryCounts(summary *OperationSummary, isDryRun bool) {
+	prefix := ""
+	if isDryRun {
+		prefix = "[DRY RUN] "
+	}
+
+	log.Info().
+		Int("included", len(summary.IncludedSnapshots)).
+		Int("added", len(summary.AddedSnapshots)).
+		Int("removed", len(summary.RemovedSnapshots)).
+		Int("stale", len(summary.StaleSnapshots)).
+		Int("updated_fstabs", len(summary.UpdatedFstabs)).
+		Int("updated_crypttabs", len(summary.UpdatedCrypttabs)).
+		Int("updated_configs", len(summary.UpdatedConfigs)).
+		Int("writable_changes", len(summary.WritableChanges)).
+		Int("esp_copies", len(summary.ESPCopies)).
+		Int("removed_esp_copies", len(summary.RemovedESPCopies)).
+		Int("timed_out", len(summary.TimedOutSnapshots)).
 		Msg(prefix + "Operation summary")
 }
+
+// Merge adds the logged lists of other to s, for a summary across several
+// runs (generate --profiles). Entries already in s aren't repeated, as
+// runs over the same filesystem select the same snapshots.
+func (s *OperationSummary) Merge(other *OperationSummary) {
+	merge := func(into *[]string, from []string) {
+		for _, item := range from {
+			if !slices.Contains(*into, item) {
+				*into = append(*into, item)
+			}
+		}
+	}
+	merge(&s.IncludedSnapshots, other.IncludedSnapshots)
+	merge(&s.AddedSnapshots, other.AddedSnapshots)
+	merge(&s.RemovedSnapshots, other.RemovedSnapshots)
+	merge(&s.StaleSnapshots, other.StaleSnapshots)
+	merge(&s.UpdatedFstabs, other.UpdatedFstabs)
+	merge(&s.UpdatedCrypttabs, other.UpdatedCrypttabs)
+	merge(&s.UpdatedConfigs, other.UpdatedConfigs)
+	merge(&s.WritableChanges, other.WritableChanges)
+	merge(&s.ESPCopies, other.ESPCopies)
+	merge(&s.RemovedESPCopies, other.RemovedESPCopies)
+	merge(&s.TimedOutSnapshots, other.TimedOutSnapshots)
+}
@@ -1,8 +1,11 @@
 package generator
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,3 +22,39 @@ func TestLogSummary_DoesNotPanic(t *testing.T) {
 	assert.NotPanics(t, func() { LogSummary(summary, true) })
 	assert.NotPanics(t, func() { LogSummary(summary, false) })
 }
+
+func TestLogSummaryCounts(t *testing.T) {
+	var buf bytes.Buffer
+	original := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = original }()
+
+	LogSummaryCounts(&OperationSummary{
+		IncludedSnapshots: []string{"snapshot1", "snapshot2"},
+		AddedSnapshots:    []string{"snapshot2"},
+		UpdatedConfigs:    []string{"/config"},
+	}, true)
+
+	assert.Contains(t, buf.String(), `"included":2`)
+	assert.Contains(t, buf.String(), `"added":1`)
+	assert.Contains(t, buf.String(), `"removed":0`)
+	assert.Contains(t, buf.String(), `"updated_configs":1`)
+	assert.Contains(t, buf.String(), `"message":"[DRY RUN] Operation summary"`)
+	assert.NotContains(t, buf.String(), "snapshot2")
+}
+
+func TestOperationSummaryMerge(t *testing.T) {
+	summary := &OperationSummary{
+		IncludedSnapshots: []string{"/.snapshots/1/snapshot"},
+		UpdatedConfigs:    []string{"/boot/efi/EFI/refind/refind-btrfs-snapshots.conf"},
+	}
+	summary.Merge(&OperationSummary{
+		IncludedSnapshots: []string{"/.snapshots/1/snapshot", "/.snapshots/2/snapshot"},
+		UpdatedConfigs:    []string{"/mnt/esp2/EFI/refind/refind-btrfs-snapshots.conf"},
+		MenuChanges:       []string{"+ \"Arch Linux\""},
+	})
+
+	assert.Equal(t, []string{"/.snapshots/1/snapshot", "/.snapshots/2/snapshot"}, summary.IncludedSnapshots)
+	assert.Equal(t, []string{"/boot/efi/EFI/refind/refind-btrfs-snapshots.conf", "/mnt/esp2/EFI/refind/refind-btrfs-snapshots.conf"}, summary.UpdatedConfigs)
+	assert.Empty(t, summary.MenuChanges)
+}
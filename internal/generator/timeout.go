@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"context"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/progress"
+	"github.com/rs/zerolog/log"
+)
+
+// runSnapshotStep runs step for snapshot, giving up on it once
+// behavior.timeout_per_snapshot passes so one snapshot on a degraded disk
+// can't hang the whole run. The step's context is cancelled then, killing
+// the btrfs command it runs and failing any it would start. A call blocked
+// in the kernel can still outlive the timeout, so a step must not change
+// state the run shares; it returns what it produced, which is dropped if
+// it was abandoned. Returns the step's result, and false when it timed out.
+// A zero timeout runs step inline.
+func runSnapshotStep[T any](p *Pipeline, snapshot *btrfs.Snapshot, stepName string, step func(ctx context.Context) T) (T, bool) {
+	timeout := p.Cfg.Behavior.TimeoutPerSnapshot
+	if timeout <= 0 {
+		return step(context.Background()), true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan T, 1)
+	go func() {
+		done <- step(ctx)
+	}()
+
+	select {
+	case result := <-done:
+		return result, true
+	case <-ctx.Done():
+		log.Warn().
+			Str("snapshot", snapshot.Path).
+			Str("step", stepName).
+			Dur("timeout", timeout).
+			Msg("Snapshot processing timed out, skipping snapshot")
+		var zero T
+		return zero, false
+	}
+}
+
+// snapshotResult is what a writability step returns: the snapshot it
+// changed or created, and its error.
+type snapshotResult struct {
+	snapshot *btrfs.Snapshot
+	err      error
+}
+
+// copySnapshot returns a copy of snapshot with its own Subvolume, for a step
+// to change.
+func copySnapshot(snapshot *btrfs.Snapshot) *btrfs.Snapshot {
+	c := *snapshot
+	if snapshot.Subvolume != nil {
+		subvol := *snapshot.Subvolume
+		c.Subvolume = &subvol
+	}
+	return &c
+}
+
+// restoreReadOnly makes the snapshots whose "make writable" step timed out
+// read-only again. The step's command was killed, but may have completed
+// first, and the snapshot is no longer booted, so nothing else would
+// restore it. Each runs under the per-snapshot timeout like the step.
+func (p *Pipeline) restoreReadOnly(snapshots []*btrfs.Snapshot) {
+	for _, snap := range snapshots {
+		err, ok := runSnapshotStep(p, snap, "restore read-only", func(ctx context.Context) error {
+			return p.Btrfs.MakeSnapshotReadOnly(ctx, copySnapshot(snap), p.Runner)
+		})
+		if ok && err != nil {
+			log.Warn().Err(err).Str("path", snap.Path).Msg("Failed to make timed-out snapshot read-only again")
+		}
+	}
+}
+
+// planSnapshots plans each snapshot under the per-snapshot timeout. Returns
+// the plans, the snapshots that finished planning, and the paths of those
+// that timed out.
+func (p *Pipeline) planSnapshots(planner *kernel.Planner, snapshots []*btrfs.Snapshot) ([]*kernel.BootPlan, []*btrfs.Snapshot, []string) {
+	var plans []*kernel.BootPlan
+	var planned []*btrfs.Snapshot
+	var timedOut []string
+	for i, snapshot := range snapshots {
+		snapshotPlans, ok := runSnapshotStep(p, snapshot, "plan", func(context.Context) []*kernel.BootPlan {
+			return planner.Plan([]*btrfs.Snapshot{snapshot})
+		})
+		progress.Step("snapshots", i+1, len(snapshots), snapshot.Path)
+		if !ok {
+			timedOut = append(timedOut, snapshot.Path)
+			continue
+		}
+		plans = append(plans, snapshotPlans...)
+		planned = append(planned, snapshot)
+	}
+	return plans, planned, timedOut
+}
@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSnapshotStep(t *testing.T) {
+	cfg := config.Defaults()
+	pipeline := &Pipeline{Cfg: &cfg}
+	snapshot := mkSnapshot(1, "/.snapshots/1/snapshot")
+
+	// No timeout: the step runs inline.
+	got, ok := runSnapshotStep(pipeline, snapshot, "plan", func(context.Context) int { return 1 })
+	assert.True(t, ok)
+	assert.Equal(t, 1, got)
+
+	cfg.Behavior.TimeoutPerSnapshot = time.Second
+	got, ok = runSnapshotStep(pipeline, snapshot, "plan", func(context.Context) int { return 2 })
+	assert.True(t, ok)
+	assert.Equal(t, 2, got)
+
+	// A step that hangs is abandoned once the timeout passes, and its
+	// context is cancelled so the btrfs command it runs is killed.
+	cfg.Behavior.TimeoutPerSnapshot = 10 * time.Millisecond
+	cancelled := make(chan struct{})
+	got, ok = runSnapshotStep(pipeline, snapshot, "plan", func(ctx context.Context) int {
+		<-ctx.Done()
+		close(cancelled)
+		return 3
+	})
+	assert.False(t, ok)
+	assert.Zero(t, got)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the abandoned step's context was not cancelled")
+	}
+}
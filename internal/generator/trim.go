@@ -0,0 +1,36 @@
+package generator
+
+import (
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/rs/zerolog/log"
+)
+
+// BuildTrimPatch trims the snapshot entries already generated into the
+// refind_linux.conf files and the managed config down to the keep newest
+// snapshots, without scanning btrfs. Snapshot times are read back from the
+// entry titles using advanced.naming.menu_format.
+func (p *Pipeline) BuildTrimPatch(keep int) (*diff.PatchDiff, error) {
+	patch := diff.NewPatchDiff()
+	parser := refind.NewParser(p.ESPPath)
+	gen := refind.NewGenerator(p.ESPPath, p.Cfg.Advanced.Naming.MenuFormat, p.Cfg.Display.LocalTime.IsTrue())
+	gen.SetFallbackMarker(p.Cfg.Display.FallbackMarker)
+	gen.SetPreserveCRLF(p.Cfg.Refind.PreserveCRLF.IsTrue())
+
+	paths, err := parser.FindRefindLinuxConfigs()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to find refind_linux.conf files")
+	}
+	paths = append(paths, parser.GetManagedConfigPath(p.resolveRefindConfigPath(parser)))
+
+	for _, path := range paths {
+		fileDiff, err := gen.TrimConfigDiff(path, keep)
+		if err != nil {
+			return nil, err
+		}
+		if fileDiff != nil {
+			patch.AddFile(fileDiff)
+		}
+	}
+	return patch, nil
+}
@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTrimPatch(t *testing.T) {
+	espPath := t.TempDir()
+	refindDir := filepath.Join(espPath, "EFI", "refind")
+	kernelDir := filepath.Join(espPath, "EFI", "arch")
+	require.NoError(t, os.MkdirAll(refindDir, 0o755))
+	require.NoError(t, os.MkdirAll(kernelDir, 0o755))
+
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind.conf"), []byte("include refind-btrfs-snapshots.conf\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(refindDir, "refind-btrfs-snapshots.conf"), []byte(`menuentry "Arch Linux" {
+    submenuentry "Arch Linux (2025-06-14T10:00:00Z)" {
+    }
+    submenuentry "Arch Linux (2025-06-13T10:00:00Z)" {
+    }
+}
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(kernelDir, "refind_linux.conf"), []byte(`"Boot" "rw"
+##refind-btrfs-snapshots-start
+"Boot (2025-06-14T10:00:00Z)" "rw"
+"Boot (2025-06-13T10:00:00Z)" "rw"
+##refind-btrfs-snapshots-end
+`), 0o644))
+
+	cfg := config.Defaults()
+	pipeline := &Pipeline{Cfg: &cfg, Runner: runner.New(true), ESPPath: espPath}
+
+	patch, err := pipeline.BuildTrimPatch(1)
+	require.NoError(t, err)
+	require.Len(t, patch.Files, 2)
+	for _, fileDiff := range patch.Files {
+		assert.Contains(t, fileDiff.Modified, "(2025-06-14T10:00:00Z)")
+		assert.NotContains(t, fileDiff.Modified, "(2025-06-13T10:00:00Z)")
+	}
+
+	patch, err = pipeline.BuildTrimPatch(2)
+	require.NoError(t, err)
+	assert.Empty(t, patch.Files)
+}
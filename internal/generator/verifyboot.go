@@ -0,0 +1,205 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/refind"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/rs/zerolog/log"
+)
+
+// BootCheck is one snapshot boot for verify-boot to try: the kernel and
+// initrds a generated entry loads, as paths on the running system, and the
+// command line it passes.
+type BootCheck struct {
+	Snapshot string
+	Kernel   string
+	Initrds  []string
+	Cmdline  string
+}
+
+// BootChecks returns a BootCheck for each of plan's boot plans, with the
+// command line written under the first source entry booting the
+// snapshot's volume. Plans whose kernel can't be located on the running
+// system, such as UKIs, are logged and left out.
+func (p *Pipeline) BootChecks(plan *Plan) ([]BootCheck, error) {
+	_, config, err := p.parseRefindConfig()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := p.sourceEntries(config, plan)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := p.newRefindGenerator(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []BootCheck
+	for _, v := range plan.volumes() {
+		volumeEntries := bootableEntries(entries, v.FS)
+		if len(volumeEntries) == 0 {
+			continue
+		}
+		plansBySnapshot := kernel.GroupBySnapshot(plan.BootPlans)
+		for _, snapshot := range v.Snapshots {
+			plans := plansBySnapshot[snapshot.Path]
+			if len(plans) == 0 {
+				log.Warn().Str("snapshot", snapshot.Path).Msg("Snapshot has no boot plan to verify, no boot sets were found on the ESP")
+				continue
+			}
+			cmdline := withoutInitrdParams(gen.SnapshotCmdline(volumeEntries[0], snapshot, v.FS))
+			for _, bp := range plans {
+				kernelPath, initrds := p.bootFiles(bp)
+				if kernelPath == "" {
+					log.Warn().Str("snapshot", snapshot.Path).Str("layout", string(bp.Layout)).Msg("Can't locate the kernel of a boot plan, not verifying it")
+					continue
+				}
+				checks = append(checks, BootCheck{
+					Snapshot: snapshot.Path,
+					Kernel:   kernelPath,
+					Initrds:  initrds,
+					Cmdline:  cmdline,
+				})
+			}
+		}
+	}
+	return checks, nil
+}
+
+// bootFiles returns the paths on the running system of the kernel and
+// initrds bp boots, or "" when the kernel can't be located.
+func (p *Pipeline) bootFiles(bp *kernel.BootPlan) (string, []string) {
+	switch {
+	case bp.VolumeRelative():
+		// Volume-relative paths start with the snapshot's subvolume path.
+		inSnapshot := func(path string) string {
+			prefix := "/" + strings.TrimPrefix(bp.Snapshot.Path, "/")
+			return filepath.Join(bp.Snapshot.FilesystemPath, strings.TrimPrefix(path, prefix))
+		}
+		var initrds []string
+		for _, initrd := range bp.SnapshotInitrds {
+			initrds = append(initrds, inSnapshot(initrd))
+		}
+		return inSnapshot(bp.SnapshotKernel), initrds
+
+	case bp.HasESPCopy():
+		var initrds []string
+		for _, initrd := range bp.ESPInitrds {
+			initrds = append(initrds, filepath.Join(p.ESPPath, initrd))
+		}
+		return filepath.Join(p.ESPPath, bp.ESPKernel), initrds
+
+	case bp.BootSet != nil && bp.BootSet.Kernel != nil:
+		var initrds []string
+		for _, microcode := range bp.BootSet.Microcode {
+			initrds = append(initrds, microcode.AbsPath)
+		}
+		if bp.BootSet.Initramfs != nil {
+			initrds = append(initrds, bp.BootSet.Initramfs.AbsPath)
+		}
+		return bp.BootSet.Kernel.AbsPath, initrds
+	}
+	return "", nil
+}
+
+// withoutInitrdParams drops the initrd= parameters rEFInd's EFI stub reads
+// from options, and the quotes a menuentry options line wraps them in;
+// kexec is given the initrds itself.
+func withoutInitrdParams(options string) string {
+	options, _ = refind.UnquoteOptions(options)
+	var kept []string
+	for _, field := range strings.Fields(options) {
+		if !strings.HasPrefix(field, "initrd=") {
+			kept = append(kept, field)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// kexecLoadedPath reports whether a kexec kernel is loaded; replaced in
+// tests.
+var kexecLoadedPath = "/sys/kernel/kexec_loaded"
+
+// KexecLoaded reports whether a kernel is already loaded with kexec. Each
+// VerifyBoot replaces and unloads it, so verify-boot refuses to start over
+// one.
+func KexecLoaded() (bool, error) {
+	data, err := os.ReadFile(kexecLoadedPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for a loaded kexec kernel: %w", err)
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
+// VerifyBoot loads check's kernel, initrds and command line with
+// `kexec --load` through r and unloads them again, so the kernel is known
+// to load without being booted. Any kernel already loaded is replaced, see
+// KexecLoaded. kexec takes a single initrd, so several are
+// joined into a temporary file first, as the firmware would load them.
+func VerifyBoot(r runner.Runner, check BootCheck) error {
+	for _, path := range append([]string{check.Kernel}, check.Initrds...) {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("boot file missing: %w", err)
+		}
+	}
+
+	args := []string{"--load", check.Kernel, "--command-line=" + check.Cmdline}
+	switch {
+	case len(check.Initrds) == 1:
+		args = append(args, "--initrd="+check.Initrds[0])
+	case len(check.Initrds) > 1 && r.IsDryRun():
+		args = append(args, "--initrd=<"+strings.Join(check.Initrds, "+")+">")
+	case len(check.Initrds) > 1:
+		joined, err := joinInitrds(check.Initrds)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(joined)
+		args = append(args, "--initrd="+joined)
+	}
+
+	if err := r.Command("kexec", args, "Load snapshot kernel to verify it"); err != nil {
+		return fmt.Errorf("kexec failed to load the kernel: %w", err)
+	}
+	if err := r.Command("kexec", []string{"--unload"}, "Unload verified snapshot kernel"); err != nil {
+		return fmt.Errorf("kexec failed to unload the kernel: %w", err)
+	}
+	return nil
+}
+
+// joinInitrds concatenates initrds into a new temporary file and returns
+// its path. The caller removes it.
+func joinInitrds(initrds []string) (path string, err error) {
+	out, err := os.CreateTemp("", "refind-btrfs-snapshots-initrd-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create joined initrd: %w", err)
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(out.Name())
+		}
+	}()
+
+	for _, initrd := range initrds {
+		in, err := os.Open(initrd)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to join initrd %s: %w", initrd, err)
+		}
+	}
+	return out.Name(), nil
+}
@@ -0,0 +1,123 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingRunner runs nothing and records the commands it is given.
+type recordingRunner struct {
+	runner.DryRunner
+	commands [][]string
+	initrd   string // content of the --initrd file when kexec --load ran
+}
+
+func (r *recordingRunner) Command(name string, args []string, description string) error {
+	r.commands = append(r.commands, append([]string{name}, args...))
+	for _, arg := range args {
+		if path, ok := strings.CutPrefix(arg, "--initrd="); ok {
+			content, _ := os.ReadFile(path)
+			r.initrd = string(content)
+		}
+	}
+	return nil
+}
+
+func (r *recordingRunner) IsDryRun() bool { return false }
+
+func TestBootFiles(t *testing.T) {
+	snapshot := mkSnapshot(42, "@/.snapshots/42/snapshot")
+	snapshot.FilesystemPath = "/.snapshots/42/snapshot"
+	pipeline := &Pipeline{ESPPath: "/boot/efi"}
+
+	t.Run("btrfs mode", func(t *testing.T) {
+		kernelPath, initrds := pipeline.bootFiles(&kernel.BootPlan{
+			Snapshot:        snapshot,
+			Mode:            kernel.BootModeBtrfs,
+			BtrfsVolume:     "ROOT",
+			SnapshotKernel:  "/@/.snapshots/42/snapshot/boot/vmlinuz-linux",
+			SnapshotInitrds: []string{"/@/.snapshots/42/snapshot/boot/initramfs-linux.img"},
+		})
+		assert.Equal(t, "/.snapshots/42/snapshot/boot/vmlinuz-linux", kernelPath)
+		assert.Equal(t, []string{"/.snapshots/42/snapshot/boot/initramfs-linux.img"}, initrds)
+	})
+
+	t.Run("ESP copy", func(t *testing.T) {
+		kernelPath, initrds := pipeline.bootFiles(&kernel.BootPlan{
+			Snapshot:   snapshot,
+			Mode:       kernel.BootModeESP,
+			ESPKernel:  "/EFI/snapshots/42/vmlinuz-linux",
+			ESPInitrds: []string{"/EFI/snapshots/42/initramfs-linux.img"},
+		})
+		assert.Equal(t, "/boot/efi/EFI/snapshots/42/vmlinuz-linux", kernelPath)
+		assert.Equal(t, []string{"/boot/efi/EFI/snapshots/42/initramfs-linux.img"}, initrds)
+	})
+
+	t.Run("UKI", func(t *testing.T) {
+		kernelPath, _ := pipeline.bootFiles(&kernel.BootPlan{Snapshot: snapshot, Mode: kernel.BootModeESP, Layout: kernel.LayoutUKI})
+		assert.Empty(t, kernelPath)
+	})
+}
+
+func TestWithoutInitrdParams(t *testing.T) {
+	assert.Equal(t, "root=UUID=abc rw rootflags=subvol=@/.snapshots/42/snapshot",
+		withoutInitrdParams(`root=UUID=abc rw initrd=\boot\intel-ucode.img rootflags=subvol=@/.snapshots/42/snapshot initrd=\boot\initramfs-linux.img`))
+	assert.Equal(t, "root=UUID=abc rw rootflags=subvol=@/.snapshots/42/snapshot",
+		withoutInitrdParams(`"root=UUID=abc rw rootflags=subvol=@/.snapshots/42/snapshot initrd=\boot\initramfs-linux.img"`),
+		"quotes from a menuentry options line are dropped")
+}
+
+func TestKexecLoaded(t *testing.T) {
+	orig := kexecLoadedPath
+	t.Cleanup(func() { kexecLoadedPath = orig })
+	kexecLoadedPath = filepath.Join(t.TempDir(), "kexec_loaded")
+
+	for content, want := range map[string]bool{"0\n": false, "1\n": true} {
+		require.NoError(t, os.WriteFile(kexecLoadedPath, []byte(content), 0o644))
+		loaded, err := KexecLoaded()
+		require.NoError(t, err)
+		assert.Equal(t, want, loaded, "kexec_loaded %q", content)
+	}
+
+	require.NoError(t, os.Remove(kexecLoadedPath))
+	_, err := KexecLoaded()
+	assert.Error(t, err)
+}
+
+func TestVerifyBoot(t *testing.T) {
+	dir := t.TempDir()
+	kernelPath := filepath.Join(dir, "vmlinuz-linux")
+	ucode := filepath.Join(dir, "intel-ucode.img")
+	initramfs := filepath.Join(dir, "initramfs-linux.img")
+	require.NoError(t, os.WriteFile(kernelPath, []byte("kernel"), 0o644))
+	require.NoError(t, os.WriteFile(ucode, []byte("ucode|"), 0o644))
+	require.NoError(t, os.WriteFile(initramfs, []byte("initramfs"), 0o644))
+
+	t.Run("joins initrds and unloads", func(t *testing.T) {
+		r := &recordingRunner{}
+		err := VerifyBoot(r, BootCheck{Kernel: kernelPath, Initrds: []string{ucode, initramfs}, Cmdline: "root=UUID=abc rw"})
+		require.NoError(t, err)
+		require.Len(t, r.commands, 2)
+		assert.Equal(t, []string{"kexec", "--load", kernelPath, "--command-line=root=UUID=abc rw"}, r.commands[0][:4])
+		assert.Equal(t, "ucode|initramfs", r.initrd)
+		assert.Equal(t, []string{"kexec", "--unload"}, r.commands[1])
+
+		joined, _ := strings.CutPrefix(r.commands[0][4], "--initrd=")
+		assert.NoFileExists(t, joined, "the joined initrd is removed")
+	})
+
+	t.Run("missing initrd", func(t *testing.T) {
+		r := &recordingRunner{}
+		err := VerifyBoot(r, BootCheck{Kernel: kernelPath, Initrds: []string{filepath.Join(dir, "missing.img")}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "boot file missing")
+		assert.Empty(t, r.commands, "kexec isn't run")
+	})
+}
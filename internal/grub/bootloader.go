@@ -0,0 +1,154 @@
+// Package grub writes a GRUB menu fragment with one menuentry per
+// (source entry × snapshot), for sourcing from /etc/grub.d/40_custom.
+package grub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bootloader"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/params"
+	"github.com/rs/zerolog/log"
+)
+
+const header = `# Generated by refind-btrfs-snapshots - do not edit, changes are overwritten.
+# Include it with a source line naming this file in /etc/grub.d/40_custom.
+`
+
+// NewGenerator returns the GRUB fragment writer as a bootloader.Generator.
+// It writes cfg.GRUB.ConfigPath, which lives on the root filesystem rather
+// than the ESP.
+func NewGenerator() bootloader.Generator {
+	return &generator{}
+}
+
+type generator struct{}
+
+func (g *generator) Name() string { return "grub" }
+
+func (g *generator) Generate(input bootloader.Input) (*bootloader.Output, error) {
+	out := &bootloader.Output{}
+	if input.Cfg == nil {
+		return out, nil
+	}
+
+	var body strings.Builder
+	body.WriteString(header)
+	entries := 0
+	for _, src := range input.SourceEntries {
+		if src.Loader == "" {
+			continue
+		}
+		menuEntries := g.menuEntries(input, src)
+		if len(menuEntries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "\nsubmenu %s {\n", quote(src.Title+" snapshots"))
+		for _, e := range menuEntries {
+			body.WriteString(e)
+		}
+		body.WriteString("}\n")
+		entries += len(menuEntries)
+	}
+
+	path := input.Cfg.GRUB.ConfigPath
+	original := ""
+	isNew := true
+	if existing, err := os.ReadFile(path); err == nil {
+		original = string(existing)
+		isNew = false
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read GRUB config: %w", err)
+	}
+	if body.String() != original {
+		out.Diffs = append(out.Diffs, &diff.FileDiff{
+			Path:     path,
+			Original: original,
+			Modified: body.String(),
+			IsNew:    isNew,
+		})
+		out.UpdatedConfigs = append(out.UpdatedConfigs, path)
+	}
+
+	log.Info().
+		Int("entries", entries).
+		Str("path", path).
+		Msg("GRUB entry generation complete")
+	return out, nil
+}
+
+// menuEntries returns a menuentry block for each of input's plans that
+// boots src's kernel. Btrfs-mode plans load the snapshot's own kernel and
+// initrds from the btrfs volume, which GRUB can read; ESP-mode plans load
+// src's (or the snapshot's ESP boot copy) from the partition holding them.
+// UKI plans and stale-delete plans are left out.
+func (g *generator) menuEntries(input bootloader.Input, src bootloader.SourceEntry) []string {
+	cfg := input.Cfg
+	var entries []string
+	for _, plan := range input.BootPlans {
+		if plan == nil || plan.Snapshot == nil || plan.Snapshot.Subvolume == nil {
+			continue
+		}
+		if plan.Layout == kernel.LayoutUKI || plan.ShouldSkip() {
+			continue
+		}
+
+		var search, loader string
+		var initrds []string
+		switch {
+		case plan.Mode == kernel.BootModeBtrfs:
+			if plan.SnapshotKernel == "" || input.RootFS == nil || input.RootFS.UUID == "" ||
+				filepath.Base(plan.SnapshotKernel) != filepath.Base(src.Loader) {
+				continue
+			}
+			search = "--fs-uuid " + input.RootFS.UUID
+			loader, initrds = plan.SnapshotKernel, plan.SnapshotInitrds
+		case plan.BootSet != nil && plan.BootSet.Kernel != nil && plan.BootSet.Kernel.Path == src.Loader:
+			loader, initrds = src.Loader, src.Initrd
+			if plan.HasESPCopy() {
+				loader, initrds = plan.ESPKernel, plan.ESPInitrds
+			}
+			search = "--file " + loader
+		default:
+			continue
+		}
+
+		title := fmt.Sprintf("%s (%s)", src.Title, bootloader.SnapshotDisplayName(plan.Snapshot, cfg.Advanced.Naming.MenuFormat, cfg.Display.LocalTime.IsTrue()))
+		if plan.Fallback {
+			title += cfg.Display.FallbackMarker
+		}
+		opts := bootloader.RewriteCmdline(src.Options, plan.Snapshot)
+		matchWritability := cfg.Behavior.MatchWritability.IsTrue()
+		switch {
+		case opts == "":
+		case cfg.Behavior.BootReadOnly.IsTrue(), matchWritability && plan.Snapshot.IsReadOnly:
+			opts = params.ReadOnlyOptions(opts)
+		case matchWritability:
+			opts = params.WritableOptions(opts)
+		}
+
+		var e strings.Builder
+		fmt.Fprintf(&e, "\tmenuentry %s {\n", quote(title))
+		fmt.Fprintf(&e, "\t\tsearch --no-floppy --set=root %s\n", search)
+		if opts != "" {
+			loader += " " + opts
+		}
+		fmt.Fprintf(&e, "\t\tlinux %s\n", loader)
+		if len(initrds) > 0 {
+			fmt.Fprintf(&e, "\t\tinitrd %s\n", strings.Join(initrds, " "))
+		}
+		e.WriteString("\t}\n")
+		entries = append(entries, e.String())
+	}
+	return entries
+}
+
+// quote returns s as a single-quoted GRUB word. A single quote can't appear
+// inside one, so each is closed, escaped and reopened.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
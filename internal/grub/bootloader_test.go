@@ -0,0 +1,170 @@
+package grub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bootloader"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/config"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func grubCfg(path string) *config.Config {
+	cfg := config.Defaults()
+	cfg.GRUB.ConfigPath = path
+	return &cfg
+}
+
+func snapshot(id uint64, path string) *btrfs.Snapshot {
+	return &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: id, Path: path},
+		SnapshotTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func espPlan(snap *btrfs.Snapshot, kernelPath string) *kernel.BootPlan {
+	return &kernel.BootPlan{
+		Snapshot: snap,
+		Mode:     kernel.BootModeESP,
+		Layout:   kernel.LayoutSplit,
+		BootSet: &kernel.BootSet{
+			Layout: kernel.LayoutSplit,
+			Kernel: &kernel.BootImage{Path: kernelPath, Filename: filepath.Base(kernelPath)},
+		},
+	}
+}
+
+var archSource = bootloader.SourceEntry{
+	Title:   "Arch Linux",
+	Loader:  "/vmlinuz-linux",
+	Initrd:  []string{"/intel-ucode.img", "/initramfs-linux.img"},
+	Options: "root=UUID=abc rw rootflags=subvol=@",
+}
+
+func TestGenerate_ESPAndBtrfsModes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.cfg")
+	esp := snapshot(256, "@/.snapshots/1/snapshot")
+	btrfsSnap := snapshot(257, "@/.snapshots/2/snapshot")
+	input := bootloader.Input{
+		Cfg:    grubCfg(path),
+		RootFS: &btrfs.Filesystem{UUID: "1234-abcd"},
+		BootPlans: []*kernel.BootPlan{
+			espPlan(esp, "/vmlinuz-linux"),
+			{
+				Snapshot:        btrfsSnap,
+				Mode:            kernel.BootModeBtrfs,
+				Layout:          kernel.LayoutSplit,
+				SnapshotKernel:  "/@/.snapshots/2/snapshot/boot/vmlinuz-linux",
+				SnapshotInitrds: []string{"/@/.snapshots/2/snapshot/boot/initramfs-linux.img"},
+			},
+			espPlan(snapshot(258, "@/.snapshots/3/snapshot"), "/vmlinuz-linux-lts"),
+		},
+		SourceEntries: []bootloader.SourceEntry{archSource},
+	}
+
+	out, err := NewGenerator().Generate(input)
+	require.NoError(t, err)
+	require.Len(t, out.Diffs, 1)
+	assert.True(t, out.Diffs[0].IsNew)
+	assert.Equal(t, []string{path}, out.UpdatedConfigs)
+	assert.Equal(t, header+`
+submenu 'Arch Linux snapshots' {
+	menuentry 'Arch Linux (2025-01-01T00:00:00Z)' {
+		search --no-floppy --set=root --file /vmlinuz-linux
+		linux /vmlinuz-linux root=UUID=abc rw rootflags=subvol=@/.snapshots/1/snapshot,subvolid=256
+		initrd /intel-ucode.img /initramfs-linux.img
+	}
+	menuentry 'Arch Linux (2025-01-01T00:00:00Z)' {
+		search --no-floppy --set=root --fs-uuid 1234-abcd
+		linux /@/.snapshots/2/snapshot/boot/vmlinuz-linux root=UUID=abc rw rootflags=subvol=@/.snapshots/2/snapshot,subvolid=257
+		initrd /@/.snapshots/2/snapshot/boot/initramfs-linux.img
+	}
+}
+`, out.Diffs[0].Modified, "the linux-lts plan doesn't boot the source's kernel")
+}
+
+func TestGenerate_SkipsStaleDeleteAndUKI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.cfg")
+	stale := espPlan(snapshot(256, "@/.snapshots/1/snapshot"), "/vmlinuz-linux")
+	stale.Staleness = &kernel.StalenessResult{IsStale: true, Action: kernel.ActionDelete}
+	uki := espPlan(snapshot(257, "@/.snapshots/2/snapshot"), "/vmlinuz-linux")
+	uki.Layout = kernel.LayoutUKI
+
+	out, err := NewGenerator().Generate(bootloader.Input{
+		Cfg:           grubCfg(path),
+		BootPlans:     []*kernel.BootPlan{stale, uki},
+		SourceEntries: []bootloader.SourceEntry{archSource},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.Diffs, 1)
+	assert.Equal(t, header, out.Diffs[0].Modified, "no entries leaves only the header")
+}
+
+func TestGenerate_ReadOnlyAndQuoting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.cfg")
+	cfg := grubCfg(path)
+	cfg.Behavior.BootReadOnly = config.Truthy(true)
+	src := archSource
+	src.Title = "Arch's Linux"
+
+	out, err := NewGenerator().Generate(bootloader.Input{
+		Cfg:           cfg,
+		BootPlans:     []*kernel.BootPlan{espPlan(snapshot(256, "@/.snapshots/1/snapshot"), "/vmlinuz-linux")},
+		SourceEntries: []bootloader.SourceEntry{src},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.Diffs, 1)
+	assert.Contains(t, out.Diffs[0].Modified, `submenu 'Arch'\''s Linux snapshots' {`)
+	assert.Contains(t, out.Diffs[0].Modified, "linux /vmlinuz-linux root=UUID=abc ro rootflags=subvol=@/.snapshots/1/snapshot,subvolid=256\n")
+}
+
+func TestGenerate_MatchWritability(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.cfg")
+	cfg := grubCfg(path)
+	cfg.Behavior.MatchWritability = config.Truthy(true)
+	readOnly := snapshot(256, "@/.snapshots/1/snapshot")
+	readOnly.IsReadOnly = true
+	src := archSource
+	src.Options = "root=UUID=abc rootflags=subvol=@"
+
+	out, err := NewGenerator().Generate(bootloader.Input{
+		Cfg: cfg,
+		BootPlans: []*kernel.BootPlan{
+			espPlan(readOnly, "/vmlinuz-linux"),
+			espPlan(snapshot(257, "@/.snapshots/2/snapshot"), "/vmlinuz-linux"),
+		},
+		SourceEntries: []bootloader.SourceEntry{src},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.Diffs, 1)
+	assert.Contains(t, out.Diffs[0].Modified, "subvolid=256 ro\n")
+	assert.Contains(t, out.Diffs[0].Modified, "subvolid=257 rw\n")
+}
+
+func TestGenerate_UnchangedFileHasNoDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.cfg")
+	input := bootloader.Input{
+		Cfg:           grubCfg(path),
+		BootPlans:     []*kernel.BootPlan{espPlan(snapshot(256, "@/.snapshots/1/snapshot"), "/vmlinuz-linux")},
+		SourceEntries: []bootloader.SourceEntry{archSource},
+	}
+	out, err := NewGenerator().Generate(input)
+	require.NoError(t, err)
+	require.Len(t, out.Diffs, 1)
+	require.NoError(t, os.WriteFile(path, []byte(out.Diffs[0].Modified), 0o644))
+
+	out, err = NewGenerator().Generate(input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Diffs)
+	assert.Empty(t, out.UpdatedConfigs)
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, `'Arch Linux'`, quote("Arch Linux"))
+	assert.Equal(t, `'it'\''s $HOME'`, quote("it's $HOME"))
+}
@@ -37,6 +37,13 @@ func DefaultPatterns() []PatternConfig {
 			StripPrefix: "initrd.img-",
 		},
 
+		// openSUSE/Gentoo initramfs (initrd-<version>)
+		{
+			Glob:        "initrd-*",
+			Role:        RoleInitramfs,
+			StripPrefix: "initrd-",
+		},
+
 		// Generic kernel filenames (no suffix to strip, override kernel name)
 		{
 			Glob:       "vmlinuz",
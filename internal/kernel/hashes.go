@@ -0,0 +1,146 @@
+package kernel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HashRecord is the fingerprint of one in-snapshot boot file as recorded
+// the first time it was planned.
+type HashRecord struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// HashStore is the sidecar of boot file hashes used by --verify-hashes,
+// keyed by the file's absolute path inside the mounted snapshot.
+// Snapshots are read-only, so a recorded file whose hash changes points at
+// corruption or a partial update rather than a legitimate upgrade; the
+// original record is kept so the warning repeats until the entry is
+// removed from the sidecar.
+type HashStore struct {
+	// mu guards the maps: planning abandoned by the per-snapshot timeout
+	// can still be verifying files while the next snapshot is planned.
+	// The lock isn't held while hashing, so a hung read blocks only its
+	// own snapshot.
+	mu      sync.Mutex
+	records map[string]HashRecord
+	// checked caches this run's Verify results so re-planning the same
+	// snapshot doesn't rehash or repeat warnings.
+	checked map[string]bool
+}
+
+// LoadHashStore reads the sidecar at path. A missing file yields an empty
+// store so the first run simply records.
+func LoadHashStore(path string) (*HashStore, error) {
+	s := &HashStore{
+		records: make(map[string]HashRecord),
+		checked: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read hash sidecar: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, fmt.Errorf("failed to parse hash sidecar %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Verify stats and hashes the file at absPath, recording it on first
+// sight. Returns false (and logs a warning) when a previously recorded hash
+// no longer matches or the file can't be read.
+func (s *HashStore) Verify(absPath string) bool {
+	s.mu.Lock()
+	ok, done := s.checked[absPath]
+	s.mu.Unlock()
+	if done {
+		return ok
+	}
+	ok = s.verify(absPath)
+	s.mu.Lock()
+	s.checked[absPath] = ok
+	s.mu.Unlock()
+	return ok
+}
+
+func (s *HashStore) verify(absPath string) bool {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		log.Warn().Err(err).Str("file", absPath).Msg("Failed to stat snapshot boot file for hash verification")
+		return false
+	}
+	sum, err := hashFile(absPath)
+	if err != nil {
+		log.Warn().Err(err).Str("file", absPath).Msg("Failed to hash snapshot boot file")
+		return false
+	}
+
+	s.mu.Lock()
+	recorded, ok := s.records[absPath]
+	if !ok {
+		s.records[absPath] = HashRecord{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+	}
+	s.mu.Unlock()
+	if !ok {
+		log.Debug().Str("file", absPath).Str("sha256", sum).Msg("Recorded snapshot boot file hash")
+		return true
+	}
+	if recorded.SHA256 != sum {
+		log.Warn().
+			Str("file", absPath).
+			Str("recorded_sha256", recorded.SHA256).
+			Str("sha256", sum).
+			Int64("recorded_size", recorded.Size).
+			Int64("size", info.Size()).
+			Msg("Snapshot boot file changed since its hash was recorded (corruption or partial update?)")
+		return false
+	}
+	return true
+}
+
+// Marshal renders the sidecar. Records for files that were not seen this
+// run are kept only while the file still exists, so entries for deleted
+// snapshots age out.
+func (s *HashStore) Marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]HashRecord, len(s.records))
+	for path, rec := range s.records {
+		if _, done := s.checked[path]; !done {
+			if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		out[path] = rec
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
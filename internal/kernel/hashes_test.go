@@ -0,0 +1,112 @@
+package kernel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadHashStore_MissingFileIsEmpty(t *testing.T) {
+	store, err := LoadHashStore(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+
+	data, err := store.Marshal()
+	require.NoError(t, err)
+	assert.JSONEq(t, "{}", string(data))
+}
+
+func TestLoadHashStore_MalformedIsError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o644))
+
+	_, err := LoadHashStore(path)
+	assert.Error(t, err)
+}
+
+func TestHashStore_RecordThenVerify(t *testing.T) {
+	dir := t.TempDir()
+	kernelPath := filepath.Join(dir, "vmlinuz-linux")
+	require.NoError(t, os.WriteFile(kernelPath, []byte("kernel v1"), 0o644))
+	sidecar := filepath.Join(dir, "hashes.json")
+
+	store, err := LoadHashStore(sidecar)
+	require.NoError(t, err)
+	assert.True(t, store.Verify(kernelPath), "first sight records the hash")
+	data, err := store.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(sidecar, data, 0o644))
+
+	store, err = LoadHashStore(sidecar)
+	require.NoError(t, err)
+	assert.True(t, store.Verify(kernelPath), "unchanged file matches")
+
+	require.NoError(t, os.WriteFile(kernelPath, []byte("kernel v2"), 0o644))
+	store, err = LoadHashStore(sidecar)
+	require.NoError(t, err)
+	assert.False(t, store.Verify(kernelPath), "changed file is reported")
+	assert.False(t, store.Verify(kernelPath), "result is cached for the run")
+
+	data, err = store.Marshal()
+	require.NoError(t, err)
+	var records map[string]HashRecord
+	require.NoError(t, json.Unmarshal(data, &records))
+	assert.Equal(t, int64(len("kernel v1")), records[kernelPath].Size, "original record is kept after a mismatch")
+}
+
+func TestHashStore_MarshalDropsDeletedFiles(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept")
+	gone := filepath.Join(dir, "gone")
+	require.NoError(t, os.WriteFile(kept, []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(gone, []byte("b"), 0o644))
+	sidecar := filepath.Join(dir, "hashes.json")
+
+	store, err := LoadHashStore(sidecar)
+	require.NoError(t, err)
+	store.Verify(kept)
+	store.Verify(gone)
+	data, err := store.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(sidecar, data, 0o644))
+	require.NoError(t, os.Remove(gone))
+
+	store, err = LoadHashStore(sidecar)
+	require.NoError(t, err)
+	data, err = store.Marshal()
+	require.NoError(t, err)
+
+	var records map[string]HashRecord
+	require.NoError(t, json.Unmarshal(data, &records))
+	assert.Contains(t, records, kept, "unseen record for an existing file is kept")
+	assert.NotContains(t, records, gone)
+}
+
+func TestPlanner_BtrfsMode_VerifyHashes(t *testing.T) {
+	root := t.TempDir()
+	bootDir := filepath.Join(root, "boot")
+	require.NoError(t, os.MkdirAll(bootDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bootDir, "vmlinuz-linux"), []byte("kernel"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(bootDir, "initramfs-linux.img"), []byte("initramfs"), 0o644))
+
+	store, err := LoadHashStore(filepath.Join(t.TempDir(), "hashes.json"))
+	require.NoError(t, err)
+	p := &Planner{rootFS: testRootFS()}
+	p.SetHashStore(store)
+	plans := p.planBtrfsMode(&btrfs.Snapshot{
+		Subvolume:      &btrfs.Subvolume{Path: "@/.snapshots/1/snapshot"},
+		FilesystemPath: root,
+	})
+	require.Len(t, plans, 1)
+
+	data, err := store.Marshal()
+	require.NoError(t, err)
+	var records map[string]HashRecord
+	require.NoError(t, json.Unmarshal(data, &records))
+	assert.Contains(t, records, filepath.Join(bootDir, "vmlinuz-linux"))
+	assert.Contains(t, records, filepath.Join(bootDir, "initramfs-linux.img"))
+}
@@ -2,6 +2,7 @@ package kernel
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -84,9 +85,22 @@ type PatternConfig struct {
 	KernelName string `yaml:"kernel_name,omitempty" mapstructure:"kernel_name"`
 }
 
+// compressionSuffixes are stripped from derived kernel names so a
+// compressed image (vmlinuz-6.19.0-2.gz) groups with its uncompressed
+// siblings (initramfs-6.19.0-2.img).
+var compressionSuffixes = []string{".gz", ".xz", ".zst", ".lz4", ".lzma", ".bz2"}
+
+// versionNamePattern matches kernel names that are a bare kernel release
+// (6.19.0-2, 6.1.0-21-amd64, 6.8.5-301.fc40.x86_64) rather than a package
+// name like linux-lts.
+var versionNamePattern = regexp.MustCompile(`^\d+\.\d+`)
+
 // DeriveKernelName extracts the kernel name from a filename using this pattern's rules.
 // If KernelName is set, it is returned directly (override).
-// Otherwise, StripPrefix and StripSuffix are applied to the filename.
+// Otherwise, StripPrefix and StripSuffix are applied to the filename, then
+// any compression extension is dropped. Version-embedded filenames yield
+// the version as the name, so a release's kernel and initramfs group
+// together.
 // Returns empty string for microcode patterns (no kernel association).
 func (p *PatternConfig) DeriveKernelName(filename string) string {
 	if p.KernelName != "" {
@@ -100,6 +114,12 @@ func (p *PatternConfig) DeriveKernelName(filename string) string {
 	if p.StripSuffix != "" {
 		name = strings.TrimSuffix(name, p.StripSuffix)
 	}
+	for _, ext := range compressionSuffixes {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok {
+			name = trimmed
+			break
+		}
+	}
 
 	// If stripping produced an empty string, return empty
 	if name == "" {
@@ -234,9 +254,20 @@ func (bs *BootSet) KernelVersion() string {
 	return ""
 }
 
+// IsVersionName reports whether a derived kernel name is a bare kernel
+// release taken from a version-embedded filename.
+func IsVersionName(name string) bool {
+	return versionNamePattern.MatchString(name)
+}
+
+// DisplayName is the human-readable kernel name: "Linux-lts" for
+// package-named sets, "Linux 6.19.0-2" for version-named ones.
 func (bs *BootSet) DisplayName() string {
 	if bs.KernelName == "" {
 		return "Linux"
 	}
+	if IsVersionName(bs.KernelName) {
+		return "Linux " + bs.KernelName
+	}
 	return strings.ToUpper(bs.KernelName[:1]) + bs.KernelName[1:]
 }
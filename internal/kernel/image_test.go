@@ -132,6 +132,38 @@ func TestDeriveKernelName_DebianStyle(t *testing.T) {
 	assert.Equal(t, "6.1.0-21-amd64", p.DeriveKernelName("initrd.img-6.1.0-21-amd64"))
 }
 
+func TestDeriveKernelName_Conventions(t *testing.T) {
+	kernel := PatternConfig{Glob: "vmlinuz-*", Role: RoleKernel, StripPrefix: "vmlinuz-"}
+	initramfs := PatternConfig{Glob: "initramfs-*.img", Role: RoleInitramfs, StripPrefix: "initramfs-", StripSuffix: ".img"}
+
+	tests := []struct {
+		name          string
+		kernelFile    string
+		initramfsFile string
+		want          string
+	}{
+		{"package named", "vmlinuz-linux-zen", "initramfs-linux-zen.img", "linux-zen"},
+		{"version named", "vmlinuz-6.19.0-2", "initramfs-6.19.0-2.img", "6.19.0-2"},
+		{"version named with flavour", "vmlinuz-6.6.13-gentoo", "initramfs-6.6.13-gentoo.img", "6.6.13-gentoo"},
+		{"compressed kernel", "vmlinuz-6.19.0-2.gz", "initramfs-6.19.0-2.img", "6.19.0-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, kernel.DeriveKernelName(tt.kernelFile))
+			assert.Equal(t, tt.want, initramfs.DeriveKernelName(tt.initramfsFile))
+		})
+	}
+}
+
+func TestIsVersionName(t *testing.T) {
+	assert.True(t, IsVersionName("6.19.0-2"))
+	assert.True(t, IsVersionName("6.1.0-21-amd64"))
+	assert.True(t, IsVersionName("6.8.5-301.fc40.x86_64"))
+	assert.False(t, IsVersionName("linux"))
+	assert.False(t, IsVersionName("linux-6.6"))
+	assert.False(t, IsVersionName(""))
+}
+
 func TestBootSet_HasFallback(t *testing.T) {
 	bs := &BootSet{KernelName: "linux"}
 	assert.False(t, bs.HasFallback())
@@ -174,6 +206,7 @@ func TestBootSet_DisplayName(t *testing.T) {
 		{"linux", "Linux"},
 		{"linux-lts", "Linux-lts"},
 		{"linux-cachyos", "Linux-cachyos"},
+		{"6.19.0-2", "Linux 6.19.0-2"},
 		{"", "Linux"},
 	}
 
@@ -0,0 +1,169 @@
+package kernel
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PackageKernel is the kernel package a snapshot's package database says
+// is installed.
+type PackageKernel struct {
+	// Manager is the package manager whose database was read: "pacman" or "dpkg".
+	Manager string
+
+	// Versions are the installed package versions (pacman) or kernel
+	// releases (dpkg, from the linux-image-<release> package names).
+	Versions []string
+}
+
+// ReadPackageKernel reads the package database inside the snapshot mounted
+// at snapshotFSPath for the kernel package behind the boot set named
+// kernelName. pacman's local database is searched for a package of that
+// name; dpkg's status file for installed linux-image-<release> packages.
+// Returns nil when neither database is readable or names the kernel, so
+// callers fall back to matching module directories.
+func ReadPackageKernel(snapshotFSPath, kernelName string) *PackageKernel {
+	if kernelName != "" {
+		if versions := readPacmanVersions(snapshotFSPath, kernelName); len(versions) > 0 {
+			return &PackageKernel{Manager: "pacman", Versions: versions}
+		}
+	}
+	if releases := readDpkgKernelReleases(snapshotFSPath); len(releases) > 0 {
+		return &PackageKernel{Manager: "dpkg", Versions: releases}
+	}
+	return nil
+}
+
+// Matches reports whether any of the package's versions is the given kernel
+// version. Versions are compared field by field, splitting on '.', '-', '_'
+// and '+', and a package version matches a kernel version that starts with
+// the same fields: pacman's linux 6.9.7.arch1-1 builds kernel 6.9.7-arch1-1
+// and linux-lts 6.6.35-1 builds 6.6.35-1-lts.
+func (p *PackageKernel) Matches(kernelVersion string) bool {
+	kernelFields := versionFields(kernelVersion)
+	for _, v := range p.Versions {
+		// A package epoch ("1:6.9.7-1") isn't part of the kernel version.
+		if _, rest, ok := strings.Cut(v, ":"); ok {
+			v = rest
+		}
+		fields := versionFields(v)
+		if len(fields) > 0 && len(fields) <= len(kernelFields) && slices.Equal(fields, kernelFields[:len(fields)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func versionFields(version string) []string {
+	return strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '-' || r == '_' || r == '+'
+	})
+}
+
+// readPacmanVersions returns the %VERSION% of the package named name in the
+// snapshot's pacman local database.
+func readPacmanVersions(snapshotFSPath, name string) []string {
+	pattern := filepath.Join(snapshotFSPath, "var", "lib", "pacman", "local", name+"-*", "desc")
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil
+	}
+
+	var versions []string
+	for _, descPath := range matches {
+		fields, err := readPacmanDesc(descPath)
+		if err != nil {
+			log.Debug().Err(err).Str("path", descPath).Msg("Failed to read pacman package description")
+			continue
+		}
+		// The glob also catches e.g. linux-headers-* for linux.
+		if fields["NAME"] == name && fields["VERSION"] != "" {
+			versions = append(versions, fields["VERSION"])
+		}
+	}
+	return versions
+}
+
+// readPacmanDesc parses a pacman desc file's "%FIELD%" headers, keeping the
+// first value line of each.
+func readPacmanDesc(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields := make(map[string]string)
+	var current string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			current = ""
+		case len(line) > 2 && strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			current = strings.Trim(line, "%")
+		case current != "":
+			if _, ok := fields[current]; !ok {
+				fields[current] = line
+			}
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// readDpkgKernelReleases returns the kernel releases of the installed
+// linux-image-<release> packages in the snapshot's dpkg status file.
+// Meta packages (linux-image-amd64) and debug symbols are skipped.
+func readDpkgKernelReleases(snapshotFSPath string) []string {
+	statusPath := filepath.Join(snapshotFSPath, "var", "lib", "dpkg", "status")
+	f, err := os.Open(statusPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Debug().Err(err).Str("path", statusPath).Msg("Failed to read dpkg status")
+		}
+		return nil
+	}
+	defer f.Close()
+
+	var releases []string
+	var pkg, status string
+	flush := func() {
+		release, ok := strings.CutPrefix(pkg, "linux-image-")
+		if ok && status == "install ok installed" && release != "" && unicode.IsDigit(rune(release[0])) && !strings.HasSuffix(release, "-dbg") {
+			release = strings.TrimSuffix(release, "-unsigned")
+			if !slices.Contains(releases, release) {
+				releases = append(releases, release)
+			}
+		}
+		pkg, status = "", ""
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "Package:"); ok {
+			pkg = strings.TrimSpace(value)
+		} else if value, ok := strings.CutPrefix(line, "Status:"); ok {
+			status = strings.TrimSpace(value)
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		log.Debug().Err(err).Str("path", statusPath).Msg("Failed to read dpkg status")
+		return nil
+	}
+	return releases
+}
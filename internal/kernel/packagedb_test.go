@@ -0,0 +1,102 @@
+package kernel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePacmanPackage adds a package to a fake pacman local database.
+func writePacmanPackage(t *testing.T, root, name, version string) {
+	t.Helper()
+	dir := filepath.Join(root, "var", "lib", "pacman", "local", name+"-"+version)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	desc := "%NAME%\n" + name + "\n\n%VERSION%\n" + version + "\n\n%BASE%\n" + name + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "desc"), []byte(desc), 0644))
+}
+
+// writeDpkgStatus writes a fake dpkg status file.
+func writeDpkgStatus(t *testing.T, root, status string) {
+	t.Helper()
+	dir := filepath.Join(root, "var", "lib", "dpkg")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "status"), []byte(status), 0644))
+}
+
+func TestReadPackageKernel_Pacman(t *testing.T) {
+	root := t.TempDir()
+	writePacmanPackage(t, root, "linux", "6.9.7.arch1-1")
+	writePacmanPackage(t, root, "linux-headers", "6.9.7.arch1-1")
+	writePacmanPackage(t, root, "linux-lts", "6.6.35-1")
+
+	pkg := ReadPackageKernel(root, "linux")
+	require.NotNil(t, pkg)
+	assert.Equal(t, "pacman", pkg.Manager)
+	assert.Equal(t, []string{"6.9.7.arch1-1"}, pkg.Versions)
+
+	pkg = ReadPackageKernel(root, "linux-lts")
+	require.NotNil(t, pkg)
+	assert.Equal(t, []string{"6.6.35-1"}, pkg.Versions)
+
+	assert.Nil(t, ReadPackageKernel(root, "linux-zen"))
+}
+
+func TestReadPackageKernel_Dpkg(t *testing.T) {
+	root := t.TempDir()
+	writeDpkgStatus(t, root, `Package: linux-image-6.1.0-18-amd64
+Status: install ok installed
+Version: 6.1.76-1
+
+Package: linux-image-6.1.0-17-amd64
+Status: deinstall ok config-files
+Version: 6.1.69-1
+
+Package: linux-image-amd64
+Status: install ok installed
+Version: 6.1.76-1
+
+Package: linux-image-6.1.0-18-amd64-dbg
+Status: install ok installed
+Version: 6.1.76-1
+
+Package: linux-image-6.1.0-18-cloud-amd64-unsigned
+Status: install ok installed
+Version: 6.1.76-1
+`)
+
+	pkg := ReadPackageKernel(root, "")
+	require.NotNil(t, pkg)
+	assert.Equal(t, "dpkg", pkg.Manager)
+	assert.Equal(t, []string{"6.1.0-18-amd64", "6.1.0-18-cloud-amd64"}, pkg.Versions)
+}
+
+func TestReadPackageKernel_NoDatabase(t *testing.T) {
+	assert.Nil(t, ReadPackageKernel(t.TempDir(), "linux"))
+}
+
+func TestPackageKernel_Matches(t *testing.T) {
+	tests := []struct {
+		name          string
+		versions      []string
+		kernelVersion string
+		want          bool
+	}{
+		{"arch", []string{"6.9.7.arch1-1"}, "6.9.7-arch1-1", true},
+		{"arch older pkgrel", []string{"6.9.7.arch1-1"}, "6.9.7-arch1-10", false},
+		{"lts suffix", []string{"6.6.35-1"}, "6.6.35-1-lts", true},
+		{"zen", []string{"6.9.7.zen1-1"}, "6.9.7-zen1-1-zen", true},
+		{"epoch", []string{"1:6.9.7.arch1-1"}, "6.9.7-arch1-1", true},
+		{"older package", []string{"6.9.6.arch1-1"}, "6.9.7-arch1-1", false},
+		{"dpkg release", []string{"6.1.0-17-amd64", "6.1.0-18-amd64"}, "6.1.0-18-amd64", true},
+		{"dpkg other flavour", []string{"6.1.0-18-cloud-amd64"}, "6.1.0-18-amd64", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg := &PackageKernel{Versions: tt.versions}
+			assert.Equal(t, tt.want, pkg.Matches(tt.kernelVersion))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package kernel
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"os"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/fstab"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -27,6 +29,34 @@ const (
 	BootModeBtrfs BootMode = "btrfs"
 )
 
+// ParseBootMode converts a string to a BootMode, returning an error for unknown values.
+func ParseBootMode(s string) (BootMode, error) {
+	switch BootMode(s) {
+	case BootModeESP, BootModeBtrfs:
+		return BootMode(s), nil
+	default:
+		return "", fmt.Errorf("unknown boot mode: %q (valid: esp, btrfs)", s)
+	}
+}
+
+// BootDirStatus says why a btrfs-mode snapshot's /boot gave no usable
+// kernel images, and so why it was planned in ESP mode instead.
+type BootDirStatus string
+
+const (
+	// BootDirMissing means the snapshot has no readable /boot at all.
+	BootDirMissing BootDirStatus = "missing"
+
+	// BootDirEmpty means /boot is an empty directory, or holds only empty
+	// directories such as /boot/efi: the mount point of a separate /boot
+	// the snapshot didn't capture, for which ESP mode is the right plan.
+	BootDirEmpty BootDirStatus = "empty"
+
+	// BootDirNoKernels means /boot has files, but none is a kernel or UKI
+	// matching the known image patterns that resolves inside the snapshot.
+	BootDirNoKernels BootDirStatus = "no-kernels"
+)
+
 // BootPlan describes how to boot one snapshot. One plan per snapshot per
 // boot set — boot mode is fstab-determined per snapshot, not system-wide.
 type BootPlan struct {
@@ -39,23 +69,53 @@ type BootPlan struct {
 
 	// SnapshotKernel and SnapshotInitrds are absolute paths within the
 	// btrfs filesystem (e.g. /@/.snapshots/73/snapshot/boot/vmlinuz-linux).
-	// SnapshotInitrds is empty for LayoutUKI — the initramfs is embedded.
+	// They are relative to BtrfsVolume's root, not the ESP, and must be
+	// emitted verbatim next to it. SnapshotInitrds is empty for LayoutUKI —
+	// the initramfs is embedded.
 	SnapshotKernel  string
 	SnapshotInitrds []string
 
+	// Fallback marks the extra btrfs-mode plan that boots SnapshotKernel
+	// with its fallback initramfs instead of the primary one (see
+	// Planner.SetFallbackEntries).
+	Fallback bool
+
 	// BtrfsVolume is the rEFInd "volume" identifier (label, UUID, etc.).
 	BtrfsVolume string
+
+	// ESPKernel and ESPInitrds are ESP-relative paths to a copy of the boot
+	// set's kernel and initrds taken while they matched the snapshot's
+	// modules (behavior.copy_boot_to_esp). Empty when there is no copy.
+	ESPKernel  string
+	ESPInitrds []string
+
+	// BootDir is set on the ESP-mode plans of a snapshot whose fstab put it
+	// in btrfs mode but whose /boot had no usable kernel images.
+	BootDir BootDirStatus
+}
+
+// VolumeRelative reports whether the plan's loader and initrds live on the
+// btrfs volume rather than the ESP. Only such plans override the source
+// entry's loader; ESP-relative plans inherit it unchanged.
+func (bp *BootPlan) VolumeRelative() bool {
+	return bp.Mode == BootModeBtrfs && bp.BtrfsVolume != "" && bp.SnapshotKernel != ""
+}
+
+// HasESPCopy reports whether the plan boots a snapshot-consistent copy of
+// its kernel on the ESP rather than the live one.
+func (bp *BootPlan) HasESPCopy() bool {
+	return bp.Mode == BootModeESP && bp.ESPKernel != ""
 }
 
 func (bp *BootPlan) ShouldSkip() bool {
-	if bp.Mode == BootModeBtrfs {
+	if bp.Mode == BootModeBtrfs || bp.HasESPCopy() {
 		return false
 	}
 	return bp.Staleness != nil && bp.Staleness.IsStale && bp.Staleness.Action == ActionDelete
 }
 
 func (bp *BootPlan) IsStale() bool {
-	if bp.Mode == BootModeBtrfs {
+	if bp.Mode == BootModeBtrfs || bp.HasESPCopy() {
 		return false
 	}
 	return bp.Staleness != nil && bp.Staleness.IsStale
@@ -70,6 +130,14 @@ type Planner struct {
 	checker      *Checker
 	bootSets     []*BootSet
 	rootFS       *btrfs.Filesystem
+	hashes       *HashStore
+
+	fallbackEntries bool
+
+	// preferredKernels and maxKernels select the in-snapshot kernels
+	// planned in btrfs mode; see SetBtrfsKernels.
+	preferredKernels []string
+	maxKernels       int
 }
 
 func NewPlanner(fstabMgr *fstab.Manager, checker *Checker, bootSets []*BootSet, rootFS *btrfs.Filesystem) *Planner {
@@ -81,6 +149,31 @@ func NewPlanner(fstabMgr *fstab.Manager, checker *Checker, bootSets []*BootSet,
 	}
 }
 
+// SetHashStore enables --verify-hashes: btrfs-mode planning hashes each
+// in-snapshot kernel and initramfs and warns when a recorded hash changed.
+func (p *Planner) SetHashStore(store *HashStore) {
+	p.hashes = store
+}
+
+// SetFallbackEntries makes btrfs-mode planning add, after each in-snapshot
+// kernel's plan, a second plan booting that kernel with its fallback
+// initramfs (plus microcode). Without it the fallback initramfs is never
+// used: each plan lists only microcode and the primary initramfs.
+func (p *Planner) SetFallbackEntries(enabled bool) {
+	p.fallbackEntries = enabled
+}
+
+// SetBtrfsKernels limits btrfs-mode planning to at most limit of the kernels
+// found in each snapshot's /boot, 0 meaning all. Kernels are ranked by
+// their name's position in preferred, then those named like a live boot
+// set, in boot set order, then the rest; the first plan of a snapshot is
+// the one its managed-config submenu boots, so the ranking applies even
+// without a cap.
+func (p *Planner) SetBtrfsKernels(preferred []string, limit int) {
+	p.preferredKernels = preferred
+	p.maxKernels = limit
+}
+
 // Plan emits one BootPlan per (snapshot × boot set). A snapshot in ESP
 // mode yields one plan per boot set; a snapshot in btrfs mode yields one
 // plan per kernel found inside the snapshot.
@@ -142,40 +235,74 @@ func (p *Planner) analyzeSnapshotBoot(snapshot *btrfs.Snapshot) *fstab.BootMount
 // btrfs filesystem. It scans for kernel images inside the snapshot.
 func (p *Planner) planBtrfsMode(snapshot *btrfs.Snapshot) []*BootPlan {
 	bootDir := filepath.Join(snapshot.FilesystemPath, "boot")
-	kernelImages := findKernelImages(bootDir)
+	kernelImages := p.selectKernelImages(snapshot, findKernelImages(bootDir))
 
 	if len(kernelImages) == 0 {
+		status := snapshotBootDirStatus(bootDir)
+		level := zerolog.WarnLevel
+		if status == BootDirEmpty {
+			// Expected when /boot is a separate mount: every snapshot has
+			// the empty mount point, so it isn't worth a warning each.
+			level = zerolog.DebugLevel
+		}
+		event := log.WithLevel(level).Str("snapshot", snapshot.Path).Str("boot_dir", bootDir).Str("status", string(status))
+		switch status {
+		case BootDirMissing:
+			event.Msg("Btrfs-mode snapshot has no /boot, falling back to ESP mode - check that /boot is part of the snapshotted subvolume")
+		case BootDirEmpty:
+			event.Msg("Snapshot's /boot is an empty mount point, planning it in ESP mode")
+		default:
+			event.Msg("Btrfs-mode snapshot's /boot has no recognised kernel images, falling back to ESP mode - check the kernel file names and symlinks")
+		}
+		plans := p.planESPMode(snapshot)
+		for _, plan := range plans {
+			plan.BootDir = status
+		}
+		return plans
+	}
+
+	btrfsVolume := p.buildBtrfsVolume()
+	if btrfsVolume == "" {
+		// Without a volume directive rEFInd would resolve the
+		// subvolume-qualified loader against the ESP.
 		log.Warn().
 			Str("snapshot", snapshot.Path).
-			Str("boot_dir", bootDir).
-			Msg("Btrfs-mode snapshot has no kernel images in /boot, falling back to ESP mode")
+			Msg("Btrfs filesystem has no label or UUID for rEFInd's volume directive, falling back to ESP mode")
 		return p.planESPMode(snapshot)
 	}
 
-	btrfsVolume := p.buildBtrfsVolume()
 	snapshotSubvolPath := snapshot.Path
 	if !strings.HasPrefix(snapshotSubvolPath, "/") {
 		snapshotSubvolPath = "/" + snapshotSubvolPath
 	}
 
+	initrdPaths := func(initrds []string) []string {
+		var paths []string
+		for _, initrd := range initrds {
+			if p.hashes != nil {
+				p.hashes.Verify(filepath.Join(bootDir, initrd))
+			}
+			initrdPath := filepath.Join(snapshotSubvolPath, "boot", initrd)
+			paths = append(paths, "/"+strings.TrimPrefix(filepath.ToSlash(initrdPath), "/"))
+		}
+		return paths
+	}
+
 	var plans []*BootPlan
 	for _, ki := range kernelImages {
+		if p.hashes != nil {
+			p.hashes.Verify(filepath.Join(snapshot.FilesystemPath, ki.kernelRelPath))
+		}
+
 		loaderPath := filepath.Join(snapshotSubvolPath, ki.kernelRelPath)
 		loaderPath = "/" + strings.TrimPrefix(filepath.ToSlash(loaderPath), "/")
 
-		var initrdPaths []string
-		for _, initrd := range ki.initrdFilenames {
-			initrdPath := filepath.Join(snapshotSubvolPath, "boot", initrd)
-			initrdPath = "/" + strings.TrimPrefix(filepath.ToSlash(initrdPath), "/")
-			initrdPaths = append(initrdPaths, initrdPath)
-		}
-
 		plan := &BootPlan{
 			Snapshot:        snapshot,
 			Mode:            BootModeBtrfs,
 			Layout:          ki.layout,
 			SnapshotKernel:  loaderPath,
-			SnapshotInitrds: initrdPaths,
+			SnapshotInitrds: initrdPaths(ki.initrdFilenames),
 			BtrfsVolume:     btrfsVolume,
 		}
 
@@ -183,16 +310,68 @@ func (p *Planner) planBtrfsMode(snapshot *btrfs.Snapshot) []*BootPlan {
 			Str("snapshot", snapshot.Path).
 			Str("layout", string(ki.layout)).
 			Str("kernel", loaderPath).
-			Strs("initrds", initrdPaths).
+			Strs("initrds", plan.SnapshotInitrds).
 			Str("volume", btrfsVolume).
 			Msg("Planned btrfs-mode boot for snapshot")
 
 		plans = append(plans, plan)
+
+		if p.fallbackEntries && ki.fallbackInitrdFilenames != nil {
+			fallback := *plan
+			fallback.SnapshotInitrds = initrdPaths(ki.fallbackInitrdFilenames)
+			fallback.Fallback = true
+
+			log.Debug().
+				Str("snapshot", snapshot.Path).
+				Str("kernel", loaderPath).
+				Strs("initrds", fallback.SnapshotInitrds).
+				Msg("Planned btrfs-mode fallback boot for snapshot")
+
+			plans = append(plans, &fallback)
+		}
 	}
 
 	return plans
 }
 
+// selectKernelImages orders a snapshot's kernels and caps their number as
+// set by SetBtrfsKernels.
+func (p *Planner) selectKernelImages(snapshot *btrfs.Snapshot, images []kernelImageSet) []kernelImageSet {
+	if len(p.preferredKernels) == 0 && p.maxKernels <= 0 {
+		return images
+	}
+
+	rank := func(ki kernelImageSet) int {
+		if i := slices.Index(p.preferredKernels, ki.kernelName); i >= 0 {
+			return i
+		}
+		for i, bs := range p.bootSets {
+			if bs.KernelName == ki.kernelName {
+				return len(p.preferredKernels) + i
+			}
+		}
+		return len(p.preferredKernels) + len(p.bootSets)
+	}
+	selected := slices.Clone(images)
+	slices.SortStableFunc(selected, func(a, b kernelImageSet) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+
+	if p.maxKernels > 0 && len(selected) > p.maxKernels {
+		var dropped []string
+		for _, ki := range selected[p.maxKernels:] {
+			dropped = append(dropped, ki.kernelName)
+		}
+		log.Debug().
+			Str("snapshot", snapshot.Path).
+			Strs("kernels", dropped).
+			Int("entries_per_snapshot", p.maxKernels).
+			Msg("Leaving out in-snapshot kernels beyond the per-snapshot cap")
+		selected = selected[:p.maxKernels]
+	}
+	return selected
+}
+
 // planESPMode creates BootPlans for a snapshot whose /boot is on the ESP.
 // One plan is created per boot set; staleness is checked.
 func (p *Planner) planESPMode(snapshot *btrfs.Snapshot) []*BootPlan {
@@ -213,21 +392,15 @@ func (p *Planner) planESPMode(snapshot *btrfs.Snapshot) []*BootPlan {
 		if p.checker != nil {
 			staleness = p.checker.CheckSnapshot(snapshot.FilesystemPath, bs)
 
-			if staleness.IsStale {
-				log.Warn().
-					Str("snapshot", snapshot.Path).
-					Str("kernel", bs.KernelName).
-					Str("action", string(staleness.Action)).
-					Str("reason", string(staleness.Reason)).
-					Str("method", string(staleness.Method)).
-					Msg("Snapshot is stale for boot kernel")
-			} else {
-				log.Debug().
-					Str("snapshot", snapshot.Path).
-					Str("kernel", bs.KernelName).
-					Str("method", string(staleness.Method)).
-					Msg("Snapshot is fresh for boot kernel")
-			}
+			// Stale plans are warned about by LogStaleness once every
+			// snapshot is planned, so a kernel every snapshot is stale for
+			// gets one line rather than one per snapshot.
+			log.Debug().
+				Str("snapshot", snapshot.Path).
+				Str("kernel", bs.KernelName).
+				Str("status", staleness.StatusString()).
+				Str("method", string(staleness.Method)).
+				Msg("Checked snapshot against boot kernel")
 		}
 
 		plans = append(plans, &BootPlan{
@@ -274,13 +447,18 @@ func (p *Planner) buildBtrfsVolume() string {
 }
 
 // kernelImageSet represents a kernel and its associated initramfs files
-// found inside a snapshot's /boot directory. For UKI sets, kernelRelPath is
-// /boot/EFI/Linux/<file>.efi and initrdFilenames is nil.
+// found inside a snapshot's /boot directory. initrdFilenames holds the
+// microcode and primary initramfs only; the fallback initramfs, with the
+// same microcode, is kept apart in fallbackInitrdFilenames so it is never
+// loaded alongside the primary one. For UKI sets, kernelRelPath is
+// /boot/EFI/Linux/<file>.efi and both initrd lists are nil.
 type kernelImageSet struct {
-	kernelRelPath   string // path relative to the snapshot root, e.g. "boot/vmlinuz-linux" or "boot/EFI/Linux/linux.efi"
-	kernelFilename  string
-	initrdFilenames []string
-	layout          BootLayout
+	kernelRelPath           string // path relative to the snapshot root, e.g. "boot/vmlinuz-linux" or "boot/EFI/Linux/linux.efi"
+	kernelFilename          string
+	kernelName              string   // e.g. "linux-lts"; a UKI's file name without .efi
+	initrdFilenames         []string // relative to the snapshot's /boot; symlinks already resolved
+	fallbackInitrdFilenames []string // as initrdFilenames; nil without a fallback initramfs
+	layout                  BootLayout
 }
 
 // findKernelImages scans a directory for kernel images and pairs them with
@@ -302,6 +480,9 @@ func findKernelImages(bootDir string) []kernelImageSet {
 
 	type imageMatch struct {
 		filename   string
+		path       string // relative to bootDir, symlinks resolved; empty when dangling
+		symlink    bool
+		followable bool // rEFInd reaches path through filename
 		role       ImageRole
 		kernelName string
 	}
@@ -317,8 +498,12 @@ func findKernelImages(bootDir string) []kernelImageSet {
 			if err != nil || !ok {
 				continue
 			}
+			path, followable, _ := resolveSnapshotBootFile(bootDir, filename)
 			matches = append(matches, imageMatch{
 				filename:   filename,
+				path:       path,
+				symlink:    entry.Type()&os.ModeSymlink != 0,
+				followable: followable,
 				role:       pattern.Role,
 				kernelName: pattern.DeriveKernelName(filename),
 			})
@@ -327,9 +512,12 @@ func findKernelImages(bootDir string) []kernelImageSet {
 	}
 
 	type imageGroup struct {
-		kernel   string
-		initrds  []string
-		fallback string
+		kernel    string
+		target    string // the kernel symlink's resolved target; empty when kernel isn't a link
+		initrds   []string
+		fallback  string
+		dangling  []string
+		duplicate bool // the kernel is planned by another group's symlink
 	}
 	groups := make(map[string]*imageGroup)
 
@@ -342,21 +530,36 @@ func findKernelImages(bootDir string) []kernelImageSet {
 			g = &imageGroup{}
 			groups[m.kernelName] = g
 		}
+		if m.path == "" && m.role != RoleFallbackInitramfs {
+			g.dangling = append(g.dangling, m.filename)
+			continue
+		}
 		switch m.role {
 		case RoleKernel:
-			g.kernel = m.filename
+			g.kernel = m.path
+			if m.symlink {
+				g.target = m.path
+				if m.followable {
+					g.kernel = m.filename
+				}
+			}
 		case RoleInitramfs:
-			g.initrds = append(g.initrds, m.filename)
+			g.initrds = append(g.initrds, m.path)
 		case RoleFallbackInitramfs:
-			g.fallback = m.filename
+			g.fallback = m.path
 		}
 	}
 
 	var microcodeFiles []string
 	for _, m := range matches {
-		if m.role == RoleMicrocode {
-			microcodeFiles = append(microcodeFiles, m.filename)
+		if m.role != RoleMicrocode {
+			continue
 		}
+		if m.path == "" {
+			log.Warn().Str("dir", bootDir).Str("file", m.filename).Msg("Skipping microcode symlink that does not resolve inside the snapshot")
+			continue
+		}
+		microcodeFiles = append(microcodeFiles, m.path)
 	}
 
 	names := make([]string, 0, len(groups))
@@ -365,9 +568,59 @@ func findKernelImages(bootDir string) []kernelImageSet {
 	}
 	slices.Sort(names)
 
+	// rEFInd follows a relative kernel symlink within the btrfs volume, so
+	// such a link keeps its stable name as the loader path, and a group for
+	// its target, like vmlinuz-linux-6.19 behind vmlinuz-linux, would plan
+	// the same kernel twice. The target's group is folded into the link's,
+	// lending it an initramfs when the link's name has none. Of several
+	// links to one kernel, the first with an initramfs of its own is kept.
+	linked := make(map[string]*imageGroup)
+	for _, name := range names {
+		g := groups[name]
+		if g.target == "" || len(g.dangling) > 0 {
+			continue
+		}
+		if kept, ok := linked[g.target]; ok {
+			if len(kept.initrds) > 0 || len(g.initrds) == 0 {
+				g.duplicate = true
+				continue
+			}
+			kept.duplicate = true
+		}
+		linked[g.target] = g
+	}
+	for _, name := range names {
+		g := groups[name]
+		link, ok := linked[g.kernel]
+		if g.target != "" || !ok {
+			continue
+		}
+		if len(g.dangling) == 0 {
+			if len(link.initrds) == 0 {
+				link.initrds = g.initrds
+			}
+			if link.fallback == "" {
+				link.fallback = g.fallback
+			}
+		}
+		g.duplicate = true
+	}
+
 	var result []kernelImageSet
 	for _, name := range names {
 		g := groups[name]
+		if g.duplicate {
+			log.Debug().Str("dir", bootDir).Str("kernel_name", name).Str("kernel", g.kernel).Msg("Skipping kernel group already planned through a kernel symlink")
+			continue
+		}
+		if len(g.dangling) > 0 {
+			log.Warn().
+				Str("dir", bootDir).
+				Str("kernel_name", name).
+				Strs("files", g.dangling).
+				Msg("Skipping kernel group with symlinks that do not resolve inside the snapshot")
+			continue
+		}
 		if g.kernel == "" {
 			log.Debug().Str("kernel_name", name).Msg("Skipping kernel group with no kernel image in snapshot /boot")
 			continue
@@ -377,11 +630,18 @@ func findKernelImages(bootDir string) []kernelImageSet {
 		allInitrds = append(allInitrds, microcodeFiles...)
 		allInitrds = append(allInitrds, g.initrds...)
 
+		var fallbackInitrds []string
+		if g.fallback != "" {
+			fallbackInitrds = append(slices.Clone(microcodeFiles), g.fallback)
+		}
+
 		result = append(result, kernelImageSet{
-			kernelRelPath:   filepath.ToSlash(filepath.Join("boot", g.kernel)),
-			kernelFilename:  g.kernel,
-			initrdFilenames: allInitrds,
-			layout:          LayoutSplit,
+			kernelRelPath:           filepath.ToSlash(filepath.Join("boot", g.kernel)),
+			kernelFilename:          filepath.Base(g.kernel),
+			kernelName:              name,
+			initrdFilenames:         allInitrds,
+			fallbackInitrdFilenames: fallbackInitrds,
+			layout:                  LayoutSplit,
 		})
 	}
 
@@ -390,6 +650,37 @@ func findKernelImages(bootDir string) []kernelImageSet {
 	return result
 }
 
+// snapshotBootDirStatus classifies a snapshot /boot in which
+// findKernelImages found nothing usable.
+func snapshotBootDirStatus(bootDir string) BootDirStatus {
+	entries, err := os.ReadDir(bootDir)
+	switch {
+	case err != nil:
+		return BootDirMissing
+	case onlyEmptyDirs(bootDir, entries):
+		return BootDirEmpty
+	default:
+		return BootDirNoKernels
+	}
+}
+
+// onlyEmptyDirs reports whether entries, read from dir, are all
+// directories holding nothing but empty directories, like the /boot/efi
+// mount point in an uncaptured /boot.
+func onlyEmptyDirs(dir string, entries []os.DirEntry) bool {
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			return false
+		}
+		sub := filepath.Join(dir, entry.Name())
+		subEntries, err := os.ReadDir(sub)
+		if err != nil || !onlyEmptyDirs(sub, subEntries) {
+			return false
+		}
+	}
+	return true
+}
+
 // findUKIsInSnapshot walks <bootDir>/EFI/Linux/ for *.efi UKIs. Each becomes
 // a self-contained kernelImageSet with no initrds and layout=UKI.
 func findUKIsInSnapshot(bootDir string) []kernelImageSet {
@@ -417,15 +708,72 @@ func findUKIsInSnapshot(bootDir string) []kernelImageSet {
 
 	out := make([]kernelImageSet, 0, len(names))
 	for _, name := range names {
+		path, _, ok := resolveSnapshotBootFile(bootDir, filepath.Join("EFI", "Linux", name))
+		if !ok {
+			log.Warn().Str("dir", ukiDir).Str("file", name).Msg("Skipping UKI symlink that does not resolve inside the snapshot")
+			continue
+		}
 		out = append(out, kernelImageSet{
-			kernelRelPath:  filepath.ToSlash(filepath.Join("boot", "EFI", "Linux", name)),
+			kernelRelPath:  filepath.ToSlash(filepath.Join("boot", path)),
 			kernelFilename: name,
+			kernelName:     name[:len(name)-len(".efi")],
 			layout:         LayoutUKI,
 		})
 	}
 	return out
 }
 
+// maxSymlinkHops bounds symlink chains followed by resolveSnapshotBootFile.
+const maxSymlinkHops = 8
+
+// resolveSnapshotBootFile returns the path of rel (relative to bootDir)
+// with symlinks resolved, still relative to bootDir. Absolute link targets
+// are resolved against the snapshot root, as they would be once the
+// snapshot is booted. Setups that symlink /boot contents to the ESP leave
+// links whose targets lie outside the snapshot or don't exist in it; those
+// report ok=false and the caller must not emit a loader path for them.
+// The result may start with "../" when the target lives outside /boot.
+// followable reports whether rEFInd can reach the file through rel's own
+// name: it follows relative links on the btrfs volume, but would resolve an
+// absolute target against the volume's root rather than the snapshot's.
+func resolveSnapshotBootFile(bootDir, rel string) (resolved string, followable, ok bool) {
+	snapshotRoot := filepath.Dir(bootDir)
+	current := filepath.Join(bootDir, rel)
+	followable = true
+
+	for range maxSymlinkHops {
+		info, err := os.Lstat(current)
+		if err != nil {
+			return "", false, false
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			if !info.Mode().IsRegular() {
+				return "", false, false
+			}
+			resolved, err := filepath.Rel(bootDir, current)
+			if err != nil {
+				return "", false, false
+			}
+			return resolved, followable, true
+		}
+
+		target, err := os.Readlink(current)
+		if err != nil {
+			return "", false, false
+		}
+		if filepath.IsAbs(target) {
+			current = filepath.Join(snapshotRoot, filepath.Clean(target))
+			followable = false
+		} else {
+			current = filepath.Join(filepath.Dir(current), target)
+		}
+		if inRoot, err := filepath.Rel(snapshotRoot, current); err != nil || inRoot == ".." || strings.HasPrefix(inRoot, "../") {
+			return "", false, false
+		}
+	}
+	return "", false, false
+}
+
 // GroupBySnapshot groups plans by snapshot path.
 func GroupBySnapshot(plans []*BootPlan) map[string][]*BootPlan {
 	result := make(map[string][]*BootPlan)
@@ -298,6 +298,24 @@ func TestPlanner_BtrfsMode_FallbackToESP(t *testing.T) {
 	assert.Equal(t, BootModeESP, plans[0].Mode)
 }
 
+func TestPlanner_BtrfsMode_NoVolumeIdentifierFallsBackToESP(t *testing.T) {
+	tmpDir := t.TempDir()
+	snapshot := testSnapshot("@/.snapshots/73/snapshot", tmpDir)
+
+	setupSnapshotFstab(t, tmpDir, `UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=@/.snapshots/73/snapshot 0 1
+`)
+	setupSnapshotBoot(t, tmpDir, []string{"vmlinuz-linux", "initramfs-linux.img"})
+
+	// No label or UUID: a volume-relative loader would be resolved on the ESP.
+	rootFS := &btrfs.Filesystem{Device: "/dev/sda2", MountPoint: "/"}
+	planner := NewPlanner(fstab.NewManager(), nil, nil, rootFS)
+	plans := planner.Plan([]*btrfs.Snapshot{snapshot})
+
+	require.Len(t, plans, 1)
+	assert.Equal(t, BootModeESP, plans[0].Mode)
+	assert.False(t, plans[0].VolumeRelative())
+}
+
 func TestPlanner_NoFstab(t *testing.T) {
 	tmpDir := t.TempDir()
 	snapshot := testSnapshot("@/.snapshots/99/snapshot", tmpDir)
@@ -446,8 +464,253 @@ func TestFindKernelImages(t *testing.T) {
 	results := findKernelImages(tmpDir)
 	require.Len(t, results, 1)
 	assert.Equal(t, "vmlinuz-linux", results[0].kernelFilename)
-	// Should have microcode + primary initramfs
-	assert.GreaterOrEqual(t, len(results[0].initrdFilenames), 1)
+	// The fallback initramfs is never listed with the primary one.
+	assert.Equal(t, []string{"intel-ucode.img", "initramfs-linux.img"}, results[0].initrdFilenames)
+	assert.Equal(t, []string{"intel-ucode.img", "initramfs-linux-fallback.img"}, results[0].fallbackInitrdFilenames)
+}
+
+func TestPlanner_BtrfsMode_FallbackEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	snapshot := testSnapshot("@/.snapshots/73/snapshot", tmpDir)
+	setupSnapshotFstab(t, tmpDir, `UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=@/.snapshots/73/snapshot 0 1
+`)
+	setupSnapshotBoot(t, tmpDir, []string{
+		"vmlinuz-linux",
+		"initramfs-linux.img",
+		"initramfs-linux-fallback.img",
+		"vmlinuz-linux-lts",
+		"initramfs-linux-lts.img",
+		"amd-ucode.img",
+	})
+
+	planner := NewPlanner(fstab.NewManager(), nil, nil, testRootFS())
+	plans := planner.Plan([]*btrfs.Snapshot{snapshot})
+	require.Len(t, plans, 2, "no fallback plans unless enabled")
+	for _, plan := range plans {
+		assert.False(t, plan.Fallback)
+	}
+
+	planner.SetFallbackEntries(true)
+	plans = planner.Plan([]*btrfs.Snapshot{snapshot})
+	require.Len(t, plans, 3, "only linux has a fallback initramfs")
+	assert.False(t, plans[0].Fallback)
+	assert.Equal(t, []string{
+		"/@/.snapshots/73/snapshot/boot/amd-ucode.img",
+		"/@/.snapshots/73/snapshot/boot/initramfs-linux.img",
+	}, plans[0].SnapshotInitrds)
+	assert.True(t, plans[1].Fallback)
+	assert.Equal(t, plans[0].SnapshotKernel, plans[1].SnapshotKernel)
+	assert.Equal(t, []string{
+		"/@/.snapshots/73/snapshot/boot/amd-ucode.img",
+		"/@/.snapshots/73/snapshot/boot/initramfs-linux-fallback.img",
+	}, plans[1].SnapshotInitrds)
+	assert.False(t, plans[2].Fallback)
+	assert.Contains(t, plans[2].SnapshotKernel, "vmlinuz-linux-lts")
+}
+
+func TestPlanner_BtrfsMode_BtrfsKernels(t *testing.T) {
+	tmpDir := t.TempDir()
+	snapshot := testSnapshot("@/.snapshots/73/snapshot", tmpDir)
+	setupSnapshotFstab(t, tmpDir, `UUID=12345678-1234-1234-1234-123456789abc / btrfs subvol=@/.snapshots/73/snapshot 0 1
+`)
+	setupSnapshotBoot(t, tmpDir, []string{
+		"vmlinuz-linux",
+		"initramfs-linux.img",
+		"vmlinuz-linux-lts",
+		"initramfs-linux-lts.img",
+		"vmlinuz-linux-zen",
+		"initramfs-linux-zen.img",
+	})
+	kernels := func(plans []*BootPlan) []string {
+		var names []string
+		for _, plan := range plans {
+			names = append(names, filepath.Base(plan.SnapshotKernel))
+		}
+		return names
+	}
+
+	liveZen := []*BootSet{{KernelName: "linux-zen"}}
+	planner := NewPlanner(fstab.NewManager(), nil, liveZen, testRootFS())
+	assert.Equal(t, []string{"vmlinuz-linux", "vmlinuz-linux-lts", "vmlinuz-linux-zen"},
+		kernels(planner.Plan([]*btrfs.Snapshot{snapshot})), "all kernels, in name order, by default")
+
+	planner.SetBtrfsKernels(nil, 1)
+	assert.Equal(t, []string{"vmlinuz-linux-zen"}, kernels(planner.Plan([]*btrfs.Snapshot{snapshot})),
+		"the kernel of a live boot set ranks first")
+
+	planner.SetBtrfsKernels([]string{"linux-lts"}, 2)
+	assert.Equal(t, []string{"vmlinuz-linux-lts", "vmlinuz-linux-zen"}, kernels(planner.Plan([]*btrfs.Snapshot{snapshot})))
+
+	planner.SetBtrfsKernels([]string{"linux-lts"}, 0)
+	assert.Equal(t, []string{"vmlinuz-linux-lts", "vmlinuz-linux-zen", "vmlinuz-linux"},
+		kernels(planner.Plan([]*btrfs.Snapshot{snapshot})), "without a cap only the order changes")
+}
+
+func TestFindKernelImages_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	bootDir := filepath.Join(root, "boot")
+	require.NoError(t, os.MkdirAll(filepath.Join(bootDir, "kernels"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr", "lib"), 0o755))
+
+	// linux: relative links within the snapshot resolve; the kernel keeps
+	// its link name, which rEFInd follows.
+	require.NoError(t, os.WriteFile(filepath.Join(bootDir, "kernels", "vmlinuz-6.1"), []byte("fake"), 0o644))
+	require.NoError(t, os.Symlink("kernels/vmlinuz-6.1", filepath.Join(bootDir, "vmlinuz-linux")))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "usr", "lib", "initramfs"), []byte("fake"), 0o644))
+	require.NoError(t, os.Symlink("../usr/lib/initramfs", filepath.Join(bootDir, "initramfs-linux.img")))
+
+	// linux-hardened: absolute links resolve against the snapshot root. The
+	// kernel's loader path is its target, as rEFInd would resolve the link
+	// against the volume's root instead.
+	require.NoError(t, os.WriteFile(filepath.Join(bootDir, "kernels", "vmlinuz-6.6-hardened"), []byte("fake"), 0o644))
+	require.NoError(t, os.Symlink("/boot/kernels/vmlinuz-6.6-hardened", filepath.Join(bootDir, "vmlinuz-linux-hardened")))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "usr", "lib", "initramfs-hardened"), []byte("fake"), 0o644))
+	require.NoError(t, os.Symlink("/../usr/lib/initramfs-hardened", filepath.Join(bootDir, "initramfs-linux-hardened.img")))
+
+	// linux-lts: kernel links to the live ESP, which isn't in the snapshot.
+	require.NoError(t, os.Symlink("/efi/vmlinuz-linux-lts", filepath.Join(bootDir, "vmlinuz-linux-lts")))
+	require.NoError(t, os.WriteFile(filepath.Join(bootDir, "initramfs-linux-lts.img"), []byte("fake"), 0o644))
+
+	// linux-zen: initramfs escapes the snapshot root.
+	require.NoError(t, os.WriteFile(filepath.Join(bootDir, "vmlinuz-linux-zen"), []byte("fake"), 0o644))
+	require.NoError(t, os.Symlink("../../initramfs-linux-zen.img", filepath.Join(bootDir, "initramfs-linux-zen.img")))
+
+	results := findKernelImages(bootDir)
+	require.Len(t, results, 2, "groups with dangling symlinks are skipped")
+	assert.Equal(t, "boot/vmlinuz-linux", results[0].kernelRelPath)
+	assert.Equal(t, "vmlinuz-linux", results[0].kernelFilename)
+	assert.Equal(t, []string{"../usr/lib/initramfs"}, results[0].initrdFilenames)
+	assert.Equal(t, "linux-hardened", results[1].kernelName)
+	assert.Equal(t, "boot/kernels/vmlinuz-6.6-hardened", results[1].kernelRelPath)
+	assert.Equal(t, []string{"../usr/lib/initramfs-hardened"}, results[1].initrdFilenames)
+}
+
+func TestFindKernelImages_VersionedKernelSymlink(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string
+		links       map[string]string
+		wantKernels []string
+		wantInitrds [][]string
+	}{
+		{
+			name:        "link_with_own_initramfs",
+			files:       []string{"vmlinuz-linux-6.19", "initramfs-linux.img"},
+			links:       map[string]string{"vmlinuz-linux": "vmlinuz-linux-6.19"},
+			wantKernels: []string{"boot/vmlinuz-linux"},
+			wantInitrds: [][]string{{"initramfs-linux.img"}},
+		},
+		{
+			name:        "initramfs_named_for_target",
+			files:       []string{"vmlinuz-linux-6.19", "initramfs-linux-6.19.img"},
+			links:       map[string]string{"vmlinuz-linux": "vmlinuz-linux-6.19"},
+			wantKernels: []string{"boot/vmlinuz-linux"},
+			wantInitrds: [][]string{{"initramfs-linux-6.19.img"}},
+		},
+		{
+			name:        "two_links_to_one_kernel",
+			files:       []string{"vmlinuz-6.19", "initrd.img"},
+			links:       map[string]string{"vmlinuz": "vmlinuz-6.19", "vmlinuz-6.19-current": "vmlinuz-6.19"},
+			wantKernels: []string{"boot/vmlinuz"},
+			wantInitrds: [][]string{{"initrd.img"}},
+		},
+		{
+			name:        "unrelated_kernels_kept",
+			files:       []string{"vmlinuz-linux-6.19", "vmlinuz-linux-lts", "initramfs-linux.img", "initramfs-linux-lts.img"},
+			links:       map[string]string{"vmlinuz-linux": "vmlinuz-linux-6.19"},
+			wantKernels: []string{"boot/vmlinuz-linux", "boot/vmlinuz-linux-lts"},
+			wantInitrds: [][]string{{"initramfs-linux.img"}, {"initramfs-linux-lts.img"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bootDir := t.TempDir()
+			for _, file := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(bootDir, file), []byte("fake"), 0o644))
+			}
+			for link, target := range tt.links {
+				require.NoError(t, os.Symlink(target, filepath.Join(bootDir, link)))
+			}
+
+			var kernels []string
+			var initrds [][]string
+			for _, ki := range findKernelImages(bootDir) {
+				kernels = append(kernels, ki.kernelRelPath)
+				initrds = append(initrds, ki.initrdFilenames)
+			}
+			assert.Equal(t, tt.wantKernels, kernels)
+			assert.Equal(t, tt.wantInitrds, initrds)
+		})
+	}
+}
+
+func TestPlanner_BtrfsMode_DanglingSymlinkFallsBackToESP(t *testing.T) {
+	root := t.TempDir()
+	bootDir := filepath.Join(root, "boot")
+	require.NoError(t, os.MkdirAll(bootDir, 0o755))
+	require.NoError(t, os.Symlink("/efi/vmlinuz-linux", filepath.Join(bootDir, "vmlinuz-linux")))
+	require.NoError(t, os.Symlink("/efi/initramfs-linux.img", filepath.Join(bootDir, "initramfs-linux.img")))
+
+	p := &Planner{}
+	plans := p.planBtrfsMode(&btrfs.Snapshot{
+		Subvolume:      &btrfs.Subvolume{Path: "@/.snapshots/1/snapshot"},
+		FilesystemPath: root,
+	})
+	require.Len(t, plans, 1)
+	assert.Equal(t, BootModeESP, plans[0].Mode)
+	assert.Equal(t, BootDirNoKernels, plans[0].BootDir)
+}
+
+func TestPlanner_BtrfsMode_BootDirStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, root string)
+		want  BootDirStatus
+	}{
+		{
+			name:  "missing",
+			setup: func(t *testing.T, root string) {},
+			want:  BootDirMissing,
+		},
+		{
+			name: "empty",
+			setup: func(t *testing.T, root string) {
+				require.NoError(t, os.Mkdir(filepath.Join(root, "boot"), 0o755))
+			},
+			want: BootDirEmpty,
+		},
+		{
+			name: "empty_with_mount_points",
+			setup: func(t *testing.T, root string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "boot", "efi"), 0o755))
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "boot", "grub", "x86_64-efi"), 0o755))
+			},
+			want: BootDirEmpty,
+		},
+		{
+			name: "no_kernels",
+			setup: func(t *testing.T, root string) {
+				require.NoError(t, os.Mkdir(filepath.Join(root, "boot"), 0o755))
+				require.NoError(t, os.WriteFile(filepath.Join(root, "boot", "kernel-custom"), []byte("fake"), 0o644))
+			},
+			want: BootDirNoKernels,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			tt.setup(t, root)
+
+			p := &Planner{}
+			plans := p.planBtrfsMode(&btrfs.Snapshot{
+				Subvolume:      &btrfs.Subvolume{Path: "@/.snapshots/1/snapshot"},
+				FilesystemPath: root,
+			})
+			require.Len(t, plans, 1)
+			assert.Equal(t, BootModeESP, plans[0].Mode)
+			assert.Equal(t, tt.want, plans[0].BootDir)
+		})
+	}
 }
 
 func TestFindKernelImages_NonexistentDir(t *testing.T) {
@@ -822,3 +1085,16 @@ UUID=AAAA-BBBB /boot vfat defaults 0 2
 		}
 	}
 }
+
+func TestParseBootMode(t *testing.T) {
+	mode, err := ParseBootMode("esp")
+	require.NoError(t, err)
+	assert.Equal(t, BootModeESP, mode)
+
+	mode, err = ParseBootMode("btrfs")
+	require.NoError(t, err)
+	assert.Equal(t, BootModeBtrfs, mode)
+
+	_, err = ParseBootMode("uki")
+	assert.Error(t, err)
+}
@@ -119,6 +119,33 @@ func TestScanDir_BzImage(t *testing.T) {
 	assert.Equal(t, "linux", images[0].KernelName)
 }
 
+func TestBuildBootSets_VersionNamed(t *testing.T) {
+	dir := createTestBootDir(t, []string{
+		"vmlinuz-6.19.0-2",
+		"initramfs-6.19.0-2.img",
+		"vmlinuz-6.18.7-1",
+		"initrd-6.18.7-1",
+	})
+
+	scanner := NewScanner(dir, DefaultPatterns())
+	images, err := scanner.ScanDir(dir)
+	require.NoError(t, err)
+
+	sets := scanner.BuildBootSets(images)
+	require.Len(t, sets, 2)
+	byName := make(map[string]*BootSet)
+	for _, bs := range sets {
+		byName[bs.KernelName] = bs
+	}
+	for _, version := range []string{"6.19.0-2", "6.18.7-1"} {
+		bs := byName[version]
+		require.NotNil(t, bs, version)
+		assert.NotNil(t, bs.Kernel, version)
+		assert.NotNil(t, bs.Initramfs, version)
+		assert.Equal(t, "Linux "+version, bs.DisplayName())
+	}
+}
+
 func TestScanDir_EmptyDir(t *testing.T) {
 	dir := t.TempDir()
 
@@ -0,0 +1,43 @@
+package kernel
+
+import (
+	"path/filepath"
+	"slices"
+)
+
+// SnapshotKernel is a kernel found in a snapshot's /boot by the same scan
+// btrfs-mode planning uses, for diagnosing snapshots that won't boot.
+type SnapshotKernel struct {
+	Path    string     `json:"path"`              // relative to the snapshot root
+	Initrds []string   `json:"initrds,omitempty"` // relative to the snapshot's /boot
+	Layout  BootLayout `json:"layout"`
+	Version string     `json:"version,omitempty"` // from the image header; empty when unreadable
+
+	// ModulesMatch reports whether the snapshot has a /lib/modules
+	// directory for Version. Always false when Version is unknown.
+	ModulesMatch bool `json:"modules_match"`
+}
+
+// FindSnapshotKernels lists the kernels in the snapshot mounted at
+// snapshotFSPath, inspecting each for its version and checking it against
+// modules, the snapshot's /lib/modules versions.
+func FindSnapshotKernels(snapshotFSPath string, modules []string) []SnapshotKernel {
+	var kernels []SnapshotKernel
+	for _, ki := range findKernelImages(filepath.Join(snapshotFSPath, "boot")) {
+		k := SnapshotKernel{
+			Path:    ki.kernelRelPath,
+			Initrds: ki.initrdFilenames,
+			Layout:  ki.layout,
+		}
+		hint := RoleKernel
+		if ki.layout == LayoutUKI {
+			hint = RoleUKI
+		}
+		if meta, err := Inspect(filepath.Join(snapshotFSPath, ki.kernelRelPath), hint); err == nil {
+			k.Version = meta.Version
+			k.ModulesMatch = k.Version != "" && slices.Contains(modules, k.Version)
+		}
+		kernels = append(kernels, k)
+	}
+	return kernels
+}
@@ -0,0 +1,47 @@
+package kernel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindSnapshotKernels(t *testing.T) {
+	fsPath := t.TempDir()
+	setupSnapshotBoot(t, fsPath, []string{"initramfs-linux.img", "vmlinuz-linux-lts", "initramfs-linux-lts.img"})
+	bootDir := filepath.Join(fsPath, "boot")
+	require.NoError(t, os.Rename(createFakeKernel(t, bootDir, "6.19.0-2-cachyos (user@host) #1 SMP", 0x020F), filepath.Join(bootDir, "vmlinuz-linux")))
+	setupSnapshotModules(t, fsPath, []string{"6.19.0-2-cachyos"})
+
+	kernels := FindSnapshotKernels(fsPath, GetSnapshotModuleVersions(fsPath))
+
+	require.Len(t, kernels, 2)
+	assert.Equal(t, SnapshotKernel{
+		Path:         "boot/vmlinuz-linux",
+		Initrds:      []string{"initramfs-linux.img"},
+		Layout:       LayoutSplit,
+		Version:      "6.19.0-2-cachyos",
+		ModulesMatch: true,
+	}, kernels[0])
+	// The lts kernel is unreadable, so its modules can't be matched.
+	assert.Equal(t, "boot/vmlinuz-linux-lts", kernels[1].Path)
+	assert.Empty(t, kernels[1].Version)
+	assert.False(t, kernels[1].ModulesMatch)
+}
+
+func TestFindSnapshotKernels_ModulesMismatch(t *testing.T) {
+	fsPath := t.TempDir()
+	bootDir := filepath.Join(fsPath, "boot")
+	require.NoError(t, os.MkdirAll(bootDir, 0o755))
+	require.NoError(t, os.Rename(createFakeKernel(t, bootDir, "6.20.1-arch1-1 (user@host) #1 SMP", 0x020F), filepath.Join(bootDir, "vmlinuz-linux")))
+	setupSnapshotModules(t, fsPath, []string{"6.19.0-2-cachyos"})
+
+	kernels := FindSnapshotKernels(fsPath, GetSnapshotModuleVersions(fsPath))
+
+	require.Len(t, kernels, 1)
+	assert.Equal(t, "6.20.1-arch1-1", kernels[0].Version)
+	assert.False(t, kernels[0].ModulesMatch)
+}
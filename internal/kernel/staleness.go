@@ -49,6 +49,10 @@ const (
 
 	// ReasonNoModulesDir means the snapshot has no /lib/modules/ directory at all.
 	ReasonNoModulesDir StaleReason = "no_modules_dir"
+
+	// ReasonPackageMismatch means the snapshot's package database records a
+	// different kernel package version than the current boot kernel.
+	ReasonPackageMismatch StaleReason = "package_mismatch"
 )
 
 // MatchMethod describes how the staleness check matched (or failed to match)
@@ -64,6 +68,11 @@ const (
 	// match the module version to the boot set's kernel name.
 	MatchPkgbase MatchMethod = "pkgbase"
 
+	// MatchPackageDB means the kernel package version recorded in the
+	// snapshot's pacman or dpkg database was compared against the inspected
+	// kernel version (kernel.use_package_db).
+	MatchPackageDB MatchMethod = "package_db"
+
 	// MatchAssumedFresh means staleness could not be determined (no inspected
 	// version, no pkgbase match) so the snapshot is assumed bootable.
 	MatchAssumedFresh MatchMethod = "assumed_fresh"
@@ -112,6 +121,7 @@ func (r *StalenessResult) StatusString() string {
 // Checker performs staleness checks for snapshots against boot sets.
 type Checker struct {
 	defaultAction StaleAction
+	usePackageDB  bool
 }
 
 // NewChecker creates a staleness checker with the given default action.
@@ -119,8 +129,16 @@ func NewChecker(action StaleAction) *Checker {
 	return &Checker{defaultAction: action}
 }
 
+// SetUsePackageDB makes CheckSnapshot compare the inspected kernel version
+// against the kernel package recorded in the snapshot's package database
+// before matching module directories, which can outlive their package.
+func (c *Checker) SetUsePackageDB(enabled bool) {
+	c.usePackageDB = enabled
+}
+
 // CheckSnapshot determines if a snapshot is stale relative to a boot set.
 // It uses the best available matching method:
+//  0. Package database version (when enabled and the database is readable)
 //  1. Binary header version (most reliable, requires kernel inspection)
 //  2. Pkgbase file matching (Arch-specific, reliable)
 //  3. Assumes fresh with warning (when neither method is available)
@@ -145,6 +163,38 @@ func (c *Checker) CheckSnapshot(snapshotFSPath string, bootSet *BootSet) *Stalen
 
 	kernelVersion := bootSet.KernelVersion()
 
+	// Path 0: Package database, when enabled and it names the kernel package
+	if c.usePackageDB && kernelVersion != "" {
+		if pkg := ReadPackageKernel(snapshotFSPath, bootSet.KernelName); pkg != nil {
+			if pkg.Matches(kernelVersion) {
+				log.Debug().
+					Str("kernel_name", bootSet.KernelName).
+					Str("version", kernelVersion).
+					Str("package_manager", pkg.Manager).
+					Strs("package_versions", pkg.Versions).
+					Msg("Snapshot kernel package version matches boot kernel (package database)")
+				return &StalenessResult{
+					IsStale:         false,
+					SnapshotModules: snapshotModules,
+					ExpectedVersion: kernelVersion,
+					Method:          MatchPackageDB,
+				}
+			}
+
+			result := &StalenessResult{
+				IsStale:         true,
+				Reason:          ReasonPackageMismatch,
+				SnapshotModules: snapshotModules,
+				ExpectedVersion: kernelVersion,
+				Method:          MatchPackageDB,
+				Warning: fmt.Sprintf("snapshot's %s database has kernel package %v but boot kernel is %s",
+					pkg.Manager, pkg.Versions, kernelVersion),
+			}
+			result.Action = c.resolveAction(result, bootSet)
+			return result
+		}
+	}
+
 	// Path 1: Binary header version available (best reliability)
 	if kernelVersion != "" {
 		for _, modVer := range snapshotModules {
@@ -237,3 +287,82 @@ func (c *Checker) resolveAction(result *StalenessResult, bootSet *BootSet) Stale
 
 	return action
 }
+
+// KernelStaleness counts, for one boot set, the ESP-mode plans booting its
+// kernel and how many of them are stale.
+type KernelStaleness struct {
+	Kernel string
+	Plans  int
+	Stale  int
+
+	// Fallback counts the stale plans booting the fallback initramfs.
+	Fallback int
+}
+
+// AllStale reports whether every plan for the kernel is stale.
+func (k KernelStaleness) AllStale() bool {
+	return k.Plans > 0 && k.Stale == k.Plans
+}
+
+// StalenessByKernel aggregates the staleness of plans per boot set kernel,
+// in the order the kernels first appear. Btrfs-mode plans and plans booting
+// an ESP copy are never stale, so only the ESP-mode plans booting the live
+// kernel are counted.
+func StalenessByKernel(plans []*BootPlan) []KernelStaleness {
+	var counts []KernelStaleness
+	index := make(map[string]int)
+	for _, plan := range plans {
+		if plan.Mode != BootModeESP || plan.BootSet == nil || plan.HasESPCopy() {
+			continue
+		}
+		i, ok := index[plan.BootSet.KernelName]
+		if !ok {
+			i = len(counts)
+			index[plan.BootSet.KernelName] = i
+			counts = append(counts, KernelStaleness{Kernel: plan.BootSet.KernelName})
+		}
+		counts[i].Plans++
+		if plan.IsStale() {
+			counts[i].Stale++
+			if plan.Staleness.FallbackUsed {
+				counts[i].Fallback++
+			}
+		}
+	}
+	return counts
+}
+
+// LogStaleness warns about the stale plans: one line for a kernel every
+// snapshot is stale for, typically just after a kernel upgrade, and one
+// line per stale snapshot otherwise.
+func LogStaleness(plans []*BootPlan) {
+	allStale := make(map[string]bool)
+	for _, k := range StalenessByKernel(plans) {
+		if !k.AllStale() {
+			continue
+		}
+		allStale[k.Kernel] = true
+		hint := "set kernel.stale_snapshot_action to fallback or enable behavior.copy_boot_to_esp"
+		if k.Fallback == k.Stale {
+			hint = "enable behavior.copy_boot_to_esp to boot snapshots with the kernel they were taken with"
+		}
+		log.Warn().
+			Str("kernel", k.Kernel).
+			Int("snapshots", k.Stale).
+			Str("hint", hint).
+			Msg("All snapshots are stale for boot kernel")
+	}
+
+	for _, plan := range plans {
+		if !plan.IsStale() || allStale[plan.BootSet.KernelName] {
+			continue
+		}
+		log.Warn().
+			Str("snapshot", plan.Snapshot.Path).
+			Str("kernel", plan.BootSet.KernelName).
+			Str("action", string(plan.Staleness.Action)).
+			Str("reason", string(plan.Staleness.Reason)).
+			Str("method", string(plan.Staleness.Method)).
+			Msg("Snapshot is stale for boot kernel")
+	}
+}
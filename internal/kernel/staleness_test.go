@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -162,6 +163,56 @@ func TestCheckSnapshot_Stale_EmptyModulesDir(t *testing.T) {
 	assert.Equal(t, ReasonNoModulesDir, result.Reason)
 }
 
+// --- Package database tests ---
+
+func TestCheckSnapshot_PackageDB_Match(t *testing.T) {
+	// A leftover module directory doesn't matter once the package database
+	// is consulted.
+	snapshotFS := makeSnapshotWithModules(t, []string{"6.9.6-arch1-1", "6.9.7-arch1-1"}, nil)
+	writePacmanPackage(t, snapshotFS, "linux", "6.9.7.arch1-1")
+	bootSet := makeBootSet("linux", "6.9.7-arch1-1", false)
+
+	checker := NewChecker(ActionWarn)
+	checker.SetUsePackageDB(true)
+	result := checker.CheckSnapshot(snapshotFS, bootSet)
+
+	assert.False(t, result.IsStale)
+	assert.Equal(t, MatchPackageDB, result.Method)
+}
+
+func TestCheckSnapshot_PackageDB_Mismatch(t *testing.T) {
+	snapshotFS := makeSnapshotWithModules(t, []string{"6.9.6-arch1-1", "6.9.7-arch1-1"}, nil)
+	writePacmanPackage(t, snapshotFS, "linux", "6.9.6.arch1-1")
+	bootSet := makeBootSet("linux", "6.9.7-arch1-1", false)
+
+	checker := NewChecker(ActionDisable)
+	checker.SetUsePackageDB(true)
+	result := checker.CheckSnapshot(snapshotFS, bootSet)
+
+	assert.True(t, result.IsStale)
+	assert.Equal(t, ReasonPackageMismatch, result.Reason)
+	assert.Equal(t, MatchPackageDB, result.Method)
+	assert.Equal(t, ActionDisable, result.Action)
+	assert.Contains(t, result.Warning, "6.9.6.arch1-1")
+
+	// Disabled: module directories decide.
+	result = NewChecker(ActionDisable).CheckSnapshot(snapshotFS, bootSet)
+	assert.False(t, result.IsStale)
+	assert.Equal(t, MatchBinaryHeader, result.Method)
+}
+
+func TestCheckSnapshot_PackageDB_FallsBackToModules(t *testing.T) {
+	snapshotFS := makeSnapshotWithModules(t, []string{"6.9.7-arch1-1"}, nil)
+	bootSet := makeBootSet("linux", "6.9.7-arch1-1", false)
+
+	checker := NewChecker(ActionWarn)
+	checker.SetUsePackageDB(true)
+	result := checker.CheckSnapshot(snapshotFS, bootSet)
+
+	assert.False(t, result.IsStale)
+	assert.Equal(t, MatchBinaryHeader, result.Method)
+}
+
 // --- ResolveAction tests ---
 
 func TestResolveAction_Warn(t *testing.T) {
@@ -296,3 +347,36 @@ func TestStalenessResult_StatusString(t *testing.T) {
 		})
 	}
 }
+
+func TestStalenessByKernel(t *testing.T) {
+	linux := makeBootSet("linux", "6.19.0-arch1-1", true)
+	lts := makeBootSet("linux-lts", "6.12.10-1-lts", false)
+	plan := func(path string, bs *BootSet, stale, fallback bool) *BootPlan {
+		return &BootPlan{
+			Snapshot:  &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{Path: path}},
+			Mode:      BootModeESP,
+			BootSet:   bs,
+			Staleness: &StalenessResult{IsStale: stale, FallbackUsed: fallback},
+		}
+	}
+	copied := plan("/.snapshots/3/snapshot", linux, true, false)
+	copied.ESPKernel = "EFI/refind-btrfs-snapshots/3/vmlinuz-linux"
+
+	plans := []*BootPlan{
+		plan("/.snapshots/1/snapshot", linux, true, true),
+		plan("/.snapshots/1/snapshot", lts, false, false),
+		plan("/.snapshots/2/snapshot", linux, true, true),
+		plan("/.snapshots/2/snapshot", lts, true, false),
+		copied,
+		{Snapshot: &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{Path: "/.snapshots/4/snapshot"}}, Mode: BootModeBtrfs},
+	}
+
+	counts := StalenessByKernel(plans)
+	assert.Equal(t, []KernelStaleness{
+		{Kernel: "linux", Plans: 2, Stale: 2, Fallback: 2},
+		{Kernel: "linux-lts", Plans: 2, Stale: 1},
+	}, counts)
+	assert.True(t, counts[0].AllStale())
+	assert.False(t, counts[1].AllStale())
+	assert.False(t, KernelStaleness{Kernel: "linux"}.AllStale(), "no plans is not all stale")
+}
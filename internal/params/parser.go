@@ -10,6 +10,12 @@ import (
 // whitespaceRun matches one or more whitespace characters, used for collapsing runs.
 var whitespaceRun = regexp.MustCompile(`\s+`)
 
+// rwOption and roOption match a standalone rw or ro kernel option.
+var (
+	rwOption = regexp.MustCompile(`(^|\s)rw(\s|$)`)
+	roOption = regexp.MustCompile(`(^|\s)ro(\s|$)`)
+)
+
 // regexCache caches compiled regexps keyed by pattern string.
 var regexCache sync.Map // map[string]*regexp.Regexp
 
@@ -134,9 +140,91 @@ func (p *BootOptionsParser) ExtractRootFlags(options string) string {
 	return p.SpaceParser.Extract(options, "rootflags")
 }
 
-// ExtractSubvol extracts the subvol parameter from rootflags
+// ExtractSubvol extracts the subvol parameter from rootflags, normalized
+// with NormalizeSubvol
 func (p *BootOptionsParser) ExtractSubvol(rootflags string) string {
-	return p.CommaParser.Extract(rootflags, "subvol")
+	return NormalizeSubvol(p.CommaParser.Extract(rootflags, "subvol"))
+}
+
+// ReadOnlyOptions makes kernel options mount the root read-only: a
+// standalone rw becomes ro, and ro is appended when neither is present.
+func ReadOnlyOptions(options string) string {
+	return rootModeOptions(options, rwOption, "ro")
+}
+
+// WritableOptions makes kernel options mount the root read-write: a
+// standalone ro becomes rw, and rw is appended when neither is present.
+func WritableOptions(options string) string {
+	return rootModeOptions(options, roOption, "rw")
+}
+
+// rootModeOptions replaces each standalone option matched by other with
+// mode, or appends mode when neither is present.
+func rootModeOptions(options string, other *regexp.Regexp, mode string) string {
+	if other.MatchString(options) {
+		// Adjacent matches share a space, so repeat until none is left.
+		for other.MatchString(options) {
+			options = other.ReplaceAllString(options, "${1}"+mode+"${2}")
+		}
+		return options
+	}
+	for _, field := range strings.Fields(options) {
+		if field == mode {
+			return options
+		}
+	}
+	return strings.TrimSpace(options + " " + mode)
+}
+
+// MergeOptions applies the space-separated kernel options in extra over
+// options: a key=value option replaces every option with the same key,
+// where the first of them was, and any other option is appended unless
+// already present.
+func MergeOptions(options, extra string) string {
+	fields := strings.Fields(options)
+	for _, opt := range strings.Fields(extra) {
+		key, _, hasValue := strings.Cut(opt, "=")
+		placed := false
+		var merged []string
+		for _, field := range fields {
+			if hasValue && strings.HasPrefix(field, key+"=") {
+				if !placed {
+					merged = append(merged, opt)
+					placed = true
+				}
+				continue
+			}
+			if field == opt {
+				placed = true
+			}
+			merged = append(merged, field)
+		}
+		if !placed {
+			merged = append(merged, opt)
+		}
+		fields = merged
+	}
+	return strings.Join(fields, " ")
+}
+
+// NormalizeSubvol collapses repeated slashes in a subvol path and strips
+// trailing ones, so "@/", "@//" and "/@/" read as "@" and "/@". A leading
+// slash is kept since it records the user's /@ format.
+func NormalizeSubvol(subvol string) string {
+	if subvol == "" {
+		return ""
+	}
+	var parts []string
+	for part := range strings.SplitSeq(subvol, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	normalized := strings.Join(parts, "/")
+	if strings.HasPrefix(subvol, "/") {
+		normalized = "/" + normalized
+	}
+	return normalized
 }
 
 // ExtractSubvolID extracts the subvolid parameter from rootflags
@@ -285,6 +285,26 @@ func TestBootOptionsParser_ExtractSubvol(t *testing.T) {
 			rootflags: "compress=zstd,space_cache=v2",
 			expected:  "",
 		},
+		{
+			name:      "trailing_slash",
+			rootflags: "subvol=@/",
+			expected:  "@",
+		},
+		{
+			name:      "slash_at_trailing_slash",
+			rootflags: "subvol=/@/,compress=zstd",
+			expected:  "/@",
+		},
+		{
+			name:      "double_slashes",
+			rootflags: "subvol=@//",
+			expected:  "@",
+		},
+		{
+			name:      "double_slashes_inside_path",
+			rootflags: "subvol=//@//.snapshots/1/snapshot/",
+			expected:  "/@/.snapshots/1/snapshot",
+		},
 	}
 
 	for _, tt := range tests {
@@ -295,6 +315,70 @@ func TestBootOptionsParser_ExtractSubvol(t *testing.T) {
 	}
 }
 
+func TestReadOnlyOptions(t *testing.T) {
+	tests := []struct {
+		options  string
+		expected string
+	}{
+		{"root=UUID=x rw quiet", "root=UUID=x ro quiet"},
+		{"rw", "ro"},
+		{"root=UUID=x quiet rw", "root=UUID=x quiet ro"},
+		{"root=UUID=x ro quiet", "root=UUID=x ro quiet"},
+		{"root=UUID=x quiet", "root=UUID=x quiet ro"},
+		{"root=UUID=x rootflags=rw,subvol=@ quiet", "root=UUID=x rootflags=rw,subvol=@ quiet ro"},
+		{"rw rw quiet", "ro ro quiet"},
+		{"", "ro"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ReadOnlyOptions(tt.options))
+		})
+	}
+}
+
+func TestWritableOptions(t *testing.T) {
+	tests := []struct {
+		options  string
+		expected string
+	}{
+		{"root=UUID=x ro quiet", "root=UUID=x rw quiet"},
+		{"root=UUID=x rw quiet", "root=UUID=x rw quiet"},
+		{"root=UUID=x quiet", "root=UUID=x quiet rw"},
+		{"root=UUID=x rootflags=ro,subvol=@ quiet", "root=UUID=x rootflags=ro,subvol=@ quiet rw"},
+		{"ro ro quiet", "rw rw quiet"},
+		{"", "rw"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options, func(t *testing.T) {
+			assert.Equal(t, tt.expected, WritableOptions(tt.options))
+		})
+	}
+}
+
+func TestMergeOptions(t *testing.T) {
+	tests := []struct {
+		options  string
+		extra    string
+		expected string
+	}{
+		{"root=UUID=x rw quiet", "debug", "root=UUID=x rw quiet debug"},
+		{"root=UUID=x rw quiet", "quiet", "root=UUID=x rw quiet"},
+		{"root=UUID=x loglevel=3 rw", "loglevel=7", "root=UUID=x loglevel=7 rw"},
+		{"loglevel=3 rw loglevel=4", "loglevel=7 debug", "loglevel=7 rw debug"},
+		{"root=UUID=x rootflags=subvol=@", "root=UUID=y", "root=UUID=y rootflags=subvol=@"},
+		{"", "debug", "debug"},
+		{"root=UUID=x  rw", "", "root=UUID=x rw"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options+"+"+tt.extra, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MergeOptions(tt.options, tt.extra))
+		})
+	}
+}
+
 func TestBootOptionsParser_ExtractSubvolID(t *testing.T) {
 	parser := NewBootOptionsParser()
 
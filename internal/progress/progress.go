@@ -0,0 +1,102 @@
+// Package progress emits machine-readable progress events, one JSON object
+// per line, for frontends that draw their own progress display instead of
+// parsing the log or the terminal spinner (--progress json).
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypePhaseStart  = "phase_start"
+	TypePhaseEnd    = "phase_end"
+	TypeStep        = "step"
+	TypeFileWritten = "file_written"
+)
+
+// Phase end statuses.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Event is a single progress event. Done and Total count the items of a
+// step's phase, such as snapshots planned or sized; Path names the item or
+// the file written. Status is set on phase_end, with Error when the phase
+// failed.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Phase  string    `json:"phase,omitempty"`
+	Done   int       `json:"done,omitempty"`
+	Total  int       `json:"total,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+var (
+	mu  sync.Mutex
+	out io.Writer
+)
+
+// SetOutput sets where events are written. nil, the default, disables them.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Enabled reports whether events are being written.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return out != nil
+}
+
+// PhaseStart reports that phase has started.
+func PhaseStart(phase string) {
+	emit(Event{Type: TypePhaseStart, Phase: phase})
+}
+
+// PhaseEnd reports that phase has finished, with StatusError and err's
+// message if err is non-nil, so a frontend sees every phase it was told
+// about end.
+func PhaseEnd(phase string, err error) {
+	e := Event{Type: TypePhaseEnd, Phase: phase, Status: StatusOK}
+	if err != nil {
+		e.Status, e.Error = StatusError, err.Error()
+	}
+	emit(e)
+}
+
+// Step reports that done of total items of phase are finished, the latest
+// being path.
+func Step(phase string, done, total int, path string) {
+	emit(Event{Type: TypeStep, Phase: phase, Done: done, Total: total, Path: path})
+}
+
+// FileWritten reports that path was written.
+func FileWritten(path string) {
+	emit(Event{Type: TypeFileWritten, Path: path})
+}
+
+// emit writes e as one line. Events are best effort: a write error never
+// fails the operation being reported.
+func emit(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	if out == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	_, _ = out.Write(append(line, '\n'))
+}
@@ -0,0 +1,48 @@
+package progress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	t.Cleanup(func() { SetOutput(nil) })
+
+	PhaseStart("discover")
+	Step("plan", 1, 3, "/.snapshots/1/snapshot")
+	FileWritten("/boot/efi/EFI/refind/refind.conf")
+	PhaseEnd("discover", nil)
+	PhaseStart("apply")
+	PhaseEnd("apply", errors.New("failed to apply changes"))
+
+	var events []Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e), "each line is one JSON event")
+		assert.False(t, e.Time.IsZero())
+		events = append(events, Event{Type: e.Type, Phase: e.Phase, Done: e.Done, Total: e.Total, Path: e.Path, Status: e.Status, Error: e.Error})
+	}
+	assert.Equal(t, []Event{
+		{Type: TypePhaseStart, Phase: "discover"},
+		{Type: TypeStep, Phase: "plan", Done: 1, Total: 3, Path: "/.snapshots/1/snapshot"},
+		{Type: TypeFileWritten, Path: "/boot/efi/EFI/refind/refind.conf"},
+		{Type: TypePhaseEnd, Phase: "discover", Status: StatusOK},
+		{Type: TypePhaseStart, Phase: "apply"},
+		{Type: TypePhaseEnd, Phase: "apply", Status: StatusError, Error: "failed to apply changes"},
+	}, events)
+}
+
+func TestEvents_Disabled(t *testing.T) {
+	SetOutput(nil)
+	assert.False(t, Enabled())
+	PhaseStart("discover") // must not panic
+}
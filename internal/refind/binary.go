@@ -0,0 +1,56 @@
+package refind
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// efiArchSuffixes maps GOARCH values to the suffix UEFI uses in binary
+// names, e.g. refind_x64.efi and BOOTX64.EFI.
+var efiArchSuffixes = map[string]string{
+	"amd64":   "x64",
+	"386":     "ia32",
+	"arm64":   "aa64",
+	"arm":     "arm",
+	"riscv64": "riscv64",
+	"loong64": "loongarch64",
+}
+
+// FindBinary returns the path of the rEFInd EFI binary for goarch in dir,
+// the directory holding refind.conf: refind_<arch>.efi, or boot<arch>.efi
+// when rEFInd is installed as the fallback boot loader. Names are matched
+// case-insensitively, as on FAT. Any refind_*.efi is accepted for an
+// architecture without a known suffix.
+func FindBinary(dir, goarch string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	suffix, known := efiArchSuffixes[goarch]
+	var wanted []string
+	if known {
+		wanted = []string{"refind_" + suffix + ".efi", "boot" + suffix + ".efi"}
+	}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if entry.IsDir() {
+			continue
+		}
+		for _, w := range wanted {
+			if name == w {
+				return filepath.Join(dir, entry.Name()), nil
+			}
+		}
+		if !known && strings.HasPrefix(name, "refind_") && strings.HasSuffix(name, ".efi") {
+			return filepath.Join(dir, entry.Name()), nil
+		}
+	}
+
+	if known {
+		return "", fmt.Errorf("no %s in %s", strings.Join(wanted, " or "), dir)
+	}
+	return "", fmt.Errorf("no refind_*.efi in %s", dir)
+}
@@ -0,0 +1,98 @@
+package refind
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+)
+
+// ageBucketNames are the age buckets snapshots are grouped into with
+// SetBucketByAge, newest first. Each bucket takes the snapshots the ones
+// before it don't.
+var ageBucketNames = []string{"Today", "This Week", "This Month", "Older"}
+
+// SetBucketByAge groups each managed entry's snapshot submenus by the
+// snapshot's age: the entry keeps its own attributes but no submenus, and
+// is followed by a copy of it per non-empty bucket, titled "<title>
+// [<bucket>]", holding that bucket's submenus. rEFInd can't nest submenus,
+// so the buckets are main menu entries, written between
+// ##refind-btrfs-snapshots-buckets markers. Weeks start on Monday; days
+// follow display.local_time.
+func (g *Generator) SetBucketByAge(enabled bool) {
+	g.bucketByAge = enabled
+}
+
+// generateAgeBuckets returns the bucket menuentries for snapshots, in
+// submenu order, under the source entry title, or "" when there are no
+// snapshots.
+func (g *Generator) generateAgeBuckets(title string, templateEntry *MenuEntry, preserved map[string]string, snapshots []*btrfs.Snapshot, entryFS *btrfs.Filesystem) string {
+	if len(snapshots) == 0 {
+		return ""
+	}
+
+	buckets := make([][]*btrfs.Snapshot, len(ageBucketNames))
+	now := g.clock()
+	for _, snapshot := range snapshots {
+		i := g.ageBucket(snapshot.SnapshotTime, now)
+		buckets[i] = append(buckets[i], snapshot)
+	}
+
+	var content strings.Builder
+	content.WriteString("\n")
+	content.WriteString("##refind-btrfs-snapshots-buckets-start\n")
+	for i, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		g.writeMenuEntryHeader(&content, fmt.Sprintf("%s [%s]", title, ageBucketNames[i]), templateEntry)
+		g.writeSnapshotSubmenus(&content, title, templateEntry, preserved, bucket, entryFS)
+		content.WriteString("}\n")
+	}
+	content.WriteString("##refind-btrfs-snapshots-buckets-end\n")
+	return content.String()
+}
+
+// ageBucket returns the index in ageBucketNames of the bucket a snapshot
+// taken at t falls in at now.
+func (g *Generator) ageBucket(t, now time.Time) int {
+	loc := time.UTC
+	if g.useLocalTime {
+		loc = time.Local
+	}
+	t, now = t.In(loc), now.In(loc)
+
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	weekStart := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+
+	switch {
+	case !t.Before(today):
+		return 0
+	case !t.Before(weekStart):
+		return 1
+	case !t.Before(monthStart):
+		return 2
+	default:
+		return 3
+	}
+}
+
+// clock returns the current time, or the time set by tests.
+func (g *Generator) clock() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// bucketBaseTitle returns the source entry title of a bucket menuentry
+// titled "<title> [<bucket>]".
+func bucketBaseTitle(title string) string {
+	if i := strings.LastIndex(title, " ["); i >= 0 {
+		return title[:i]
+	}
+	return title
+}
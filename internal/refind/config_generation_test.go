@@ -1,8 +1,10 @@
 package refind
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -223,6 +225,49 @@ func TestGenerateMenuTitle(t *testing.T) {
 	}
 }
 
+func TestKernelTitles(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetKernelTitles(map[string]string{
+		"vmlinuz-linux-custom":   "Custom Kernel",
+		"VMLINUZ-6.1.0-13-AMD64": "Debian 12",
+	})
+
+	assert.Equal(t, "Custom Kernel", generator.generateMenuTitle("vmlinuz-linux-custom", &MenuEntry{Loader: "/@/boot/vmlinuz-linux-custom"}))
+	assert.Equal(t, "Custom Kernel", generator.generateMenuTitle("vmlinuz-linux-custom", &MenuEntry{Loader: `\boot\vmlinuz-linux-custom.efi`}), "matched without the extension")
+	assert.Equal(t, "Debian 12", generator.generateMenuTitle("vmlinuz-6.1.0-13-amd64", &MenuEntry{Loader: "/vmlinuz-6.1.0-13-amd64"}), "matched case-insensitively")
+	assert.Equal(t, "Arch Linux", generator.generateMenuTitle("vmlinuz-linux", &MenuEntry{Loader: "/boot/vmlinuz-linux"}), "built-in names still apply")
+
+	generator.bootSets = []*kernel.BootSet{{
+		KernelName: "linux-custom",
+		Kernel:     &kernel.BootImage{Path: "/vmlinuz-linux-custom"},
+	}}
+	content := generator.generateTemplateEntry(nil, nil, nil)
+	assert.Contains(t, content, `menuentry "Custom Kernel" {`)
+}
+
+func TestGenerateTemplateEntry_EntryVolume(t *testing.T) {
+	rootFS := &btrfs.Filesystem{UUID: "root-uuid", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}}
+	dataFS := &btrfs.Filesystem{UUID: "data-uuid", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}}
+	rootSnapshot := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 300, Path: "@/.snapshots/1/snapshot"}}
+	dataSnapshot := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 400, Path: "@/.snapshots/9/snapshot"}}
+
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetVolumes([]VolumeSnapshots{
+		{FS: rootFS, Snapshots: []*btrfs.Snapshot{rootSnapshot}},
+		{FS: dataFS, Snapshots: []*btrfs.Snapshot{dataSnapshot}},
+	})
+	require.NoError(t, generator.SetOptionsTemplate("root=UUID={{.RootUUID}} rootflags=subvolid={{.SubvolID}} rw"))
+
+	entry := &MenuEntry{
+		Title:       "Data volume",
+		Options:     "rw rootflags=subvol=@ root=UUID=data-uuid",
+		BootOptions: &BootOptions{Root: "UUID=data-uuid", Subvol: "@"},
+	}
+	content := generator.generateTemplateEntry([]*MenuEntry{entry}, []*btrfs.Snapshot{rootSnapshot}, rootFS)
+	assert.Contains(t, content, "options root=UUID=data-uuid rootflags=subvolid=400 rw", "submenus boot the entry's own volume and its snapshots")
+	assert.NotContains(t, content, "root-uuid")
+}
+
 func TestExtractBaseName(t *testing.T) {
 	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
 
@@ -286,6 +331,40 @@ func TestGroupEntriesByBase(t *testing.T) {
 	assert.Len(t, groups["Custom Entry"], 1)
 }
 
+func TestGroupEntriesByBase_MissingLoaderGroupsByRoot(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+
+	// Options before loader is fine; a missing loader must not fall back to
+	// the title, which would split entries booting the same system.
+	path := filepath.Join(t.TempDir(), "refind.conf")
+	require.NoError(t, os.WriteFile(path, []byte(`menuentry "Arch Linux" {
+    options "root=UUID=test-uuid rootflags=subvol=@ rw"
+    loader /boot/vmlinuz-linux
+}
+menuentry "Arch Linux (no loader)" {
+    options "root=UUID=test-uuid rootflags=subvol=/@ rw"
+}
+menuentry "Arch Linux (no loader, fallback)" {
+    options "root=UUID=test-uuid rootflags=subvol=@ rw init=/bin/sh"
+}
+menuentry "Other" {
+    options "root=UUID=other-uuid rootflags=subvol=@ rw"
+}
+`), 0644))
+
+	entries, _, _, err := generator.parser.parseConfigFile(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "/boot/vmlinuz-linux", entries[0].Loader)
+	assert.Equal(t, "@", entries[0].BootOptions.Subvol)
+
+	groups := generator.groupEntriesByBase(entries)
+
+	assert.Len(t, groups["vmlinuz-linux"], 1)
+	assert.Len(t, groups["root:UUID=test-uuid:@"], 2)
+	assert.Len(t, groups["root:UUID=other-uuid:@"], 1)
+}
+
 func TestParseExistingManagedConfig(t *testing.T) {
 	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
 
@@ -344,12 +423,13 @@ func TestMergeCustomizations(t *testing.T) {
 	}
 
 	existing := &MenuEntry{
-		Title:   "Custom Title",
-		Icon:    "/custom/icon.png",
-		Volume:  "LABEL=CUSTOM",
-		Loader:  "/custom/loader",
-		Initrd:  []string{"/custom/initrd"},
-		Options: "custom-options",
+		Title:    "Custom Title",
+		Icon:     "/custom/icon.png",
+		Volume:   "LABEL=CUSTOM",
+		Loader:   "/custom/loader",
+		Initrd:   []string{"/custom/initrd"},
+		Options:  "custom-options",
+		Disabled: true,
 	}
 
 	merged := generator.mergeCustomizations(template, existing)
@@ -361,6 +441,7 @@ func TestMergeCustomizations(t *testing.T) {
 	assert.Equal(t, "/custom/loader", merged.Loader)
 	assert.Equal(t, []string{"/custom/initrd"}, merged.Initrd)
 	assert.Equal(t, "custom-options", merged.Options)
+	assert.True(t, merged.Disabled, "a user-disabled entry stays disabled")
 
 	// Should have empty submenues (they get regenerated)
 	assert.Empty(t, merged.Submenues)
@@ -392,7 +473,7 @@ func TestGenerateSingleMenuEntry(t *testing.T) {
 		UUID: "test-uuid",
 	}
 
-	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, snapshots, rootFS)
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, snapshots, rootFS)
 
 	// Should contain menuentry
 	assert.Contains(t, content, "menuentry \"Arch Linux\" {")
@@ -446,6 +527,43 @@ menuentry "Arch Linux" {
 	assert.Equal(t, "quiet zswap.enabled=0 rw rootflags=subvol=@ root=UUID=test-uuid custom_param=1", archEntry.Options)
 }
 
+func TestGenerateFromExistingEntries_PreservesDisabledState(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+
+	existingContent := `menuentry "Arch Linux" {
+    disabled
+    loader /boot/vmlinuz-linux
+    options "root=UUID=test-uuid rootflags=subvol=@ rw"
+    submenuentry "Arch Linux (2025-01-01T00:00:00Z)" {
+        disabled
+        options "root=UUID=test-uuid rootflags=subvol=@/.snapshots/1/snapshot rw"
+    }
+    submenuentry "Arch Linux (2025-02-02T00:00:00Z)" {
+        options "root=UUID=test-uuid rootflags=subvol=@/.snapshots/2/snapshot rw"
+    }
+}`
+
+	existingEntries := generator.parseExistingManagedConfig(existingContent)
+	require.Len(t, existingEntries, 1)
+	arch := existingEntries["Arch Linux"]
+	assert.True(t, arch.Disabled)
+	require.Len(t, arch.Submenues, 2)
+	assert.True(t, arch.Submenues[0].Disabled)
+	assert.False(t, arch.Submenues[1].Disabled)
+
+	snapshots := []*btrfs.Snapshot{
+		{Subvolume: &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/1/snapshot"}, SnapshotTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Subvolume: &btrfs.Subvolume{ID: 102, Path: "@/.snapshots/2/snapshot"}, SnapshotTime: time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{Subvolume: &btrfs.Subvolume{ID: 103, Path: "@/.snapshots/3/snapshot"}, SnapshotTime: time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	content := generator.generateFromExistingEntries(existingEntries, nil, snapshots, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.Contains(t, content, "menuentry \"Arch Linux\" {\n    disabled\n")
+	assert.Contains(t, content, "    submenuentry \"Arch Linux (2025-01-01T00:00:00Z)\" {\n        disabled\n")
+	assert.Contains(t, content, "    submenuentry \"Arch Linux (2025-02-02T00:00:00Z)\" {\n        options")
+	assert.Contains(t, content, "    submenuentry \"Arch Linux (2025-03-03T00:00:00Z)\" {\n        options", "new snapshots are enabled")
+}
+
 func TestUpdateOptionsForSnapshot_AvoidDoubleAt(t *testing.T) {
 	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
 
@@ -466,16 +584,133 @@ func TestUpdateOptionsForSnapshot_AvoidDoubleAt(t *testing.T) {
 	originalOptions := "quiet rw rootflags=subvol=@ root=UUID=test-uuid"
 
 	// Test normal case: path without @
-	result1 := generator.updateOptionsForSnapshot(originalOptions, snapshot)
+	result1 := generator.updateOptionsForSnapshot(originalOptions, snapshot, nil)
 	assert.Contains(t, result1, "rootflags=subvol=@/.snapshots/101/snapshot")
 	assert.NotContains(t, result1, "@@") // Should not have double @
 
 	// Test case where path already has @: should not get double @
-	result2 := generator.updateOptionsForSnapshot(originalOptions, snapshotWithAt)
+	result2 := generator.updateOptionsForSnapshot(originalOptions, snapshotWithAt, nil)
 	assert.Contains(t, result2, "rootflags=subvol=@/.snapshots/102/snapshot")
 	assert.NotContains(t, result2, "@@") // Should not have double @
 }
 
+func TestUpdateOptionsForSnapshot_Timeshift(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	// As FindSnapshots reports a Timeshift snapshot: its path is from the
+	// top level, where Timeshift keeps timeshift-btrfs beside @.
+	snapshot := &btrfs.Snapshot{
+		Subvolume:      &btrfs.Subvolume{ID: 300, Path: "timeshift-btrfs/snapshots/2025-06-14_10-00-01/@"},
+		OriginalPath:   "@",
+		FilesystemPath: "/run/timeshift/backup/timeshift-btrfs/snapshots/2025-06-14_10-00-01/@",
+		Timeshift:      true,
+	}
+	fs := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}}
+
+	got := generator.updateOptionsForSnapshot("quiet rw rootflags=subvol=@ root=UUID=test-uuid", snapshot, fs)
+	assert.Equal(t, "quiet rw rootflags=subvol=timeshift-btrfs/snapshots/2025-06-14_10-00-01/@,subvolid=300 root=UUID=test-uuid", got)
+
+	got = generator.updateOptionsForSnapshot("quiet rw rootflags=subvol=/@ root=UUID=test-uuid", snapshot, fs)
+	assert.Contains(t, got, "rootflags=subvol=/timeshift-btrfs/snapshots/2025-06-14_10-00-01/@,subvolid=300")
+}
+
+func TestUpdateOptionsForSnapshot_SubvolSlashes(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	snapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/101/snapshot"},
+	}
+
+	tests := []struct {
+		subvol string
+		want   string
+	}{
+		{"@/", "rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101"},
+		{"/@/", "rootflags=subvol=/@/.snapshots/101/snapshot,subvolid=101"},
+		{"@//", "rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101"},
+		{"//@", "rootflags=subvol=/@/.snapshots/101/snapshot,subvolid=101"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subvol, func(t *testing.T) {
+			result := generator.updateOptionsForSnapshot("quiet rw rootflags=subvol="+tt.subvol+" root=UUID=test-uuid", snapshot, nil)
+			assert.Contains(t, result, tt.want)
+		})
+	}
+}
+
+func TestUpdateOptionsForSnapshot_BootReadOnly(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetBootReadOnly(true)
+	snapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/101/snapshot"},
+	}
+
+	result := generator.updateOptionsForSnapshot("quiet rw rootflags=subvol=@ root=UUID=test-uuid", snapshot, nil)
+	assert.Equal(t, "quiet ro rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101 root=UUID=test-uuid", result)
+}
+
+func TestUpdateOptionsForSnapshot_MatchWritability(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetMatchWritability(true)
+	readOnly := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/101/snapshot", IsReadOnly: true},
+	}
+	writable := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 102, Path: "@/.snapshots/102/snapshot"},
+	}
+
+	assert.Equal(t, "quiet ro rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101 root=UUID=test-uuid",
+		generator.updateOptionsForSnapshot("quiet rw rootflags=subvol=@ root=UUID=test-uuid", readOnly, nil))
+	assert.Equal(t, "quiet rw rootflags=subvol=@/.snapshots/102/snapshot,subvolid=102 root=UUID=test-uuid",
+		generator.updateOptionsForSnapshot("quiet ro rootflags=subvol=@ root=UUID=test-uuid", writable, nil))
+	assert.Equal(t, "quiet rootflags=subvol=@/.snapshots/102/snapshot,subvolid=102 root=UUID=test-uuid rw",
+		generator.updateOptionsForSnapshot("quiet rootflags=subvol=@ root=UUID=test-uuid", writable, nil), "rw is added when the source sets neither")
+
+	generator.SetBootReadOnly(true)
+	assert.Equal(t, "quiet ro rootflags=subvol=@/.snapshots/102/snapshot,subvolid=102 root=UUID=test-uuid",
+		generator.updateOptionsForSnapshot("quiet rw rootflags=subvol=@ root=UUID=test-uuid", writable, nil), "boot_readonly wins")
+}
+
+func TestUpdateOptionsForSnapshot_SnapshotOptions(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	require.NoError(t, generator.SetSnapshotOptions([]SnapshotOptions{
+		{SubvolID: 101, Options: "debug loglevel=7"},
+		{Description: "^pre-upgrade", Options: "systemd.unit=rescue.target"},
+	}))
+	original := "quiet loglevel=3 rw rootflags=subvol=@ root=UUID=test-uuid"
+
+	debugSnapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/101/snapshot"},
+	}
+	assert.Equal(t, "quiet loglevel=7 rw rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101 root=UUID=test-uuid debug",
+		generator.updateOptionsForSnapshot(original, debugSnapshot, nil))
+
+	upgradeSnapshot := &btrfs.Snapshot{
+		Subvolume:   &btrfs.Subvolume{ID: 102, Path: "@/.snapshots/102/snapshot"},
+		Description: "pre-upgrade linux 6.9",
+	}
+	assert.Equal(t, "quiet loglevel=3 rw rootflags=subvol=@/.snapshots/102/snapshot,subvolid=102 root=UUID=test-uuid systemd.unit=rescue.target",
+		generator.updateOptionsForSnapshot(original, upgradeSnapshot, nil))
+
+	otherSnapshot := &btrfs.Snapshot{
+		Subvolume:   &btrfs.Subvolume{ID: 103, Path: "@/.snapshots/103/snapshot"},
+		Description: "timeline",
+	}
+	assert.Equal(t, "quiet loglevel=3 rw rootflags=subvol=@/.snapshots/103/snapshot,subvolid=103 root=UUID=test-uuid",
+		generator.updateOptionsForSnapshot(original, otherSnapshot, nil))
+
+	assert.Error(t, generator.SetSnapshotOptions([]SnapshotOptions{{Description: "[debug", Options: "debug"}}))
+}
+
+func TestParseBootOptions_SubvolTrailingSlashMatchesRoot(t *testing.T) {
+	entry := &MenuEntry{
+		Title:       "Arch Linux",
+		BootOptions: parseBootOptions("root=UUID=test-uuid rootflags=subvol=/@/ rw"),
+	}
+	assert.Equal(t, "/@", entry.BootOptions.Subvol)
+
+	rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{ID: 256, Path: "@"}}
+	assert.True(t, IsBootable(entry, rootFS))
+}
+
 func TestParseConfig_MultipleInitrdDirectives(t *testing.T) {
 	// Create a temporary directory for test files
 	tmpDir := t.TempDir()
@@ -547,7 +782,7 @@ func TestGenerateSingleMenuEntry_MultipleInitrdDirectives(t *testing.T) {
 		UUID: "test-uuid",
 	}
 
-	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, snapshots, rootFS)
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, snapshots, rootFS)
 
 	// Should contain menuentry
 	assert.Contains(t, content, "menuentry \"Arch Linux\" {")
@@ -578,6 +813,73 @@ func TestGenerateSingleMenuEntry_MultipleInitrdDirectives(t *testing.T) {
 	assert.Contains(t, content, "}")
 }
 
+// TestGenerateSingleMenuEntry_DropsUnknownDirectives guards against generated
+// entries claiming anything else a live entry sets, such as a hotkey: only
+// the directives the generator knows are written.
+func TestGenerateSingleMenuEntry_DropsUnknownDirectives(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "refind.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte(`menuentry "Arch Linux" {
+    hotkey a
+    ostype Linux
+    loader /boot/vmlinuz-linux
+    initrd /boot/initramfs-linux.img
+    options "root=UUID=test-uuid rootflags=subvol=@ rw quiet"
+}
+`), 0644))
+	config, err := NewParser(tmpDir).ParseConfig(configPath)
+	require.NoError(t, err)
+	require.Len(t, config.Entries, 1)
+
+	snapshots := []*btrfs.Snapshot{{
+		Subvolume:    &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"},
+		SnapshotTime: time.Date(2025, 6, 12, 7, 0, 18, 0, time.UTC),
+	}}
+	content := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false).
+		generateSingleMenuEntry("Arch Linux", config.Entries[0], nil, snapshots, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.NotContains(t, content, "hotkey")
+	assert.NotContains(t, content, "ostype")
+	assert.Contains(t, content, "    loader /boot/vmlinuz-linux")
+}
+
+// TestGenerateManagedConfigDiff_KeepsAllowedDirectives checks that graphics
+// and ostype lines added to a managed config menuentry survive a
+// regeneration, and are repeated in age bucket copies, while other
+// directives are dropped.
+func TestGenerateManagedConfigDiff_KeepsAllowedDirectives(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte(`menuentry "Arch Linux" {
+    loader /boot/vmlinuz-linux
+    initrd /boot/initramfs-linux.img
+    options "root=UUID=test-uuid rootflags=subvol=@ rw quiet"
+    ostype Linux
+    graphics on
+    hotkey a
+    firmware_bootnum 0001
+}
+`), 0644))
+	snapshots := []*btrfs.Snapshot{{
+		Subvolume:    &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"},
+		SnapshotTime: time.Date(2025, 6, 12, 7, 0, 18, 0, time.UTC),
+	}}
+	fs := &btrfs.Filesystem{UUID: "test-uuid"}
+
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	fileDiff, err := generator.GenerateManagedConfigDiff(nil, snapshots, fs, configPath)
+	require.NoError(t, err)
+	require.NotNil(t, fileDiff)
+	assert.Contains(t, fileDiff.Modified, "    options \"root=UUID=test-uuid rootflags=subvol=@ rw quiet\"\n    ostype Linux\n    graphics on\n    submenuentry")
+	assert.NotContains(t, fileDiff.Modified, "hotkey")
+	assert.NotContains(t, fileDiff.Modified, "firmware_bootnum")
+
+	generator.SetBucketByAge(true)
+	fileDiff, err = generator.GenerateManagedConfigDiff(nil, snapshots, fs, configPath)
+	require.NoError(t, err)
+	require.NotNil(t, fileDiff)
+	assert.Equal(t, 2, strings.Count(fileDiff.Modified, "graphics on"), "the bucket entry repeats kept directives:\n%s", fileDiff.Modified)
+}
+
 // --- Boot Plan / Boot Mode tests for generated output ---
 
 // TestGenerateSingleMenuEntry_BtrfsMode verifies that btrfs-mode snapshots
@@ -617,7 +919,7 @@ func TestGenerateSingleMenuEntry_BtrfsMode(t *testing.T) {
 	}
 
 	rootFS := &btrfs.Filesystem{UUID: "test-uuid"}
-	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, []*btrfs.Snapshot{snapshot}, rootFS)
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{snapshot}, rootFS)
 
 	// Main entry should have ESP-relative paths (the default boot entry)
 	assert.Contains(t, content, "menuentry \"Arch Linux\" {")
@@ -635,6 +937,74 @@ func TestGenerateSingleMenuEntry_BtrfsMode(t *testing.T) {
 	assert.Contains(t, content, "subvolid=256")
 }
 
+func TestGenerateSingleMenuEntry_BtrfsModeInitrdOptions(t *testing.T) {
+	snapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{
+			ID:   256,
+			Path: "@/.snapshots/73/snapshot",
+		},
+		FilesystemPath: "/mnt/@/.snapshots/73/snapshot",
+		SnapshotTime:   time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC),
+	}
+
+	bootPlans := []*kernel.BootPlan{
+		{
+			Snapshot:       snapshot,
+			Mode:           kernel.BootModeBtrfs,
+			SnapshotKernel: "/@/.snapshots/73/snapshot/boot/vmlinuz-linux",
+			SnapshotInitrds: []string{
+				"/@/.snapshots/73/snapshot/boot/intel-ucode.img",
+				"/@/.snapshots/73/snapshot/boot/initramfs-linux.img",
+			},
+			BtrfsVolume: "ARCH_ROOT",
+		},
+	}
+
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, bootPlans)
+
+	templateEntry := &MenuEntry{
+		Loader:  `\boot\vmlinuz-linux`,
+		Options: `quiet rw initrd=\boot\intel-ucode.img initrd=\boot\initramfs-linux.img rootflags=subvol=@ root=UUID=test-uuid`,
+	}
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{snapshot}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.Contains(t, content, "        loader  /@/.snapshots/73/snapshot/boot/vmlinuz-linux")
+	assert.NotContains(t, content, "        initrd  ", "initrds stay in the options, loaded once")
+	assert.Contains(t, content, `        options quiet rw rootflags=subvol=@/.snapshots/73/snapshot,subvolid=256 root=UUID=test-uuid initrd=\@\.snapshots\73\snapshot\boot\intel-ucode.img initrd=\@\.snapshots\73\snapshot\boot\initramfs-linux.img`)
+}
+
+func TestGenerateSingleMenuEntry_BtrfsModeQuotedInitrdOptions(t *testing.T) {
+	snapshot := &btrfs.Snapshot{
+		Subvolume:      &btrfs.Subvolume{ID: 256, Path: "@/.snapshots/73/snapshot"},
+		FilesystemPath: "/mnt/@/.snapshots/73/snapshot",
+		SnapshotTime:   time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC),
+	}
+	bootPlans := []*kernel.BootPlan{{
+		Snapshot:        snapshot,
+		Mode:            kernel.BootModeBtrfs,
+		SnapshotKernel:  "/@/.snapshots/73/snapshot/boot/vmlinuz-linux",
+		SnapshotInitrds: []string{"/@/.snapshots/73/snapshot/boot/initramfs-linux.img"},
+		BtrfsVolume:     "ARCH_ROOT",
+	}}
+
+	// A refind_linux.conf line consolidated into the managed config has
+	// its options quoted for the menuentry.
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "refind_linux.conf")
+	require.NoError(t, os.WriteFile(confPath, []byte(`"Boot default" "root=UUID=test-uuid rootflags=subvol=@ initrd=\boot\initramfs-linux.img"`+"\n"), 0644))
+	entries, err := NewParser(dir).parseRefindLinuxConf(confPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	templateEntry := &MenuEntry{Loader: `\boot\vmlinuz-linux`, Options: quoteOptions(entries[0].Options)}
+
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, bootPlans)
+	generator.SetBootReadOnly(true)
+	content := generator.generateSingleMenuEntry("Boot default", templateEntry, nil, []*btrfs.Snapshot{snapshot}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.Contains(t, content, `        options "root=UUID=test-uuid rootflags=subvol=@/.snapshots/73/snapshot,subvolid=256 initrd=\@\.snapshots\73\snapshot\boot\initramfs-linux.img ro"`+"\n")
+}
+
 // TestGenerateSingleMenuEntry_ESPModeUnchangedWithBootPlans verifies that
 // ESP-mode output is byte-identical with or without boot plans present.
 // This is the backward compatibility guarantee for existing users.
@@ -665,11 +1035,11 @@ func TestGenerateSingleMenuEntry_ESPModeUnchangedWithBootPlans(t *testing.T) {
 
 	// Generate WITH boot plans (ESP mode plan)
 	withPlans := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, []*kernel.BootPlan{espPlan})
-	contentWith := withPlans.generateSingleMenuEntry("Arch Linux", templateEntry, []*btrfs.Snapshot{snapshot}, rootFS)
+	contentWith := withPlans.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{snapshot}, rootFS)
 
 	// Generate WITHOUT boot plans (old code path, no plans at all)
 	without := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
-	contentWithout := without.generateSingleMenuEntry("Arch Linux", templateEntry, []*btrfs.Snapshot{snapshot}, rootFS)
+	contentWithout := without.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{snapshot}, rootFS)
 
 	// Must be byte-identical — this is the key backward compat assertion
 	assert.Equal(t, contentWithout, contentWith,
@@ -719,7 +1089,7 @@ func TestGenerateSingleMenuEntry_MixedModeSnapshots(t *testing.T) {
 	}
 
 	rootFS := &btrfs.Filesystem{UUID: "test-uuid"}
-	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry,
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil,
 		[]*btrfs.Snapshot{espSnap, btrfsSnap}, rootFS)
 
 	// ESP submenu: must NOT have volume/loader/initrd overrides
@@ -744,6 +1114,400 @@ func TestGenerateSingleMenuEntry_MixedModeSnapshots(t *testing.T) {
 	assert.Contains(t, btrfsSection, "rootflags=subvol=@/.snapshots/73/snapshot")
 }
 
+func TestGenerateSingleMenuEntry_BtrfsFallbackSubmenu(t *testing.T) {
+	snapshot := &btrfs.Snapshot{
+		Subvolume:      &btrfs.Subvolume{ID: 256, Path: "@/.snapshots/73/snapshot"},
+		FilesystemPath: "/mnt/@/.snapshots/73/snapshot",
+		SnapshotTime:   time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC),
+	}
+	primary := &kernel.BootPlan{
+		Snapshot:       snapshot,
+		Mode:           kernel.BootModeBtrfs,
+		SnapshotKernel: "/@/.snapshots/73/snapshot/boot/vmlinuz-linux",
+		SnapshotInitrds: []string{
+			"/@/.snapshots/73/snapshot/boot/intel-ucode.img",
+			"/@/.snapshots/73/snapshot/boot/initramfs-linux.img",
+		},
+		BtrfsVolume: "ARCH_ROOT",
+	}
+	fallback := *primary
+	fallback.SnapshotInitrds = []string{
+		"/@/.snapshots/73/snapshot/boot/intel-ucode.img",
+		"/@/.snapshots/73/snapshot/boot/initramfs-linux-fallback.img",
+	}
+	fallback.Fallback = true
+
+	// The fallback plan is listed first to check it isn't taken as the
+	// snapshot's primary plan.
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, []*kernel.BootPlan{&fallback, primary})
+	generator.SetFallbackMarker(" [fallback]")
+	templateEntry := &MenuEntry{
+		Loader:  "/boot/vmlinuz-linux",
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+		// Disabling the fallback submenu leaves the primary one alone.
+		Submenues: []*SubmenuEntry{
+			{Title: "Arch Linux (2025-02-14T10:00:00Z)"},
+			{Title: "Arch Linux (2025-02-14T10:00:00Z) [fallback]", Disabled: true},
+		},
+	}
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil,
+		[]*btrfs.Snapshot{snapshot}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.Equal(t, `menuentry "Arch Linux" {
+    loader /boot/vmlinuz-linux
+    options quiet rw rootflags=subvol=@ root=UUID=test-uuid
+    submenuentry "Arch Linux (2025-02-14T10:00:00Z)" {
+        volume  ARCH_ROOT
+        loader  /@/.snapshots/73/snapshot/boot/vmlinuz-linux
+        initrd  /@/.snapshots/73/snapshot/boot/intel-ucode.img
+        initrd  /@/.snapshots/73/snapshot/boot/initramfs-linux.img
+        options quiet rw rootflags=subvol=@/.snapshots/73/snapshot,subvolid=256 root=UUID=test-uuid
+    }
+    submenuentry "Arch Linux (2025-02-14T10:00:00Z) [fallback]" {
+        disabled
+        volume  ARCH_ROOT
+        loader  /@/.snapshots/73/snapshot/boot/vmlinuz-linux
+        initrd  /@/.snapshots/73/snapshot/boot/intel-ucode.img
+        initrd  /@/.snapshots/73/snapshot/boot/initramfs-linux-fallback.img
+        options quiet rw rootflags=subvol=@/.snapshots/73/snapshot,subvolid=256 root=UUID=test-uuid
+    }
+}
+`, content)
+}
+
+func TestGenerateSingleMenuEntry_SubmenuOrder(t *testing.T) {
+	templateEntry := &MenuEntry{
+		Loader:  "/boot/vmlinuz-linux",
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+	}
+	// Deliberately unsorted, as when merging several volumes.
+	snapshots := []*btrfs.Snapshot{
+		{Subvolume: &btrfs.Subvolume{ID: 102, Path: "/.snapshots/102/snapshot"}, SnapshotTime: time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)},
+		{Subvolume: &btrfs.Subvolume{ID: 103, Path: "/.snapshots/103/snapshot"}, SnapshotTime: time.Date(2025, 6, 13, 0, 0, 0, 0, time.UTC)},
+		{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 11, 0, 0, 0, 0, time.UTC)},
+	}
+	rootFS := &btrfs.Filesystem{UUID: "test-uuid"}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"newest", []string{"2025-06-13", "2025-06-12", "2025-06-11"}},
+		{"oldest", []string{"2025-06-11", "2025-06-12", "2025-06-13"}},
+		{"", []string{"2025-06-13", "2025-06-12", "2025-06-11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			generator := NewGenerator("/boot/efi", "2006-01-02", false)
+			generator.SetSubmenuOrder(tt.order)
+
+			content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, snapshots, rootFS)
+
+			var got []int
+			for _, date := range tt.want {
+				got = append(got, strings.Index(content, fmt.Sprintf("submenuentry \"Arch Linux (%s)\"", date)))
+			}
+			assert.NotContains(t, got, -1)
+			assert.True(t, slices.IsSorted(got), "submenus out of order: %v", got)
+		})
+	}
+}
+
+func TestGenerateSingleMenuEntry_ESPCopy(t *testing.T) {
+	templateEntry := &MenuEntry{
+		Loader:  `\vmlinuz-linux`,
+		Initrd:  []string{"/intel-ucode.img", "/initramfs-linux.img"},
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+	}
+	copied := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 11, 0, 0, 0, 0, time.UTC)}
+	live := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 102, Path: "/.snapshots/102/snapshot"}, SnapshotTime: time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)}
+	bootSet := &kernel.BootSet{
+		KernelName: "linux",
+		Layout:     kernel.LayoutSplit,
+		Kernel:     &kernel.BootImage{Path: "/vmlinuz-linux", Filename: "vmlinuz-linux"},
+	}
+	plans := []*kernel.BootPlan{
+		{
+			Snapshot:   copied,
+			Mode:       kernel.BootModeESP,
+			BootSet:    bootSet,
+			ESPKernel:  "/EFI/refind-btrfs-snapshots/test-uuid/101/linux/vmlinuz-linux",
+			ESPInitrds: []string{"/EFI/refind-btrfs-snapshots/test-uuid/101/linux/initramfs-linux.img"},
+		},
+		{Snapshot: live, Mode: kernel.BootModeESP, BootSet: bootSet},
+	}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, []*kernel.BootSet{bootSet}, plans)
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{copied, live}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	copiedStart := strings.Index(content, `submenuentry "Arch Linux (2025-06-11)"`)
+	liveStart := strings.Index(content, `submenuentry "Arch Linux (2025-06-12)"`)
+	require.True(t, liveStart >= 0 && copiedStart > liveStart, "unexpected submenus:\n%s", content)
+	assert.NotContains(t, content[liveStart:copiedStart], "loader", "live-kernel snapshot should inherit the entry's loader")
+	assert.Contains(t, content[copiedStart:], "        loader  /EFI/refind-btrfs-snapshots/test-uuid/101/linux/vmlinuz-linux\n")
+	assert.Contains(t, content[copiedStart:], "        initrd  /EFI/refind-btrfs-snapshots/test-uuid/101/linux/initramfs-linux.img\n")
+}
+
+func TestGenerateSingleMenuEntry_ESPCopyKeepsLoaderVolume(t *testing.T) {
+	templateEntry := &MenuEntry{
+		Loader:  `ESP:\vmlinuz-linux`,
+		Initrd:  []string{`ESP:\initramfs-linux.img`},
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+	}
+	snapshot := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 11, 0, 0, 0, 0, time.UTC)}
+	bootSet := &kernel.BootSet{
+		KernelName: "linux",
+		Layout:     kernel.LayoutSplit,
+		Kernel:     &kernel.BootImage{Path: "/vmlinuz-linux", Filename: "vmlinuz-linux"},
+	}
+	plans := []*kernel.BootPlan{{
+		Snapshot:   snapshot,
+		Mode:       kernel.BootModeESP,
+		BootSet:    bootSet,
+		ESPKernel:  "/EFI/refind-btrfs-snapshots/test-uuid/101/linux/vmlinuz-linux",
+		ESPInitrds: []string{"/EFI/refind-btrfs-snapshots/test-uuid/101/linux/initramfs-linux.img"},
+	}}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, []*kernel.BootSet{bootSet}, plans)
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{snapshot}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.Contains(t, content, "    loader ESP:\\vmlinuz-linux\n", "source loader should be kept verbatim")
+	assert.Contains(t, content, "        loader  ESP:/EFI/refind-btrfs-snapshots/test-uuid/101/linux/vmlinuz-linux\n")
+	assert.Contains(t, content, "        initrd  ESP:/EFI/refind-btrfs-snapshots/test-uuid/101/linux/initramfs-linux.img\n")
+}
+
+// fallbackPlan builds a split boot set with a fallback initramfs and a
+// stale plan for snapshot that resolved to the fallback action.
+func fallbackPlan(snapshot *btrfs.Snapshot) *kernel.BootPlan {
+	return &kernel.BootPlan{
+		Snapshot: snapshot,
+		Mode:     kernel.BootModeESP,
+		BootSet: &kernel.BootSet{
+			KernelName: "linux",
+			Layout:     kernel.LayoutSplit,
+			Kernel:     &kernel.BootImage{Path: "/vmlinuz-linux", Filename: "vmlinuz-linux"},
+			Initramfs:  &kernel.BootImage{Path: "/initramfs-linux.img", Filename: "initramfs-linux.img"},
+			Fallback:   &kernel.BootImage{Path: "/initramfs-linux-fallback.img", Filename: "initramfs-linux-fallback.img"},
+		},
+		Staleness: &kernel.StalenessResult{IsStale: true, Action: kernel.ActionFallback, FallbackUsed: true},
+	}
+}
+
+func TestGenerateSingleMenuEntry_FallbackMarker(t *testing.T) {
+	templateEntry := &MenuEntry{
+		Loader:  "/vmlinuz-linux",
+		Initrd:  []string{"/intel-ucode.img", "/initramfs-linux.img"},
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+		Submenues: []*SubmenuEntry{
+			{Title: "Arch Linux (2025-06-11)", Disabled: true},
+		},
+	}
+	stale := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 11, 0, 0, 0, 0, time.UTC)}
+	fresh := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 102, Path: "/.snapshots/102/snapshot"}, SnapshotTime: time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, nil, []*kernel.BootPlan{fallbackPlan(stale)})
+	generator.SetFallbackMarker(" [fallback]")
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{stale, fresh}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	assert.Contains(t, content, `submenuentry "Arch Linux (2025-06-12)" {`)
+	staleStart := strings.Index(content, `submenuentry "Arch Linux (2025-06-11) [fallback]" {`)
+	require.GreaterOrEqual(t, staleStart, 0, "missing fallback marker:\n%s", content)
+	staleBlock := content[staleStart:]
+	assert.Contains(t, staleBlock, "        disabled\n", "disabled state should survive the title change")
+	assert.NotContains(t, content, "initramfs-linux-fallback.img", "only the title is marked; the initrds are left alone")
+}
+
+func TestGenerateRefindLinuxConf_FallbackMarker(t *testing.T) {
+	stale := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 11, 0, 0, 0, 0, time.UTC)}
+	entries := []*MenuEntry{
+		{Title: "Arch Linux", Loader: "/vmlinuz-linux", Options: `root=UUID=test-uuid rootflags=subvol=@ initrd=\initramfs-linux.img`, SourceFile: "/boot/refind_linux.conf"},
+	}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, nil, []*kernel.BootPlan{fallbackPlan(stale)})
+	generator.SetFallbackMarker(" [fallback]")
+
+	content, err := generator.generateRefindLinuxConfWithAllEntries("", []*btrfs.Snapshot{stale}, entries, &btrfs.Filesystem{UUID: "test-uuid"})
+	require.NoError(t, err)
+	assert.Contains(t, content, `"Arch Linux (2025-06-11) [fallback]" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101 initrd=\initramfs-linux.img"`)
+}
+
+func TestCheckInitrds(t *testing.T) {
+	espPath := t.TempDir()
+	for _, name := range []string{"intel-ucode.img", "initramfs-linux.img"} {
+		require.NoError(t, os.WriteFile(filepath.Join(espPath, name), []byte("initrd"), 0o644))
+	}
+	stale := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 11, 0, 0, 0, 0, time.UTC)}
+	fresh := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 102, Path: "/.snapshots/102/snapshot"}, SnapshotTime: time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)}
+	snapshots := []*btrfs.Snapshot{stale, fresh}
+	fs := &btrfs.Filesystem{UUID: "test-uuid"}
+
+	t.Run("managed", func(t *testing.T) {
+		templateEntry := &MenuEntry{
+			Loader:  "/vmlinuz-linux",
+			Initrd:  []string{`\intel-ucode.img`, "/initramfs-linux-custom.img"},
+			Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+		}
+		generator := NewGeneratorWithBootPlans(espPath, "2006-01-02", false, nil, nil, []*kernel.BootPlan{{Snapshot: stale, Mode: kernel.BootModeESP}})
+		generator.SetCheckInitrds(true)
+
+		content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, snapshots, fs)
+		assert.Contains(t, content, `submenuentry "Arch Linux (2025-06-12)" {`, "without an ESP-mode plan the initrds aren't checked")
+		assert.NotContains(t, content, "2025-06-11", "the initramfs isn't on the ESP")
+
+		generator.SetCheckInitrds(false)
+		content = generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, snapshots, fs)
+		assert.Contains(t, content, "2025-06-11")
+	})
+
+	t.Run("refind_linux_conf", func(t *testing.T) {
+		entries := []*MenuEntry{
+			{Title: "Arch Linux", Options: `root=UUID=test-uuid rootflags=subvol=@ initrd=\initramfs-linux.img`, SourceFile: "/boot/refind_linux.conf"},
+			{Title: "Arch Linux (old)", Options: `root=UUID=test-uuid rootflags=subvol=@ initrd=\initramfs-linux-old.img`, SourceFile: "/boot/refind_linux.conf"},
+		}
+		generator := NewGeneratorWithBootPlans(espPath, "2006-01-02", false, nil, nil, []*kernel.BootPlan{{Snapshot: fresh, Mode: kernel.BootModeESP}})
+		generator.SetCheckInitrds(true)
+
+		content, err := generator.generateRefindLinuxConfWithAllEntries("", []*btrfs.Snapshot{fresh}, entries, fs)
+		require.NoError(t, err)
+		assert.Contains(t, content, `"Arch Linux (2025-06-12)"`)
+		assert.NotContains(t, content, "Arch Linux (old)")
+	})
+
+	t.Run("no_plan", func(t *testing.T) {
+		// Without an ESP-mode plan, /boot may not be the ESP, so initrds
+		// aren't looked up there.
+		entries := []*MenuEntry{
+			{Title: "Arch Linux (old)", Options: `root=UUID=test-uuid rootflags=subvol=@ initrd=\initramfs-linux-old.img`, SourceFile: "/boot/refind_linux.conf"},
+		}
+		generator := NewGeneratorWithBootPlans(espPath, "2006-01-02", false, nil, nil, nil)
+		generator.SetCheckInitrds(true)
+
+		content, err := generator.generateRefindLinuxConfWithAllEntries("", []*btrfs.Snapshot{fresh}, entries, fs)
+		require.NoError(t, err)
+		assert.Contains(t, content, `"Arch Linux (old) (2025-06-12)"`)
+	})
+}
+
+func TestMicrocodeMismatchAction(t *testing.T) {
+	ucodePath := filepath.Join(t.TempDir(), "intel-ucode.img")
+	require.NoError(t, os.WriteFile(ucodePath, []byte("ucode"), 0o644))
+	updated := time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(ucodePath, updated, updated))
+	bootSets := []*kernel.BootSet{{
+		KernelName: "linux",
+		Kernel:     &kernel.BootImage{Path: "/vmlinuz-linux"},
+		Microcode:  []*kernel.BootImage{{Path: "/intel-ucode.img", AbsPath: ucodePath}},
+	}}
+
+	before := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: updated.Add(-time.Hour)}
+	after := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 102, Path: "/.snapshots/102/snapshot"}, SnapshotTime: updated.Add(time.Hour)}
+	copied := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 103, Path: "/.snapshots/103/snapshot"}, SnapshotTime: updated.Add(-time.Hour)}
+	plans := []*kernel.BootPlan{{
+		Snapshot:   copied,
+		Mode:       kernel.BootModeESP,
+		BootSet:    bootSets[0],
+		ESPKernel:  "/EFI/snapshots/u/103/linux/vmlinuz-linux",
+		ESPInitrds: []string{"/EFI/snapshots/u/103/linux/intel-ucode.img", "/EFI/snapshots/u/103/linux/initramfs-linux.img"},
+	}}
+	options := `rw initrd=\intel-ucode.img initrd=\initramfs-linux.img`
+
+	t.Run("keep", func(t *testing.T) {
+		generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, bootSets, plans)
+		generator.SetMicrocodeAction(MicrocodeKeep)
+		assert.Equal(t, options, generator.microcodeOptions(options, before))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, bootSets, plans)
+		generator.SetMicrocodeAction(MicrocodeDrop)
+		assert.Equal(t, `rw initrd=\initramfs-linux.img`, generator.microcodeOptions(options, before))
+		assert.Equal(t, options, generator.microcodeOptions(options, after), "microcode older than the snapshot is kept")
+
+		templateEntry := &MenuEntry{
+			Loader:  "/vmlinuz-linux",
+			Initrd:  []string{"/intel-ucode.img", "/initramfs-linux.img"},
+			Options: "rw rootflags=subvol=@ root=UUID=test-uuid",
+		}
+		content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{before, after}, &btrfs.Filesystem{UUID: "test-uuid"})
+		start := strings.Index(content, `submenuentry "Arch Linux (2025-06-11)" {`)
+		require.GreaterOrEqual(t, start, 0, "missing snapshot submenu:\n%s", content)
+		assert.Contains(t, content[start:], "        initrd  /initramfs-linux.img\n")
+		assert.NotContains(t, content[start:], "initrd  /intel-ucode.img")
+		assert.Equal(t, 1, strings.Count(content, "initrd  "), "the newer snapshot inherits the entry's initrds:\n%s", content)
+	})
+
+	t.Run("drop leaving only microcode", func(t *testing.T) {
+		generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, bootSets, plans)
+		generator.SetMicrocodeAction(MicrocodeDrop)
+		fs := &btrfs.Filesystem{UUID: "test-uuid"}
+
+		templateEntry := &MenuEntry{
+			Loader:  "/vmlinuz-linux",
+			Initrd:  []string{"/intel-ucode.img"},
+			Options: "rw rootflags=subvol=@ root=UUID=test-uuid",
+		}
+		content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{before, after}, fs)
+		assert.NotContains(t, content, `submenuentry "Arch Linux (2025-06-11)"`, "an entry left without an initramfs is skipped")
+		assert.Contains(t, content, `submenuentry "Arch Linux (2025-06-12)"`)
+
+		entries := []*MenuEntry{{Title: "Arch Linux", Options: `rw root=UUID=test-uuid rootflags=subvol=@ initrd=\intel-ucode.img`}}
+		linuxConf, err := generator.generateRefindLinuxConfWithAllEntries("", []*btrfs.Snapshot{before, after}, entries, fs)
+		require.NoError(t, err)
+		assert.NotContains(t, linuxConf, `"Arch Linux (2025-06-11)"`)
+		assert.Contains(t, linuxConf, `"Arch Linux (2025-06-12)"`)
+	})
+
+	t.Run("pin", func(t *testing.T) {
+		generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, bootSets, plans)
+		generator.SetMicrocodeAction(MicrocodePin)
+		assert.Equal(t, `rw initrd=\EFI\snapshots\u\103\linux\intel-ucode.img initrd=\initramfs-linux.img`, generator.microcodeOptions(options, copied))
+		assert.Equal(t, options, generator.microcodeOptions(options, before), "without an ESP copy the live microcode is kept")
+	})
+}
+
+func TestGenerateSingleMenuEntry_ChainloadLoader(t *testing.T) {
+	templateEntry := &MenuEntry{
+		Loader:  "/vmlinuz-linux",
+		Initrd:  []string{"/initramfs-linux.img"},
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+	}
+	snapshot := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)}
+	generator := NewGenerator("/boot/efi", "2006-01-02", false)
+	generator.SetChainloadLoader("/EFI/tools/loader.efi")
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{snapshot}, &btrfs.Filesystem{UUID: "test-uuid"})
+
+	start := strings.Index(content, `submenuentry "Arch Linux (2025-06-12)" {`)
+	require.GreaterOrEqual(t, start, 0, "missing snapshot submenu:\n%s", content)
+	submenu := content[start:]
+	assert.Contains(t, submenu, "        loader  /EFI/tools/loader.efi\n")
+	assert.Contains(t, submenu, "        options quiet rw rootflags=subvol=@/.snapshots/101/snapshot,subvolid=101 root=UUID=test-uuid\n")
+	assert.Contains(t, content, "    loader /vmlinuz-linux\n", "the source entry should still boot the kernel")
+}
+
+func TestGenerateSingleMenuEntry_ChainloadSkipsBtrfsMode(t *testing.T) {
+	templateEntry := &MenuEntry{
+		Loader:  "/vmlinuz-linux",
+		Initrd:  []string{"/initramfs-linux.img"},
+		Options: "quiet rw rootflags=subvol=@ root=UUID=test-uuid",
+	}
+	espSnapshot := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/101/snapshot"}, SnapshotTime: time.Date(2025, 6, 12, 0, 0, 0, 0, time.UTC)}
+	btrfsSnapshot := &btrfs.Snapshot{Subvolume: &btrfs.Subvolume{ID: 102, Path: "@/.snapshots/102/snapshot"}, SnapshotTime: time.Date(2025, 6, 13, 0, 0, 0, 0, time.UTC)}
+	bootPlans := []*kernel.BootPlan{{
+		Snapshot:        btrfsSnapshot,
+		Mode:            kernel.BootModeBtrfs,
+		SnapshotKernel:  "/@/.snapshots/102/snapshot/boot/vmlinuz-linux",
+		SnapshotInitrds: []string{"/@/.snapshots/102/snapshot/boot/initramfs-linux.img"},
+		BtrfsVolume:     "ARCH_ROOT",
+	}}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02", false, nil, nil, bootPlans)
+	generator.SetChainloadLoader("/EFI/tools/loader.efi")
+
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, nil, []*btrfs.Snapshot{btrfsSnapshot, espSnapshot}, &btrfs.Filesystem{UUID: "test-uuid"})
+	assert.Contains(t, content, `submenuentry "Arch Linux (2025-06-12)" {`)
+	assert.NotContains(t, content, "2025-06-13", "a chainloaded btrfs-mode submenu would boot no kernel:\n%s", content)
+}
+
 func TestIsLegacyGeneratedSnapshotEntry(t *testing.T) {
 	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
 
@@ -1006,3 +1770,584 @@ func TestUpdateRefindLinuxConfWithAllEntries_WithSnapshots_WritesMarkers(t *test
 	assert.Contains(t, content, "##refind-btrfs-snapshots-end")
 	assert.Contains(t, content, ".snapshots/101/snapshot")
 }
+
+func TestUpdateRefindLinuxConfWithAllEntries_LineEndings(t *testing.T) {
+	source := `"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw quiet"`
+	generated := `"Boot default (2026-02-14T12:30:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/99/snapshot,subvolid=99 rw quiet"`
+	want := source + "\n\n##refind-btrfs-snapshots-start\n" + generated + "\n##refind-btrfs-snapshots-end\n"
+
+	tests := []struct {
+		name         string
+		existing     string
+		preserveCRLF bool
+		want         string
+	}{
+		{"trailing blank lines", source + "\n\n\n", false, want},
+		{"no trailing newline", source, false, want},
+		{"CRLF rewritten as LF", source + "\r\n", false, want},
+		{"CRLF preserved", source + "\r\n", true, strings.ReplaceAll(want, "\n", "\r\n")},
+		{"LF kept with CRLF preservation", source + "\n", true, want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+			generator.SetPreserveCRLF(tt.preserveCRLF)
+
+			confPath := filepath.Join(t.TempDir(), "refind_linux.conf")
+			require.NoError(t, os.WriteFile(confPath, []byte(tt.existing), 0644))
+			sourceEntries := []*MenuEntry{{
+				Title:      "Boot default",
+				Options:    "root=UUID=test-uuid rootflags=subvol=@ rw quiet",
+				SourceFile: confPath,
+			}}
+			snapshots := []*btrfs.Snapshot{{
+				Subvolume:    &btrfs.Subvolume{ID: 99, Path: "/.snapshots/99/snapshot"},
+				SnapshotTime: time.Date(2026, 2, 14, 12, 30, 0, 0, time.UTC),
+			}}
+			rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{Path: "@"}}
+
+			diff, err := generator.UpdateRefindLinuxConfWithAllEntries(snapshots, sourceEntries, rootFS)
+			require.NoError(t, err)
+			require.NotNil(t, diff)
+			assert.Equal(t, tt.want, diff.Modified)
+
+			// A second run over the written file changes nothing.
+			require.NoError(t, os.WriteFile(confPath, []byte(diff.Modified), 0644))
+			again, err := generator.UpdateRefindLinuxConfWithAllEntries(snapshots, sourceEntries, rootFS)
+			require.NoError(t, err)
+			assert.Nil(t, again)
+		})
+	}
+}
+
+func TestGenerateSingleMenuEntry_OnlyModePreservesOtherMode(t *testing.T) {
+	espSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/42/snapshot"},
+		SnapshotTime: time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC),
+	}
+	btrfsSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 256, Path: "@/.snapshots/73/snapshot"},
+		SnapshotTime: time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC),
+	}
+	newSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 300, Path: "@/.snapshots/80/snapshot"},
+		SnapshotTime: time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC),
+	}
+
+	// Only btrfs-mode plans survive --only-mode btrfs; the ESP snapshots
+	// have no plan.
+	bootPlans := []*kernel.BootPlan{
+		{
+			Snapshot:       btrfsSnap,
+			Mode:           kernel.BootModeBtrfs,
+			SnapshotKernel: "/@/.snapshots/73/snapshot/boot/vmlinuz-linux",
+			BtrfsVolume:    "ARCH_ROOT",
+		},
+	}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, bootPlans)
+	generator.SetOnlyMode(kernel.BootModeBtrfs)
+
+	existing := `menuentry "Arch Linux" {
+    loader /boot/vmlinuz-linux
+    submenuentry "Arch Linux (2025-01-15T12:00:00Z)" {
+        options custom-esp-options
+    }
+    submenuentry "Arch Linux (2025-02-14T10:00:00Z)" {
+        options stale-btrfs-options
+    }
+}
+`
+	preserved := generator.parseExistingSubmenuBlocks(existing)
+	require.Len(t, preserved, 2)
+
+	templateEntry := &MenuEntry{Loader: "/boot/vmlinuz-linux", Options: "quiet rw rootflags=subvol=@"}
+	content := generator.generateSingleMenuEntry("Arch Linux", templateEntry, preserved,
+		[]*btrfs.Snapshot{espSnap, btrfsSnap, newSnap}, &btrfs.Filesystem{})
+
+	assert.Contains(t, content, "        options custom-esp-options\n", "ESP-mode submenu must be carried over verbatim")
+	assert.NotContains(t, content, "stale-btrfs-options", "btrfs-mode submenu must be regenerated")
+	assert.Contains(t, content, "loader  /@/.snapshots/73/snapshot/boot/vmlinuz-linux")
+	assert.NotContains(t, content, "2025-03-01T09:00:00Z", "ESP-mode snapshot without an existing entry must not be added")
+}
+
+func TestUpdateRefindLinuxConfWithAllEntries_OmitMarkers(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetOmitMarkers(true)
+
+	confPath := filepath.Join(t.TempDir(), "refind_linux.conf")
+	require.NoError(t, os.WriteFile(confPath, []byte(`"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw quiet"
+`), 0644))
+
+	sourceEntries := []*MenuEntry{{
+		Title:      "Boot default",
+		Options:    "root=UUID=test-uuid rootflags=subvol=@ rw quiet",
+		SourceFile: confPath,
+	}}
+	snapshotTime := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	snapshots := []*btrfs.Snapshot{{
+		Subvolume:    &btrfs.Subvolume{ID: 101, Path: "/.snapshots/101/snapshot"},
+		SnapshotTime: snapshotTime,
+	}}
+	rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{Path: "@"}}
+
+	first, err := generator.UpdateRefindLinuxConfWithAllEntries(snapshots, sourceEntries, rootFS)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	assert.NotContains(t, first.Modified, "##refind-btrfs-snapshots")
+	assert.Contains(t, first.Modified, `"Boot default (2025-01-01T12:00:00Z)"`)
+
+	// A hand-edited unmarked entry is left alone and not written again.
+	edited := strings.Replace(first.Modified, "subvolid=101 rw quiet", "subvolid=101 rw quiet loglevel=7", 1)
+	require.Contains(t, edited, "loglevel=7")
+	require.NoError(t, os.WriteFile(confPath, []byte(edited), 0644))
+
+	second, err := generator.UpdateRefindLinuxConfWithAllEntries(snapshots, sourceEntries, rootFS)
+	require.NoError(t, err)
+	assert.Nil(t, second, "existing unmarked entries must not be duplicated or rewritten")
+}
+
+func TestUpdateRefindLinuxConfWithAllEntries_OnlyModePreservesOtherMode(t *testing.T) {
+	espSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 101, Path: "@/.snapshots/42/snapshot"},
+		SnapshotTime: time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC),
+	}
+	btrfsSnap := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 256, Path: "@/.snapshots/73/snapshot"},
+		SnapshotTime: time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC),
+	}
+
+	bootPlans := []*kernel.BootPlan{{Snapshot: espSnap, Mode: kernel.BootModeESP}}
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, bootPlans)
+	generator.SetOnlyMode(kernel.BootModeESP)
+
+	confPath := filepath.Join(t.TempDir(), "refind_linux.conf")
+	existingContent := `"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw"
+##refind-btrfs-snapshots-start
+"Boot default (2025-01-15T12:00:00Z)" "old-esp-options"
+"Boot default (2025-02-14T10:00:00Z)" "kept-btrfs-options"
+##refind-btrfs-snapshots-end
+`
+	require.NoError(t, os.WriteFile(confPath, []byte(existingContent), 0o644))
+
+	sourceEntries := []*MenuEntry{{
+		Title:      "Boot default",
+		Options:    "root=UUID=test-uuid rootflags=subvol=@ rw",
+		SourceFile: confPath,
+	}}
+
+	diff, err := generator.UpdateRefindLinuxConfWithAllEntries([]*btrfs.Snapshot{espSnap, btrfsSnap}, sourceEntries, &btrfs.Filesystem{})
+	require.NoError(t, err)
+	require.NotNil(t, diff)
+
+	assert.NotContains(t, diff.Modified, "old-esp-options", "ESP-mode line must be regenerated")
+	assert.Contains(t, diff.Modified, `"Boot default (2025-01-15T12:00:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/42/snapshot,subvolid=101 rw"`)
+	assert.Contains(t, diff.Modified, `"Boot default (2025-02-14T10:00:00Z)" "kept-btrfs-options"`, "btrfs-mode line must be carried over verbatim")
+}
+
+func TestTrimConfigDiff(t *testing.T) {
+	dir := t.TempDir()
+	generator := NewGenerator(dir, "2006-01-02T15:04:05Z", false)
+	generator.SetFallbackMarker(" [fallback]")
+
+	t.Run("refind_linux_conf", func(t *testing.T) {
+		path := filepath.Join(dir, "refind_linux.conf")
+		require.NoError(t, os.WriteFile(path, []byte(`"Boot with standard options" "rw root=UUID=abc rootflags=subvol=@"
+
+##refind-btrfs-snapshots-start
+"Boot with standard options (2025-06-14T10:00:00Z)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/3/snapshot"
+"Boot with standard options (2025-06-13T10:00:00Z) [fallback]" "rw root=UUID=abc rootflags=subvol=@/.snapshots/2/snapshot"
+"Boot with standard options (2025-06-12T10:00:00Z)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/1/snapshot"
+"Boot with standard options (hand edited)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/0/snapshot"
+##refind-btrfs-snapshots-end
+`), 0o644))
+
+		fileDiff, err := generator.TrimConfigDiff(path, 2)
+		require.NoError(t, err)
+		require.NotNil(t, fileDiff)
+		assert.Contains(t, fileDiff.Modified, "(2025-06-14T10:00:00Z)")
+		assert.Contains(t, fileDiff.Modified, "(2025-06-13T10:00:00Z) [fallback]")
+		assert.NotContains(t, fileDiff.Modified, "2025-06-12")
+		assert.Contains(t, fileDiff.Modified, "(hand edited)", "entries without a readable time are kept")
+		assert.Contains(t, fileDiff.Modified, `"Boot with standard options" "rw root=UUID=abc rootflags=subvol=@"`)
+
+		fileDiff, err = generator.TrimConfigDiff(path, 3)
+		require.NoError(t, err)
+		assert.Nil(t, fileDiff, "nothing to trim")
+	})
+
+	t.Run("managed_config", func(t *testing.T) {
+		path := filepath.Join(dir, "refind-btrfs-snapshots.conf")
+		header := `# Generated by refind-btrfs-snapshots
+
+menuentry "Arch Linux" {
+    loader /vmlinuz-linux
+`
+		newest := `    submenuentry "Arch Linux (2025-06-14T10:00:00Z)" {
+        options "rw rootflags=subvol=@/.snapshots/3/snapshot"
+    }
+`
+		older := `    submenuentry "Arch Linux (2025-06-13T10:00:00Z)" {
+        disabled
+        options "rw rootflags=subvol=@/.snapshots/2/snapshot"
+    }
+`
+		require.NoError(t, os.WriteFile(path, []byte(header+newest+older+"}\n"), 0o644))
+
+		fileDiff, err := generator.TrimConfigDiff(path, 1)
+		require.NoError(t, err)
+		require.NotNil(t, fileDiff)
+		assert.Equal(t, header+newest+"}\n", fileDiff.Modified)
+	})
+
+	t.Run("missing_file", func(t *testing.T) {
+		fileDiff, err := generator.TrimConfigDiff(filepath.Join(dir, "missing.conf"), 1)
+		require.NoError(t, err)
+		assert.Nil(t, fileDiff)
+	})
+}
+
+func TestMigrateConfigDiff(t *testing.T) {
+	dir := t.TempDir()
+	generator := NewGenerator(dir, "2006-01-02_15-04-05", false)
+
+	t.Run("legacy_entries", func(t *testing.T) {
+		path := filepath.Join(dir, "refind_linux.conf")
+		require.NoError(t, os.WriteFile(path, []byte(`"Boot with standard options" "rw root=UUID=abc rootflags=subvol=@"
+
+# Snapshot entries generated by refind-btrfs-snapshots
+"Boot with standard options (2024-01-15_12-30-00)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/2/snapshot"
+"Boot with standard options (2024-01-14_12-30-00)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/1/snapshot"
+
+"Boot to single-user mode" "rw root=UUID=abc rootflags=subvol=@ single"
+`), 0o644))
+
+		fileDiff, err := generator.MigrateConfigDiff(path)
+		require.NoError(t, err)
+		require.NotNil(t, fileDiff)
+		assert.Equal(t, `"Boot with standard options" "rw root=UUID=abc rootflags=subvol=@"
+
+"Boot to single-user mode" "rw root=UUID=abc rootflags=subvol=@ single"
+
+##refind-btrfs-snapshots-start
+"Boot with standard options (2024-01-15_12-30-00)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/2/snapshot"
+"Boot with standard options (2024-01-14_12-30-00)" "rw root=UUID=abc rootflags=subvol=@/.snapshots/1/snapshot"
+##refind-btrfs-snapshots-end
+`, fileDiff.Modified)
+
+		require.NoError(t, os.WriteFile(path, []byte(fileDiff.Modified), 0o644))
+		fileDiff, err = generator.MigrateConfigDiff(path)
+		require.NoError(t, err)
+		assert.Nil(t, fileDiff, "already migrated")
+	})
+
+	t.Run("no_legacy_entries", func(t *testing.T) {
+		path := filepath.Join(dir, "other", "refind_linux.conf")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(`"Boot with standard options" "rw root=UUID=abc"
+`), 0o644))
+
+		fileDiff, err := generator.MigrateConfigDiff(path)
+		require.NoError(t, err)
+		assert.Nil(t, fileDiff)
+	})
+
+	t.Run("managed_config", func(t *testing.T) {
+		path := filepath.Join(dir, "refind-btrfs-snapshots.conf")
+		require.NoError(t, os.WriteFile(path, []byte(`menuentry "Arch Linux" {
+    submenuentry "Arch Linux (2024-01-15_12-30-00)" {
+    }
+}
+`), 0o644))
+
+		fileDiff, err := generator.MigrateConfigDiff(path)
+		require.NoError(t, err)
+		assert.Nil(t, fileDiff)
+	})
+}
+
+func TestSelfCheck(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetFallbackMarker(" [fallback]")
+	snapshots := []*btrfs.Snapshot{
+		{
+			Subvolume:    &btrfs.Subvolume{ID: 301, Path: "@/.snapshots/3/snapshot"},
+			SnapshotTime: time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			Subvolume:    &btrfs.Subvolume{ID: 201, Path: "@/.snapshots/2/snapshot"},
+			SnapshotTime: time.Date(2025, 6, 13, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{Path: "@"}}
+
+	t.Run("refind_linux_conf_round_trip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "refind_linux.conf")
+		require.NoError(t, os.WriteFile(path, []byte(`"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw"`+"\n"), 0o644))
+		sourceEntries := []*MenuEntry{{Title: "Boot default", Options: "root=UUID=test-uuid rootflags=subvol=@ rw", SourceFile: path}}
+
+		fileDiff, err := generator.UpdateRefindLinuxConfWithAllEntries(snapshots, sourceEntries, rootFS)
+		require.NoError(t, err)
+		require.NotNil(t, fileDiff)
+		require.Contains(t, fileDiff.Modified, `"Boot default (2025-06-14T10:00:00Z)"`)
+		assert.Empty(t, generator.SelfCheck(path, fileDiff.Modified, snapshots))
+	})
+
+	t.Run("managed_config_round_trip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.conf")
+		require.NoError(t, os.WriteFile(path, []byte(`menuentry "Arch Linux" {
+    loader /boot/vmlinuz-linux
+    options root=UUID=test-uuid rootflags=subvol=/@ rw
+}
+`), 0o644))
+
+		fileDiff, err := generator.GenerateManagedConfigDiff(nil, snapshots, rootFS, path)
+		require.NoError(t, err)
+		require.NotNil(t, fileDiff)
+		require.Contains(t, fileDiff.Modified, `submenuentry "Arch Linux (2025-06-14T10:00:00Z)"`)
+		assert.Empty(t, generator.SelfCheck(path, fileDiff.Modified, snapshots))
+	})
+
+	t.Run("prefix_less_layout", func(t *testing.T) {
+		// Snapshot paths without the root's @: from the top-level subvolume
+		// as root, and from the mounted root under @.
+		for name, tt := range map[string]struct {
+			path string
+			fs   *btrfs.Filesystem
+		}{
+			"root_on_top_level": {".snapshots/3/snapshot", &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{ID: 5, Path: "<FS_TREE>"}}},
+			"root_on_@":         {"/.snapshots/3/snapshot", rootFS},
+		} {
+			t.Run(name, func(t *testing.T) {
+				prefixLess := []*btrfs.Snapshot{{
+					Subvolume:    &btrfs.Subvolume{ID: 301, Path: tt.path},
+					SnapshotTime: time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC),
+				}}
+				path := filepath.Join(t.TempDir(), "refind_linux.conf")
+				require.NoError(t, os.WriteFile(path, []byte(`"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw"`+"\n"), 0o644))
+				sourceEntries := []*MenuEntry{{Title: "Boot default", Options: "root=UUID=test-uuid rootflags=subvol=@ rw", SourceFile: path}}
+
+				fileDiff, err := generator.UpdateRefindLinuxConfWithAllEntries(prefixLess, sourceEntries, tt.fs)
+				require.NoError(t, err)
+				require.NotNil(t, fileDiff)
+				require.Contains(t, fileDiff.Modified, `"Boot default (2025-06-14T10:00:00Z)"`)
+				assert.Empty(t, generator.SelfCheck(path, fileDiff.Modified, prefixLess))
+			})
+		}
+	})
+
+	t.Run("discrepancies", func(t *testing.T) {
+		content := `"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw"
+##refind-btrfs-snapshots-start
+"Boot default (2025-06-14T10:00:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/2/snapshot,subvolid=301 rw"
+"Boot default (2025-06-13T10:00:00Z) [fallback]" "root=UUID=test-uuid rw"
+"Boot default (2025-06-12T10:00:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/1/snapshot rw"
+"Boot "default" (2025-06-14T10:00:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/3/snapshot rw"
+##refind-btrfs-snapshots-end
+`
+		problems := generator.SelfCheck("/boot/efi/EFI/arch/refind_linux.conf", content, snapshots)
+		require.Len(t, problems, 4)
+		assert.Contains(t, problems[0], `boots subvol="@/.snapshots/2/snapshot" subvolid="301", not snapshot @/.snapshots/3/snapshot`)
+		assert.Contains(t, problems[1], "has no subvol or subvolid")
+		assert.Contains(t, problems[2], "names no known snapshot")
+		assert.Contains(t, problems[3], "reads back as 4 fields")
+	})
+}
+
+func TestGenerateManagedConfigDiff_RecoveryDefault(t *testing.T) {
+	good := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 256, Path: "@/.snapshots/72/snapshot"},
+		SnapshotTime: time.Date(2025, 2, 13, 10, 0, 0, 0, time.UTC),
+	}
+	stale := &btrfs.Snapshot{
+		Subvolume:    &btrfs.Subvolume{ID: 257, Path: "@/.snapshots/73/snapshot"},
+		SnapshotTime: time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC),
+	}
+	plans := []*kernel.BootPlan{
+		{Snapshot: good, Mode: kernel.BootModeESP},
+		{Snapshot: stale, Mode: kernel.BootModeESP, Staleness: &kernel.StalenessResult{IsStale: true, Action: kernel.ActionWarn}},
+	}
+	rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{Path: "@"}}
+
+	configPath := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte(`menuentry "Arch Linux" {
+    icon /EFI/refind/icons/os_arch.png
+    loader /boot/vmlinuz-linux
+    initrd /boot/initramfs-linux.img
+    options quiet rw rootflags=subvol=@ root=UUID=test-uuid
+}
+`), 0o644))
+
+	generator := NewGeneratorWithBootPlans("/boot/efi", "2006-01-02T15:04:05Z", false, nil, nil, plans)
+	generator.SetRecoveryDefault(true)
+	configDiff, err := generator.GenerateManagedConfigDiff(nil, []*btrfs.Snapshot{stale, good}, rootFS, configPath)
+	require.NoError(t, err)
+	require.NotNil(t, configDiff)
+	assert.True(t, strings.HasSuffix(configDiff.Modified, `
+##refind-btrfs-snapshots-recovery-start
+# Boots the newest snapshot by default (behavior.set_recovery_default)
+default_selection "Recovery: Arch Linux (2025-02-13T10:00:00Z)"
+menuentry "Recovery: Arch Linux (2025-02-13T10:00:00Z)" {
+    icon /EFI/refind/icons/os_arch.png
+    loader /boot/vmlinuz-linux
+    initrd /boot/initramfs-linux.img
+    options quiet rw rootflags=subvol=@/.snapshots/72/snapshot,subvolid=256 root=UUID=test-uuid
+}
+##refind-btrfs-snapshots-recovery-end
+`), configDiff.Modified)
+
+	// The block isn't read back as a source entry, so a rerun is a no-op.
+	require.NoError(t, os.WriteFile(configPath, []byte(configDiff.Modified), 0o644))
+	configDiff, err = generator.GenerateManagedConfigDiff(nil, []*btrfs.Snapshot{stale, good}, rootFS, configPath)
+	require.NoError(t, err)
+	assert.Nil(t, configDiff)
+
+	generator.SetRecoveryDefault(false)
+	configDiff, err = generator.GenerateManagedConfigDiff(nil, []*btrfs.Snapshot{stale, good}, rootFS, configPath)
+	require.NoError(t, err)
+	require.NotNil(t, configDiff)
+	assert.NotContains(t, configDiff.Modified, "Recovery:")
+	assert.NotContains(t, configDiff.Modified, "default_selection")
+}
+
+func TestAgeBucket(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	// A Wednesday.
+	now := time.Date(2025, 6, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		taken time.Time
+		want  string
+	}{
+		{time.Date(2025, 6, 18, 0, 0, 0, 0, time.UTC), "Today"},
+		{time.Date(2025, 6, 17, 23, 59, 0, 0, time.UTC), "This Week"},
+		{time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC), "This Week"},
+		{time.Date(2025, 6, 15, 23, 59, 0, 0, time.UTC), "This Month"},
+		{time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), "This Month"},
+		{time.Date(2025, 5, 31, 23, 59, 0, 0, time.UTC), "Older"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ageBucketNames[generator.ageBucket(tt.taken, now)], tt.taken)
+	}
+}
+
+func TestGenerateManagedConfigDiff_BucketByAge(t *testing.T) {
+	snapshots := []*btrfs.Snapshot{
+		{Subvolume: &btrfs.Subvolume{ID: 301, Path: "/.snapshots/301/snapshot"}, SnapshotTime: time.Date(2025, 6, 18, 9, 0, 0, 0, time.UTC)},
+		{Subvolume: &btrfs.Subvolume{ID: 300, Path: "/.snapshots/300/snapshot"}, SnapshotTime: time.Date(2025, 5, 2, 9, 0, 0, 0, time.UTC)},
+	}
+	rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: &btrfs.Subvolume{Path: "@"}}
+
+	configPath := filepath.Join(t.TempDir(), "refind-btrfs-snapshots.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte(`menuentry "Arch Linux" {
+    loader /boot/vmlinuz-linux
+    options quiet rw rootflags=subvol=@ root=UUID=test-uuid
+}
+`), 0o644))
+
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+	generator.SetBucketByAge(true)
+	generator.now = func() time.Time { return time.Date(2025, 6, 18, 12, 0, 0, 0, time.UTC) }
+	configDiff, err := generator.GenerateManagedConfigDiff(nil, snapshots, rootFS, configPath)
+	require.NoError(t, err)
+	require.NotNil(t, configDiff)
+	assert.True(t, strings.HasSuffix(configDiff.Modified, `
+menuentry "Arch Linux" {
+    loader /boot/vmlinuz-linux
+    options quiet rw rootflags=subvol=@ root=UUID=test-uuid
+}
+
+##refind-btrfs-snapshots-buckets-start
+menuentry "Arch Linux [Today]" {
+    loader /boot/vmlinuz-linux
+    options quiet rw rootflags=subvol=@ root=UUID=test-uuid
+    submenuentry "Arch Linux (2025-06-18T09:00:00Z)" {
+        options quiet rw rootflags=subvol=@/.snapshots/301/snapshot,subvolid=301 root=UUID=test-uuid
+    }
+}
+menuentry "Arch Linux [Older]" {
+    loader /boot/vmlinuz-linux
+    options quiet rw rootflags=subvol=@ root=UUID=test-uuid
+    submenuentry "Arch Linux (2025-05-02T09:00:00Z)" {
+        options quiet rw rootflags=subvol=@/.snapshots/300/snapshot,subvolid=300 root=UUID=test-uuid
+    }
+}
+##refind-btrfs-snapshots-buckets-end
+`), configDiff.Modified)
+
+	// Bucket entries aren't read back as source entries, and a submenu
+	// disabled inside one stays disabled.
+	disabled := strings.Replace(configDiff.Modified, `"Arch Linux (2025-05-02T09:00:00Z)" {`, `"Arch Linux (2025-05-02T09:00:00Z)" {
+        disabled`, 1)
+	require.NoError(t, os.WriteFile(configPath, []byte(disabled), 0o644))
+	configDiff, err = generator.GenerateManagedConfigDiff(nil, snapshots, rootFS, configPath)
+	require.NoError(t, err)
+	assert.Nil(t, configDiff)
+}
+
+func TestMenuDiff(t *testing.T) {
+	generator := NewGenerator("/boot/efi", "2006-01-02T15:04:05Z", false)
+
+	t.Run("managed_config", func(t *testing.T) {
+		original := `# Generated
+menuentry "Arch Linux" {
+    loader /vmlinuz-linux
+    submenuentry "Arch Linux (2025-06-13T10:00:00Z)" {
+        options "subvol=@/.snapshots/2/snapshot"
+    }
+    submenuentry "Arch Linux (2025-06-12T10:00:00Z)" {
+        options "subvol=@/.snapshots/1/snapshot"
+    }
+}
+menuentry "Old Entry" {
+    loader /vmlinuz-old
+}
+`
+		modified := `# Generated again
+menuentry "Arch Linux" {
+    loader   /vmlinuz-linux
+    submenuentry "Arch Linux (2025-06-14T10:00:00Z)" {
+        options "subvol=@/.snapshots/3/snapshot"
+    }
+    submenuentry "Arch Linux (2025-06-13T10:00:00Z)" {
+        options "subvol=@/.snapshots/2/snapshot ro"
+    }
+}
+menuentry "Recovery: Arch Linux (2025-06-14T10:00:00Z)" {
+    loader /vmlinuz-linux
+}
+`
+		expected := `Menu changes in /boot/efi/EFI/refind/refind-btrfs-snapshots.conf:
+~ "Arch Linux"
+    + "Arch Linux (2025-06-14T10:00:00Z)"
+    ~ "Arch Linux (2025-06-13T10:00:00Z)" (options)
+    - "Arch Linux (2025-06-12T10:00:00Z)"
++ "Recovery: Arch Linux (2025-06-14T10:00:00Z)"
+- "Old Entry"
+`
+		assert.Equal(t, expected, generator.MenuDiff("/boot/efi/EFI/refind/refind-btrfs-snapshots.conf", original, modified))
+	})
+
+	t.Run("refind_linux_conf", func(t *testing.T) {
+		original := `"Boot default" "root=UUID=test-uuid rootflags=subvol=@ rw"
+##refind-btrfs-snapshots-start
+"Boot default (2025-06-13T10:00:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/2/snapshot rw"
+##refind-btrfs-snapshots-end
+`
+		modified := `"Boot default"   "root=UUID=test-uuid rootflags=subvol=@ rw"
+##refind-btrfs-snapshots-start
+"Boot default (2025-06-14T10:00:00Z)" "root=UUID=test-uuid rootflags=subvol=@/.snapshots/3/snapshot rw"
+##refind-btrfs-snapshots-end
+`
+		expected := `Menu changes in /boot/refind_linux.conf:
++ "Boot default (2025-06-14T10:00:00Z)"
+- "Boot default (2025-06-13T10:00:00Z)"
+`
+		assert.Equal(t, expected, generator.MenuDiff("/boot/refind_linux.conf", original, modified))
+	})
+
+	t.Run("whitespace_only", func(t *testing.T) {
+		original := "menuentry \"Arch Linux\" {\n    loader /vmlinuz-linux\n}\n"
+		modified := "# comment\n\nmenuentry \"Arch Linux\" {\n\tloader  /vmlinuz-linux\n}\n"
+		assert.Empty(t, generator.MenuDiff("/boot/efi/EFI/refind/refind-btrfs-snapshots.conf", original, modified))
+	})
+}
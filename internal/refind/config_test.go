@@ -1,14 +1,19 @@
 package refind
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/params"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFindRefindLinuxConfigs_MultipleFiles(t *testing.T) {
@@ -390,7 +395,7 @@ func TestUpdateOptionsForSnapshot_SubvolumeFormatPreservation(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			generator := &Generator{}
-			result := generator.updateOptionsForSnapshot(tt.originalOptions, snapshot)
+			result := generator.updateOptionsForSnapshot(tt.originalOptions, snapshot, nil)
 
 			// Extract the subvol value from the result
 			parser := params.NewBootOptionsParser()
@@ -405,3 +410,212 @@ func TestUpdateOptionsForSnapshot_SubvolumeFormatPreservation(t *testing.T) {
 		})
 	}
 }
+
+func TestIsBootable_TopLevelRoot(t *testing.T) {
+	for _, subvol := range []*btrfs.Subvolume{{ID: 5, Path: "/"}, {ID: 5, Path: "<FS_TREE>"}} {
+		rootFS := &btrfs.Filesystem{UUID: "test-uuid", Subvolume: subvol}
+		testCases := []struct {
+			name     string
+			options  *BootOptions
+			expected bool
+		}{
+			{"no_rootflags", &BootOptions{Root: "UUID=test-uuid"}, true},
+			{"subvol_slash", &BootOptions{Root: "UUID=test-uuid", Subvol: "/"}, true},
+			{"subvolid_5", &BootOptions{Root: "UUID=test-uuid", SubvolID: "5"}, true},
+			{"subvol_at", &BootOptions{Root: "UUID=test-uuid", Subvol: "@"}, false},
+			{"other_subvolid", &BootOptions{Root: "UUID=test-uuid", SubvolID: "256"}, false},
+			{"other_device", &BootOptions{Root: "UUID=other-uuid"}, false},
+		}
+		for _, tc := range testCases {
+			t.Run(subvol.Path+"/"+tc.name, func(t *testing.T) {
+				entry := &MenuEntry{Title: tc.name, BootOptions: tc.options}
+				assert.Equal(t, tc.expected, IsBootable(entry, rootFS))
+			})
+		}
+	}
+}
+
+func TestSnapshotOptions_TopLevelRoot(t *testing.T) {
+	snapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 312, Path: ".snapshots/8/snapshot"},
+	}
+	fs := &btrfs.Filesystem{UUID: "fs-uuid", Subvolume: &btrfs.Subvolume{ID: 5, Path: "/"}}
+	generator := &Generator{}
+
+	assert.Equal(t, "quiet rw root=UUID=fs-uuid rootflags=subvol=/.snapshots/8/snapshot,subvolid=312",
+		generator.snapshotOptions("quiet rw root=UUID=fs-uuid", snapshot, fs))
+	assert.Equal(t, "quiet rw rootflags=subvolid=312,subvol=/.snapshots/8/snapshot root=UUID=fs-uuid",
+		generator.snapshotOptions("quiet rw rootflags=subvolid=5 root=UUID=fs-uuid", snapshot, fs))
+}
+
+func TestSnapshotOptions_Template(t *testing.T) {
+	snapshot := &btrfs.Snapshot{
+		Subvolume: &btrfs.Subvolume{ID: 312, Path: "@/.snapshots/8/snapshot"},
+	}
+	fs := &btrfs.Filesystem{UUID: "fs-uuid", Label: "ARCH_ROOT"}
+	original := "quiet rw rootflags=subvol=@ root=UUID=fs-uuid"
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name:     "no_template",
+			expected: "quiet rw rootflags=subvol=@/.snapshots/8/snapshot,subvolid=312 root=UUID=fs-uuid",
+		},
+		{
+			name:     "full_control",
+			template: "root=LABEL={{.Label}} rootflags=subvolid={{.SubvolID}} rw",
+			expected: "root=LABEL=ARCH_ROOT rootflags=subvolid=312 rw",
+		},
+		{
+			name:     "extends_default",
+			template: "{{.Options}} resume=UUID={{.RootUUID}} snapshot={{.SubvolPath}}",
+			expected: "quiet rw rootflags=subvol=@/.snapshots/8/snapshot,subvolid=312 root=UUID=fs-uuid resume=UUID=fs-uuid snapshot=@/.snapshots/8/snapshot",
+		},
+		{
+			name:     "execution_error_falls_back",
+			template: "{{.Missing}}",
+			expected: "quiet rw rootflags=subvol=@/.snapshots/8/snapshot,subvolid=312 root=UUID=fs-uuid",
+		},
+		{
+			name:     "double_quote_falls_back",
+			template: `{{.Options}} title="{{.Label}}"`,
+			expected: "quiet rw rootflags=subvol=@/.snapshots/8/snapshot,subvolid=312 root=UUID=fs-uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := &Generator{}
+			require.NoError(t, generator.SetOptionsTemplate(tt.template))
+			assert.Equal(t, tt.expected, generator.snapshotOptions(original, snapshot, fs))
+		})
+	}
+
+	t.Run("quoted_options", func(t *testing.T) {
+		generator := &Generator{}
+		require.NoError(t, generator.SetOptionsTemplate("{{.Options}} splash"))
+		assert.Equal(t, `"quiet rw rootflags=subvol=@/.snapshots/8/snapshot,subvolid=312 root=UUID=fs-uuid splash"`,
+			generator.snapshotOptions(`"`+original+`"`, snapshot, fs), "the template sees the options unquoted and its output is quoted again")
+	})
+}
+
+func TestSetOptionsTemplate_Invalid(t *testing.T) {
+	generator := &Generator{}
+	assert.Error(t, generator.SetOptionsTemplate("{{.RootUUID"))
+}
+
+func TestCheckOptionsLength(t *testing.T) {
+	var buf bytes.Buffer
+	original := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = original }()
+
+	generator := &Generator{}
+	generator.SetMaxOptionsLength(32)
+
+	generator.checkOptionsLength("Arch Linux (short)", "quiet rw")
+	assert.Empty(t, buf.String())
+
+	generator.checkOptionsLength("Arch Linux (long)", strings.Repeat("x", 33))
+	assert.Contains(t, buf.String(), `"entry":"Arch Linux (long)"`)
+	assert.Contains(t, buf.String(), `"length":33`)
+
+	buf.Reset()
+	generator.SetMaxOptionsLength(0)
+	generator.checkOptionsLength("Arch Linux (long)", strings.Repeat("x", 4096))
+	assert.Empty(t, buf.String())
+}
+
+func TestCheckDefaultSelection(t *testing.T) {
+	const managed = `menuentry "Arch Linux" {
+    loader /vmlinuz-linux
+}
+
+menuentry "Arch Linux LTS" {
+    disabled
+    loader /vmlinuz-linux-lts
+}
+`
+	const zenFirst = `menuentry "Zen Linux" {
+    loader /vmlinuz-linux-zen
+}
+
+` + managed
+	const templates = `menuentry "Arch Linux" {
+    disabled
+    loader /vmlinuz-linux
+}
+`
+
+	tests := []struct {
+		name             string
+		defaultSelection string
+		managedContent   string
+		wantReasons      []string
+	}{
+		{name: "no_default_selection", managedContent: managed},
+		{name: "index_before_include", defaultSelection: "default_selection 1", managedContent: managed},
+		{
+			name:             "index_after_include",
+			defaultSelection: "default_selection 2",
+			managedContent:   managed,
+			wantReasons:      []string{`default_selection 2 is a menu position and the generated entries included at refind.conf line 7`, `e.g. default_selection "Arch Linux"`},
+		},
+		{name: "index_with_only_disabled_entries", defaultSelection: "default_selection 2", managedContent: templates},
+		{
+			name:             "title_claimed_by_generated_entry",
+			defaultSelection: "default_selection arch",
+			managedContent:   managed,
+			wantReasons:      []string{`default_selection "arch" now matches generated entry "Arch Linux", included at refind.conf line 7, ahead of "Arch Linux"`},
+		},
+		{
+			name:             "title_claimed_in_file_order",
+			defaultSelection: "default_selection linux",
+			managedContent:   zenFirst,
+			wantReasons:      []string{`default_selection "linux" now matches generated entry "Zen Linux"`},
+		},
+		{name: "title_matched_before_include", defaultSelection: "default_selection Windows", managedContent: managed},
+		{name: "previous_boot", defaultSelection: "default_selection +", managedContent: managed},
+		{
+			name:             "list_with_time_range",
+			defaultSelection: `default_selection "Windows,2" 23:30 6:00`,
+			managedContent:   managed,
+			wantReasons:      []string{"default_selection 2 is a menu position"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			mainPath := filepath.Join(dir, "refind.conf")
+			main := "timeout 20\n" + tt.defaultSelection + `
+
+menuentry "Windows" {
+    loader /EFI/Microsoft/Boot/bootmgfw.efi
+}
+include refind-btrfs-snapshots.conf
+menuentry "Arch Linux" {
+    loader /vmlinuz-linux
+}
+`
+			require.NoError(t, os.WriteFile(mainPath, []byte(main), 0o644))
+			config, err := NewParser(dir).ParseConfig(mainPath)
+			require.NoError(t, err)
+
+			generator := NewGenerator(dir, "2006-01-02T15:04:05Z", false)
+			reasons := generator.CheckDefaultSelection(config, filepath.Join(dir, "refind-btrfs-snapshots.conf"), tt.managedContent)
+
+			if len(tt.wantReasons) == 0 {
+				assert.Empty(t, reasons)
+				return
+			}
+			require.Len(t, reasons, 1)
+			for _, want := range tt.wantReasons {
+				assert.Contains(t, reasons[0], want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package refind
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetConsolidatedEntries sets the refind_linux.conf entries whose snapshot
+// entries move into the managed config (refind.multiple_refind_linux
+// "managed"). Each becomes an enabled menuentry loading the kernel next to
+// its refind_linux.conf, with the snapshots as submenus, regenerated on
+// every run so changes to the source line carry over.
+func (g *Generator) SetConsolidatedEntries(entries []*MenuEntry) {
+	g.consolidated = entries
+}
+
+// consolidatedMenuEntries returns the menuentries for the consolidated
+// refind_linux.conf entries, keyed by title. A title shared by several
+// entries is qualified with the directory of the kernel each loads. The
+// disabled state of an entry and its submenus is carried over from existing,
+// the entries of the managed config as it stands.
+func (g *Generator) consolidatedMenuEntries(existing map[string]*MenuEntry) map[string]*MenuEntry {
+	counts := make(map[string]int)
+	for _, source := range g.consolidated {
+		counts[source.Title]++
+	}
+
+	entries := make(map[string]*MenuEntry)
+	for _, source := range g.consolidated {
+		if source.Loader == "" {
+			log.Warn().
+				Str("source_file", source.SourceFile).
+				Str("title", source.Title).
+				Msg("No kernel found next to refind_linux.conf, not adding its entry to the managed config")
+			continue
+		}
+		title := source.Title
+		if counts[title] > 1 {
+			title = fmt.Sprintf("%s (%s)", title, path.Dir(strings.ReplaceAll(source.Loader, `\`, "/")))
+		}
+		entry := &MenuEntry{
+			Title:       title,
+			Loader:      source.Loader,
+			Options:     quoteOptions(source.Options),
+			BootOptions: source.BootOptions,
+			SourceFile:  source.SourceFile,
+		}
+		// The EFI stub already loads initrds named by initrd= options.
+		if !hasInitrdOption(source.Options) {
+			entry.Initrd = source.Initrd
+		}
+		if old, ok := existing[title]; ok {
+			entry.Disabled = old.Disabled
+			entry.Submenues = old.Submenues
+		}
+		entries[title] = entry
+	}
+	return entries
+}
@@ -0,0 +1,168 @@
+package refind
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CheckDefaultSelection reports how including the managed config at
+// managedPath, with managedContent, changes what the main config's
+// default_selection picks. rEFInd numbers main menu entries from 1 in the
+// order their stanzas are read, includes in place, and matches any other
+// identifier as a case-insensitive title substring, taking the first hit.
+// Enabled menuentries in the managed config therefore shift every position
+// after the include line and can claim a title match from a later entry.
+// Returns nil when default_selection is unaffected.
+func (g *Generator) CheckDefaultSelection(config *Config, managedPath, managedContent string) []string {
+	identifiers := defaultSelectionIdentifiers(config.GlobalConfig)
+	includeIndex := managedIncludeIndex(config, managedPath)
+	if len(identifiers) == 0 || includeIndex < 0 || includeIndex >= len(config.IncludeLines) {
+		return nil
+	}
+
+	generated := g.enabledManagedTitles(managedContent)
+	if len(generated) == 0 {
+		return nil
+	}
+
+	includeLine := config.IncludeLines[includeIndex]
+	manual := manualEntryOrder(config, managedPath)
+	before := 0
+	for before < len(manual) && manual[before].line < includeLine {
+		before++
+	}
+
+	var reasons []string
+	for _, id := range identifiers {
+		if id == "+" {
+			continue
+		}
+		if n, err := strconv.Atoi(id); err == nil {
+			if n <= before {
+				continue
+			}
+			reason := fmt.Sprintf("default_selection %d is a menu position and the generated entries included at %s line %d come before it, so it may now select a different entry; select by title instead",
+				n, filepath.Base(config.Path), includeLine)
+			if n-1 < len(manual) {
+				reason += fmt.Sprintf(", e.g. default_selection %q", manual[n-1].entry.Title)
+			}
+			reasons = append(reasons, reason)
+			continue
+		}
+
+		if slices.ContainsFunc(manual[:before], func(m orderedEntry) bool { return titleMatches(m.entry.Title, id) }) {
+			continue
+		}
+		i := slices.IndexFunc(generated, func(title string) bool { return titleMatches(title, id) })
+		if i < 0 {
+			continue
+		}
+		reason := fmt.Sprintf("default_selection %q now matches generated entry %q, included at %s line %d", id, generated[i], filepath.Base(config.Path), includeLine)
+		if j := slices.IndexFunc(manual[before:], func(m orderedEntry) bool { return titleMatches(m.entry.Title, id) }); j >= 0 {
+			reason += fmt.Sprintf(", ahead of %q", manual[before+j].entry.Title)
+		}
+		reason += "; move the include line below the entry you want as the default"
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+// enabledManagedTitles returns the titles of the enabled menuentries in
+// the managed config content in file order, the order rEFInd adds them to
+// the main menu, so the first title match is the one it would select.
+func (g *Generator) enabledManagedTitles(content string) []string {
+	entries := g.parseExistingManagedConfig(content)
+	var titles []string
+	for line := range strings.Lines(content) {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "menuentry ") {
+			continue
+		}
+		title := extractQuotedValue(line, "menuentry ")
+		if entry, ok := entries[title]; ok && !entry.Disabled && !slices.Contains(titles, title) {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// defaultSelectionIdentifiers returns the identifiers of the last
+// default_selection line in globals: a comma-separated list, quoted when it
+// has more than one identifier or contains spaces, optionally followed by
+// a time range.
+func defaultSelectionIdentifiers(globals []string) []string {
+	var value string
+	for _, line := range globals {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "default_selection" {
+			value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "default_selection"))
+		}
+	}
+	if value == "" {
+		return nil
+	}
+
+	if quoted, ok := strings.CutPrefix(value, `"`); ok {
+		value, _, _ = strings.Cut(quoted, `"`)
+	} else {
+		value = strings.Fields(value)[0]
+	}
+
+	var identifiers []string
+	for id := range strings.SplitSeq(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			identifiers = append(identifiers, id)
+		}
+	}
+	return identifiers
+}
+
+// orderedEntry is a manual menu entry with the main config line it is read
+// at: its own line, or the line of the include that pulls it in.
+type orderedEntry struct {
+	line  int
+	entry *MenuEntry
+}
+
+// manualEntryOrder returns the enabled manual stanzas from the main config
+// and its includes in the order rEFInd reads them, leaving out the managed
+// config's own entries and refind_linux.conf entries.
+func manualEntryOrder(config *Config, managedPath string) []orderedEntry {
+	mainDir := filepath.Dir(config.Path)
+	managedIndex := managedIncludeIndex(config, managedPath)
+	var ordered []orderedEntry
+	for _, entry := range config.Entries {
+		if entry.Disabled {
+			continue
+		}
+		if entry.SourceFile == config.Path {
+			ordered = append(ordered, orderedEntry{line: entry.LineNumber, entry: entry})
+			continue
+		}
+		for i, include := range config.IncludePaths {
+			if i >= len(config.IncludeLines) || i == managedIndex {
+				continue
+			}
+			fullPath := include
+			if !filepath.IsAbs(include) {
+				fullPath = filepath.Join(mainDir, include)
+			}
+			if entry.SourceFile == fullPath {
+				ordered = append(ordered, orderedEntry{line: config.IncludeLines[i], entry: entry})
+				break
+			}
+		}
+	}
+	slices.SortStableFunc(ordered, func(a, b orderedEntry) int {
+		return cmp.Compare(a.line, b.line)
+	})
+	return ordered
+}
+
+func titleMatches(title, id string) bool {
+	return strings.Contains(strings.ToLower(title), strings.ToLower(id))
+}
@@ -0,0 +1,25 @@
+package refind
+
+import "strings"
+
+// SetPreserveCRLF keeps CRLF line endings in a config that already uses
+// them, e.g. one last edited on Windows. Otherwise every written config
+// uses LF endings.
+func (g *Generator) SetPreserveCRLF(enabled bool) {
+	g.preserveCRLF = enabled
+}
+
+// finishContent returns content, generated to replace original, with LF
+// line endings and exactly one trailing newline. With SetPreserveCRLF the
+// endings are CRLF instead when original has any.
+func (g *Generator) finishContent(original, content string) string {
+	content = strings.TrimRight(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	if content == "" {
+		return ""
+	}
+	content += "\n"
+	if g.preserveCRLF && strings.Contains(original, "\r\n") {
+		content = strings.ReplaceAll(content, "\n", "\r\n")
+	}
+	return content
+}
@@ -10,7 +10,10 @@ import (
 
 // IsBootable reports whether a menu entry is a viable target for snapshot
 // generation: it must have boot options with a root parameter, a subvol or
-// subvolid in rootflags, and both must match the root filesystem.
+// subvolid in rootflags, and both must match the root filesystem. When the
+// root filesystem is the top-level subvolume (subvolid 5) there is no @ to
+// name, so an entry without a subvol or subvolid, or with ones naming the
+// top level, matches on its root device alone.
 func IsBootable(entry *MenuEntry, rootFS *btrfs.Filesystem) bool {
 	if entry.BootOptions == nil {
 		log.Trace().Str("title", entry.Title).Msg("Entry rejected: no boot options")
@@ -20,6 +23,9 @@ func IsBootable(entry *MenuEntry, rootFS *btrfs.Filesystem) bool {
 		log.Trace().Str("title", entry.Title).Msg("Entry rejected: no root parameter")
 		return false
 	}
+	if rootFS.Subvolume != nil && rootFS.Subvolume.IsTopLevel() {
+		return isBootableFromTopLevel(entry, rootFS)
+	}
 	if entry.BootOptions.Subvol == "" && entry.BootOptions.SubvolID == "" {
 		log.Trace().
 			Str("title", entry.Title).
@@ -75,3 +81,33 @@ func IsBootable(entry *MenuEntry, rootFS *btrfs.Filesystem) bool {
 		Msg("Entry accepted as bootable")
 	return true
 }
+
+// isBootableFromTopLevel is IsBootable for a root filesystem mounted from
+// the top-level subvolume.
+func isBootableFromTopLevel(entry *MenuEntry, rootFS *btrfs.Filesystem) bool {
+	if !rootFS.MatchesDevice(entry.BootOptions.Root) {
+		log.Trace().
+			Str("title", entry.Title).
+			Str("entry_root", entry.BootOptions.Root).
+			Str("rootfs_device", rootFS.Device).
+			Str("rootfs_uuid", rootFS.UUID).
+			Msg("Entry rejected: device mismatch")
+		return false
+	}
+	if !btrfs.SelectsTopLevel(entry.BootOptions.Subvol, entry.BootOptions.SubvolID) {
+		log.Trace().
+			Str("title", entry.Title).
+			Str("entry_subvol", entry.BootOptions.Subvol).
+			Str("entry_subvolid", entry.BootOptions.SubvolID).
+			Msg("Entry rejected: boots a subvolume, root is the top level")
+		return false
+	}
+
+	log.Debug().
+		Str("title", entry.Title).
+		Str("root", entry.BootOptions.Root).
+		Str("subvol", entry.BootOptions.Subvol).
+		Str("subvolid", entry.BootOptions.SubvolID).
+		Msg("Entry accepted as bootable from the top-level subvolume")
+	return true
+}
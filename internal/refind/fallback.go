@@ -0,0 +1,38 @@
+package refind
+
+import (
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+)
+
+// SetFallbackMarker sets the suffix appended to the titles of snapshot
+// entries that boot the fallback initramfs because the snapshot is stale
+// (stale_snapshot_action=fallback). Empty leaves titles unmarked.
+func (g *Generator) SetFallbackMarker(marker string) {
+	g.fallbackMarker = marker
+}
+
+// baseTitle strips the fallback marker so entries written before and after
+// a snapshot switched to the fallback initramfs are recognised as the same.
+func (g *Generator) baseTitle(title string) string {
+	if g.fallbackMarker == "" {
+		return title
+	}
+	return strings.TrimSuffix(title, g.fallbackMarker)
+}
+
+// usesFallback reports whether snapshot's ESP-mode plan for the kernel
+// entry loads resolved to the fallback initramfs (Staleness.FallbackUsed),
+// so its entry carries the fallback marker.
+func (g *Generator) usesFallback(snapshot *btrfs.Snapshot, entry *MenuEntry) bool {
+	plan := g.planForEntry(snapshot, entry)
+	return plan != nil && plan.Staleness != nil && plan.Staleness.FallbackUsed
+}
+
+// espInitrds returns the initrd lines of snapshot's ESP-mode submenu under
+// entry, or nil when it inherits entry's: microcode updated since the
+// snapshot dropped or pinned.
+func (g *Generator) espInitrds(snapshot *btrfs.Snapshot, entry *MenuEntry) []string {
+	return g.microcodeInitrds(entry.Initrd, snapshot)
+}
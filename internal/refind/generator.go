@@ -1,6 +1,11 @@
 package refind
 
 import (
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
 )
 
@@ -12,6 +17,40 @@ type Generator struct {
 	bootPlans    []*kernel.BootPlan
 	menuFormat   string
 	useLocalTime bool
+	onlyMode     kernel.BootMode
+	volumes      []VolumeSnapshots
+	oldestFirst  bool
+
+	fallbackMarker string
+
+	optionsTemplate  *template.Template
+	maxOptionsLength int
+	bootReadOnly     bool
+	matchWritability bool
+	omitMarkers      bool
+	chainloadLoader  string
+	optionOverrides  []optionOverride
+	recoveryDefault  bool
+	bucketByAge      bool
+	preserveCRLF     bool
+	microcodeAction  string
+	kernelTitles     map[string]string
+	checkInitrds     bool
+	consolidated     []*MenuEntry
+
+	// initrdExists caches initrdOnESP per initrd path.
+	initrdExists map[string]bool
+
+	// now returns the current time for age buckets; replaced in tests.
+	now func() time.Time
+}
+
+// VolumeSnapshots pairs a btrfs filesystem with the snapshots processed on
+// it, so generation across several volumes (--all-volumes) can attach each
+// entry's submenus from the volume that entry actually boots.
+type VolumeSnapshots struct {
+	FS        *btrfs.Filesystem
+	Snapshots []*btrfs.Snapshot
 }
 
 // NewGenerator creates a new rEFInd config generator.
@@ -39,3 +78,79 @@ func NewGeneratorWithBootPlans(espPath, menuFormat string, useLocalTime bool, sc
 		useLocalTime: useLocalTime,
 	}
 }
+
+// SetOnlyMode restricts regeneration to snapshots whose boot plan has the
+// given mode. Generated entries for snapshots of the other mode are carried
+// over unchanged from the existing file. An empty mode regenerates all.
+func (g *Generator) SetOnlyMode(mode kernel.BootMode) {
+	g.onlyMode = mode
+}
+
+// preservesSnapshot reports whether snapshot's existing generated entries
+// must be kept as-is because its boot mode is excluded by SetOnlyMode. The
+// caller filters boot plans to the requested mode, so a snapshot with no
+// plan belongs to the other mode.
+func (g *Generator) preservesSnapshot(snapshot *btrfs.Snapshot) bool {
+	if g.onlyMode == "" {
+		return false
+	}
+	plan := g.getBootPlanForSnapshot(snapshot)
+	return plan == nil || plan.Mode != g.onlyMode
+}
+
+// SetVolumes enables per-volume snapshot routing: each source entry only
+// receives snapshots from the volume it is bootable on. Entries matching no
+// volume keep the snapshot list passed by the caller.
+func (g *Generator) SetVolumes(volumes []VolumeSnapshots) {
+	g.volumes = volumes
+}
+
+// SetSubmenuOrder sets the order snapshot submenus are emitted in:
+// "newest" (the default) or "oldest" first. rEFInd has no directive for a
+// default submenu entry and highlights the first one, so this decides which
+// snapshot is pre-selected.
+func (g *Generator) SetSubmenuOrder(order string) {
+	g.oldestFirst = order == "oldest"
+}
+
+// SetChainloadLoader makes snapshot submenus in the managed config chainload
+// loader, an ESP path to an EFI binary, rather than boot the kernel directly,
+// for firmware that fails to start kernels loaded by rEFInd. Empty keeps
+// direct booting. refind_linux.conf entries can't set a loader and are
+// unaffected.
+func (g *Generator) SetChainloadLoader(loader string) {
+	g.chainloadLoader = loader
+}
+
+// filesystemForEntry returns the volume entry boots when SetVolumes was
+// used, else fallback.
+func (g *Generator) filesystemForEntry(entry *MenuEntry, fallback *btrfs.Filesystem) *btrfs.Filesystem {
+	for _, v := range g.volumes {
+		if IsBootable(entry, v.FS) {
+			return v.FS
+		}
+	}
+	return fallback
+}
+
+// snapshotsForEntry returns the snapshots to generate under entry, in
+// submenu order: those of the first volume entry boots when SetVolumes was
+// used, else fallback.
+func (g *Generator) snapshotsForEntry(entry *MenuEntry, fallback []*btrfs.Snapshot) []*btrfs.Snapshot {
+	snapshots := fallback
+	for _, v := range g.volumes {
+		if IsBootable(entry, v.FS) {
+			snapshots = v.Snapshots
+			break
+		}
+	}
+
+	ordered := slices.Clone(snapshots)
+	slices.SortStableFunc(ordered, func(a, b *btrfs.Snapshot) int {
+		if g.oldestFirst {
+			return a.SnapshotTime.Compare(b.SnapshotTime)
+		}
+		return b.SnapshotTime.Compare(a.SnapshotTime)
+	})
+	return ordered
+}
@@ -2,6 +2,7 @@ package refind
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"slices"
 	"strings"
@@ -18,11 +19,15 @@ func (g *Generator) GenerateManagedConfigDiff(sourceEntries []*MenuEntry, snapsh
 
 	var originalContent string
 	var existingEntries map[string]*MenuEntry
+	var preserved map[string]string
 	var isNewFile bool
 
 	if existingFileContent, err := os.ReadFile(configPath); err == nil {
 		originalContent = string(existingFileContent)
 		existingEntries = g.parseExistingManagedConfig(originalContent)
+		if g.onlyMode != "" {
+			preserved = g.parseExistingSubmenuBlocks(originalContent)
+		}
 		isNewFile = false
 	} else {
 		existingEntries = make(map[string]*MenuEntry)
@@ -39,14 +44,23 @@ func (g *Generator) GenerateManagedConfigDiff(sourceEntries []*MenuEntry, snapsh
 	content.WriteString("#   include refind-btrfs-snapshots.conf\n")
 	content.WriteString("#\n")
 
-	if isNewFile {
+	consolidated := g.consolidatedMenuEntries(existingEntries)
+	switch {
+	case isNewFile && len(consolidated) == 0:
 		content.WriteString(g.generateTemplateEntry(sourceEntries, snapshots, rootFS))
-	} else {
+	case isNewFile:
+		content.WriteString("\n")
+		content.WriteString(g.generateFromExistingEntries(consolidated, preserved, snapshots, rootFS))
+		if len(sourceEntries) > 0 {
+			content.WriteString(g.generateTemplateEntry(sourceEntries, snapshots, rootFS))
+		}
+	default:
+		maps.Copy(existingEntries, consolidated)
 		content.WriteString("\n")
-		content.WriteString(g.generateFromExistingEntries(existingEntries, snapshots, rootFS))
+		content.WriteString(g.generateFromExistingEntries(existingEntries, preserved, snapshots, rootFS))
 	}
 
-	newContent := content.String()
+	newContent := g.finishContent(originalContent, content.String())
 
 	if newContent == originalContent {
 		log.Debug().Str("path", configPath).Msg("File content matches, no changes required")
@@ -72,13 +86,18 @@ func (g *Generator) generateTemplateEntry(sourceEntries []*MenuEntry, snapshots
 	content.WriteString("# You can create multiple menuentry blocks for different boot configurations\n")
 	content.WriteString("\n")
 
+	// The sample options, and the snapshot submenus built from them, are
+	// those of the first source entry, booting its own volume's snapshots.
 	var sampleOptions string
+	entryFS := rootFS
 	if len(sourceEntries) > 0 {
 		sampleOptions = sourceEntries[0].Options
+		entryFS = g.filesystemForEntry(sourceEntries[0], rootFS)
+		snapshots = g.snapshotsForEntry(sourceEntries[0], snapshots)
 	}
-	if sampleOptions == "" && rootFS != nil {
-		if rootFS.UUID != "" {
-			sampleOptions = fmt.Sprintf("quiet rw rootflags=subvol=@ root=UUID=%s", rootFS.UUID)
+	if sampleOptions == "" && entryFS != nil {
+		if entryFS.UUID != "" {
+			sampleOptions = fmt.Sprintf("quiet rw rootflags=subvol=@ root=UUID=%s", entryFS.UUID)
 		} else {
 			sampleOptions = "quiet rw rootflags=subvol=@"
 		}
@@ -91,6 +110,9 @@ func (g *Generator) generateTemplateEntry(sourceEntries []*MenuEntry, snapshots
 			}
 
 			displayName := bs.DisplayName()
+			if title, ok := g.kernelTitle(bs.Kernel.Path); ok {
+				displayName = title
+			}
 			content.WriteString(fmt.Sprintf("menuentry \"%s\" {\n", displayName))
 			content.WriteString("    disabled\n")
 			content.WriteString("    icon     /EFI/refind/icons/os_arch.png\n")
@@ -116,7 +138,7 @@ func (g *Generator) generateTemplateEntry(sourceEntries []*MenuEntry, snapshots
 				snapshotTitle := fmt.Sprintf("%s (%s)", displayName, g.getSnapshotDisplayName(snapshot))
 				content.WriteString(fmt.Sprintf("    submenuentry \"%s\" {\n", snapshotTitle))
 				if sampleOptions != "" {
-					snapshotOptions := g.updateOptionsForSnapshot(sampleOptions, snapshot)
+					snapshotOptions := g.snapshotOptions(sampleOptions, snapshot, entryFS)
 					content.WriteString(fmt.Sprintf("        options %s\n", snapshotOptions))
 				}
 				content.WriteString("    }\n")
@@ -144,7 +166,7 @@ func (g *Generator) generateTemplateEntry(sourceEntries []*MenuEntry, snapshots
 			snapshotTitle := fmt.Sprintf("Arch Linux (%s)", g.getSnapshotDisplayName(snapshot))
 			content.WriteString(fmt.Sprintf("    submenuentry \"%s\" {\n", snapshotTitle))
 			if sampleOptions != "" {
-				snapshotOptions := g.updateOptionsForSnapshot(sampleOptions, snapshot)
+				snapshotOptions := g.snapshotOptions(sampleOptions, snapshot, entryFS)
 				content.WriteString(fmt.Sprintf("        options %s\n", snapshotOptions))
 			}
 			content.WriteString("    }\n")
@@ -164,8 +186,10 @@ func (g *Generator) generateTemplateEntry(sourceEntries []*MenuEntry, snapshots
 	return content.String()
 }
 
-// generateFromExistingEntries generates content from existing customized entries
-func (g *Generator) generateFromExistingEntries(existingEntries map[string]*MenuEntry, snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem) string {
+// generateFromExistingEntries generates content from existing customized entries.
+// preserved holds raw submenu blocks, keyed by title, for snapshots whose
+// boot mode is excluded by SetOnlyMode.
+func (g *Generator) generateFromExistingEntries(existingEntries map[string]*MenuEntry, preserved map[string]string, snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem) string {
 	var content strings.Builder
 
 	if len(existingEntries) == 0 {
@@ -188,30 +212,93 @@ func (g *Generator) generateFromExistingEntries(existingEntries map[string]*Menu
 		}
 		first = false
 
-		entryContent := g.generateSingleMenuEntry(title, entry, snapshots, rootFS)
+		entryContent := g.generateSingleMenuEntry(title, entry, preserved, snapshots, rootFS)
 		content.WriteString(entryContent)
 	}
+	content.WriteString(g.generateRecoveryDefault(titles, existingEntries, snapshots, rootFS))
 
 	return content.String()
 }
 
-// getBootPlanForSnapshot looks up the first boot plan for a snapshot.
+// getBootPlanForSnapshot looks up the first boot plan for a snapshot,
+// passing over fallback-initramfs plans.
 // Returns nil if no boot plans are available (falls back to ESP-mode behavior).
 func (g *Generator) getBootPlanForSnapshot(snapshot *btrfs.Snapshot) *kernel.BootPlan {
 	for _, plan := range g.bootPlans {
-		if plan.Snapshot.Path == snapshot.Path {
+		if plan.Snapshot.Path == snapshot.Path && !plan.Fallback {
+			return plan
+		}
+	}
+	return nil
+}
+
+// fallbackPlanFor returns the plan booting plan's in-snapshot kernel with
+// its fallback initramfs, or nil when none was planned.
+func (g *Generator) fallbackPlanFor(plan *kernel.BootPlan) *kernel.BootPlan {
+	if plan == nil || !plan.VolumeRelative() {
+		return nil
+	}
+	for _, p := range g.bootPlans {
+		if p.Fallback && p.Snapshot.Path == plan.Snapshot.Path && p.SnapshotKernel == plan.SnapshotKernel {
+			return p
+		}
+	}
+	return nil
+}
+
+// planForEntry returns snapshot's ESP-mode plan for the kernel entry loads,
+// or nil.
+func (g *Generator) planForEntry(snapshot *btrfs.Snapshot, entry *MenuEntry) *kernel.BootPlan {
+	for _, plan := range g.bootPlans {
+		if plan.Snapshot.Path != snapshot.Path || plan.Mode != kernel.BootModeESP || plan.BootSet == nil || plan.BootSet.Kernel == nil {
+			continue
+		}
+		if sameESPPath(plan.BootSet.Kernel.Path, entry.Loader) {
 			return plan
 		}
 	}
 	return nil
 }
 
-// generateSingleMenuEntry generates a single menuentry with snapshots as submenus
-func (g *Generator) generateSingleMenuEntry(title string, templateEntry *MenuEntry, snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem) string {
+// sameESPPath compares ESP paths as rEFInd does on FAT: case-insensitively,
+// with backslashes and a leading separator normalised and any volume
+// qualifier ignored.
+func sameESPPath(a, b string) bool {
+	normalise := func(p string) string {
+		_, rest := SplitVolumePath(p)
+		return strings.TrimPrefix(strings.ReplaceAll(rest, `\`, "/"), "/")
+	}
+	return strings.EqualFold(normalise(a), normalise(b))
+}
+
+// generateSingleMenuEntry generates a single menuentry with snapshots as
+// submenus, or followed by one menuentry per age bucket holding them when
+// SetBucketByAge is on.
+func (g *Generator) generateSingleMenuEntry(title string, templateEntry *MenuEntry, preserved map[string]string, snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem) string {
 	var content strings.Builder
 
+	g.writeMenuEntryHeader(&content, title, templateEntry)
+	entryFS := g.filesystemForEntry(templateEntry, rootFS)
+	entrySnapshots := g.snapshotsForEntry(templateEntry, snapshots)
+	if g.bucketByAge {
+		content.WriteString("}\n")
+		content.WriteString(g.generateAgeBuckets(title, templateEntry, preserved, entrySnapshots, entryFS))
+		return content.String()
+	}
+	g.writeSnapshotSubmenus(&content, title, templateEntry, preserved, entrySnapshots, entryFS)
+	content.WriteString("}\n")
+
+	return content.String()
+}
+
+// writeMenuEntryHeader opens a menuentry titled title with templateEntry's
+// attributes.
+func (g *Generator) writeMenuEntryHeader(content *strings.Builder, title string, templateEntry *MenuEntry) {
 	content.WriteString(fmt.Sprintf("menuentry \"%s\" {\n", title))
 
+	if templateEntry.Disabled {
+		content.WriteString("    disabled\n")
+	}
 	if templateEntry.Icon != "" {
 		content.WriteString(fmt.Sprintf("    icon %s\n", templateEntry.Icon))
 	}
@@ -227,35 +314,141 @@ func (g *Generator) generateSingleMenuEntry(title string, templateEntry *MenuEnt
 	if templateEntry.Options != "" {
 		content.WriteString(fmt.Sprintf("    options %s\n", templateEntry.Options))
 	}
+	for _, directive := range templateEntry.ExtraDirectives {
+		content.WriteString(fmt.Sprintf("    %s\n", directive))
+	}
+}
 
+// writeSnapshotSubmenus writes the submenus of snapshots, titled after the
+// source entry title, with fallback-initramfs submenus where planned.
+func (g *Generator) writeSnapshotSubmenus(content *strings.Builder, title string, templateEntry *MenuEntry, preserved map[string]string, snapshots []*btrfs.Snapshot, entryFS *btrfs.Filesystem) {
 	for _, snapshot := range snapshots {
 		snapshotTitle := fmt.Sprintf("%s (%s)", title, g.getSnapshotDisplayName(snapshot))
-		content.WriteString(fmt.Sprintf("    submenuentry \"%s\" {\n", snapshotTitle))
-
+		if g.preservesSnapshot(snapshot) {
+			content.WriteString(preserved[snapshotTitle])
+			continue
+		}
 		plan := g.getBootPlanForSnapshot(snapshot)
-		g.writeSplitSubmenuBody(&content, plan, templateEntry, snapshot)
-		content.WriteString("    }\n")
+		if g.skipsMissingInitrd(snapshotTitle, plan, templateEntry, snapshot, templateEntry.Options) || g.skipsMicrocodeOnly(snapshotTitle, plan, templateEntry, snapshot, templateEntry.Options) || g.skipsChainload(snapshotTitle, plan) {
+			continue
+		}
+		if g.usesFallback(snapshot, templateEntry) {
+			g.writeSubmenu(content, snapshotTitle+g.fallbackMarker, plan, templateEntry, snapshot, entryFS)
+			continue
+		}
+		g.writeSubmenu(content, snapshotTitle, plan, templateEntry, snapshot, entryFS)
+		// A chainloaded binary can't load the in-snapshot initramfs, so a
+		// fallback submenu would be identical to the primary one.
+		if fallbackPlan := g.fallbackPlanFor(plan); fallbackPlan != nil && g.chainloadLoader == "" {
+			g.writeSubmenu(content, snapshotTitle+g.fallbackMarker, fallbackPlan, templateEntry, snapshot, entryFS)
+		}
 	}
+}
 
-	content.WriteString("}\n")
+// writeSubmenu writes one snapshot's submenuentry block.
+func (g *Generator) writeSubmenu(content *strings.Builder, title string, plan *kernel.BootPlan, templateEntry *MenuEntry, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) {
+	content.WriteString(fmt.Sprintf("    submenuentry \"%s\" {\n", title))
+	if g.submenuDisabled(templateEntry, title) {
+		content.WriteString("        disabled\n")
+	}
+	g.writeSplitSubmenuBody(content, title, plan, templateEntry, snapshot, fs)
+	content.WriteString("    }\n")
+}
 
-	return content.String()
+// submenuDisabled reports whether the existing managed entry had the
+// submenu with this title disabled by the user. Without a submenu of the
+// exact title the fallback marker is ignored, so the choice survives a
+// snapshot starting or stopping to use the fallback initramfs.
+func (g *Generator) submenuDisabled(entry *MenuEntry, title string) bool {
+	for _, s := range entry.Submenues {
+		if s.Title == title {
+			return s.Disabled
+		}
+	}
+	return slices.ContainsFunc(entry.Submenues, func(s *SubmenuEntry) bool {
+		return s.Disabled && g.baseTitle(s.Title) == g.baseTitle(title)
+	})
 }
 
 // writeSplitSubmenuBody handles both Split- and BLS-layout sets: rEFInd
 // doesn't read BLS .conf files, so the emitted shape is identical.
-func (g *Generator) writeSplitSubmenuBody(content *strings.Builder, plan *kernel.BootPlan, templateEntry *MenuEntry, snapshot *btrfs.Snapshot) {
-	if plan != nil && plan.Mode == kernel.BootModeBtrfs {
-		if plan.BtrfsVolume != "" {
-			content.WriteString(fmt.Sprintf("        volume  %s\n", plan.BtrfsVolume))
-		}
+func (g *Generator) writeSplitSubmenuBody(content *strings.Builder, title string, plan *kernel.BootPlan, templateEntry *MenuEntry, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) {
+	if g.chainloadLoader != "" {
+		g.writeChainloadSubmenuBody(content, title, templateEntry, snapshot, fs)
+		return
+	}
+	if plan != nil && plan.VolumeRelative() {
+		// Volume-relative paths are emitted as planned; only the source
+		// entry's own ESP-relative loader is inherited.
+		content.WriteString(fmt.Sprintf("        volume  %s\n", plan.BtrfsVolume))
 		content.WriteString(fmt.Sprintf("        loader  %s\n", plan.SnapshotKernel))
-		for _, initrd := range plan.SnapshotInitrds {
+		// Initrds the source entry loads with initrd= options are pointed
+		// at the snapshot's in writeSubmenuOptions instead.
+		if !hasInitrdOption(templateEntry.Options) {
+			for _, initrd := range plan.SnapshotInitrds {
+				content.WriteString(fmt.Sprintf("        initrd  %s\n", initrd))
+			}
+		}
+	} else if entryPlan := g.planForEntry(snapshot, templateEntry); entryPlan != nil && entryPlan.HasESPCopy() {
+		// The copy taken while the kernel matched the snapshot's modules
+		// replaces the live kernel the source entry loads, on the volume
+		// its loader names.
+		content.WriteString(fmt.Sprintf("        loader  %s\n", withVolumeOf(templateEntry.Loader, entryPlan.ESPKernel)))
+		for _, initrd := range entryPlan.ESPInitrds {
+			content.WriteString(fmt.Sprintf("        initrd  %s\n", withVolumeOf(templateEntry.Loader, initrd)))
+		}
+	} else if initrds := g.espInitrds(snapshot, templateEntry); initrds != nil {
+		for _, initrd := range initrds {
 			content.WriteString(fmt.Sprintf("        initrd  %s\n", initrd))
 		}
 	}
 
-	snapshotOptions := g.updateOptionsForSnapshot(templateEntry.Options, snapshot)
+	g.writeSubmenuOptions(content, title, plan, templateEntry, snapshot, fs)
+}
+
+// skipsChainload reports whether title's submenu is left out because it
+// would chainload the configured EFI binary for a volume-relative plan: its
+// in-snapshot kernel lives on btrfs, where the binary can't load it, so the
+// submenu would boot no snapshot kernel at all.
+func (g *Generator) skipsChainload(title string, plan *kernel.BootPlan) bool {
+	if g.chainloadLoader == "" || plan == nil || !plan.VolumeRelative() {
+		return false
+	}
+	log.Warn().
+		Str("entry", title).
+		Str("kernel", plan.SnapshotKernel).
+		Msg("Skipping chainloaded snapshot entry: the chainloaded binary can't load the in-snapshot kernel on btrfs")
+	return true
+}
+
+// writeChainloadSubmenuBody writes a submenu that chainloads the configured
+// EFI binary from the ESP instead of loading a kernel. rEFInd passes the
+// options, with an initrd= for each initrd line, to the binary as its load
+// options, so it receives the snapshot's command line. Volume-relative
+// plans are skipped before this, see skipsChainload.
+func (g *Generator) writeChainloadSubmenuBody(content *strings.Builder, title string, templateEntry *MenuEntry, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) {
+	content.WriteString(fmt.Sprintf("        loader  %s\n", g.chainloadLoader))
+	if entryPlan := g.planForEntry(snapshot, templateEntry); entryPlan != nil && entryPlan.HasESPCopy() {
+		for _, initrd := range entryPlan.ESPInitrds {
+			content.WriteString(fmt.Sprintf("        initrd  %s\n", withVolumeOf(templateEntry.Loader, initrd)))
+		}
+	} else if initrds := g.espInitrds(snapshot, templateEntry); initrds != nil {
+		for _, initrd := range initrds {
+			content.WriteString(fmt.Sprintf("        initrd  %s\n", initrd))
+		}
+	}
+	g.writeSubmenuOptions(content, title, nil, templateEntry, snapshot, fs)
+}
+
+// writeSubmenuOptions writes the snapshot's rewritten options line. Under a
+// volume-relative plan, its initrd= parameters load the plan's in-snapshot
+// initrds.
+func (g *Generator) writeSubmenuOptions(content *strings.Builder, title string, plan *kernel.BootPlan, templateEntry *MenuEntry, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) {
+	snapshotOptions := g.snapshotOptions(templateEntry.Options, snapshot, fs)
+	if plan != nil && plan.VolumeRelative() && hasInitrdOption(snapshotOptions) {
+		snapshotOptions = snapshotInitrdOptions(snapshotOptions, plan.SnapshotInitrds)
+	}
+	g.checkOptionsLength(title, snapshotOptions)
 	if snapshotOptions != "" {
 		content.WriteString(fmt.Sprintf("        options %s\n", snapshotOptions))
 	}
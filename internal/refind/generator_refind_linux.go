@@ -45,6 +45,9 @@ func (g *Generator) UpdateRefindLinuxConfWithAllEntries(snapshots []*btrfs.Snaps
 		log.Debug().Str("path", linuxConfPath).Msg("File content matches, no changes required")
 		return nil, nil
 	}
+	if g.omitMarkers {
+		log.Warn().Str("path", linuxConfPath).Msg("Writing snapshot entries without markers; later runs will not update or remove them")
+	}
 
 	return &diff.FileDiff{
 		Path:     linuxConfPath,
@@ -59,6 +62,7 @@ func (g *Generator) generateRefindLinuxConfWithAllEntries(originalContent string
 	var lines []string
 	var inGeneratedSection bool
 	var foundMarkers bool
+	preserved := make(map[string]string)
 
 	markerScanner := bufio.NewScanner(strings.NewReader(originalContent))
 	for markerScanner.Scan() {
@@ -84,16 +88,18 @@ func (g *Generator) generateRefindLinuxConfWithAllEntries(originalContent string
 			}
 
 			if inGeneratedSection {
+				g.collectGeneratedLine(preserved, line)
 				continue
 			}
 		} else {
-			if strings.Contains(line, "# Snapshot entries generated by refind-btrfs-snapshots") {
+			if strings.Contains(line, legacyHeaderComment) {
 				inGeneratedSection = true
 				continue
 			}
 
 			if inGeneratedSection {
 				if strings.TrimSpace(line) != "" && g.isLegacyGeneratedSnapshotEntry(line) {
+					g.collectGeneratedLine(preserved, line)
 					continue
 				} else if strings.TrimSpace(line) == "" {
 					continue
@@ -113,26 +119,82 @@ func (g *Generator) generateRefindLinuxConfWithAllEntries(originalContent string
 		return "", err
 	}
 
-	if len(sourceEntries) > 0 && len(snapshots) > 0 {
-		if len(lines) > 0 && lines[len(lines)-1] != "" {
-			lines = append(lines, "")
+	var generated []string
+	for _, sourceEntry := range sourceEntries {
+		entryFS := g.filesystemForEntry(sourceEntry, rootFS)
+		for _, snapshot := range g.snapshotsForEntry(sourceEntry, snapshots) {
+			snapshotTitle := fmt.Sprintf("%s (%s)", sourceEntry.Title, g.getSnapshotDisplayName(snapshot))
+			if g.preservesSnapshot(snapshot) {
+				if line, ok := preserved[snapshotTitle]; ok {
+					generated = append(generated, line)
+				}
+				continue
+			}
+			snapshotOptions := g.snapshotOptions(sourceEntry.Options, snapshot, entryFS)
+			if g.usesFallback(snapshot, sourceEntry) {
+				snapshotTitle += g.fallbackMarker
+			}
+			snapshotOptions = g.microcodeOptions(snapshotOptions, snapshot)
+			plan := g.getBootPlanForSnapshot(snapshot)
+			if g.skipsMissingInitrd(snapshotTitle, plan, sourceEntry, snapshot, snapshotOptions) || g.skipsMicrocodeOnly(snapshotTitle, plan, sourceEntry, snapshot, snapshotOptions) {
+				continue
+			}
+			g.checkOptionsLength(snapshotTitle, snapshotOptions)
+
+			snapshotLine := fmt.Sprintf("\"%s\" \"%s\"", snapshotTitle, snapshotOptions)
+			generated = append(generated, snapshotLine)
 		}
-		lines = append(lines, "##refind-btrfs-snapshots-start")
+	}
 
-		for _, sourceEntry := range sourceEntries {
-			for _, snapshot := range snapshots {
-				snapshotTitle := fmt.Sprintf("%s (%s)", sourceEntry.Title, g.getSnapshotDisplayName(snapshot))
-				snapshotOptions := g.updateOptionsForSnapshot(sourceEntry.Options, snapshot)
+	if g.omitMarkers {
+		generated = g.withoutExistingTitles(generated, lines)
+	}
 
-				snapshotLine := fmt.Sprintf("\"%s\" \"%s\"", snapshotTitle, snapshotOptions)
-				lines = append(lines, snapshotLine)
-			}
+	// Exactly one blank line separates the generated entries from the rest.
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(generated) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		if g.omitMarkers {
+			lines = append(lines, generated...)
+		} else {
+			lines = append(lines, "##refind-btrfs-snapshots-start")
+			lines = append(lines, generated...)
+			lines = append(lines, "##refind-btrfs-snapshots-end")
 		}
-
-		lines = append(lines, "##refind-btrfs-snapshots-end")
 	}
 
-	return strings.Join(lines, "\n") + "\n", nil
+	return g.finishContent(originalContent, strings.Join(lines, "\n")), nil
+}
+
+// SetOmitMarkers writes refind_linux.conf snapshot lines without the
+// ##refind-btrfs-snapshots-start/end markers, leaving them for the user to
+// manage: later runs can't recognise them to update or remove them, and only
+// add lines for snapshots whose title isn't already in the file.
+func (g *Generator) SetOmitMarkers(enabled bool) {
+	g.omitMarkers = enabled
+}
+
+// withoutExistingTitles drops the generated lines whose title is already
+// used by a line in existing, so unmarked entries aren't written twice.
+func (g *Generator) withoutExistingTitles(generated, existing []string) []string {
+	titles := make(map[string]bool)
+	for _, line := range existing {
+		if parts := g.parser.parseQuotedLine(strings.TrimSpace(line)); len(parts) > 0 {
+			titles[parts[0]] = true
+		}
+	}
+	var kept []string
+	for _, line := range generated {
+		if parts := g.parser.parseQuotedLine(line); len(parts) > 0 && titles[parts[0]] {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
 }
 
 // isLegacyGeneratedSnapshotEntry checks if a line is a legacy generated snapshot entry.
@@ -146,3 +208,13 @@ func (g *Generator) isLegacyGeneratedSnapshotEntry(line string) bool {
 	title := parts[0]
 	return legacyTimestampPattern.MatchString(title)
 }
+
+// collectGeneratedLine records a previously generated snapshot line under its
+// title so SetOnlyMode can write it back unchanged.
+func (g *Generator) collectGeneratedLine(preserved map[string]string, line string) {
+	parts := g.parser.parseQuotedLine(strings.TrimSpace(line))
+	if len(parts) < 1 {
+		return
+	}
+	preserved[g.baseTitle(parts[0])] = line
+}
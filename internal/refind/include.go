@@ -0,0 +1,61 @@
+package refind
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// mainConfigName is the only config filename rEFInd loads on its own; any
+// other name is read only when rEFInd is launched with "-c <file>".
+const mainConfigName = "refind.conf"
+
+// CheckManagedInclude reports why rEFInd would not read the managed config at
+// managedPath when booting with the parsed main config. rEFInd only honours
+// include lines in the main file, resolves them relative to that file's
+// directory, and compares paths case-insensitively (the ESP is FAT). Returns
+// nil when the managed config is reachable.
+func CheckManagedInclude(config *Config, managedPath string) []string {
+	var reasons []string
+
+	mainDir := filepath.Dir(config.Path)
+	if !strings.EqualFold(filepath.Base(config.Path), mainConfigName) {
+		reasons = append(reasons, fmt.Sprintf("main config %s is not named %s; rEFInd only reads it when launched with -c", config.Path, mainConfigName))
+	}
+
+	if !strings.EqualFold(filepath.Dir(managedPath), mainDir) {
+		reasons = append(reasons, fmt.Sprintf("managed config is outside %s; rEFInd resolves includes relative to the main config's directory", mainDir))
+		return reasons
+	}
+
+	name := filepath.Base(managedPath)
+	for _, include := range config.IncludePaths {
+		include = strings.Trim(include, `"`)
+		if filepath.IsAbs(include) {
+			if strings.EqualFold(filepath.Clean(include), managedPath) {
+				reasons = append(reasons, fmt.Sprintf("include %q is absolute; rEFInd resolves includes relative to %s, use \"include %s\"", include, mainDir, name))
+				return reasons
+			}
+			continue
+		}
+		if strings.EqualFold(filepath.Join(mainDir, include), managedPath) {
+			return reasons
+		}
+	}
+
+	reasons = append(reasons, fmt.Sprintf("%s has no \"include %s\" line", config.Path, name))
+	return reasons
+}
+
+// managedIncludeIndex returns the index in config.IncludePaths of the
+// relative include rEFInd would resolve to managedPath, or -1.
+func managedIncludeIndex(config *Config, managedPath string) int {
+	mainDir := filepath.Dir(config.Path)
+	for i, include := range config.IncludePaths {
+		include = strings.Trim(include, `"`)
+		if !filepath.IsAbs(include) && strings.EqualFold(filepath.Join(mainDir, include), managedPath) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package refind
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckManagedInclude(t *testing.T) {
+	mainPath := "/boot/efi/EFI/refind/refind.conf"
+	managedPath := "/boot/efi/EFI/refind/refind-btrfs-snapshots.conf"
+
+	tests := []struct {
+		name        string
+		config      *Config
+		managedPath string
+		wantReasons []string
+	}{
+		{
+			name:        "relative_include_reachable",
+			config:      &Config{Path: mainPath, IncludePaths: []string{"refind-btrfs-snapshots.conf"}},
+			managedPath: managedPath,
+		},
+		{
+			name:        "quoted_include_case_insensitive",
+			config:      &Config{Path: mainPath, IncludePaths: []string{`"Refind-Btrfs-Snapshots.CONF"`}},
+			managedPath: managedPath,
+		},
+		{
+			name:        "missing_include",
+			config:      &Config{Path: mainPath, IncludePaths: []string{"theme.conf"}},
+			managedPath: managedPath,
+			wantReasons: []string{`no "include refind-btrfs-snapshots.conf" line`},
+		},
+		{
+			name:        "absolute_include",
+			config:      &Config{Path: mainPath, IncludePaths: []string{managedPath}},
+			managedPath: managedPath,
+			wantReasons: []string{"is absolute"},
+		},
+		{
+			name:        "managed_outside_main_dir",
+			config:      &Config{Path: mainPath, IncludePaths: []string{"../other/refind-btrfs-snapshots.conf"}},
+			managedPath: "/boot/efi/EFI/other/refind-btrfs-snapshots.conf",
+			wantReasons: []string{"outside /boot/efi/EFI/refind"},
+		},
+		{
+			name:        "non_default_main_config_name",
+			config:      &Config{Path: "/boot/efi/EFI/refind/custom.conf", IncludePaths: []string{"refind-btrfs-snapshots.conf"}},
+			managedPath: managedPath,
+			wantReasons: []string{"is not named refind.conf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := CheckManagedInclude(tt.config, tt.managedPath)
+			assert.Len(t, reasons, len(tt.wantReasons))
+			for i, want := range tt.wantReasons {
+				if i < len(reasons) {
+					assert.Contains(t, reasons[i], want)
+				}
+			}
+		})
+	}
+}
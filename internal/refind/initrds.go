@@ -0,0 +1,131 @@
+package refind
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/rs/zerolog/log"
+)
+
+// SetCheckInitrds makes ESP-mode snapshot entries that load an initrd
+// missing from the ESP be left out with a warning, rather than written to
+// fail at boot with a kernel and initramfs that don't match
+// (kernel.check_initrds).
+func (g *Generator) SetCheckInitrds(enabled bool) {
+	g.checkInitrds = enabled
+}
+
+// skipsMissingInitrd reports whether snapshot's ESP-mode entry under entry
+// is left out because an initrd it loads, from an initrd line or an
+// initrd= in options, is missing on the ESP. Only snapshots with an ESP-mode
+// plan are checked: without one, /boot need not be the ESP the paths are
+// looked up on. Volume-relative plans and ESP boot copies load their own
+// files and aren't checked, nor are initrds on another volume.
+func (g *Generator) skipsMissingInitrd(title string, plan *kernel.BootPlan, entry *MenuEntry, snapshot *btrfs.Snapshot, options string) bool {
+	if !g.checkInitrds || entry.Volume != "" {
+		return false
+	}
+	if plan == nil || plan.Mode != kernel.BootModeESP || plan.VolumeRelative() {
+		return false
+	}
+	if entryPlan := g.planForEntry(snapshot, entry); entryPlan != nil && entryPlan.HasESPCopy() {
+		return false
+	}
+
+	initrds := g.espInitrds(snapshot, entry)
+	if initrds == nil {
+		initrds = entry.Initrd
+	}
+	initrds = append(initrds, initrdOptions(options)...)
+
+	for _, initrd := range initrds {
+		if !g.initrdOnESP(initrd) {
+			log.Warn().
+				Str("entry", title).
+				Str("initrd", initrd).
+				Str("snapshot", snapshot.Path).
+				Msg("Skipping ESP-mode snapshot entry: initrd not found on the ESP")
+			return true
+		}
+	}
+	return false
+}
+
+// initrdOnESP reports whether initrd exists relative to the ESP. Paths
+// naming another volume count as present. Results are cached for the run.
+func (g *Generator) initrdOnESP(initrd string) bool {
+	if volume, _ := SplitVolumePath(initrd); volume != "" {
+		return true
+	}
+	if exists, ok := g.initrdExists[initrd]; ok {
+		return exists
+	}
+
+	path := filepath.Join(g.espPath, filepath.FromSlash(strings.ReplaceAll(initrd, `\`, "/")))
+	_, err := os.Stat(path)
+	exists := !errors.Is(err, os.ErrNotExist)
+	if err != nil && exists {
+		log.Debug().Err(err).Str("path", path).Msg("Can't check initrd, assuming it exists")
+	}
+	if g.initrdExists == nil {
+		g.initrdExists = make(map[string]bool)
+	}
+	g.initrdExists[initrd] = exists
+	return exists
+}
+
+// initrdOptions returns the values of the initrd= parameters in options.
+func initrdOptions(options string) []string {
+	unquoted, _ := UnquoteOptions(options)
+	var initrds []string
+	for _, field := range strings.Fields(unquoted) {
+		if value, ok := strings.CutPrefix(field, "initrd="); ok {
+			initrds = append(initrds, value)
+		}
+	}
+	return initrds
+}
+
+// hasInitrdOption reports whether options load an initrd with initrd=.
+func hasInitrdOption(options string) bool {
+	unquoted, _ := UnquoteOptions(options)
+	return slices.ContainsFunc(strings.Fields(unquoted), func(field string) bool {
+		return strings.HasPrefix(field, "initrd=")
+	})
+}
+
+// snapshotInitrdOptions replaces the initrd= parameters of options, which
+// name initrds on the ESP, with one for each of a btrfs-mode plan's
+// in-snapshot initrds, at the position of the first. The kernel's EFI stub
+// reads them from the volume the kernel was loaded from, so the paths are
+// the planned ones within the btrfs volume.
+func snapshotInitrdOptions(options string, initrds []string) string {
+	return withUnquotedOptions(options, func(options string) string {
+		return replaceInitrdOptions(options, initrds)
+	})
+}
+
+// replaceInitrdOptions is snapshotInitrdOptions for unquoted options.
+func replaceInitrdOptions(options string, initrds []string) string {
+	var fields []string
+	replaced := false
+	for _, field := range strings.Fields(options) {
+		if !strings.HasPrefix(field, "initrd=") {
+			fields = append(fields, field)
+			continue
+		}
+		if replaced {
+			continue
+		}
+		for _, initrd := range initrds {
+			fields = append(fields, "initrd="+strings.ReplaceAll(initrd, "/", `\`))
+		}
+		replaced = true
+	}
+	return strings.Join(fields, " ")
+}
@@ -5,25 +5,63 @@ import (
 	"strings"
 )
 
-// parseExistingManagedConfig parses an existing managed config to extract menuentry customizations
+// keptDirectives are the menuentry directives, beyond those parsed into
+// MenuEntry fields, that a user may add to a managed config menuentry and
+// that are written back verbatim on regeneration. Only directives setting
+// how rEFInd presents the entry are kept: one claiming a hotkey or booting
+// a firmware entry instead (firmware_bootnum) would not hold for snapshot
+// entries, and is dropped.
+var keptDirectives = map[string]bool{
+	"graphics": true,
+	"ostype":   true,
+}
+
+// parseExistingManagedConfig parses an existing managed config to extract menuentry customizations.
+// The generated recovery block is skipped; it is rewritten on every run.
+// The submenus of age bucket entries are added to their source entry.
 func (g *Generator) parseExistingManagedConfig(content string) map[string]*MenuEntry {
 	entries := make(map[string]*MenuEntry)
 
 	var currentEntry *MenuEntry
+	var currentSubmenu *SubmenuEntry
 	var inMenuEntry bool
 	var inSubmenu bool
+	var inRecovery bool
+	var inBuckets bool
+	var bucketEntries []*MenuEntry
+	closeEntry := func() {
+		if inBuckets {
+			bucketEntries = append(bucketEntries, currentEntry)
+		} else {
+			entries[currentEntry.Title] = currentEntry
+		}
+	}
 
 	scanner := bufio.NewScanner(strings.NewReader(content))
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
-		if line == "" || strings.HasPrefix(line, "#") {
+		switch {
+		case strings.HasPrefix(line, "##refind-btrfs-snapshots-recovery-start"):
+			inRecovery = true
+			continue
+		case strings.HasPrefix(line, "##refind-btrfs-snapshots-recovery-end"):
+			inRecovery = false
+			continue
+		case strings.HasPrefix(line, "##refind-btrfs-snapshots-buckets-start"):
+			inBuckets = true
+			continue
+		case strings.HasPrefix(line, "##refind-btrfs-snapshots-buckets-end"):
+			inBuckets = false
+			continue
+		}
+		if inRecovery || line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
 		if strings.HasPrefix(line, "menuentry ") {
 			if currentEntry != nil {
-				entries[currentEntry.Title] = currentEntry
+				closeEntry()
 			}
 
 			title := extractQuotedValue(line, "menuentry ")
@@ -37,6 +75,12 @@ func (g *Generator) parseExistingManagedConfig(content string) map[string]*MenuE
 		}
 
 		if strings.HasPrefix(line, "submenuentry ") && inMenuEntry {
+			// Submenus are regenerated; they're only parsed so per-submenu
+			// state like "disabled" survives regeneration.
+			currentSubmenu = &SubmenuEntry{Title: extractQuotedValue(line, "submenuentry ")}
+			if currentEntry != nil {
+				currentEntry.Submenues = append(currentEntry.Submenues, currentSubmenu)
+			}
 			inSubmenu = true
 			continue
 		}
@@ -46,7 +90,7 @@ func (g *Generator) parseExistingManagedConfig(content string) map[string]*MenuE
 				inSubmenu = false
 			} else if inMenuEntry {
 				if currentEntry != nil {
-					entries[currentEntry.Title] = currentEntry
+					closeEntry()
 					currentEntry = nil
 				}
 				inMenuEntry = false
@@ -54,14 +98,60 @@ func (g *Generator) parseExistingManagedConfig(content string) map[string]*MenuE
 			continue
 		}
 
-		if inMenuEntry && !inSubmenu && currentEntry != nil {
+		if inSubmenu && currentSubmenu != nil {
+			g.parser.parseSubmenuDirective(currentSubmenu, line)
+		} else if inMenuEntry && currentEntry != nil {
+			if name, _, _ := strings.Cut(line, " "); keptDirectives[name] {
+				currentEntry.ExtraDirectives = append(currentEntry.ExtraDirectives, line)
+				continue
+			}
 			g.parser.parseMenuDirective(currentEntry, line)
 		}
 	}
 
 	if currentEntry != nil {
-		entries[currentEntry.Title] = currentEntry
+		closeEntry()
+	}
+	for _, bucket := range bucketEntries {
+		if entry, ok := entries[bucketBaseTitle(bucket.Title)]; ok {
+			entry.Submenues = append(entry.Submenues, bucket.Submenues...)
+		}
 	}
 
 	return entries
 }
+
+// parseExistingSubmenuBlocks returns the raw text of each submenuentry block
+// in an existing managed config, keyed by submenu title, so entries of a
+// boot mode excluded by SetOnlyMode can be written back unchanged.
+func (g *Generator) parseExistingSubmenuBlocks(content string) map[string]string {
+	blocks := make(map[string]string)
+
+	var current strings.Builder
+	var title string
+	var inSubmenu bool
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if !inSubmenu {
+			if strings.HasPrefix(line, "submenuentry ") {
+				title = extractQuotedValue(line, "submenuentry ")
+				current.Reset()
+				current.WriteString(raw + "\n")
+				inSubmenu = true
+			}
+			continue
+		}
+
+		current.WriteString(raw + "\n")
+		if line == "}" {
+			blocks[g.baseTitle(title)] = current.String()
+			inSubmenu = false
+		}
+	}
+
+	return blocks
+}
@@ -0,0 +1,151 @@
+package refind
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// menuNode is one menuentry, submenuentry or refind_linux.conf line as read
+// for MenuDiff: its title, its directives in file order, and its submenus.
+type menuNode struct {
+	title      string
+	directives []string
+	children   []*menuNode
+}
+
+// MenuDiff describes how the boot menu read from the rEFInd config at path
+// changes between original and modified, as a tree of added (+), removed
+// (-) and changed (~) entries and submenus. A changed entry names the
+// directives that differ. Comments, blank lines and indentation are
+// ignored, so a change that only touches them gives "".
+func (g *Generator) MenuDiff(path, original, modified string) string {
+	before := g.readMenu(path, original)
+	after := g.readMenu(path, modified)
+
+	var out strings.Builder
+	writeMenuDiff(&out, before, after, "")
+	if out.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Menu changes in %s:\n%s", path, out.String())
+}
+
+// readMenu reads the entries of a refind_linux.conf, whose lines are each an
+// entry, or of a config of menuentry blocks.
+func (g *Generator) readMenu(path, content string) []*menuNode {
+	var nodes []*menuNode
+	scanner := bufio.NewScanner(strings.NewReader(content))
+
+	if filepath.Base(path) == "refind_linux.conf" {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			parts := g.parser.parseQuotedLine(line)
+			if len(parts) == 0 {
+				continue
+			}
+			node := &menuNode{title: parts[0]}
+			if len(parts) > 1 {
+				node.directives = []string{"options " + strings.Join(parts[1:], " ")}
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+
+	var entry, submenu *menuNode
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "menuentry "):
+			entry = &menuNode{title: extractQuotedValue(line, "menuentry ")}
+			nodes = append(nodes, entry)
+		case strings.HasPrefix(line, "submenuentry ") && entry != nil:
+			submenu = &menuNode{title: extractQuotedValue(line, "submenuentry ")}
+			entry.children = append(entry.children, submenu)
+		case line == "}":
+			if submenu != nil {
+				submenu = nil
+			} else {
+				entry = nil
+			}
+		case submenu != nil:
+			submenu.directives = append(submenu.directives, strings.Join(strings.Fields(line), " "))
+		case entry != nil:
+			entry.directives = append(entry.directives, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	return nodes
+}
+
+// writeMenuDiff writes the changes from before to after at one level of the
+// menu: added and changed nodes in their new order, then removed ones.
+// Nodes are matched by title, and repeated titles in order.
+func writeMenuDiff(out *strings.Builder, before, after []*menuNode, indent string) {
+	remaining := slices.Clone(before)
+	take := func(title string) *menuNode {
+		for i, node := range remaining {
+			if node != nil && node.title == title {
+				remaining[i] = nil
+				return node
+			}
+		}
+		return nil
+	}
+
+	for _, node := range after {
+		old := take(node.title)
+		if old == nil {
+			fmt.Fprintf(out, "%s+ %q\n", indent, node.title)
+			continue
+		}
+		changed := changedDirectives(old.directives, node.directives)
+		var children strings.Builder
+		writeMenuDiff(&children, old.children, node.children, indent+"    ")
+		if len(changed) == 0 && children.Len() == 0 {
+			continue
+		}
+		if len(changed) > 0 {
+			fmt.Fprintf(out, "%s~ %q (%s)\n", indent, node.title, strings.Join(changed, ", "))
+		} else {
+			fmt.Fprintf(out, "%s~ %q\n", indent, node.title)
+		}
+		out.WriteString(children.String())
+	}
+
+	for _, node := range remaining {
+		if node != nil {
+			fmt.Fprintf(out, "%s- %q\n", indent, node.title)
+		}
+	}
+}
+
+// changedDirectives returns the names of the directives whose values differ
+// between before and after, in order of first appearance.
+func changedDirectives(before, after []string) []string {
+	values := func(directives []string) map[string][]string {
+		byName := make(map[string][]string)
+		for _, directive := range directives {
+			name, value, _ := strings.Cut(directive, " ")
+			byName[name] = append(byName[name], value)
+		}
+		return byName
+	}
+	old, updated := values(before), values(after)
+
+	var changed []string
+	for _, directive := range slices.Concat(after, before) {
+		name, _, _ := strings.Cut(directive, " ")
+		if slices.Contains(changed, name) || slices.Equal(old[name], updated[name]) {
+			continue
+		}
+		changed = append(changed, name)
+	}
+	return changed
+}
@@ -0,0 +1,175 @@
+package refind
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/kernel"
+	"github.com/rs/zerolog/log"
+)
+
+// Actions for an ESP-mode snapshot entry loading a microcode image that
+// changed after the snapshot was taken (kernel.microcode_mismatch_action).
+const (
+	MicrocodeKeep = "keep"
+	MicrocodeDrop = "drop"
+	MicrocodePin  = "pin"
+)
+
+// SetMicrocodeAction sets what happens to the microcode initrd of an
+// ESP-mode snapshot entry when the live microcode image was modified after
+// the snapshot was taken: MicrocodeKeep loads it anyway, MicrocodeDrop
+// leaves it out, and MicrocodePin loads the copy taken with the snapshot's
+// ESP boot copy instead, keeping the live one when there is no copy.
+// Microcode images are those found in the boot sets.
+func (g *Generator) SetMicrocodeAction(action string) {
+	g.microcodeAction = action
+}
+
+// changedMicrocode returns the ESP paths of the boot sets' microcode images
+// modified after snapshot was taken.
+func (g *Generator) changedMicrocode(snapshot *btrfs.Snapshot) []string {
+	if g.microcodeAction == "" || g.microcodeAction == MicrocodeKeep || snapshot.SnapshotTime.IsZero() {
+		return nil
+	}
+	var changed []string
+	for _, bs := range g.bootSets {
+		for _, mc := range bs.Microcode {
+			if slices.Contains(changed, mc.Path) {
+				continue
+			}
+			info, err := os.Stat(mc.AbsPath)
+			if err != nil || !info.ModTime().After(snapshot.SnapshotTime) {
+				continue
+			}
+			changed = append(changed, mc.Path)
+		}
+	}
+	return changed
+}
+
+// replaceMicrocode applies the microcode action to initrd, an ESP path
+// loaded by one of snapshot's entries. changed are the microcode images
+// modified since the snapshot. Returns the path to load instead, "" to drop
+// it, and whether it changed. A microcode image is pinned to the copy of
+// the same name in any of the snapshot's ESP boot copies.
+func (g *Generator) replaceMicrocode(initrd string, changed []string, snapshot *btrfs.Snapshot) (string, bool) {
+	if !slices.ContainsFunc(changed, func(mc string) bool { return sameESPPath(initrd, mc) }) {
+		return initrd, false
+	}
+	if g.microcodeAction == MicrocodeDrop {
+		log.Debug().Str("snapshot", snapshot.Path).Str("microcode", initrd).Msg("Dropping microcode updated since the snapshot")
+		return "", true
+	}
+
+	name := loaderFileName(initrd)
+	for _, plan := range g.bootPlans {
+		if plan.Snapshot.Path != snapshot.Path || !plan.HasESPCopy() {
+			continue
+		}
+		for _, copied := range plan.ESPInitrds {
+			if strings.EqualFold(path.Base(copied), name) {
+				return withVolumeOf(initrd, copied), true
+			}
+		}
+	}
+	log.Warn().
+		Str("snapshot", snapshot.Path).
+		Str("microcode", initrd).
+		Msg("No copy of the microcode taken with the snapshot to pin, loading the live one; enable behavior.copy_boot_to_esp")
+	return initrd, false
+}
+
+// microcodeInitrds applies the microcode action to the initrd lines of one
+// of snapshot's submenus. Returns nil when nothing changed, and an empty
+// slice when every line was dropped.
+func (g *Generator) microcodeInitrds(initrds []string, snapshot *btrfs.Snapshot) []string {
+	changed := g.changedMicrocode(snapshot)
+	if len(changed) == 0 {
+		return nil
+	}
+	out := []string{}
+	modified := false
+	for _, initrd := range initrds {
+		replacement, ok := g.replaceMicrocode(initrd, changed, snapshot)
+		modified = modified || ok
+		if replacement != "" {
+			out = append(out, replacement)
+		}
+	}
+	if !modified {
+		return nil
+	}
+	return out
+}
+
+// microcodeOptions applies the microcode action to the initrd= parameters
+// of one of snapshot's refind_linux.conf lines.
+func (g *Generator) microcodeOptions(options string, snapshot *btrfs.Snapshot) string {
+	changed := g.changedMicrocode(snapshot)
+	if len(changed) == 0 {
+		return options
+	}
+	var fields []string
+	for _, field := range strings.Fields(options) {
+		if value, ok := strings.CutPrefix(field, "initrd="); ok {
+			replacement, ok := g.replaceMicrocode(value, changed, snapshot)
+			if replacement == "" {
+				continue
+			}
+			if ok {
+				field = "initrd=" + strings.ReplaceAll(replacement, "/", `\`)
+			}
+		}
+		fields = append(fields, field)
+	}
+	return strings.Join(fields, " ")
+}
+
+// skipsMicrocodeOnly reports whether snapshot's ESP-mode entry under entry
+// is left out because dropping microcode (MicrocodeDrop) left it loading no
+// initrd but microcode: the kernel would boot without its initramfs.
+// options are the entry's options as written, after microcodeOptions.
+// Volume-relative plans and ESP boot copies load their own initrds and
+// aren't checked.
+func (g *Generator) skipsMicrocodeOnly(title string, plan *kernel.BootPlan, entry *MenuEntry, snapshot *btrfs.Snapshot, options string) bool {
+	if g.microcodeAction != MicrocodeDrop || (plan != nil && plan.VolumeRelative()) {
+		return false
+	}
+	if entryPlan := g.planForEntry(snapshot, entry); entryPlan != nil && entryPlan.HasESPCopy() {
+		return false
+	}
+
+	lines := g.espInitrds(snapshot, entry)
+	dropped := lines != nil && len(lines) < len(entry.Initrd)
+	if lines == nil {
+		lines = entry.Initrd
+	}
+	optionInitrds := initrdOptions(options)
+	dropped = dropped || len(optionInitrds) < len(initrdOptions(entry.Options))
+	if !dropped {
+		return false
+	}
+	if slices.ContainsFunc(slices.Concat(lines, optionInitrds), func(initrd string) bool { return !g.isMicrocode(initrd) }) {
+		return false
+	}
+	log.Warn().
+		Str("entry", title).
+		Str("snapshot", snapshot.Path).
+		Msg("Skipping ESP-mode snapshot entry: it loads no initramfs once microcode updated since the snapshot is dropped")
+	return true
+}
+
+// isMicrocode reports whether initrd is one of the boot sets' microcode
+// images.
+func (g *Generator) isMicrocode(initrd string) bool {
+	for _, bs := range g.bootSets {
+		if slices.ContainsFunc(bs.Microcode, func(mc *kernel.BootImage) bool { return sameESPPath(initrd, mc.Path) }) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package refind
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/rs/zerolog/log"
+)
+
+// legacyHeaderComment introduced the generated lines in refind_linux.conf
+// before the section markers were added.
+const legacyHeaderComment = "# Snapshot entries generated by refind-btrfs-snapshots"
+
+// MigrateConfigDiff wraps the legacy, marker-less snapshot lines in the
+// refind_linux.conf at path in ##refind-btrfs-snapshots-start/end markers,
+// so later runs find them by marker instead of by their timestamped title.
+// The managed config is always written whole and needs no migration.
+// Returns nil when the file doesn't exist, already has markers, or has no
+// legacy lines.
+func (g *Generator) MigrateConfigDiff(path string) (*diff.FileDiff, error) {
+	if filepath.Base(path) != "refind_linux.conf" {
+		log.Debug().Str("path", path).Msg("Managed config is written whole, nothing to migrate")
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	original := string(content)
+
+	if strings.Contains(original, "##refind-btrfs-snapshots-start") || strings.Contains(original, "##refind-btrfs-snapshots-end") {
+		log.Debug().Str("path", path).Msg("Generated entries already use markers")
+		return nil, nil
+	}
+
+	var kept, legacy []string
+	moved := false
+	for _, line := range strings.Split(strings.ReplaceAll(original, "\r\n", "\n"), "\n") {
+		blank := strings.TrimSpace(line) == ""
+		switch {
+		case strings.Contains(line, legacyHeaderComment):
+			moved = true
+		case !blank && g.isLegacyGeneratedSnapshotEntry(line):
+			legacy = append(legacy, line)
+			moved = true
+		case blank && moved && len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "":
+			// Don't leave a double gap where the moved lines were.
+		default:
+			kept = append(kept, line)
+			moved = moved && blank
+		}
+	}
+	if len(legacy) == 0 {
+		log.Debug().Str("path", path).Msg("No legacy generated entries to migrate")
+		return nil, nil
+	}
+
+	// Laid out as generate writes it: one blank line, then the section.
+	for len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "" {
+		kept = kept[:len(kept)-1]
+	}
+	if len(kept) > 0 {
+		kept = append(kept, "")
+	}
+	kept = append(kept, "##refind-btrfs-snapshots-start")
+	kept = append(kept, legacy...)
+	kept = append(kept, "##refind-btrfs-snapshots-end")
+
+	log.Debug().Str("path", path).Int("entries", len(legacy)).Msg("Wrapping legacy generated entries in markers")
+	return &diff.FileDiff{
+		Path:     path,
+		Original: original,
+		Modified: g.finishContent(original, strings.Join(kept, "\n")),
+	}, nil
+}
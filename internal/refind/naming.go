@@ -22,10 +22,14 @@ func (g *Generator) groupEntriesByBase(entries []*MenuEntry) map[string][]*MenuE
 	return groups
 }
 
-// generateGroupKey creates a key for grouping entries that should be consolidated
+// generateGroupKey creates a key for grouping entries that should be consolidated.
+// Entries are keyed by loader; refind_linux.conf entries (which never carry
+// a loader line) by their directory. A menuentry missing its loader is keyed
+// by root device and subvolume so it still groups with entries that boot
+// the same system, falling back to its title only when it has no options.
 func (g *Generator) generateGroupKey(entry *MenuEntry) string {
 	if entry.Loader != "" {
-		loaderName := filepath.Base(entry.Loader)
+		loaderName := loaderFileName(entry.Loader)
 		if ext := filepath.Ext(loaderName); ext != "" {
 			loaderName = strings.TrimSuffix(loaderName, ext)
 		}
@@ -37,6 +41,10 @@ func (g *Generator) generateGroupKey(entry *MenuEntry) string {
 		return "refind_linux:" + dir
 	}
 
+	if bo := entry.BootOptions; bo != nil && (bo.Root != "" || bo.Subvol != "") {
+		return "root:" + bo.Root + ":" + strings.TrimPrefix(bo.Subvol, "/")
+	}
+
 	return g.extractBaseName(entry.Title)
 }
 
@@ -46,8 +54,40 @@ func (g *Generator) extractBaseName(title string) string {
 	return strings.TrimSpace(baseName)
 }
 
+// SetKernelTitles sets the menu titles for kernels by loader file name
+// (advanced.kernel_titles), e.g. "vmlinuz-linux-custom", matched
+// case-insensitively with or without its extension. They take precedence
+// over the titles derived from kernel names.
+func (g *Generator) SetKernelTitles(titles map[string]string) {
+	g.kernelTitles = make(map[string]string, len(titles))
+	for loader, title := range titles {
+		g.kernelTitles[strings.ToLower(loader)] = title
+	}
+}
+
+// kernelTitle returns the title set by SetKernelTitles for loader, a path
+// or file name.
+func (g *Generator) kernelTitle(loader string) (string, bool) {
+	if loader == "" || len(g.kernelTitles) == 0 {
+		return "", false
+	}
+	name := strings.ToLower(loaderFileName(loader))
+	if title, ok := g.kernelTitles[name]; ok {
+		return title, true
+	}
+	title, ok := g.kernelTitles[strings.TrimSuffix(name, filepath.Ext(name))]
+	return title, ok
+}
+
 // generateMenuTitle generates an appropriate menu title from group key and template entry
 func (g *Generator) generateMenuTitle(groupKey string, templateEntry *MenuEntry) string {
+	if title, ok := g.kernelTitle(templateEntry.Loader); ok {
+		return title
+	}
+	if title, ok := g.kernelTitle(groupKey); ok {
+		return title
+	}
+
 	switch groupKey {
 	case "vmlinuz-linux", "vmlinuz":
 		return "Arch Linux"
@@ -86,6 +126,12 @@ func (g *Generator) mergeCustomizations(template, existing *MenuEntry) *MenuEntr
 		merged.Options = existing.Options
 		merged.BootOptions = parseBootOptions(existing.Options)
 	}
+	if len(existing.ExtraDirectives) > 0 {
+		merged.ExtraDirectives = existing.ExtraDirectives
+	}
+	if existing.Disabled {
+		merged.Disabled = true
+	}
 
 	merged.Submenues = []*SubmenuEntry{}
 
@@ -2,42 +2,200 @@ package refind
 
 import (
 	"fmt"
-	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
 
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/bootloader"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
 	"github.com/jmylchreest/refind-btrfs-snapshots/internal/params"
+	"github.com/rs/zerolog/log"
 )
 
+// OptionsTemplateData is the data advanced.options_template is executed
+// with for each snapshot submenu.
+type OptionsTemplateData struct {
+	RootUUID   string // UUID of the btrfs filesystem the snapshot lives on
+	Label      string // filesystem label, empty if unset
+	SubvolID   uint64 // snapshot subvolume ID
+	SubvolPath string // snapshot subvolume path as written to rootflags=subvol=
+	Options    string // the options line the default subvol rewriting produces, unquoted
+}
+
+// SetOptionsTemplate replaces the default subvol rewriting of submenu
+// options with a text/template over OptionsTemplateData. An empty text
+// restores the default.
+func (g *Generator) SetOptionsTemplate(text string) error {
+	if text == "" {
+		g.optionsTemplate = nil
+		return nil
+	}
+	tmpl, err := template.New("options_template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	g.optionsTemplate = tmpl
+	return nil
+}
+
+// SnapshotOptions adds Options to the submenus of the snapshot with
+// subvolume ID SubvolID, or of every snapshot whose description matches
+// the regular expression Description.
+type SnapshotOptions struct {
+	SubvolID    uint64
+	Description string
+	Options     string
+}
+
+type optionOverride struct {
+	subvolID    uint64
+	description *regexp.Regexp
+	options     string
+}
+
+// SetSnapshotOptions sets per-snapshot options applied, in order, over
+// each matching snapshot's rewritten options (see params.MergeOptions).
+func (g *Generator) SetSnapshotOptions(overrides []SnapshotOptions) error {
+	g.optionOverrides = nil
+	for _, o := range overrides {
+		so := optionOverride{subvolID: o.SubvolID, options: o.Options}
+		if o.Description != "" {
+			re, err := regexp.Compile(o.Description)
+			if err != nil {
+				return fmt.Errorf("description pattern %q: %w", o.Description, err)
+			}
+			so.description = re
+		}
+		g.optionOverrides = append(g.optionOverrides, so)
+	}
+	return nil
+}
+
+// applySnapshotOptions merges the options of every override matching
+// snapshot into options.
+func (g *Generator) applySnapshotOptions(options string, snapshot *btrfs.Snapshot) string {
+	for _, o := range g.optionOverrides {
+		matched := (o.subvolID != 0 && o.subvolID == snapshot.ID) ||
+			(o.description != nil && o.description.MatchString(snapshot.Description))
+		if !matched {
+			continue
+		}
+		log.Debug().Str("snapshot", snapshot.Path).Str("options", o.options).Msg("Applying per-snapshot options")
+		options = params.MergeOptions(options, o.options)
+	}
+	return options
+}
+
+// SetMaxOptionsLength sets the length above which a generated options line
+// is warned about. Zero disables the check.
+func (g *Generator) SetMaxOptionsLength(n int) {
+	g.maxOptionsLength = n
+}
+
+// SetBootReadOnly makes snapshot entries mount their root read-only
+// (behavior.boot_readonly), for snapshots left untouched rather than made
+// writable.
+func (g *Generator) SetBootReadOnly(enabled bool) {
+	g.bootReadOnly = enabled
+}
+
+// SetMatchWritability makes snapshot entries mount their root ro or rw to
+// match whether the snapshot is read-only, overriding the source entry's
+// (behavior.match_writability). behavior.boot_readonly takes precedence.
+func (g *Generator) SetMatchWritability(enabled bool) {
+	g.matchWritability = enabled
+}
+
+// checkOptionsLength warns when the options generated for the submenu or
+// refind_linux.conf line titled title exceed the configured maximum. Long
+// LUKS command lines plus the snapshot subvol path can outgrow what some
+// firmware passes through intact.
+func (g *Generator) checkOptionsLength(title, options string) {
+	if g.maxOptionsLength <= 0 || len(options) <= g.maxOptionsLength {
+		return
+	}
+	log.Warn().
+		Str("entry", title).
+		Int("length", len(options)).
+		Int("max_length", g.maxOptionsLength).
+		Msg("Generated boot options exceed refind.max_options_length; consider shortening kernel parameters")
+}
+
+// SnapshotCmdline returns the options line written for snapshot's submenu
+// or refind_linux.conf line under entry. rootFS is the filesystem snapshot
+// boots from when entry boots none of the SetVolumes volumes.
+func (g *Generator) SnapshotCmdline(entry *MenuEntry, snapshot *btrfs.Snapshot, rootFS *btrfs.Filesystem) string {
+	return g.snapshotOptions(entry.Options, snapshot, g.filesystemForEntry(entry, rootFS))
+}
+
+// snapshotOptions returns the options line for snapshot's submenu under a
+// source entry with originalOptions, booting from fs. Falls back to the
+// default rewriting, with a warning, if the options template fails or
+// renders a double quote, which would end the quoted options early.
+func (g *Generator) snapshotOptions(originalOptions string, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) string {
+	options := g.updateOptionsForSnapshot(originalOptions, snapshot, fs)
+	if g.optionsTemplate == nil {
+		return options
+	}
+
+	unquoted, quoted := UnquoteOptions(options)
+	parser := params.NewBootOptionsParser()
+	data := OptionsTemplateData{
+		SubvolID:   snapshot.ID,
+		SubvolPath: parser.ExtractSubvol(parser.ExtractRootFlags(unquoted)),
+		Options:    unquoted,
+	}
+	if data.SubvolPath == "" {
+		data.SubvolPath = snapshot.Path
+	}
+	if fs != nil {
+		data.RootUUID = fs.UUID
+		data.Label = fs.Label
+	}
+
+	var out strings.Builder
+	if err := g.optionsTemplate.Execute(&out, data); err != nil {
+		log.Warn().Err(err).Str("snapshot", snapshot.Path).Msg("Failed to execute options template, using default options")
+		return options
+	}
+	rendered := strings.TrimSpace(out.String())
+	if strings.Contains(rendered, `"`) {
+		log.Warn().Str("snapshot", snapshot.Path).Str("options", rendered).Msg("Options template rendered a double quote, which would break the quoted options; using default options")
+		return options
+	}
+	if quoted {
+		return quoteOptions(rendered)
+	}
+	return rendered
+}
+
 // updateOptionsForSnapshot updates boot options to point to the snapshot
-func (g *Generator) updateOptionsForSnapshot(originalOptions string, snapshot *btrfs.Snapshot) string {
+// on fs, which may be nil when unknown.
+func (g *Generator) updateOptionsForSnapshot(originalOptions string, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) string {
 	if originalOptions == "" {
 		return ""
 	}
+	return withUnquotedOptions(originalOptions, func(options string) string {
+		return g.rewriteOptionsForSnapshot(options, snapshot, fs)
+	})
+}
 
+// rewriteOptionsForSnapshot is updateOptionsForSnapshot for unquoted
+// originalOptions.
+func (g *Generator) rewriteOptionsForSnapshot(originalOptions string, snapshot *btrfs.Snapshot, fs *btrfs.Filesystem) string {
 	parser := params.NewBootOptionsParser()
 	options := originalOptions
 
 	// Preserve the user's @ vs /@ subvolume format from the original config.
 	rootflags := parser.ExtractRootFlags(originalOptions)
-	originalSubvol := parser.ExtractSubvol(rootflags)
-
-	var snapshotSubvol string
-
-	var snapshotPathPart string
-	if strings.HasPrefix(snapshot.Path, "@") {
-		snapshotPathPart = strings.TrimPrefix(snapshot.Path, "@")
-	} else {
-		snapshotPathPart = snapshot.Path
-	}
-
-	if originalSubvol != "" && strings.HasPrefix(originalSubvol, "/@") {
-		snapshotSubvol = "/@" + snapshotPathPart
-	} else {
-		snapshotSubvol = "@" + snapshotPathPart
+	snapshotSubvol := snapshot.SubvolPath(parser.ExtractSubvol(rootflags))
+	if fs != nil && fs.Subvolume != nil && fs.Subvolume.IsTopLevel() {
+		// Root is the top-level subvolume, which snapshot paths are
+		// already relative to; there is no @ to put in front.
+		snapshotSubvol = "/" + strings.TrimPrefix(snapshot.Path, "/")
 	}
 
-	options = parser.UpdateSubvol(options, snapshotSubvol)
+	options = parser.UpdateSubvol(options, params.NormalizeSubvol(snapshotSubvol))
 	options = parser.UpdateSubvolID(options, fmt.Sprintf("%d", snapshot.ID))
 
 	initrds := parser.SpaceParser.ExtractMultiple(options, "initrd")
@@ -48,19 +206,46 @@ func (g *Generator) updateOptionsForSnapshot(originalOptions string, snapshot *b
 		}
 	}
 
-	return options
+	switch {
+	case g.bootReadOnly, g.matchWritability && snapshot.IsReadOnly:
+		options = params.ReadOnlyOptions(options)
+	case g.matchWritability:
+		options = params.WritableOptions(options)
+	}
+
+	return g.applySnapshotOptions(options, snapshot)
 }
 
-// getSnapshotDisplayName generates a display name for a snapshot
-func (g *Generator) getSnapshotDisplayName(snapshot *btrfs.Snapshot) string {
-	if strings.HasPrefix(filepath.Base(snapshot.Path), "rwsnap_") {
-		name := filepath.Base(snapshot.Path)
-		parts := strings.Split(name, "_")
-		if len(parts) >= 3 {
-			timestamp := strings.Join(parts[1:len(parts)-1], "_")
-			return timestamp
-		}
+// withUnquotedOptions applies rewrite to options without the double quotes
+// a menuentry options line may wrap them in, quoting the result again if
+// they had them, so options rewrite appends land inside the quotes.
+func withUnquotedOptions(options string, rewrite func(string) string) string {
+	unquoted, quoted := UnquoteOptions(options)
+	if !quoted {
+		return rewrite(options)
 	}
+	return quoteOptions(rewrite(unquoted))
+}
 
-	return btrfs.FormatSnapshotTimeForMenu(snapshot.SnapshotTime, g.menuFormat, g.useLocalTime)
+// UnquoteOptions returns options without the double quotes a menuentry
+// options line wraps them in, and whether they had them.
+func UnquoteOptions(options string) (string, bool) {
+	if len(options) >= 2 && strings.HasPrefix(options, `"`) && strings.HasSuffix(options, `"`) {
+		return options[1 : len(options)-1], true
+	}
+	return options, false
+}
+
+// quoteOptions quotes options for a menuentry options line, as
+// refind_linux.conf options are stored unquoted.
+func quoteOptions(options string) string {
+	if options == "" || strings.HasPrefix(options, `"`) {
+		return options
+	}
+	return `"` + options + `"`
+}
+
+// getSnapshotDisplayName generates a display name for a snapshot
+func (g *Generator) getSnapshotDisplayName(snapshot *btrfs.Snapshot) string {
+	return bootloader.SnapshotDisplayName(snapshot, g.menuFormat, g.useLocalTime)
 }
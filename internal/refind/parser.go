@@ -94,12 +94,15 @@ func (p *Parser) ParseConfig(configPath string) (*Config, error) {
 	}
 
 	config.Entries = append(config.Entries, entries...)
-	config.IncludePaths = includes
+	for _, include := range includes {
+		config.IncludePaths = append(config.IncludePaths, include.path)
+		config.IncludeLines = append(config.IncludeLines, include.line)
+	}
 	config.GlobalConfig = globals
 
 	log.Info().Str("path", configPath).Int("entries", len(entries)).Msg("Parsed main rEFInd config file")
 
-	for _, includePath := range includes {
+	for _, includePath := range config.IncludePaths {
 		fullPath := includePath
 		if !filepath.IsAbs(includePath) {
 			fullPath = filepath.Join(filepath.Dir(configPath), includePath)
@@ -137,7 +140,13 @@ func (p *Parser) ParseConfig(configPath string) (*Config, error) {
 	return config, nil
 }
 
-func (p *Parser) parseConfigFile(path string) ([]*MenuEntry, []string, []string, error) {
+// configInclude is an include line and its line number in the file.
+type configInclude struct {
+	path string
+	line int
+}
+
+func (p *Parser) parseConfigFile(path string) ([]*MenuEntry, []configInclude, []string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to open config file: %w", err)
@@ -145,7 +154,7 @@ func (p *Parser) parseConfigFile(path string) ([]*MenuEntry, []string, []string,
 	defer file.Close()
 
 	var entries []*MenuEntry
-	var includes []string
+	var includes []configInclude
 	var globals []string
 	var currentEntry *MenuEntry
 	var inMenuEntry bool
@@ -167,7 +176,7 @@ func (p *Parser) parseConfigFile(path string) ([]*MenuEntry, []string, []string,
 
 		if strings.HasPrefix(line, "include ") {
 			includePath := strings.TrimSpace(strings.TrimPrefix(line, "include "))
-			includes = append(includes, includePath)
+			includes = append(includes, configInclude{path: includePath, line: lineNumber})
 			globals = append(globals, scanner.Text())
 			continue
 		}
@@ -256,11 +265,17 @@ func (p *Parser) parseMenuDirective(entry *MenuEntry, line string) {
 		entry.Options = value
 		entry.BootOptions = parseBootOptions(value)
 	case "disabled":
-		// User-toggled disable; we preserve the line as-is during regeneration.
+		// User-toggled disable; re-emitted when the managed config is regenerated.
+		entry.Disabled = true
 	}
 }
 
 func (p *Parser) parseSubmenuDirective(submenu *SubmenuEntry, line string) {
+	if line == "disabled" {
+		submenu.Disabled = true
+		return
+	}
+
 	parts := strings.SplitN(line, " ", 2)
 	if len(parts) < 2 {
 		return
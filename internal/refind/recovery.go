@@ -0,0 +1,123 @@
+package refind
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/rs/zerolog/log"
+)
+
+// recoveryTitlePrefix starts the title of the generated recovery entry.
+const recoveryTitlePrefix = "Recovery: "
+
+// SetRecoveryDefault makes the managed config end with a main menu entry
+// booting the newest snapshot that isn't stale, and a default_selection
+// naming it. rEFInd's default_selection only picks main menu entries, so
+// the snapshot's submenu is repeated as one. The block sits between
+// ##refind-btrfs-snapshots-recovery markers and is rewritten, or dropped
+// when disabled, on every run.
+func (g *Generator) SetRecoveryDefault(enabled bool) {
+	g.recoveryDefault = enabled
+}
+
+// generateRecoveryDefault returns the recovery block for the first enabled
+// entry in titles order that has a bootable snapshot, or "" when there is
+// none or SetRecoveryDefault is off.
+func (g *Generator) generateRecoveryDefault(titles []string, entries map[string]*MenuEntry, snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem) string {
+	if !g.recoveryDefault {
+		return ""
+	}
+	for _, title := range titles {
+		entry := entries[title]
+		if entry.Disabled {
+			continue
+		}
+		snapshot := g.newestBootableSnapshot(entry, snapshots)
+		if snapshot == nil {
+			continue
+		}
+
+		snapshotTitle := fmt.Sprintf("%s (%s)", title, g.getSnapshotDisplayName(snapshot))
+		recoveryTitle := recoveryTitlePrefix + snapshotTitle
+		if strings.ContainsAny(recoveryTitle, `",`) {
+			// default_selection can't quote a '"' and splits on ','.
+			log.Warn().Str("title", recoveryTitle).Msg("Recovery entry title can't be named by default_selection, not setting a recovery default")
+			return ""
+		}
+		log.Info().Str("title", recoveryTitle).Str("snapshot", snapshot.Path).Msg("Setting recovery default")
+
+		var body strings.Builder
+		g.writeSplitSubmenuBody(&body, snapshotTitle, g.getBootPlanForSnapshot(snapshot), entry, snapshot, g.filesystemForEntry(entry, rootFS))
+		bodyLines := strings.Split(strings.TrimSuffix(body.String(), "\n"), "\n")
+		sets := func(directive string) bool {
+			return slices.ContainsFunc(bodyLines, func(line string) bool {
+				fields := strings.Fields(line)
+				return len(fields) > 0 && fields[0] == directive
+			})
+		}
+
+		var content strings.Builder
+		content.WriteString("\n")
+		content.WriteString("##refind-btrfs-snapshots-recovery-start\n")
+		content.WriteString("# Boots the newest snapshot by default (behavior.set_recovery_default)\n")
+		content.WriteString(fmt.Sprintf("default_selection \"%s\"\n", recoveryTitle))
+		content.WriteString(fmt.Sprintf("menuentry \"%s\" {\n", recoveryTitle))
+		if entry.Icon != "" {
+			content.WriteString(fmt.Sprintf("    icon %s\n", entry.Icon))
+		}
+		// A submenu inherits what it doesn't set from its menuentry; a main
+		// entry has to spell it out.
+		if entry.Volume != "" && !sets("volume") {
+			content.WriteString(fmt.Sprintf("    volume %s\n", entry.Volume))
+		}
+		if entry.Loader != "" && !sets("loader") {
+			content.WriteString(fmt.Sprintf("    loader %s\n", entry.Loader))
+		}
+		if !sets("initrd") {
+			for _, initrd := range entry.Initrd {
+				content.WriteString(fmt.Sprintf("    initrd %s\n", initrd))
+			}
+		}
+		for _, directive := range entry.ExtraDirectives {
+			if name, _, _ := strings.Cut(directive, " "); !sets(name) {
+				content.WriteString("    " + directive + "\n")
+			}
+		}
+		for _, line := range bodyLines {
+			if line != "" {
+				content.WriteString("    " + strings.TrimSpace(line) + "\n")
+			}
+		}
+		content.WriteString("}\n")
+		content.WriteString("##refind-btrfs-snapshots-recovery-end\n")
+		return content.String()
+	}
+	return ""
+}
+
+// newestBootableSnapshot returns the newest of entry's snapshots whose boot
+// plan isn't stale and whose submenu the user hasn't disabled.
+func (g *Generator) newestBootableSnapshot(entry *MenuEntry, snapshots []*btrfs.Snapshot) *btrfs.Snapshot {
+	var newest *btrfs.Snapshot
+	for _, snapshot := range g.snapshotsForEntry(entry, snapshots) {
+		if g.preservesSnapshot(snapshot) {
+			continue
+		}
+		plan := g.getBootPlanForSnapshot(snapshot)
+		if plan != nil && plan.IsStale() {
+			continue
+		}
+		if g.skipsMissingInitrd(entry.Title, plan, entry, snapshot, entry.Options) || g.skipsMicrocodeOnly(entry.Title, plan, entry, snapshot, entry.Options) || g.skipsChainload(entry.Title, plan) {
+			continue
+		}
+		if g.submenuDisabled(entry, fmt.Sprintf("%s (%s)", entry.Title, g.getSnapshotDisplayName(snapshot))) {
+			continue
+		}
+		if newest == nil || snapshot.SnapshotTime.After(newest.SnapshotTime) {
+			newest = snapshot
+		}
+	}
+	return newest
+}
@@ -0,0 +1,105 @@
+package refind
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/params"
+)
+
+// SelfCheck parses content, as generated for the refind_linux.conf or
+// managed config at path, back the way rEFInd and the next run will read
+// it, and compares each generated snapshot entry with the snapshot it was
+// written for. An entry's title must name one of snapshots, and its options
+// must resolve to that snapshot's subvolume and, when given, subvolid.
+// Returns a description of each discrepancy. refind_linux.conf lines are
+// only checked between the section markers.
+func (g *Generator) SelfCheck(path, content string, snapshots []*btrfs.Snapshot) []string {
+	byLabel := make(map[string][]*btrfs.Snapshot)
+	for _, snapshot := range snapshots {
+		label := g.getSnapshotDisplayName(snapshot)
+		byLabel[label] = append(byLabel[label], snapshot)
+	}
+
+	var problems []string
+	check := func(title, options string) {
+		if problem := g.checkSnapshotEntry(title, options, byLabel); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if filepath.Base(path) == "refind_linux.conf" {
+		inGeneratedSection := false
+		scanner := bufio.NewScanner(strings.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case strings.Contains(line, "##refind-btrfs-snapshots-start"):
+				inGeneratedSection = true
+			case strings.Contains(line, "##refind-btrfs-snapshots-end"):
+				inGeneratedSection = false
+			case inGeneratedSection && line != "":
+				parts := g.parser.parseQuotedLine(line)
+				if len(parts) != 2 {
+					problems = append(problems, fmt.Sprintf("line %q reads back as %d fields, not a title and options", line, len(parts)))
+					continue
+				}
+				check(parts[0], parts[1])
+			}
+		}
+		return problems
+	}
+
+	entries := g.parseExistingManagedConfig(content)
+	titles := make([]string, 0, len(entries))
+	for title := range entries {
+		titles = append(titles, title)
+	}
+	slices.Sort(titles)
+	for _, title := range titles {
+		for _, submenu := range entries[title].Submenues {
+			check(submenu.Title, submenu.Options)
+		}
+	}
+	return problems
+}
+
+// checkSnapshotEntry compares one generated entry with the snapshots its
+// title may name, returning "" when it matches one of them.
+func (g *Generator) checkSnapshotEntry(title, options string, byLabel map[string][]*btrfs.Snapshot) string {
+	label := g.snapshotLabel(title)
+	candidates := byLabel[label]
+	if len(candidates) == 0 {
+		return fmt.Sprintf("entry %q names no known snapshot", title)
+	}
+
+	opts := parseBootOptions(options)
+	if opts.Subvol == "" && opts.SubvolID == "" {
+		return fmt.Sprintf("entry %q has no subvol or subvolid in its options", title)
+	}
+	for _, snapshot := range candidates {
+		subvolOK := opts.Subvol == "" || bootsSnapshotSubvol(opts.Subvol, snapshot)
+		subvolIDOK := opts.SubvolID == "" || opts.SubvolID == strconv.FormatUint(snapshot.ID, 10)
+		if subvolOK && subvolIDOK {
+			return ""
+		}
+	}
+	return fmt.Sprintf("entry %q boots subvol=%q subvolid=%q, not snapshot %s (subvolid %d)",
+		title, opts.Subvol, opts.SubvolID, candidates[0].Path, candidates[0].ID)
+}
+
+// bootsSnapshotSubvol reports whether subvol, from rootflags=subvol=, names
+// snapshot's subvolume: its path as reported, or under the root's @ as
+// snapshot entries write it, with or without a leading slash.
+func bootsSnapshotSubvol(subvol string, snapshot *btrfs.Snapshot) bool {
+	normalize := func(path string) string {
+		return strings.TrimPrefix(params.NormalizeSubvol(strings.TrimPrefix(path, "<FS_TREE>")), "/")
+	}
+	subvol = normalize(subvol)
+	return subvol == normalize(snapshot.Path) || subvol == normalize(snapshot.SubvolPath(""))
+}
@@ -0,0 +1,181 @@
+package refind
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/btrfs"
+	"github.com/jmylchreest/refind-btrfs-snapshots/internal/diff"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotLabelPattern matches the snapshot part of a generated title,
+// "<source title> (<snapshot time>)".
+var snapshotLabelPattern = regexp.MustCompile(`\(([^()]*)\)$`)
+
+// TrimConfigDiff trims the generated snapshot entries in the
+// refind_linux.conf or managed config at path down to those for the keep
+// newest snapshots, leaving everything else as written. Snapshots are
+// ranked by the time in their titles, read back with the generator's menu
+// format; entries whose time can't be read are kept. Returns nil when the
+// file doesn't exist or nothing is trimmed.
+func (g *Generator) TrimConfigDiff(path string, keep int) (*diff.FileDiff, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	original := string(content)
+
+	var trimmed string
+	if filepath.Base(path) == "refind_linux.conf" {
+		trimmed = g.trimRefindLinuxConf(original, keep)
+	} else {
+		trimmed = g.trimManagedConfig(original, keep)
+	}
+	if trimmed == original {
+		log.Debug().Str("path", path).Msg("No generated entries to trim")
+		return nil, nil
+	}
+	trimmed = g.finishContent(original, trimmed)
+
+	return &diff.FileDiff{
+		Path:     path,
+		Original: original,
+		Modified: trimmed,
+	}, nil
+}
+
+// trimRefindLinuxConf drops the lines between the generated section markers
+// whose snapshot isn't among the keep newest.
+func (g *Generator) trimRefindLinuxConf(content string, keep int) string {
+	lines := strings.SplitAfter(content, "\n")
+
+	var titles []string
+	inGeneratedSection := false
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "##refind-btrfs-snapshots-start"):
+			inGeneratedSection = true
+		case strings.Contains(line, "##refind-btrfs-snapshots-end"):
+			inGeneratedSection = false
+		case inGeneratedSection:
+			if parts := g.parser.parseQuotedLine(strings.TrimSpace(line)); len(parts) > 0 {
+				titles = append(titles, parts[0])
+			}
+		}
+	}
+	kept := g.newestSnapshotLabels(titles, keep)
+
+	var out strings.Builder
+	inGeneratedSection = false
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "##refind-btrfs-snapshots-start"):
+			inGeneratedSection = true
+		case strings.Contains(line, "##refind-btrfs-snapshots-end"):
+			inGeneratedSection = false
+		case inGeneratedSection:
+			if parts := g.parser.parseQuotedLine(strings.TrimSpace(line)); len(parts) > 0 && !kept(parts[0]) {
+				continue
+			}
+		}
+		out.WriteString(line)
+	}
+	return out.String()
+}
+
+// trimManagedConfig drops the submenuentry blocks whose snapshot isn't
+// among the keep newest.
+func (g *Generator) trimManagedConfig(content string, keep int) string {
+	lines := strings.SplitAfter(content, "\n")
+
+	var titles []string
+	for _, line := range lines {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "submenuentry ") {
+			titles = append(titles, extractQuotedValue(trimmed, "submenuentry "))
+		}
+	}
+	kept := g.newestSnapshotLabels(titles, keep)
+
+	var out strings.Builder
+	var block []string
+	var blockTitle string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if block == nil && strings.HasPrefix(trimmed, "submenuentry ") {
+			block = []string{line}
+			blockTitle = extractQuotedValue(trimmed, "submenuentry ")
+			continue
+		}
+		if block != nil {
+			block = append(block, line)
+			if trimmed == "}" {
+				if kept(blockTitle) {
+					out.WriteString(strings.Join(block, ""))
+				}
+				block = nil
+			}
+			continue
+		}
+		out.WriteString(line)
+	}
+	// An unterminated block is left alone.
+	out.WriteString(strings.Join(block, ""))
+	return out.String()
+}
+
+// newestSnapshotLabels ranks the snapshots named in titles by their time
+// and returns a predicate reporting whether a title belongs to one of the
+// keep newest. Titles without a readable snapshot time always pass.
+func (g *Generator) newestSnapshotLabels(titles []string, keep int) func(title string) bool {
+	type labelTime struct {
+		label string
+		time  time.Time
+	}
+	var dated []labelTime
+	seen := make(map[string]bool)
+	for _, title := range titles {
+		label := g.snapshotLabel(title)
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		t, err := btrfs.ParseSnapshotTimeForMenu(label, g.menuFormat, g.useLocalTime)
+		if err != nil {
+			log.Warn().Str("title", title).Msg("Cannot read snapshot time from generated entry title, keeping it")
+			continue
+		}
+		dated = append(dated, labelTime{label, t})
+	}
+
+	slices.SortFunc(dated, func(a, b labelTime) int {
+		return b.time.Compare(a.time)
+	})
+	dropped := make(map[string]bool)
+	for _, d := range dated[min(keep, len(dated)):] {
+		dropped[d.label] = true
+	}
+
+	return func(title string) bool {
+		return !dropped[g.snapshotLabel(title)]
+	}
+}
+
+// snapshotLabel returns the snapshot part of a generated title, ignoring
+// the fallback marker, or "" when the title has none.
+func (g *Generator) snapshotLabel(title string) string {
+	m := snapshotLabelPattern.FindStringSubmatch(g.baseTitle(strings.TrimSpace(title)))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
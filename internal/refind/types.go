@@ -6,6 +6,7 @@ type Config struct {
 	Path         string       `json:"path"`
 	Entries      []*MenuEntry `json:"entries"`
 	IncludePaths []string     `json:"include_paths"`
+	IncludeLines []int        `json:"include_lines,omitempty"` // line of each IncludePaths entry in the main config
 	GlobalConfig []string     `json:"global_config"`
 }
 
@@ -21,6 +22,12 @@ type MenuEntry struct {
 	SourceFile  string          `json:"source_file"`
 	LineNumber  int             `json:"line_number"`
 	BootOptions *BootOptions    `json:"boot_options,omitempty"`
+	Disabled    bool            `json:"disabled,omitempty"`
+
+	// ExtraDirectives are the managed config entry's graphics and ostype
+	// lines (keptDirectives), kept verbatim so a regenerated managed
+	// config writes them back.
+	ExtraDirectives []string `json:"extra_directives,omitempty"`
 }
 
 // SubmenuEntry represents a submenu entry
@@ -31,6 +38,7 @@ type SubmenuEntry struct {
 	Options     string       `json:"options,omitempty"`
 	AddOptions  string       `json:"add_options,omitempty"`
 	BootOptions *BootOptions `json:"boot_options,omitempty"`
+	Disabled    bool         `json:"disabled,omitempty"`
 }
 
 // BootOptions represents parsed boot options
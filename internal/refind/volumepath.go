@@ -0,0 +1,36 @@
+package refind
+
+import (
+	"path"
+	"strings"
+)
+
+// SplitVolumePath splits a loader or initrd path into the volume qualifier
+// rEFInd accepts in front of it and the path on that volume. The qualifier
+// is a volume name, label or partition GUID followed by a colon, as in
+// "fs0:\EFI\vmlinuz" or "ESP:/vmlinuz-linux", or "+," for the volume the
+// entry itself is on. It is returned with its delimiter, so volume+rest
+// gives p back; volume is "" when p has none.
+func SplitVolumePath(p string) (volume, rest string) {
+	if strings.HasPrefix(p, "+,") {
+		return "+,", p[2:]
+	}
+	if i := strings.Index(p, ":"); i > 0 && !strings.ContainsAny(p[:i], `/\`) {
+		return p[:i+1], p[i+1:]
+	}
+	return "", p
+}
+
+// withVolumeOf returns p qualified with the volume of original, so a path
+// rewritten for a snapshot stays on the volume the source entry named.
+func withVolumeOf(original, p string) string {
+	volume, _ := SplitVolumePath(original)
+	return volume + p
+}
+
+// loaderFileName returns the file name of a loader or initrd path, without
+// its volume qualifier and with backslashes read as separators.
+func loaderFileName(p string) string {
+	_, rest := SplitVolumePath(p)
+	return path.Base(strings.ReplaceAll(rest, `\`, "/"))
+}
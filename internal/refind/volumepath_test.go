@@ -0,0 +1,32 @@
+package refind
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitVolumePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		volume string
+		rest   string
+	}{
+		{path: `\EFI\arch\vmlinuz-linux`, rest: `\EFI\arch\vmlinuz-linux`},
+		{path: "/vmlinuz-linux", rest: "/vmlinuz-linux"},
+		{path: `fs0:\EFI\arch\vmlinuz-linux`, volume: "fs0:", rest: `\EFI\arch\vmlinuz-linux`},
+		{path: "ESP:/vmlinuz-linux", volume: "ESP:", rest: "/vmlinuz-linux"},
+		{path: "+,bootx64.efi", volume: "+,", rest: "bootx64.efi"},
+		{path: "/EFI/odd:name.efi", rest: "/EFI/odd:name.efi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			volume, rest := SplitVolumePath(tt.path)
+			assert.Equal(t, tt.volume, volume)
+			assert.Equal(t, tt.rest, rest)
+		})
+	}
+
+	assert.Equal(t, "vmlinuz-linux", loaderFileName(`fs0:\EFI\arch\vmlinuz-linux`))
+	assert.Equal(t, "ESP:/initramfs-linux-fallback.img", withVolumeOf("ESP:/initramfs-linux.img", "/initramfs-linux-fallback.img"))
+}
@@ -2,9 +2,12 @@ package runner
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/rs/zerolog/log"
@@ -15,6 +18,7 @@ type Runner interface {
 	Command(name string, args []string, description string) error
 	WriteFile(path string, content []byte, perm os.FileMode, description string) error
 	MkdirAll(path string, perm os.FileMode, description string) error
+	Remove(path string, description string) error
 	IsDryRun() bool
 }
 
@@ -22,12 +26,18 @@ type Runner interface {
 type RealRunner struct{}
 
 func (r *RealRunner) Command(name string, args []string, description string) error {
+	return r.CommandContext(context.Background(), name, args, description)
+}
+
+// CommandContext is Command, killing the command if ctx is done before it
+// exits.
+func (r *RealRunner) CommandContext(ctx context.Context, name string, args []string, description string) error {
 	log.Debug().
 		Str("command", name+" "+joinArgs(args)).
 		Str("description", description).
 		Msg("Executing command")
 
-	cmd := exec.Command(name, args...)
+	cmd := exec.CommandContext(ctx, name, args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
@@ -58,6 +68,15 @@ func (r *RealRunner) MkdirAll(path string, perm os.FileMode, description string)
 	return os.MkdirAll(path, perm)
 }
 
+func (r *RealRunner) Remove(path string, description string) error {
+	log.Debug().
+		Str("path", path).
+		Str("description", description).
+		Msg("Removing file")
+
+	return os.Remove(path)
+}
+
 func (r *RealRunner) IsDryRun() bool {
 	return false
 }
@@ -90,10 +109,149 @@ func (r *DryRunner) MkdirAll(path string, perm os.FileMode, description string)
 	return nil
 }
 
+func (r *DryRunner) Remove(path string, description string) error {
+	log.Info().
+		Str("path", path).
+		Str("description", description).
+		Msg("[DRY RUN] Would remove file")
+	return nil
+}
+
 func (r *DryRunner) IsDryRun() bool {
 	return true
 }
 
+// StagingRunner writes files into a mirror of the filesystem under Dir
+// instead of their real paths, so the exact bytes can be inspected or
+// boot-tested without touching the live ESP or snapshots. Commands are
+// logged but never executed, since they would act on the live system.
+type StagingRunner struct {
+	Dir string
+}
+
+// NewStaging creates a runner that stages all writes under dir.
+func NewStaging(dir string) *StagingRunner {
+	return &StagingRunner{Dir: dir}
+}
+
+// StagedPath maps an absolute target path to its location under Dir.
+func (r *StagingRunner) StagedPath(path string) string {
+	return filepath.Join(r.Dir, path)
+}
+
+func (r *StagingRunner) Command(name string, args []string, description string) error {
+	log.Info().
+		Str("command", name+" "+joinArgs(args)).
+		Str("description", description).
+		Msg("[STAGING] Skipping command")
+	return nil
+}
+
+func (r *StagingRunner) WriteFile(path string, content []byte, perm os.FileMode, description string) error {
+	staged := r.StagedPath(path)
+	log.Debug().
+		Str("path", path).
+		Str("staged_path", staged).
+		Str("description", description).
+		Int("size", len(content)).
+		Msg("Staging file")
+
+	if err := os.MkdirAll(filepath.Dir(staged), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(staged, content, perm)
+}
+
+func (r *StagingRunner) MkdirAll(path string, perm os.FileMode, description string) error {
+	return os.MkdirAll(r.StagedPath(path), perm)
+}
+
+func (r *StagingRunner) Remove(path string, description string) error {
+	staged := r.StagedPath(path)
+	log.Debug().
+		Str("path", path).
+		Str("staged_path", staged).
+		Str("description", description).
+		Msg("Removing staged file")
+
+	if err := os.Remove(staged); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (r *StagingRunner) IsDryRun() bool {
+	return false
+}
+
+// contextCommander is implemented by runners whose commands can be bound
+// to a context.
+type contextCommander interface {
+	CommandContext(ctx context.Context, name string, args []string, description string) error
+}
+
+// contextRunner is a Runner bound to a context, see WithContext.
+type contextRunner struct {
+	Runner
+	ctx context.Context
+}
+
+// WithContext returns r bound to ctx: once ctx is done, no further
+// operation is started and a running command is killed.
+func WithContext(ctx context.Context, r Runner) Runner {
+	return &contextRunner{Runner: r, ctx: ctx}
+}
+
+func (r *contextRunner) Command(name string, args []string, description string) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if cr, ok := r.Runner.(contextCommander); ok {
+		return cr.CommandContext(r.ctx, name, args, description)
+	}
+	return r.Runner.Command(name, args, description)
+}
+
+func (r *contextRunner) WriteFile(path string, content []byte, perm os.FileMode, description string) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	return r.Runner.WriteFile(path, content, perm, description)
+}
+
+func (r *contextRunner) MkdirAll(path string, perm os.FileMode, description string) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	return r.Runner.MkdirAll(path, perm, description)
+}
+
+func (r *contextRunner) Remove(path string, description string) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	return r.Runner.Remove(path, description)
+}
+
+// ExecutesCommands reports whether r really runs the commands it is given.
+// DryRunner and StagingRunner only log them, though a StagingRunner isn't a
+// dry run, so anything a command would have created doesn't exist under
+// either.
+func ExecutesCommands(r Runner) bool {
+	for {
+		cr, ok := r.(*contextRunner)
+		if !ok {
+			break
+		}
+		r = cr.Runner
+	}
+	switch r.(type) {
+	case *DryRunner, *StagingRunner:
+		return false
+	}
+	return !r.IsDryRun()
+}
+
 // New creates the appropriate runner based on dry-run mode
 func New(dryRun bool) Runner {
 	if dryRun {
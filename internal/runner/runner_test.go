@@ -1,10 +1,12 @@
 package runner
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -62,6 +64,22 @@ func TestDryRunner(t *testing.T) {
 	if _, err := os.Stat(testFile); !errors.Is(err, os.ErrNotExist) {
 		t.Error("DryRunner should not create actual file")
 	}
+
+	// Test Remove (should not delete file)
+	existing := filepath.Join(tempDir, "test-dry-remove.txt")
+	if err := os.WriteFile(existing, testContent, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = runner.Remove(existing, "test remove")
+	if err != nil {
+		t.Errorf("DryRunner Remove should not return error, got: %v", err)
+	}
+
+	// File should still exist
+	if _, err := os.Stat(existing); err != nil {
+		t.Errorf("DryRunner should not remove actual file, got error: %v", err)
+	}
 }
 
 func TestRealRunner(t *testing.T) {
@@ -117,6 +135,83 @@ func TestRealRunner(t *testing.T) {
 	if string(content) != string(testContent) {
 		t.Errorf("File content mismatch, expected: %s, got: %s", testContent, content)
 	}
+
+	// Test Remove
+	err = runner.Remove(testFile, "test remove")
+	if err != nil {
+		t.Errorf("RealRunner Remove should not return error, got: %v", err)
+	}
+
+	// File should be gone
+	if _, err := os.Stat(testFile); !errors.Is(err, os.ErrNotExist) {
+		t.Error("RealRunner should remove file")
+	}
+}
+
+func TestStagingRunner(t *testing.T) {
+	stageDir := t.TempDir()
+	liveDir := t.TempDir()
+	runner := NewStaging(stageDir)
+
+	if runner.IsDryRun() {
+		t.Error("StagingRunner should return false for IsDryRun")
+	}
+
+	// Test Command (should not execute)
+	marker := filepath.Join(liveDir, "marker")
+	err := runner.Command("touch", []string{marker}, "test command")
+	if err != nil {
+		t.Errorf("StagingRunner Command should not return error, got: %v", err)
+	}
+	if _, err := os.Stat(marker); !errors.Is(err, os.ErrNotExist) {
+		t.Error("StagingRunner should not execute commands")
+	}
+
+	// Test WriteFile (should land under the stage dir, mirroring the path)
+	target := filepath.Join(liveDir, "EFI", "refind", "refind.conf")
+	testContent := []byte("test content")
+
+	err = runner.WriteFile(target, testContent, 0644, "test write")
+	if err != nil {
+		t.Errorf("StagingRunner WriteFile should not return error, got: %v", err)
+	}
+	if _, err := os.Stat(target); !errors.Is(err, os.ErrNotExist) {
+		t.Error("StagingRunner should not write the live path")
+	}
+	content, err := os.ReadFile(filepath.Join(stageDir, target))
+	if err != nil {
+		t.Errorf("StagingRunner should write the staged path, got error: %v", err)
+	}
+	if string(content) != string(testContent) {
+		t.Errorf("File content mismatch, expected: %s, got: %s", testContent, content)
+	}
+
+	// Test MkdirAll
+	err = runner.MkdirAll(filepath.Join(liveDir, "newdir"), 0755, "test mkdir")
+	if err != nil {
+		t.Errorf("StagingRunner MkdirAll should not return error, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(liveDir, "newdir")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("StagingRunner should not create live directory")
+	}
+
+	// Test Remove (only the staged copy is removed)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, testContent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = runner.Remove(target, "test remove")
+	if err != nil {
+		t.Errorf("StagingRunner Remove should not return error, got: %v", err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("StagingRunner should not remove the live file, got error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stageDir, target)); !errors.Is(err, os.ErrNotExist) {
+		t.Error("StagingRunner should remove the staged file")
+	}
 }
 
 func TestJoinArgs(t *testing.T) {
@@ -156,3 +251,52 @@ func TestJoinArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := WithContext(ctx, &RealRunner{})
+	if r.IsDryRun() {
+		t.Error("WithContext should keep the wrapped runner's dry-run mode")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- r.Command("sleep", []string{"10"}, "sleep") }()
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Command should fail once its context is cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command was not killed when its context was cancelled")
+	}
+
+	dir := filepath.Join(t.TempDir(), "not-created")
+	if err := r.MkdirAll(dir, 0755, "mkdir"); !errors.Is(err, context.Canceled) {
+		t.Errorf("MkdirAll after cancel = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("MkdirAll should not run once its context is cancelled")
+	}
+}
+
+func TestExecutesCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Runner
+		want bool
+	}{
+		{"real", &RealRunner{}, true},
+		{"dry_run", &DryRunner{}, false},
+		{"staging", NewStaging(t.TempDir()), false},
+		{"staging_with_context", WithContext(context.Background(), NewStaging(t.TempDir())), false},
+		{"real_with_context", WithContext(context.Background(), &RealRunner{}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExecutesCommands(tt.r); got != tt.want {
+				t.Errorf("ExecutesCommands() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
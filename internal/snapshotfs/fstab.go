@@ -1,5 +1,6 @@
 // Package snapshotfs computes diffs that align in-snapshot filesystem state
-// (currently just /etc/fstab) with the snapshot's own subvolume. Helpers are
+// (/etc/fstab, and /etc/crypttab when root is on dm-crypt) with the snapshot's
+// own subvolume and the live system. Helpers are
 // pure — callers apply diffs via the shared runner — so running twice on an
 // already-aligned snapshot produces zero diffs.
 package snapshotfs
@@ -32,6 +33,27 @@ func UpdateSnapshotFstab(snap *btrfs.Snapshot, rootFS *btrfs.Filesystem, mgr *fs
 	return &FstabUpdate{Snapshot: snap, Diff: d}, nil
 }
 
+// CheckSubvolids warns about each snapshot whose fstab mounts root by a
+// subvolid other than the snapshot's own. For callers that leave fstab
+// unrewritten, where a stale ID copied from the origin would otherwise go
+// unnoticed until the snapshot fails to boot.
+func CheckSubvolids(snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem, mgr *fstab.Manager) {
+	for _, snap := range snapshots {
+		id, err := mgr.StaleSubvolid(snap, rootFS)
+		if err != nil {
+			log.Warn().Err(err).Str("snapshot", snap.Path).Msg("Failed to check snapshot fstab")
+			continue
+		}
+		if id != "" {
+			log.Warn().
+				Str("snapshot", snap.Path).
+				Str("fstab_subvolid", id).
+				Uint64("subvolume_id", snap.ID).
+				Msg("Snapshot fstab mounts root by a stale subvolid and won't be rewritten")
+		}
+	}
+}
+
 // UpdateFstabs is a convenience wrapper that calls UpdateSnapshotFstab for
 // each snapshot. Per-snapshot errors are logged at warn and the loop
 // continues so one bad snapshot doesn't block the rest. Callers that need
@@ -51,3 +73,21 @@ func UpdateFstabs(snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem, mgr *fs
 	}
 	return out
 }
+
+// UpdateCrypttabs returns the crypttab diff of each snapshot whose entry
+// for the dm-crypt root mapping has drifted from the live crypttab. Like
+// UpdateFstabs, per-snapshot errors are logged at warn and skipped.
+func UpdateCrypttabs(snapshots []*btrfs.Snapshot, rootFS *btrfs.Filesystem, mgr *fstab.Manager) []FstabUpdate {
+	var out []FstabUpdate
+	for _, snap := range snapshots {
+		d, err := mgr.UpdateSnapshotCrypttab(snap, rootFS)
+		if err != nil {
+			log.Warn().Err(err).Str("snapshot", snap.Path).Msg("Failed to update snapshot crypttab")
+			continue
+		}
+		if d != nil {
+			out = append(out, FstabUpdate{Snapshot: snap, Diff: d})
+		}
+	}
+	return out
+}
@@ -1,3 +1,6 @@
+// Package uki emits per-snapshot Unified Kernel Image clones with rewritten
+// .cmdline sections. It implements bootloader.Generator so the standard
+// pipeline can drive it alongside the rEFInd and BLS generators.
 package uki
 
 import (
@@ -105,7 +108,7 @@ func buildClones(sources []*kernel.BootSet, snaps []*btrfs.Snapshot, outputDir,
 			if snap == nil || snap.Subvolume == nil || snap.Path == "" {
 				continue
 			}
-			newCmdline := rewriteCmdline(baseCmdline, snap)
+			newCmdline := bootloader.RewriteCmdline(baseCmdline, snap)
 			clone, err := CloneWithCmdline(srcBytes, newCmdline)
 			if err != nil {
 				return nil, fmt.Errorf("clone %s for snapshot %d: %w", srcPath, snap.ID, err)